@@ -0,0 +1,146 @@
+// Package cascade implements cascading between servers: a group may be
+// configured with an upstream group on another server, in which case
+// the server joins the upstream group as an ordinary client, receives
+// all of its streams, and redistributes them in the local group.  This
+// allows the audience of a large event to be spread over multiple
+// servers, each of which only receives a single copy of every stream.
+//
+// Every client of the upstream group that publishes streams is mirrored
+// by a system client in the local group with the same id and username.
+// Only media are redistributed; chat messages and the list of users are
+// not.
+package cascade
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+const (
+	// the delays before reconnecting to the upstream server after
+	// a failure
+	minRetryDelay = 2 * time.Second
+	maxRetryDelay = time.Minute
+)
+
+// A link is the connection of a local group to its upstream group.
+type link struct {
+	group    string
+	upstream group.Upstream
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+var links struct {
+	mu    sync.Mutex
+	links map[string]*link
+}
+
+func randomId() string {
+	b := make([]byte, 9)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// called locked
+func start(g string, upstream group.Upstream) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &link{
+		group:    g,
+		upstream: upstream,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	if links.links == nil {
+		links.links = make(map[string]*link)
+	}
+	links.links[g] = l
+	go l.run(ctx)
+}
+
+// stop stops a link and waits for it to terminate.  It must be called
+// after the link has been removed from links.
+func (l *link) stop() {
+	l.cancel()
+	<-l.done
+}
+
+// Update starts and stops links in order to match the group descriptions.
+func Update() {
+	names, err := group.GetDescriptionNames()
+	if err != nil {
+		log.Printf("Cascade: couldn't read groups: %v", err)
+		return
+	}
+	wanted := make(map[string]group.Upstream)
+	for _, name := range names {
+		desc, err := group.GetDescription(name)
+		if err != nil || desc.Upstream == nil {
+			continue
+		}
+		wanted[name] = *desc.Upstream
+	}
+
+	var stopped []*link
+	links.mu.Lock()
+	for name, l := range links.links {
+		upstream, ok := wanted[name]
+		if ok && upstream == l.upstream {
+			delete(wanted, name)
+			continue
+		}
+		delete(links.links, name)
+		stopped = append(stopped, l)
+	}
+	for name, upstream := range wanted {
+		start(name, upstream)
+	}
+	links.mu.Unlock()
+
+	for _, l := range stopped {
+		l.stop()
+	}
+}
+
+// Stop stops all links.
+func Stop() {
+	links.mu.Lock()
+	ls := links.links
+	links.links = nil
+	links.mu.Unlock()
+
+	for _, l := range ls {
+		l.stop()
+	}
+}
+
+func (l *link) run(ctx context.Context) {
+	defer close(l.done)
+
+	delay := minRetryDelay
+	for {
+		start := time.Now()
+		err := l.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("Cascade %v: %v", l.group, err)
+		}
+		if time.Since(start) > maxRetryDelay {
+			delay = minRetryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
@@ -0,0 +1,457 @@
+package cascade
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+func TestGetStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/group/event/.status":
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"name": "event",
+                                    "endpoint": "/ws"}`))
+			case "/group/incomplete/.status":
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"name": "incomplete"}`))
+			default:
+				http.NotFound(w, r)
+			}
+		},
+	))
+	defer server.Close()
+
+	ctx := context.Background()
+	for _, u := range []string{"/group/event/", "/group/event"} {
+		location, status, err := getStatus(ctx, server.URL+u)
+		if err != nil {
+			t.Errorf("%v: %v", u, err)
+			continue
+		}
+		if location.String() != server.URL+"/group/event/" ||
+			status.Name != "event" || status.Endpoint != "/ws" {
+			t.Errorf("%v: got %v %v", u, location, status)
+		}
+	}
+
+	for _, u := range []string{"/group/other/", "/group/incomplete/"} {
+		_, _, err := getStatus(ctx, server.URL+u)
+		if err == nil {
+			t.Errorf("%v: no error", u)
+		}
+	}
+}
+
+// upstream is a fake upstream server.
+type upstream struct {
+	t      *testing.T
+	server *httptest.Server
+	// called after the client has joined and requested streams
+	joined func(conn *websocket.Conn)
+	// the value of the joined message, or "" to accept the join
+	fail string
+}
+
+func newUpstream(t *testing.T) *upstream {
+	u := &upstream{t: t}
+	upgrader := websocket.Upgrader{}
+	u.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/group/event/.status":
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(group.Status{
+					Name:     "event",
+					Endpoint: "/ws",
+				})
+			case "/ws":
+				conn, err := upgrader.Upgrade(w, r, nil)
+				if err != nil {
+					t.Errorf("Upgrade: %v", err)
+					return
+				}
+				defer conn.Close()
+				u.serve(conn)
+			default:
+				http.NotFound(w, r)
+			}
+		},
+	))
+	return u
+}
+
+func (u *upstream) url() string {
+	return u.server.URL + "/group/event/"
+}
+
+func (u *upstream) serve(conn *websocket.Conn) {
+	t := u.t
+	var m message
+	err := conn.ReadJSON(&m)
+	if err != nil || m.Type != "handshake" ||
+		len(m.Version) == 0 || m.Version[0] != "2" {
+		t.Errorf("Handshake: %v %v", m, err)
+		return
+	}
+	conn.WriteJSON(message{
+		Type:    "handshake",
+		Version: []string{"2"},
+	})
+
+	err = conn.ReadJSON(&m)
+	if err != nil || m.Type != "join" || m.Kind != "join" ||
+		m.Group != "event" || m.Username == nil ||
+		*m.Username != "edge" || m.Password != "pw" {
+		t.Errorf("Join: %v %v", m, err)
+		return
+	}
+	if u.fail != "" {
+		conn.WriteJSON(message{
+			Type:  "joined",
+			Kind:  "fail",
+			Group: "event",
+			Value: u.fail,
+		})
+		return
+	}
+	conn.WriteJSON(message{
+		Type:  "joined",
+		Kind:  "join",
+		Group: "event",
+	})
+
+	err = conn.ReadJSON(&m)
+	if err != nil || m.Type != "request" {
+		t.Errorf("Request: %v %v", m, err)
+		return
+	}
+
+	if u.joined != nil {
+		u.joined(conn)
+	}
+	for {
+		err := conn.ReadJSON(&m)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func setupGroup(t *testing.T, upstream string) *group.Group {
+	group.Directory = t.TempDir()
+	group.DataDirectory = t.TempDir()
+	desc := `{"upstream": {"url": "` + upstream + `",
+                  "username": "edge", "password": "pw"}}`
+	err := os.WriteFile(
+		filepath.Join(group.Directory, t.Name()+".json"),
+		[]byte(desc), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := group.Add(t.Name(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestConnectOtherHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(group.Status{
+				Name:     "event",
+				Endpoint: "wss://galene.example.org/ws",
+			})
+		},
+	))
+	defer server.Close()
+
+	g := setupGroup(t, server.URL+"/group/event/")
+	desc := g.Description()
+
+	l := &link{group: g.Name(), upstream: *desc.Upstream}
+	err := l.connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not on the upstream") {
+		t.Errorf("Expected endpoint failure, got %v", err)
+	}
+}
+
+func TestConnectFail(t *testing.T) {
+	u := newUpstream(t)
+	defer u.server.Close()
+	u.fail = "not authorised"
+
+	g := setupGroup(t, u.url())
+	desc := g.Description()
+
+	l := &link{group: g.Name(), upstream: *desc.Upstream}
+	err := l.connect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "not authorised") {
+		t.Errorf("Expected join failure, got %v", err)
+	}
+}
+
+// recorder is a client that records the streams pushed to it.
+type recorder struct {
+	mu    sync.Mutex
+	conns map[string][]conn.UpTrack
+}
+
+func (r *recorder) Group() *group.Group                       { return nil }
+func (r *recorder) Addr() net.Addr                            { return nil }
+func (r *recorder) Id() string                                { return "recorder" }
+func (r *recorder) Username() string                          { return "" }
+func (r *recorder) SetUsername(string)                        {}
+func (r *recorder) Permissions() []string                     { return nil }
+func (r *recorder) SetPermissions([]string)                   {}
+func (r *recorder) Data() map[string]interface{}              { return nil }
+func (r *recorder) Joined(group, kind string) error           { return nil }
+func (r *recorder) Kick(id string, u *string, m string) error { return nil }
+
+func (r *recorder) RequestConns(target group.Client, g *group.Group, id string) error {
+	return nil
+}
+
+func (r *recorder) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (r *recorder) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conns == nil {
+		r.conns = make(map[string][]conn.UpTrack)
+	}
+	if up == nil {
+		delete(r.conns, id)
+	} else {
+		r.conns[id] = tracks
+	}
+	return nil
+}
+
+func TestConnect(t *testing.T) {
+	u := newUpstream(t)
+	defer u.server.Close()
+
+	done := make(chan struct{})
+	reports := make(chan struct{}, 1)
+	u.joined = func(ws *websocket.Conn) {
+		pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Errorf("NewPeerConnection: %v", err)
+			return
+		}
+		track, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{
+				MimeType:  webrtc.MimeTypeOpus,
+				ClockRate: 48000,
+				Channels:  2,
+			}, "audio", "stream",
+		)
+		if err != nil {
+			t.Errorf("NewTrack: %v", err)
+			return
+		}
+		tr, err := pc.AddTransceiverFromTrack(track,
+			webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+			},
+		)
+		if err != nil {
+			t.Errorf("AddTransceiver: %v", err)
+			return
+		}
+		go func() {
+			for {
+				ps, _, err := tr.Sender().ReadRTCP()
+				if err != nil {
+					return
+				}
+				for _, p := range ps {
+					_, ok := p.(*rtcp.ReceiverReport)
+					if !ok {
+						continue
+					}
+					select {
+					case reports <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}()
+		offer, err := pc.CreateOffer(nil)
+		if err != nil {
+			t.Errorf("CreateOffer: %v", err)
+			return
+		}
+		gathered := webrtc.GatheringCompletePromise(pc)
+		pc.SetLocalDescription(offer)
+		<-gathered
+
+		alice := "alice"
+		ws.WriteJSON(message{
+			Type:     "offer",
+			Id:       "stream",
+			Source:   "alice-id",
+			Username: &alice,
+			Label:    "camera",
+			SDP:      pc.LocalDescription().SDP,
+		})
+		var m message
+		err = ws.ReadJSON(&m)
+		if err != nil || m.Type != "answer" || m.Id != "stream" {
+			t.Errorf("Answer: %v %v", m, err)
+			return
+		}
+		err = pc.SetRemoteDescription(webrtc.SessionDescription{
+			Type: webrtc.SDPTypeAnswer,
+			SDP:  m.SDP,
+		})
+		if err != nil {
+			t.Errorf("SetRemoteDescription: %v", err)
+			return
+		}
+
+		go func() {
+			defer pc.Close()
+			ticker := time.NewTicker(20 * time.Millisecond)
+			defer ticker.Stop()
+			seqno := uint16(0)
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+				seqno++
+				track.WriteRTP(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    111,
+						SequenceNumber: seqno,
+						Timestamp:      uint32(seqno) * 960,
+					},
+					Payload: []byte{0xf8, 0xff, 0xfe},
+				})
+			}
+		}()
+	}
+
+	g := setupGroup(t, u.url())
+	desc := g.Description()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &link{group: g.Name(), upstream: *desc.Upstream}
+	result := make(chan error, 1)
+	go func() {
+		result <- l.connect(ctx)
+	}()
+
+	var r recorder
+	published := false
+	for i := 0; i < 100 && !published; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c := g.GetClient("alice-id")
+		if c == nil {
+			continue
+		}
+		if c.Username() != "alice" {
+			t.Errorf("Username: %v", c.Username())
+		}
+		c.RequestConns(&r, g, "")
+		r.mu.Lock()
+		published = len(r.conns["stream"]) == 1
+		r.mu.Unlock()
+	}
+	if !published {
+		t.Errorf("Stream was not published")
+	}
+
+	select {
+	case <-reports:
+	case <-time.After(10 * time.Second):
+		t.Errorf("No receiver reports were sent upstream")
+	}
+
+	close(done)
+	cancel()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Errorf("Connect returned nil")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Connect didn't terminate")
+	}
+
+	if c := g.GetClient("alice-id"); c != nil {
+		t.Errorf("Client not removed")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	u := newUpstream(t)
+	defer u.server.Close()
+	u.fail = "not authorised"
+
+	g := setupGroup(t, u.url())
+	defer Stop()
+
+	Update()
+	links.mu.Lock()
+	l := links.links[g.Name()]
+	links.mu.Unlock()
+	if l == nil || l.upstream.URL != u.url() {
+		t.Fatalf("Link not started: %v", l)
+	}
+
+	Update()
+	links.mu.Lock()
+	l2 := links.links[g.Name()]
+	links.mu.Unlock()
+	if l2 != l {
+		t.Errorf("Link restarted")
+	}
+
+	err := os.WriteFile(
+		filepath.Join(group.Directory, g.Name()+".json"),
+		[]byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Update()
+	links.mu.Lock()
+	n := len(links.links)
+	links.mu.Unlock()
+	if n != 0 {
+		t.Errorf("Link not stopped")
+	}
+	select {
+	case <-l.done:
+	default:
+		t.Errorf("Link still running")
+	}
+}
@@ -0,0 +1,391 @@
+package cascade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+const (
+	protocolVersion = "2"
+	// the time we wait for the upstream server to respond
+	requestTimeout = 10 * time.Second
+	// the upstream server pings us after 20s of inactivity
+	readTimeout = 60 * time.Second
+)
+
+// message is a message of the client protocol, see galene-protocol.md.
+// Only the fields that we use are included.
+type message struct {
+	Type      string                   `json:"type"`
+	Version   []string                 `json:"version,omitempty"`
+	Kind      string                   `json:"kind,omitempty"`
+	Error     string                   `json:"error,omitempty"`
+	Id        string                   `json:"id,omitempty"`
+	Replace   string                   `json:"replace,omitempty"`
+	Source    string                   `json:"source,omitempty"`
+	Username  *string                  `json:"username,omitempty"`
+	Password  string                   `json:"password,omitempty"`
+	Token     string                   `json:"token,omitempty"`
+	Group     string                   `json:"group,omitempty"`
+	Value     interface{}              `json:"value,omitempty"`
+	SDP       string                   `json:"sdp,omitempty"`
+	Candidate *webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	Label     string                   `json:"label,omitempty"`
+	Request   interface{}              `json:"request,omitempty"`
+}
+
+// A session is a connection to the upstream server.
+type session struct {
+	group *group.Group
+	conn  *websocket.Conn
+	addr  net.Addr
+
+	// protects writes to conn
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// maps the id of a stream to the client that publishes it
+	streams map[string]*rtpconn.CascadeClient
+	// maps the id of an upstream client to the local client
+	clients map[string]*rtpconn.CascadeClient
+}
+
+// getStatus returns the status of the group at location u.
+func getStatus(ctx context.Context, u string) (*url.URL, group.Status, error) {
+	if !strings.HasSuffix(u, "/") {
+		u = u + "/"
+	}
+	location, err := url.Parse(u)
+	if err != nil {
+		return nil, group.Status{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(
+		ctx, "GET", location.JoinPath(".status").String(), nil,
+	)
+	if err != nil {
+		return nil, group.Status{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, group.Status{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, group.Status{}, errors.New(resp.Status)
+	}
+
+	var status group.Status
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, group.Status{}, err
+	}
+	if status.Name == "" || status.Endpoint == "" {
+		return nil, group.Status{}, errors.New("incomplete group status")
+	}
+	return location, status, nil
+}
+
+// connect joins the upstream group and redistributes its streams until
+// an error occurs or the context is cancelled.
+func (l *link) connect(ctx context.Context) error {
+	g, err := group.Add(l.group, nil)
+	if err != nil {
+		return err
+	}
+
+	location, status, err := getStatus(ctx, l.upstream.URL)
+	if err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+	endpoint, err := location.Parse(status.Endpoint)
+	if err != nil {
+		return err
+	}
+	// don't send our credentials to another server
+	if !strings.EqualFold(endpoint.Host, location.Host) {
+		return fmt.Errorf("endpoint %v is not on the upstream server",
+			endpoint.Host)
+	}
+	// a relative endpoint inherits the scheme of the location
+	switch strings.ToLower(endpoint.Scheme) {
+	case "http":
+		endpoint.Scheme = "ws"
+	case "https":
+		endpoint.Scheme = "wss"
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	conn, _, err := websocket.DefaultDialer.DialContext(
+		dctx, endpoint.String(), nil,
+	)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	s := &session{
+		group:   g,
+		conn:    conn,
+		addr:    conn.RemoteAddr(),
+		streams: make(map[string]*rtpconn.CascadeClient),
+		clients: make(map[string]*rtpconn.CascadeClient),
+	}
+	defer s.close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// causes ReadJSON to fail
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	err = s.write(message{
+		Type:    "handshake",
+		Version: []string{protocolVersion},
+		Id:      randomId(),
+	})
+	if err != nil {
+		return err
+	}
+
+	join := message{
+		Type:  "join",
+		Kind:  "join",
+		Group: status.Name,
+	}
+	if l.upstream.Token != "" {
+		join.Token = l.upstream.Token
+	} else {
+		username := l.upstream.Username
+		join.Username = &username
+		join.Password = l.upstream.Password
+	}
+	err = s.write(join)
+	if err != nil {
+		return err
+	}
+
+	for {
+		var m message
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		err := conn.ReadJSON(&m)
+		if err != nil {
+			return err
+		}
+		err = s.handle(ctx, l, m)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) write(m message) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(requestTimeout))
+	return s.conn.WriteJSON(m)
+}
+
+func messageError(m message) error {
+	if v, ok := m.Value.(string); ok && v != "" {
+		return errors.New(v)
+	}
+	if m.Error != "" {
+		return errors.New(m.Error)
+	}
+	return errors.New("unknown error")
+}
+
+func (s *session) handle(ctx context.Context, l *link, m message) error {
+	switch m.Type {
+	case "ping":
+		return s.write(message{Type: "pong"})
+	case "joined":
+		switch m.Kind {
+		case "join":
+			log.Printf("Cascade %v: joined %v", l.group, l.upstream.URL)
+			return s.write(message{
+				Type: "request",
+				Request: map[string][]string{
+					"": {"audio", "video"},
+				},
+			})
+		case "fail":
+			return fmt.Errorf("join: %w", messageError(m))
+		case "leave":
+			return errors.New("left the upstream group")
+		}
+	case "offer":
+		if m.Id == "" {
+			return errors.New("offer with empty id")
+		}
+		if m.Replace != "" {
+			s.closeStream(m.Replace)
+		}
+		err := s.gotOffer(ctx, m)
+		if err != nil {
+			log.Printf("Cascade %v: stream %v: %v", l.group, m.Id, err)
+			s.closeStream(m.Id)
+			return s.write(message{Type: "abort", Id: m.Id})
+		}
+	case "ice":
+		c := s.getStream(m.Id)
+		if c == nil || m.Candidate == nil {
+			return nil
+		}
+		err := c.GotICE(m.Id, m.Candidate)
+		if err != nil {
+			log.Printf("Cascade %v: ICE: %v", l.group, err)
+		}
+	case "close":
+		s.closeStream(m.Id)
+	case "usermessage":
+		if m.Kind == "error" || m.Kind == "warning" {
+			log.Printf("Cascade %v: %v: %v",
+				l.group, m.Kind, messageError(m))
+		}
+	}
+	return nil
+}
+
+// getStream returns the client that publishes the stream with the
+// given id.
+func (s *session) getStream(id string) *rtpconn.CascadeClient {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+// getClient returns the local client that mirrors the upstream client
+// with the given id, creating it if necessary.
+func (s *session) getClient(id, username string) (*rtpconn.CascadeClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.clients[id]
+	if c != nil {
+		select {
+		case <-c.Done():
+			// kicked, create a new one
+		default:
+			return c, nil
+		}
+	}
+
+	c = rtpconn.NewCascadeClient(s.group, id, username, s.addr)
+	_, err := group.AddClient(s.group.Name(), c,
+		group.ClientCredentials{System: true},
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.clients[id] = c
+	return c, nil
+}
+
+func (s *session) gotOffer(ctx context.Context, m message) error {
+	c := s.getStream(m.Id)
+	if c == nil {
+		if m.Source == "" {
+			return errors.New("offer with empty source")
+		}
+		username := ""
+		if m.Username != nil {
+			username = *m.Username
+		}
+		var err error
+		c, err = s.getClient(m.Source, username)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.streams[m.Id] = c
+		s.mu.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	id := m.Id
+	answer, err := c.GotOffer(ctx, id, m.Label, m.SDP, func() {
+		// request an ICE restart
+		s.write(message{Type: "renegotiate", Id: id})
+	})
+	if err != nil {
+		return err
+	}
+	return s.write(message{
+		Type: "answer",
+		Id:   m.Id,
+		SDP:  answer,
+	})
+}
+
+// closeStream closes a stream, and closes the client that published it
+// if it has no other streams.
+func (s *session) closeStream(id string) {
+	s.mu.Lock()
+	c := s.streams[id]
+	if c == nil {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.streams, id)
+	last := false
+	if s.clients[c.Id()] == c {
+		last = true
+		for _, other := range s.streams {
+			if other == c {
+				last = false
+				break
+			}
+		}
+		if last {
+			delete(s.clients, c.Id())
+		}
+	}
+	s.mu.Unlock()
+
+	c.Unpublish(id)
+	if last {
+		c.Close()
+	}
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = make(map[string]*rtpconn.CascadeClient)
+	clients := s.clients
+	s.clients = make(map[string]*rtpconn.CascadeClient)
+	s.mu.Unlock()
+
+	for id, c := range streams {
+		c.Unpublish(id)
+	}
+	for _, c := range clients {
+		c.Close()
+	}
+	s.conn.Close()
+}
@@ -0,0 +1,341 @@
+package codecs
+
+import (
+	"errors"
+
+	"github.com/pion/rtp"
+)
+
+// The dependency descriptor is an RTP header extension, defined in
+// Appendix A of the AV1 RTP specification, that describes the spatial and
+// temporal layer of a frame independently of the codec.  A descriptor
+// refers to a template in the dependency structure, which is only sent
+// along with some frames (typically keyframes), so the receiver must
+// remember the latest structure.
+
+// DependencyDescriptorURI is the URI of the dependency descriptor header
+// extension.
+const DependencyDescriptorURI = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+
+var ErrNoDependencyStructure = errors.New("no dependency structure")
+
+// The decode target indications.
+const (
+	dtiNotPresent  = 0
+	dtiDiscardable = 1
+	dtiSwitch      = 2
+	dtiRequired    = 3
+)
+
+type ddTemplate struct {
+	sid, tid uint8
+	dtis     []uint8
+}
+
+// DependencyStructure is the template dependency structure of a stream.
+type DependencyStructure struct {
+	templateIdOffset uint8
+	templates        []ddTemplate
+	// the spatial layer of each decode target
+	dtSid []uint8
+}
+
+// DependencyDescriptor is the parsed contents of a dependency descriptor.
+type DependencyDescriptor struct {
+	Start, End  bool
+	FrameNumber uint16
+	Sid, Tid    uint8
+	// the decode target indications of the frame
+	Dtis []uint8
+	// the structure carried by this descriptor, if any
+	Structure *DependencyStructure
+}
+
+type bitReader struct {
+	data   []byte
+	offset int
+}
+
+var errDDTruncated = errors.New("truncated dependency descriptor")
+
+func (r *bitReader) read(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.offset >= len(r.data)*8 {
+			return 0, errDDTruncated
+		}
+		bit := (r.data[r.offset/8] >> (7 - r.offset%8)) & 1
+		v = (v << 1) | uint32(bit)
+		r.offset++
+	}
+	return v, nil
+}
+
+// readNS reads a non-symmetric unsigned value in the range [0, n).
+func (r *bitReader) readNS(n uint32) (uint32, error) {
+	w := 0
+	for x := n; x != 0; x >>= 1 {
+		w++
+	}
+	m := (uint32(1) << w) - n
+	v, err := r.read(w - 1)
+	if err != nil {
+		return 0, err
+	}
+	if v < m {
+		return v, nil
+	}
+	extra, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return (v << 1) - m + extra, nil
+}
+
+func parseDependencyStructure(r *bitReader) (*DependencyStructure, error) {
+	offset, err := r.read(6)
+	if err != nil {
+		return nil, err
+	}
+	dtCntMinusOne, err := r.read(5)
+	if err != nil {
+		return nil, err
+	}
+	dtCnt := int(dtCntMinusOne) + 1
+	s := &DependencyStructure{templateIdOffset: uint8(offset)}
+
+	// template layers
+	var sid, tid uint8
+	for {
+		if len(s.templates) >= 64 {
+			return nil, errors.New("too many templates")
+		}
+		s.templates = append(s.templates, ddTemplate{sid: sid, tid: tid})
+		next, err := r.read(2)
+		if err != nil {
+			return nil, err
+		}
+		if next == 1 {
+			tid++
+		} else if next == 2 {
+			tid = 0
+			sid++
+		} else if next == 3 {
+			break
+		}
+	}
+
+	// template DTIs
+	for i := range s.templates {
+		s.templates[i].dtis = make([]uint8, dtCnt)
+		for j := 0; j < dtCnt; j++ {
+			v, err := r.read(2)
+			if err != nil {
+				return nil, err
+			}
+			s.templates[i].dtis[j] = uint8(v)
+		}
+	}
+
+	// template fdiffs, which we don't need
+	for range s.templates {
+		for {
+			follows, err := r.read(1)
+			if err != nil {
+				return nil, err
+			}
+			if follows == 0 {
+				break
+			}
+			_, err = r.read(4)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// template chains, which we don't need either
+	chainCnt, err := r.readNS(uint32(dtCnt) + 1)
+	if err != nil {
+		return nil, err
+	}
+	if chainCnt > 0 {
+		for i := 0; i < dtCnt; i++ {
+			_, err := r.readNS(chainCnt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = r.read(len(s.templates) * int(chainCnt) * 4)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// decode target layers
+	s.dtSid = make([]uint8, dtCnt)
+	for i := 0; i < dtCnt; i++ {
+		for _, t := range s.templates {
+			if t.dtis[i] != dtiNotPresent && t.sid > s.dtSid[i] {
+				s.dtSid[i] = t.sid
+			}
+		}
+	}
+
+	// we ignore the render resolutions that follow
+	return s, nil
+}
+
+// ParseDependencyDescriptor parses a dependency descriptor.  The
+// argument structure is the latest structure seen on the stream, or nil
+// if none.
+func ParseDependencyDescriptor(data []byte, structure *DependencyStructure) (DependencyDescriptor, error) {
+	var d DependencyDescriptor
+	if len(data) < 3 {
+		return d, errDDTruncated
+	}
+	d.Start = (data[0] & 0x80) != 0
+	d.End = (data[0] & 0x40) != 0
+	templateId := data[0] & 0x3F
+	d.FrameNumber = uint16(data[1])<<8 | uint16(data[2])
+
+	r := &bitReader{data: data, offset: 24}
+	var customDtis bool
+	if len(data) > 3 {
+		flags, err := r.read(5)
+		if err != nil {
+			return d, err
+		}
+		structurePresent := (flags & 0x10) != 0
+		activePresent := (flags & 0x08) != 0
+		customDtis = (flags & 0x04) != 0
+		if structurePresent {
+			s, err := parseDependencyStructure(r)
+			if err != nil {
+				return d, err
+			}
+			d.Structure = s
+			structure = s
+		}
+		if activePresent {
+			if structure == nil {
+				return d, ErrNoDependencyStructure
+			}
+			_, err := r.read(len(structure.dtSid))
+			if err != nil {
+				return d, err
+			}
+		}
+	}
+
+	if structure == nil {
+		return d, ErrNoDependencyStructure
+	}
+
+	index := int((templateId + 64 - structure.templateIdOffset) % 64)
+	if index >= len(structure.templates) {
+		return d, errors.New("unknown template")
+	}
+	t := structure.templates[index]
+	d.Sid = t.sid
+	d.Tid = t.tid
+	if customDtis {
+		d.Dtis = make([]uint8, len(t.dtis))
+		for i := range d.Dtis {
+			v, err := r.read(2)
+			if err != nil {
+				return d, err
+			}
+			d.Dtis[i] = uint8(v)
+		}
+	} else {
+		d.Dtis = t.dtis
+	}
+	return d, nil
+}
+
+// DependencyFlags is like PacketFlags, but takes the layer information
+// from the dependency descriptor carried in the header extension with
+// the given id.  It returns the structure carried by the descriptor, if
+// any.
+func DependencyFlags(codec string, buf []byte, id uint8, structure *DependencyStructure) (Flags, *DependencyStructure, error) {
+	flags, err := PacketFlags(codec, buf)
+	if err != nil {
+		return flags, nil, err
+	}
+
+	var packet rtp.Packet
+	err = packet.Unmarshal(buf)
+	if err != nil {
+		return flags, nil, err
+	}
+	ext := packet.GetExtension(id)
+	if ext == nil {
+		return flags, nil, nil
+	}
+	d, err := ParseDependencyDescriptor(ext, structure)
+	if err != nil {
+		return flags, d.Structure, err
+	}
+
+	flags.Start = d.Start
+	flags.End = d.End
+	if d.Start {
+		kf, _ := Keyframe(codec, &packet)
+		flags.Keyframe = kf
+	}
+	flags.Sid = d.Sid
+	flags.Tid = d.Tid
+
+	switchable := false
+	discardable := true
+	nonReference := true
+	s := structure
+	if d.Structure != nil {
+		s = d.Structure
+	}
+	for i, dti := range d.Dtis {
+		if dti == dtiSwitch {
+			switchable = true
+		}
+		if dti == dtiSwitch || dti == dtiRequired {
+			discardable = false
+		}
+		if dti != dtiNotPresent && i < len(s.dtSid) &&
+			s.dtSid[i] > d.Sid {
+			nonReference = false
+		}
+	}
+	flags.TidUpSync = flags.Keyframe || switchable
+	flags.SidUpSync = flags.Keyframe || switchable
+	flags.SidNonReference = nonReference
+	flags.Discardable = discardable
+	return flags, d.Structure, nil
+}
+
+// RemoveExtension copies the RTP packet src into dst without its header
+// extension, and returns the length of the result.
+func RemoveExtension(dst, src []byte) (int, error) {
+	if len(src) < 12 {
+		return 0, errTruncated
+	}
+	offset := 12 + int(src[0]&0x0F)*4
+	if (src[0] & 0x10) == 0 {
+		return copy(dst, src), nil
+	}
+	if len(src) < offset+4 {
+		return 0, errTruncated
+	}
+	length := int(src[offset+2])<<8 | int(src[offset+3])
+	end := offset + 4 + length*4
+	if len(src) < end {
+		return 0, errTruncated
+	}
+	if len(dst) < offset+len(src)-end {
+		return 0, errors.New("buffer too small")
+	}
+	n := copy(dst, src[:offset])
+	n += copy(dst[n:], src[end:])
+	dst[0] &^= 0x10
+	return n, nil
+}
@@ -0,0 +1,153 @@
+package codecs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+type bitWriter struct {
+	data   []byte
+	offset int
+}
+
+func (w *bitWriter) write(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.offset%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if (v>>i)&1 != 0 {
+			w.data[w.offset/8] |= 0x80 >> (w.offset % 8)
+		}
+		w.offset++
+	}
+}
+
+// l1t3Descriptor returns a descriptor that carries an L1T3 structure
+// with three templates, one per temporal layer.
+func l1t3Descriptor() []byte {
+	var w bitWriter
+	w.write(1, 1)     // start of frame
+	w.write(1, 1)     // end of frame
+	w.write(6, 0)     // template id
+	w.write(16, 1234) // frame number
+	w.write(5, 0x10)  // structure present
+
+	w.write(6, 0) // template id offset
+	w.write(5, 2) // three decode targets
+	// template layers
+	w.write(2, 1)
+	w.write(2, 1)
+	w.write(2, 3)
+	// template DTIs
+	for _, dtis := range [][]uint32{{2, 2, 2}, {0, 2, 3}, {0, 0, 1}} {
+		for _, dti := range dtis {
+			w.write(2, dti)
+		}
+	}
+	// template fdiffs
+	w.write(3, 0)
+	// no chains
+	w.write(2, 0)
+	// no resolutions
+	w.write(1, 0)
+	return w.data
+}
+
+func TestDependencyDescriptor(t *testing.T) {
+	d, err := ParseDependencyDescriptor(l1t3Descriptor(), nil)
+	if err != nil {
+		t.Fatalf("ParseDependencyDescriptor: %v", err)
+	}
+	if !d.Start || !d.End || d.FrameNumber != 1234 ||
+		d.Tid != 0 || d.Sid != 0 || d.Structure == nil {
+		t.Errorf("Bad descriptor %v", d)
+	}
+	s := d.Structure
+	if len(s.templates) != 3 || len(s.dtSid) != 3 {
+		t.Errorf("Bad structure %v", s)
+	}
+
+	_, err = ParseDependencyDescriptor([]byte{0x82, 0, 1}, nil)
+	if !errors.Is(err, ErrNoDependencyStructure) {
+		t.Errorf("Expected ErrNoDependencyStructure, got %v", err)
+	}
+
+	d, err = ParseDependencyDescriptor([]byte{0x02, 0, 1}, s)
+	if err != nil {
+		t.Fatalf("ParseDependencyDescriptor: %v", err)
+	}
+	if d.Start || d.End || d.Tid != 2 || d.Structure != nil ||
+		!bytes.Equal(d.Dtis, []byte{0, 0, 1}) {
+		t.Errorf("Bad descriptor %v", d)
+	}
+
+	_, err = ParseDependencyDescriptor([]byte{0x05, 0, 1}, s)
+	if err == nil {
+		t.Errorf("Unknown template accepted")
+	}
+
+	data := l1t3Descriptor()
+	for i := 3; i < len(data); i++ {
+		_, err := ParseDependencyDescriptor(data[:i], nil)
+		if err == nil {
+			t.Errorf("Truncated descriptor (%v) accepted", i)
+		}
+	}
+}
+
+func TestDependencyFlags(t *testing.T) {
+	d, _ := ParseDependencyDescriptor(l1t3Descriptor(), nil)
+	s := d.Structure
+
+	tests := []struct {
+		templateId  byte
+		tid         uint8
+		upSync      bool
+		discardable bool
+	}{
+		{0, 0, true, false},
+		{1, 1, true, false},
+		{2, 2, false, true},
+	}
+
+	for _, test := range tests {
+		packet := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				SequenceNumber: 42,
+			},
+			Payload: []byte{0x10, 0x30, 0x00},
+		}
+		packet.SetExtension(3, []byte{0xc0 | test.templateId, 0, 1})
+		buf, err := packet.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		flags, _, err := DependencyFlags("video/AV1", buf, 3, s)
+		if err != nil {
+			t.Fatalf("DependencyFlags: %v", err)
+		}
+		if flags.Seqno != 42 || !flags.Start || !flags.End ||
+			flags.Tid != test.tid || flags.Sid != 0 ||
+			flags.TidUpSync != test.upSync ||
+			flags.Discardable != test.discardable {
+			t.Errorf("Template %v: bad flags %v",
+				test.templateId, flags)
+		}
+
+		buf2 := make([]byte, len(buf))
+		n, err := RemoveExtension(buf2, buf)
+		if err != nil {
+			t.Fatalf("RemoveExtension: %v", err)
+		}
+		packet.Extension = false
+		packet.Extensions = nil
+		expected, _ := packet.Marshal()
+		if !bytes.Equal(buf2[:n], expected) {
+			t.Errorf("Expected %v, got %v", expected, buf2[:n])
+		}
+	}
+}
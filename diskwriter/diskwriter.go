@@ -18,6 +18,7 @@ import (
 	"github.com/at-wat/ebml-go/webm"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
 
 	"github.com/jech/samplebuilder"
@@ -36,11 +37,14 @@ const (
 var Directory string
 
 type Client struct {
-	group *group.Group
-	id    string
+	group    *group.Group
+	id       string
+	format   string
+	layout   string
+	manifest *manifest
 
 	mu     sync.Mutex
-	down   map[string]*diskConn
+	down   map[string][]*diskConn
 	closed bool
 }
 
@@ -50,8 +54,126 @@ func newId() string {
 	return hex.EncodeToString(b)
 }
 
-func New(g *group.Group) *Client {
-	return &Client{group: g, id: newId()}
+// New creates a disk writer for the given group.  The format is either
+// "webm", which yields Matroska files if the video is H.264, or "mp4",
+// which yields fragmented MP4 files.  The layout is either "muxed", which
+// records the audio and video of each stream into a single file, or
+// "tracks", which records every track into a separate file and writes
+// a manifest.  If either is empty, the group's default is used.
+func New(g *group.Group, format, layout string) (*Client, error) {
+	desc := g.Description()
+	if format == "" {
+		format = desc.RecordingFormat
+	}
+	switch format {
+	case "":
+		format = "webm"
+	case "webm", "mp4":
+	default:
+		return nil, group.UserError("unknown recording format " + format)
+	}
+	if layout == "" {
+		layout = desc.RecordingLayout
+	}
+	switch layout {
+	case "":
+		layout = "muxed"
+	case "muxed", "tracks":
+	default:
+		return nil, group.UserError("unknown recording layout " + layout)
+	}
+	client := &Client{
+		group:  g,
+		id:     newId(),
+		format: format,
+		layout: layout,
+	}
+	if layout == "tracks" {
+		client.manifest = newManifest(
+			filepath.Join(Directory, g.Name()), g.Name(), time.Now(),
+		)
+	}
+	return client, nil
+}
+
+var ErrRecordingDisabled = group.UserError(
+	"recording is disabled in this group",
+)
+var ErrAlreadyRecording = group.UserError("already recording")
+
+// Recording returns the disk writer of a group, or nil if the group is
+// not being recorded.
+func Recording(g *group.Group) *Client {
+	for _, c := range g.GetClients(nil) {
+		if disk, ok := c.(*Client); ok {
+			return disk
+		}
+	}
+	return nil
+}
+
+// startMu serialises Start and Stop, so that two concurrent calls to
+// Start cannot both find that the group is not being recorded.
+var startMu sync.Mutex
+
+// Start starts recording a group.  The disk writer joins the group, and
+// records both the streams that are already published and any streams
+// that are published later, until Stop is called.  See New for the
+// meaning of format and layout.
+func Start(g *group.Group, format, layout string) (*Client, error) {
+	if g.Description().PrivacyMode {
+		return nil, ErrRecordingDisabled
+	}
+
+	startMu.Lock()
+	defer startMu.Unlock()
+	if Recording(g) != nil {
+		return nil, ErrAlreadyRecording
+	}
+	disk, err := New(g, format, layout)
+	if err != nil {
+		return nil, err
+	}
+	_, err = group.AddClient(g.Name(), disk,
+		group.ClientCredentials{
+			System: true,
+		},
+	)
+	if err != nil {
+		disk.Close()
+		return nil, err
+	}
+	for _, c := range g.GetClients(disk) {
+		c.RequestConns(disk, g, "")
+	}
+	return disk, nil
+}
+
+// Stop stops recording a group.  It returns false if the group was not
+// being recorded.
+func Stop(g *group.Group) bool {
+	startMu.Lock()
+	defer startMu.Unlock()
+	recording := false
+	for _, c := range g.GetClients(nil) {
+		disk, ok := c.(*Client)
+		if ok {
+			disk.Close()
+			group.DelClient(disk)
+			recording = true
+		}
+	}
+	return recording
+}
+
+// Format returns the container format used by the disk writer.
+func (client *Client) Format() string {
+	return client.format
+}
+
+// Layout returns the layout of the recording, either "muxed" or "tracks".
+func (client *Client) Layout() string {
+	return client.layout
 }
 
 func (client *Client) Group() *group.Group {
@@ -95,9 +217,17 @@ func (client *Client) Close() error {
 	defer client.mu.Unlock()
 
 	for _, down := range client.down {
-		down.Close()
+		for _, d := range down {
+			d.Close()
+		}
 	}
 	client.down = nil
+	if !client.closed && client.manifest != nil {
+		client.manifest.close()
+	}
+	if !client.closed {
+		go logPrune(client.group.Name())
+	}
 	client.closed = true
 	return nil
 }
@@ -131,18 +261,19 @@ func (client *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []c
 	if replace != "" {
 		rp := client.down[replace]
 		if rp != nil {
-			rp.Close()
+			for _, d := range rp {
+				d.Close()
+			}
 			delete(client.down, replace)
 		} else {
 			log.Printf("Disk writer: replacing unknown connection")
 		}
 	}
 
-	old := client.down[id]
-	if old != nil {
-		old.Close()
-		delete(client.down, id)
+	for _, d := range client.down[id] {
+		d.Close()
 	}
+	delete(client.down, id)
 
 	if up == nil {
 		return nil
@@ -156,15 +287,39 @@ func (client *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []c
 	}
 
 	if client.down == nil {
-		client.down = make(map[string]*diskConn)
+		client.down = make(map[string][]*diskConn)
 	}
 
-	down, err := newDiskConn(client, directory, up, tracks)
+	tracks, err = recordableTracks(client, tracks)
 	if err != nil {
 		g.WallOps("Write to disk: " + err.Error())
 		return err
 	}
 
+	var down []*diskConn
+	if client.layout == "tracks" {
+		for _, t := range tracks {
+			d, err := newDiskConn(
+				client, directory, up, []conn.UpTrack{t},
+			)
+			if err != nil {
+				for _, d := range down {
+					d.Close()
+				}
+				g.WallOps("Write to disk: " + err.Error())
+				return err
+			}
+			down = append(down, d)
+		}
+	} else {
+		d, err := newDiskConn(client, directory, up, tracks)
+		if err != nil {
+			g.WallOps("Write to disk: " + err.Error())
+			return err
+		}
+		down = append(down, d)
+	}
+
 	client.down[up.Id()] = down
 	return nil
 }
@@ -173,16 +328,28 @@ type diskConn struct {
 	client    *Client
 	directory string
 	username  string
+	format    string
 	hasVideo  bool
 
+	// in the "tracks" layout, the kind of the only track, which is
+	// appended to the filename, and the manifest of the recording
+	kind     string
+	manifest *manifest
+
+	// the maximum interval between keyframes, 0 if unlimited
+	kfInterval time.Duration
+
 	mu            sync.Mutex
-	file          *os.File
+	file          *diskFile
 	remote        conn.Up
 	tracks        []*diskTrack
 	width, height uint32
 	lastWarning   time.Time
 	originLocal   time.Time
 	originRemote  uint64
+	// the time of the last failure, see failure.go
+	failed   time.Time
+	resuming bool
 }
 
 // called locked
@@ -202,12 +369,21 @@ func (conn *diskConn) open(extension string) error {
 		return errors.New("already open")
 	}
 
-	file, err := openDiskFile(conn.directory, conn.username, extension)
+	name := conn.username
+	if conn.kind != "" {
+		if name != "" {
+			name = name + "-"
+		}
+		name = name + conn.kind
+	}
+	file, err := openDiskFile(conn.directory, name, extension)
 	if err != nil {
 		return err
 	}
 
-	conn.file = file
+	setActive(file.Name(), true)
+	conn.file = &diskFile{file: file}
+	conn.addToManifest()
 	return nil
 }
 
@@ -321,7 +497,10 @@ type diskTrack struct {
 	savedKf     *rtp.Packet
 }
 
-func newDiskConn(client *Client, directory string, up conn.Up, remoteTracks []conn.UpTrack) (*diskConn, error) {
+// recordableTracks returns the tracks that will be recorded: at most one
+// audio and one video track, the latter preferably of the highest
+// quality.
+func recordableTracks(client *Client, remoteTracks []conn.UpTrack) ([]conn.UpTrack, error) {
 	var audio, video conn.UpTrack
 
 	for _, remote := range remoteTracks {
@@ -356,15 +535,28 @@ func newDiskConn(client *Client, directory string, up conn.Up, remoteTracks []co
 	if video != nil {
 		tracks = append(tracks, video)
 	}
+	return tracks, nil
+}
 
+// newDiskConn creates a connection that records the given tracks into
+// a single file.
+func newDiskConn(client *Client, directory string, up conn.Up, tracks []conn.UpTrack) (*diskConn, error) {
 	_, username := up.User()
 	conn := diskConn{
 		client:    client,
 		directory: directory,
 		username:  username,
+		format:    client.format,
 		tracks:    make([]*diskTrack, 0, len(tracks)),
 		remote:    up,
 	}
+	conn.kfInterval = keyframeInterval(
+		client.group.Description().RecordingKeyframeInterval,
+	)
+	if client.layout == "tracks" && len(tracks) == 1 {
+		conn.kind = tracks[0].Kind().String()
+		conn.manifest = client.manifest
+	}
 
 	for _, remote := range tracks {
 		var builder *samplebuilder.SampleBuilder
@@ -430,7 +622,7 @@ func (t *diskTrack) Write(buf []byte) (int, error) {
 	t.conn.mu.Lock()
 	defer t.conn.mu.Unlock()
 
-	if t.builder == nil {
+	if t.builder == nil || t.conn.checkFailed() {
 		return 0, nil
 	}
 
@@ -491,6 +683,23 @@ func fetch(t *diskTrack, seqno uint16) {
 	t.writeRTP(p)
 }
 
+// The default maximum interval between keyframes in recordings.  Encoders
+// typically send keyframes much less often, which makes recordings hard
+// to seek.
+const defaultKeyframeInterval = 4 * time.Second
+
+// keyframeInterval converts the recording-keyframe-interval group option
+// to a duration.  It returns 0 if periodic keyframe requests are disabled.
+func keyframeInterval(seconds int) time.Duration {
+	if seconds < 0 {
+		return 0
+	}
+	if seconds == 0 {
+		return defaultKeyframeInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
 func requestKeyframe(t *diskTrack) {
 	now := time.Now()
 	if now.Sub(t.kfRequested) > 500*time.Millisecond {
@@ -514,7 +723,8 @@ func (t *diskTrack) writeRTP(p *rtp.Packet) error {
 					t.remote.Codec().ClockRate,
 				)
 			}
-		} else if time.Since(t.lastKf) > 4*time.Second {
+		} else if t.conn.kfInterval > 0 &&
+			time.Since(t.lastKf) > t.conn.kfInterval {
 			requestKeyframe(t)
 		}
 	}
@@ -575,9 +785,7 @@ func (t *diskTrack) writeBuffered(force bool) error {
 				)
 				err := t.conn.initWriter(w, h, t, ts)
 				if err != nil {
-					t.conn.warn(
-						"Write to disk " + err.Error(),
-					)
+					t.conn.fail(err)
 					return err
 				}
 			}
@@ -587,10 +795,7 @@ func (t *diskTrack) writeBuffered(force bool) error {
 				if !t.conn.hasVideo {
 					err := t.conn.initWriter(0, 0, t, ts)
 					if err != nil {
-						t.conn.warn(
-							"Write to disk " +
-								err.Error(),
-						)
+						t.conn.fail(err)
 						return err
 					}
 				}
@@ -609,9 +814,16 @@ func (t *diskTrack) writeBuffered(force bool) error {
 		tm := (ts - value(t.origin)) /
 			(t.remote.Codec().ClockRate / 1000)
 		_, err := t.writer.Write(keyframe, int64(tm), sample.Data)
+		if err == nil && t.conn.file != nil {
+			err = t.conn.file.Err()
+		}
 		if err != nil {
+			if t.conn.failed.IsZero() {
+				t.conn.fail(err)
+			}
 			return err
 		}
+		t.conn.written()
 	}
 }
 
@@ -731,6 +943,10 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 		}
 	}
 
+	if conn.format == "mp4" {
+		return conn.initMP4Writer(width, height, track, ts)
+	}
+
 	isWebm := true
 	var desc []mkvcore.TrackDescription
 	for i, t := range conn.tracks {
@@ -848,6 +1064,38 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 	return nil
 }
 
+// called locked
+func (conn *diskConn) initMP4Writer(width, height uint32, track *diskTrack, ts uint32) error {
+	codecs := make([]webrtc.RTPCodecCapability, 0, len(conn.tracks))
+	for _, t := range conn.tracks {
+		codecs = append(codecs, t.remote.Codec())
+	}
+
+	if track != nil {
+		track.adjustOrigin(ts)
+	}
+
+	err := conn.open("mp4")
+	if err != nil {
+		return err
+	}
+
+	ws, err := newMP4Writer(conn.file, codecs, width, height)
+	if err != nil {
+		conn.file.Close()
+		conn.file = nil
+		return err
+	}
+
+	conn.width = width
+	conn.height = height
+
+	for i, t := range conn.tracks {
+		t.writer = ws[i]
+	}
+	return nil
+}
+
 func (t *diskTrack) GetMaxBitrate() (uint64, int, int) {
 	return ^uint64(0), -1, -1
 }
@@ -1,9 +1,14 @@
 package diskwriter
 
 import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/jech/galene/group"
 	"github.com/jech/galene/rtptime"
 )
 
@@ -171,3 +176,64 @@ func TestAdjustOriginLocalRemote(t *testing.T) {
 		t.Errorf("Expected 132, got %v", value(c.tracks[0].origin))
 	}
 }
+
+func TestKeyframeInterval(t *testing.T) {
+	tests := []struct {
+		seconds  int
+		interval time.Duration
+	}{
+		{0, defaultKeyframeInterval},
+		{-1, 0},
+		{2, 2 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, test := range tests {
+		i := keyframeInterval(test.seconds)
+		if i != test.interval {
+			t.Errorf("%v: got %v, expected %v",
+				test.seconds, i, test.interval)
+		}
+	}
+}
+
+func TestStartConcurrent(t *testing.T) {
+	Directory = t.TempDir()
+	group.Directory = t.TempDir()
+	err := os.WriteFile(
+		filepath.Join(group.Directory, "start-concurrent-test.json"),
+		[]byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	g, err := group.Add("start-concurrent-test", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("start-concurrent-test")
+
+	var wg sync.WaitGroup
+	var started atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := Start(g, "", "")
+			if err == nil {
+				started.Add(1)
+			} else if err != ErrAlreadyRecording {
+				t.Errorf("Start: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := started.Load(); n != 1 {
+		t.Errorf("Started %v recordings", n)
+	}
+	if !Stop(g) {
+		t.Errorf("Stop failed")
+	}
+	if Recording(g) != nil {
+		t.Errorf("Still recording after Stop")
+	}
+}
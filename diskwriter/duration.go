@@ -0,0 +1,427 @@
+package diskwriter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Computing the duration of a recording requires reading the whole file,
+// so we remember the durations of the files we have already seen, and
+// compute the durations of new files in the background.
+
+type durationEntry struct {
+	size     int64
+	modTime  time.Time
+	duration time.Duration
+	// the error if the file could not be parsed
+	err error
+}
+
+var durations struct {
+	mu      sync.Mutex
+	entries map[string]durationEntry
+	// the files whose duration is being computed in the background
+	pending map[string]struct{}
+}
+
+const maxCachedDurations = 4096
+
+// the number of durations computed concurrently in the background
+var durationSem = make(chan struct{}, 2)
+
+var errUnknownFormat = errors.New("unknown recording format")
+
+// Duration returns the duration of a recording.
+func Duration(filename string) (time.Duration, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	durations.mu.Lock()
+	e, ok := durations.entries[filename]
+	durations.mu.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.duration, e.err
+	}
+
+	var d time.Duration
+	r := bufio.NewReader(f)
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".webm", ".mkv":
+		d, err = mkvDuration(r)
+	case ".mp4":
+		d, err = mp4Duration(r)
+	default:
+		err = errUnknownFormat
+	}
+
+	durations.mu.Lock()
+	defer durations.mu.Unlock()
+	if durations.entries == nil ||
+		len(durations.entries) >= maxCachedDurations {
+		durations.entries = make(map[string]durationEntry)
+	}
+	durations.entries[filename] = durationEntry{
+		size:     fi.Size(),
+		modTime:  fi.ModTime(),
+		duration: d,
+		err:      err,
+	}
+	if err != nil {
+		return 0, err
+	}
+	return d, nil
+}
+
+// CachedDuration returns the duration of a recording if it is already
+// known.  Otherwise, it starts computing it in the background and returns
+// false.  The duration of a file that is being recorded is not computed,
+// since it changes all the time.
+func CachedDuration(filename string, fi os.FileInfo) (time.Duration, bool) {
+	durations.mu.Lock()
+	defer durations.mu.Unlock()
+	e, ok := durations.entries[filename]
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.duration, e.err == nil
+	}
+	if isActive(filename) {
+		return 0, false
+	}
+	if _, ok := durations.pending[filename]; ok {
+		return 0, false
+	}
+	if durations.pending == nil {
+		durations.pending = make(map[string]struct{})
+	}
+	durations.pending[filename] = struct{}{}
+	go func() {
+		durationSem <- struct{}{}
+		Duration(filename)
+		<-durationSem
+		durations.mu.Lock()
+		delete(durations.pending, filename)
+		durations.mu.Unlock()
+	}()
+	return 0, false
+}
+
+// ebmlVint reads a variable-length integer, and returns its value and
+// its length.  If id is true, the length marker is kept, as is usual for
+// element IDs.  The third result is true if all the value bits are set,
+// which denotes an unknown size.
+func ebmlVint(r *bufio.Reader, id bool) (uint64, int, bool, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, false, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && b&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, false, errors.New("bad EBML integer")
+	}
+	v := uint64(b)
+	if !id {
+		v &= 0xFF >> length
+	}
+	all := v == 0xFF>>length
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		v = v<<8 | uint64(b)
+		all = all && b == 0xFF
+	}
+	return v, length, all, nil
+}
+
+const (
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549A966
+	mkvTimecodeScale = 0x2AD7B1
+	mkvCluster       = 0x1F43B675
+	mkvTimecode      = 0xE7
+	mkvBlockGroup    = 0xA0
+	mkvBlock         = 0xA1
+	mkvSimpleBlock   = 0xA3
+)
+
+// mkvDuration returns the time between the first and the last block of
+// a Matroska file.  Since we only need a handful of elements, we do a
+// flat scan, entering the containers we are interested in and skipping
+// everything else; this copes with containers of unknown size, which
+// are produced when recording.
+func mkvDuration(r *bufio.Reader) (time.Duration, error) {
+	scale := uint64(1000000)
+	var cluster int64
+	var first, last int64
+	seen := false
+
+	readUint := func(size uint64) (uint64, error) {
+		if size > 8 {
+			return 0, errors.New("integer too large")
+		}
+		var v uint64
+		for i := uint64(0); i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			v = v<<8 | uint64(b)
+		}
+		return v, nil
+	}
+
+	for {
+		id, _, _, err := ebmlVint(r, true)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		size, _, unknown, err := ebmlVint(r, false)
+		if err != nil {
+			break
+		}
+		switch id {
+		case mkvSegment, mkvInfo, mkvCluster, mkvBlockGroup:
+			continue
+		}
+		if unknown {
+			return 0, errors.New("unexpected unknown size")
+		}
+		switch id {
+		case mkvTimecodeScale:
+			scale, err = readUint(size)
+		case mkvTimecode:
+			var v uint64
+			v, err = readUint(size)
+			cluster = int64(v)
+		case mkvBlock, mkvSimpleBlock:
+			var n int
+			_, n, _, err = ebmlVint(r, false)
+			if err != nil {
+				break
+			}
+			var buf [2]byte
+			_, err = io.ReadFull(r, buf[:])
+			if err != nil {
+				break
+			}
+			t := cluster +
+				int64(int16(binary.BigEndian.Uint16(buf[:])))
+			if !seen || t < first {
+				first = t
+			}
+			if !seen || t > last {
+				last = t
+			}
+			seen = true
+			if size >= uint64(n)+2 {
+				_, err = r.Discard(int(size) - n - 2)
+			}
+		default:
+			_, err = r.Discard(int(size))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a recording in progress
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return time.Duration(last-first) * time.Duration(scale), nil
+}
+
+// mp4Duration returns the duration of a fragmented MP4 file, as written
+// by mp4Writer.
+func mp4Duration(r *bufio.Reader) (time.Duration, error) {
+	timescales := make(map[uint32]uint32)
+	starts := make(map[uint32]uint64)
+	ends := make(map[uint32]uint64)
+
+	for {
+		var header [8]byte
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		size := uint64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:])
+		if size == 1 {
+			var b [8]byte
+			_, err = io.ReadFull(r, b[:])
+			if err != nil {
+				break
+			}
+			size = binary.BigEndian.Uint64(b[:]) - 8
+		}
+		if size < 8 {
+			return 0, errors.New("bad MP4 box")
+		}
+		size -= 8
+		if typ != "moov" && typ != "moof" {
+			_, err = r.Discard(int(size))
+			if err != nil {
+				break
+			}
+			continue
+		}
+		if size > 16*1024*1024 {
+			return 0, errors.New("MP4 box too large")
+		}
+		data := make([]byte, size)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			break
+		}
+		if typ == "moov" {
+			mp4Timescales(data, timescales)
+		} else {
+			mp4Fragment(data, starts, ends)
+		}
+	}
+
+	var d time.Duration
+	for id, end := range ends {
+		ts := timescales[id]
+		if ts == 0 {
+			continue
+		}
+		dd := time.Duration(end-starts[id]) * time.Second /
+			time.Duration(ts)
+		if dd > d {
+			d = dd
+		}
+	}
+	return d, nil
+}
+
+// mp4Boxes calls f for each box contained in data.
+func mp4Boxes(data []byte, f func(typ string, payload []byte)) {
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		if size < 8 || uint64(size) > uint64(len(data)) {
+			return
+		}
+		f(string(data[4:8]), data[8:size])
+		data = data[size:]
+	}
+}
+
+func mp4Timescales(moov []byte, timescales map[uint32]uint32) {
+	mp4Boxes(moov, func(typ string, trak []byte) {
+		if typ != "trak" {
+			return
+		}
+		var id, timescale uint32
+		mp4Boxes(trak, func(typ string, payload []byte) {
+			switch typ {
+			case "tkhd":
+				// the track id follows two timestamps
+				o := 12
+				if len(payload) > 0 && payload[0] == 1 {
+					o = 20
+				}
+				if len(payload) >= o+4 {
+					id = binary.BigEndian.Uint32(payload[o:])
+				}
+			case "mdia":
+				mp4Boxes(payload, func(typ string, p []byte) {
+					if typ != "mdhd" {
+						return
+					}
+					o := 12
+					if len(p) > 0 && p[0] == 1 {
+						o = 20
+					}
+					if len(p) >= o+4 {
+						timescale =
+							binary.BigEndian.Uint32(p[o:])
+					}
+				})
+			}
+		})
+		if id != 0 {
+			timescales[id] = timescale
+		}
+	})
+}
+
+func mp4Fragment(moof []byte, starts, ends map[uint32]uint64) {
+	mp4Boxes(moof, func(typ string, traf []byte) {
+		if typ != "traf" {
+			return
+		}
+		var id uint32
+		var base, duration uint64
+		mp4Boxes(traf, func(typ string, p []byte) {
+			if len(p) < 8 {
+				return
+			}
+			switch typ {
+			case "tfhd":
+				id = binary.BigEndian.Uint32(p[4:])
+			case "tfdt":
+				if p[0] == 1 && len(p) >= 12 {
+					base = binary.BigEndian.Uint64(p[4:])
+				} else {
+					base = uint64(binary.BigEndian.Uint32(p[4:]))
+				}
+			case "trun":
+				duration += trunDuration(p)
+			}
+		})
+		if _, ok := starts[id]; !ok || base < starts[id] {
+			starts[id] = base
+		}
+		if base+duration > ends[id] {
+			ends[id] = base + duration
+		}
+	})
+}
+
+// trunDuration returns the sum of the sample durations in a trun box.
+func trunDuration(p []byte) uint64 {
+	flags := binary.BigEndian.Uint32(p) & 0xFFFFFF
+	count := binary.BigEndian.Uint32(p[4:])
+	o := 8
+	if flags&0x01 != 0 {
+		o += 4
+	}
+	if flags&0x04 != 0 {
+		o += 4
+	}
+	if flags&0x100 == 0 {
+		return 0
+	}
+	entry := 4
+	for _, f := range []uint32{0x200, 0x400, 0x800} {
+		if flags&f != 0 {
+			entry += 4
+		}
+	}
+	var d uint64
+	for i := uint32(0); i < count && o+4 <= len(p); i++ {
+		d += uint64(binary.BigEndian.Uint32(p[o:]))
+		o += entry
+	}
+	return d
+}
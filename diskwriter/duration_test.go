@@ -0,0 +1,123 @@
+package diskwriter
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/at-wat/ebml-go/mkvcore"
+	"github.com/at-wat/ebml-go/webm"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestMKVDuration(t *testing.T) {
+	var buf closeBuffer
+	ws, err := mkvcore.NewSimpleBlockWriter(
+		&buf, []mkvcore.TrackDescription{{
+			TrackNumber: 1,
+			TrackEntry: webm.TrackEntry{
+				Name:        "Audio",
+				TrackNumber: 1,
+				CodecID:     "A_OPUS",
+				TrackType:   2,
+			},
+		}},
+		mkvcore.WithEBMLHeader(webm.DefaultEBMLHeader),
+		mkvcore.WithSegmentInfo(webm.DefaultSegmentInfo),
+	)
+	if err != nil {
+		t.Fatalf("NewSimpleBlockWriter: %v", err)
+	}
+	// long enough to span several clusters
+	for ts := int64(1000); ts <= 61000; ts += 20 {
+		_, err := ws[0].Write(true, ts, []byte{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	ws[0].Close()
+
+	d, err := mkvDuration(bufio.NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil || d != 60*time.Second {
+		t.Errorf("Expected 60s, got %v %v", d, err)
+	}
+
+	// a recording in progress
+	b := buf.Bytes()[:buf.Len()/2]
+	d, err = mkvDuration(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil || d < 20*time.Second || d > 40*time.Second {
+		t.Errorf("Truncated: got %v %v", d, err)
+	}
+}
+
+func TestMP4Duration(t *testing.T) {
+	var buf closeBuffer
+	ws, err := newMP4Writer(&buf, []webrtc.RTPCodecCapability{
+		{MimeType: "audio/opus", ClockRate: 48000, Channels: 2},
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("newMP4Writer: %v", err)
+	}
+	for ts := int64(1000); ts < 11000; ts += 20 {
+		_, err := ws[0].Write(true, ts, []byte{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	ws[0].Close()
+
+	d, err := mp4Duration(bufio.NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil || d != 10*time.Second {
+		t.Errorf("Expected 10s, got %v %v", d, err)
+	}
+}
+
+func TestDurationCache(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.mp4")
+	var buf closeBuffer
+	ws, err := newMP4Writer(&buf, []webrtc.RTPCodecCapability{
+		{MimeType: "audio/opus", ClockRate: 48000, Channels: 2},
+	}, 0, 0)
+	if err != nil {
+		t.Fatalf("newMP4Writer: %v", err)
+	}
+	for ts := int64(0); ts < 2000; ts += 20 {
+		ws[0].Write(true, ts, []byte{1})
+	}
+	ws[0].Close()
+	err = os.WriteFile(fn, buf.Bytes(), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fi, err := os.Stat(fn)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	_, ok := CachedDuration(fn, fi)
+	if ok {
+		t.Errorf("Duration known before it was computed")
+	}
+	for i := 0; i < 100 && !ok; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, ok = CachedDuration(fn, fi)
+	}
+	if d, ok := CachedDuration(fn, fi); !ok || d != 2*time.Second {
+		t.Errorf("Expected 2s, got %v %v", d, ok)
+	}
+
+	for i := 0; i < 2; i++ {
+		d, err := Duration(fn)
+		if err != nil || d != 2*time.Second {
+			t.Errorf("Expected 2s, got %v %v", d, err)
+		}
+	}
+
+	_, err = Duration(filepath.Join(t.TempDir(), "test.txt"))
+	if err == nil {
+		t.Errorf("Duration of missing file succeeded")
+	}
+}
@@ -0,0 +1,144 @@
+package diskwriter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// When writing a recording fails, for example because the disk is full,
+// the current file is closed, the operators are notified, and packets are
+// dropped for resumeInterval.  After that, we attempt to resume recording
+// into a new file.
+
+const resumeInterval = 10 * time.Second
+
+// diskFile is a recording file.  Write errors are recorded rather than
+// returned, since the muxers either panic or stop consuming data when
+// the underlying writer fails; data written after an error is discarded.
+type diskFile struct {
+	file *os.File
+
+	mu      sync.Mutex
+	written int64
+	err     error
+}
+
+func (f *diskFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return len(p), nil
+	}
+	n, err := f.file.Write(p)
+	f.written += int64(n)
+	if err != nil {
+		f.err = err
+	}
+	return len(p), nil
+}
+
+func (f *diskFile) Close() error {
+	err := f.file.Close()
+	setActive(f.file.Name(), false)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return err
+}
+
+// Err returns the first error that occurred when writing to f.
+func (f *diskFile) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+func (f *diskFile) empty() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.written == 0
+}
+
+// who returns the user whose stream is recorded, for use in messages.
+func (conn *diskConn) who() string {
+	if conn.username == "" {
+		return "(anonymous)"
+	}
+	return conn.username
+}
+
+// fail is called when writing to disk has failed.  It closes the current
+// file, and notifies the operators unless we were attempting to resume.
+//
+// called locked
+func (conn *diskConn) fail(err error) {
+	conn.failed = time.Now()
+	file := conn.file
+	conn.close()
+	if file != nil && file.empty() {
+		// don't leave empty files behind when the disk is full
+		os.Remove(file.file.Name())
+	}
+
+	if conn.resuming {
+		return
+	}
+	conn.resuming = true
+
+	message := fmt.Sprintf(
+		"Recording of %v suspended: %v", conn.who(), err,
+	)
+	log.Println(message)
+	conn.client.group.WallOps(message)
+	conn.client.group.NotifyWebhooks(group.WebhookEvent{
+		Kind:     "record-error",
+		Username: conn.username,
+		Error:    err.Error(),
+	})
+}
+
+// checkFailed returns true if packets should be dropped because of
+// a recent failure.  When resumeInterval has passed, it prepares the
+// tracks for recording into a new file.
+//
+// called locked
+func (conn *diskConn) checkFailed() bool {
+	if conn.failed.IsZero() {
+		return false
+	}
+	if time.Since(conn.failed) < resumeInterval {
+		return true
+	}
+	conn.failed = time.Time{}
+	for _, t := range conn.tracks {
+		t.lastSeqno = none
+	}
+	return false
+}
+
+// written is called after data has been successfully written.  It
+// notifies the operators if recording has resumed after a failure.
+//
+// called locked
+func (conn *diskConn) written() {
+	if !conn.resuming || !conn.failed.IsZero() {
+		return
+	}
+	conn.resuming = false
+	message := fmt.Sprintf(
+		"Recording of %v resumed into a new file", conn.who(),
+	)
+	log.Println(message)
+	conn.client.group.WallOps(message)
+	conn.client.group.NotifyWebhooks(group.WebhookEvent{
+		Kind:     "record-resume",
+		Username: conn.username,
+	})
+}
@@ -0,0 +1,72 @@
+package diskwriter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskFile(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "test.webm"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	file := &diskFile{file: f}
+	if !file.empty() {
+		t.Errorf("New file is not empty")
+	}
+	n, err := file.Write([]byte("hello"))
+	if n != 5 || err != nil || file.Err() != nil || file.empty() {
+		t.Errorf("Write: %v %v %v", n, err, file.Err())
+	}
+
+	f.Close()
+	n, err = file.Write([]byte("world"))
+	if n != 5 || err != nil {
+		t.Errorf("Write after failure: %v %v", n, err)
+	}
+	if !errors.Is(file.Err(), os.ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", file.Err())
+	}
+}
+
+func TestFailResume(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "test.webm"))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer f.Close()
+	conn := &diskConn{
+		file: &diskFile{file: f},
+		// don't notify, there is no group
+		resuming: true,
+	}
+	if conn.checkFailed() {
+		t.Errorf("Working connection failed")
+	}
+
+	conn.fail(errors.New("disk full"))
+	if conn.file != nil {
+		t.Errorf("File not closed")
+	}
+	if _, err := os.Stat(f.Name()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Empty file not removed: %v", err)
+	}
+	if !conn.checkFailed() {
+		t.Errorf("Failed connection accepts packets")
+	}
+
+	conn.tracks = []*diskTrack{{conn: conn, lastSeqno: some(42)}}
+
+	conn.failed = time.Now().Add(-resumeInterval)
+	if conn.checkFailed() {
+		t.Errorf("Connection didn't resume")
+	}
+	if valid(conn.tracks[0].lastSeqno) {
+		t.Errorf("Sequence number not reset")
+	}
+}
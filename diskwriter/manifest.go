@@ -0,0 +1,136 @@
+package diskwriter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// When the recording layout is "tracks", every track is recorded into
+// a separate file, and the files are described by a manifest, a JSON file
+// that is rewritten whenever a new file is created.  The manifest records
+// the offset of every file from the start of the recording, which allows
+// the tracks to be aligned in post-production.
+
+// manifestEntry describes a file of a per-track recording.
+type manifestEntry struct {
+	File     string `json:"file"`
+	Id       string `json:"id"`
+	Label    string `json:"label,omitempty"`
+	Username string `json:"username,omitempty"`
+	Kind     string `json:"kind"`
+	Codec    string `json:"codec"`
+	// the offset, in seconds, of the start of the file from the
+	// start of the recording
+	Start float64 `json:"start"`
+}
+
+type manifest struct {
+	directory string
+
+	mu       sync.Mutex
+	filename string
+	Group    string          `json:"group"`
+	Start    time.Time       `json:"start"`
+	Files    []manifestEntry `json:"files"`
+}
+
+func newManifest(directory, group string, start time.Time) *manifest {
+	return &manifest{
+		directory: directory,
+		Group:     group,
+		Start:     start,
+		Files:     make([]manifestEntry, 0),
+	}
+}
+
+// add adds an entry to the manifest and writes it to disk.  The time of
+// the entry is the time of the start of the file.
+func (m *manifest) add(entry manifestEntry, tm time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Start = tm.Sub(m.Start).Seconds()
+	if entry.Start < 0 {
+		entry.Start = 0
+	}
+	m.Files = append(m.Files, entry)
+	return m.write()
+}
+
+// write writes the manifest atomically, creating the file if required.
+// called locked
+func (m *manifest) write() error {
+	if m.filename == "" {
+		f, err := openDiskFile(m.directory, "", "manifest.json")
+		if err != nil {
+			return err
+		}
+		m.filename = f.Name()
+		f.Close()
+		setActive(m.filename, true)
+	}
+
+	data, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(m.directory, ".manifest-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), m.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return errors.New("couldn't write manifest: " + err.Error())
+	}
+	return nil
+}
+
+// close is called when the recording is over.
+func (m *manifest) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.filename != "" {
+		setActive(m.filename, false)
+	}
+}
+
+// addToManifest records the file that was just opened by conn in the
+// manifest of the recording, if any.
+// called locked
+func (conn *diskConn) addToManifest() {
+	if conn.manifest == nil || conn.file == nil {
+		return
+	}
+	tm := conn.originLocal
+	if tm.IsZero() {
+		tm = time.Now()
+	}
+	entry := manifestEntry{
+		File:     filepath.Base(conn.file.file.Name()),
+		Id:       conn.remote.Id(),
+		Label:    conn.remote.Label(),
+		Username: conn.username,
+	}
+	if len(conn.tracks) > 0 {
+		codec := conn.tracks[0].remote.Codec()
+		entry.Kind = conn.tracks[0].remote.Kind().String()
+		entry.Codec = codec.MimeType
+	}
+	err := conn.manifest.add(entry, tm)
+	if err != nil {
+		conn.warn("Write to disk: " + err.Error())
+	}
+}
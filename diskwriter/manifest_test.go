@@ -0,0 +1,60 @@
+package diskwriter
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now()
+	m := newManifest(dir, "test", start)
+
+	err := m.add(manifestEntry{
+		File: "audio.webm", Id: "a", Username: "vimes", Kind: "audio",
+	}, start.Add(1500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	err = m.add(manifestEntry{
+		File: "video.webm", Id: "a", Username: "vimes", Kind: "video",
+	}, start.Add(-time.Second))
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 ||
+		!strings.HasSuffix(entries[0].Name(), ".manifest.json") {
+		t.Fatalf("Unexpected files %v", entries)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var mm struct {
+		Group string          `json:"group"`
+		Files []manifestEntry `json:"files"`
+	}
+	err = json.Unmarshal(data, &mm)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if mm.Group != "test" || len(mm.Files) != 2 {
+		t.Fatalf("Unexpected manifest %v", mm)
+	}
+	if mm.Files[0].Start != 1.5 || mm.Files[0].Kind != "audio" {
+		t.Errorf("Unexpected entry %v", mm.Files[0])
+	}
+	if mm.Files[1].Start != 0 {
+		t.Errorf("Negative start not clamped: %v", mm.Files[1])
+	}
+}
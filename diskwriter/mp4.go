@@ -0,0 +1,618 @@
+package diskwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// This file implements a minimal writer for fragmented MP4 files
+// (ISO/IEC 14496-12), which are easier to ingest than WebM by many
+// tools.  The file starts with an initialisation segment (ftyp and moov),
+// which is written once the codec configuration is known, followed by
+// a sequence of fragments (moof and mdat), each of which starts at a video
+// keyframe if there is a video track.
+
+const (
+	// the minimum duration of a fragment, in milliseconds
+	mp4MinFragment = 1000
+	// the duration after which a fragment is flushed even if no
+	// keyframe has been seen
+	mp4MaxFragment = 10000
+)
+
+type mp4Sample struct {
+	timestamp int64 // in milliseconds
+	duration  int64 // in milliseconds
+	keyframe  bool
+	data      []byte
+}
+
+type mp4Track struct {
+	writer    *mp4Writer
+	id        uint32
+	codec     webrtc.RTPCodecCapability
+	timescale uint32
+	closed    bool
+
+	// H.264 parameter sets
+	sps, pps []byte
+	// VP9 profile, bit depth, chroma subsampling and range, from the
+	// first keyframe
+	vp9 *vp9Config
+
+	started    bool
+	decodeTime int64 // in milliseconds
+	samples    []mp4Sample
+	last       *mp4Sample
+}
+
+type mp4Writer struct {
+	w             io.WriteCloser
+	width, height uint32
+	tracks        []*mp4Track
+	initialised   bool
+	sequence      uint32
+	hasVideo      bool
+}
+
+func isVideo(codec webrtc.RTPCodecCapability) bool {
+	return strings.HasPrefix(strings.ToLower(codec.MimeType), "video/")
+}
+
+// newMP4Writer returns one writer per codec, in the same order.  The
+// underlying file is closed when all the writers have been closed.
+func newMP4Writer(w io.WriteCloser, codecs []webrtc.RTPCodecCapability, width, height uint32) ([]*mp4Track, error) {
+	writer := &mp4Writer{
+		w:      w,
+		width:  width,
+		height: height,
+	}
+	for i, codec := range codecs {
+		t := &mp4Track{
+			writer: writer,
+			id:     uint32(i + 1),
+			codec:  codec,
+		}
+		mime := strings.ToLower(codec.MimeType)
+		switch mime {
+		case "audio/opus":
+			t.timescale = 48000
+		case "video/h264", "video/vp8", "video/vp9":
+			t.timescale = 90000
+			writer.hasVideo = true
+		default:
+			return nil, errors.New("cannot record " + mime + " in MP4")
+		}
+		writer.tracks = append(writer.tracks, t)
+	}
+	return writer.tracks, nil
+}
+
+func (t *mp4Track) units(ms int64) int64 {
+	return ms * int64(t.timescale) / 1000
+}
+
+// Write implements mkvcore.BlockWriter.  The timestamp is in milliseconds.
+func (t *mp4Track) Write(keyframe bool, timestamp int64, b []byte) (int, error) {
+	if t.closed {
+		return 0, errors.New("track is closed")
+	}
+
+	data := b
+	if strings.EqualFold(t.codec.MimeType, "video/h264") {
+		var sps, pps []byte
+		data, sps, pps = annexBToAVCC(b)
+		if sps != nil && pps != nil {
+			t.sps, t.pps = sps, pps
+		}
+		if t.sps == nil || t.pps == nil {
+			return 0, errors.New("missing H.264 parameter sets")
+		}
+		if len(data) == 0 {
+			return len(b), nil
+		}
+	} else {
+		data = append([]byte(nil), b...)
+		if keyframe && t.vp9 == nil &&
+			strings.EqualFold(t.codec.MimeType, "video/vp9") {
+			t.vp9 = parseVP9Config(b)
+		}
+	}
+
+	if !t.started {
+		t.started = true
+		t.decodeTime = timestamp
+	}
+
+	if t.last != nil {
+		d := timestamp - t.last.timestamp
+		if d < 0 {
+			d = 0
+		}
+		t.last.duration = d
+		t.samples = append(t.samples, *t.last)
+		t.last = nil
+	}
+
+	w := t.writer
+	if keyframe && isVideo(t.codec) {
+		if t.pending() >= mp4MinFragment {
+			err := w.flush()
+			if err != nil {
+				return 0, err
+			}
+		}
+	} else if !w.hasVideo && t.pending() >= mp4MinFragment {
+		err := w.flush()
+		if err != nil {
+			return 0, err
+		}
+	} else if t.pending() >= mp4MaxFragment {
+		err := w.flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	t.last = &mp4Sample{
+		timestamp: timestamp,
+		keyframe:  keyframe || !isVideo(t.codec),
+		data:      data,
+	}
+	return len(b), nil
+}
+
+// pending returns the duration of the samples that haven't been written
+// yet.
+func (t *mp4Track) pending() int64 {
+	var d int64
+	for _, s := range t.samples {
+		d += s.duration
+	}
+	return d
+}
+
+// Close implements mkvcore.BlockCloser.
+func (t *mp4Track) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if t.last != nil {
+		// we don't know the duration of the last sample, guess
+		d := int64(20)
+		if len(t.samples) > 0 {
+			d = t.samples[len(t.samples)-1].duration
+		}
+		t.last.duration = d
+		t.samples = append(t.samples, *t.last)
+		t.last = nil
+	}
+
+	w := t.writer
+	for _, tt := range w.tracks {
+		if !tt.closed {
+			return nil
+		}
+	}
+	err := w.flush()
+	err2 := w.w.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (w *mp4Writer) flush() error {
+	count := 0
+	for _, t := range w.tracks {
+		count += len(t.samples)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	if !w.initialised {
+		init, err := w.initSegment()
+		if err != nil {
+			return err
+		}
+		_, err = w.w.Write(init)
+		if err != nil {
+			return err
+		}
+		w.initialised = true
+	}
+
+	w.sequence++
+	moof := w.moof(0)
+	moof = w.moof(uint32(len(moof)))
+
+	size := 8
+	for _, t := range w.tracks {
+		for _, s := range t.samples {
+			size += len(s.data)
+		}
+	}
+	buf := make([]byte, 0, len(moof)+size)
+	buf = append(buf, moof...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, "mdat"...)
+	for _, t := range w.tracks {
+		for _, s := range t.samples {
+			buf = append(buf, s.data...)
+			t.decodeTime += s.duration
+		}
+		t.samples = t.samples[:0]
+	}
+	_, err := w.w.Write(buf)
+	return err
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func mp4FullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags)
+	return mp4Box(typ, append([][]byte{header}, payload...)...)
+}
+
+func be16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func be32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+var mp4Matrix = []byte{
+	0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 0,
+}
+
+func (w *mp4Writer) initSegment() ([]byte, error) {
+	ftyp := mp4Box("ftyp",
+		[]byte("iso5"), be32(0x200),
+		[]byte("iso5iso6mp41"),
+	)
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		be32(0), be32(0), // creation and modification time
+		be32(1000), be32(0), // timescale and duration
+		be32(0x00010000), be16(0x0100), // rate and volume
+		make([]byte, 10),
+		mp4Matrix,
+		make([]byte, 24),
+		be32(uint32(len(w.tracks)+1)),
+	)
+
+	traks := make([][]byte, 0, len(w.tracks))
+	trexs := make([][]byte, 0, len(w.tracks))
+	for _, t := range w.tracks {
+		trak, err := t.trak(w.width, w.height)
+		if err != nil {
+			return nil, err
+		}
+		traks = append(traks, trak)
+		trexs = append(trexs, mp4FullBox("trex", 0, 0,
+			be32(t.id), be32(1), be32(0), be32(0), be32(0),
+		))
+	}
+
+	moov := mp4Box("moov",
+		append(append([][]byte{mvhd}, traks...),
+			mp4Box("mvex", trexs...),
+		)...,
+	)
+	return append(ftyp, moov...), nil
+}
+
+func (t *mp4Track) trak(width, height uint32) ([]byte, error) {
+	video := isVideo(t.codec)
+	var volume uint16
+	if !video {
+		volume = 0x0100
+		width, height = 0, 0
+	}
+
+	tkhd := mp4FullBox("tkhd", 0, 3,
+		be32(0), be32(0), // creation and modification time
+		be32(t.id), be32(0), be32(0), // track id, reserved, duration
+		make([]byte, 8),
+		be16(0), be16(0), be16(volume), be16(0),
+		mp4Matrix,
+		be32(width<<16), be32(height<<16),
+	)
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		be32(0), be32(0),
+		be32(t.timescale), be32(0),
+		be16(0x55c4), be16(0), // language "und"
+	)
+
+	handler, name := "soun", "SoundHandler"
+	header := mp4FullBox("smhd", 0, 0, be32(0))
+	if video {
+		handler, name = "vide", "VideoHandler"
+		header = mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+	hdlr := mp4FullBox("hdlr", 0, 0,
+		be32(0), []byte(handler), make([]byte, 12),
+		[]byte(name), []byte{0},
+	)
+
+	dinf := mp4Box("dinf",
+		mp4FullBox("dref", 0, 0, be32(1), mp4FullBox("url ", 0, 1)),
+	)
+
+	entry, err := t.sampleEntry(width, height)
+	if err != nil {
+		return nil, err
+	}
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, be32(1), entry),
+		mp4FullBox("stts", 0, 0, be32(0)),
+		mp4FullBox("stsc", 0, 0, be32(0)),
+		mp4FullBox("stsz", 0, 0, be32(0), be32(0)),
+		mp4FullBox("stco", 0, 0, be32(0)),
+	)
+
+	return mp4Box("trak",
+		tkhd,
+		mp4Box("mdia", mdhd, hdlr, mp4Box("minf", header, dinf, stbl)),
+	), nil
+}
+
+func (t *mp4Track) sampleEntry(width, height uint32) ([]byte, error) {
+	mime := strings.ToLower(t.codec.MimeType)
+	if mime == "audio/opus" {
+		channels := t.codec.Channels
+		if channels == 0 {
+			channels = 2
+		}
+		dops := mp4Box("dOps",
+			[]byte{0, byte(channels)},
+			be16(312), // pre-skip
+			be32(48000),
+			be16(0),   // output gain
+			[]byte{0}, // channel mapping family
+		)
+		return mp4Box("Opus",
+			make([]byte, 6), be16(1), // data reference index
+			make([]byte, 8),
+			be16(channels), be16(16), be16(0), be16(0),
+			be32(48000<<16),
+			dops,
+		), nil
+	}
+
+	var config []byte
+	var typ string
+	switch mime {
+	case "video/h264":
+		if t.sps == nil || t.pps == nil || len(t.sps) < 4 {
+			return nil, errors.New("missing H.264 parameter sets")
+		}
+		typ = "avc1"
+		config = mp4Box("avcC",
+			[]byte{1, t.sps[1], t.sps[2], t.sps[3], 0xFF, 0xE1},
+			be16(uint16(len(t.sps))), t.sps,
+			[]byte{1},
+			be16(uint16(len(t.pps))), t.pps,
+		)
+	case "video/vp8", "video/vp9":
+		typ = "vp08"
+		if mime == "video/vp9" {
+			typ = "vp09"
+		}
+		// profile 0, level 1.0, 8 bits, 4:2:0, BT.709, unless the
+		// VP9 frame header says otherwise
+		c := vp9Config{bitDepth: 8, chroma: 1}
+		if t.vp9 != nil {
+			c = *t.vp9
+		}
+		var fullRange byte
+		if c.fullRange {
+			fullRange = 1
+		}
+		config = mp4FullBox("vpcC", 1, 0,
+			[]byte{
+				c.profile, 10,
+				c.bitDepth<<4 | c.chroma<<1 | fullRange,
+				1, 1, 1,
+			},
+			be16(0),
+		)
+	default:
+		return nil, errors.New("unknown codec " + mime)
+	}
+
+	return mp4Box(typ,
+		make([]byte, 6), be16(1), // data reference index
+		make([]byte, 16),
+		be16(uint16(width)), be16(uint16(height)),
+		be32(0x00480000), be32(0x00480000), // resolution
+		be32(0), be16(1), // frame count
+		make([]byte, 32), // compressor name
+		be16(0x0018), be16(0xFFFF),
+		config,
+	), nil
+}
+
+// moof returns a movie fragment header for the pending samples.  Since
+// the data offsets depend on the size of the header, this is called
+// twice, first with a size of 0.
+func (w *mp4Writer) moof(size uint32) []byte {
+	offset := size + 8
+	trafs := [][]byte{mp4FullBox("mfhd", 0, 0, be32(w.sequence))}
+	for _, t := range w.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		tfhd := mp4FullBox("tfhd", 0, 0x020000, be32(t.id))
+		tfdt := mp4FullBox("tfdt", 1, 0,
+			binary.BigEndian.AppendUint64(nil,
+				uint64(t.units(t.decodeTime)),
+			),
+		)
+		var entries bytes.Buffer
+		start := t.decodeTime
+		for _, s := range t.samples {
+			// compute durations in track units without
+			// accumulating rounding errors
+			d := t.units(start+s.duration) - t.units(start)
+			start += s.duration
+			flags := uint32(0x01010000)
+			if s.keyframe {
+				flags = 0x02000000
+			}
+			entries.Write(be32(uint32(d)))
+			entries.Write(be32(uint32(len(s.data))))
+			entries.Write(be32(flags))
+		}
+		trun := mp4FullBox("trun", 0, 0x000701,
+			be32(uint32(len(t.samples))), be32(offset),
+			entries.Bytes(),
+		)
+		trafs = append(trafs, mp4Box("traf", tfhd, tfdt, trun))
+		for _, s := range t.samples {
+			offset += uint32(len(s.data))
+		}
+	}
+	return mp4Box("moof", trafs...)
+}
+
+// annexBToAVCC converts an H.264 access unit from Annex B format to the
+// length-prefixed format used by MP4.  Parameter sets and access unit
+// delimiters are removed, and returned separately.
+func annexBToAVCC(b []byte) ([]byte, []byte, []byte) {
+	var out, sps, pps []byte
+	for len(b) > 0 {
+		// skip the start code
+		i := 0
+		for i < len(b) && b[i] == 0 {
+			i++
+		}
+		if i < 2 || i >= len(b) || b[i] != 1 {
+			// not Annex B, assume a single NAL
+			i = -1
+		}
+		b = b[i+1:]
+
+		end := len(b)
+		for j := 0; j+2 < len(b); j++ {
+			if b[j] == 0 && b[j+1] == 0 &&
+				(b[j+2] == 1 || (b[j+2] == 0 &&
+					j+3 < len(b) && b[j+3] == 1)) {
+				end = j
+				break
+			}
+		}
+		nal := b[:end]
+		b = b[end:]
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1F {
+		case 7:
+			sps = append([]byte(nil), nal...)
+		case 8:
+			pps = append([]byte(nil), nal...)
+		case 9:
+		default:
+			out = binary.BigEndian.AppendUint32(out, uint32(len(nal)))
+			out = append(out, nal...)
+		}
+	}
+	return out, sps, pps
+}
+
+type vp9Config struct {
+	profile   byte
+	bitDepth  byte
+	chroma    byte // as in the vpcC box
+	fullRange bool
+}
+
+// parseVP9Config parses the uncompressed header of a VP9 keyframe, and
+// returns nil if it is not a keyframe.
+func parseVP9Config(frame []byte) *vp9Config {
+	pos := 0
+	// the header is at most 40 bits long
+	bit := func() byte {
+		v := (frame[pos/8] >> (7 - pos%8)) & 1
+		pos++
+		return v
+	}
+	bits := func(n int) int {
+		v := 0
+		for i := 0; i < n; i++ {
+			v = v<<1 | int(bit())
+		}
+		return v
+	}
+
+	if len(frame) < 5 || bits(2) != 2 {
+		return nil
+	}
+	c := &vp9Config{}
+	c.profile = bit()
+	c.profile |= bit() << 1
+	if c.profile == 3 {
+		bit() // reserved
+	}
+	showExisting := bit()
+	frameType := bit()
+	if showExisting != 0 || frameType != 0 {
+		return nil
+	}
+	bits(2) // show_frame, error_resilient_mode
+	if bits(24) != 0x498342 {
+		return nil
+	}
+
+	c.bitDepth = 8
+	if c.profile >= 2 {
+		c.bitDepth = 10
+		if bit() != 0 {
+			c.bitDepth = 12
+		}
+	}
+	subsamplingX, subsamplingY := byte(1), byte(1)
+	if bits(3) != 7 { // not sRGB
+		c.fullRange = bit() != 0
+		if c.profile == 1 || c.profile == 3 {
+			subsamplingX, subsamplingY = bit(), bit()
+		}
+	} else {
+		c.fullRange = true
+		subsamplingX, subsamplingY = 0, 0
+	}
+
+	switch {
+	case subsamplingX == 0 && subsamplingY == 0:
+		c.chroma = 3 // 4:4:4
+	case subsamplingY == 0:
+		c.chroma = 2 // 4:2:2
+	default:
+		c.chroma = 1 // 4:2:0
+	}
+	return c
+}
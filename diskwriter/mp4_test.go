@@ -0,0 +1,199 @@
+package diskwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+type testBox struct {
+	typ     string
+	payload []byte
+}
+
+func parseBoxes(t *testing.T, b []byte) []testBox {
+	var boxes []testBox
+	for len(b) > 0 {
+		if len(b) < 8 {
+			t.Fatalf("Truncated box header")
+		}
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			t.Fatalf("Bad box size %v", size)
+		}
+		boxes = append(boxes, testBox{string(b[4:8]), b[8:size]})
+		b = b[size:]
+	}
+	return boxes
+}
+
+func boxTypes(boxes []testBox) []string {
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.typ)
+	}
+	return types
+}
+
+func TestAnnexBToAVCC(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xc0, 0x1f}
+	pps := []byte{0x68, 0xce, 0x3c, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00, 0x21}
+	var in []byte
+	in = append(in, 0, 0, 0, 1, 0x09, 0xf0)
+	in = append(in, 0, 0, 0, 1)
+	in = append(in, sps...)
+	in = append(in, 0, 0, 1)
+	in = append(in, pps...)
+	in = append(in, 0, 0, 0, 1)
+	in = append(in, idr...)
+
+	out, s, p := annexBToAVCC(in)
+	if !bytes.Equal(s, sps) || !bytes.Equal(p, pps) {
+		t.Errorf("Parameter sets: %v %v", s, p)
+	}
+	expected := append([]byte{0, 0, 0, byte(len(idr))}, idr...)
+	if !bytes.Equal(out, expected) {
+		t.Errorf("Expected %v, got %v", expected, out)
+	}
+}
+
+func TestMP4Writer(t *testing.T) {
+	var buf closeBuffer
+	ws, err := newMP4Writer(&buf, []webrtc.RTPCodecCapability{
+		{MimeType: "audio/opus", ClockRate: 48000, Channels: 2},
+		{MimeType: "video/h264", ClockRate: 90000},
+	}, 640, 480)
+	if err != nil {
+		t.Fatalf("newMP4Writer: %v", err)
+	}
+	audio, video := ws[0], ws[1]
+
+	keyframe := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1f,
+		0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+		0, 0, 0, 1, 0x65, 0x88, 0x84,
+	}
+	delta := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02}
+
+	// 3 seconds of media, with a keyframe every 2 seconds
+	for ts := int64(0); ts < 3000; ts += 20 {
+		_, err := audio.Write(true, ts, []byte{1, 2, 3})
+		if err != nil {
+			t.Fatalf("Write audio: %v", err)
+		}
+		if ts%40 == 0 {
+			kf := ts%2000 == 0
+			data := delta
+			if kf {
+				data = keyframe
+			}
+			_, err := video.Write(kf, ts, data)
+			if err != nil {
+				t.Fatalf("Write video: %v", err)
+			}
+		}
+	}
+	if buf.Len() == 0 {
+		t.Errorf("Nothing written after first fragment")
+	}
+
+	audio.Close()
+	if buf.closed {
+		t.Errorf("File closed too early")
+	}
+	video.Close()
+	if !buf.closed {
+		t.Errorf("File not closed")
+	}
+
+	boxes := parseBoxes(t, buf.Bytes())
+	types := boxTypes(boxes)
+	expected := []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("Expected %v, got %v", expected, types)
+	}
+
+	moov := parseBoxes(t, boxes[1].payload)
+	if !reflect.DeepEqual(boxTypes(moov),
+		[]string{"mvhd", "trak", "trak", "mvex"}) {
+		t.Errorf("Unexpected moov %v", boxTypes(moov))
+	}
+
+	// count the samples in every fragment
+	var samples []uint32
+	for _, i := range []int{2, 4} {
+		for _, b := range parseBoxes(t, boxes[i].payload) {
+			if b.typ != "traf" {
+				continue
+			}
+			for _, bb := range parseBoxes(t, b.payload) {
+				if bb.typ == "trun" {
+					samples = append(samples,
+						binary.BigEndian.Uint32(
+							bb.payload[4:],
+						))
+				}
+			}
+		}
+	}
+	// the first fragment stops before the second keyframe
+	if !reflect.DeepEqual(samples, []uint32{100, 50, 50, 25}) {
+		t.Errorf("Unexpected sample counts %v", samples)
+	}
+}
+
+func TestMP4WriterNoParameterSets(t *testing.T) {
+	var buf closeBuffer
+	ws, err := newMP4Writer(&buf, []webrtc.RTPCodecCapability{
+		{MimeType: "video/h264", ClockRate: 90000},
+	}, 640, 480)
+	if err != nil {
+		t.Fatalf("newMP4Writer: %v", err)
+	}
+	_, err = ws[0].Write(true, 0, []byte{0, 0, 0, 1, 0x65, 0x88})
+	if err == nil {
+		t.Errorf("Write succeeded without parameter sets")
+	}
+}
+
+func TestParseVP9Config(t *testing.T) {
+	tests := []struct {
+		frame    []byte
+		expected *vp9Config
+	}{
+		// profile 0, studio range
+		{[]byte{0x82, 0x49, 0x83, 0x42, 0x00}, &vp9Config{0, 8, 1, false}},
+		// profile 1, BT.709, full range, 4:4:4
+		{[]byte{0xa2, 0x49, 0x83, 0x42, 0x50}, &vp9Config{1, 8, 3, true}},
+		// profile 2, 10 bits
+		{[]byte{0x92, 0x49, 0x83, 0x42, 0x00}, &vp9Config{2, 10, 1, false}},
+		// profile 3, 12 bits, sRGB
+		{[]byte{0xb1, 0x24, 0xc1, 0xa1, 0x7c}, &vp9Config{3, 12, 3, true}},
+		// interframe
+		{[]byte{0x86, 0x49, 0x83, 0x42, 0x00}, nil},
+		// bad sync code
+		{[]byte{0x82, 0x49, 0x83, 0x43, 0x00}, nil},
+		{[]byte{0x82, 0x49}, nil},
+	}
+	for _, test := range tests {
+		c := parseVP9Config(test.frame)
+		if !reflect.DeepEqual(c, test.expected) {
+			t.Errorf("%x: expected %v, got %v",
+				test.frame, test.expected, c)
+		}
+	}
+}
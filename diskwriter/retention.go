@@ -0,0 +1,180 @@
+package diskwriter
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// Recordings are pruned periodically, and whenever a disk writer is
+// closed.  The files that are being written are never pruned.
+
+// the interval between two periodic prunings
+const pruneInterval = 15 * time.Minute
+
+// activeFiles contains the names of the files being written.
+var activeFiles struct {
+	mu    sync.Mutex
+	files map[string]struct{}
+}
+
+func activeKey(filename string) string {
+	f, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.Clean(filename)
+	}
+	return f
+}
+
+// setActive records whether a file is being written.
+func setActive(filename string, active bool) {
+	activeFiles.mu.Lock()
+	defer activeFiles.mu.Unlock()
+	if !active {
+		delete(activeFiles.files, activeKey(filename))
+		return
+	}
+	if activeFiles.files == nil {
+		activeFiles.files = make(map[string]struct{})
+	}
+	activeFiles.files[activeKey(filename)] = struct{}{}
+}
+
+func isActive(filename string) bool {
+	activeFiles.mu.Lock()
+	defer activeFiles.mu.Unlock()
+	_, ok := activeFiles.files[activeKey(filename)]
+	return ok
+}
+
+// PruneResult describes the recordings removed by Prune.
+type PruneResult struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// prune removes the files in directory that are older than maxAge, and
+// then the oldest files until the total size is at most maxSize.  A zero
+// value for maxAge or maxSize means no limit.  Active files are counted
+// towards the total size, but never removed.
+func prune(directory string, maxAge time.Duration, maxSize int64, now time.Time) (PruneResult, error) {
+	var result PruneResult
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return result, err
+	}
+
+	var files []fs.FileInfo
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fi)
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, fi := range files {
+		age := now.Sub(fi.ModTime())
+		if !(maxAge > 0 && age > maxAge) &&
+			!(maxSize > 0 && total > maxSize) {
+			continue
+		}
+		filename := filepath.Join(directory, fi.Name())
+		if isActive(filename) {
+			continue
+		}
+		err := os.Remove(filename)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return result, err
+		}
+		total -= fi.Size()
+		result.Files++
+		result.Bytes += fi.Size()
+	}
+	return result, nil
+}
+
+// PruneGroup applies a group's retention policy to its recordings.
+func PruneGroup(name string) (PruneResult, error) {
+	desc, err := group.GetDescription(name)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	if desc.RecordingMaxAge <= 0 && desc.RecordingMaxSize <= 0 {
+		return PruneResult{}, nil
+	}
+	return prune(
+		filepath.Join(Directory, filepath.FromSlash(name)),
+		time.Duration(desc.RecordingMaxAge)*time.Second,
+		desc.RecordingMaxSize,
+		time.Now(),
+	)
+}
+
+// PruneLoop prunes the recordings of all groups periodically.  It never
+// returns.
+func PruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		Prune()
+		<-ticker.C
+	}
+}
+
+// logPrune prunes the recordings of a group and logs the result.
+func logPrune(name string) {
+	result, err := PruneGroup(name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Prune recordings of %v: %v", name, err)
+		}
+		return
+	}
+	if result.Files > 0 {
+		log.Printf("Pruned %v recordings (%v bytes) of %v",
+			result.Files, result.Bytes, name)
+	}
+}
+
+// Prune applies the retention policy of each group to its recordings.
+func Prune() {
+	var names []string
+	filepath.WalkDir(Directory,
+		func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() || p == Directory {
+				return nil
+			}
+			name, err := filepath.Rel(Directory, p)
+			if err == nil {
+				names = append(names, filepath.ToSlash(name))
+			}
+			return nil
+		},
+	)
+
+	for _, name := range names {
+		logPrune(name)
+	}
+}
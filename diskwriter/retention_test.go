@@ -0,0 +1,112 @@
+package diskwriter
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"old.webm", 100, 48 * time.Hour},
+		{"older.webm", 100, 72 * time.Hour},
+		{"recent.webm", 100, time.Hour},
+		{"active.webm", 1000, 96 * time.Hour},
+	}
+
+	setup := func() string {
+		d := t.TempDir()
+		for _, f := range files {
+			fn := filepath.Join(d, f.name)
+			err := os.WriteFile(fn, make([]byte, f.size), 0600)
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			tm := now.Add(-f.age)
+			err = os.Chtimes(fn, tm, tm)
+			if err != nil {
+				t.Fatalf("Chtimes: %v", err)
+			}
+		}
+		setActive(filepath.Join(d, "active.webm"), true)
+		err := os.Mkdir(filepath.Join(d, "subgroup"), 0700)
+		if err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+		return d
+	}
+
+	remaining := func(d string) []string {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	tests := []struct {
+		maxAge  time.Duration
+		maxSize int64
+		files   int
+		left    []string
+	}{
+		{0, 0, 0, []string{
+			"active.webm", "old.webm", "older.webm", "recent.webm",
+			"subgroup",
+		}},
+		{24 * time.Hour, 0, 2, []string{
+			"active.webm", "recent.webm", "subgroup",
+		}},
+		{0, 1150, 2, []string{
+			"active.webm", "recent.webm", "subgroup",
+		}},
+		{0, 1250, 1, []string{
+			"active.webm", "old.webm", "recent.webm", "subgroup",
+		}},
+		{0, 1, 3, []string{"active.webm", "subgroup"}},
+	}
+
+	for _, test := range tests {
+		d := setup()
+		result, err := prune(d, test.maxAge, test.maxSize, now)
+		if err != nil {
+			t.Errorf("prune: %v", err)
+			continue
+		}
+		if result.Files != test.files ||
+			result.Bytes != int64(100*test.files) {
+			t.Errorf("prune %v %v: got %v",
+				test.maxAge, test.maxSize, result)
+		}
+		left := remaining(d)
+		if len(left) != len(test.left) {
+			t.Errorf("prune %v %v: expected %v, got %v",
+				test.maxAge, test.maxSize, test.left, left)
+			continue
+		}
+		for i := range left {
+			if left[i] != test.left[i] {
+				t.Errorf("prune %v %v: expected %v, got %v",
+					test.maxAge, test.maxSize, test.left, left)
+				break
+			}
+		}
+	}
+
+	_, err := prune(filepath.Join(t.TempDir(), "missing"), time.Hour, 0, now)
+	if err != nil {
+		t.Errorf("prune (missing directory): %v", err)
+	}
+}
@@ -0,0 +1,650 @@
+// Package dnssd advertises the server on the local network using
+// multicast DNS service discovery (RFC 6762 and RFC 6763), which allows
+// clients to find the server on networks with no DNS server.
+package dnssd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+)
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// The TTL of our records, in seconds.  Queriers that don't use port 5353
+// don't understand mDNS, and get a short TTL (RFC 6762 Section 6.7).
+const (
+	ttl       = 120
+	legacyTTL = 10
+)
+
+// the cache-flush bit of the class field, which indicates that we own
+// the record
+const cacheFlush = 0x8000
+
+var errNotFound = errors.New("no matching records")
+
+// service describes the advertised service.
+type service struct {
+	instance dnsmessage.Name
+	stype    dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	txt      []string
+	// returns the addresses of the interface with the given index
+	addresses func(ifindex int) []net.IP
+	// the labels from which the instance and host names are derived
+	instanceLabel, hostLabel string
+}
+
+var servicesName = dnsmessage.MustNewName("_services._dns-sd._udp.local.")
+
+// label converts s into something that can be used as a single DNS label.
+func label(s string) string {
+	s = strings.ReplaceAll(s, ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+func newService(hostname string, port int, secure bool) (*service, error) {
+	stype := "_https._tcp.local."
+	if !secure {
+		stype = "_http._tcp.local."
+	}
+	host := label(strings.SplitN(hostname, ".", 2)[0])
+	if host == "" {
+		return nil, errors.New("empty hostname")
+	}
+	s := &service{
+		port:          uint16(port),
+		txt:           []string{"path=/"},
+		addresses:     interfaceAddresses,
+		instanceLabel: "Galene on " + host,
+		hostLabel:     host,
+	}
+	var err error
+	s.stype, err = dnsmessage.NewName(stype)
+	if err != nil {
+		return nil, err
+	}
+	err = s.rename(1)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rename sets the names of the service, adding a suffix if n > 1, which
+// is used to choose new names after a conflict (RFC 6762 Section 9).
+func (s *service) rename(n int) error {
+	instance, host := s.instanceLabel, s.hostLabel
+	if n > 1 {
+		instance = fmt.Sprintf("%v (%v)", instance, n)
+		host = fmt.Sprintf("%v-%v", host, n)
+	}
+	var err error
+	s.instance, err = dnsmessage.NewName(
+		label(instance) + "." + s.stype.String(),
+	)
+	if err != nil {
+		return err
+	}
+	s.host, err = dnsmessage.NewName(label(host) + ".local.")
+	return err
+}
+
+// advertised returns true if we advertise the address ip.  Link-local
+// IPv4 addresses are common on networks with no DHCP server, which is
+// where mDNS is most useful, but link-local IPv6 addresses require a zone.
+func advertised(ip net.IP) bool {
+	return ip.IsGlobalUnicast() ||
+		(ip.To4() != nil && ip.IsLinkLocalUnicast())
+}
+
+// interfaceAddresses returns the addresses that we advertise on
+// a given interface, or on all interfaces if ifindex is 0.
+func interfaceAddresses(ifindex int) []net.IP {
+	var addrs []net.Addr
+	var err error
+	if ifindex == 0 {
+		addrs, err = net.InterfaceAddrs()
+	} else {
+		var ifi *net.Interface
+		ifi, err = net.InterfaceByIndex(ifindex)
+		if err == nil {
+			addrs, err = ifi.Addrs()
+		}
+	}
+	if err != nil {
+		log.Printf("DNS-SD: %v", err)
+		return nil
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || !advertised(ipnet.IP) {
+			continue
+		}
+		ips = append(ips, ipnet.IP)
+	}
+	return ips
+}
+
+func equal(n1, n2 dnsmessage.Name) bool {
+	return strings.EqualFold(n1.String(), n2.String())
+}
+
+// records returns our records, with the addresses of interface ifindex.
+func (s *service) records(ifindex int, ttl uint32, flush bool) []dnsmessage.Resource {
+	class := dnsmessage.ClassINET
+	if flush {
+		class |= cacheFlush
+	}
+	header := func(name dnsmessage.Name, tpe dnsmessage.Type, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{
+			Name: name, Type: tpe, Class: class, TTL: ttl,
+		}
+	}
+	rs := []dnsmessage.Resource{
+		{
+			// shared records never have the cache-flush bit
+			Header: header(servicesName,
+				dnsmessage.TypePTR, dnsmessage.ClassINET),
+			Body: &dnsmessage.PTRResource{PTR: s.stype},
+		},
+		{
+			Header: header(s.stype,
+				dnsmessage.TypePTR, dnsmessage.ClassINET),
+			Body: &dnsmessage.PTRResource{PTR: s.instance},
+		},
+		{
+			Header: header(s.instance, dnsmessage.TypeSRV, class),
+			Body: &dnsmessage.SRVResource{
+				Port: s.port, Target: s.host,
+			},
+		},
+		{
+			Header: header(s.instance, dnsmessage.TypeTXT, class),
+			Body:   &dnsmessage.TXTResource{TXT: s.txt},
+		},
+	}
+	for _, ip := range s.addresses(ifindex) {
+		if ip4 := ip.To4(); ip4 != nil {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip4)
+			rs = append(rs, dnsmessage.Resource{
+				Header: header(s.host, dnsmessage.TypeA, class),
+				Body:   &a,
+			})
+		} else {
+			var a dnsmessage.AAAAResource
+			copy(a.AAAA[:], ip.To16())
+			rs = append(rs, dnsmessage.Resource{
+				Header: header(s.host, dnsmessage.TypeAAAA, class),
+				Body:   &a,
+			})
+		}
+	}
+	return rs
+}
+
+func matches(q dnsmessage.Question, r dnsmessage.Resource) bool {
+	return equal(q.Name, r.Header.Name) &&
+		(q.Type == dnsmessage.TypeALL || q.Type == r.Header.Type)
+}
+
+// additional returns true if r should be included in the additional
+// section of a reply containing answers (RFC 6763 Section 12).
+func (s *service) additional(answers []dnsmessage.Resource, r dnsmessage.Resource) bool {
+	for _, a := range answers {
+		if a.Header == r.Header && a.Body.GoString() == r.Body.GoString() {
+			return false
+		}
+	}
+	for _, a := range answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if equal(body.PTR, s.instance) &&
+				!equal(r.Header.Name, servicesName) &&
+				!equal(r.Header.Name, s.stype) {
+				return true
+			}
+		case *dnsmessage.SRVResource:
+			if equal(r.Header.Name, s.host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reply returns the reply to the query contained in buf, received on
+// interface ifindex.  The boolean is true if the reply should be sent
+// by unicast to the querier.  Queriers that don't use port 5353 are
+// legacy resolvers, which expect a conventional unicast DNS reply.
+func (s *service) reply(buf []byte, ifindex int, legacy bool) ([]byte, bool, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(buf)
+	if err != nil {
+		return nil, false, err
+	}
+	if h.Response || h.OpCode != 0 {
+		return nil, false, errNotFound
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, false, err
+	}
+
+	t := uint32(ttl)
+	if legacy {
+		t = legacyTTL
+	}
+	records := s.records(ifindex, t, !legacy)
+
+	unicast := legacy
+	var answers []dnsmessage.Resource
+	for i, q := range questions {
+		if q.Class&cacheFlush != 0 {
+			// the unicast-response bit
+			unicast = true
+			questions[i].Class &^= cacheFlush
+		}
+		for _, r := range records {
+			if matches(questions[i], r) {
+				answers = append(answers, r)
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return nil, false, errNotFound
+	}
+
+	var additionals []dnsmessage.Resource
+	for _, r := range records {
+		if s.additional(answers, r) {
+			additionals = append(additionals, r)
+		}
+	}
+
+	header := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		header.ID = h.ID
+	} else {
+		questions = nil
+	}
+	reply, err := build(header, questions, answers, nil, additionals)
+	return reply, unicast, err
+}
+
+func addResource(b *dnsmessage.Builder, r dnsmessage.Resource) error {
+	switch body := r.Body.(type) {
+	case *dnsmessage.PTRResource:
+		return b.PTRResource(r.Header, *body)
+	case *dnsmessage.SRVResource:
+		return b.SRVResource(r.Header, *body)
+	case *dnsmessage.TXTResource:
+		return b.TXTResource(r.Header, *body)
+	case *dnsmessage.AResource:
+		return b.AResource(r.Header, *body)
+	case *dnsmessage.AAAAResource:
+		return b.AAAAResource(r.Header, *body)
+	default:
+		return errors.New("unexpected resource type")
+	}
+}
+
+func build(header dnsmessage.Header, questions []dnsmessage.Question, answers, authorities, additionals []dnsmessage.Resource) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), header)
+	b.EnableCompression()
+	err := b.StartQuestions()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		err = b.Question(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.StartAnswers()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range answers {
+		err = addResource(&b, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.StartAuthorities()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range authorities {
+		err = addResource(&b, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = b.StartAdditionals()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range additionals {
+		err = addResource(&b, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// announcement returns an unsolicited response containing all of our
+// records.  If ttl is 0, it is a goodbye packet, which causes queriers
+// to flush our records.
+func (s *service) announcement(ifindex int, ttl uint32) ([]byte, error) {
+	records := s.records(ifindex, ttl, true)
+	return build(
+		dnsmessage.Header{Response: true, Authoritative: true},
+		nil, records[1:], nil, nil,
+	)
+}
+
+// probe returns a query for the names that we intend to use, with the
+// records that we propose in the authority section (RFC 6762 Section 8.1).
+func (s *service) probe(ifindex int) ([]byte, error) {
+	var questions []dnsmessage.Question
+	for _, name := range []dnsmessage.Name{s.instance, s.host} {
+		questions = append(questions, dnsmessage.Question{
+			Name:  name,
+			Type:  dnsmessage.TypeALL,
+			Class: dnsmessage.ClassINET | cacheFlush,
+		})
+	}
+	records := s.records(ifindex, ttl, false)
+	return build(dnsmessage.Header{}, questions, nil, records[2:], nil)
+}
+
+// conflicts returns true if the message in buf is a response that
+// contains records for our unique names that differ from ours (RFC 6762
+// Section 9).
+func (s *service) conflicts(buf []byte) bool {
+	var msg dnsmessage.Message
+	err := msg.Unpack(buf)
+	if err != nil || !msg.Header.Response {
+		return false
+	}
+	ours := s.records(0, ttl, true)
+	same := func(r dnsmessage.Resource) bool {
+		for _, o := range ours {
+			if equal(o.Header.Name, r.Header.Name) &&
+				o.Header.Type == r.Header.Type &&
+				o.Body.GoString() == r.Body.GoString() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, r := range append(msg.Answers, msg.Additionals...) {
+		if !equal(r.Header.Name, s.instance) &&
+			!equal(r.Header.Name, s.host) {
+			continue
+		}
+		switch r.Header.Type {
+		case dnsmessage.TypeSRV, dnsmessage.TypeTXT,
+			dnsmessage.TypeA, dnsmessage.TypeAAAA:
+			if !same(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var responder struct {
+	mu      sync.Mutex
+	service *service
+	conn    *ipv4.PacketConn
+	done    chan struct{}
+}
+
+// multicastInterfaces returns the interfaces on which we advertise.
+func multicastInterfaces() []net.Interface {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		log.Printf("DNS-SD: %v", err)
+		return nil
+	}
+	var result []net.Interface
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagUp != 0 &&
+			ifi.Flags&net.FlagMulticast != 0 &&
+			ifi.Flags&net.FlagLoopback == 0 {
+			result = append(result, ifi)
+		}
+	}
+	return result
+}
+
+// announce sends an announcement on all interfaces.
+func announce(s *service, conn *ipv4.PacketConn, ttl uint32) {
+	for _, ifi := range multicastInterfaces() {
+		buf, err := s.announcement(ifi.Index, ttl)
+		if err != nil {
+			log.Printf("DNS-SD: %v", err)
+			continue
+		}
+		_, err = conn.WriteTo(
+			buf, &ipv4.ControlMessage{IfIndex: ifi.Index}, mdnsAddr,
+		)
+		if err != nil {
+			log.Printf("DNS-SD announce on %v: %v", ifi.Name, err)
+		}
+	}
+}
+
+// probeNames sends three probes 250ms apart, and returns false if another
+// host replied that it uses our names.
+func probeNames(s *service, conn *ipv4.PacketConn, done <-chan struct{}) (bool, error) {
+	buf := make([]byte, 9000)
+	for i := 0; i < 3; i++ {
+		for _, ifi := range multicastInterfaces() {
+			p, err := s.probe(ifi.Index)
+			if err != nil {
+				return false, err
+			}
+			_, err = conn.WriteTo(
+				p, &ipv4.ControlMessage{IfIndex: ifi.Index},
+				mdnsAddr,
+			)
+			if err != nil {
+				log.Printf("DNS-SD probe on %v: %v", ifi.Name, err)
+			}
+		}
+		deadline := time.Now().Add(250 * time.Millisecond)
+		conn.SetReadDeadline(deadline)
+		for time.Now().Before(deadline) {
+			n, _, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				select {
+				case <-done:
+					return false, net.ErrClosed
+				default:
+				}
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					break
+				}
+				return false, err
+			}
+			if s.conflicts(buf[:n]) {
+				return false, nil
+			}
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+	return true, nil
+}
+
+// maxRenames is the number of names that we try before giving up.
+const maxRenames = 10
+
+// run chooses unique names, then answers queries until done is closed.
+func run(s *service, conn *ipv4.PacketConn, done <-chan struct{}) {
+	for n := 1; ; n++ {
+		if n > maxRenames {
+			log.Printf("DNS-SD: couldn't find a unique name, " +
+				"not advertising")
+			return
+		}
+		err := s.rename(n)
+		if err != nil {
+			log.Printf("DNS-SD: %v", err)
+			return
+		}
+		ok, err := probeNames(s, conn, done)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("DNS-SD: %v", err)
+			}
+			return
+		}
+		if ok {
+			break
+		}
+		log.Printf("DNS-SD: %v is already in use", s.instance)
+	}
+
+	responder.mu.Lock()
+	select {
+	case <-done:
+		responder.mu.Unlock()
+		return
+	default:
+	}
+	responder.service = s
+	responder.mu.Unlock()
+
+	log.Printf("Advertising %v on the local network", s.instance)
+	go func() {
+		// RFC 6762 Section 8.3
+		for i := 0; i < 2; i++ {
+			announce(s, conn, ttl)
+			select {
+			case <-time.After(time.Second):
+			case <-done:
+				return
+			}
+		}
+	}()
+	serve(s, conn, done)
+}
+
+func serve(s *service, conn *ipv4.PacketConn, done <-chan struct{}) {
+	buf := make([]byte, 9000)
+	for {
+		n, cm, src, err := conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				log.Printf("DNS-SD: %v", err)
+			}
+			return
+		}
+		ifindex := 0
+		if cm != nil {
+			ifindex = cm.IfIndex
+		}
+		from, ok := src.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		legacy := from.Port != mdnsAddr.Port
+		reply, unicast, err := s.reply(buf[:n], ifindex, legacy)
+		if err != nil {
+			continue
+		}
+		dst := mdnsAddr
+		if unicast {
+			dst = from
+		}
+		_, err = conn.WriteTo(
+			reply, &ipv4.ControlMessage{IfIndex: ifindex}, dst,
+		)
+		if err != nil {
+			log.Printf("DNS-SD: %v", err)
+		}
+	}
+}
+
+// Start starts advertising a web server listening on the given port.
+func Start(port int, secure bool) error {
+	responder.mu.Lock()
+	defer responder.mu.Unlock()
+
+	if responder.conn != nil {
+		return errors.New("already started")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	s, err := newService(hostname, port, secure)
+	if err != nil {
+		return err
+	}
+
+	// ListenMulticastUDP sets SO_REUSEADDR, which allows us to coexist
+	// with a system mDNS responder.
+	c, err := net.ListenMulticastUDP("udp4", nil, mdnsAddr)
+	if err != nil {
+		return err
+	}
+	conn := ipv4.NewPacketConn(c)
+	for _, ifi := range multicastInterfaces() {
+		// this fails for the interface joined by ListenMulticastUDP
+		conn.JoinGroup(&ifi, mdnsAddr)
+	}
+	err = conn.SetControlMessage(ipv4.FlagInterface, true)
+	if err != nil {
+		log.Printf("DNS-SD: %v", err)
+	}
+	conn.SetMulticastTTL(255)
+
+	// the service is only set once probing has succeeded
+	responder.service = nil
+	responder.conn = conn
+	responder.done = make(chan struct{})
+
+	go run(s, conn, responder.done)
+	return nil
+}
+
+// Stop stops advertising the server.
+func Stop() {
+	responder.mu.Lock()
+	defer responder.mu.Unlock()
+
+	if responder.conn == nil {
+		return
+	}
+	close(responder.done)
+	if responder.service != nil {
+		announce(responder.service, responder.conn, 0)
+	}
+	responder.conn.Close()
+	responder.conn = nil
+	responder.service = nil
+}
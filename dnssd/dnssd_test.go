@@ -0,0 +1,291 @@
+package dnssd
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testService(t *testing.T) *service {
+	s, err := newService("classroom.example.org", 8443, true)
+	if err != nil {
+		t.Fatalf("newService: %v", err)
+	}
+	s.addresses = func(ifindex int) []net.IP {
+		return []net.IP{
+			net.ParseIP("192.168.1.2"), net.ParseIP("2001:db8::2"),
+		}
+	}
+	return s
+}
+
+func query(t *testing.T, id uint16, name string, tpe dnsmessage.Type, class dnsmessage.Class) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	b.StartQuestions()
+	err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  tpe,
+		Class: class,
+	})
+	if err != nil {
+		t.Fatalf("Question: %v", err)
+	}
+	buf, err := b.Finish()
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	return buf
+}
+
+func parse(t *testing.T, buf []byte) dnsmessage.Message {
+	var m dnsmessage.Message
+	err := m.Unpack(buf)
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	return m
+}
+
+func TestNames(t *testing.T) {
+	s := testService(t)
+	if s.host.String() != "classroom.local." {
+		t.Errorf("Bad host %v", s.host)
+	}
+	if s.stype.String() != "_https._tcp.local." {
+		t.Errorf("Bad service type %v", s.stype)
+	}
+	if s.instance.String() != "Galene on classroom._https._tcp.local." {
+		t.Errorf("Bad instance %v", s.instance)
+	}
+
+	s, err := newService("classroom", 80, false)
+	if err != nil || s.stype.String() != "_http._tcp.local." {
+		t.Errorf("Bad service type %v (%v)", s.stype, err)
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	s := testService(t)
+	reply, unicast, err := s.reply(
+		query(t, 0, "_https._tcp.local.",
+			dnsmessage.TypePTR, dnsmessage.ClassINET),
+		0, false,
+	)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if unicast {
+		t.Errorf("Unicast reply to multicast query")
+	}
+	m := parse(t, reply)
+	if !m.Header.Response || len(m.Questions) != 0 {
+		t.Errorf("Bad reply %v", m.GoString())
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("Expected 1 answer, got %v", len(m.Answers))
+	}
+	ptr, ok := m.Answers[0].Body.(*dnsmessage.PTRResource)
+	if !ok || ptr.PTR != s.instance {
+		t.Errorf("Bad answer %v", m.Answers[0].GoString())
+	}
+	if m.Answers[0].Header.Class != dnsmessage.ClassINET {
+		t.Errorf("Shared record has class %v",
+			m.Answers[0].Header.Class)
+	}
+
+	types := make(map[dnsmessage.Type]int)
+	for _, r := range m.Additionals {
+		types[r.Header.Type]++
+		if r.Header.Class != dnsmessage.ClassINET|cacheFlush {
+			t.Errorf("Bad class %v", r.Header.Class)
+		}
+		if r.Header.TTL != ttl {
+			t.Errorf("Bad TTL %v", r.Header.TTL)
+		}
+	}
+	if types[dnsmessage.TypeSRV] != 1 || types[dnsmessage.TypeTXT] != 1 ||
+		types[dnsmessage.TypeA] != 1 || types[dnsmessage.TypeAAAA] != 1 ||
+		len(m.Additionals) != 4 {
+		t.Errorf("Bad additionals %v", types)
+	}
+}
+
+func TestHost(t *testing.T) {
+	s := testService(t)
+	reply, unicast, err := s.reply(
+		query(t, 0, "Classroom.local.",
+			dnsmessage.TypeA, dnsmessage.ClassINET|cacheFlush),
+		0, false,
+	)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if !unicast {
+		t.Errorf("Unicast response not requested")
+	}
+	m := parse(t, reply)
+	if len(m.Answers) != 1 || len(m.Additionals) != 0 {
+		t.Fatalf("Bad reply %v", m.GoString())
+	}
+	a, ok := m.Answers[0].Body.(*dnsmessage.AResource)
+	if !ok || a.A != [4]byte{192, 168, 1, 2} {
+		t.Errorf("Bad answer %v", m.Answers[0].GoString())
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	s := testService(t)
+	reply, unicast, err := s.reply(
+		query(t, 42, "Galene on classroom._https._tcp.local.",
+			dnsmessage.TypeSRV, dnsmessage.ClassINET),
+		0, true,
+	)
+	if err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	if !unicast {
+		t.Errorf("Multicast reply to legacy query")
+	}
+	m := parse(t, reply)
+	if m.Header.ID != 42 || len(m.Questions) != 1 {
+		t.Errorf("Bad legacy reply %v", m.GoString())
+	}
+	if len(m.Answers) != 1 {
+		t.Fatalf("Expected 1 answer, got %v", len(m.Answers))
+	}
+	srv, ok := m.Answers[0].Body.(*dnsmessage.SRVResource)
+	if !ok || srv.Port != 8443 || srv.Target != s.host {
+		t.Errorf("Bad answer %v", m.Answers[0].GoString())
+	}
+	if m.Answers[0].Header.TTL != legacyTTL ||
+		m.Answers[0].Header.Class != dnsmessage.ClassINET {
+		t.Errorf("Bad legacy header %v", m.Answers[0].Header)
+	}
+	if len(m.Additionals) != 2 {
+		t.Errorf("Expected 2 addresses, got %v", len(m.Additionals))
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	s := testService(t)
+	_, _, err := s.reply(
+		query(t, 0, "other.local.",
+			dnsmessage.TypeA, dnsmessage.ClassINET),
+		0, false,
+	)
+	if err != errNotFound {
+		t.Errorf("Expected errNotFound, got %v", err)
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	s := testService(t)
+	buf, err := s.announcement(0, 0)
+	if err != nil {
+		t.Fatalf("announcement: %v", err)
+	}
+	m := parse(t, buf)
+	if len(m.Answers) != 5 {
+		t.Errorf("Expected 5 records, got %v", len(m.Answers))
+	}
+	for _, r := range m.Answers {
+		if r.Header.TTL != 0 {
+			t.Errorf("Goodbye with TTL %v", r.Header.TTL)
+		}
+	}
+}
+
+func TestAdvertised(t *testing.T) {
+	tests := []struct {
+		ip         string
+		advertised bool
+	}{
+		{"192.168.1.2", true},
+		{"169.254.12.34", true},
+		{"2001:db8::2", true},
+		{"fe80::1", false},
+		{"127.0.0.1", false},
+		{"224.0.0.251", false},
+	}
+	for _, tt := range tests {
+		if advertised(net.ParseIP(tt.ip)) != tt.advertised {
+			t.Errorf("%v: expected %v", tt.ip, tt.advertised)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := testService(t)
+	err := s.rename(2)
+	if err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if s.instance.String() != "Galene on classroom (2)._https._tcp.local." {
+		t.Errorf("Bad instance %v", s.instance)
+	}
+	if s.host.String() != "classroom-2.local." {
+		t.Errorf("Bad host %v", s.host)
+	}
+	s.rename(1)
+	if s.host.String() != "classroom.local." {
+		t.Errorf("Bad host %v", s.host)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	s := testService(t)
+	buf, err := s.probe(0)
+	if err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	m := parse(t, buf)
+	if m.Header.Response || len(m.Questions) != 2 {
+		t.Fatalf("Bad probe %v", m)
+	}
+	for _, q := range m.Questions {
+		if q.Type != dnsmessage.TypeALL || q.Class&cacheFlush == 0 {
+			t.Errorf("Bad question %v", q)
+		}
+	}
+	if len(m.Authorities) != 4 {
+		t.Errorf("Expected 4 authorities, got %v", len(m.Authorities))
+	}
+
+	// our own probe is not a conflict
+	if s.conflicts(buf) {
+		t.Errorf("Probe conflicts")
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	s := testService(t)
+	buf, err := s.announcement(0, ttl)
+	if err != nil {
+		t.Fatalf("announcement: %v", err)
+	}
+	if s.conflicts(buf) {
+		t.Errorf("Our own announcement conflicts")
+	}
+
+	other := testService(t)
+	other.addresses = func(ifindex int) []net.IP {
+		return []net.IP{net.ParseIP("192.168.1.3")}
+	}
+	buf, err = other.announcement(0, ttl)
+	if err != nil {
+		t.Fatalf("announcement: %v", err)
+	}
+	if !s.conflicts(buf) {
+		t.Errorf("No conflict with a different address")
+	}
+
+	other.rename(2)
+	buf, err = other.announcement(0, ttl)
+	if err != nil {
+		t.Fatalf("announcement: %v", err)
+	}
+	if s.conflicts(buf) {
+		t.Errorf("Conflict with a different name")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build boringcrypto
+
+package fips
+
+import (
+	_ "crypto/tls/fipsonly"
+)
+
+// Boring is true if Galene was built with a FIPS-validated cryptographic
+// module.
+const Boring = true
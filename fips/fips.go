@@ -0,0 +1,54 @@
+// Package fips restricts the cryptographic algorithms used by Galene to
+// the ones approved by FIPS 140, as required by some deployments.
+package fips
+
+import (
+	"crypto/tls"
+
+	"github.com/pion/dtls/v3"
+	"github.com/pion/dtls/v3/pkg/crypto/elliptic"
+	"github.com/pion/webrtc/v4"
+)
+
+// Enabled indicates that only approved algorithms should be used.  It is
+// true by default when Galene is built with GOEXPERIMENT=boringcrypto.
+var Enabled = Boring
+
+var approvedCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var approvedCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// ConfigureTLS restricts a TLS configuration to approved algorithms if
+// FIPS mode is enabled.  Unless Galene is built with boringcrypto, this
+// cannot restrict the cipher suites of TLS 1.3.
+func ConfigureTLS(conf *tls.Config) {
+	if !Enabled {
+		return
+	}
+	if conf.MinVersion < tls.VersionTLS12 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	conf.CipherSuites = approvedCipherSuites
+	conf.CurvePreferences = approvedCurves
+}
+
+// ConfigureDTLS restricts the DTLS and SRTP algorithms of a setting
+// engine to approved algorithms if FIPS mode is enabled.  All the cipher
+// suites offered by default are approved, but the default curves and
+// SRTP profiles are not.
+func ConfigureDTLS(s *webrtc.SettingEngine) {
+	if !Enabled {
+		return
+	}
+	s.SetDTLSEllipticCurves(elliptic.P256, elliptic.P384)
+	s.SetSRTPProtectionProfiles(
+		dtls.SRTP_AEAD_AES_128_GCM,
+		dtls.SRTP_AEAD_AES_256_GCM,
+		dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+	)
+}
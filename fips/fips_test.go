@@ -0,0 +1,36 @@
+package fips
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	Enabled = false
+	conf := &tls.Config{}
+	ConfigureTLS(conf)
+	if conf.MinVersion != 0 || conf.CipherSuites != nil {
+		t.Errorf("Configuration changed when disabled")
+	}
+
+	Enabled = true
+	defer func() {
+		Enabled = Boring
+	}()
+	ConfigureTLS(conf)
+	if conf.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2, got %v", conf.MinVersion)
+	}
+	for _, id := range conf.CipherSuites {
+		for _, s := range tls.InsecureCipherSuites() {
+			if s.ID == id {
+				t.Errorf("Insecure cipher suite %v", s.Name)
+			}
+		}
+	}
+	for _, c := range conf.CurvePreferences {
+		if c == tls.X25519 {
+			t.Errorf("X25519 allowed")
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+
+package fips
+
+// Boring is true if Galene was built with a FIPS-validated cryptographic
+// module.
+const Boring = false
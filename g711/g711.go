@@ -0,0 +1,99 @@
+// Package g711 implements the G.711 audio codecs, µ-law (PCMU) and
+// A-law (PCMA), which convert between 16-bit linear samples and 8-bit
+// logarithmic samples.
+package g711
+
+const (
+	ulawBias = 0x84
+	ulawClip = 8159
+)
+
+var ulawSegments = [8]int{
+	0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF,
+}
+
+var alawSegments = [8]int{
+	0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF,
+}
+
+func segment(v int, segments *[8]int) int {
+	for i, s := range segments {
+		if v <= s {
+			return i
+		}
+	}
+	return len(segments)
+}
+
+// EncodeUlaw converts a linear sample to µ-law.
+func EncodeUlaw(sample int16) byte {
+	v := int(sample) >> 2
+	mask := byte(0xFF)
+	if v < 0 {
+		v = -v
+		mask = 0x7F
+	}
+	if v > ulawClip {
+		v = ulawClip
+	}
+	v += ulawBias >> 2
+
+	seg := segment(v, &ulawSegments)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	return (byte(seg<<4) | byte((v>>(seg+1))&0xF)) ^ mask
+}
+
+// DecodeUlaw converts a µ-law sample to linear.
+func DecodeUlaw(u byte) int16 {
+	u = ^u
+	t := (int(u&0x0F) << 3) + ulawBias
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(ulawBias - t)
+	}
+	return int16(t - ulawBias)
+}
+
+// EncodeAlaw converts a linear sample to A-law.
+func EncodeAlaw(sample int16) byte {
+	v := int(sample) >> 3
+	mask := byte(0xD5)
+	if v < 0 {
+		v = -v - 1
+		mask = 0x55
+	}
+
+	seg := segment(v, &alawSegments)
+	if seg >= 8 {
+		return 0x7F ^ mask
+	}
+	a := byte(seg << 4)
+	if seg < 2 {
+		a |= byte((v >> 1) & 0xF)
+	} else {
+		a |= byte((v >> seg) & 0xF)
+	}
+	return a ^ mask
+}
+
+// DecodeAlaw converts an A-law sample to linear.
+func DecodeAlaw(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	seg := (a & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
@@ -0,0 +1,55 @@
+package g711
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUlaw(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		u := byte(i)
+		if u == 0x7F {
+			// negative zero
+			continue
+		}
+		if v := EncodeUlaw(DecodeUlaw(u)); v != u {
+			t.Errorf("%#x: got %#x (%v)", u, v, DecodeUlaw(u))
+		}
+	}
+
+	if DecodeUlaw(EncodeUlaw(0)) != 0 {
+		t.Errorf("Zero is not preserved")
+	}
+	if DecodeUlaw(EncodeUlaw(math.MaxInt16)) != 32124 ||
+		DecodeUlaw(EncodeUlaw(math.MinInt16)) != -32124 {
+		t.Errorf("Clipping failed")
+	}
+}
+
+func TestAlaw(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		a := byte(i)
+		if v := EncodeAlaw(DecodeAlaw(a)); v != a {
+			t.Errorf("%#x: got %#x (%v)", a, v, DecodeAlaw(a))
+		}
+	}
+
+	if DecodeAlaw(EncodeAlaw(math.MaxInt16)) != 32256 ||
+		DecodeAlaw(EncodeAlaw(math.MinInt16)) != -32256 {
+		t.Errorf("Clipping failed")
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	lu, la := DecodeUlaw(EncodeUlaw(math.MinInt16)),
+		DecodeAlaw(EncodeAlaw(math.MinInt16))
+	for s := math.MinInt16; s <= math.MaxInt16; s += 7 {
+		u := DecodeUlaw(EncodeUlaw(int16(s)))
+		a := DecodeAlaw(EncodeAlaw(int16(s)))
+		if u < lu || a < la {
+			t.Fatalf("%v: not monotonic (%v %v, %v %v)",
+				s, lu, u, la, a)
+		}
+		lu, la = u, a
+	}
+}
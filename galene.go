@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -14,20 +15,29 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jech/galene/cascade"
 	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/dnssd"
+	"github.com/jech/galene/fips"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
 	"github.com/jech/galene/limit"
+	"github.com/jech/galene/rtsp"
+	"github.com/jech/galene/sip"
+	"github.com/jech/galene/stats"
 	"github.com/jech/galene/token"
 	"github.com/jech/galene/turnserver"
 	"github.com/jech/galene/webserver"
 )
 
 func main() {
-	var cpuprofile, memprofile, mutexprofile, httpAddr string
+	var cpuprofile, memprofile, mutexprofile, httpAddr, adminAddr string
 	var udpRange string
+	var standby, lan bool
 
 	flag.StringVar(&httpAddr, "http", ":8443", "web server `address`")
+	flag.StringVar(&adminAddr, "admin-http", "",
+		"administrative API `address`, by default served by the web server")
 	flag.StringVar(&webserver.StaticRoot, "static", "./static/",
 		"web server root `directory`")
 	flag.BoolVar(&webserver.Insecure, "insecure", false,
@@ -47,14 +57,33 @@ func main() {
 	flag.StringVar(&udpRange, "udp-range", "",
 		"UDP `port` (multiplexing) or port1-port2 (range)")
 	flag.BoolVar(&group.UseMDNS, "mdns", false, "gather mDNS addresses")
+	flag.BoolVar(&lan, "lan", false,
+		"advertise the server on the local network, "+
+			"ignore external ICE servers")
+	flag.BoolVar(&standby, "standby", false,
+		"accept replicated state from a primary server")
 	flag.BoolVar(&ice.ICERelayOnly, "relay-only", false,
 		"require use of TURN relays for all media traffic")
 	flag.StringVar(&turnserver.Address, "turn", "auto",
 		"built-in TURN server `address` (\"\" to disable)")
 	flag.StringVar(&turnserver.Realm, "realm", "galene.org",
 		"built-in TURN realm hostname")
+	flag.StringVar(&sip.Address, "sip", "",
+		"SIP gateway `address` (\"\" to disable)")
+	flag.BoolVar(&fips.Enabled, "fips", fips.Enabled,
+		"only use FIPS-approved cryptographic algorithms")
 	flag.Parse()
 
+	if fips.Enabled && !fips.Boring {
+		log.Printf("FIPS mode enabled, " +
+			"but not built with a validated cryptographic module")
+	}
+
+	group.SetStandby(standby)
+
+	group.LANMode = lan
+	ice.LANMode = lan
+
 	if udpRange != "" {
 		if strings.ContainsRune(udpRange, '-') {
 			var min, max uint16
@@ -125,6 +154,7 @@ func main() {
 	}
 
 	ice.ICEFilename = filepath.Join(group.DataDirectory, "ice-servers.json")
+	stats.GeoIPFilename = filepath.Join(group.DataDirectory, "geoip.csv")
 	token.SetStatefulFilename(
 		filepath.Join(
 			filepath.Join(group.DataDirectory, "var"),
@@ -132,9 +162,25 @@ func main() {
 		),
 	)
 
+	group.ActivityFilename = filepath.Join(
+		filepath.Join(group.DataDirectory, "var"), "activity.json",
+	)
+	group.HistoryDirectory = filepath.Join(
+		filepath.Join(group.DataDirectory, "var"), "chat",
+	)
+	group.DropBoxDirectory = filepath.Join(
+		filepath.Join(group.DataDirectory, "var"), "dropbox",
+	)
+	group.ClearDropBoxes()
+
 	// make sure the list of public groups is updated early
 	go group.Update()
 
+	err = group.Watch()
+	if err != nil {
+		log.Printf("Couldn't watch configuration files: %v", err)
+	}
+
 	// causes the built-in server to start if required
 	ice.Update()
 	defer turnserver.Stop()
@@ -144,10 +190,38 @@ func main() {
 		log.Fatalf("Server: %v", err)
 	}
 
+	if adminAddr != "" {
+		err = webserver.ServeAdmin(adminAddr, group.DataDirectory)
+		if err != nil {
+			log.Fatalf("Admin server: %v", err)
+		}
+	}
+
+	err = sip.Start()
+	if err != nil {
+		log.Fatalf("SIP gateway: %v", err)
+	}
+	defer sip.Stop()
+
+	go rtsp.Update()
+	defer rtsp.Stop()
+
+	go cascade.Update()
+	defer cascade.Stop()
+
+	if lan {
+		err = advertise(httpAddr)
+		if err != nil {
+			log.Printf("Couldn't advertise server: %v", err)
+		}
+		defer dnssd.Stop()
+	}
+
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
 
 	go relayTest()
+	go diskwriter.PruneLoop()
 
 	ticker := time.NewTicker(15 * time.Minute)
 	defer ticker.Stop()
@@ -155,15 +229,30 @@ func main() {
 	slowTicker := time.NewTicker(12 * time.Hour)
 	defer slowTicker.Stop()
 
+	tokenTicker := time.NewTicker(time.Hour)
+	defer tokenTicker.Stop()
+	go expireTokens()
+
+	scheduleTicker := time.NewTicker(time.Minute)
+	defer scheduleTicker.Stop()
+
+	replicationTicker := time.NewTicker(10 * time.Second)
+	defer replicationTicker.Stop()
+
 	for {
 		select {
 		case <-ticker.C:
-			go func() {
-				group.Update()
-				token.Expire()
-			}()
+			go group.Update()
 		case <-slowTicker.C:
 			go relayTest()
+		case <-tokenTicker.C:
+			go expireTokens()
+		case <-scheduleTicker.C:
+			go group.CheckSchedules()
+			go rtsp.Update()
+			go cascade.Update()
+		case <-replicationTicker.C:
+			go group.Replicate()
 		case <-terminate:
 			webserver.Shutdown()
 			return
@@ -171,6 +260,35 @@ func main() {
 	}
 }
 
+func expireTokens() {
+	// this sets the retention period used by token.Expire
+	_, err := group.GetConfiguration()
+	if err != nil {
+		log.Printf("Expire tokens: %v", err)
+		return
+	}
+	n, err := token.Expire()
+	if err != nil {
+		log.Printf("Expire tokens: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("Removed %v expired tokens", n)
+	}
+}
+
+func advertise(addr string) error {
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := net.LookupPort("tcp", p)
+	if err != nil {
+		return err
+	}
+	return dnssd.Start(port, !webserver.Insecure)
+}
+
 func relayTest() {
 	now := time.Now()
 	d, err := ice.RelayTest(20 * time.Second)
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type apiToken struct {
+	Id          string     `json:"id"`
+	Description string     `json:"description,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	LastUsed    *time.Time `json:"lastUsed,omitempty"`
+}
+
+type apiTokenRequest struct {
+	Description string     `json:"description,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+type apiTokenReply struct {
+	Token string `json:"token"`
+	apiToken
+}
+
+// scopesOption is a command-line option that may be repeated, and whose
+// values may be separated with commas.
+type scopesOption []string
+
+func (o *scopesOption) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			*o = append(*o, s)
+		}
+	}
+	return nil
+}
+
+func (o *scopesOption) String() string {
+	if o == nil {
+		return "(nil)"
+	}
+	return strings.Join(*o, ",")
+}
+
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+func apiTokensURL(id string) string {
+	elems := []string{"/galene-api/v0/.api-tokens/"}
+	if id != "" {
+		elems = append(elems, id)
+	}
+	u, err := url.JoinPath(serverURL, elems...)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+	return u
+}
+
+func createAPITokenCmd(cmdname string, args []string) {
+	var scopes scopesOption
+	var description string
+	var expires timeOption
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Var(&scopes, "scope",
+		"token `scope`, may be repeated or separated by commas")
+	cmd.StringVar(&description, "description", "",
+		"token `description`")
+	cmd.Var(&expires, "expires", "expiration `time` or duration")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if len(scopes) == 0 {
+		fmt.Fprintf(cmd.Output(), "Option \"-scope\" is required\n")
+		exit(1)
+	}
+
+	req := apiTokenRequest{
+		Description: description,
+		Scopes:      scopes,
+	}
+	if expires.set {
+		req.Expires = &expires.value
+	}
+
+	var reply apiTokenReply
+	err := queryJSON(apiTokensURL(""), req, &reply)
+	if err != nil {
+		fatalf("Create API token: %v", err)
+	}
+	fmt.Println(reply.Token)
+}
+
+func listAPITokensCmd(cmdname string, args []string) {
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	var tokens []apiToken
+	_, err := getJSON(apiTokensURL(""), &tokens)
+	if err != nil {
+		fatalf("Get API tokens: %v", err)
+	}
+	for _, t := range tokens {
+		fmt.Printf("%-11s %-19s %-19s %s\n",
+			t.Id, formatTimePtr(t.Expires), formatTimePtr(t.LastUsed),
+			strings.Join(t.Scopes, ","),
+		)
+	}
+}
+
+func deleteAPITokenCmd(cmdname string, args []string) {
+	var id string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&id, "id", "", "token `id`")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if id == "" {
+		fmt.Fprintf(cmd.Output(), "Option \"-id\" is required\n")
+		exit(1)
+	}
+
+	err := deleteValue(apiTokensURL(id))
+	if err != nil {
+		fatalf("Delete API token: %v", err)
+	}
+}
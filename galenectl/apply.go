@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+// An action computed by the apply command.
+type applyAction struct {
+	description string
+	prune       bool
+	do          func() error
+}
+
+// An applier computes the actions needed to bring the server in sync
+// with a local directory.
+type applier struct {
+	secrets bool
+	actions []applyAction
+}
+
+func (a *applier) add(prune bool, do func() error, format string, args ...any) {
+	a.actions = append(a.actions, applyAction{
+		description: fmt.Sprintf(format, args...),
+		prune:       prune,
+		do:          do,
+	})
+}
+
+func isNotFound(err error) bool {
+	var herr httpError
+	return errors.As(err, &herr) && herr.statusCode == http.StatusNotFound
+}
+
+// putValue performs an unconditional PUT request.
+func putValue(url, ctype string, value any) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	setAuthorization(req)
+	req.Header.Set("Content-Type", ctype)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return httpError{resp.StatusCode, resp.Status}
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// replaceJSON replaces a value on the server, using the entity tag
+// returned by the server in order to avoid overwriting a concurrent
+// modification.
+func replaceJSON(url string, value map[string]any) error {
+	return updateJSON(url, func(map[string]any) map[string]any {
+		return value
+	})
+}
+
+// toMap converts a value to its JSON representation as a dictionary.
+func toMap(value any) (map[string]any, error) {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	err = json.Unmarshal(j, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readGroupFiles reads the group definitions under dir.  The layout of
+// dir is the same as that of Galene's groups directory.
+func readGroupFiles(dir string) (map[string]*group.Description, error) {
+	descs := make(map[string]*group.Description)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(strings.TrimSuffix(rel, ".json"))
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		decoder := json.NewDecoder(f)
+		decoder.DisallowUnknownFields()
+		var desc group.Description
+		err = decoder.Decode(&desc)
+		if err != nil {
+			return fmt.Errorf("%v: %w", p, err)
+		}
+		descs[name] = &desc
+		return nil
+	})
+	return descs, err
+}
+
+// readTokenFile reads a file of stateful tokens in JSONL format.
+func readTokenFile(filename string) ([]*token.Stateful, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := make([]*token.Stateful, 0)
+	decoder := json.NewDecoder(f)
+	for {
+		var t token.Stateful
+		err := decoder.Decode(&t)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %w", filename, err)
+		}
+		if t.Token == "" {
+			return nil, fmt.Errorf("%v: token without a name",
+				filename)
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+func (a *applier) planUser(groupname, username string, wildcard bool, user *group.UserDescription, isNew bool) error {
+	u, err := userURL(wildcard, groupname, username)
+	if err != nil {
+		return err
+	}
+	pu, err := url.JoinPath(u, ".password")
+	if err != nil {
+		return err
+	}
+	var name string
+	if wildcard {
+		name = "wildcard user of " + groupname
+	} else {
+		name = "user " + groupname + "/" + username
+	}
+
+	local, err := toMap(group.UserDescription{
+		Permissions: user.Permissions,
+	})
+	if err != nil {
+		return err
+	}
+
+	created := isNew
+	if !isNew {
+		var remote map[string]any
+		_, err := getJSON(u, &remote)
+		if isNotFound(err) {
+			created = true
+		} else if err != nil {
+			return err
+		} else if len(diffJSON("", remote, local)) > 0 {
+			a.add(false, func() error {
+				return replaceJSON(u, local)
+			}, "update %v", name)
+		}
+	}
+	if created {
+		a.add(false, func() error {
+			return putJSON(u, local, false)
+		}, "create %v", name)
+	}
+	if user.Password.Type != "" && (created || a.secrets) {
+		pw := user.Password
+		a.add(false, func() error {
+			return putJSON(pu, pw, true)
+		}, "set password of %v", name)
+	}
+	return nil
+}
+
+func (a *applier) planGroup(name string, desc *group.Description) error {
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups", name)
+	if err != nil {
+		return err
+	}
+
+	// the server only deals with sanitised descriptions
+	d := *desc
+	d.Users = nil
+	d.WildcardUser = nil
+	d.AuthKeys = nil
+	local, err := toMap(&d)
+	if err != nil {
+		return err
+	}
+
+	isNew := false
+	var remote map[string]any
+	_, err = getJSON(u, &remote)
+	if isNotFound(err) {
+		isNew = true
+		a.add(false, func() error {
+			return putJSON(u, local, false)
+		}, "create group %v", name)
+	} else if err != nil {
+		return err
+	} else if len(diffJSON("", remote, local)) > 0 {
+		a.add(false, func() error {
+			return replaceJSON(u, local)
+		}, "update group %v", name)
+	}
+
+	if desc.AuthKeys != nil && (isNew || a.secrets) {
+		ku, err := url.JoinPath(u, ".keys")
+		if err != nil {
+			return err
+		}
+		keys := map[string]any{"keys": desc.AuthKeys}
+		a.add(false, func() error {
+			return putValue(ku, "application/jwk-set+json", keys)
+		}, "set keys of group %v", name)
+	}
+
+	var remoteUsers []string
+	if !isNew {
+		uu, err := url.JoinPath(u, ".users/")
+		if err != nil {
+			return err
+		}
+		_, err = getJSON(uu, &remoteUsers)
+		if err != nil {
+			return err
+		}
+	}
+
+	users := make([]string, 0, len(desc.Users))
+	for username := range desc.Users {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	for _, username := range users {
+		user := desc.Users[username]
+		err := a.planUser(name, username, false, &user,
+			isNew || !member(username, remoteUsers),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, username := range remoteUsers {
+		if _, ok := desc.Users[username]; ok {
+			continue
+		}
+		uu, err := userURL(false, name, username)
+		if err != nil {
+			return err
+		}
+		a.add(true, func() error {
+			return deleteValue(uu)
+		}, "delete user %v/%v", name, username)
+	}
+
+	if desc.WildcardUser != nil {
+		err := a.planUser(name, "", true, desc.WildcardUser, isNew)
+		if err != nil {
+			return err
+		}
+	} else if !isNew {
+		wu, err := userURL(true, name, "")
+		if err != nil {
+			return err
+		}
+		var remote map[string]any
+		_, err = getJSON(wu, &remote)
+		if err == nil {
+			a.add(true, func() error {
+				return deleteValue(wu)
+			}, "delete wildcard user of %v", name)
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *applier) planTokens(groups []string, tokens []*token.Stateful) error {
+	local := make(map[string]*token.Stateful, len(tokens))
+	for _, t := range tokens {
+		local[t.Token] = t
+		if !member(t.Group, groups) {
+			groups = append(groups, t.Group)
+		}
+	}
+
+	for _, g := range groups {
+		u, err := url.JoinPath(
+			serverURL, "/galene-api/v0/.groups", g, ".tokens/",
+		)
+		if err != nil {
+			return err
+		}
+		var remote []string
+		_, err = getJSON(u, &remote)
+		if isNotFound(err) {
+			// the group doesn't exist yet
+			remote = nil
+		} else if err != nil {
+			return err
+		}
+
+		for _, t := range tokens {
+			if t.Group != g {
+				continue
+			}
+			tu, err := url.JoinPath(u, t.Token)
+			if err != nil {
+				return err
+			}
+			tt := t.Clone()
+			tt.Token = ""
+			tt.Group = ""
+			value, err := toMap(tt)
+			if err != nil {
+				return err
+			}
+			if !member(t.Token, remote) {
+				a.add(false, func() error {
+					return putJSON(tu, value, false)
+				}, "create token %v", t.Token)
+				continue
+			}
+			var old map[string]any
+			_, err = getJSON(tu, &old)
+			if err != nil {
+				return err
+			}
+			if len(diffJSON("", old, value)) > 0 {
+				a.add(false, func() error {
+					return replaceJSON(tu, value)
+				}, "update token %v", t.Token)
+			}
+		}
+
+		for _, name := range remote {
+			if _, ok := local[name]; ok {
+				continue
+			}
+			tu, err := url.JoinPath(u, name)
+			if err != nil {
+				return err
+			}
+			a.add(true, func() error {
+				return deleteValue(tu)
+			}, "delete token %v", name)
+		}
+	}
+	return nil
+}
+
+func member(v string, l []string) bool {
+	for _, w := range l {
+		if v == w {
+			return true
+		}
+	}
+	return false
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%v [y/N] ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.TrimSpace(answer)
+	return strings.EqualFold(answer, "y") ||
+		strings.EqualFold(answer, "yes")
+}
+
+func applyCmd(cmdname string, args []string) {
+	var dir string
+	var prune, dryRun, yes, secrets bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&dir, "dir", "", "`directory` containing definitions")
+	cmd.BoolVar(&prune, "prune", false,
+		"delete entries that are not defined locally",
+	)
+	cmd.BoolVar(&dryRun, "n", false,
+		"only display the changes that would be made",
+	)
+	cmd.BoolVar(&yes, "yes", false, "don't ask for confirmation")
+	cmd.BoolVar(&secrets, "secrets", false,
+		"update passwords and keys of existing entries",
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if dir == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-dir\" is required\n")
+		exit(1)
+	}
+
+	descs, err := readGroupFiles(dir)
+	if err != nil {
+		fatalf("Read groups: %v", err)
+	}
+
+	tokens, err := readTokenFile(filepath.Join(dir, "tokens.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		tokens = nil
+	} else if err != nil {
+		fatalf("Read tokens: %v", err)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+	var remoteGroups []string
+	_, err = getJSON(u, &remoteGroups)
+	if err != nil {
+		fatalf("Get groups: %v", err)
+	}
+
+	names := make([]string, 0, len(descs))
+	for name := range descs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	a := applier{secrets: secrets}
+	for _, name := range names {
+		err := a.planGroup(name, descs[name])
+		if err != nil {
+			fatalf("Group %v: %v", name, err)
+		}
+	}
+
+	// tokens are only managed if a token file is present, otherwise
+	// we'd prune tokens created by users
+	if tokens != nil {
+		err := a.planTokens(append([]string(nil), names...), tokens)
+		if err != nil {
+			fatalf("Tokens: %v", err)
+		}
+	}
+
+	sort.Strings(remoteGroups)
+	for _, name := range remoteGroups {
+		if _, ok := descs[name]; ok {
+			continue
+		}
+		gu, err := url.JoinPath(serverURL, "/galene-api/v0/.groups", name)
+		if err != nil {
+			fatalf("Build URL: %v", err)
+		}
+		a.add(true, func() error {
+			return deleteValue(gu)
+		}, "delete group %v", name)
+	}
+
+	// deletions are performed last
+	sort.SliceStable(a.actions, func(i, j int) bool {
+		return !a.actions[i].prune && a.actions[j].prune
+	})
+
+	var actions []applyAction
+	pruned := 0
+	for _, action := range a.actions {
+		if action.prune && !prune {
+			continue
+		}
+		if action.prune {
+			pruned++
+		}
+		actions = append(actions, action)
+		fmt.Println(action.description)
+	}
+
+	if dryRun || len(actions) == 0 {
+		return
+	}
+
+	if pruned > 0 && !yes {
+		if !confirm(fmt.Sprintf("Delete %v entries?", pruned)) {
+			fatalf("Aborted")
+		}
+	}
+
+	for _, action := range actions {
+		err := action.do()
+		if err != nil {
+			fatalf("%v: %v", action.description, err)
+		}
+	}
+}
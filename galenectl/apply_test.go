@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadGroupFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(p), 0700)
+		if err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		err = os.WriteFile(p, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	write("a.json", `{"public": true}`)
+	write("b/c.json", `{"users": {"vimes": {"permissions": "op"}}}`)
+	write(".hidden/d.json", `{}`)
+	write("README", `not a group`)
+	write("tokens.jsonl",
+		`{"token": "abc", "group": "a", "permissions": []}
+{"token": "def", "group": "b/c", "permissions": ["present"]}
+`)
+
+	descs, err := readGroupFiles(dir)
+	if err != nil {
+		t.Fatalf("readGroupFiles: %v", err)
+	}
+	if len(descs) != 2 || descs["a"] == nil || descs["b/c"] == nil {
+		t.Fatalf("Unexpected groups %v", descs)
+	}
+	if !descs["a"].Public {
+		t.Errorf("Group a is not public")
+	}
+	if len(descs["b/c"].Users) != 1 {
+		t.Errorf("Unexpected users %v", descs["b/c"].Users)
+	}
+
+	tokens, err := readTokenFile(filepath.Join(dir, "tokens.jsonl"))
+	if err != nil {
+		t.Fatalf("readTokenFile: %v", err)
+	}
+	if len(tokens) != 2 || tokens[1].Group != "b/c" {
+		t.Errorf("Unexpected tokens %v", tokens)
+	}
+
+	write("bad.json", `{"no-such-field": true}`)
+	_, err = readGroupFiles(dir)
+	if err == nil {
+		t.Errorf("readGroupFiles succeeded with unknown field")
+	}
+}
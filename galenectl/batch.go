@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// In batch mode, commands are run within a single process, which allows
+// them to share the HTTP client and its connections.  Since a failing
+// command must not terminate the process, commands call fatalf and exit
+// rather than log.Fatalf and os.Exit, which panic in batch mode; the
+// panic is recovered by runBatchCommand.
+
+var batchMode bool
+
+func init() {
+	// registered here in order to avoid an initialisation loop
+	commands["batch"] = command{
+		command:     batchCmd,
+		description: "run a batch of commands",
+	}
+}
+
+// batchExit is the value of the panic caused by exit in batch mode.
+type batchExit int
+
+// batchError is the value of the panic caused by fatalf in batch mode.
+type batchError string
+
+func (e batchError) Error() string {
+	return string(e)
+}
+
+// fatalf logs a message and terminates the current command.
+func fatalf(format string, args ...any) {
+	if batchMode {
+		panic(batchError(fmt.Sprintf(format, args...)))
+	}
+	log.Fatalf(format, args...)
+}
+
+// exit terminates the current command with the given status.
+func exit(code int) {
+	if batchMode {
+		panic(batchExit(code))
+	}
+	os.Exit(code)
+}
+
+// newFlagSet returns a flag set for the given command.  In batch mode,
+// errors cause a panic rather than terminating the process.
+func newFlagSet(cmdname string) *flag.FlagSet {
+	if batchMode {
+		return flag.NewFlagSet(cmdname, flag.PanicOnError)
+	}
+	return flag.NewFlagSet(cmdname, flag.ExitOnError)
+}
+
+// splitCommand splits a command line into words.  Words are separated by
+// whitespace, and may be quoted with single or double quotes; a backslash
+// quotes the following character except within single quotes.
+func splitCommand(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				escaped = true
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escaped = true
+			inWord = true
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '#' && !inWord:
+			return words, nil
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parseBatch parses a batch of commands, which is either a JSON array of
+// commands, each of which is an array of words, or a sequence of lines,
+// each of which contains a single command.
+func parseBatch(data []byte) ([][]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var cmds [][]string
+		err := json.Unmarshal(trimmed, &cmds)
+		if err != nil {
+			return nil, err
+		}
+		for i, c := range cmds {
+			if len(c) == 0 {
+				return nil, fmt.Errorf("command %v is empty", i+1)
+			}
+		}
+		return cmds, nil
+	}
+
+	var cmds [][]string
+	for i, line := range strings.Split(string(data), "\n") {
+		words, err := splitCommand(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %w", i+1, err)
+		}
+		if len(words) > 0 {
+			cmds = append(cmds, words)
+		}
+	}
+	return cmds, nil
+}
+
+// runBatchCommand runs a single command in batch mode, and returns an
+// error if it failed.
+func runBatchCommand(args []string) (err error) {
+	cmdname := args[0]
+	command, ok := commands[cmdname]
+	if !ok || cmdname == "batch" {
+		return errors.New("unknown command " + cmdname)
+	}
+
+	defer func() {
+		r := recover()
+		switch r := r.(type) {
+		case nil:
+		case batchExit:
+			if r != 0 {
+				err = fmt.Errorf("exit status %v", int(r))
+			}
+		case batchError:
+			err = r
+		case runtime.Error:
+			// a bug, don't hide it
+			panic(r)
+		case error:
+			// flag parsing errors
+			err = r
+		default:
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	command.command(cmdname, args[1:])
+	return nil
+}
+
+func batchCmd(cmdname string, args []string) {
+	var stop bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...] [file]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.BoolVar(&stop, "e", false, "stop at the first failed command")
+	cmd.Parse(args)
+
+	if cmd.NArg() > 1 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	var data []byte
+	var err error
+	if cmd.NArg() == 0 || cmd.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(cmd.Arg(0))
+	}
+	if err != nil {
+		fatalf("Read commands: %v", err)
+	}
+
+	cmds, err := parseBatch(data)
+	if err != nil {
+		fatalf("Parse commands: %v", err)
+	}
+
+	// commands must not read the batch from standard input
+	os.Stdin.Close()
+
+	batchMode = true
+	failed := 0
+	for i, c := range cmds {
+		err := runBatchCommand(c)
+		if err != nil {
+			failed++
+			log.Printf("%v (%v): failed: %v", i+1, c[0], err)
+			if stop {
+				break
+			}
+		} else {
+			log.Printf("%v (%v): ok", i+1, c[0])
+		}
+	}
+	batchMode = false
+
+	if failed > 0 {
+		log.Fatalf("%v of %v commands failed", failed, len(cmds))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []string
+	}{
+		{"", nil},
+		{"   # comment", nil},
+		{"list-groups", []string{"list-groups"}},
+		{"create-user  -group city-watch\t-user vimes # the boss",
+			[]string{"create-user", "-group", "city-watch",
+				"-user", "vimes"}},
+		{`announce -message "Hello, world"`,
+			[]string{"announce", "-message", "Hello, world"}},
+		{`a 'b "c" \d' "e \"f\"" g\ h ""`,
+			[]string{"a", `b "c" \d`, `e "f"`, "g h", ""}},
+		{"a#b", []string{"a#b"}},
+	}
+	for _, test := range tests {
+		words, err := splitCommand(test.line)
+		if err != nil || !reflect.DeepEqual(words, test.words) {
+			t.Errorf("%q: got %q (%v), expected %q",
+				test.line, words, err, test.words)
+		}
+	}
+
+	for _, line := range []string{`"abc`, `'abc`, `abc\`} {
+		_, err := splitCommand(line)
+		if err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	cmds, err := parseBatch([]byte(
+		"create-group -group a\n\n# comment\ndelete-group -group b\n",
+	))
+	expected := [][]string{
+		{"create-group", "-group", "a"},
+		{"delete-group", "-group", "b"},
+	}
+	if err != nil || !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("Got %v (%v), expected %v", cmds, err, expected)
+	}
+
+	cmds, err = parseBatch([]byte(
+		` [["create-group", "-group", "a"], ["delete-group", "-group", "b"]]`,
+	))
+	if err != nil || !reflect.DeepEqual(cmds, expected) {
+		t.Errorf("Got %v (%v), expected %v", cmds, err, expected)
+	}
+
+	for _, s := range []string{`[[]]`, `[["a"]`, "a \"b\n"} {
+		_, err := parseBatch([]byte(s))
+		if err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestRunBatchCommand(t *testing.T) {
+	batchMode = true
+	defer func() {
+		batchMode = false
+	}()
+
+	stdout := os.Stdout
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	os.Stdout = devnull
+	defer func() {
+		os.Stdout = stdout
+		devnull.Close()
+	}()
+
+	err = runBatchCommand([]string{
+		"hash-password", "-password", "secret", "-type", "pbkdf2",
+	})
+	if err != nil {
+		t.Errorf("hash-password: %v", err)
+	}
+
+	err = runBatchCommand([]string{
+		"hash-password", "-password", "secret", "-type", "unknown",
+	})
+	if _, ok := err.(batchError); !ok {
+		t.Errorf("Expected batchError, got %v", err)
+	}
+
+	err = runBatchCommand([]string{"hash-password", "-unknown"})
+	if err == nil {
+		t.Errorf("Bad flag accepted")
+	}
+
+	err = runBatchCommand([]string{"hash-password", "extra"})
+	if err == nil || err.Error() != "exit status 1" {
+		t.Errorf("Expected exit status 1, got %v", err)
+	}
+
+	for _, c := range []string{"unknown", "batch"} {
+		err = runBatchCommand([]string{c})
+		if err == nil {
+			t.Errorf("%v: no error", c)
+		}
+	}
+}
+
+func TestBatchRuntimeError(t *testing.T) {
+	commands["crash-test"] = command{
+		command: func(cmdname string, args []string) {
+			var m map[string]int
+			m[cmdname] = 1
+		},
+	}
+	defer delete(commands, "crash-test")
+
+	defer func() {
+		if _, ok := recover().(runtime.Error); !ok {
+			t.Errorf("Runtime error was not propagated")
+		}
+	}()
+	runBatchCommand([]string{"crash-test"})
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Secrets (the administrator's password and token) may be stored in the
+// operating system's keyring rather than in galenectl.json.  The keyring
+// backend is selected by the "keyring" field of the configuration file;
+// the value "file" stores secrets in cleartext in the configuration file.
+
+var errNoSecret = errors.New("secret not found")
+
+type keyring interface {
+	get(server, key string) (string, error)
+	set(server, key, value string) error
+	unset(server, key string) error
+}
+
+// keyrings maps the names of keyring backends to their implementations.
+var keyrings = map[string]keyring{
+	"secret-service": secretServiceKeyring{},
+	"macos":          macosKeyring{},
+}
+
+// defaultKeyring returns the name of the keyring backend that is used
+// when none is configured.
+func defaultKeyring() string {
+	if runtime.GOOS == "darwin" {
+		return "macos"
+	}
+	_, err := exec.LookPath("secret-tool")
+	if err == nil {
+		return "secret-service"
+	}
+	return "file"
+}
+
+func getKeyring(name string) (keyring, error) {
+	if name == "" || name == "file" {
+		return nil, nil
+	}
+	k, ok := keyrings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyring %v", name)
+	}
+	return k, nil
+}
+
+// runSecretCommand runs a command that manipulates a keyring.  It
+// returns the command's output and its exit status, which is -1 if the
+// command could not be run.
+func runSecretCommand(stdin string, name string, args ...string) (string, int, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", -1, fmt.Errorf("%v: %w", name, err)
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", exitErr.ExitCode(), fmt.Errorf("%v: %v", name, msg)
+	}
+	return stdout.String(), 0, nil
+}
+
+// secretServiceKeyring uses the freedesktop Secret Service, through the
+// secret-tool utility from libsecret.
+type secretServiceKeyring struct{}
+
+func (secretServiceKeyring) attributes(server, key string) []string {
+	return []string{"service", "galenectl", "server", server, "key", key}
+}
+
+func (k secretServiceKeyring) get(server, key string) (string, error) {
+	out, status, err := runSecretCommand("", "secret-tool",
+		append([]string{"lookup"}, k.attributes(server, key)...)...,
+	)
+	if status == 1 {
+		return "", errNoSecret
+	} else if err != nil {
+		return "", err
+	}
+	if out == "" {
+		return "", errNoSecret
+	}
+	return out, nil
+}
+
+func (k secretServiceKeyring) set(server, key, value string) error {
+	args := []string{
+		"store", "--label=galenectl " + key + " for " + server,
+	}
+	_, _, err := runSecretCommand(value, "secret-tool",
+		append(args, k.attributes(server, key)...)...,
+	)
+	return err
+}
+
+func (k secretServiceKeyring) unset(server, key string) error {
+	_, status, err := runSecretCommand("", "secret-tool",
+		append([]string{"clear"}, k.attributes(server, key)...)...,
+	)
+	if status == 1 {
+		return errNoSecret
+	}
+	return err
+}
+
+// macosKeyring uses the macOS keychain, through the security utility.
+type macosKeyring struct{}
+
+// the exit status of security when an item doesn't exist
+const macosNotFound = 44
+
+func (macosKeyring) account(server, key string) string {
+	return key + "@" + server
+}
+
+func (k macosKeyring) get(server, key string) (string, error) {
+	out, status, err := runSecretCommand("", "security",
+		"find-generic-password",
+		"-s", "galenectl", "-a", k.account(server, key), "-w",
+	)
+	if status == macosNotFound {
+		return "", errNoSecret
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(out, "\n"), nil
+}
+
+// macosQuote quotes a string for the interactive mode of security.
+func macosQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (k macosKeyring) set(server, key, value string) error {
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("secret contains a newline")
+	}
+	// the secret is passed on standard input rather than on the
+	// command line, where other users could see it
+	command := fmt.Sprintf(
+		"add-generic-password -U -s galenectl -a %v -w %v\n",
+		macosQuote(k.account(server, key)), macosQuote(value),
+	)
+	_, _, err := runSecretCommand(command, "security", "-i")
+	return err
+}
+
+func (k macosKeyring) unset(server, key string) error {
+	_, status, err := runSecretCommand("", "security",
+		"delete-generic-password",
+		"-s", "galenectl", "-a", k.account(server, key),
+	)
+	if status == macosNotFound {
+		return errNoSecret
+	}
+	return err
+}
+
+// configKeys are the keys that may be manipulated by "galenectl config".
+var configKeys = []string{
+	"server", "admin-username", "admin-password", "admin-token", "keyring",
+	"client-certificate", "client-key",
+}
+
+func isSecretKey(key string) bool {
+	return key == "admin-password" || key == "admin-token"
+}
+
+func configField(config *configuration, key string) *string {
+	switch key {
+	case "server":
+		return &config.Server
+	case "admin-username":
+		return &config.AdminUsername
+	case "admin-password":
+		return &config.AdminPassword
+	case "admin-token":
+		return &config.AdminToken
+	case "keyring":
+		return &config.Keyring
+	case "client-certificate":
+		return &config.ClientCert
+	case "client-key":
+		return &config.ClientKey
+	}
+	return nil
+}
+
+// configServer returns the server that secrets are associated with.
+func configServer(config *configuration) string {
+	if config.Server != "" {
+		return config.Server
+	}
+	return "https://localhost:8443"
+}
+
+func configGet(config *configuration, key string) (string, error) {
+	field := configField(config, key)
+	if field == nil {
+		return "", fmt.Errorf("unknown key %v", key)
+	}
+	if *field != "" || !isSecretKey(key) {
+		return *field, nil
+	}
+	k, err := getKeyring(config.Keyring)
+	if err != nil || k == nil {
+		return "", err
+	}
+	v, err := k.get(configServer(config), key)
+	if errors.Is(err, errNoSecret) {
+		return "", nil
+	}
+	return v, err
+}
+
+func configSet(config *configuration, key, value string) error {
+	field := configField(config, key)
+	if field == nil {
+		return fmt.Errorf("unknown key %v", key)
+	}
+	if key == "keyring" {
+		_, err := getKeyring(value)
+		if err != nil {
+			return err
+		}
+	}
+	if !isSecretKey(key) {
+		*field = value
+		return nil
+	}
+
+	if config.Keyring == "" {
+		config.Keyring = defaultKeyring()
+	}
+	k, err := getKeyring(config.Keyring)
+	if err != nil {
+		return err
+	}
+	if k == nil {
+		*field = value
+		return nil
+	}
+	err = k.set(configServer(config), key, value)
+	if err != nil {
+		return err
+	}
+	// don't leave a stale cleartext copy
+	*field = ""
+	return nil
+}
+
+func configUnset(config *configuration, key string) error {
+	field := configField(config, key)
+	if field == nil {
+		return fmt.Errorf("unknown key %v", key)
+	}
+	*field = ""
+	if !isSecretKey(key) {
+		return nil
+	}
+	k, err := getKeyring(config.Keyring)
+	if err != nil || k == nil {
+		return err
+	}
+	err = k.unset(configServer(config), key)
+	if errors.Is(err, errNoSecret) {
+		return nil
+	}
+	return err
+}
+
+// loadSecrets fills in the administrator's credentials from the keyring
+// if they have not been provided otherwise.
+func loadSecrets(config *configuration) {
+	if adminPassword != "" || adminToken != "" {
+		return
+	}
+	k, err := getKeyring(config.Keyring)
+	if err != nil {
+		log.Printf("Keyring: %v", err)
+		return
+	}
+	if k == nil {
+		return
+	}
+	server := configServer(config)
+	adminToken, err = k.get(server, "admin-token")
+	if err != nil && !errors.Is(err, errNoSecret) {
+		log.Printf("Keyring: %v", err)
+	}
+	if adminToken != "" {
+		return
+	}
+	adminPassword, err = k.get(server, "admin-password")
+	if err != nil && !errors.Is(err, errNoSecret) {
+		log.Printf("Keyring: %v", err)
+	}
+}
+
+func writeConfig(filename string, config *configuration) error {
+	dir := filepath.Dir(filename)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "*.temp")
+	if err != nil {
+		return err
+	}
+	temp := f.Name()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(config)
+	if err != nil {
+		f.Close()
+		os.Remove(temp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	err = os.Rename(temp, filename)
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+func configCmd(cmdname string, args []string) {
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v set key [value]\n"+
+			"%v [option...] %v get key\n"+
+			"%v [option...] %v unset key\n"+
+			"Keys: %v\n",
+		os.Args[0], cmdname, os.Args[0], cmdname,
+		os.Args[0], cmdname, strings.Join(configKeys, ", "),
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() < 2 {
+		cmd.Usage()
+		exit(1)
+	}
+	op, key := cmd.Arg(0), cmd.Arg(1)
+
+	config, err := readConfig(configFile)
+	if err != nil {
+		fatalf("Read configuration file: %v", err)
+	}
+
+	switch op {
+	case "get":
+		if cmd.NArg() != 2 {
+			cmd.Usage()
+			exit(1)
+		}
+		v, err := configGet(&config, key)
+		if err != nil {
+			fatalf("Get %v: %v", key, err)
+		}
+		fmt.Println(v)
+		return
+	case "set":
+		var value string
+		if cmd.NArg() == 3 {
+			value = cmd.Arg(2)
+		} else if cmd.NArg() == 2 && isSecretKey(key) {
+			fmt.Fprintf(os.Stdin, "%v: ", key)
+			v, err := term.ReadPassword(int(os.Stdin.Fd()))
+			if err != nil {
+				fatalf("ReadPassword: %v", err)
+			}
+			fmt.Fprint(os.Stdin, "\n")
+			value = string(v)
+		} else {
+			cmd.Usage()
+			exit(1)
+		}
+		err = configSet(&config, key, value)
+		if err != nil {
+			fatalf("Set %v: %v", key, err)
+		}
+	case "unset":
+		if cmd.NArg() != 2 {
+			cmd.Usage()
+			exit(1)
+		}
+		err = configUnset(&config, key)
+		if err != nil {
+			fatalf("Unset %v: %v", key, err)
+		}
+	default:
+		cmd.Usage()
+		exit(1)
+	}
+
+	err = writeConfig(configFile, &config)
+	if err != nil {
+		fatalf("Write configuration file: %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type memoryKeyring map[string]string
+
+func (k memoryKeyring) get(server, key string) (string, error) {
+	v, ok := k[server+" "+key]
+	if !ok {
+		return "", errNoSecret
+	}
+	return v, nil
+}
+
+func (k memoryKeyring) set(server, key, value string) error {
+	k[server+" "+key] = value
+	return nil
+}
+
+func (k memoryKeyring) unset(server, key string) error {
+	_, ok := k[server+" "+key]
+	if !ok {
+		return errNoSecret
+	}
+	delete(k, server+" "+key)
+	return nil
+}
+
+func TestConfigKeyring(t *testing.T) {
+	k := make(memoryKeyring)
+	keyrings["memory"] = k
+	defer delete(keyrings, "memory")
+
+	var config configuration
+	err := configSet(&config, "server", "https://galene.example.org")
+	if err != nil {
+		t.Fatalf("Set server: %v", err)
+	}
+	err = configSet(&config, "keyring", "memory")
+	if err != nil {
+		t.Fatalf("Set keyring: %v", err)
+	}
+	err = configSet(&config, "admin-password", "secret")
+	if err != nil {
+		t.Fatalf("Set password: %v", err)
+	}
+	if config.AdminPassword != "" {
+		t.Errorf("Password stored in cleartext")
+	}
+	if k["https://galene.example.org admin-password"] != "secret" {
+		t.Errorf("Password not in keyring: %v", k)
+	}
+
+	v, err := configGet(&config, "admin-password")
+	if err != nil || v != "secret" {
+		t.Errorf("Get password: %v %v", v, err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "galenectl.json")
+	err = writeConfig(filename, &config)
+	if err != nil {
+		t.Fatalf("writeConfig: %v", err)
+	}
+	config2, err := readConfig(filename)
+	if err != nil {
+		t.Fatalf("readConfig: %v", err)
+	}
+	if config2 != config {
+		t.Errorf("Expected %v, got %v", config, config2)
+	}
+
+	adminPassword, adminToken = "", ""
+	loadSecrets(&config2)
+	if adminPassword != "secret" || adminToken != "" {
+		t.Errorf("loadSecrets: got %v %v", adminPassword, adminToken)
+	}
+	adminPassword = ""
+
+	err = configUnset(&config, "admin-password")
+	if err != nil {
+		t.Errorf("Unset password: %v", err)
+	}
+	if len(k) != 0 {
+		t.Errorf("Keyring not empty: %v", k)
+	}
+	err = configUnset(&config, "admin-password")
+	if err != nil {
+		t.Errorf("Unset password twice: %v", err)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	config := configuration{Keyring: "file"}
+	err := configSet(&config, "admin-token", "token")
+	if err != nil || config.AdminToken != "token" {
+		t.Errorf("Set token: %v %v", config.AdminToken, err)
+	}
+	err = configSet(&config, "keyring", "nonexistent")
+	if err == nil {
+		t.Errorf("Unknown keyring accepted")
+	}
+	err = configSet(&config, "unknown", "value")
+	if err == nil {
+		t.Errorf("Unknown key accepted")
+	}
+}
+
+func TestMacosQuote(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", `""`},
+		{"secret", `"secret"`},
+		{`a "b" c`, `"a \"b\" c"`},
+		{`a\b`, `"a\\b"`},
+	}
+	for _, test := range tests {
+		if q := macosQuote(test.in); q != test.out {
+			t.Errorf("%q: expected %q, got %q", test.in, test.out, q)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"time"
+)
+
+type drainClient struct {
+	Id          string   `json:"id"`
+	Type        string   `json:"type"`
+	Permissions []string `json:"permissions"`
+}
+
+// countClients returns the number of clients in a group, not counting
+// system clients, such as disk writers and RTSP sources, which don't
+// leave by themselves.
+func countClients(clients []drainClient) int {
+	n := 0
+	for _, c := range clients {
+		if !slices.Contains(c.Permissions, "system") {
+			n++
+		}
+	}
+	return n
+}
+
+func drainGroupCmd(cmdname string, args []string) {
+	var groupname string
+	var cancel bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.BoolVar(&cancel, "cancel", false, "allow joins again")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(
+		serverURL, "/galene-api/v0/.groups/", groupname, ".drain",
+	)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	if cancel {
+		err = deleteValue(u)
+		if err != nil {
+			fatalf("Cancel drain: %v", err)
+		}
+		return
+	}
+
+	_, err = postJSON(u, nil)
+	if err != nil {
+		fatalf("Drain group: %v", err)
+	}
+}
+
+func waitForEmptyCmd(cmdname string, args []string) {
+	var groupname string
+	var timeout time.Duration
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.DurationVar(&timeout, "timeout", 0,
+		"give up after this `duration`, never if 0")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 || timeout < 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(
+		serverURL, "/galene-api/v0/.groups/", groupname, ".clients/",
+	)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		var clients []drainClient
+		_, err = getJSON(u, &clients)
+		if err != nil {
+			fatalf("Get clients: %v", err)
+		}
+		n := countClients(clients)
+		if n == 0 {
+			return
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			fatalf("Timeout: %v clients still in group %v",
+				n, groupname)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -17,11 +17,13 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/term"
@@ -35,11 +37,15 @@ type configuration struct {
 	AdminUsername string `json:"admin-username,omitempty"`
 	AdminPassword string `json:"admin-password,omitempty"`
 	AdminToken    string `json:"admin-token,omitempty"`
+	Keyring       string `json:"keyring,omitempty"`
+	ClientCert    string `json:"client-certificate,omitempty"`
+	ClientKey     string `json:"client-key,omitempty"`
 }
 
 var insecure bool
 var serverURL, adminUsername, adminPassword, adminToken string
 var configFile string
+var clientCert, clientKey string
 
 var client http.Client
 
@@ -57,6 +63,10 @@ var commands = map[string]command{
 		command:     initialSetupCmd,
 		description: "initial setup of Galene and galenectl",
 	},
+	"config": {
+		command:     configCmd,
+		description: "manage galenectl's configuration",
+	},
 	"set-password": {
 		command:     setPasswordCmd,
 		description: "set a user's password",
@@ -65,6 +75,10 @@ var commands = map[string]command{
 		command:     deletePasswordCmd,
 		description: "delete a user's password",
 	},
+	"ping": {
+		command:     pingCmd,
+		description: "check that the server is alive",
+	},
 	"list-groups": {
 		command:     listGroupsCmd,
 		description: "list groups",
@@ -73,6 +87,18 @@ var commands = map[string]command{
 		command:     showGroupCmd,
 		description: "show group definition",
 	},
+	"apply": {
+		command:     applyCmd,
+		description: "synchronise the server with a directory",
+	},
+	"import": {
+		command:     importCmd,
+		description: "convert rooms from Jitsi or BigBlueButton",
+	},
+	"diff-group": {
+		command:     diffGroupCmd,
+		description: "compare a group definition with a file",
+	},
 	"create-group": {
 		command:     createGroupCmd,
 		description: "create a group",
@@ -101,6 +127,38 @@ var commands = map[string]command{
 		command:     updateUserCmd,
 		description: "change a user's permissions",
 	},
+	"show-user": {
+		command:     showUserCmd,
+		description: "show a user's definition",
+	},
+	"list-clients": {
+		command:     listClientsCmd,
+		description: "list connected clients",
+	},
+	"drain-group": {
+		command:     drainGroupCmd,
+		description: "refuse new joins to a group",
+	},
+	"wait-for-empty": {
+		command:     waitForEmptyCmd,
+		description: "wait until a group is empty",
+	},
+	"announce": {
+		command:     announceCmd,
+		description: "post to an announcement channel",
+	},
+	"promote": {
+		command:     promoteCmd,
+		description: "turn a standby server into a primary",
+	},
+	"reload": {
+		command:     reloadCmd,
+		description: "reload the server's configuration",
+	},
+	"maintenance": {
+		command:     maintenanceCmd,
+		description: "schedule, show or cancel a maintenance window",
+	},
 	"list-tokens": {
 		command:     listTokensCmd,
 		description: "list tokens",
@@ -109,6 +167,14 @@ var commands = map[string]command{
 		command:     createTokenCmd,
 		description: "request a token",
 	},
+	"sign-token": {
+		command:     signTokenCmd,
+		description: "generate a cryptographic token",
+	},
+	"show-token": {
+		command:     showTokenCmd,
+		description: "show a token's fields",
+	},
 	"revoke-token": {
 		command:     revokeTokenCmd,
 		description: "revoke a token",
@@ -117,6 +183,38 @@ var commands = map[string]command{
 		command:     deleteTokenCmd,
 		description: "delete a token",
 	},
+	"expire-tokens": {
+		command:     expireTokensCmd,
+		description: "remove tokens that have expired",
+	},
+	"check-token": {
+		command:     checkTokenCmd,
+		description: "check the validity of a token",
+	},
+	"list-recordings": {
+		command:     listRecordingsCmd,
+		description: "list a group's recordings",
+	},
+	"get-recording": {
+		command:     getRecordingCmd,
+		description: "download a recording",
+	},
+	"delete-recording": {
+		command:     deleteRecordingCmd,
+		description: "delete a recording",
+	},
+	"create-api-token": {
+		command:     createAPITokenCmd,
+		description: "create an API token",
+	},
+	"list-api-tokens": {
+		command:     listAPITokensCmd,
+		description: "list API tokens",
+	},
+	"delete-api-token": {
+		command:     deleteAPITokenCmd,
+		description: "delete an API token",
+	},
 }
 
 func main() {
@@ -167,6 +265,10 @@ func main() {
 		"administrator `password`")
 	flag.StringVar(&adminToken, "admin-token",
 		"", "administrator `token`")
+	flag.StringVar(&clientCert, "client-certificate", "",
+		"client certificate `file`, for servers that require one")
+	flag.StringVar(&clientKey, "client-key", "",
+		"client private key `file`")
 	flag.Parse()
 
 	if flag.NArg() < 1 {
@@ -191,13 +293,33 @@ func main() {
 	if adminPassword == "" {
 		adminPassword = config.AdminPassword
 	}
+	if adminToken == "" {
+		adminToken = os.Getenv("GALENECTL_ADMIN_TOKEN")
+	}
 	if adminToken == "" {
 		adminToken = config.AdminToken
 	}
+	if clientCert == "" {
+		clientCert = config.ClientCert
+	}
+	if clientKey == "" {
+		clientKey = config.ClientKey
+	}
+	if clientKey == "" {
+		clientKey = clientCert
+	}
+	loadSecrets(&config)
 
-	if insecure {
+	if insecure || clientCert != "" {
 		t := http.DefaultTransport.(*http.Transport).Clone()
-		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecure}
+		if clientCert != "" {
+			cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+			if err != nil {
+				log.Fatalf("Load client certificate: %v", err)
+			}
+			t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		}
 		client.Transport = t
 	}
 
@@ -229,7 +351,7 @@ func readConfig(filename string) (configuration, error) {
 	return config, nil
 }
 
-func makePassword(pw string, algorithm string, iterations, length, saltlen, cost int) (group.Password, error) {
+func makePassword(pw string, algorithm string, iterations, length, saltlen, cost, memory, parallelism int) (group.Password, error) {
 	salt := make([]byte, saltlen)
 	_, err := rand.Read(salt)
 	if err != nil {
@@ -249,6 +371,25 @@ func makePassword(pw string, algorithm string, iterations, length, saltlen, cost
 			Salt:       hex.EncodeToString(salt),
 			Iterations: iterations,
 		}, nil
+	case "argon2id":
+		if iterations <= 0 || memory <= 0 ||
+			parallelism <= 0 || parallelism > 255 {
+			return group.Password{},
+				errors.New("bad argon2id parameters")
+		}
+		key := argon2.IDKey(
+			[]byte(pw), salt, uint32(iterations), uint32(memory),
+			uint8(parallelism), uint32(length),
+		)
+		encoded := hex.EncodeToString(key)
+		return group.Password{
+			Type:        "argon2id",
+			Key:         &encoded,
+			Salt:        hex.EncodeToString(salt),
+			Iterations:  iterations,
+			Memory:      memory,
+			Parallelism: parallelism,
+		}, nil
 	case "bcrypt":
 		key, err := bcrypt.GenerateFromPassword(
 			[]byte(pw), cost,
@@ -264,7 +405,7 @@ func makePassword(pw string, algorithm string, iterations, length, saltlen, cost
 		}, nil
 	case "wildcard":
 		if pw != "" {
-			log.Fatalf(
+			fatalf(
 				"Wildcard password " +
 					"must be the empty string",
 			)
@@ -277,6 +418,17 @@ func makePassword(pw string, algorithm string, iterations, length, saltlen, cost
 	}
 }
 
+// defaultIterations returns the default number of iterations for a given
+// password hashing algorithm.
+func defaultIterations(algorithm string) int {
+	switch algorithm {
+	case "argon2id":
+		return 2
+	default:
+		return 4096
+	}
+}
+
 func setUsage(cmd *flag.FlagSet, cmdname string, format string, args ...any) {
 	cmd.Usage = func() {
 		fmt.Fprintf(cmd.Output(), format, args...)
@@ -286,9 +438,9 @@ func setUsage(cmd *flag.FlagSet, cmdname string, format string, args ...any) {
 
 func hashPasswordCmd(cmdname string, args []string) {
 	var password, algorithm string
-	var iterations, cost, length, saltlen int
+	var iterations, cost, length, saltlen, memory, parallelism int
 
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -296,16 +448,24 @@ func hashPasswordCmd(cmdname string, args []string) {
 	cmd.StringVar(&password, "password", "", "new `password`")
 	cmd.StringVar(&algorithm, "type", "bcrypt",
 		"password `type`")
-	cmd.IntVar(&iterations, "iterations", 4096,
-		"`number` of iterations (pbkdf2)")
+	cmd.IntVar(&iterations, "iterations", 0,
+		"`number` of iterations (pbkdf2, argon2id)")
 	cmd.IntVar(&cost, "cost", 8, "`cost` (bcrypt)")
-	cmd.IntVar(&length, "key", 32, "key `length` (pbkdf2)")
-	cmd.IntVar(&saltlen, "salt", 8, "salt `length` (pbkdf2)")
+	cmd.IntVar(&length, "key", 32, "key `length` (pbkdf2, argon2id)")
+	cmd.IntVar(&saltlen, "salt", 8, "salt `length` (pbkdf2, argon2id)")
+	cmd.IntVar(&memory, "memory", 19*1024,
+		"memory `size` in kilobytes (argon2id)")
+	cmd.IntVar(&parallelism, "parallelism", 1,
+		"`number` of threads (argon2id)")
 	cmd.Parse(args)
 
+	if iterations == 0 {
+		iterations = defaultIterations(algorithm)
+	}
+
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if algorithm != "wildcard" && password == "" {
@@ -313,28 +473,29 @@ func hashPasswordCmd(cmdname string, args []string) {
 		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
 		fmt.Println()
 		if err != nil {
-			log.Fatalf("ReadPassword: %v", err)
+			fatalf("ReadPassword: %v", err)
 		}
 		password = string(pw)
 	}
 
 	p, err := makePassword(
 		password, algorithm, iterations, length, saltlen, cost,
+		memory, parallelism,
 	)
 	if err != nil {
-		log.Fatalf("Make password: %v", err)
+		fatalf("Make password: %v", err)
 	}
 	e := json.NewEncoder(os.Stdout)
 	err = e.Encode(p)
 	if err != nil {
-		log.Fatalf("Encode: %v", err)
+		fatalf("Encode: %v", err)
 	}
 }
 
 func initialSetupCmd(cmdname string, args []string) {
 	var galeneConfigFn string
 
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -345,21 +506,21 @@ func initialSetupCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if adminUsername == "" {
 		fmt.Fprintf(cmd.Output(),
 			"Option \"-admin-username\" is required.\n",
 		)
-		os.Exit(1)
+		exit(1)
 	}
 
 	if adminPassword == "" && adminToken == "" {
 		fmt.Fprint(os.Stdin, "Administrator password: ")
 		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
 		if err != nil {
-			log.Fatalf("ReadPassword: %v", err)
+			fatalf("ReadPassword: %v", err)
 		}
 		adminPassword = string(pw)
 		fmt.Fprint(os.Stdin, "\n")
@@ -369,7 +530,7 @@ func initialSetupCmd(cmdname string, args []string) {
 		os.O_WRONLY|os.O_CREATE|os.O_CREATE|os.O_EXCL,
 		0600)
 	if err != nil {
-		log.Fatalf("Create %v: %v", galeneConfigFn, err)
+		fatalf("Create %v: %v", galeneConfigFn, err)
 	}
 
 	galenectlConfig, err := os.OpenFile(configFile,
@@ -378,27 +539,31 @@ func initialSetupCmd(cmdname string, args []string) {
 	if err != nil {
 		galeneConfig.Close()
 		os.Remove(galeneConfigFn)
-		log.Fatalf("Create %v: %v", galeneConfigFn, err)
+		fatalf("Create %v: %v", galeneConfigFn, err)
 	}
 
 	defer galeneConfig.Close()
 	defer galenectlConfig.Close()
 
-	var users map[string]group.UserDescription
+	var users map[string]group.ServerUser
 	if adminPassword != "" {
-		pw, err := makePassword(adminPassword, "bcrypt", 0, 0, 0, 12)
+		pw, err := makePassword(
+			adminPassword, "bcrypt", 0, 0, 0, 12, 0, 0,
+		)
 		if err != nil {
-			log.Fatalf("makePassword: %v", err)
+			fatalf("makePassword: %v", err)
 		}
 
 		perms, err := group.NewPermissions("admin")
 		if err != nil {
-			log.Fatalf("NewPermissions: %v", err)
+			fatalf("NewPermissions: %v", err)
 		}
-		users = map[string]group.UserDescription{
+		users = map[string]group.ServerUser{
 			adminUsername: {
-				Password:    pw,
-				Permissions: perms,
+				UserDescription: group.UserDescription{
+					Password:    pw,
+					Permissions: perms,
+				},
 			},
 		}
 	}
@@ -412,7 +577,7 @@ func initialSetupCmd(cmdname string, args []string) {
 	encoder.SetIndent("", "    ")
 	err = encoder.Encode(&config)
 	if err != nil {
-		log.Fatalf("Encode %v: %v", galeneConfigFn, err)
+		fatalf("Encode %v: %v", galeneConfigFn, err)
 	}
 
 	ctlConfig := configuration{
@@ -426,7 +591,7 @@ func initialSetupCmd(cmdname string, args []string) {
 	ctlEncoder.SetIndent("", "    ")
 	err = ctlEncoder.Encode(&ctlConfig)
 	if err != nil {
-		log.Fatalf("Encode %v: %v", configFile, err)
+		fatalf("Encode %v: %v", configFile, err)
 	}
 
 	fmt.Printf("The file %v has been created.  ", galeneConfigFn)
@@ -450,10 +615,15 @@ type httpError struct {
 }
 
 func (e httpError) Error() string {
+	s := fmt.Sprintf("HTTP error: %v", e.statusCode)
 	if e.status != "" {
-		return fmt.Sprintf("HTTP error: %v", e.status)
+		s = fmt.Sprintf("HTTP error: %v", e.status)
+	}
+	if e.statusCode == http.StatusUnauthorized {
+		// the credentials might be valid but too narrowly scoped
+		s += " (bad credentials or insufficient scopes)"
 	}
-	return fmt.Sprintf("HTTP error: %v", e.statusCode)
+	return s
 }
 
 func getJSON(url string, value any) (string, error) {
@@ -479,6 +649,28 @@ func getJSON(url string, value any) (string, error) {
 	return etag, decoder.Decode(value)
 }
 
+// getCopy copies the body of the reply to a GET request to w.
+func getCopy(url string, w io.Writer) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	setAuthorization(req)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return httpError{resp.StatusCode, resp.Status}
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
 func putJSON(url string, value any, overwrite bool) error {
 	j, err := json.Marshal(value)
 	if err != nil {
@@ -533,6 +725,32 @@ func postJSON(url string, value any) (string, error) {
 	return location, nil
 }
 
+// queryJSON performs a POST request with a JSON body and decodes the
+// reply.
+func queryJSON(url string, value any, reply any) error {
+	j, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	setAuthorization(req)
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return httpError{resp.StatusCode, resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
 func updateJSON[T any](url string, update func(T) T) error {
 	var old T
 	etag, err := getJSON(url, &old)
@@ -586,11 +804,11 @@ func deleteValue(url string) error {
 
 func setPasswordCmd(cmdname string, args []string) {
 	var groupname, username string
-	var wildcard bool
+	var wildcard, global bool
 	var password, algorithm string
-	var iterations, cost, length, saltlen int
+	var iterations, cost, length, saltlen, memory, parallelism int
 
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -598,79 +816,67 @@ func setPasswordCmd(cmdname string, args []string) {
 	cmd.StringVar(&groupname, "group", "", "group `name`")
 	cmd.StringVar(&username, "user", "", "user `name`")
 	cmd.BoolVar(&wildcard, "wildcard", false, "set wildcard user's password")
+	cmd.BoolVar(&global, "global", false, "set a global user's password")
 	cmd.StringVar(&password, "password", "", "new `password`")
 	cmd.StringVar(&algorithm, "type", "bcrypt",
 		"password `type`")
-	cmd.IntVar(&iterations, "iterations", 4096,
-		"`number` of iterations (pbkdf2)")
+	cmd.IntVar(&iterations, "iterations", 0,
+		"`number` of iterations (pbkdf2, argon2id)")
 	cmd.IntVar(&cost, "cost", 8,
 		"`cost` (bcrypt)")
-	cmd.IntVar(&length, "key", 32, "key `length` (pbkdf2)")
-	cmd.IntVar(&saltlen, "salt", 8, "salt `length` (pbkdf2)")
+	cmd.IntVar(&length, "key", 32, "key `length` (pbkdf2, argon2id)")
+	cmd.IntVar(&saltlen, "salt", 8, "salt `length` (pbkdf2, argon2id)")
+	cmd.IntVar(&memory, "memory", 19*1024,
+		"memory `size` in kilobytes (argon2id)")
+	cmd.IntVar(&parallelism, "parallelism", 1,
+		"`number` of threads (argon2id)")
 	cmd.Parse(args)
 
-	if cmd.NArg() != 0 {
-		cmd.Usage()
-		os.Exit(1)
+	if iterations == 0 {
+		iterations = defaultIterations(algorithm)
 	}
 
-	if groupname == "" {
-		fmt.Fprintf(cmd.Output(),
-			"Option \"-group\" is required\n")
-		os.Exit(1)
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
 	}
 
-	if wildcard != (username == "") {
-		fmt.Fprintf(cmd.Output(),
-			"Exactly one of \"-user\" and \"-wildcard\" "+
-				"is required\n")
-		os.Exit(1)
-	}
+	checkUserOptions(cmd, global, wildcard, groupname, username)
 
 	if algorithm != "wildcard" && password == "" {
 		fmt.Fprint(os.Stdin, "New password: ")
 		pw, err := term.ReadPassword(int(os.Stdin.Fd()))
 		fmt.Println()
 		if err != nil {
-			log.Fatalf("ReadPassword: %v", err)
+			fatalf("ReadPassword: %v", err)
 		}
 		password = string(pw)
 	}
 
 	pw, err := makePassword(
 		password, algorithm, iterations, length, saltlen, cost,
+		memory, parallelism,
 	)
 	if err != nil {
-		log.Fatalf("Make password: %v", err)
+		fatalf("Make password: %v", err)
 	}
 
-	var u string
-	if wildcard {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".wildcard-user/.password",
-		)
-	} else {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".users", username, ".password",
-		)
-	}
+	u, err := passwordURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	err = putJSON(u, pw, true)
 	if err != nil {
-		log.Fatalf("Set password: %v", err)
+		fatalf("Set password: %v", err)
 	}
 }
 
 func deletePasswordCmd(cmdname string, args []string) {
 	var groupname, username string
-	var wildcard bool
+	var wildcard, global bool
 
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -678,46 +884,24 @@ func deletePasswordCmd(cmdname string, args []string) {
 	cmd.StringVar(&groupname, "group", "", "group `name`")
 	cmd.StringVar(&username, "user", "", "user `name`")
 	cmd.BoolVar(&wildcard, "wildcard", false, "set wildcard user's password")
+	cmd.BoolVar(&global, "global", false, "delete a global user's password")
 	cmd.Parse(args)
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
-	if groupname == "" {
-		fmt.Fprintf(cmd.Output(),
-			"Option \"-group\" is required\n")
-		os.Exit(1)
-	}
-
-	if wildcard != (username == "") {
-		fmt.Fprintf(cmd.Output(),
-			"Exactly one of \"-user\" and \"-wildcard\" "+
-				"is required\n")
-		os.Exit(1)
-	}
+	checkUserOptions(cmd, global, wildcard, groupname, username)
 
-	var u string
-	var err error
-	if wildcard {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".wildcard-user/.password",
-		)
-	} else {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".users", username, ".password",
-		)
-	}
+	u, err := passwordURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	err = deleteValue(u)
 	if err != nil {
-		log.Fatalf("Delete password: %v", err)
+		fatalf("Delete password: %v", err)
 	}
 }
 
@@ -773,6 +957,47 @@ func (o *stringOption) String() string {
 	return o.value
 }
 
+// timeOption represents a command-line option that is either a duration
+// relative to the current time or an absolute time in RFC 3339 format.
+type timeOption struct {
+	set   bool
+	value time.Time
+}
+
+func parseTime(value string, now time.Time) (time.Time, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil {
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New(
+			"expected a duration or an RFC 3339 time",
+		)
+	}
+	return t, nil
+}
+
+func (o *timeOption) Set(value string) error {
+	t, err := parseTime(value, time.Now())
+	if err != nil {
+		return err
+	}
+	o.value = t
+	o.set = true
+	return nil
+}
+
+func (o *timeOption) String() string {
+	if o == nil {
+		return "(nil)"
+	}
+	if !o.set {
+		return "(unset)"
+	}
+	return o.value.Format(time.RFC3339)
+}
+
 // stdinJSON reads a JSON dictionary on standard input if doit is true.
 // It always returns a non-nil dictionary in the non-error case.
 func stdinJSON(doit bool) (map[string]any, error) {
@@ -791,7 +1016,7 @@ func createGroupCmd(cmdname string, args []string) {
 	var groupname string
 	var unrestrictedTokens, autoSubgroups boolOption
 	var doJSON bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -810,25 +1035,25 @@ func createGroupCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if groupname == "" {
 		fmt.Fprintf(cmd.Output(),
 			"Option \"-group\" is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
 		serverURL, "/galene-api/v0/.groups", groupname,
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	data, err := stdinJSON(doJSON)
 	if err != nil {
-		log.Fatalf("Decode standard input: %v", err)
+		fatalf("Decode standard input: %v", err)
 	}
 
 	if unrestrictedTokens.set {
@@ -840,13 +1065,13 @@ func createGroupCmd(cmdname string, args []string) {
 
 	err = putJSON(u, data, false)
 	if err != nil {
-		log.Fatalf("Create group: %v", err)
+		fatalf("Create group: %v", err)
 	}
 }
 
 func deleteGroupCmd(cmdname string, args []string) {
 	var groupname string
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -856,25 +1081,25 @@ func deleteGroupCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if groupname == "" {
 		fmt.Fprintf(cmd.Output(),
 			"Option \"-group\" is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
 		serverURL, "/galene-api/v0/.groups", groupname,
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	err = deleteValue(u)
 	if err != nil {
-		log.Fatalf("Delete group: %v", err)
+		fatalf("Delete group: %v", err)
 	}
 }
 
@@ -882,7 +1107,7 @@ func updateGroupCmd(cmdname string, args []string) {
 	var groupname string
 	var unrestrictedTokens, autoSubgroups boolOption
 	var doJSON bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -901,19 +1126,19 @@ func updateGroupCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
 		serverURL, "/galene-api/v0/.groups", groupname,
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	data, err := stdinJSON(doJSON)
 	if err != nil {
-		log.Fatalf("Decode standard input: %v", err)
+		fatalf("Decode standard input: %v", err)
 	}
 
 	err = updateJSON(u, func(m map[string]any) map[string]any {
@@ -935,7 +1160,7 @@ func updateGroupCmd(cmdname string, args []string) {
 	})
 
 	if err != nil {
-		log.Fatalf("Update group: %v", err)
+		fatalf("Update group: %v", err)
 	}
 }
 
@@ -1000,32 +1225,40 @@ func match(patterns []string, value string) (bool, error) {
 
 func listUsersCmd(cmdname string, args []string) {
 	var groupname string
-	var long bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	var long, global bool
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...] [pattern...]\n",
 		os.Args[0], cmdname,
 	)
 	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.BoolVar(&global, "global", false, "list global users")
 	cmd.BoolVar(&long, "l", false, "display permissions")
 	cmd.Parse(args)
 	patterns := cmd.Args()
 
-	if groupname == "" {
+	if global == (groupname != "") {
 		fmt.Fprintf(cmd.Output(),
-			"Option \"-group\" is required\n")
-		os.Exit(1)
+			"Exactly one of \"-group\" and \"-global\" "+
+				"is required\n")
+		exit(1)
 	}
 
-	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/", groupname,
-		".users/")
+	var u string
+	var err error
+	if global {
+		u, err = url.JoinPath(serverURL, "/galene-api/v0/.users/")
+	} else {
+		u, err = url.JoinPath(serverURL, "/galene-api/v0/.groups/",
+			groupname, ".users/")
+	}
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 	var users []string
 	_, err = getJSON(u, &users)
 	if err != nil {
-		log.Fatalf("Get users: %v", err)
+		fatalf("Get users: %v", err)
 	}
 	sort.Slice(users, func(i, j int) bool {
 		return users[i] < users[j]
@@ -1034,7 +1267,7 @@ func listUsersCmd(cmdname string, args []string) {
 		if len(patterns) > 0 {
 			found, err := match(patterns, user)
 			if err != nil {
-				log.Fatalf("Match: %v", err)
+				fatalf("Match: %v", err)
 			}
 			if !found {
 				continue
@@ -1061,6 +1294,38 @@ func listUsersCmd(cmdname string, args []string) {
 	}
 }
 
+// checkUserOptions exits if the options that designate a user are
+// inconsistent.
+func checkUserOptions(cmd *flag.FlagSet, global, wildcard bool, groupname, username string) {
+	if global {
+		if groupname != "" || wildcard {
+			fmt.Fprintf(cmd.Output(),
+				"Option \"-global\" cannot be used with "+
+					"\"-group\" or \"-wildcard\"\n")
+			exit(1)
+		}
+		if username == "" {
+			fmt.Fprintf(cmd.Output(),
+				"Option \"-user\" is required\n")
+			exit(1)
+		}
+		return
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	if wildcard != (username == "") {
+		fmt.Fprintf(cmd.Output(),
+			"Exactly one of \"-user\" and \"-wildcard\" "+
+				"is required\n")
+		exit(1)
+	}
+}
+
 func userURL(wildcard bool, groupname, username string) (string, error) {
 	if wildcard {
 		return url.JoinPath(
@@ -1074,12 +1339,29 @@ func userURL(wildcard bool, groupname, username string) (string, error) {
 	)
 }
 
+// targetUserURL returns the URL of either a global user or a user of
+// a group.
+func targetUserURL(global, wildcard bool, groupname, username string) (string, error) {
+	if global {
+		return url.JoinPath(serverURL, "/galene-api/v0/.users", username)
+	}
+	return userURL(wildcard, groupname, username)
+}
+
+func passwordURL(global, wildcard bool, groupname, username string) (string, error) {
+	u, err := targetUserURL(global, wildcard, groupname, username)
+	if err != nil {
+		return "", err
+	}
+	return url.JoinPath(u, ".password")
+}
+
 func createUserCmd(cmdname string, args []string) {
 	var groupname, username string
-	var wildcard bool
+	var wildcard, global bool
 	var permissions stringOption
 	var doJSON bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -1087,6 +1369,7 @@ func createUserCmd(cmdname string, args []string) {
 	cmd.StringVar(&groupname, "group", "", "group `name`")
 	cmd.StringVar(&username, "user", "", "user `name`")
 	cmd.BoolVar(&wildcard, "wildcard", false, "create the wildcard user")
+	cmd.BoolVar(&global, "global", false, "create a global user")
 	cmd.Var(&permissions, "permissions",
 		"permissions (default \"present\")")
 	cmd.BoolVar(&doJSON, "json", false,
@@ -1096,21 +1379,10 @@ func createUserCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
-	}
-
-	if groupname == "" {
-		fmt.Fprintf(cmd.Output(),
-			"Option \"-group\" is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
-	if wildcard != (username == "") {
-		fmt.Fprintf(cmd.Output(),
-			"Exactly one of \"-user\" and \"-wildcard\" "+
-				"is required\n")
-		os.Exit(1)
-	}
+	checkUserOptions(cmd, global, wildcard, groupname, username)
 
 	var perms any
 	if permissions.set {
@@ -1123,14 +1395,14 @@ func createUserCmd(cmdname string, args []string) {
 		}
 	}
 
-	u, err := userURL(wildcard, groupname, username)
+	u, err := targetUserURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	data, err := stdinJSON(doJSON)
 	if err != nil {
-		log.Fatalf("Decode standard input: %v", err)
+		fatalf("Decode standard input: %v", err)
 	}
 
 	// command line overrides template.  If neither, default to "present".
@@ -1142,16 +1414,16 @@ func createUserCmd(cmdname string, args []string) {
 
 	err = putJSON(u, data, false)
 	if err != nil {
-		log.Fatalf("Create user: %v", err)
+		fatalf("Create user: %v", err)
 	}
 }
 
 func updateUserCmd(cmdname string, args []string) {
 	var groupname, username string
-	var wildcard bool
+	var wildcard, global bool
 	var permissions stringOption
 	var doJSON bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname,
 		"%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
@@ -1159,17 +1431,20 @@ func updateUserCmd(cmdname string, args []string) {
 	cmd.StringVar(&groupname, "group", "", "group `name`")
 	cmd.StringVar(&username, "user", "", "user `name`")
 	cmd.BoolVar(&wildcard, "wildcard", false, "update the wildcard user")
+	cmd.BoolVar(&global, "global", false, "update a global user")
 	cmd.Var(&permissions, "permissions", "permissions")
 	cmd.Parse(args)
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
-	u, err := userURL(wildcard, groupname, username)
+	checkUserOptions(cmd, global, wildcard, groupname, username)
+
+	u, err := targetUserURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	var perms any
@@ -1185,7 +1460,7 @@ func updateUserCmd(cmdname string, args []string) {
 
 	data, err := stdinJSON(doJSON)
 	if err != nil {
-		log.Fatalf("Decode standard input: %v", err)
+		fatalf("Decode standard input: %v", err)
 	}
 
 	err = updateJSON(u, func(m map[string]any) map[string]any {
@@ -1204,156 +1479,529 @@ func updateUserCmd(cmdname string, args []string) {
 	})
 
 	if err != nil {
-		log.Fatalf("Update user: %v", err)
+		fatalf("Update user: %v", err)
 	}
 }
 
 func deleteUserCmd(cmdname string, args []string) {
 	var groupname, username string
-	var wildcard bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	var wildcard, global, purge bool
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
 	cmd.StringVar(&groupname, "group", "", "group `name`")
 	cmd.StringVar(&username, "user", "", "user `name`")
 	cmd.BoolVar(&wildcard, "wildcard", false, "delete the wildcard user")
+	cmd.BoolVar(&global, "global", false, "delete a global user")
+	cmd.BoolVar(&purge, "purge", false,
+		"also revoke the user's tokens and sessions")
 	cmd.Parse(args)
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
-	if groupname == "" {
-		fmt.Fprintf(cmd.Output(),
-			"Option \"-group\" is required\n")
-		os.Exit(1)
-	}
+	checkUserOptions(cmd, global, wildcard, groupname, username)
 
-	if wildcard != (username == "") {
+	if (wildcard || global) && purge {
 		fmt.Fprintf(cmd.Output(),
-			"Exactly one of \"-user\" and \"-wildcard\" "+
-				"is required\n")
-		os.Exit(1)
+			"Option \"-purge\" cannot be used with "+
+				"\"-wildcard\" or \"-global\"\n")
+		exit(1)
 	}
 
-	var u string
-	var err error
-	if wildcard {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".wildcard-user",
-		)
-	} else {
-		u, err = url.JoinPath(
-			serverURL, "/galene-api/v0/.groups", groupname,
-			".users", username,
-		)
-	}
+	u, err := targetUserURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
+	}
+
+	if purge {
+		u += "?purge=1"
 	}
 
 	err = deleteValue(u)
 	if err != nil {
-		log.Fatalf("Delete user: %v", err)
+		fatalf("Delete user: %v", err)
 	}
 }
 
-func showGroupCmd(cmdname string, args []string) {
-	var groupname string
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
-	setUsage(cmd, cmdname,
-		"%v [option...] %v\n",
+func showUserCmd(cmdname string, args []string) {
+	var groupname, username string
+	var wildcard, global, doJSON bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
 	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.StringVar(&username, "user", "", "user `name`")
+	cmd.BoolVar(&wildcard, "wildcard", false, "show the wildcard user")
+	cmd.BoolVar(&global, "global", false, "show a global user")
+	cmd.BoolVar(&doJSON, "json", false, "output JSON")
 	cmd.Parse(args)
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
-	if groupname == "" {
-		log.Fatal("Option \"-group\" is required.")
-	}
+	checkUserOptions(cmd, global, wildcard, groupname, username)
 
-	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/", groupname)
+	u, err := targetUserURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
-
-	var description map[string]any
-	_, err = getJSON(u, &description)
+	var user map[string]any
+	_, err = getJSON(u, &user)
 	if err != nil {
-		log.Fatalf("Get group description: %v", err)
+		fatalf("Get user: %v", err)
 	}
-	encoder := json.NewEncoder(os.Stdout)
-	encoder.SetIndent("", "    ")
-	err = encoder.Encode(&description)
+
+	// the server never returns the password itself, only its type
+	pu, err := passwordURL(global, wildcard, groupname, username)
 	if err != nil {
-		log.Fatalf("Encode: %v", err)
+		fatalf("Build URL: %v", err)
+	}
+	var password map[string]any
+	_, err = getJSON(pu, &password)
+	if err == nil {
+		user["password"] = password
+	} else if !isNotFound(err) {
+		fatalf("Get password: %v", err)
 	}
-}
 
-func listGroupsCmd(cmdname string, args []string) {
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
-	setUsage(cmd, cmdname,
-		"%v [option...] %v [pattern...]\n",
+	if doJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(user)
+		if err != nil {
+			fatalf("Encode: %v", err)
+		}
+		return
+	}
+
+	buf, err := json.Marshal(user)
+	if err != nil {
+		fatalf("Encode: %v", err)
+	}
+	var d group.UserDescription
+	err = json.Unmarshal(buf, &d)
+	if err != nil {
+		fatalf("Decode: %v", err)
+	}
+	if wildcard {
+		username = "(wildcard)"
+	}
+	printUser(os.Stdout, username, &d)
+}
+
+func printUser(w io.Writer, username string, user *group.UserDescription) {
+	fmt.Fprintf(w, "Username:    %v\n", username)
+	fmt.Fprintf(w, "Permissions: %v\n", formatPermissions(user.Permissions))
+	if user.Password.Type == "" {
+		fmt.Fprintf(w, "Password:    (none)\n")
+	} else {
+		fmt.Fprintf(w, "Password:    set (%v)\n", user.Password.Type)
+	}
+}
+
+func showGroupCmd(cmdname string, args []string) {
+	var groupname string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v\n",
+		os.Args[0], cmdname,
+	)
+	var expand bool
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.BoolVar(&expand, "expand", false,
+		"include inherited fields")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fatalf("Option \"-group\" is required.")
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/", groupname)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+	if expand {
+		u += "?expand=1"
+	}
+
+	var description map[string]any
+	_, err = getJSON(u, &description)
+	if err != nil {
+		fatalf("Get group description: %v", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(&description)
+	if err != nil {
+		fatalf("Encode: %v", err)
+	}
+}
+
+// A difference between two JSON values, as computed by diffJSON.
+type jsonDiff struct {
+	op       byte // '-' (removed), '+' (added) or '~' (changed)
+	key      string
+	old, new any
+}
+
+// diffJSON computes the differences between two JSON values, recursing
+// into dictionaries.  Arrays and scalars are compared as a whole.
+func diffJSON(prefix string, old, new any) []jsonDiff {
+	om, ok1 := old.(map[string]any)
+	nm, ok2 := new.(map[string]any)
+	if !ok1 || !ok2 {
+		if reflect.DeepEqual(old, new) {
+			return nil
+		}
+		return []jsonDiff{{'~', prefix, old, new}}
+	}
+
+	keys := make([]string, 0, len(om)+len(nm))
+	for k := range om {
+		keys = append(keys, k)
+	}
+	for k := range nm {
+		if _, ok := om[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []jsonDiff
+	for _, k := range keys {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		o, ok1 := om[k]
+		n, ok2 := nm[k]
+		if !ok2 {
+			diffs = append(diffs, jsonDiff{'-', key, o, nil})
+		} else if !ok1 {
+			diffs = append(diffs, jsonDiff{'+', key, nil, n})
+		} else {
+			diffs = append(diffs, diffJSON(key, o, n)...)
+		}
+	}
+	return diffs
+}
+
+func formatJSON(v any) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(buf)
+}
+
+func diffGroupCmd(cmdname string, args []string) {
+	var groupname string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...] file\n",
 		os.Args[0], cmdname,
 	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	f, err := os.Open(cmd.Arg(0))
+	if err != nil {
+		fatalf("Open: %v", err)
+	}
+	var local map[string]any
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&local)
+	f.Close()
+	if err != nil {
+		fatalf("Decode %v: %v", cmd.Arg(0), err)
+	}
+	// the server only provides sanitised descriptions
+	delete(local, "users")
+	delete(local, "wildcard-user")
+	delete(local, "authKeys")
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/", groupname)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	var remote map[string]any
+	_, err = getJSON(u, &remote)
+	if err != nil {
+		fatalf("Get group description: %v", err)
+	}
+
+	diffs := diffJSON("", remote, local)
+	for _, d := range diffs {
+		switch d.op {
+		case '-':
+			fmt.Printf("- %v: %v\n", d.key, formatJSON(d.old))
+		case '+':
+			fmt.Printf("+ %v: %v\n", d.key, formatJSON(d.new))
+		default:
+			fmt.Printf("~ %v: %v -> %v\n",
+				d.key, formatJSON(d.old), formatJSON(d.new),
+			)
+		}
+	}
+	if len(diffs) > 0 {
+		exit(1)
+	}
+}
+
+type groupStatus struct {
+	Name         string `json:"name"`
+	Instantiated bool   `json:"instantiated"`
+	Clients      int    `json:"clients"`
+	Locked       bool   `json:"locked"`
+	Draining     bool   `json:"draining"`
+	Recording    bool   `json:"recording"`
+}
+
+func formatGroupStatus(g groupStatus) string {
+	state := "idle"
+	if g.Instantiated {
+		state = "running"
+	}
+	var flags []string
+	if g.Locked {
+		flags = append(flags, "locked")
+	}
+	if g.Draining {
+		flags = append(flags, "draining")
+	}
+	if g.Recording {
+		flags = append(flags, "recording")
+	}
+	return strings.TrimRight(fmt.Sprintf("%-32s %-8s %5d %v",
+		g.Name, state, g.Clients, strings.Join(flags, ","),
+	), " ")
+}
+
+func listGroupsCmd(cmdname string, args []string) {
+	var long bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...] [pattern...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.BoolVar(&long, "l", false,
+		"show the number of clients and the status of each group")
 	cmd.Parse(args)
 	patterns := cmd.Args()
 
 	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/")
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
-	var groups []string
-	_, err = getJSON(u, &groups)
+	var groups []groupStatus
+	if long {
+		_, err = getJSON(u+"?status=1", &groups)
+	} else {
+		var names []string
+		_, err = getJSON(u, &names)
+		for _, name := range names {
+			groups = append(groups, groupStatus{Name: name})
+		}
+	}
 	if err != nil {
-		log.Fatalf("Get groups: %v", err)
+		fatalf("Get groups: %v", err)
 	}
 	sort.Slice(groups, func(i, j int) bool {
-		return groups[i] < groups[j]
+		return groups[i].Name < groups[j].Name
 	})
 	for _, g := range groups {
 		if len(patterns) > 0 {
-			found, err := match(patterns, g)
+			found, err := match(patterns, g.Name)
 			if err != nil {
-				log.Fatalf("Match: %v", err)
+				fatalf("Match: %v", err)
 			}
 			if !found {
 				continue
 			}
 		}
-		fmt.Println(g)
+		if long {
+			fmt.Println(formatGroupStatus(g))
+		} else {
+			fmt.Println(g.Name)
+		}
+	}
+}
+
+func listClientsCmd(cmdname string, args []string) {
+	var groupname string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(
+		serverURL, "/galene-api/v0/.groups/", groupname, ".clients/",
+	)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	var clients []struct {
+		Id          string   `json:"id"`
+		Username    string   `json:"username"`
+		Permissions []string `json:"permissions"`
+		Type        string   `json:"type"`
+		Address     string   `json:"address"`
+	}
+	_, err = getJSON(u, &clients)
+	if err != nil {
+		fatalf("Get clients: %v", err)
+	}
+	for _, c := range clients {
+		fmt.Printf("%-32s %-16s %-8s %-10s %v\n",
+			c.Id, c.Username,
+			formatRawPermissions(c.Permissions),
+			c.Type, c.Address,
+		)
+	}
+}
+
+func announceCmd(cmdname string, args []string) {
+	var channel string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...] message...\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&channel, "channel", "", "channel `name`")
+	cmd.Parse(args)
+
+	if cmd.NArg() == 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if channel == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-channel\" is required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(
+		serverURL, "/galene-api/v0/.announce/", channel,
+	)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	_, err = postJSON(u, map[string]any{
+		"message": strings.Join(cmd.Args(), " "),
+	})
+	if err != nil {
+		fatalf("Announce: %v", err)
+	}
+}
+
+func promoteCmd(cmdname string, args []string) {
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.replica/.promote")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	_, err = postJSON(u, nil)
+	if err != nil {
+		fatalf("Promote: %v", err)
+	}
+}
+
+func reloadCmd(cmdname string, args []string) {
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.reload")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	_, err = postJSON(u, nil)
+	if err != nil {
+		fatalf("Reload: %v", err)
 	}
 }
 
 func listTokensCmd(cmdname string, args []string) {
 	var groupname stringOption
-	var long bool
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	var long, csv bool
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
 	cmd.Var(&groupname, "group", "group `name`")
 	cmd.BoolVar(&long, "l", false, "display token fields")
+	cmd.BoolVar(&csv, "csv", false,
+		"export tokens that have not expired as CSV")
 	cmd.Parse(args)
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if !groupname.set {
 		fmt.Fprintf(cmd.Output(),
 			"Option \"-group\" is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
@@ -1361,13 +2009,21 @@ func listTokensCmd(cmdname string, args []string) {
 	)
 
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
+	}
+
+	if csv {
+		err := getCopy(u+"?format=csv", os.Stdout)
+		if err != nil {
+			fatalf("Get tokens: %v", err)
+		}
+		return
 	}
 
 	var tokens []string
 	_, err = getJSON(u, &tokens)
 	if err != nil {
-		log.Fatalf("Get tokens: %v", err)
+		fatalf("Get tokens: %v", err)
 	}
 	sort.Slice(tokens, func(i, j int) bool {
 		return tokens[i] < tokens[j]
@@ -1421,63 +2077,351 @@ func listTokensCmd(cmdname string, args []string) {
 	}
 }
 
+func showTokenCmd(cmdname string, args []string) {
+	var groupname stringOption
+	var tok string
+	var doJSON bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Var(&groupname, "group", "group `name`")
+	cmd.StringVar(&tok, "token", "", "`token` to show")
+	cmd.BoolVar(&doJSON, "json", false, "output JSON")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if !groupname.set || tok == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Options \"-group\" and \"-token\" are required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(
+		serverURL, "/galene-api/v0/.groups/", groupname.value,
+		".tokens", tok,
+	)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	if doJSON {
+		var t map[string]any
+		_, err = getJSON(u, &t)
+		if err != nil {
+			fatalf("Get token: %v", err)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(t)
+		if err != nil {
+			fatalf("Encode: %v", err)
+		}
+		return
+	}
+
+	var t token.Stateful
+	_, err = getJSON(u, &t)
+	if err != nil {
+		fatalf("Get token: %v", err)
+	}
+	printStatefulToken(os.Stdout, &t, time.Now())
+}
+
+func printStatefulToken(w io.Writer, t *token.Stateful, now time.Time) {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "(none)"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	fmt.Fprintf(w, "Token:       %v\n", t.Token)
+	if t.IncludeSubgroups {
+		fmt.Fprintf(w, "Group:       %v (and subgroups)\n", t.Group)
+	} else {
+		fmt.Fprintf(w, "Group:       %v\n", t.Group)
+	}
+	if t.Username != nil {
+		fmt.Fprintf(w, "Username:    %v\n", *t.Username)
+	}
+	fmt.Fprintf(w, "Permissions: %v\n", strings.Join(t.Permissions, ", "))
+	if t.NotBefore != nil {
+		fmt.Fprintf(w, "Not before:  %v\n", formatTime(t.NotBefore))
+	}
+	if t.Expires != nil && t.Expires.Before(now) {
+		fmt.Fprintf(w, "Expires:     %v (expired)\n",
+			formatTime(t.Expires))
+	} else {
+		fmt.Fprintf(w, "Expires:     %v\n", formatTime(t.Expires))
+	}
+	if t.IssuedBy != nil {
+		fmt.Fprintf(w, "Issued by:   %v\n", *t.IssuedBy)
+	}
+	if t.IssuedAt != nil {
+		fmt.Fprintf(w, "Issued at:   %v\n", formatTime(t.IssuedAt))
+	}
+	if l := t.Limits; l != nil {
+		if l.AudioOnly {
+			fmt.Fprintf(w, "Audio only:  yes\n")
+		}
+		if l.MaxTracks > 0 {
+			fmt.Fprintf(w, "Max tracks:  %v\n", l.MaxTracks)
+		}
+		if l.MaxBitrate > 0 {
+			fmt.Fprintf(w, "Max bitrate: %v\n", l.MaxBitrate)
+		}
+	}
+}
+
+// limitFlags adds options that set the limits carried by a token.
+func limitFlags(cmd *flag.FlagSet, limits *token.Limits) {
+	cmd.Uint64Var(&limits.MaxBitrate, "max-bitrate", 0,
+		"maximum `bitrate` in bits per second")
+	cmd.IntVar(&limits.MaxTracks, "max-tracks", 0,
+		"maximum `number` of tracks")
+	cmd.BoolVar(&limits.AudioOnly, "audio-only", false,
+		"only allow publishing audio")
+}
+
 func createTokenCmd(cmdname string, args []string) {
 	var groupname stringOption
-	var username, permissions string
+	var username, permissions, template string
 	var includeSubgroups boolOption
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	var expires, notBefore timeOption
+	var count int
+	var limits token.Limits
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
 	cmd.Var(&groupname, "group", "group `name`")
 	cmd.Var(&includeSubgroups, "include-subgroups", "include subgroups")
+	cmd.Var(&expires, "expires",
+		"expiry `time`, a duration or an RFC 3339 time (default 24h)")
+	cmd.Var(&notBefore, "not-before",
+		"start of validity `time`, a duration or an RFC 3339 time")
+	cmd.IntVar(&count, "count", 1, "`number` of tokens to create")
 	cmd.StringVar(&username, "user", "", "encode user `name` in token")
 	cmd.StringVar(&permissions, "permissions", "present", "permissions")
+	cmd.StringVar(&template, "template", "",
+		"use defaults from token template `name`")
+	limitFlags(cmd, &limits)
 	cmd.Parse(args)
 
+	permissionsSet := false
+	cmd.Visit(func(f *flag.Flag) {
+		if f.Name == "permissions" {
+			permissionsSet = true
+		}
+	})
+
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if !groupname.set {
 		fmt.Fprintf(cmd.Output(),
 			"Option \"-group\" is required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
-	perms, err := parsePermissions(permissions, true)
-	if err != nil {
-		log.Fatalf("Parse permissions: %v", err)
+	if count < 1 {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-count\" must be positive\n")
+		exit(1)
 	}
+
+	if expires.set && notBefore.set &&
+		!expires.value.After(notBefore.value) {
+		fmt.Fprintf(cmd.Output(),
+			"Token would expire before it becomes valid\n")
+		exit(1)
+	}
+
 	t := make(map[string]any)
-	t["permissions"] = perms
-	t["expires"] = time.Now().Add(24 * time.Hour)
+	// when using a template, only send the values that were given
+	// explicitly, so that the server fills in the rest
+	if template == "" || permissionsSet {
+		perms, err := parsePermissions(permissions, true)
+		if err != nil {
+			fatalf("Parse permissions: %v", err)
+		}
+		t["permissions"] = perms
+	}
+	if expires.set {
+		t["expires"] = expires.value
+	} else if template == "" {
+		t["expires"] = time.Now().Add(24 * time.Hour)
+	}
+	if notBefore.set {
+		t["not-before"] = notBefore.value
+	}
 	if username != "" {
 		t["username"] = username
 	}
 	if includeSubgroups.set {
 		t["includeSubgroups"] = includeSubgroups.value
 	}
+	if limits != (token.Limits{}) {
+		t["limits"] = limits
+	}
 
 	u, err := url.JoinPath(
 		serverURL, "/galene-api/v0/.groups/", groupname.value, ".tokens/",
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
+	}
+	if template != "" {
+		u += "?template=" + url.QueryEscape(template)
+	}
+
+	for i := 0; i < count; i++ {
+		location, err := postJSON(u, t)
+		if err != nil {
+			fatalf("Create token: %v", err)
+		}
+		fmt.Println(location)
+	}
+}
+
+// readKeys reads a file containing either a single JWK, a JWK set, or
+// a group definition, and returns the keys that it contains.
+func readKeys(filename string) ([]map[string]any, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data map[string]any
+	decoder := json.NewDecoder(f)
+	err = decoder.Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []any
+	if k, ok := data["keys"].([]any); ok {
+		keys = k
+	} else if k, ok := data["authKeys"].([]any); ok {
+		keys = k
+	} else if _, ok := data["kty"]; ok {
+		return []map[string]any{data}, nil
+	} else {
+		return nil, errors.New("no keys found")
+	}
+
+	result := make([]map[string]any, 0, len(keys))
+	for _, k := range keys {
+		kk, ok := k.(map[string]any)
+		if !ok {
+			return nil, errors.New("bad key")
+		}
+		result = append(result, kk)
+	}
+	return result, nil
+}
+
+func signTokenCmd(cmdname string, args []string) {
+	var groupname, username, permissions, keyfile, kid string
+	var expires time.Duration
+	var limits token.Limits
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	cmd.StringVar(&username, "user", "", "encode user `name` in token")
+	cmd.StringVar(&permissions, "permissions", "present", "permissions")
+	cmd.StringVar(&keyfile, "key", "",
+		"`file` containing a key, a key set or a group definition",
+	)
+	cmd.StringVar(&kid, "kid", "", "key `id` of the signing key")
+	cmd.DurationVar(&expires, "expires", 24*time.Hour,
+		"token validity `duration`",
+	)
+	limitFlags(cmd, &limits)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-group\" is required\n")
+		exit(1)
+	}
+
+	if keyfile == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Option \"-key\" is required\n")
+		exit(1)
+	}
+
+	keys, err := readKeys(keyfile)
+	if err != nil {
+		fatalf("Read keys: %v", err)
+	}
+
+	var key map[string]any
+	for _, k := range keys {
+		if kid != "" && k["kid"] != kid {
+			continue
+		}
+		// we need a symmetric key or a private key
+		if k["kty"] == "oct" || k["d"] != nil {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		fatalf("No suitable signing key found")
+	}
+
+	perms, err := parsePermissions(permissions, true)
+	if err != nil {
+		fatalf("Parse permissions: %v", err)
 	}
 
-	location, err := postJSON(u, t)
+	aud, err := url.JoinPath(serverURL, "/group/", groupname)
 	if err != nil {
-		log.Fatalf("Create token: %v", err)
+		fatalf("Build URL: %v", err)
 	}
-	fmt.Println(location)
+
+	now := time.Now()
+	claims := map[string]any{
+		"aud":         aud + "/",
+		"permissions": perms,
+		"iat":         now.Unix(),
+		"exp":         now.Add(expires).Unix(),
+	}
+	if username != "" {
+		claims["sub"] = username
+	}
+	if limits != (token.Limits{}) {
+		claims["limits"] = limits
+	}
+
+	t, err := token.SignJWT(key, claims)
+	if err != nil {
+		fatalf("Sign token: %v", err)
+	}
+	fmt.Println(t)
 }
 
 func revokeTokenCmd(cmdname string, args []string) {
 	var groupname stringOption
 	var token string
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
@@ -1487,13 +2431,13 @@ func revokeTokenCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if !groupname.set || token == "" {
 		fmt.Fprintf(cmd.Output(),
 			"Options \"-group\" and \"-token\" are required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
@@ -1501,7 +2445,7 @@ func revokeTokenCmd(cmdname string, args []string) {
 		".tokens", token,
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 
 	err = updateJSON(u, func(v map[string]any) map[string]any {
@@ -1509,14 +2453,14 @@ func revokeTokenCmd(cmdname string, args []string) {
 		return v
 	})
 	if err != nil {
-		log.Fatalf("Update token: %v", err)
+		fatalf("Update token: %v", err)
 	}
 }
 
 func deleteTokenCmd(cmdname string, args []string) {
 	var groupname stringOption
 	var token string
-	cmd := flag.NewFlagSet(cmdname, flag.ExitOnError)
+	cmd := newFlagSet(cmdname)
 	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
 		os.Args[0], cmdname,
 	)
@@ -1526,13 +2470,13 @@ func deleteTokenCmd(cmdname string, args []string) {
 
 	if cmd.NArg() != 0 {
 		cmd.Usage()
-		os.Exit(1)
+		exit(1)
 	}
 
 	if !groupname.set || token == "" {
 		fmt.Fprintf(cmd.Output(),
 			"Options \"-group\" and \"-token\" are required\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	u, err := url.JoinPath(
@@ -1540,10 +2484,125 @@ func deleteTokenCmd(cmdname string, args []string) {
 		".tokens", token,
 	)
 	if err != nil {
-		log.Fatalf("Build URL: %v", err)
+		fatalf("Build URL: %v", err)
 	}
 	err = deleteValue(u)
 	if err != nil {
-		log.Fatalf("Delete token: %v", err)
+		fatalf("Delete token: %v", err)
+	}
+}
+
+func expireTokensCmd(cmdname string, args []string) {
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.expire-tokens")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	var reply struct {
+		Expired int `json:"expired"`
+	}
+	err = queryJSON(u, nil, &reply)
+	if err != nil {
+		fatalf("Expire tokens: %v", err)
+	}
+	fmt.Printf("Removed %v expired tokens\n", reply.Expired)
+}
+
+func checkTokenCmd(cmdname string, args []string) {
+	var tok string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&tok, "token", "", "`token` to check")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if tok == "" {
+		fmt.Fprintf(cmd.Output(), "Option \"-token\" is required\n")
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.introspect")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	var info group.TokenInfo
+	err = queryJSON(u, map[string]string{"token": tok}, &info)
+	if err != nil {
+		fatalf("Check token: %v", err)
+	}
+	printTokenInfo(os.Stdout, &info)
+	if !info.Valid {
+		exit(1)
+	}
+}
+
+func printTokenInfo(w io.Writer, info *group.TokenInfo) {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "(none)"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	if info.Valid {
+		fmt.Fprintf(w, "Valid:       yes\n")
+	} else {
+		fmt.Fprintf(w, "Valid:       no (%v)\n", info.Error)
+	}
+	if info.Type == "" {
+		return
+	}
+	fmt.Fprintf(w, "Type:        %v\n", info.Type)
+	if info.IncludeSubgroups {
+		fmt.Fprintf(w, "Group:       %v (and subgroups)\n", info.Group)
+	} else {
+		fmt.Fprintf(w, "Group:       %v\n", info.Group)
+	}
+	if info.Username != nil {
+		fmt.Fprintf(w, "Username:    %v\n", *info.Username)
+	}
+	if info.Permissions != nil {
+		fmt.Fprintf(w, "Permissions: %v\n",
+			strings.Join(info.Permissions, ", "),
+		)
+	}
+	if info.NotBefore != nil {
+		fmt.Fprintf(w, "Not before:  %v\n", formatTime(info.NotBefore))
+	}
+	fmt.Fprintf(w, "Expires:     %v\n", formatTime(info.Expires))
+	if info.IssuedBy != nil {
+		fmt.Fprintf(w, "Issued by:   %v\n", *info.IssuedBy)
+	}
+	if info.IssuedAt != nil {
+		fmt.Fprintf(w, "Issued at:   %v\n", formatTime(info.IssuedAt))
+	}
+	if l := info.Limits; l != nil {
+		if l.AudioOnly {
+			fmt.Fprintf(w, "Audio only:  yes\n")
+		}
+		if l.MaxTracks > 0 {
+			fmt.Fprintf(w, "Max tracks:  %v\n", l.MaxTracks)
+		}
+		if l.MaxBitrate > 0 {
+			fmt.Fprintf(w, "Max bitrate: %v\n", l.MaxBitrate)
+		}
 	}
 }
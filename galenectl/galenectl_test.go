@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
 )
 
 func TestMakePassword(t *testing.T) {
@@ -18,19 +25,25 @@ func TestMakePassword(t *testing.T) {
 			t.Errorf("%v did match", pw)
 		}
 	}
-	pw, err := makePassword("secret", "pbkdf2", 4096, 32, 8, 0)
+	pw, err := makePassword("secret", "pbkdf2", 4096, 32, 8, 0, 0, 0)
 	if err != nil {
 		t.Errorf("PBKDF2: %v", err)
 	}
 	doit(pw)
 
-	pw, err = makePassword("secret", "bcrypt", 0, 0, 0, 10)
+	pw, err = makePassword("secret", "bcrypt", 0, 0, 0, 10, 0, 0)
 	if err != nil {
 		t.Errorf("bcrypt: %v", err)
 	}
 	doit(pw)
 
-	pw, err = makePassword("", "wildcard", 0, 0, 0, 0)
+	pw, err = makePassword("secret", "argon2id", 2, 32, 16, 0, 1024, 1)
+	if err != nil {
+		t.Errorf("argon2id: %v", err)
+	}
+	doit(pw)
+
+	pw, err = makePassword("", "wildcard", 0, 0, 0, 0, 0, 0)
 	if err != nil {
 		t.Errorf("Wildcard: %v", err)
 	}
@@ -66,3 +79,197 @@ func TestFormatPermissions(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatGroupStatus(t *testing.T) {
+	tests := []struct {
+		status groupStatus
+		result string
+	}{
+		{
+			groupStatus{Name: "test"},
+			fmt.Sprintf("%-32s idle         0", "test"),
+		},
+		{
+			groupStatus{
+				Name: "test", Instantiated: true, Clients: 3,
+				Locked: true, Recording: true,
+			},
+			fmt.Sprintf("%-32s running      3 locked,recording", "test"),
+		},
+		{
+			groupStatus{
+				Name: "test", Instantiated: true, Clients: 1,
+				Draining: true,
+			},
+			fmt.Sprintf("%-32s running      1 draining", "test"),
+		},
+	}
+	for _, test := range tests {
+		result := formatGroupStatus(test.status)
+		if result != test.result {
+			t.Errorf("Expected %q, got %q", test.result, result)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct{ old, new, diff string }{
+		{`{}`, `{}`, ""},
+		{`{"public": true}`, `{"public": true}`, ""},
+		{`{"public": true}`, `{}`, "-public "},
+		{`{}`, `{"public": true}`, "+public "},
+		{`{"public": true}`, `{"public": false}`, "~public "},
+		{`{"codecs": ["vp8"]}`, `{"codecs": ["vp8", "opus"]}`,
+			"~codecs "},
+		{`{"a": {"b": 1, "c": 2}}`, `{"a": {"b": 1, "d": 2}}`,
+			"-a.c +a.d "},
+		{`{"b": 1, "a": 2}`, `{"a": 1}`, "~a -b "},
+	}
+	for _, test := range tests {
+		var old, new map[string]any
+		err := json.Unmarshal([]byte(test.old), &old)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		err = json.Unmarshal([]byte(test.new), &new)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		diff := ""
+		for _, d := range diffJSON("", old, new) {
+			diff += string(d.op) + d.key + " "
+		}
+		if diff != test.diff {
+			t.Errorf("Diff %v %v: expected %#v, got %#v",
+				test.old, test.new, test.diff, diff)
+		}
+	}
+}
+
+func TestReadKeys(t *testing.T) {
+	key := `{"kty": "oct", "alg": "HS256", "k": "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ"}`
+	tests := []struct {
+		contents string
+		count    int
+	}{
+		{key, 1},
+		{`{"keys": [` + key + `, ` + key + `]}`, 2},
+		{`{"public": true, "authKeys": [` + key + `]}`, 1},
+		{`{"public": true}`, -1},
+	}
+	filename := filepath.Join(t.TempDir(), "keys.json")
+	for _, test := range tests {
+		err := os.WriteFile(filename, []byte(test.contents), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		keys, err := readKeys(filename)
+		if test.count < 0 {
+			if err == nil {
+				t.Errorf("readKeys %v succeeded", test.contents)
+			}
+			continue
+		}
+		if err != nil || len(keys) != test.count {
+			t.Errorf("readKeys %v: %v %v", test.contents, keys, err)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		result time.Time
+	}{
+		{"2h", now.Add(2 * time.Hour)},
+		{"-30m", now.Add(-30 * time.Minute)},
+		{"2025-07-01T08:00:00Z", time.Date(2025, 7, 1, 8, 0, 0, 0, time.UTC)},
+		{"tomorrow", time.Time{}},
+		{"2025-07-01", time.Time{}},
+	}
+	for _, test := range tests {
+		result, err := parseTime(test.value, now)
+		if test.result.IsZero() {
+			if err == nil {
+				t.Errorf("parseTime %v succeeded", test.value)
+			}
+			continue
+		}
+		if err != nil || !result.Equal(test.result) {
+			t.Errorf("parseTime %v: expected %v, got %v %v",
+				test.value, test.result, result, err)
+		}
+	}
+}
+
+func TestPrintUser(t *testing.T) {
+	var user group.UserDescription
+	err := json.Unmarshal(
+		[]byte(`{"permissions": "op", "password": {"type": "bcrypt"}}`),
+		&user,
+	)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	var buf bytes.Buffer
+	printUser(&buf, "bob", &user)
+	expected := "Username:    bob\n" +
+		"Permissions: op\n" +
+		"Password:    set (bcrypt)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	user.Password = group.Password{}
+	buf.Reset()
+	printUser(&buf, "bob", &user)
+	if !strings.Contains(buf.String(), "Password:    (none)\n") {
+		t.Errorf("Bad output %q", buf.String())
+	}
+}
+
+func TestPrintStatefulToken(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(-time.Hour)
+	by := "admin"
+	tok := token.Stateful{
+		Token:       "abc",
+		Group:       "test",
+		Permissions: []string{"present", "message"},
+		Expires:     &expires,
+		IssuedBy:    &by,
+	}
+	var buf bytes.Buffer
+	printStatefulToken(&buf, &tok, now)
+	for _, s := range []string{
+		"Token:       abc\n",
+		"Group:       test\n",
+		"Permissions: present, message\n",
+		"(expired)\n",
+		"Issued by:   admin\n",
+	} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("%q not in %q", s, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "Username:") {
+		t.Errorf("Unexpected username in %q", buf.String())
+	}
+}
+
+func TestCountClients(t *testing.T) {
+	clients := []drainClient{
+		{Id: "a", Type: "websocket", Permissions: []string{"present"}},
+		{Id: "b", Type: "disk", Permissions: []string{"system"}},
+		{Id: "c", Type: "whip", Permissions: []string{"present"}},
+		{Id: "d", Type: "rtsp", Permissions: []string{"system"}},
+		{Id: "e", Type: "cascade", Permissions: []string{"system"}},
+	}
+	if n := countClients(clients); n != 2 {
+		t.Errorf("Expected 2, got %v", n)
+	}
+	if n := countClients(clients[1:2]); n != 0 {
+		t.Errorf("Expected 0, got %v", n)
+	}
+}
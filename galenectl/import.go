@@ -0,0 +1,627 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jech/galene/group"
+)
+
+// The import command converts the rooms and users of other conferencing
+// platforms into group definitions in the on-disk format, which can then
+// be reviewed and uploaded with the apply command.
+
+// An importedGroup is a group definition produced by an importer.
+type importedGroup struct {
+	name string
+	desc *group.Description
+}
+
+// importPassword hashes a password found in an export, using the same
+// defaults as set-password.
+var importPassword = func(pw string) (group.Password, error) {
+	return makePassword(pw, "bcrypt", 0, 0, 0, 8, 0, 0)
+}
+
+// importGroupName converts a room name into a group name.
+func importGroupName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return strings.TrimLeft(b.String(), ".")
+}
+
+func mustPermissions(name string) group.Permissions {
+	p, err := group.NewPermissions(name)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// roomPassword returns the wildcard user of a room protected by
+// a shared password, or of an open room if pw is empty.
+func roomPassword(pw string) (*group.UserDescription, error) {
+	if pw == "" {
+		return &group.UserDescription{
+			Password:    group.Password{Type: "wildcard"},
+			Permissions: mustPermissions("present"),
+		}, nil
+	}
+	p, err := importPassword(pw)
+	if err != nil {
+		return nil, err
+	}
+	return &group.UserDescription{
+		Password:    p,
+		Permissions: mustPermissions("present"),
+	}, nil
+}
+
+// BigBlueButton
+
+type bbbMeeting struct {
+	MeetingID   string `xml:"meetingID"`
+	MeetingName string `xml:"meetingName"`
+	AttendeePW  string `xml:"attendeePW"`
+	ModeratorPW string `xml:"moderatorPW"`
+}
+
+type bbbResponse struct {
+	ReturnCode string       `xml:"returncode"`
+	Meetings   []bbbMeeting `xml:"meetings>meeting"`
+}
+
+// importBBB converts the reply to a BigBlueButton getMeetings API call.
+// BigBlueButton meetings have a password for attendees, which becomes
+// the password of the wildcard user, and a password for moderators,
+// which becomes that of the operator "moderator".
+func importBBB(r io.Reader) ([]importedGroup, []string, error) {
+	var resp bbbResponse
+	err := xml.NewDecoder(r).Decode(&resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.ReturnCode != "SUCCESS" {
+		return nil, nil, errors.New("BigBlueButton returned " +
+			strconv.Quote(resp.ReturnCode))
+	}
+
+	var groups []importedGroup
+	var warnings []string
+	for _, m := range resp.Meetings {
+		name := importGroupName(m.MeetingID)
+		if name == "" {
+			warnings = append(warnings, fmt.Sprintf(
+				"meeting %q: bad meeting id", m.MeetingName,
+			))
+			continue
+		}
+		desc := &group.Description{
+			DisplayName: m.MeetingName,
+		}
+		desc.WildcardUser, err = roomPassword(m.AttendeePW)
+		if err != nil {
+			return nil, nil, err
+		}
+		if m.ModeratorPW != "" {
+			p, err := importPassword(m.ModeratorPW)
+			if err != nil {
+				return nil, nil, err
+			}
+			desc.Users = map[string]group.UserDescription{
+				"moderator": {
+					Password:    p,
+					Permissions: mustPermissions("op"),
+				},
+			}
+		}
+		groups = append(groups, importedGroup{name, desc})
+	}
+	return groups, warnings, nil
+}
+
+// Jitsi
+
+// parseLua parses the Lua data files written by Prosody, the XMPP server
+// used by Jitsi Meet, which consist of a single return statement.
+// Tables are returned as maps indexed by strings.
+func parseLua(data []byte) (any, error) {
+	p := luaParser{data: data}
+	p.skipSpace()
+	if !p.keyword("return") {
+		return nil, errors.New("expected return statement")
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ';' {
+		p.pos++
+		p.skipSpace()
+	}
+	if p.pos < len(p.data) {
+		return nil, p.error("trailing garbage")
+	}
+	return v, nil
+}
+
+type luaParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *luaParser) error(message string) error {
+	return fmt.Errorf("offset %v: %v", p.pos, message)
+}
+
+func (p *luaParser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			p.pos++
+		} else if c == '-' && p.pos+1 < len(p.data) &&
+			p.data[p.pos+1] == '-' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+func isLuaIdent(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+func (p *luaParser) keyword(k string) bool {
+	end := p.pos + len(k)
+	if end > len(p.data) || string(p.data[p.pos:end]) != k {
+		return false
+	}
+	if end < len(p.data) && isLuaIdent(p.data[end]) {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *luaParser) value() (any, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, p.error("unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.table()
+	case c == '"' || c == '\'':
+		return p.string()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case p.keyword("true"):
+		return true, nil
+	case p.keyword("false"):
+		return false, nil
+	case p.keyword("nil"):
+		return nil, nil
+	default:
+		return nil, p.error("unexpected character")
+	}
+}
+
+func (p *luaParser) number() (any, error) {
+	start := p.pos
+	for p.pos < len(p.data) &&
+		strings.IndexByte("+-.0123456789eExX", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(string(p.data[start:p.pos]), 64)
+	if err != nil {
+		return nil, p.error("bad number")
+	}
+	return f, nil
+}
+
+func (p *luaParser) string() (string, error) {
+	quote := p.data[p.pos]
+	p.pos++
+	var b []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == quote {
+			return string(b), nil
+		}
+		if c != '\\' {
+			b = append(b, c)
+			continue
+		}
+		if p.pos >= len(p.data) {
+			break
+		}
+		c = p.data[p.pos]
+		p.pos++
+		switch c {
+		case 'n', '\n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			n := int(c - '0')
+			for i := 0; i < 2 && p.pos < len(p.data) &&
+				p.data[p.pos] >= '0' && p.data[p.pos] <= '9'; i++ {
+				n = n*10 + int(p.data[p.pos]-'0')
+				p.pos++
+			}
+			if n > 255 {
+				return "", p.error("bad escape")
+			}
+			b = append(b, byte(n))
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", p.error("unterminated string")
+}
+
+func (p *luaParser) table() (map[string]any, error) {
+	p.pos++
+	t := make(map[string]any)
+	index := 1
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, p.error("unterminated table")
+		}
+		if p.data[p.pos] == '}' {
+			p.pos++
+			return t, nil
+		}
+
+		var key string
+		if p.data[p.pos] == '[' {
+			p.pos++
+			k, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			switch k := k.(type) {
+			case string:
+				key = k
+			case float64:
+				key = strconv.FormatFloat(k, 'g', -1, 64)
+			default:
+				return nil, p.error("unsupported key")
+			}
+			p.skipSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] != ']' {
+				return nil, p.error("expected ]")
+			}
+			p.pos++
+			p.skipSpace()
+			if p.pos >= len(p.data) || p.data[p.pos] != '=' {
+				return nil, p.error("expected =")
+			}
+			p.pos++
+		} else if start := p.pos; isLuaIdent(p.data[p.pos]) &&
+			!(p.data[p.pos] >= '0' && p.data[p.pos] <= '9') {
+			for p.pos < len(p.data) && isLuaIdent(p.data[p.pos]) {
+				p.pos++
+			}
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == '=' {
+				key = string(p.data[start:p.pos])
+				key = strings.TrimSpace(key)
+				p.pos++
+			} else {
+				p.pos = start
+			}
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			key = strconv.Itoa(index)
+			index++
+		}
+		t[key] = v
+
+		p.skipSpace()
+		if p.pos < len(p.data) &&
+			(p.data[p.pos] == ',' || p.data[p.pos] == ';') {
+			p.pos++
+		}
+	}
+}
+
+func readLuaFile(filename string) (map[string]any, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseLua(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+	t, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%v: not a table", filename)
+	}
+	return t, nil
+}
+
+// prosodyName decodes a file or directory name encoded by Prosody.
+func prosodyName(name string) string {
+	n, err := url.PathUnescape(name)
+	if err != nil {
+		return name
+	}
+	return n
+}
+
+// readProsodyAccounts returns the plaintext passwords of the accounts
+// stored under dir, indexed by JID.  Accounts with hashed passwords are
+// returned with an empty password.
+func readProsodyAccounts(dir string) (map[string]string, error) {
+	hosts, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[string]string)
+	for _, host := range hosts {
+		adir := filepath.Join(dir, host.Name(), "accounts")
+		files, err := os.ReadDir(adir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".dat") {
+				continue
+			}
+			t, err := readLuaFile(filepath.Join(adir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			jid := prosodyName(strings.TrimSuffix(f.Name(), ".dat")) +
+				"@" + prosodyName(host.Name())
+			pw, _ := t["password"].(string)
+			accounts[jid] = pw
+		}
+	}
+	return accounts, nil
+}
+
+// importJitsi converts the persistent multi-user chat rooms stored in
+// the Prosody data directory dir.  Owners and administrators of a room
+// become operators, members become presenters, and the room's password
+// becomes that of the wildcard user.
+func importJitsi(dir string) ([]importedGroup, []string, error) {
+	accounts, err := readProsodyAccounts(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var groups []importedGroup
+	var warnings []string
+	for _, host := range hosts {
+		cdir := filepath.Join(dir, host.Name(), "config")
+		files, err := os.ReadDir(cdir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, nil, err
+		}
+		for _, f := range files {
+			if !strings.HasSuffix(f.Name(), ".dat") {
+				continue
+			}
+			room := prosodyName(strings.TrimSuffix(f.Name(), ".dat"))
+			t, err := readLuaFile(filepath.Join(cdir, f.Name()))
+			if err != nil {
+				return nil, nil, err
+			}
+			g, w, err := importJitsiRoom(room, t, accounts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("room %v: %w", room, err)
+			}
+			warnings = append(warnings, w...)
+			if g.name != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return groups, warnings, nil
+}
+
+func importJitsiRoom(room string, t map[string]any, accounts map[string]string) (importedGroup, []string, error) {
+	var warnings []string
+	name := importGroupName(room)
+	if name == "" {
+		warnings = append(warnings,
+			fmt.Sprintf("room %q: bad room name", room))
+		return importedGroup{}, warnings, nil
+	}
+
+	data, _ := t["_data"].(map[string]any)
+	desc := &group.Description{}
+	if n, ok := data["name"].(string); ok && n != room {
+		desc.DisplayName = n
+	}
+	desc.Description, _ = data["description"].(string)
+	if hidden, ok := data["hidden"].(bool); ok && !hidden {
+		desc.Public = true
+	}
+	if membersOnly, _ := data["members_only"].(bool); !membersOnly {
+		pw, _ := data["password"].(string)
+		var err error
+		desc.WildcardUser, err = roomPassword(pw)
+		if err != nil {
+			return importedGroup{}, nil, err
+		}
+	}
+
+	affiliations, _ := t["_affiliations"].(map[string]any)
+	jids := make([]string, 0, len(affiliations))
+	for jid := range affiliations {
+		jids = append(jids, jid)
+	}
+	sort.Strings(jids)
+	for _, jid := range jids {
+		var perms string
+		switch affiliations[jid] {
+		case "owner", "admin":
+			perms = "op"
+		case "member":
+			perms = "present"
+		default:
+			continue
+		}
+		username, host, found := strings.Cut(jid, "@")
+		if !found || username == "" {
+			continue
+		}
+		if username == "focus" && strings.HasPrefix(host, "auth.") {
+			// Jicofo, which owns all rooms
+			continue
+		}
+		user := group.UserDescription{
+			Permissions: mustPermissions(perms),
+		}
+		if pw := accounts[jid]; pw != "" {
+			p, err := importPassword(pw)
+			if err != nil {
+				return importedGroup{}, nil, err
+			}
+			user.Password = p
+		} else {
+			warnings = append(warnings, fmt.Sprintf(
+				"group %v: no password for user %v",
+				name, username,
+			))
+		}
+		if desc.Users == nil {
+			desc.Users = make(map[string]group.UserDescription)
+		}
+		if _, ok := desc.Users[username]; ok {
+			warnings = append(warnings, fmt.Sprintf(
+				"group %v: duplicate user %v", name, username,
+			))
+			continue
+		}
+		desc.Users[username] = user
+	}
+	return importedGroup{name, desc}, warnings, nil
+}
+
+// writeImportedGroup writes a group definition into dir.
+func writeImportedGroup(dir string, g importedGroup, force bool) error {
+	filename := filepath.Join(dir, filepath.FromSlash(g.name)+".json")
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "    ")
+	err = encoder.Encode(g.desc)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func importCmd(cmdname string, args []string) {
+	var format, dir string
+	var force, dryrun bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...] file\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&format, "format", "",
+		"export `format`, \"jitsi\" or \"bbb\"")
+	cmd.StringVar(&dir, "dir", ".", "output `directory`")
+	cmd.BoolVar(&force, "f", false, "overwrite existing definitions")
+	cmd.BoolVar(&dryrun, "n", false, "don't write any files")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	var groups []importedGroup
+	var warnings []string
+	var err error
+	switch format {
+	case "jitsi":
+		groups, warnings, err = importJitsi(cmd.Arg(0))
+	case "bbb":
+		var f *os.File
+		f, err = os.Open(cmd.Arg(0))
+		if err != nil {
+			fatalf("Open: %v", err)
+		}
+		groups, warnings, err = importBBB(f)
+		f.Close()
+	default:
+		cmd.Usage()
+		exit(1)
+	}
+	if err != nil {
+		fatalf("Import: %v", err)
+	}
+
+	for _, w := range warnings {
+		log.Printf("Warning: %v", w)
+	}
+
+	for _, g := range groups {
+		fmt.Printf("Group %v (%v users)\n", g.name, len(g.desc.Users))
+		if dryrun {
+			continue
+		}
+		err := writeImportedGroup(dir, g, force)
+		if err != nil {
+			fatalf("Write group %v: %v", g.name, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestParseLua(t *testing.T) {
+	v, err := parseLua([]byte(`return {
+	["_data"] = {
+		["name"] = "Night \"Watch\"\nmeeting";
+		["persistent"] = true;
+		["hidden"] = false;
+		["history_length"] = 20;
+		["caf\195\169"] = 'x';
+	};
+	["_affiliations"] = {
+		["vimes@example.org"] = "owner";
+	};
+	list = { "a", "b", [10] = "c" };
+};
+`))
+	if err != nil {
+		t.Fatalf("parseLua: %v", err)
+	}
+	expected := map[string]any{
+		"_data": map[string]any{
+			"name":           "Night \"Watch\"\nmeeting",
+			"persistent":     true,
+			"hidden":         false,
+			"history_length": 20.0,
+			"café":           "x",
+		},
+		"_affiliations": map[string]any{
+			"vimes@example.org": "owner",
+		},
+		"list": map[string]any{"1": "a", "2": "b", "10": "c"},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Got %#v, expected %#v", v, expected)
+	}
+
+	for _, s := range []string{
+		"", "{}", "return", "return {", `return "abc`, "return {} x",
+	} {
+		_, err := parseLua([]byte(s))
+		if err == nil {
+			t.Errorf("%q: no error", s)
+		}
+	}
+}
+
+func TestImportGroupName(t *testing.T) {
+	tests := map[string]string{
+		"city-watch":   "city-watch",
+		"City Watch":   "city-watch",
+		"../../passwd": "-..-passwd",
+		"a/b":          "a-b",
+	}
+	for in, out := range tests {
+		n := importGroupName(in)
+		if n != out {
+			t.Errorf("%v: got %v, expected %v", in, n, out)
+		}
+	}
+}
+
+func plainPassword(pw string) (group.Password, error) {
+	return group.Password{Type: "plain", Key: &pw}, nil
+}
+
+func TestImportBBB(t *testing.T) {
+	defer func(f func(string) (group.Password, error)) {
+		importPassword = f
+	}(importPassword)
+	importPassword = plainPassword
+	groups, warnings, err := importBBB(strings.NewReader(`<response>
+<returncode>SUCCESS</returncode>
+<meetings>
+<meeting>
+<meetingName>Night Watch</meetingName>
+<meetingID>Night-Watch</meetingID>
+<attendeePW>ap</attendeePW>
+<moderatorPW>mp</moderatorPW>
+</meeting>
+<meeting>
+<meetingName>Open</meetingName>
+<meetingID>open</meetingID>
+</meeting>
+</meetings>
+</response>`))
+	if err != nil {
+		t.Fatalf("importBBB: %v", err)
+	}
+	if len(warnings) != 0 || len(groups) != 2 {
+		t.Fatalf("Got %v groups, warnings %v", len(groups), warnings)
+	}
+	g := groups[0]
+	if g.name != "night-watch" || g.desc.DisplayName != "Night Watch" {
+		t.Errorf("Bad group %v %v", g.name, g.desc.DisplayName)
+	}
+	if *g.desc.WildcardUser.Password.Key != "ap" ||
+		g.desc.WildcardUser.Permissions.String() != "present" {
+		t.Errorf("Bad wildcard user %v", g.desc.WildcardUser)
+	}
+	m := g.desc.Users["moderator"]
+	if *m.Password.Key != "mp" || m.Permissions.String() != "op" {
+		t.Errorf("Bad moderator %v", m)
+	}
+	g = groups[1]
+	if g.desc.WildcardUser.Password.Type != "wildcard" ||
+		g.desc.Users != nil {
+		t.Errorf("Bad open group %#v", g.desc)
+	}
+
+	_, _, err = importBBB(strings.NewReader(
+		`<response><returncode>FAILED</returncode></response>`,
+	))
+	if err == nil {
+		t.Errorf("Failed reply accepted")
+	}
+}
+
+func TestImportJitsi(t *testing.T) {
+	defer func(f func(string) (group.Password, error)) {
+		importPassword = f
+	}(importPassword)
+	importPassword = plainPassword
+	dir := t.TempDir()
+	write := func(name, contents string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(p), 0700)
+		if err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		err = os.WriteFile(p, []byte(contents), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	write("meet%2eexample%2eorg/accounts/vimes.dat",
+		`return { ["password"] = "secret"; };`)
+	write("meet%2eexample%2eorg/accounts/carrot.dat",
+		`return { ["stored_key"] = "abc"; ["salt"] = "def"; };`)
+	write("conference%2emeet%2eexample%2eorg/config/watch.dat",
+		`return {
+	["_data"] = {
+		["password"] = "pw"; ["hidden"] = false;
+		["name"] = "The Watch";
+	};
+	["_affiliations"] = {
+		["focus@auth.meet.example.org"] = "owner";
+		["vimes@meet.example.org"] = "owner";
+		["carrot@meet.example.org"] = "member";
+		["nobby@meet.example.org"] = "outcast";
+	};
+};`)
+	write("conference%2emeet%2eexample%2eorg/config/guild.dat",
+		`return { ["_data"] = { ["members_only"] = true; }; };`)
+
+	groups, warnings, err := importJitsi(dir)
+	if err != nil {
+		t.Fatalf("importJitsi: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %v", len(groups))
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "carrot") {
+		t.Errorf("Unexpected warnings %v", warnings)
+	}
+
+	var watch, guild *group.Description
+	for _, g := range groups {
+		switch g.name {
+		case "watch":
+			watch = g.desc
+		case "guild":
+			guild = g.desc
+		}
+	}
+	if watch == nil || guild == nil {
+		t.Fatalf("Missing groups")
+	}
+	if watch.DisplayName != "The Watch" || !watch.Public ||
+		*watch.WildcardUser.Password.Key != "pw" {
+		t.Errorf("Bad group %#v", watch)
+	}
+	if len(watch.Users) != 2 ||
+		*watch.Users["vimes"].Password.Key != "secret" ||
+		watch.Users["vimes"].Permissions.String() != "op" ||
+		watch.Users["carrot"].Password.Type != "" ||
+		watch.Users["carrot"].Permissions.String() != "present" {
+		t.Errorf("Bad users %v", watch.Users)
+	}
+	if guild.WildcardUser != nil || guild.Public {
+		t.Errorf("Bad members-only group %#v", guild)
+	}
+
+	out := t.TempDir()
+	for _, g := range groups {
+		err := writeImportedGroup(out, g, false)
+		if err != nil {
+			t.Fatalf("writeImportedGroup: %v", err)
+		}
+	}
+	err = writeImportedGroup(out, groups[0], false)
+	if !os.IsExist(err) {
+		t.Errorf("Overwrote existing group: %v", err)
+	}
+	descs, err := readGroupFiles(out)
+	if err != nil || len(descs) != 2 {
+		t.Errorf("readGroupFiles: %v %v", descs, err)
+	}
+}
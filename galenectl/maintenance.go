@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func formatMaintenance(m group.Maintenance, now time.Time) string {
+	var s string
+	if m.Start.After(now) {
+		s = fmt.Sprintf("maintenance at %v (in %v)",
+			m.Start.Format(time.RFC3339),
+			m.Start.Sub(now).Round(time.Second))
+	} else {
+		s = fmt.Sprintf("maintenance since %v",
+			m.Start.Format(time.RFC3339))
+	}
+	if m.End != nil {
+		s += fmt.Sprintf(", until %v", m.End.Format(time.RFC3339))
+	}
+	lockout := group.DefaultMaintenanceLockout
+	if m.Lockout > 0 {
+		lockout = time.Duration(m.Lockout) * time.Second
+	}
+	s += fmt.Sprintf(", lockout %v", lockout)
+	if m.Drain {
+		s += ", drain"
+	}
+	if m.Message != "" {
+		s += fmt.Sprintf(": %v", m.Message)
+	}
+	return s
+}
+
+func maintenanceCmd(cmdname string, args []string) {
+	var start, end timeOption
+	var lockout time.Duration
+	var message string
+	var drain, cancel bool
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname,
+		"%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.Var(&start, "start",
+		"start `time` or duration of the maintenance window")
+	cmd.Var(&end, "end", "end `time` or duration from now")
+	cmd.DurationVar(&lockout, "lockout", 0,
+		"refuse joins during this `duration` before the start")
+	cmd.StringVar(&message, "message", "", "message sent to users")
+	cmd.BoolVar(&drain, "drain", false,
+		"disconnect all users at the start")
+	cmd.BoolVar(&cancel, "cancel", false,
+		"cancel the scheduled maintenance")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 || (cancel && start.set) ||
+		(!start.set && (end.set || lockout != 0 ||
+			message != "" || drain)) {
+		cmd.Usage()
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.maintenance")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	if cancel {
+		err := deleteValue(u)
+		if err != nil {
+			fatalf("Cancel maintenance: %v", err)
+		}
+		return
+	}
+
+	if !start.set {
+		var m group.Maintenance
+		_, err := getJSON(u, &m)
+		var herr httpError
+		if errors.As(err, &herr) &&
+			herr.statusCode == http.StatusNotFound {
+			fmt.Println("no maintenance scheduled")
+			return
+		} else if err != nil {
+			fatalf("Get maintenance: %v", err)
+		}
+		fmt.Println(formatMaintenance(m, time.Now()))
+		return
+	}
+
+	m := group.Maintenance{
+		Start:   start.value,
+		Lockout: int(lockout / time.Second),
+		Message: message,
+		Drain:   drain,
+	}
+	if end.set {
+		m.End = &end.value
+	}
+	err = putJSON(u, m, true)
+	if err != nil {
+		fatalf("Schedule maintenance: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+type healthReply struct {
+	Version string  `json:"version"`
+	Uptime  float64 `json:"uptime"`
+	Groups  int     `json:"groups"`
+	Clients int     `json:"clients"`
+	// nil if the server is too old to report readiness
+	Ready       *bool      `json:"ready"`
+	Maintenance *time.Time `json:"maintenance"`
+}
+
+func formatHealth(h healthReply, rtt time.Duration) string {
+	uptime := time.Duration(h.Uptime * float64(time.Millisecond))
+	loading := ""
+	if h.Ready != nil && !*h.Ready {
+		loading = " (loading)"
+	}
+	maintenance := ""
+	if h.Maintenance != nil {
+		maintenance = fmt.Sprintf(", maintenance at %v",
+			h.Maintenance.Format(time.RFC3339))
+	}
+	return fmt.Sprintf(
+		"version %v, up %v%v, %v groups, %v clients%v: time=%.1fms",
+		h.Version, uptime.Round(time.Second), loading,
+		h.Groups, h.Clients, maintenance,
+		float64(rtt)/float64(time.Millisecond),
+	)
+}
+
+func pingCmd(cmdname string, args []string) {
+	var count int
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.IntVar(&count, "count", 1, "number of `requests`")
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 || count < 1 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.health")
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		var health healthReply
+		start := time.Now()
+		_, err = getJSON(u, &health)
+		if err != nil {
+			fatalf("Ping %v: %v", serverURL, err)
+		}
+		fmt.Println(formatHealth(health, time.Since(start)))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type recording struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Duration float64   `json:"duration"`
+}
+
+func formatRecording(r recording) string {
+	duration := "-"
+	if r.Duration > 0 {
+		d := time.Duration(r.Duration * float64(time.Second))
+		duration = d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%-40s %12d %10s %v", r.Name, r.Size, duration,
+		r.Modified.Local().Format(time.DateTime),
+	)
+}
+
+// recordingFlags parses the options common to the recordings commands.
+func recordingFlags(cmdname string, args []string, name bool, extra func(*flag.FlagSet)) (string, string) {
+	var groupname, filename string
+	cmd := newFlagSet(cmdname)
+	setUsage(cmd, cmdname, "%v [option...] %v [option...]\n",
+		os.Args[0], cmdname,
+	)
+	cmd.StringVar(&groupname, "group", "", "group `name`")
+	if name {
+		cmd.StringVar(&filename, "name", "", "recording `filename`")
+	}
+	if extra != nil {
+		extra(cmd)
+	}
+	cmd.Parse(args)
+
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		exit(1)
+	}
+
+	if groupname == "" {
+		fmt.Fprintf(cmd.Output(), "Option \"-group\" is required\n")
+		exit(1)
+	}
+	if name && filename == "" {
+		fmt.Fprintf(cmd.Output(), "Option \"-name\" is required\n")
+		exit(1)
+	}
+
+	elems := []string{"/galene-api/v0/.groups/", groupname, ".recordings/"}
+	if name {
+		elems = append(elems, filename)
+	}
+	u, err := url.JoinPath(serverURL, elems...)
+	if err != nil {
+		fatalf("Build URL: %v", err)
+	}
+	return u, filename
+}
+
+func listRecordingsCmd(cmdname string, args []string) {
+	u, _ := recordingFlags(cmdname, args, false, nil)
+
+	var recordings []recording
+	_, err := getJSON(u, &recordings)
+	if err != nil {
+		fatalf("Get recordings: %v", err)
+	}
+	for _, r := range recordings {
+		fmt.Println(formatRecording(r))
+	}
+}
+
+func getRecordingCmd(cmdname string, args []string) {
+	var output string
+	u, filename := recordingFlags(cmdname, args, true,
+		func(cmd *flag.FlagSet) {
+			cmd.StringVar(&output, "o", "",
+				"output `filename`, \"-\" for standard output")
+		},
+	)
+
+	if output == "-" {
+		err := getCopy(u, os.Stdout)
+		if err != nil {
+			fatalf("Get recording: %v", err)
+		}
+		return
+	}
+
+	if output == "" {
+		output = filepath.Base(filename)
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		fatalf("Create %v: %v", output, err)
+	}
+	err = getCopy(u, f)
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(output)
+		fatalf("Get recording: %v", err)
+	}
+}
+
+func deleteRecordingCmd(cmdname string, args []string) {
+	u, _ := recordingFlags(cmdname, args, true, nil)
+
+	err := deleteValue(u)
+	if err != nil {
+		fatalf("Delete recording: %v", err)
+	}
+}
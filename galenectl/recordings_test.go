@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFormatRecording(t *testing.T) {
+	modified := time.Date(2025, 3, 1, 14, 30, 0, 0, time.Local)
+	tests := []struct {
+		recording recording
+		result    string
+	}{
+		{
+			recording{Name: "a.webm", Size: 1234, Modified: modified},
+			fmt.Sprintf("%-40s %12d %10s 2025-03-01 14:30:00",
+				"a.webm", 1234, "-"),
+		},
+		{
+			recording{
+				Name: "b.mp4", Size: 42, Modified: modified,
+				Duration: 3723.4,
+			},
+			fmt.Sprintf("%-40s %12d %10s 2025-03-01 14:30:00",
+				"b.mp4", 42, "1h2m3s"),
+		},
+	}
+	for _, test := range tests {
+		result := formatRecording(test.recording)
+		if result != test.result {
+			t.Errorf("Expected %q, got %q", test.result, result)
+		}
+	}
+}
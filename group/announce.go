@@ -0,0 +1,77 @@
+package group
+
+import (
+	"errors"
+	"log"
+	"path"
+)
+
+// Announcement channels are defined in the server configuration.  Every
+// channel maps to a list of patterns, and a message posted to a channel
+// is delivered to all clients of the groups whose name matches one of
+// the patterns.
+
+// ErrUnknownChannel is returned when posting to an announcement channel
+// that is not defined in the configuration.
+var ErrUnknownChannel = errors.New("unknown announcement channel")
+
+type announcer interface {
+	Announce(username, message string) error
+}
+
+// matchAnnouncement returns true if name matches one of the patterns.
+func matchAnnouncement(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Announce delivers a message to the clients of all groups whose name
+// matches one of the patterns of the given channel.  It returns the
+// number of groups that were reached.
+func Announce(channel, username, message string) (int, error) {
+	conf, err := GetConfiguration()
+	if err != nil {
+		return 0, err
+	}
+	patterns, ok := conf.Announcements[channel]
+	if !ok {
+		return 0, ErrUnknownChannel
+	}
+
+	// check the patterns before sending anything
+	_, err = matchAnnouncement(patterns, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var gs []*Group
+	Range(func(g *Group) bool {
+		ok, _ := matchAnnouncement(patterns, g.name)
+		if ok {
+			gs = append(gs, g)
+		}
+		return true
+	})
+
+	for _, g := range gs {
+		for _, c := range g.GetClients(nil) {
+			a, ok := c.(announcer)
+			if !ok {
+				continue
+			}
+			err := a.Announce(username, message)
+			if err != nil {
+				log.Printf("Announce: %v", err)
+			}
+		}
+	}
+	return len(gs), nil
+}
@@ -0,0 +1,36 @@
+package group
+
+import (
+	"testing"
+)
+
+func TestMatchAnnouncement(t *testing.T) {
+	patterns := []string{"course-*", "lecture/*"}
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"course-101", true},
+		{"course-", true},
+		{"course", false},
+		{"course-101/breakout", false},
+		{"lecture/physics", true},
+		{"lecture", false},
+		{"staff", false},
+	}
+	for _, tt := range tests {
+		match, err := matchAnnouncement(patterns, tt.name)
+		if err != nil {
+			t.Errorf("Match %v: %v", tt.name, err)
+		}
+		if match != tt.match {
+			t.Errorf("Match %v: expected %v, got %v",
+				tt.name, tt.match, match)
+		}
+	}
+
+	_, err := matchAnnouncement([]string{"["}, "")
+	if err == nil {
+		t.Errorf("Bad pattern succeeded")
+	}
+}
@@ -0,0 +1,356 @@
+package group
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API tokens allow automated clients, such as CI jobs, to use the
+// administrative API without knowing the password of an administrator.
+// An API token is of the form "id.secret"; we only store the hash of the
+// secret, so the token cannot be recovered after it has been created.
+//
+// A token carries a list of scopes, each of which is either "admin",
+// which grants access to the whole API, or of the form
+// "resource:action" or "resource:action:group".  The action is one of
+// "read", "create" or "write", the latter of which implies the other two.
+// If a group is specified, the scope only applies to that group and its
+// subgroups.  Managing API tokens requires the "admin" scope, since
+// a token that could create tokens would be as powerful as "admin".
+//
+// The same scopes may be granted to server-wide users in the
+// configuration file, which allows defining administrators with limited
+// powers.
+
+// ErrBadAPIToken is returned when an API token is unknown, malformed or
+// expired.
+var ErrBadAPIToken = errors.New("bad API token")
+
+// APIResources are the resources that may appear in a scope.
+var APIResources = []string{
+	"announce", "clients", "groups", "keys", "maintenance",
+	"recordings", "replica", "stats", "tokens", "users",
+}
+
+// the time after which the last use of a token is written to disk again
+const apiTokenUseGranularity = time.Minute
+
+type APIToken struct {
+	Id          string     `json:"id"`
+	Hash        string     `json:"hash,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	LastUsed    *time.Time `json:"lastUsed,omitempty"`
+}
+
+var apiTokens struct {
+	mu       sync.Mutex
+	modTime  time.Time
+	fileSize int64
+	tokens   []*APIToken
+}
+
+func apiTokensFilename() string {
+	return filepath.Join(DataDirectory, "api-tokens.json")
+}
+
+// called locked
+func loadAPITokens() error {
+	filename := apiTokensFilename()
+	fi, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			apiTokens.tokens = nil
+			apiTokens.modTime = time.Time{}
+			apiTokens.fileSize = 0
+			return nil
+		}
+		return err
+	}
+	if apiTokens.modTime.Equal(fi.ModTime()) &&
+		apiTokens.fileSize == fi.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var tokens []*APIToken
+	err = json.Unmarshal(data, &tokens)
+	if err != nil {
+		return err
+	}
+	apiTokens.tokens = tokens
+	apiTokens.modTime = fi.ModTime()
+	apiTokens.fileSize = fi.Size()
+	return nil
+}
+
+// called locked
+func writeAPITokens() error {
+	filename := apiTokensFilename()
+	data, err := json.MarshalIndent(apiTokens.tokens, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		// force reading next time
+		apiTokens.modTime = time.Time{}
+		return nil
+	}
+	apiTokens.modTime = fi.ModTime()
+	apiTokens.fileSize = fi.Size()
+	return nil
+}
+
+func hashAPISecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func parseScope(scope string) (resource, action, group string, err error) {
+	if scope == "admin" {
+		return "admin", "", "", nil
+	}
+	s := strings.SplitN(scope, ":", 3)
+	if len(s) < 2 || !member(s[0], APIResources) {
+		return "", "", "", errors.New("bad scope " + scope)
+	}
+	if s[1] != "read" && s[1] != "create" && s[1] != "write" {
+		return "", "", "", errors.New("bad action in scope " + scope)
+	}
+	if len(s) == 3 {
+		if !validGroupName(s[2]) {
+			return "", "", "", errors.New(
+				"bad group in scope " + scope,
+			)
+		}
+		return s[0], s[1], s[2], nil
+	}
+	return s[0], s[1], "", nil
+}
+
+// CheckScopes returns an error if one of scopes is malformed.
+func CheckScopes(scopes []string) error {
+	for _, s := range scopes {
+		_, _, _, err := parseScope(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Allows returns true if the token grants the given action on the given
+// resource of group, which is empty for resources that don't belong to
+// a group.
+func (token *APIToken) Allows(resource, action, group string) bool {
+	return ScopesAllow(token.Scopes, resource, action, group)
+}
+
+// ScopesAllow returns true if one of scopes grants the given action on
+// the given resource of group.  An empty resource is only granted by the
+// "admin" scope.
+func ScopesAllow(scopes []string, resource, action, group string) bool {
+	for _, scope := range scopes {
+		r, a, g, err := parseScope(scope)
+		if err != nil {
+			continue
+		}
+		if r == "admin" {
+			return true
+		}
+		if r != resource {
+			continue
+		}
+		if a != action && a != "write" {
+			continue
+		}
+		if g != "" && group != g && !strings.HasPrefix(group, g+"/") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// CreateAPIToken stores a new token with the given description, scopes
+// and expiration time, and returns the token.  The secret part of the
+// token is not stored, so this is the only time it is available.
+func CreateAPIToken(description string, scopes []string, expires *time.Time) (string, *APIToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, UserError("no scopes")
+	}
+	err := CheckScopes(scopes)
+	if err != nil {
+		return "", nil, UserError(err.Error())
+	}
+
+	buf := make([]byte, 8+24)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf[:8])
+	secret := base64.RawURLEncoding.EncodeToString(buf[8:])
+
+	t := &APIToken{
+		Id:          id,
+		Hash:        hashAPISecret(secret),
+		Description: description,
+		Scopes:      append([]string(nil), scopes...),
+		Created:     time.Now().Truncate(time.Second),
+		Expires:     expires,
+	}
+
+	apiTokens.mu.Lock()
+	defer apiTokens.mu.Unlock()
+
+	err = loadAPITokens()
+	if err != nil {
+		return "", nil, err
+	}
+	apiTokens.tokens = append(apiTokens.tokens, t)
+	err = writeAPITokens()
+	if err != nil {
+		apiTokens.tokens = apiTokens.tokens[:len(apiTokens.tokens)-1]
+		return "", nil, err
+	}
+	return id + "." + secret, t.sanitised(), nil
+}
+
+func (token *APIToken) sanitised() *APIToken {
+	t := *token
+	t.Hash = ""
+	t.Scopes = append([]string(nil), token.Scopes...)
+	return &t
+}
+
+// ListAPITokens returns all API tokens, without their hashes.
+func ListAPITokens() ([]*APIToken, error) {
+	apiTokens.mu.Lock()
+	defer apiTokens.mu.Unlock()
+
+	err := loadAPITokens()
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]*APIToken, 0, len(apiTokens.tokens))
+	for _, t := range apiTokens.tokens {
+		tokens = append(tokens, t.sanitised())
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+	return tokens, nil
+}
+
+// GetAPIToken returns the API token with the given id, without its hash.
+func GetAPIToken(id string) (*APIToken, error) {
+	apiTokens.mu.Lock()
+	defer apiTokens.mu.Unlock()
+
+	err := loadAPITokens()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range apiTokens.tokens {
+		if t.Id == id {
+			return t.sanitised(), nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// DeleteAPIToken deletes the API token with the given id.
+func DeleteAPIToken(id string) error {
+	apiTokens.mu.Lock()
+	defer apiTokens.mu.Unlock()
+
+	err := loadAPITokens()
+	if err != nil {
+		return err
+	}
+	for i, t := range apiTokens.tokens {
+		if t.Id == id {
+			old := apiTokens.tokens
+			apiTokens.tokens = append(
+				append([]*APIToken(nil), old[:i]...),
+				old[i+1:]...,
+			)
+			err := writeAPITokens()
+			if err != nil {
+				apiTokens.tokens = old
+				return err
+			}
+			return nil
+		}
+	}
+	return os.ErrNotExist
+}
+
+// CheckAPIToken returns the API token corresponding to value, and
+// records its use.
+func CheckAPIToken(value string, now time.Time) (*APIToken, error) {
+	id, secret, ok := strings.Cut(value, ".")
+	if !ok || id == "" || secret == "" {
+		return nil, ErrBadAPIToken
+	}
+	hash := hashAPISecret(secret)
+
+	apiTokens.mu.Lock()
+	defer apiTokens.mu.Unlock()
+
+	err := loadAPITokens()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range apiTokens.tokens {
+		if t.Id != id {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+			return nil, ErrBadAPIToken
+		}
+		if t.Expires != nil && !now.Before(*t.Expires) {
+			return nil, ErrBadAPIToken
+		}
+		if t.LastUsed == nil ||
+			now.Sub(*t.LastUsed) >= apiTokenUseGranularity {
+			n := now.Truncate(time.Second)
+			t.LastUsed = &n
+			err := writeAPITokens()
+			if err != nil {
+				log.Printf("Write API tokens: %v", err)
+			}
+		}
+		return t.sanitised(), nil
+	}
+	return nil, ErrBadAPIToken
+}
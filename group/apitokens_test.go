@@ -0,0 +1,121 @@
+package group
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAPITokenAllows(t *testing.T) {
+	tok := &APIToken{
+		Scopes: []string{
+			"groups:read",
+			"tokens:create:school",
+			"users:write:other",
+			"bogus:write",
+		},
+	}
+	tests := []struct {
+		resource, action, group string
+		allowed                 bool
+	}{
+		{"groups", "read", "", true},
+		{"groups", "read", "school", true},
+		{"groups", "write", "school", false},
+		{"tokens", "create", "school", true},
+		{"tokens", "create", "school/room1", true},
+		{"tokens", "create", "schools", false},
+		{"tokens", "read", "school", false},
+		{"users", "read", "other", true},
+		{"users", "create", "other", true},
+		{"users", "write", "school", false},
+		{"bogus", "write", "", false},
+		{"api-tokens", "read", "", false},
+	}
+	for _, test := range tests {
+		a := tok.Allows(test.resource, test.action, test.group)
+		if a != test.allowed {
+			t.Errorf("%v %v %v: expected %v, got %v",
+				test.resource, test.action, test.group,
+				test.allowed, a)
+		}
+	}
+
+	admin := &APIToken{Scopes: []string{"admin"}}
+	if !admin.Allows("api-tokens", "write", "") {
+		t.Errorf("Admin token not allowed")
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	DataDirectory = t.TempDir()
+
+	_, _, err := CreateAPIToken("", []string{"groups:frobnicate"}, nil)
+	if err == nil {
+		t.Errorf("Bad scope accepted")
+	}
+	_, _, err = CreateAPIToken("", nil, nil)
+	if err == nil {
+		t.Errorf("Empty scopes accepted")
+	}
+
+	value, tok, err := CreateAPIToken("ci", []string{"stats:read"}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if tok.Hash != "" || tok.Description != "ci" {
+		t.Errorf("Bad token %v", tok)
+	}
+
+	now := time.Now()
+	tok2, err := CheckAPIToken(value, now)
+	if err != nil || tok2.Id != tok.Id || tok2.LastUsed == nil {
+		t.Errorf("CheckAPIToken: %v %v", tok2, err)
+	}
+
+	_, err = CheckAPIToken(tok.Id+".wrong", now)
+	if !errors.Is(err, ErrBadAPIToken) {
+		t.Errorf("Wrong secret: got %v", err)
+	}
+	_, err = CheckAPIToken("garbage", now)
+	if !errors.Is(err, ErrBadAPIToken) {
+		t.Errorf("Garbage: got %v", err)
+	}
+
+	// the last use is persisted
+	apiTokens.tokens = nil
+	apiTokens.modTime = time.Time{}
+	tok3, err := GetAPIToken(tok.Id)
+	if err != nil || tok3.LastUsed == nil || tok3.Hash != "" {
+		t.Errorf("GetAPIToken: %v %v", tok3, err)
+	}
+
+	expires := now.Add(time.Hour)
+	value2, _, err := CreateAPIToken("", []string{"admin"}, &expires)
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	_, err = CheckAPIToken(value2, now.Add(2*time.Hour))
+	if !errors.Is(err, ErrBadAPIToken) {
+		t.Errorf("Expired token: got %v", err)
+	}
+
+	tokens, err := ListAPITokens()
+	if err != nil || len(tokens) != 2 {
+		t.Errorf("ListAPITokens: %v %v", tokens, err)
+	}
+
+	err = DeleteAPIToken(tok.Id)
+	if err != nil {
+		t.Errorf("DeleteAPIToken: %v", err)
+	}
+	_, err = CheckAPIToken(value, now)
+	if !errors.Is(err, ErrBadAPIToken) {
+		t.Errorf("Deleted token: got %v", err)
+	}
+	err = DeleteAPIToken(tok.Id)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete twice: got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Groups that haven't been joined for a long time may be archived: their
+// definition is moved to the hidden directory .archive within the groups
+// directory (or the equivalent prefix in other stores), where it is
+// ignored by the server until it is restored.
+
+// ActivityFilename is the file where the time of the last join to every
+// group is stored.  If empty, groups are never archived.
+var ActivityFilename string
+
+// ErrBusy is returned when attempting to archive a group with clients.
+var ErrBusy = errors.New("group is busy")
+
+type activityState struct {
+	mu     sync.Mutex
+	loaded bool
+	dirty  bool
+	last   map[string]time.Time
+}
+
+var activity activityState
+
+const archivePrefix = ".archive"
+
+func archiveName(name string) string {
+	return archivePrefix + "/" + name
+}
+
+// loadUnlocked reads the activity file if it hasn't been read yet.
+// Called with a.mu held.
+func (a *activityState) loadUnlocked() {
+	if a.loaded {
+		return
+	}
+	a.loaded = true
+	a.last = make(map[string]time.Time)
+	f, err := os.Open(ActivityFilename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Read activity: %v", err)
+		}
+		return
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&a.last)
+	if err != nil {
+		log.Printf("Read activity: %v", err)
+		a.last = make(map[string]time.Time)
+	}
+}
+
+// saveUnlocked writes the activity file if it has changed.  Called with
+// a.mu held.
+func (a *activityState) saveUnlocked() error {
+	if !a.dirty {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(ActivityFilename), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(
+		filepath.Dir(ActivityFilename), "activity*.json",
+	)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(a.last)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), ActivityFilename)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	a.dirty = false
+	return nil
+}
+
+// activityName returns the name of the group definition that applies to
+// g, which is different from the name of g for subgroups.  Called with
+// g.mu held.
+func activityName(g *Group) string {
+	if !g.description.isSubgroup {
+		return g.name
+	}
+	return g.description.definition
+}
+
+// noteActivity records that a group has just been joined.
+func noteActivity(name string, now time.Time) {
+	if ActivityFilename == "" {
+		return
+	}
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	activity.loadUnlocked()
+	activity.last[name] = now
+	activity.dirty = true
+}
+
+// ArchiveInactive archives all groups that haven't been joined for the
+// given duration.  Groups that have never been seen before are assumed
+// to have been joined just now.
+func ArchiveInactive(after time.Duration) {
+	if ActivityFilename == "" {
+		return
+	}
+
+	names, err := GetDescriptionNames()
+	if err != nil {
+		log.Printf("Archive groups: %v", err)
+		return
+	}
+
+	now := time.Now()
+	var archive []string
+
+	// don't take the group locks while holding activity.mu
+	busy := make(map[string]bool)
+	for _, name := range names {
+		if g := Get(name); g != nil && g.ClientCount() > 0 {
+			busy[name] = true
+		}
+	}
+
+	activity.mu.Lock()
+	activity.loadUnlocked()
+	known := make(map[string]bool, len(names))
+	for _, name := range names {
+		known[name] = true
+		if busy[name] {
+			activity.last[name] = now
+			activity.dirty = true
+			continue
+		}
+		last, ok := activity.last[name]
+		if !ok {
+			activity.last[name] = now
+			activity.dirty = true
+			continue
+		}
+		if now.Sub(last) > after {
+			archive = append(archive, name)
+		}
+	}
+	for name := range activity.last {
+		if !known[name] {
+			delete(activity.last, name)
+			activity.dirty = true
+		}
+	}
+	activity.mu.Unlock()
+
+	for _, name := range archive {
+		err := ArchiveDescription(name)
+		if err != nil {
+			log.Printf("Archive group %v: %v", name, err)
+			continue
+		}
+		log.Printf("Archived inactive group %v", name)
+	}
+
+	activity.mu.Lock()
+	err = activity.saveUnlocked()
+	activity.mu.Unlock()
+	if err != nil {
+		log.Printf("Write activity: %v", err)
+	}
+}
+
+// ArchiveDescription moves the definition of a group to the archive.
+// It fails with ErrBusy if the group has any clients.
+func ArchiveDescription(name string) error {
+	if !validGroupName(name) {
+		return UserError("illegal group name")
+	}
+
+	err := func() error {
+		groups.mu.Lock()
+		defer groups.mu.Unlock()
+
+		if g := groups.groups[name]; g != nil {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if !deleteUnlocked(g) {
+				return ErrBusy
+			}
+		}
+
+		s, err := getStore()
+		if err != nil {
+			return err
+		}
+		return moveDescription(s, name, archiveName(name), true)
+	}()
+	if err != nil {
+		return err
+	}
+
+	if ActivityFilename != "" {
+		activity.mu.Lock()
+		activity.loadUnlocked()
+		delete(activity.last, name)
+		activity.dirty = true
+		activity.mu.Unlock()
+	}
+	return nil
+}
+
+// RestoreDescription moves the definition of a group from the archive
+// back to the groups directory.  It fails with os.ErrExist if a group
+// with the same name exists.
+func RestoreDescription(name string) error {
+	if !validGroupName(name) {
+		return UserError("illegal group name")
+	}
+
+	err := func() error {
+		groups.mu.Lock()
+		defer groups.mu.Unlock()
+
+		s, err := getStore()
+		if err != nil {
+			return err
+		}
+		return moveDescription(s, archiveName(name), name, false)
+	}()
+	if err != nil {
+		return err
+	}
+
+	// restart the clock
+	noteActivity(name, time.Now())
+	return nil
+}
+
+// moveDescription renames a definition within a store.  If overwrite is
+// false, it fails with os.ErrExist if the destination exists.
+func moveDescription(s Store, from, to string, overwrite bool) error {
+	data, version, err := s.Get(from)
+	if err != nil {
+		return err
+	}
+	old, err := s.Version(to)
+	if err == nil {
+		if !overwrite {
+			return os.ErrExist
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		old = ""
+	} else {
+		return err
+	}
+	err = s.Put(to, data, old)
+	if err != nil {
+		return err
+	}
+	forgetMissing()
+	return s.Delete(from, version)
+}
+
+// GetArchivedNames returns the names of all archived groups.
+func GetArchivedNames() ([]string, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	names, err := s.List(archivePrefix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return names, err
+}
@@ -0,0 +1,95 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	Directory = t.TempDir()
+	ActivityFilename = filepath.Join(t.TempDir(), "activity.json")
+	defer func() {
+		ActivityFilename = ""
+	}()
+	activity.mu.Lock()
+	activity.loaded = false
+	activity.dirty = false
+	activity.last = nil
+	activity.mu.Unlock()
+
+	for _, name := range []string{"a", "b", "sub/c"} {
+		filename := filepath.Join(Directory, name+".json")
+		err := os.MkdirAll(filepath.Dir(filename), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filename, []byte("{}"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ArchiveInactive(time.Hour)
+	names, err := GetDescriptionNames()
+	if err != nil || len(names) != 3 {
+		t.Errorf("Expected 3 groups, got %v %v", names, err)
+	}
+	if _, err := os.Stat(ActivityFilename); err != nil {
+		t.Errorf("Activity file: %v", err)
+	}
+
+	activity.mu.Lock()
+	activity.last["a"] = time.Now().Add(-2 * time.Hour)
+	activity.last["sub/c"] = time.Now().Add(-2 * time.Hour)
+	activity.mu.Unlock()
+
+	ArchiveInactive(time.Hour)
+	names, err = GetDescriptionNames()
+	if err != nil || !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("Expected [b], got %v %v", names, err)
+	}
+	archived, err := GetArchivedNames()
+	expected := []string{"a", filepath.Join("sub", "c")}
+	if err != nil || !reflect.DeepEqual(archived, expected) {
+		t.Errorf("Expected %v, got %v %v", expected, archived, err)
+	}
+
+	if _, err := Add(".archive/a", nil); err == nil {
+		t.Errorf("Archived group is accessible")
+	}
+
+	err = RestoreDescription("a")
+	if err != nil {
+		t.Errorf("Restore: %v", err)
+	}
+	err = RestoreDescription("a")
+	if !os.IsNotExist(err) {
+		t.Errorf("Restore twice: %v", err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(Directory, "sub", "c.json"), []byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RestoreDescription("sub/c")
+	if !os.IsExist(err) {
+		t.Errorf("Restore existing group: %v", err)
+	}
+
+	names, err = GetDescriptionNames()
+	if err != nil || len(names) != 3 {
+		t.Errorf("Expected 3 groups, got %v %v", names, err)
+	}
+
+	// the restored group is not archived again immediately
+	ArchiveInactive(time.Hour)
+	names, err = GetDescriptionNames()
+	if err != nil || len(names) != 3 {
+		t.Errorf("Expected 3 groups, got %v %v", names, err)
+	}
+}
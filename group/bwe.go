@@ -0,0 +1,63 @@
+package group
+
+import (
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Down connections use a sender-side bandwidth estimator driven by the
+// transport-wide congestion control feedback (TWCC) sent by the receiver.
+// The estimate is used to choose the simulcast and temporal layers sent
+// to the receiver.
+
+// initialBWE is the estimate used before any feedback has been received.
+const initialBWE = 1024 * 1024
+
+// bweEstimator wraps the GCC estimator.  The CC interceptor returns any
+// error from the estimator to the reader of RTCP packets, which would
+// cause us to stop reading RTCP on a malformed feedback packet.
+type bweEstimator struct {
+	cc.BandwidthEstimator
+}
+
+func (e bweEstimator) WriteRTCP(pkts []rtcp.Packet, attrs interceptor.Attributes) error {
+	e.BandwidthEstimator.WriteRTCP(pkts, attrs)
+	return nil
+}
+
+// configureBWE arranges for TWCC feedback to be negotiated and for the
+// bandwidth estimator of every new peer connection to be passed to bwe.
+// It must be called after all codecs have been registered.
+func configureBWE(m *webrtc.MediaEngine, ir *interceptor.Registry, bwe func(cc.BandwidthEstimator)) error {
+	fb := webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC}
+	m.RegisterFeedback(fb, webrtc.RTPCodecTypeVideo)
+	m.RegisterFeedback(fb, webrtc.RTPCodecTypeAudio)
+	err := webrtc.ConfigureTWCCHeaderExtensionSender(m, ir)
+	if err != nil {
+		return err
+	}
+
+	f, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		e, err := gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(initialBWE),
+			gcc.SendSideBWEMinBitrate(LowBitrate),
+			// we don't pace, we adapt the layers instead
+			gcc.SendSideBWEPacer(gcc.NewNoOpPacer()),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return bweEstimator{e}, nil
+	})
+	if err != nil {
+		return err
+	}
+	f.OnNewPeerConnection(func(id string, e cc.BandwidthEstimator) {
+		bwe(e)
+	})
+	ir.Add(f)
+	return nil
+}
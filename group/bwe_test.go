@@ -0,0 +1,49 @@
+package group
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestConfigureBWE(t *testing.T) {
+	offer := func(bwe func(cc.BandwidthEstimator)) string {
+		api, err := apiFromCodecs(codecsFromNames(nil), bwe, nil)
+		if err != nil {
+			t.Fatalf("apiFromCodecs: %v", err)
+		}
+		pc, err := api.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatalf("NewPeerConnection: %v", err)
+		}
+		defer pc.Close()
+		_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+			webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+			},
+		)
+		if err != nil {
+			t.Fatalf("AddTransceiverFromKind: %v", err)
+		}
+		o, err := pc.CreateOffer(nil)
+		if err != nil {
+			t.Fatalf("CreateOffer: %v", err)
+		}
+		return o.SDP
+	}
+
+	s := offer(nil)
+	if strings.Contains(s, sdp.TransportCCURI) ||
+		strings.Contains(s, "transport-cc") {
+		t.Errorf("TWCC negotiated without BWE")
+	}
+
+	s = offer(func(cc.BandwidthEstimator) {})
+	if !strings.Contains(s, sdp.TransportCCURI) ||
+		!strings.Contains(s, "transport-cc") {
+		t.Errorf("TWCC not negotiated with BWE")
+	}
+}
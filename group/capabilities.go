@@ -0,0 +1,79 @@
+package group
+
+import (
+	"github.com/jech/galene/token"
+)
+
+// Capabilities describes the features that are enabled and the limits
+// that apply to a given client.  It is sent to clients when they join a
+// group, so that they may adapt their user interface.
+type Capabilities struct {
+	// The maximum bitrate that the client may send, in bits per
+	// second, or 0 if unlimited.
+	MaxBitrate uint64 `json:"maxBitrate,omitempty"`
+	// The maximum number of tracks that the client may publish, or 0
+	// if unlimited.
+	MaxTracks int `json:"maxTracks,omitempty"`
+	// Whether the client may only publish audio.
+	AudioOnly bool `json:"audioOnly,omitempty"`
+	// Whether the client receives audio only by default.
+	ReceiveAudioOnly bool `json:"receiveAudioOnly,omitempty"`
+	// The codecs allowed in the group.
+	Codecs []string `json:"codecs"`
+	// Whether recording is allowed in the group.
+	Recording bool `json:"recording,omitempty"`
+	// The number of chat messages kept in the history, and their
+	// maximum age in seconds.
+	ChatHistory    int `json:"chatHistory"`
+	ChatHistoryAge int `json:"chatHistoryAge"`
+	// Whether chat history survives server restarts.
+	PersistentHistory bool `json:"persistentHistory,omitempty"`
+	// Whether subgroups (breakout rooms) are created on the fly.
+	Subgroups bool `json:"subgroups,omitempty"`
+	// Whether the client should protect the contents of the group
+	// from being captured.
+	Privacy bool `json:"privacy,omitempty"`
+	// Whether sharing the screen requires a specific permission.
+	RestrictScreenshare bool `json:"restrictScreenshare,omitempty"`
+	// The maximum dimensions and framerate of published video, or 0
+	// if unlimited.
+	MaxVideoWidth     int `json:"maxVideoWidth,omitempty"`
+	MaxVideoHeight    int `json:"maxVideoHeight,omitempty"`
+	MaxVideoFramerate int `json:"maxVideoFramerate,omitempty"`
+}
+
+// Capabilities returns the capabilities of a client of the group that is
+// subject to the given limits, which may be nil.
+func (g *Group) Capabilities(limits *token.Limits) Capabilities {
+	desc := g.Description()
+
+	codecs := desc.Codecs
+	if len(codecs) == 0 {
+		codecs = defaultCodecs
+	}
+	caps := Capabilities{
+		Codecs:              append([]string(nil), codecs...),
+		Recording:           desc.AllowRecording && !desc.PrivacyMode,
+		ChatHistory:         maxHistorySize(desc),
+		ChatHistoryAge:      int(maxHistoryAge(desc).Seconds()),
+		PersistentHistory:   persistentHistory(desc),
+		Subgroups:           desc.AutoSubgroups,
+		Privacy:             desc.PrivacyMode,
+		ReceiveAudioOnly:    desc.ReceiveAudioOnly,
+		RestrictScreenshare: desc.RestrictScreenshare,
+		MaxVideoWidth:       desc.MaxVideoWidth,
+		MaxVideoHeight:      desc.MaxVideoHeight,
+		MaxVideoFramerate:   desc.MaxVideoFramerate,
+	}
+	if limits != nil {
+		caps.MaxBitrate = limits.MaxBitrate
+		caps.MaxTracks = limits.MaxTracks
+		caps.AudioOnly = limits.AudioOnly
+	}
+	for _, m := range []uint64{desc.MaxBitrate, desc.MaxUserBitrate} {
+		if m > 0 && (caps.MaxBitrate == 0 || m < caps.MaxBitrate) {
+			caps.MaxBitrate = m
+		}
+	}
+	return caps
+}
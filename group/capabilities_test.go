@@ -0,0 +1,81 @@
+package group
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jech/galene/token"
+)
+
+func TestCapabilities(t *testing.T) {
+	g := &Group{
+		description: &Description{
+			AllowRecording: true,
+			MaxHistoryAge:  60,
+		},
+	}
+	caps := g.Capabilities(nil)
+	expected := Capabilities{
+		Codecs:         []string{"vp8", "opus"},
+		Recording:      true,
+		ChatHistory:    maxChatHistory,
+		ChatHistoryAge: 60,
+	}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Expected %v, got %v", expected, caps)
+	}
+
+	g.description = &Description{
+		Codecs:         []string{"vp9", "opus"},
+		MaxHistorySize: 10,
+		AutoSubgroups:  true,
+	}
+	caps = g.Capabilities(&token.Limits{
+		MaxBitrate: 500000,
+		AudioOnly:  true,
+	})
+	expected = Capabilities{
+		MaxBitrate:     500000,
+		AudioOnly:      true,
+		Codecs:         []string{"vp9", "opus"},
+		ChatHistory:    10,
+		ChatHistoryAge: int(DefaultMaxHistoryAge.Seconds()),
+		Subgroups:      true,
+	}
+	if !reflect.DeepEqual(caps, expected) {
+		t.Errorf("Expected %v, got %v", expected, caps)
+	}
+
+	g.description = &Description{
+		AllowRecording: true,
+		PrivacyMode:    true,
+	}
+	caps = g.Capabilities(nil)
+	if caps.Recording || !caps.Privacy {
+		t.Errorf("Privacy mode: got %v", caps)
+	}
+
+	g.description = &Description{
+		MaxBitrate:     2000000,
+		MaxUserBitrate: 3000000,
+	}
+	caps = g.Capabilities(nil)
+	if caps.MaxBitrate != 2000000 {
+		t.Errorf("Group bitrate: got %v", caps.MaxBitrate)
+	}
+	caps = g.Capabilities(&token.Limits{MaxBitrate: 500000})
+	if caps.MaxBitrate != 500000 {
+		t.Errorf("Group bitrate with limits: got %v", caps.MaxBitrate)
+	}
+
+	g.description = &Description{
+		MaxVideoWidth:     1280,
+		MaxVideoHeight:    720,
+		MaxVideoFramerate: 15,
+	}
+	caps = g.Capabilities(nil)
+	if caps.MaxVideoWidth != 1280 || caps.MaxVideoHeight != 720 ||
+		caps.MaxVideoFramerate != 15 {
+		t.Errorf("Video limits: got %v", caps)
+	}
+}
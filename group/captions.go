@@ -0,0 +1,77 @@
+package group
+
+import (
+	"time"
+)
+
+// Captions are relayed to all the clients in the group, but, unlike chat
+// messages, they are not kept in the chat history.  If the group's
+// description sets caption-history, the captions sent recently are kept
+// in memory, so that clients that join in the middle of a sentence get
+// the current caption.
+
+const (
+	captionHistoryAge = 30 * time.Second
+	maxCaptionHistory = 64
+)
+
+type Caption struct {
+	Source string
+	User   *string
+	Time   time.Time
+	Value  interface{}
+}
+
+// called locked
+func (g *Group) discardObsoleteCaptions(now time.Time) {
+	i := 0
+	for i < len(g.captions) {
+		if now.Sub(g.captions[i].Time) <= captionHistoryAge {
+			break
+		}
+		i++
+	}
+	if i > 0 {
+		copy(g.captions, g.captions[i:])
+		g.captions = g.captions[:len(g.captions)-i]
+	}
+}
+
+// AddCaption records a caption, if the group keeps recent captions.
+func (g *Group) AddCaption(source string, user *string, now time.Time, value interface{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.description.CaptionHistory {
+		g.captions = nil
+		return
+	}
+
+	g.discardObsoleteCaptions(now)
+	for len(g.captions) >= maxCaptionHistory {
+		copy(g.captions, g.captions[1:])
+		g.captions = g.captions[:len(g.captions)-1]
+	}
+	g.captions = append(g.captions,
+		Caption{Source: source, User: user, Time: now, Value: value},
+	)
+}
+
+// GetCaptions returns the captions sent recently.
+func (g *Group) GetCaptions(now time.Time) []Caption {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.description.CaptionHistory {
+		g.captions = nil
+		return nil
+	}
+
+	g.discardObsoleteCaptions(now)
+	if len(g.captions) == 0 {
+		return nil
+	}
+	c := make([]Caption, len(g.captions))
+	copy(c, g.captions)
+	return c
+}
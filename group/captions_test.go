@@ -0,0 +1,40 @@
+package group
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCaptions(t *testing.T) {
+	g := &Group{description: &Description{}}
+	now := time.Now()
+
+	g.AddCaption("source", nil, now, "hello")
+	if c := g.GetCaptions(now); c != nil {
+		t.Errorf("Expected nil, got %v", c)
+	}
+
+	g.description.CaptionHistory = true
+	g.AddCaption("source", nil, now, "hello")
+	g.AddCaption("source", nil, now.Add(20*time.Second), "world")
+	c := g.GetCaptions(now.Add(20 * time.Second))
+	if len(c) != 2 || c[0].Value != "hello" || c[1].Value != "world" {
+		t.Errorf("Expected [hello world], got %v", c)
+	}
+
+	c = g.GetCaptions(now.Add(40 * time.Second))
+	if len(c) != 1 || c[0].Value != "world" {
+		t.Errorf("Expected [world], got %v", c)
+	}
+
+	for i := 0; i < 2*maxCaptionHistory; i++ {
+		g.AddCaption("source", nil, now.Add(time.Minute),
+			fmt.Sprintf("%v", i))
+	}
+	c = g.GetCaptions(now.Add(time.Minute))
+	if len(c) != maxCaptionHistory ||
+		c[len(c)-1].Value != fmt.Sprintf("%v", 2*maxCaptionHistory-1) {
+		t.Errorf("Bad captions %v", c)
+	}
+}
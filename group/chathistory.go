@@ -0,0 +1,308 @@
+package group
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HistoryDirectory is the directory where chat history is stored for
+// groups that request persistent history.  If empty, chat history is
+// never written to disk.
+var HistoryDirectory string
+
+// historyEntry is the on-disk representation of a ChatHistoryEntry.
+type historyEntry struct {
+	Id     string      `json:"id,omitempty"`
+	Source string      `json:"source,omitempty"`
+	User   *string     `json:"username,omitempty"`
+	Time   time.Time   `json:"time"`
+	Kind   string      `json:"kind,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+func maxHistorySize(desc *Description) int {
+	if desc.MaxHistorySize > 0 {
+		return desc.MaxHistorySize
+	}
+	return maxChatHistory
+}
+
+func persistentHistory(desc *Description) bool {
+	return HistoryDirectory != "" && desc.PersistentHistory
+}
+
+func historyFilename(name string) string {
+	return filepath.Join(HistoryDirectory, filepath.FromSlash(name)+".jsonl")
+}
+
+// readHistory reads the chat history of a group from disk.  It returns
+// the entries that are recent enough, together with the number of lines
+// in the file.
+func readHistory(name string, max int, age time.Duration) ([]ChatHistoryEntry, int, error) {
+	f, err := os.Open(historyFilename(name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var h []ChatHistoryEntry
+	lines := 0
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var e historyEntry
+		err := decoder.Decode(&e)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		lines++
+		if time.Since(e.Time) > age {
+			continue
+		}
+		h = append(h, ChatHistoryEntry{
+			Id:     e.Id,
+			Source: e.Source,
+			User:   e.User,
+			Time:   e.Time,
+			Kind:   e.Kind,
+			Value:  e.Value,
+		})
+	}
+	if len(h) > max {
+		h = h[len(h)-max:]
+	}
+	return h, lines, nil
+}
+
+func writeEntry(encoder *json.Encoder, e ChatHistoryEntry) error {
+	return encoder.Encode(historyEntry{
+		Id:     e.Id,
+		Source: e.Source,
+		User:   e.User,
+		Time:   e.Time,
+		Kind:   e.Kind,
+		Value:  e.Value,
+	})
+}
+
+// appendHistory appends entries to the on-disk history of a group.
+func appendHistory(name string, h []ChatHistoryEntry) error {
+	filename := historyFilename(name)
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600,
+	)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, e := range h {
+		err = writeEntry(encoder, e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// rewriteHistory atomically replaces the on-disk history of a group.
+func rewriteHistory(name string, h []ChatHistoryEntry) error {
+	filename := historyFilename(name)
+	if len(h) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(filename), "history*.jsonl")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, e := range h {
+		err = writeEntry(encoder, e)
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// The on-disk history is written by a goroutine per group, so that no
+// file I/O is done with the group locked.  Consecutive appends are
+// batched, and a rewrite supersedes any writes that are still pending.
+
+type historyOp struct {
+	rewrite bool // replace the file rather than appending to it
+	entries []ChatHistoryEntry
+}
+
+type historyWriter struct {
+	ops  []historyOp
+	done chan struct{} // closed when the writer exits
+}
+
+var historyWriters struct {
+	mu      sync.Mutex
+	writers map[string]*historyWriter
+}
+
+// queueHistory schedules a write to the history of a group, starting
+// a writer if necessary.
+func queueHistory(name string, op historyOp) {
+	historyWriters.mu.Lock()
+	defer historyWriters.mu.Unlock()
+
+	if historyWriters.writers == nil {
+		historyWriters.writers = make(map[string]*historyWriter)
+	}
+	w := historyWriters.writers[name]
+	if w == nil {
+		w = &historyWriter{done: make(chan struct{})}
+		historyWriters.writers[name] = w
+		go runHistoryWriter(name, w)
+	}
+
+	if op.rewrite {
+		w.ops = nil
+	} else if n := len(w.ops); n > 0 && !w.ops[n-1].rewrite {
+		w.ops[n-1].entries = append(w.ops[n-1].entries, op.entries...)
+		return
+	}
+	w.ops = append(w.ops, op)
+}
+
+func runHistoryWriter(name string, w *historyWriter) {
+	defer close(w.done)
+	for {
+		historyWriters.mu.Lock()
+		ops := w.ops
+		w.ops = nil
+		if len(ops) == 0 {
+			delete(historyWriters.writers, name)
+			historyWriters.mu.Unlock()
+			return
+		}
+		historyWriters.mu.Unlock()
+
+		for _, op := range ops {
+			var err error
+			if op.rewrite {
+				err = rewriteHistory(name, op.entries)
+			} else {
+				err = appendHistory(name, op.entries)
+			}
+			if err != nil {
+				log.Printf("Write chat history for %v: %v",
+					name, err)
+			}
+		}
+	}
+}
+
+// waitHistory waits until the pending writes to the history of a group
+// have completed.
+func waitHistory(name string) {
+	historyWriters.mu.Lock()
+	w := historyWriters.writers[name]
+	historyWriters.mu.Unlock()
+	if w != nil {
+		<-w.done
+	}
+}
+
+// loadHistory reads the on-disk history of a group, if it hasn't been
+// read yet.  Called with g.mu not held.
+func (g *Group) loadHistory() {
+	g.mu.Lock()
+	if g.historyLoaded || !persistentHistory(g.description) {
+		g.mu.Unlock()
+		return
+	}
+	max := maxHistorySize(g.description)
+	age := maxHistoryAge(g.description)
+	g.mu.Unlock()
+
+	// a previous instance of the group may still be writing
+	waitHistory(g.name)
+	h, lines, err := readHistory(g.name, max, age)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.historyLoaded {
+		// somebody else was faster
+		return
+	}
+	g.historyLoaded = true
+	if err != nil {
+		log.Printf("Read chat history for %v: %v", g.name, err)
+		return
+	}
+	if lines > 0 {
+		g.history = h
+	}
+	g.historyLines = lines
+}
+
+// saveHistoryUnlocked schedules writing the last entry of the in-memory
+// history to disk, compacting the file if it has grown too large.
+// Called with g.mu held.
+func (g *Group) saveHistoryUnlocked() {
+	if !persistentHistory(g.description) || len(g.history) == 0 {
+		return
+	}
+	if g.historyLines >= 2*maxHistorySize(g.description) {
+		g.rewriteHistoryUnlocked()
+		return
+	}
+	queueHistory(g.name, historyOp{
+		entries: []ChatHistoryEntry{g.history[len(g.history)-1]},
+	})
+	g.historyLines++
+}
+
+// rewriteHistoryUnlocked schedules replacing the on-disk history with
+// the in-memory one.  Called with g.mu held.
+func (g *Group) rewriteHistoryUnlocked() {
+	if !persistentHistory(g.description) {
+		return
+	}
+	queueHistory(g.name, historyOp{
+		rewrite: true,
+		entries: append([]ChatHistoryEntry(nil), g.history...),
+	})
+	g.historyLines = len(g.history)
+}
@@ -0,0 +1,73 @@
+package group
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPersistentHistory(t *testing.T) {
+	HistoryDirectory = t.TempDir()
+	defer func() {
+		HistoryDirectory = ""
+	}()
+
+	desc := &Description{
+		PersistentHistory: true,
+		MaxHistorySize:    10,
+	}
+	g := &Group{name: "test/sub", description: desc}
+
+	user := "user"
+	g.AddToChatHistory(
+		"old", "source", &user,
+		time.Now().Add(-2*DefaultMaxHistoryAge), "", "old",
+	)
+	for i := 0; i < 35; i++ {
+		g.AddToChatHistory(
+			fmt.Sprintf("id-%v", i), "source", &user,
+			time.Now(), "", fmt.Sprintf("%v", i),
+		)
+	}
+	if len(g.history) != 10 {
+		t.Errorf("Expected 10, got %v", len(g.history))
+	}
+	if g.historyLines > 20 {
+		t.Errorf("History file was not compacted (%v)", g.historyLines)
+	}
+
+	g2 := &Group{name: "test/sub", description: desc}
+	h := g2.GetChatHistory()
+	if len(h) != 10 {
+		t.Fatalf("Expected 10, got %v", len(h))
+	}
+	for i, e := range h {
+		j := i + 25
+		if e.Id != fmt.Sprintf("id-%v", j) ||
+			e.Value.(string) != fmt.Sprintf("%v", j) ||
+			e.User == nil || *e.User != user {
+			t.Errorf("Expected %v, got %v", j, e)
+		}
+	}
+
+	g2.ClearChatHistory("id-30", "source")
+	g3 := &Group{name: "test/sub", description: desc}
+	if h := g3.GetChatHistory(); len(h) != 9 {
+		t.Errorf("Expected 9, got %v", len(h))
+	}
+
+	g3.ClearChatHistory("", "")
+	waitHistory("test/sub")
+	_, err := os.Stat(historyFilename("test/sub"))
+	if !os.IsNotExist(err) {
+		t.Errorf("History file was not removed: %v", err)
+	}
+
+	g4 := &Group{name: "test/other", description: &Description{}}
+	g4.AddToChatHistory("id", "source", &user, time.Now(), "", "x")
+	_, err = os.Stat(historyFilename("test/other"))
+	if !os.IsNotExist(err) {
+		t.Errorf("History written for non-persistent group: %v", err)
+	}
+}
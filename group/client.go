@@ -11,18 +11,22 @@ import (
 	"net"
 	"runtime"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
 
 	"github.com/jech/galene/conn"
+	"github.com/jech/galene/fips"
 )
 
 type RawPassword struct {
-	Type       string  `json:"type,omitempty"`
-	Hash       string  `json:"hash,omitempty"`
-	Key        *string `json:"key,omitempty"`
-	Salt       string  `json:"salt,omitempty"`
-	Iterations int     `json:"iterations,omitempty"`
+	Type        string  `json:"type,omitempty"`
+	Hash        string  `json:"hash,omitempty"`
+	Key         *string `json:"key,omitempty"`
+	Salt        string  `json:"salt,omitempty"`
+	Iterations  int     `json:"iterations,omitempty"`
+	Memory      int     `json:"memory,omitempty"`
+	Parallelism int     `json:"parallelism,omitempty"`
 }
 
 type Password RawPassword
@@ -40,7 +44,28 @@ func constantTimeCompare(a, b string) bool {
 	return len(a) == len(b) && equal
 }
 
+// ErrNotApproved is returned when matching a password hashed with an
+// algorithm that is not approved in FIPS mode.
+var ErrNotApproved = errors.New("password hash not approved in FIPS mode")
+
+// approved returns true if the password may be used in FIPS mode.
+// Plain passwords are stored unhashed, which is not approved, and
+// SP 800-132 requires a salt of at least 128 bits.
+func (p Password) approved() bool {
+	switch p.Type {
+	case "", "wildcard":
+		return true
+	case "pbkdf2":
+		return len(p.Salt) >= 2*16 && p.Iterations >= 1000
+	default:
+		return false
+	}
+}
+
 func (p Password) Match(pw string) (bool, error) {
+	if fips.Enabled && !p.approved() {
+		return false, ErrNotApproved
+	}
 	switch p.Type {
 	case "":
 		return false, nil
@@ -91,6 +116,31 @@ func (p Password) Match(pw string) (bool, error) {
 			return false, nil
 		}
 		return err == nil, err
+	case "argon2id":
+		if p.Key == nil {
+			return false, errors.New("missing key")
+		}
+		key, err := hex.DecodeString(*p.Key)
+		if err != nil {
+			return false, err
+		}
+		salt, err := hex.DecodeString(p.Salt)
+		if err != nil {
+			return false, err
+		}
+		if p.Iterations <= 0 || p.Memory <= 0 ||
+			p.Parallelism <= 0 || p.Parallelism > 255 {
+			return false, errors.New("bad argon2id parameters")
+		}
+		hashSemaphore <- struct{}{}
+		defer func() {
+			<-hashSemaphore
+		}()
+		theirKey := argon2.IDKey(
+			[]byte(pw), salt, uint32(p.Iterations),
+			uint32(p.Memory), uint8(p.Parallelism), uint32(len(key)),
+		)
+		return subtle.ConstantTimeCompare(key, theirKey) == 1, nil
 	default:
 		return false, errors.New("unknown password type")
 	}
@@ -115,7 +165,8 @@ func (p *Password) UnmarshalJSON(b []byte) error {
 }
 
 func (p Password) MarshalJSON() ([]byte, error) {
-	if p.Type == "plain" && p.Hash == "" && p.Salt == "" && p.Iterations == 0 {
+	if p.Type == "plain" && p.Hash == "" && p.Salt == "" &&
+		p.Iterations == 0 && p.Memory == 0 && p.Parallelism == 0 {
 		return json.Marshal(p.Key)
 	}
 	return json.Marshal(RawPassword(p))
@@ -131,6 +182,10 @@ type ClientCredentials struct {
 	Username *string
 	Password string
 	Token    string
+	// session cookies sent by the client, see session.go
+	Sessions []string
+	// the PIN entered by a caller of the SIP gateway, see dialin.go
+	PIN string
 }
 
 type Client interface {
@@ -3,6 +3,7 @@ package group
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,6 +12,8 @@ import (
 
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/jech/galene/fips"
 )
 
 var key1 = ""
@@ -36,6 +39,15 @@ var pw4 = Password{
 	Type: "bcrypt",
 	Key:  &key4,
 }
+var key7 = "af0f7de10ac087cfdbd9149cbdb143857a977d519ae40f9b066452f9587f0452"
+var pw7 = Password{
+	Type:        "argon2id",
+	Key:         &key7,
+	Salt:        "5b1e0c8ea3d7f2a4",
+	Iterations:  2,
+	Memory:      1024,
+	Parallelism: 1,
+}
 var pw5 = Password{}
 var pw6 = Password{
 	Type: "bad",
@@ -54,6 +66,9 @@ func TestGood(t *testing.T) {
 	if match, err := pw4.Match("pass"); err != nil || !match {
 		t.Errorf("pw4 doesn't match (%v)", err)
 	}
+	if match, err := pw7.Match("pass"); err != nil || !match {
+		t.Errorf("pw7 doesn't match (%v)", err)
+	}
 }
 
 func TestBad(t *testing.T) {
@@ -69,6 +84,9 @@ func TestBad(t *testing.T) {
 	if match, err := pw4.Match("bad"); err != nil || match {
 		t.Errorf("pw4 matches")
 	}
+	if match, err := pw7.Match("bad"); err != nil || match {
+		t.Errorf("pw7 matches")
+	}
 	if match, err := pw5.Match(""); err != nil || match {
 		t.Errorf("pw5 matches")
 	}
@@ -81,7 +99,7 @@ func TestBad(t *testing.T) {
 }
 
 func TestEmptyKey(t *testing.T) {
-	for _, tpe := range []string{"plain", "pbkdf2", "bcrypt", "bad"} {
+	for _, tpe := range []string{"plain", "pbkdf2", "bcrypt", "argon2id", "bad"} {
 		pw := Password{Type: tpe}
 		if match, err := pw.Match(""); err == nil || match {
 			t.Errorf("empty password of type %v didn't error", tpe)
@@ -94,13 +112,50 @@ func TestEmptyKey(t *testing.T) {
 	}
 }
 
+func TestFIPS(t *testing.T) {
+	fips.Enabled = true
+	defer func() {
+		fips.Enabled = fips.Boring
+	}()
+
+	salt := []byte("0123456789abcdef")
+	key := hex.EncodeToString(
+		pbkdf2.Key([]byte("pass"), salt, 4096, 32, sha256.New),
+	)
+	pw := Password{
+		Type:       "pbkdf2",
+		Hash:       "sha-256",
+		Key:        &key,
+		Salt:       hex.EncodeToString(salt),
+		Iterations: 4096,
+	}
+	if match, err := pw.Match("pass"); err != nil || !match {
+		t.Errorf("pbkdf2 doesn't match (%v)", err)
+	}
+	// plain
+	if match, err := pw2.Match("pass"); err != ErrNotApproved || match {
+		t.Errorf("pw2: got %v %v", match, err)
+	}
+
+	// short salt
+	if match, err := pw3.Match("pass"); err != ErrNotApproved || match {
+		t.Errorf("pw3: got %v %v", match, err)
+	}
+	if match, err := pw4.Match("pass"); err != ErrNotApproved || match {
+		t.Errorf("pw4: got %v %v", match, err)
+	}
+	if match, err := pw7.Match("pass"); err != ErrNotApproved || match {
+		t.Errorf("pw7: got %v %v", match, err)
+	}
+}
+
 func TestJSON(t *testing.T) {
 	plain, err := json.Marshal(pw2)
 	if err != nil || string(plain) != `"pass"` {
 		t.Errorf("Expected \"pass\", got %v", string(plain))
 	}
 
-	for _, pw := range []Password{pw1, pw2, pw3, pw4, pw5} {
+	for _, pw := range []Password{pw1, pw2, pw3, pw4, pw5, pw7} {
 		j, err := json.Marshal(pw)
 		if err != nil {
 			t.Fatalf("Marshal: %v", err)
@@ -0,0 +1,93 @@
+package group
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// Parsing a description is expensive, so we cache the descriptions of
+// groups that are not running.  A cached description is used as long as
+// its version and the versions of the descriptions it inherits from are
+// unchanged.  Failed lookups are cached for a short time, since checking
+// that a description doesn't exist requires looking up all of its
+// ancestors; they are flushed whenever we create a definition.
+
+const (
+	maxCachedDescriptions  = 1024
+	missingDescriptionTime = 2 * time.Second
+)
+
+var descriptionCache struct {
+	mu      sync.Mutex
+	entries map[string]*Description
+	// the time at which a lookup failed
+	missing map[string]time.Time
+}
+
+// cachedDescription is like readDescription, but uses the cache.
+func cachedDescription(name string) (*Description, error) {
+	now := time.Now()
+
+	descriptionCache.mu.Lock()
+	desc := descriptionCache.entries[name]
+	t, missing := descriptionCache.missing[name]
+	descriptionCache.mu.Unlock()
+
+	if missing && now.Sub(t) < missingDescriptionTime {
+		return nil, os.ErrNotExist
+	}
+	if desc != nil && descriptionUnchanged(name, desc) {
+		return desc, nil
+	}
+
+	desc, err := readDescription(name, true)
+
+	descriptionCache.mu.Lock()
+	defer descriptionCache.mu.Unlock()
+	delete(descriptionCache.entries, name)
+	delete(descriptionCache.missing, name)
+	if err == nil {
+		if descriptionCache.entries == nil {
+			descriptionCache.entries = make(map[string]*Description)
+		}
+		if len(descriptionCache.entries) >= maxCachedDescriptions {
+			// evict an arbitrary entry
+			for k := range descriptionCache.entries {
+				delete(descriptionCache.entries, k)
+				break
+			}
+		}
+		descriptionCache.entries[name] = desc
+	} else if errors.Is(err, os.ErrNotExist) {
+		if descriptionCache.missing == nil {
+			descriptionCache.missing = make(map[string]time.Time)
+		}
+		if len(descriptionCache.missing) >= maxCachedDescriptions {
+			expireMissing(now)
+		}
+		if len(descriptionCache.missing) < maxCachedDescriptions {
+			descriptionCache.missing[name] = now
+		}
+	}
+	return desc, err
+}
+
+// expireMissing discards the failed lookups that are too old.
+// Called locked.
+func expireMissing(now time.Time) {
+	for k, t := range descriptionCache.missing {
+		if now.Sub(t) >= missingDescriptionTime {
+			delete(descriptionCache.missing, k)
+		}
+	}
+}
+
+// forgetMissing flushes the cache of failed lookups.  It is called
+// whenever a definition is created, which may create subgroups too.
+func forgetMissing() {
+	descriptionCache.mu.Lock()
+	defer descriptionCache.mu.Unlock()
+	descriptionCache.missing = nil
+}
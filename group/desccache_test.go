@@ -0,0 +1,60 @@
+package group
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDescriptionCache(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+	filename := filepath.Join(Directory, "cached.json")
+
+	_, err = GetDescription("cached")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("GetDescription: got %v, expected ErrNotExist", err)
+	}
+
+	err = os.WriteFile(filename, []byte(`{"displayName": "one"}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// the failed lookup is cached
+	_, err = GetDescription("cached")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetDescription: got %v, expected ErrNotExist", err)
+	}
+
+	forgetMissing()
+	d1, err := GetDescription("cached")
+	if err != nil || d1.DisplayName != "one" {
+		t.Fatalf("GetDescription: got %v %v", d1, err)
+	}
+	d2, err := GetDescription("cached")
+	if err != nil || d2 != d1 {
+		t.Errorf("GetDescription: description was not cached")
+	}
+
+	err = os.WriteFile(filename, []byte(`{"displayName": "two!"}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filename, future, future)
+	d3, err := GetDescription("cached")
+	if err != nil || d3.DisplayName != "two!" {
+		t.Errorf("GetDescription: got %v %v", d3, err)
+	}
+
+	os.Remove(filename)
+	_, err = GetDescription("cached")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("GetDescription: got %v, expected ErrNotExist", err)
+	}
+}
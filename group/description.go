@@ -1,6 +1,7 @@
 package group
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/jech/galene/ice"
 	"github.com/jech/galene/token"
 )
 
@@ -35,6 +37,13 @@ var permissionsMap = map[string][]string{
 	"admin":   {"admin"},
 }
 
+// serverPermissionsMap contains the permissions that may only be granted
+// to server-wide users, see ServerUser.
+var serverPermissionsMap = map[string][]string{
+	"stats":    {"stats"},
+	"announce": {"announce"},
+}
+
 func NewPermissions(name string) (Permissions, error) {
 	_, ok := permissionsMap[name]
 	if !ok {
@@ -50,7 +59,10 @@ func (p Permissions) Permissions(desc *Description) []string {
 		return p.permissions
 	}
 
-	perms := permissionsMap[p.name]
+	perms, ok := permissionsMap[p.name]
+	if !ok {
+		perms = serverPermissionsMap[p.name]
+	}
 
 	op := false
 	present := false
@@ -69,7 +81,7 @@ func (p Permissions) Permissions(desc *Description) []string {
 		}
 	}
 
-	if desc != nil && desc.AllowRecording {
+	if desc != nil && desc.AllowRecording && !desc.PrivacyMode {
 		if op && !record {
 			// copy the slice
 			perms = append([]string{"record"}, perms...)
@@ -137,31 +149,47 @@ type UserDescription struct {
 
 // Custom MarshalJSON in order to omit empty fields
 func (u UserDescription) MarshalJSON() ([]byte, error) {
-	uu := make(map[string]any, 2)
+	return json.Marshal(u.fields())
+}
+
+func (u *UserDescription) fields() map[string]any {
+	uu := make(map[string]any, 3)
 	if u.Password.Type != "" {
 		uu["password"] = &u.Password
 	}
 	if u.Permissions.name != "" || u.Permissions.permissions != nil {
 		uu["permissions"] = &u.Permissions
 	}
-	return json.Marshal(uu)
+	return uu
 }
 
 // Description represents a group description together with some metadata
-// about the JSON file it was deserialised from.
+// about the definition it was deserialised from.
 type Description struct {
-	// The file this was deserialised from.  This is not necessarily
-	// the name of the group, for example in case of a subgroup.
-	FileName string `json:"-"`
+	// The name of the definition this was deserialised from.  This
+	// is not necessarily the name of the group, for example in case
+	// of a subgroup.
+	definition string
 
-	// The modtime and size of the file.  These are used to detect
-	// when a file has changed on disk.
-	modTime  time.Time `json:"-"`
-	fileSize int64     `json:"-"`
+	// The version of the definition in the store.  This is used to
+	// detect when a definition has changed.
+	version string
 
 	// Whether this is an automatically generated subgroup
 	isSubgroup bool `json:"-"`
 
+	// The versions of the definitions inherited by this description.
+	inherited []descriptionStamp
+
+	// The time zone of the schedule, set by checkSchedule.
+	scheduleLocation *time.Location
+
+	// The name of a group whose description is merged into this one.
+	Inherits string `json:"inherits,omitempty"`
+
+	// The name of a template whose description is merged into this one.
+	Template string `json:"template,omitempty"`
+
 	// The user-friendly group name
 	DisplayName string `json:"displayName,omitempty"`
 
@@ -187,18 +215,124 @@ type Description struct {
 	// The time for which history entries are kept.
 	MaxHistoryAge int `json:"max-history-age,omitempty"`
 
+	// The maximum number of history entries kept.
+	MaxHistorySize int `json:"max-history-size,omitempty"`
+
+	// The maximum bitrate, in bits per second, of a single stream.
+	// Unlimited if 0.
+	MaxBitrate uint64 `json:"max-bitrate,omitempty"`
+
+	// The maximum total bitrate, in bits per second, sent or received
+	// by a single user.  Unlimited if 0.
+	MaxUserBitrate uint64 `json:"max-user-bitrate,omitempty"`
+
+	// The maximum width and height, in pixels, and the maximum
+	// framerate, in frames per second, of the video published in the
+	// group.  Unlimited if 0.
+	MaxVideoWidth     int `json:"max-video-width,omitempty"`
+	MaxVideoHeight    int `json:"max-video-height,omitempty"`
+	MaxVideoFramerate int `json:"max-video-framerate,omitempty"`
+
+	// The maximum rate, in messages per second, at which a non-op
+	// client may send chat messages, user messages and actions.
+	// Unlimited if 0.
+	MaxMessageRate float64 `json:"max-message-rate,omitempty"`
+
+	// The number of messages that may be sent in a burst when
+	// max-message-rate is set.
+	MessageBurst int `json:"message-burst,omitempty"`
+
+	// The number of spare transceivers of each kind allocated in the
+	// connections to clients that support them, which avoids
+	// renegotiation when tracks are added.
+	SpareTransceivers int `json:"spare-transceivers,omitempty"`
+
+	// The keys that clients may set in their user data, mapped to
+	// the type of their values.  Only raisehand if unset.
+	UserData UserDataSchema `json:"user-data,omitempty"`
+
+	// The PIN that callers enter in order to join the group through
+	// the SIP gateway.  Dial-in is disabled if empty.
+	DialInPIN string `json:"dial-in-pin,omitempty"`
+
+	// Whether chat history is saved to disk.
+	PersistentHistory bool `json:"persistent-history,omitempty"`
+
+	// Whether recent captions are sent to joining clients.
+	CaptionHistory bool `json:"caption-history,omitempty"`
+
 	// Time after which joining is no longer allowed
 	Expires *time.Time `json:"expires,omitempty"`
 
 	// Time before which joining is not allowed
 	NotBefore *time.Time `json:"not-before,omitempty"`
 
+	// Weekly windows during which joining is allowed.  Always
+	// allowed if empty.
+	Schedule []ScheduleWindow `json:"schedule,omitempty"`
+
+	// The time zone of the schedule, the local time zone if empty.
+	ScheduleTimezone string `json:"schedule-timezone,omitempty"`
+
+	// Whether to kick out users when the schedule closes the group.
+	ScheduleKick bool `json:"schedule-kick,omitempty"`
+
 	// Whether recording is allowed.
 	AllowRecording bool `json:"allow-recording,omitempty"`
 
+	// The container format of recordings, either "webm" (the
+	// default) or "mp4".
+	RecordingFormat string `json:"recording-format,omitempty"`
+
+	// The layout of recordings, either "muxed" (the default), which
+	// records each stream into a single file, or "tracks", which
+	// records every track into a separate file described by
+	// a manifest.
+	RecordingLayout string `json:"recording-layout,omitempty"`
+
+	// The time, in seconds, after which recordings are deleted.
+	// Unlimited if 0.
+	RecordingMaxAge int `json:"recording-max-age,omitempty"`
+
+	// The maximum total size, in bytes, of the group's recordings;
+	// the oldest recordings are deleted first.  Unlimited if 0.
+	RecordingMaxSize int64 `json:"recording-max-size,omitempty"`
+
+	// The maximum interval, in seconds, between keyframes in
+	// recordings; the server requests a keyframe from the sender
+	// when it is exceeded.  The default is 4s, a negative value
+	// disables periodic requests.
+	RecordingKeyframeInterval int `json:"recording-keyframe-interval,omitempty"`
+
+	// Whether clients should protect the contents of the group from
+	// being captured.  Recording and file transfer are disabled, and
+	// clients are asked to watermark video with the viewer's name.
+	PrivacyMode bool `json:"privacy-mode,omitempty"`
+
 	// Whether creating tokens is allowed
 	UnrestrictedTokens bool `json:"unrestricted-tokens,omitempty"`
 
+	// The maximum number of unexpired tokens that users may create.
+	MaxTokens int `json:"max-tokens,omitempty"`
+
+	// The maximum lifetime, in seconds, of the tokens that users
+	// create.
+	MaxTokenLifetime int `json:"max-token-lifetime,omitempty"`
+
+	// Named sets of default values for stateful tokens.
+	TokenTemplates map[string]TokenTemplate `json:"token-templates,omitempty"`
+
+	// How tokens issued by an external identity provider are
+	// exchanged for stateful tokens.
+	TokenExchange *TokenExchange `json:"token-exchange,omitempty"`
+
+	// How users log in through an OpenID Connect provider.
+	OIDC *OIDC `json:"oidc,omitempty"`
+
+	// The permissions granted to users authenticated by the LDAP
+	// directory, by directory group.
+	LDAPUsers []LDAPRule `json:"ldap-users,omitempty"`
+
 	// Whether subgroups are created on the fly.
 	AutoSubgroups bool `json:"auto-subgroups,omitempty"`
 
@@ -208,12 +342,42 @@ type Description struct {
 	// Whether to kick all users when the last op logs out.
 	Autokick bool `json:"autokick,omitempty"`
 
+	// If non-zero, only the video of this many most recent speakers
+	// is forwarded.
+	ActiveSpeakers int `json:"active-speakers,omitempty"`
+
+	// If true, only the audio of the client holding the floor is
+	// forwarded, see floor.go.
+	FloorControl bool `json:"floor-control,omitempty"`
+
+	// If not nil, presenters may share files with the group, see
+	// dropbox.go.
+	DropBox *DropBoxDescription `json:"drop-box,omitempty"`
+
+	// URLs that are notified of the events in the group.
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+
+	// The time, in milliseconds, after which a redundant WHIP publisher
+	// that has stopped sending is replaced by its backup.
+	WhipFailoverGap int `json:"whip-failover-gap,omitempty"`
+
+	// RTSP streams, such as those of IP cameras, that the server
+	// publishes in the group, see rtsp.go.
+	RTSPSources []RTSPSource `json:"rtsp-sources,omitempty"`
+
+	// A group on another server whose streams are redistributed in
+	// this group, see upstream.go.
+	Upstream *Upstream `json:"upstream,omitempty"`
+
 	// Users allowed to login
 	Users map[string]UserDescription `json:"users,omitempty"`
 
 	// Credentials for user with arbitrary username
 	WildcardUser *UserDescription `json:"wildcard-user,omitempty"`
 
+	// Global users allowed to login, see globalusers.go
+	GlobalUsers map[string]GlobalUserReference `json:"global-users,omitempty"`
+
 	// The (public) keys used for token authentication.
 	AuthKeys []map[string]interface{} `json:"authKeys,omitempty"`
 
@@ -223,10 +387,41 @@ type Description struct {
 	// The URL of the authentication portal, if any.
 	AuthPortal string `json:"authPortal,omitempty"`
 
+	// ICE servers used by this group instead of the global ones.
+	ICEServers []ice.Server `json:"ice-servers,omitempty"`
+
+	// Restrictions on host candidates, overrides the global policy.
+	ICEPolicy *ICEPolicy `json:"ice-policy,omitempty"`
+
+	// The frequency of RTCP reports, overrides the global policy.
+	RTCP *RTCPPolicy `json:"rtcp,omitempty"`
+
 	// Codec preferences.  If empty, a suitable default is chosen in
 	// the APIFromNames function.
 	Codecs []string `json:"codecs,omitempty"`
 
+	// Whether to send redundant Opus audio (RFC 2198) to receivers
+	// that support it.
+	AudioRedundancy bool `json:"audio-redundancy,omitempty"`
+
+	// Whether clients receive audio only unless they ask for video.
+	ReceiveAudioOnly bool `json:"receive-audio-only,omitempty"`
+
+	// Whether to refuse to issue session cookies, see session.go.
+	NoSessionCookies bool `json:"no-session-cookies,omitempty"`
+
+	// Whether clients asking for the low rendition of a single-layer
+	// video stream receive a low framerate thumbnail.
+	Thumbnails bool `json:"thumbnails,omitempty"`
+
+	// Whether sharing the screen requires the "screenshare"
+	// permission in addition to "present".
+	RestrictScreenshare bool `json:"restrict-screenshare,omitempty"`
+
+	// Whether to protect video sent to receivers that support it with
+	// forward error correction, see rtpconn/fec.go.
+	FlexFEC bool `json:"flexfec,omitempty"`
+
 	// Obsolete fields
 	Op             []ClientPattern `json:"op,omitempty"`
 	Presenter      []ClientPattern `json:"presenter,omitempty"`
@@ -244,15 +439,24 @@ func maxHistoryAge(desc *Description) time.Duration {
 	return DefaultMaxHistoryAge
 }
 
-func getDescriptionFile[T any](name string, allowSubgroups bool, get func(string) (T, error)) (T, string, bool, error) {
+const DefaultMaxTokenLifetime = 30 * 24 * time.Hour
+
+func maxTokenLifetime(desc *Description) time.Duration {
+	if desc.MaxTokenLifetime > 0 {
+		return time.Duration(desc.MaxTokenLifetime) * time.Second
+	}
+	return DefaultMaxTokenLifetime
+}
+
+// findDescription looks up the definition that applies to a group, which
+// may be the definition of a parent group if allowSubgroups is true.  It
+// returns the result of get and the name of the definition.
+func findDescription[T any](name string, allowSubgroups bool, get func(string) (T, error)) (T, string, bool, error) {
 	isSubgroup := false
 	for name != "" {
-		fileName := filepath.Join(
-			Directory, path.Clean("/"+name)+".json",
-		)
-		r, err := get(fileName)
+		r, err := get(name)
 		if !errors.Is(err, os.ErrNotExist) {
-			return r, fileName, isSubgroup, err
+			return r, name, isSubgroup, err
 		}
 		if !allowSubgroups {
 			break
@@ -268,31 +472,32 @@ func getDescriptionFile[T any](name string, allowSubgroups bool, get func(string
 // descriptionMatch returns true if the description hasn't changed between
 // d1 and d2
 func descriptionMatch(d1, d2 *Description) bool {
-	if d1.FileName != d2.FileName {
-		return false
-	}
-
-	if d1.fileSize != d2.fileSize || !d1.modTime.Equal(d2.modTime) {
+	if d1.definition != d2.definition || d1.version != d2.version {
 		return false
 	}
-	return true
+	return stampsMatch(d1.inherited, d2.inherited)
 }
 
 // descriptionUnchanged returns true if a group's description hasn't
 // changed since it was last read.
 func descriptionUnchanged(name string, desc *Description) bool {
-	fi, fileName, _, err := getDescriptionFile(name, true, os.Stat)
-	if err != nil || fileName != desc.FileName {
+	s, err := getStore()
+	if err != nil {
+		return false
+	}
+	version, definition, _, err := findDescription(name, true, s.Version)
+	if err != nil || definition != desc.definition {
 		return false
 	}
 
-	if fi.Size() != desc.fileSize || !fi.ModTime().Equal(desc.modTime) {
+	if version != desc.version {
 		return false
 	}
-	return true
+	return inheritedUnchanged(s, desc)
 }
 
-// GetDescription gets a group description, either from cache or from disk
+// GetDescription gets a group description, either from cache or from the
+// store
 func GetDescription(name string) (*Description, error) {
 	g := Get(name)
 	if g != nil {
@@ -301,13 +506,20 @@ func GetDescription(name string) (*Description, error) {
 		}
 	}
 
-	return readDescription(name, true)
+	return cachedDescription(name)
 }
 
 // GetSanitisedDescription returns the subset of the description that is
-// published on the web interface together with a suitable ETag.
-func GetSanitisedDescription(name string) (*Description, string, error) {
-	d, err := GetDescription(name)
+// published on the web interface together with a suitable ETag.  If
+// expand is true, inherited fields are included.
+func GetSanitisedDescription(name string, expand bool) (*Description, string, error) {
+	var d *Description
+	var err error
+	if expand {
+		d, err = GetDescription(name)
+	} else {
+		d, err = readRawDescription(name)
+	}
 	if err != nil {
 		return nil, "", err
 	}
@@ -319,20 +531,45 @@ func GetSanitisedDescription(name string) (*Description, string, error) {
 	desc.Users = nil
 	desc.WildcardUser = nil
 	desc.AuthKeys = nil
-	return &desc, makeETag(desc.fileSize, desc.modTime), nil
+	if desc.OIDC != nil {
+		oidc := *desc.OIDC
+		oidc.ClientSecret = ""
+		desc.OIDC = &oidc
+	}
+	desc.ICEServers = hideICECredentials(desc.ICEServers)
+	desc.Upstream = hideUpstreamCredentials(desc.Upstream)
+	desc.RTSPSources = hideRTSPCredentials(desc.RTSPSources)
+	desc.Webhooks = hideWebhookSecrets(desc.Webhooks)
+	desc.DialInPIN = ""
+	if expand {
+		return &desc, expandedETag(d), nil
+	}
+	return &desc, makeETag(desc.version), nil
 }
 
 // GetDescriptionTag returns an ETag for a description.
 func GetDescriptionTag(name string) (string, error) {
-	fi, _, _, err := getDescriptionFile(name, false, os.Stat)
+	s, err := getStore()
+	if err != nil {
+		return "", err
+	}
+	version, err := s.Version(name)
 	if err != nil {
 		return "", err
 	}
-	return makeETag(fi.Size(), fi.ModTime()), nil
+	return makeETag(version), nil
 }
 
-func makeETag(fileSize int64, modTime time.Time) string {
-	return fmt.Sprintf("\"%v-%v\"", fileSize, modTime.UnixNano())
+func makeETag(version string) string {
+	return "\"" + version + "\""
+}
+
+// etagVersion returns the version contained in an ETag.
+func etagVersion(etag string) (string, bool) {
+	if len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		return "", false
+	}
+	return etag[1 : len(etag)-1], true
 }
 
 // DeleteDescription deletes a description (and therefore persistently
@@ -341,14 +578,24 @@ func DeleteDescription(name, etag string) error {
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
-	fi, fileName, _, err := getDescriptionFile(name, false, os.Stat)
+	s, err := getStore()
 	if err != nil {
 		return err
 	}
-	if etag != makeETag(fi.Size(), fi.ModTime()) {
+	version, ok := etagVersion(etag)
+	if !ok {
+		_, err := s.Version(name)
+		if err != nil {
+			return err
+		}
 		return ErrTagMismatch
 	}
-	return os.Remove(fileName)
+	err = s.Delete(name, version)
+	if err != nil {
+		return err
+	}
+	runHook("delete", name, "")
+	return nil
 }
 
 // UpdateDescription overwrites a description if it matches a given ETag.
@@ -358,20 +605,40 @@ func UpdateDescription(name, etag string, desc *Description) error {
 		return errors.New("description is not sanitised")
 	}
 
+	err := checkSchedule(desc)
+	if err != nil {
+		return err
+	}
+
+	err = desc.UserData.Check()
+	if err != nil {
+		return err
+	}
+
+	err = checkDialInPIN(desc.DialInPIN)
+	if err != nil {
+		return err
+	}
+
+	err = checkRTSPSources(desc.RTSPSources)
+	if err != nil {
+		return err
+	}
+
+	err = CheckUpstream(desc.Upstream)
+	if err != nil {
+		return err
+	}
+
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
 	oldetag := ""
-	var filename string
-	old, err := readDescription(name, false)
+	old, err := readRawDescription(name)
 	if err == nil {
-		oldetag = makeETag(old.fileSize, old.modTime)
-		filename = old.FileName
+		oldetag = makeETag(old.version)
 	} else if errors.Is(err, os.ErrNotExist) {
 		old = nil
-		filename = filepath.Join(
-			Directory, path.Clean("/"+name)+".json",
-		)
 	} else {
 		return err
 	}
@@ -381,16 +648,45 @@ func UpdateDescription(name, etag string, desc *Description) error {
 	}
 
 	newdesc := *desc
+	newdesc.definition = name
+	newdesc.version = ""
 	if old != nil {
+		newdesc.version = old.version
 		newdesc.Users = old.Users
 		newdesc.WildcardUser = old.WildcardUser
 		newdesc.AuthKeys = old.AuthKeys
+		if newdesc.OIDC != nil && newdesc.OIDC.ClientSecret == "" &&
+			old.OIDC != nil {
+			oidc := *newdesc.OIDC
+			oidc.ClientSecret = old.OIDC.ClientSecret
+			newdesc.OIDC = &oidc
+		}
+		newdesc.ICEServers =
+			keepICECredentials(newdesc.ICEServers, old.ICEServers)
+		newdesc.Upstream =
+			keepUpstreamCredentials(newdesc.Upstream, old.Upstream)
+		newdesc.RTSPSources =
+			keepRTSPCredentials(newdesc.RTSPSources, old.RTSPSources)
+		newdesc.Webhooks =
+			keepWebhookSecrets(newdesc.Webhooks, old.Webhooks)
+		if newdesc.DialInPIN == "" {
+			newdesc.DialInPIN = old.DialInPIN
+		}
 	}
 
-	return rewriteDescriptionFile(filename, &newdesc)
+	err = writeDescription(&newdesc)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		runHook("create", name, "")
+	}
+	return nil
 }
 
-func rewriteDescriptionFile(filename string, desc *Description) error {
+// writeDescription writes a description to the store.  It fails with
+// ErrTagMismatch if the definition has changed since desc was read.
+func writeDescription(desc *Description) error {
 	conf, err := GetConfiguration()
 	if err != nil {
 		return err
@@ -399,72 +695,113 @@ func rewriteDescriptionFile(filename string, desc *Description) error {
 		return ErrDescriptionsNotWritable
 	}
 
-	dir := filepath.Dir(filename)
-
-	err = os.MkdirAll(dir, 0700)
+	data, err := json.Marshal(desc)
 	if err != nil {
 		return err
 	}
+	data = append(data, '\n')
 
-	f, err := os.CreateTemp(dir, "*.temp")
+	s, err := getStore()
+	if err != nil {
+		return err
+	}
+	err = s.Put(desc.definition, data, desc.version)
 	if err != nil {
 		return err
 	}
-	temp := f.Name()
+	forgetMissing()
+	return nil
+}
 
-	encoder := json.NewEncoder(f)
-	err = encoder.Encode(desc)
-	if err == nil {
-		err = f.Sync()
+// readDescription reads a group's description from the store, and
+// resolves its inheritance chain.
+func readDescription(name string, allowSubgroups bool) (*Description, error) {
+	return readDescriptionFile(name, allowSubgroups, true)
+}
+
+// readRawDescription reads a group's description from the store without
+// resolving inheritance, which is suitable for modifying it.
+func readRawDescription(name string) (*Description, error) {
+	return readDescriptionFile(name, false, false)
+}
+
+type storedDescription struct {
+	data    []byte
+	version string
+}
+
+func readDescriptionFile(name string, allowSubgroups bool, expand bool) (*Description, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
 	}
+	r, definition, isSubgroup, err := findDescription(name, allowSubgroups,
+		func(name string) (storedDescription, error) {
+			data, version, err := s.Get(name)
+			return storedDescription{data, version}, err
+		},
+	)
 	if err != nil {
-		f.Close()
-		os.Remove(temp)
-		return err
+		return nil, err
 	}
-	err = f.Close()
+
+	var desc Description
+
+	data := r.data
+	var inherited []descriptionStamp
+	if expand {
+		data, inherited, err = expandDescription(s, data, definition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	err = d.Decode(&desc)
 	if err != nil {
-		os.Remove(temp)
-		return err
+		return nil, err
 	}
+	desc.definition = definition
+	desc.version = r.version
+	desc.inherited = inherited
 
-	err = os.Rename(temp, filename)
+	err = upgradeDescription(&desc)
 	if err != nil {
-		os.Remove(temp)
-		return err
+		return nil, err
 	}
 
-	return nil
+	err = checkSchedule(&desc)
+	if err != nil {
+		return nil, err
+	}
 
-}
+	err = desc.ICEPolicy.Check()
+	if err != nil {
+		return nil, err
+	}
 
-// readDescription reads a group's description from disk
-func readDescription(name string, allowSubgroups bool) (*Description, error) {
-	r, fileName, isSubgroup, err :=
-		getDescriptionFile(name, allowSubgroups, os.Open)
+	err = desc.RTCP.Check()
 	if err != nil {
 		return nil, err
 	}
-	defer r.Close()
 
-	var desc Description
+	err = desc.UserData.Check()
+	if err != nil {
+		return nil, err
+	}
 
-	fi, err := r.Stat()
+	err = checkDialInPIN(desc.DialInPIN)
 	if err != nil {
 		return nil, err
 	}
 
-	d := json.NewDecoder(r)
-	d.DisallowUnknownFields()
-	err = d.Decode(&desc)
+	err = checkRTSPSources(desc.RTSPSources)
 	if err != nil {
 		return nil, err
 	}
-	desc.FileName = fileName
-	desc.fileSize = fi.Size()
-	desc.modTime = fi.ModTime()
 
-	err = upgradeDescription(&desc)
+	err = CheckUpstream(desc.Upstream)
 	if err != nil {
 		return nil, err
 	}
@@ -485,7 +822,7 @@ func upgradeDescription(desc *Description) error {
 	if desc.AllowAnonymous {
 		log.Printf(
 			"%v: field allow-anonymous is obsolete, ignored",
-			desc.FileName,
+			desc.definition,
 		)
 		desc.AllowAnonymous = false
 	}
@@ -521,7 +858,7 @@ func upgradeDescription(desc *Description) error {
 			if u.Username == "" {
 				if desc.WildcardUser != nil {
 					log.Printf("%v: duplicate wildcard user",
-						desc.FileName)
+						desc.definition)
 					continue
 				}
 				u := upgradeUser(u, p)
@@ -531,7 +868,7 @@ func upgradeDescription(desc *Description) error {
 			_, found := desc.Users[u.Username]
 			if found {
 				log.Printf("%v: duplicate user %v, ignored",
-					desc.FileName, u.Username)
+					desc.definition, u.Username)
 				continue
 			}
 			desc.Users[u.Username] = upgradeUser(u, p)
@@ -555,16 +892,24 @@ func upgradeDescription(desc *Description) error {
 }
 
 func GetDescriptionNames() ([]string, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	return s.List("")
+}
+
+func descriptionNames(directory string) ([]string, error) {
 	var names []string
 	err := filepath.WalkDir(
-		Directory,
+		directory,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return nil
 			}
 			base := filepath.Base(path)
 			if d.IsDir() {
-				if base[0] == '.' {
+				if path != directory && base[0] == '.' {
 					return fs.SkipDir
 				}
 				return nil
@@ -572,7 +917,7 @@ func GetDescriptionNames() ([]string, error) {
 			if base[0] == '.' {
 				return nil
 			}
-			p, err := filepath.Rel(Directory, path)
+			p, err := filepath.Rel(directory, path)
 			if err != nil || !strings.HasSuffix(p, ".json") {
 				return nil
 			}
@@ -596,12 +941,12 @@ func SetKeys(group string, keys []map[string]any) error {
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
-	desc, err := readDescription(group, false)
+	desc, err := readRawDescription(group)
 	if err != nil {
 		return err
 	}
 	desc.AuthKeys = keys
-	return rewriteDescriptionFile(desc.FileName, desc)
+	return writeDescription(desc)
 }
 
 func GetUsers(group string) ([]string, string, error) {
@@ -615,10 +960,33 @@ func GetUsers(group string) ([]string, string, error) {
 		users = append(users, u)
 	}
 
-	return users, makeETag(desc.fileSize, desc.modTime), nil
+	return users, makeETag(desc.version), nil
 }
 
 func GetSanitisedUser(group, username string, wildcard bool) (UserDescription, string, error) {
+	u, etag, err := getUser(group, username, wildcard)
+	if err != nil {
+		return UserDescription{}, "", err
+	}
+	u.Password = Password{}
+	return u, etag, nil
+}
+
+// GetUserPasswordType returns the type of a user's password, without
+// revealing the password itself.  It returns os.ErrNotExist if the user
+// has no password.
+func GetUserPasswordType(group, username string, wildcard bool) (string, error) {
+	u, _, err := getUser(group, username, wildcard)
+	if err != nil {
+		return "", err
+	}
+	if u.Password.Type == "" {
+		return "", os.ErrNotExist
+	}
+	return u.Password.Type, nil
+}
+
+func getUser(group, username string, wildcard bool) (UserDescription, string, error) {
 	if wildcard && username != "" {
 		return UserDescription{}, "",
 			errors.New("wildcard with username")
@@ -647,8 +1015,7 @@ func GetSanitisedUser(group, username string, wildcard bool) (UserDescription, s
 		}
 	}
 
-	u.Password = Password{}
-	return u, makeETag(desc.fileSize, desc.modTime), nil
+	return u, makeETag(desc.version), nil
 }
 
 func GetUserTag(group, username string, wildcard bool) (string, error) {
@@ -664,7 +1031,7 @@ func DeleteUser(group, username string, wildcard bool, etag string) error {
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
-	desc, err := readDescription(group, false)
+	desc, err := readRawDescription(group)
 	if err != nil {
 		return err
 	}
@@ -683,7 +1050,7 @@ func DeleteUser(group, username string, wildcard bool, etag string) error {
 		}
 	}
 
-	oldetag := makeETag(desc.fileSize, desc.modTime)
+	oldetag := makeETag(desc.version)
 	if oldetag != etag {
 		return ErrTagMismatch
 	}
@@ -694,7 +1061,7 @@ func DeleteUser(group, username string, wildcard bool, etag string) error {
 		delete(desc.Users, username)
 	}
 
-	return rewriteDescriptionFile(desc.FileName, desc)
+	return writeDescription(desc)
 }
 
 func UpdateUser(group, username string, wildcard bool, etag string, user *UserDescription) error {
@@ -708,7 +1075,7 @@ func UpdateUser(group, username string, wildcard bool, etag string, user *UserDe
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
-	desc, err := readDescription(group, false)
+	desc, err := readRawDescription(group)
 	if err != nil {
 		return err
 	}
@@ -729,7 +1096,7 @@ func UpdateUser(group, username string, wildcard bool, etag string, user *UserDe
 
 	var oldetag string
 	if ok {
-		oldetag = makeETag(desc.fileSize, desc.modTime)
+		oldetag = makeETag(desc.version)
 	} else {
 		oldetag = ""
 	}
@@ -746,7 +1113,56 @@ func UpdateUser(group, username string, wildcard bool, etag string, user *UserDe
 	} else {
 		desc.Users[username] = newuser
 	}
-	return rewriteDescriptionFile(desc.FileName, desc)
+	return writeDescription(desc)
+}
+
+// A RosterEntry describes one of the users passed to ReplaceUsers.
+type RosterEntry struct {
+	Username    string      `json:"username"`
+	Permissions Permissions `json:"permissions"`
+	// If nil, the password of an existing user is preserved.
+	Password *Password `json:"password,omitempty"`
+}
+
+// ReplaceUsers atomically replaces the set of users of a group.  Users
+// not in the roster are deleted.  The wildcard user is not affected.
+func ReplaceUsers(group, etag string, roster []RosterEntry) error {
+	users := make(map[string]UserDescription, len(roster))
+	for _, e := range roster {
+		if e.Username == "" {
+			return errors.New("empty username")
+		}
+		if _, ok := users[e.Username]; ok {
+			return errors.New("duplicate username " + e.Username)
+		}
+		users[e.Username] = UserDescription{
+			Permissions: e.Permissions,
+		}
+	}
+
+	groups.mu.Lock()
+	defer groups.mu.Unlock()
+
+	desc, err := readRawDescription(group)
+	if err != nil {
+		return err
+	}
+
+	if makeETag(desc.version) != etag {
+		return ErrTagMismatch
+	}
+
+	for _, e := range roster {
+		u := users[e.Username]
+		if e.Password != nil {
+			u.Password = *e.Password
+		} else if old, ok := desc.Users[e.Username]; ok {
+			u.Password = old.Password
+		}
+		users[e.Username] = u
+	}
+	desc.Users = users
+	return writeDescription(desc)
 }
 
 func SetUserPassword(group, username string, wildcard bool, pw Password) error {
@@ -757,7 +1173,7 @@ func SetUserPassword(group, username string, wildcard bool, pw Password) error {
 	groups.mu.Lock()
 	defer groups.mu.Unlock()
 
-	desc, err := readDescription(group, false)
+	desc, err := readRawDescription(group)
 	if err != nil {
 		return err
 	}
@@ -779,5 +1195,5 @@ func SetUserPassword(group, username string, wildcard bool, pw Password) error {
 		user.Password = pw
 		desc.Users[username] = user
 	}
-	return rewriteDescriptionFile(desc.FileName, desc)
+	return writeDescription(desc)
 }
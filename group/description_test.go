@@ -34,6 +34,50 @@ func TestMarshalUserDescription(t *testing.T) {
 	}
 }
 
+func TestMarshalServerUser(t *testing.T) {
+	tests := []string{
+		`{}`,
+		`{"password":"secret","permissions":"admin"}`,
+		`{"password":"secret","scopes":["stats:read"]}`,
+		`{"password":"secret","permissions":"stats","scopes":["groups:write:a","tokens:create"]}`,
+		`{"password":"secret","permissions":"announce"}`,
+		`{"password":"secret","permissions":["admin"]}`,
+	}
+
+	for _, test := range tests {
+		var u ServerUser
+		err := json.Unmarshal([]byte(test), &u)
+		if err != nil {
+			t.Errorf("Unmarshal %v: %v", test, err)
+			continue
+		}
+		v, err := json.Marshal(u)
+		if err != nil || string(v) != test {
+			t.Errorf("Marshal %v: got %v %v", test, string(v), err)
+		}
+	}
+
+	var su ServerUser
+	err := json.Unmarshal([]byte(`{"permissions":"stats"}`), &su)
+	if err != nil || !reflect.DeepEqual(su.Permissions.Permissions(nil),
+		[]string{"stats"}) {
+		t.Errorf("Stats permission: %v %v", su.Permissions, err)
+	}
+	err = json.Unmarshal([]byte(`{"permissions":"bad"}`), &su)
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("Unknown server permission: %v", err)
+	}
+
+	// the server permissions cannot be granted by groups
+	for _, p := range []string{"stats", "announce"} {
+		var u UserDescription
+		err := json.Unmarshal([]byte(`{"permissions":"`+p+`"}`), &u)
+		if !errors.Is(err, ErrUnknownPermission) {
+			t.Errorf("Group permission %v: %v", p, err)
+		}
+	}
+}
+
 func TestEmptyJSON(t *testing.T) {
 	type emptyTest struct {
 		value  any
@@ -235,7 +279,7 @@ func TestWritableGroups(t *testing.T) {
 		t.Errorf("Mode is 0o%03o (expected 0o600)\n", mode)
 	}
 
-	desc, token, err := GetSanitisedDescription("test")
+	desc, token, err := GetSanitisedDescription("test", false)
 	if err != nil || token == "" {
 		t.Errorf("GetSanitisedDescription: got %v", err)
 	}
@@ -0,0 +1,79 @@
+package group
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"os"
+)
+
+// Callers of the SIP gateway select a group by entering its dial-in PIN,
+// which must be unique across all groups.  A caller who enters the PIN
+// of a group joins it with the present permission.
+
+const (
+	// PINs may be guessed by calling repeatedly, see sip/pinlimit.go
+	minDialInPIN = 8
+	maxDialInPIN = 16
+)
+
+// ErrUnknownPIN is returned by FindDialIn when no group has the given PIN.
+var ErrUnknownPIN = errors.New("unknown PIN")
+
+func checkDialInPIN(pin string) error {
+	if pin == "" {
+		return nil
+	}
+	if len(pin) < minDialInPIN || len(pin) > maxDialInPIN {
+		return errors.New("dial-in-pin must be between 8 and 16 digits")
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return errors.New("dial-in-pin must only contain digits")
+		}
+	}
+	return nil
+}
+
+func checkPIN(desc *Description, pin string) bool {
+	if desc.DialInPIN == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare(
+		[]byte(desc.DialInPIN), []byte(pin),
+	) == 1
+}
+
+// FindDialIn returns the name of the group whose dial-in PIN is pin.
+func FindDialIn(pin string) (string, error) {
+	if pin == "" {
+		return "", ErrUnknownPIN
+	}
+	names, err := GetDescriptionNames()
+	if err != nil {
+		return "", err
+	}
+	found := ""
+	for _, name := range names {
+		desc, err := GetDescription(name)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("Dial-in: %v: %v", name, err)
+			}
+			continue
+		}
+		if !checkPIN(desc, pin) {
+			continue
+		}
+		if found != "" {
+			log.Printf("Dial-in: groups %v and %v have the same PIN",
+				found, name)
+			return "", ErrUnknownPIN
+		}
+		found = name
+	}
+	if found == "" {
+		return "", ErrUnknownPIN
+	}
+	return found, nil
+}
@@ -0,0 +1,92 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckDialInPIN(t *testing.T) {
+	good := []string{"", "12345678", "0123456789012345"}
+	bad := []string{
+		"1234", "1234567", "01234567890123456", "12a45678", "1234 567",
+	}
+	for _, pin := range good {
+		if err := checkDialInPIN(pin); err != nil {
+			t.Errorf("%q: %v", pin, err)
+		}
+	}
+	for _, pin := range bad {
+		if err := checkDialInPIN(pin); err == nil {
+			t.Errorf("%q accepted", pin)
+		}
+	}
+}
+
+func TestFindDialIn(t *testing.T) {
+	Directory = t.TempDir()
+	descs := map[string]string{
+		"a":     `{"dial-in-pin": "12345678", "users": {"admin": {}}}`,
+		"b":     `{}`,
+		"sub/c": `{"dial-in-pin": "56785678"}`,
+		"d":     `{"dial-in-pin": "99999999"}`,
+		"e":     `{"dial-in-pin": "99999999"}`,
+	}
+	for name, desc := range descs {
+		filename := filepath.Join(Directory, name+".json")
+		err := os.MkdirAll(filepath.Dir(filename), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filename, []byte(desc), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]string{
+		"12345678": "a",
+		"56785678": "sub/c",
+		"00000000": "",
+		"":         "",
+		"99999999": "",
+	}
+	for pin, expected := range tests {
+		name, err := FindDialIn(pin)
+		if expected == "" {
+			if err != ErrUnknownPIN {
+				t.Errorf("%q: expected ErrUnknownPIN, got %v %v",
+					pin, name, err)
+			}
+		} else if err != nil || name != expected {
+			t.Errorf("%q: expected %v, got %v %v",
+				pin, expected, name, err)
+		}
+	}
+
+	g, err := Add("a", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer Delete("a")
+	username := "phone"
+	_, perms, err := g.GetPermission(ClientCredentials{
+		Username: &username, PIN: "12345678",
+	})
+	if err != nil || len(perms) != 1 || perms[0] != "present" {
+		t.Errorf("GetPermission: %v %v", perms, err)
+	}
+	_, _, err = g.GetPermission(ClientCredentials{
+		Username: &username, PIN: "87654321",
+	})
+	if err == nil {
+		t.Errorf("Bad PIN accepted")
+	}
+	admin := "admin"
+	_, _, err = g.GetPermission(ClientCredentials{
+		Username: &admin, PIN: "12345678",
+	})
+	if err != ErrDuplicateUsername {
+		t.Errorf("Expected ErrDuplicateUsername, got %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package group
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A group may have a drop box, where presenters upload files that are
+// made available to all members of the group.  The files are stored in
+// a subdirectory of DropBoxDirectory, and deleted when the group becomes
+// empty or the server restarts.
+//
+// Downloading a file requires either the credentials of a member of the
+// group, or an access string, which is given to every client in the
+// group status.  An access string is only valid as long as the client
+// it was given to remains in the group.
+
+// DropBoxDirectory is the directory where the files of drop boxes are
+// stored.  If empty, the system's temporary directory is used.
+var DropBoxDirectory string
+
+// DropBoxDescription is the configuration of a group's drop box.
+type DropBoxDescription struct {
+	// The maximum size of a file, in bytes.
+	MaxSize int64 `json:"max-size,omitempty"`
+	// The maximum total size of the files, in bytes.
+	MaxTotal int64 `json:"max-total,omitempty"`
+	// The allowed media types, which may contain wildcards,
+	// such as "image/*".  If empty, all types are allowed.
+	Types []string `json:"types,omitempty"`
+}
+
+// The default limits of a drop box.
+const (
+	defaultDropBoxMaxSize  = 32 * 1024 * 1024
+	defaultDropBoxMaxTotal = 256 * 1024 * 1024
+)
+
+var ErrNoDropBox = errors.New("this group has no drop box")
+var ErrFileTooLarge = errors.New("file too large")
+var ErrDropBoxFull = errors.New("drop box is full")
+var ErrBadFileType = errors.New("file type not allowed")
+var ErrBadFilename = errors.New("bad filename")
+
+// File describes a file in a drop box.
+type File struct {
+	Id       string    `json:"id"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Size     int64     `json:"size"`
+	Username string    `json:"username,omitempty"`
+	Time     time.Time `json:"time"`
+	// the access string of the client that the file was sent to
+	Access string `json:"access,omitempty"`
+}
+
+type dropBox struct {
+	directory string
+	files     []File
+}
+
+func (d *DropBoxDescription) maxSize() int64 {
+	if d.MaxSize > 0 {
+		return d.MaxSize
+	}
+	return defaultDropBoxMaxSize
+}
+
+func (d *DropBoxDescription) maxTotal() int64 {
+	if d.MaxTotal > 0 {
+		return d.MaxTotal
+	}
+	return defaultDropBoxMaxTotal
+}
+
+// typeAllowed returns true if files of media type ctype may be uploaded.
+func (d *DropBoxDescription) typeAllowed(ctype string) bool {
+	if len(d.Types) == 0 {
+		return true
+	}
+	for _, t := range d.Types {
+		ok, _ := path.Match(t, ctype)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func newFileId() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// called locked
+func (g *Group) dropBoxSize() int64 {
+	var total int64
+	if g.dropbox != nil {
+		for _, f := range g.dropbox.files {
+			total += f.Size
+		}
+	}
+	return total
+}
+
+// AddFile stores a file in the group's drop box, and notifies the
+// members of the group.
+func (g *Group) AddFile(username, name, ctype string, r io.Reader) (File, error) {
+	desc := g.Description()
+	if desc.DropBox == nil {
+		return File{}, ErrNoDropBox
+	}
+	mtype, _, err := mime.ParseMediaType(ctype)
+	if err != nil || !desc.DropBox.typeAllowed(mtype) {
+		return File{}, ErrBadFileType
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "" || name == "." || name == "/" {
+		return File{}, ErrBadFilename
+	}
+	id, err := newFileId()
+	if err != nil {
+		return File{}, err
+	}
+
+	g.mu.Lock()
+	if g.dropbox == nil {
+		dir, err := newDropBoxDirectory()
+		if err != nil {
+			g.mu.Unlock()
+			return File{}, err
+		}
+		g.dropbox = &dropBox{directory: dir}
+	}
+	dir := g.dropbox.directory
+	limit := min(
+		desc.DropBox.maxSize(),
+		desc.DropBox.maxTotal()-g.dropBoxSize(),
+	)
+	g.mu.Unlock()
+
+	if limit <= 0 {
+		return File{}, ErrDropBoxFull
+	}
+
+	filename := filepath.Join(dir, id)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return File{}, err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	err2 := f.Close()
+	if err == nil {
+		err = err2
+	}
+	if err == nil && n > limit {
+		err = ErrFileTooLarge
+		if limit < desc.DropBox.maxSize() {
+			err = ErrDropBoxFull
+		}
+	}
+	if err != nil {
+		os.Remove(filename)
+		return File{}, err
+	}
+
+	file := File{
+		Id:       id,
+		Name:     name,
+		Type:     mtype,
+		Size:     n,
+		Username: username,
+		Time:     time.Now(),
+	}
+
+	g.mu.Lock()
+	if g.dropbox == nil || g.dropbox.directory != dir ||
+		g.dropBoxSize()+n > desc.DropBox.maxTotal() {
+		// the drop box was cleared or filled concurrently
+		g.mu.Unlock()
+		os.Remove(filename)
+		return File{}, ErrDropBoxFull
+	}
+	g.dropbox.files = append(g.dropbox.files, file)
+	clients := g.getClientsUnlocked(nil)
+	g.mu.Unlock()
+
+	for _, c := range clients {
+		c.Joined(g.Name(), "change")
+	}
+	return file, nil
+}
+
+// GetFile returns the description of a file in the drop box, and the name
+// of the file that holds its contents.
+func (g *Group) GetFile(id string) (File, string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.dropbox != nil {
+		for _, f := range g.dropbox.files {
+			if f.Id == id {
+				return f, filepath.Join(g.dropbox.directory, id), nil
+			}
+		}
+	}
+	return File{}, "", os.ErrNotExist
+}
+
+// ClientFiles is like Files, but includes the access strings of
+// a given client.
+func (g *Group) ClientFiles(id string) []File {
+	files := g.Files()
+	for i := range files {
+		files[i].Access = g.fileAccess(files[i].Id, id)
+	}
+	return files
+}
+
+func (g *Group) fileAccess(file, client string) string {
+	mac := sessionMAC("file\x00" + g.name + "\x00" + file + "\x00" + client)
+	return client + "." + base64.RawURLEncoding.EncodeToString(mac)
+}
+
+// CheckFileAccess returns true if access is a valid access string for
+// the given file, and the client that it was given to is still in the
+// group.
+func (g *Group) CheckFileAccess(file, access string) bool {
+	client, _, found := strings.Cut(access, ".")
+	if !found || client == "" {
+		return false
+	}
+	if !hmac.Equal([]byte(access), []byte(g.fileAccess(file, client))) {
+		return false
+	}
+	return g.GetClient(client) != nil
+}
+
+// Files returns the files in the drop box, oldest first.
+func (g *Group) Files() []File {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.dropbox == nil {
+		return nil
+	}
+	files := make([]File, len(g.dropbox.files))
+	copy(files, g.dropbox.files)
+	return files
+}
+
+// DelFile removes a file from the drop box.
+func (g *Group) DelFile(id string) error {
+	g.mu.Lock()
+	if g.dropbox == nil {
+		g.mu.Unlock()
+		return os.ErrNotExist
+	}
+	files := g.dropbox.files
+	for i, f := range files {
+		if f.Id == id {
+			g.dropbox.files = append(files[:i:i], files[i+1:]...)
+			err := os.Remove(filepath.Join(g.dropbox.directory, id))
+			clients := g.getClientsUnlocked(nil)
+			g.mu.Unlock()
+			if err != nil {
+				log.Printf("Remove file: %v", err)
+			}
+			for _, c := range clients {
+				c.Joined(g.Name(), "change")
+			}
+			return nil
+		}
+	}
+	g.mu.Unlock()
+	return os.ErrNotExist
+}
+
+// clearFiles empties the drop box.  It returns the directory that
+// contains the files, which the caller must delete by calling
+// removeDropBox after releasing the lock.
+//
+// called locked
+func (g *Group) clearFiles() string {
+	if g.dropbox == nil {
+		return ""
+	}
+	dir := g.dropbox.directory
+	g.dropbox = nil
+	return dir
+}
+
+func removeDropBox(dir string) {
+	if dir == "" {
+		return
+	}
+	err := os.RemoveAll(dir)
+	if err != nil {
+		log.Printf("Remove drop box: %v", err)
+	}
+}
+
+func newDropBoxDirectory() (string, error) {
+	if DropBoxDirectory == "" {
+		return os.MkdirTemp("", "galene-dropbox-")
+	}
+	err := os.MkdirAll(DropBoxDirectory, 0700)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(DropBoxDirectory, "dropbox-")
+}
+
+// ClearDropBoxes deletes the files left over from a previous run of the
+// server.  It is called at startup.
+func ClearDropBoxes() {
+	if DropBoxDirectory != "" {
+		removeDropBox(DropBoxDirectory)
+	}
+}
@@ -0,0 +1,124 @@
+package group
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// accessClient is a client that is only looked up by id.
+type accessClient struct {
+	Client
+}
+
+func TestDropBox(t *testing.T) {
+	g := &Group{
+		name: "test",
+		description: &Description{
+			DropBox: &DropBoxDescription{
+				MaxSize:  10,
+				MaxTotal: 15,
+				Types:    []string{"application/pdf", "image/*"},
+			},
+		},
+		clients: make(map[string]Client),
+	}
+
+	_, err := g.AddFile("alice", "a.html", "text/html",
+		strings.NewReader("<p>"))
+	if !errors.Is(err, ErrBadFileType) {
+		t.Errorf("Expected ErrBadFileType, got %v", err)
+	}
+	_, err = g.AddFile("alice", "a.pdf", "application/pdf",
+		strings.NewReader("01234567890"))
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Expected ErrFileTooLarge, got %v", err)
+	}
+
+	f, err := g.AddFile("alice", "../slides.pdf", "application/pdf",
+		strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if f.Name != "slides.pdf" || f.Size != 10 || f.Username != "alice" {
+		t.Errorf("Bad file %#v", f)
+	}
+	_, err = g.AddFile("alice", "b.png", "image/png",
+		strings.NewReader("012345"))
+	if !errors.Is(err, ErrDropBoxFull) {
+		t.Errorf("Expected ErrDropBoxFull, got %v", err)
+	}
+
+	f2, filename, err := g.GetFile(f.Id)
+	if err != nil || f2 != f {
+		t.Fatalf("GetFile: %v %v", f2, err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil || !bytes.Equal(data, []byte("0123456789")) {
+		t.Errorf("ReadFile: %v %v", data, err)
+	}
+	if files := g.Files(); len(files) != 1 || files[0] != f {
+		t.Errorf("Files: %v", files)
+	}
+
+	g.clients["client"] = accessClient{}
+	files := g.ClientFiles("client")
+	if len(files) != 1 || files[0].Access == "" {
+		t.Fatalf("ClientFiles: %v", files)
+	}
+	access := files[0].Access
+	if !g.CheckFileAccess(f.Id, access) {
+		t.Errorf("Access denied")
+	}
+	for _, a := range []string{
+		"", "client", "client.", access + "x",
+		g.ClientFiles("other")[0].Access,
+	} {
+		if g.CheckFileAccess(f.Id, a) {
+			t.Errorf("Access %v granted", a)
+		}
+	}
+	if g.CheckFileAccess("other", access) {
+		t.Errorf("Access granted to another file")
+	}
+	delete(g.clients, "client")
+	if g.CheckFileAccess(f.Id, access) {
+		t.Errorf("Access granted after the client left")
+	}
+
+	err = g.DelFile(f.Id)
+	if err != nil {
+		t.Errorf("DelFile: %v", err)
+	}
+	if _, _, err := g.GetFile(f.Id); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist, got %v", err)
+	}
+	if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("File not deleted: %v", err)
+	}
+
+	_, err = g.AddFile("alice", "c.png", "image/png",
+		strings.NewReader("012345"))
+	if err != nil {
+		t.Errorf("AddFile: %v", err)
+	}
+	dir := g.dropbox.directory
+	g.mu.Lock()
+	removeDropBox(g.clearFiles())
+	g.mu.Unlock()
+	if g.Files() != nil {
+		t.Errorf("Files not cleared")
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Directory not deleted: %v", err)
+	}
+
+	g.description = &Description{}
+	_, err = g.AddFile("alice", "c.png", "image/png",
+		strings.NewReader("012345"))
+	if !errors.Is(err, ErrNoDropBox) {
+		t.Errorf("Expected ErrNoDropBox, got %v", err)
+	}
+}
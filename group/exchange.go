@@ -0,0 +1,160 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+// Token exchange allows a client holding a JWT issued by an external
+// identity provider to obtain a stateful token for a group.  The claims
+// of the JWT are mapped to permissions by a list of rules.
+
+// TokenExchange describes how externally issued tokens are exchanged.
+type TokenExchange struct {
+	// The public keys of the identity provider.
+	Keys []map[string]any `json:"keys"`
+	// The required value of the "iss" claim.
+	Issuer string `json:"issuer,omitempty"`
+	// A value required in the "aud" claim.  Since the identity
+	// provider may issue tokens for other services, exchange is
+	// refused if it is not set.
+	Audience string `json:"audience,omitempty"`
+	// The claim that holds the username, "sub" by default.
+	UsernameClaim string `json:"username-claim,omitempty"`
+	// The maximum time, in seconds, for which the stateful token is
+	// valid.  The token never outlives the external token.
+	Validity int `json:"validity,omitempty"`
+	// The rules that map claims to permissions.  The first rule that
+	// matches applies.
+	Rules []ExchangeRule `json:"rules"`
+}
+
+// An ExchangeRule grants permissions to the bearers of tokens whose claim
+// Claim has value Value, or contains Value if it is an array.  A rule
+// with an empty claim matches all tokens.
+type ExchangeRule struct {
+	Claim       string      `json:"claim,omitempty"`
+	Value       string      `json:"value,omitempty"`
+	Permissions Permissions `json:"permissions"`
+}
+
+const defaultExchangeValidity = time.Hour
+
+// ErrExchangeDenied is returned when a token may not be exchanged.
+var ErrExchangeDenied = &NotAuthorisedError{
+	err: errors.New("token exchange denied"),
+}
+
+var errExchangeMisconfigured = &NotAuthorisedError{
+	err: errors.New("token exchange requires an issuer and an audience"),
+}
+
+func (rule *ExchangeRule) match(claims map[string]any) bool {
+	if rule.Claim == "" {
+		return true
+	}
+	switch v := claims[rule.Claim].(type) {
+	case string:
+		return v == rule.Value
+	case []any:
+		for _, w := range v {
+			if s, ok := w.(string); ok && s == rule.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExchangeToken validates an externally issued JWT according to the
+// group's token-exchange definition, and returns a new stateful token
+// for the group.  The returned token has not been saved.
+func ExchangeToken(g *Group, jwt string, now time.Time) (*token.Stateful, error) {
+	desc := g.Description()
+	exchange := desc.TokenExchange
+	if exchange == nil {
+		return nil, ErrExchangeDenied
+	}
+	if exchange.Issuer == "" || exchange.Audience == "" {
+		return nil, errExchangeMisconfigured
+	}
+
+	claims, err := token.VerifyJWT(
+		jwt, exchange.Keys, exchange.Issuer, exchange.Audience,
+	)
+	if err != nil {
+		return nil, &NotAuthorisedError{err: err}
+	}
+
+	usernameClaim := exchange.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	tok, err := claimsToken(g, claims, usernameClaim, exchange.Rules,
+		exchange.Validity, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		e := time.Unix(int64(exp), 0)
+		if e.Before(*tok.Expires) {
+			tok.Expires = &e
+		}
+	}
+	return tok, nil
+}
+
+// claimsToken returns a stateful token for the user described by the
+// claims of a verified JWT, with the permissions granted by the first
+// matching rule.  Validity is in seconds.
+func claimsToken(g *Group, claims map[string]any, usernameClaim string, rules []ExchangeRule, validity int, now time.Time) (*token.Stateful, error) {
+	desc := g.Description()
+	username, ok := claims[usernameClaim].(string)
+	if !ok || username == "" {
+		return nil, ErrExchangeDenied
+	}
+	if g.UserExists(username) {
+		// don't allow the identity provider to impersonate the
+		// users defined in the group
+		return nil, ErrDuplicateUsername
+	}
+
+	var perms []string
+	found := false
+	for i := range rules {
+		if rules[i].match(claims) {
+			perms = rules[i].Permissions.Permissions(desc)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, ErrExchangeDenied
+	}
+
+	v := defaultExchangeValidity
+	if validity > 0 {
+		v = time.Duration(validity) * time.Second
+	}
+	expires := now.Add(v)
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	tok := &token.Stateful{
+		Token:       base64.RawURLEncoding.EncodeToString(buf),
+		Group:       g.Name(),
+		Username:    &username,
+		Permissions: append([]string{}, perms...),
+		Expires:     &expires,
+		IssuedAt:    &now,
+	}
+	if iss, ok := claims["iss"].(string); ok && iss != "" {
+		tok.IssuedBy = &iss
+	}
+	return tok, nil
+}
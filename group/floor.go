@@ -0,0 +1,106 @@
+package group
+
+import (
+	"time"
+)
+
+// In floor-control mode, only the audio of the client that holds the
+// floor is forwarded.  The floor is either taken explicitly, in which case
+// it is kept until it is released, or automatically by the first client
+// that speaks while the floor is free, in which case it is lost after
+// FloorTimeout of silence.  Operators may give the floor to any client.
+
+// FloorTimeout is the duration of silence after which a client that
+// took the floor automatically loses it.
+const FloorTimeout = 2 * time.Second
+
+var ErrFloorTaken = UserError("somebody else has the floor")
+
+type floorState struct {
+	holder   string
+	explicit bool
+	// the last time an automatic holder spoke
+	last time.Time
+}
+
+// FloorControl returns true if the group is in floor-control mode.
+func (g *Group) FloorControl() bool {
+	return g.Description().FloorControl
+}
+
+// Floor returns the id of the client holding the floor, or the empty
+// string if the floor is free.
+func (g *Group) Floor() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.floor.holder
+}
+
+// called locked
+func (g *Group) floorFree(now time.Time) bool {
+	return g.floor.holder == "" ||
+		(!g.floor.explicit && now.Sub(g.floor.last) >= FloorTimeout)
+}
+
+// floorChanged notifies all clients that the holder of the floor has
+// changed.
+func (g *Group) floorChanged() {
+	for _, c := range g.GetClients(nil) {
+		c.Joined(g.Name(), "change")
+	}
+}
+
+// SpeakFloor records the fact that client id is speaking, and gives it
+// the floor if it is free.  It returns true if the holder of the floor
+// has changed.
+func (g *Group) SpeakFloor(id string, now time.Time) bool {
+	g.mu.Lock()
+	if g.floor.holder == id {
+		g.floor.last = now
+		g.mu.Unlock()
+		return false
+	}
+	if !g.floorFree(now) {
+		g.mu.Unlock()
+		return false
+	}
+	g.floor = floorState{holder: id, last: now}
+	g.mu.Unlock()
+
+	g.floorChanged()
+	return true
+}
+
+// TakeFloor gives the floor to client id until it is released.  If force
+// is false, it fails if another client holds the floor.  If force is true
+// and id is empty, the floor is freed.
+func (g *Group) TakeFloor(id string, force bool, now time.Time) error {
+	g.mu.Lock()
+	if !force && g.floor.holder != id && !g.floorFree(now) {
+		g.mu.Unlock()
+		return ErrFloorTaken
+	}
+	old := g.floor.holder
+	g.floor = floorState{holder: id, explicit: id != ""}
+	g.mu.Unlock()
+
+	if old != id {
+		g.floorChanged()
+	}
+	return nil
+}
+
+// ReleaseFloor frees the floor if it is held by client id.  It returns
+// true if the floor was freed.
+func (g *Group) ReleaseFloor(id string) bool {
+	g.mu.Lock()
+	if id == "" || g.floor.holder != id {
+		g.mu.Unlock()
+		return false
+	}
+	g.floor = floorState{}
+	g.mu.Unlock()
+
+	g.floorChanged()
+	return true
+}
@@ -0,0 +1,66 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFloor(t *testing.T) {
+	g := &Group{
+		name:        "test",
+		description: &Description{FloorControl: true},
+		clients:     make(map[string]Client),
+	}
+	now := time.Now()
+
+	if !g.FloorControl() || g.Floor() != "" {
+		t.Fatalf("Bad initial state")
+	}
+
+	if !g.SpeakFloor("a", now) || g.Floor() != "a" {
+		t.Errorf("Speaker didn't get the free floor")
+	}
+	if g.SpeakFloor("b", now.Add(time.Second)) || g.Floor() != "a" {
+		t.Errorf("Speaker took the floor from a recent speaker")
+	}
+	if g.SpeakFloor("a", now.Add(2*time.Second)) {
+		t.Errorf("Holder changed when speaking")
+	}
+	now = now.Add(2*time.Second + FloorTimeout)
+	if !g.SpeakFloor("b", now) || g.Floor() != "b" {
+		t.Errorf("Speaker didn't get the floor after silence")
+	}
+
+	err := g.TakeFloor("c", false, now)
+	if err != ErrFloorTaken {
+		t.Errorf("Expected ErrFloorTaken, got %v", err)
+	}
+	err = g.TakeFloor("c", false, now.Add(FloorTimeout))
+	if err != nil || g.Floor() != "c" {
+		t.Errorf("TakeFloor: %v %v", err, g.Floor())
+	}
+	now = now.Add(10 * FloorTimeout)
+	if g.SpeakFloor("a", now) || g.Floor() != "c" {
+		t.Errorf("Speaker took an explicit floor")
+	}
+	if g.TakeFloor("a", false, now) != ErrFloorTaken {
+		t.Errorf("TakeFloor succeeded on an explicit floor")
+	}
+	if g.ReleaseFloor("a") || g.Floor() != "c" {
+		t.Errorf("Non-holder released the floor")
+	}
+
+	err = g.TakeFloor("a", true, now)
+	if err != nil || g.Floor() != "a" {
+		t.Errorf("Forced TakeFloor: %v %v", err, g.Floor())
+	}
+	if !g.ReleaseFloor("a") || g.Floor() != "" {
+		t.Errorf("ReleaseFloor failed")
+	}
+
+	g.TakeFloor("b", true, now)
+	err = g.TakeFloor("", true, now)
+	if err != nil || g.Floor() != "" {
+		t.Errorf("Couldn't free the floor: %v %v", err, g.Floor())
+	}
+}
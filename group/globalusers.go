@@ -0,0 +1,295 @@
+package group
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Global users are accounts defined once for the whole server, in the
+// file users.json in the data directory, rather than in a group
+// definition.  A global user may only log into the groups that reference
+// it in their "global-users" field, which may override the user's
+// permissions.  A user defined in the group itself takes precedence over
+// a global user with the same name.
+
+// A GlobalUserReference allows a global user to log into a group.
+type GlobalUserReference struct {
+	// If set, overrides the permissions of the global user.
+	Permissions *Permissions `json:"permissions,omitempty"`
+}
+
+var globalUsers struct {
+	mu       sync.Mutex
+	modTime  time.Time
+	fileSize int64
+	users    map[string]UserDescription
+}
+
+func globalUsersFilename() string {
+	return filepath.Join(DataDirectory, "users.json")
+}
+
+// called locked
+func loadGlobalUsers() error {
+	filename := globalUsersFilename()
+	fi, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			globalUsers.users = nil
+			globalUsers.modTime = time.Time{}
+			globalUsers.fileSize = 0
+			return nil
+		}
+		return err
+	}
+	if globalUsers.modTime.Equal(fi.ModTime()) &&
+		globalUsers.fileSize == fi.Size() {
+		return nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var users map[string]UserDescription
+	err = json.Unmarshal(data, &users)
+	if err != nil {
+		return err
+	}
+	globalUsers.users = users
+	globalUsers.modTime = fi.ModTime()
+	globalUsers.fileSize = fi.Size()
+	return nil
+}
+
+// called locked
+func writeGlobalUsers(users map[string]UserDescription) error {
+	filename := globalUsersFilename()
+	data, err := json.MarshalIndent(users, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	globalUsers.users = users
+	fi, err := os.Stat(filename)
+	if err != nil {
+		// force reading next time
+		globalUsers.modTime = time.Time{}
+		return nil
+	}
+	globalUsers.modTime = fi.ModTime()
+	globalUsers.fileSize = fi.Size()
+	return nil
+}
+
+// called locked; returns a copy that may be modified and passed to
+// writeGlobalUsers.
+func copyGlobalUsers() map[string]UserDescription {
+	users := make(map[string]UserDescription, len(globalUsers.users)+1)
+	for k, v := range globalUsers.users {
+		users[k] = v
+	}
+	return users
+}
+
+// globalETag returns an ETag that changes whenever v changes.
+func globalETag(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	h := sha256.Sum256(data)
+	return makeETag(base64.RawURLEncoding.EncodeToString(h[:12]))
+}
+
+// GetGlobalUsers returns the names of all global users.
+func GetGlobalUsers() ([]string, string, error) {
+	globalUsers.mu.Lock()
+	defer globalUsers.mu.Unlock()
+
+	err := loadGlobalUsers()
+	if err != nil {
+		return nil, "", err
+	}
+	users := make([]string, 0, len(globalUsers.users))
+	for u := range globalUsers.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users, globalETag(globalUsers.users), nil
+}
+
+func getGlobalUser(username string) (UserDescription, error) {
+	globalUsers.mu.Lock()
+	defer globalUsers.mu.Unlock()
+
+	err := loadGlobalUsers()
+	if err != nil {
+		return UserDescription{}, err
+	}
+	u, ok := globalUsers.users[username]
+	if !ok {
+		return UserDescription{}, os.ErrNotExist
+	}
+	return u, nil
+}
+
+// GetSanitisedGlobalUser returns a global user without its password.
+func GetSanitisedGlobalUser(username string) (UserDescription, string, error) {
+	u, err := getGlobalUser(username)
+	if err != nil {
+		return UserDescription{}, "", err
+	}
+	etag := globalETag(u)
+	u.Password = Password{}
+	return u, etag, nil
+}
+
+// GetGlobalUserPasswordType is like GetUserPasswordType, but for a global
+// user.
+func GetGlobalUserPasswordType(username string) (string, error) {
+	u, err := getGlobalUser(username)
+	if err != nil {
+		return "", err
+	}
+	if u.Password.Type == "" {
+		return "", os.ErrNotExist
+	}
+	return u.Password.Type, nil
+}
+
+func GetGlobalUserTag(username string) (string, error) {
+	_, etag, err := GetSanitisedGlobalUser(username)
+	return etag, err
+}
+
+// UpdateGlobalUser creates or modifies a global user.  In order to create
+// a new user, pass an empty ETag.  The password is left unchanged.
+func UpdateGlobalUser(username, etag string, user *UserDescription) error {
+	if username == "" || !validUsername(username) {
+		return UserError("bad username")
+	}
+	if user.Password.Type != "" || user.Password.Key != nil {
+		return errors.New("user description is not sanitised")
+	}
+
+	globalUsers.mu.Lock()
+	defer globalUsers.mu.Unlock()
+
+	err := loadGlobalUsers()
+	if err != nil {
+		return err
+	}
+
+	old, ok := globalUsers.users[username]
+	oldetag := ""
+	if ok {
+		oldetag = globalETag(old)
+	}
+	if oldetag != etag {
+		return ErrTagMismatch
+	}
+
+	newuser := *user
+	newuser.Password = old.Password
+	users := copyGlobalUsers()
+	users[username] = newuser
+	return writeGlobalUsers(users)
+}
+
+// DeleteGlobalUser deletes a global user.  The references to the user
+// in group definitions are left alone.
+func DeleteGlobalUser(username, etag string) error {
+	globalUsers.mu.Lock()
+	defer globalUsers.mu.Unlock()
+
+	err := loadGlobalUsers()
+	if err != nil {
+		return err
+	}
+
+	old, ok := globalUsers.users[username]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if globalETag(old) != etag {
+		return ErrTagMismatch
+	}
+
+	users := copyGlobalUsers()
+	delete(users, username)
+	return writeGlobalUsers(users)
+}
+
+func SetGlobalUserPassword(username string, pw Password) error {
+	globalUsers.mu.Lock()
+	defer globalUsers.mu.Unlock()
+
+	err := loadGlobalUsers()
+	if err != nil {
+		return err
+	}
+
+	user, ok := globalUsers.users[username]
+	if !ok {
+		return os.ErrNotExist
+	}
+	user.Password = pw
+	users := copyGlobalUsers()
+	users[username] = user
+	return writeGlobalUsers(users)
+}
+
+// MatchGlobalUser returns true if password is the password of the global
+// user username.
+func MatchGlobalUser(username, password string) (bool, error) {
+	u, err := getGlobalUser(username)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return u.Password.Match(password)
+}
+
+// globalUserPermission returns the permissions of a global user in
+// a group.  The boolean is false if the group doesn't reference the user.
+func globalUserPermission(desc *Description, username, password string) (Permissions, bool, error) {
+	ref, found := desc.GlobalUsers[username]
+	if !found {
+		return Permissions{}, false, nil
+	}
+	u, err := getGlobalUser(username)
+	if errors.Is(err, os.ErrNotExist) {
+		return Permissions{}, false, nil
+	} else if err != nil {
+		return Permissions{}, true, err
+	}
+	ok, err := u.Password.Match(password)
+	if err != nil {
+		return Permissions{}, true, err
+	}
+	if !ok {
+		return Permissions{}, true, &NotAuthorisedError{}
+	}
+	if ref.Permissions != nil {
+		return *ref.Permissions, true, nil
+	}
+	return u.Permissions, true, nil
+}
@@ -0,0 +1,169 @@
+package group
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalUsers(t *testing.T) {
+	Directory = t.TempDir()
+	DataDirectory = t.TempDir()
+
+	users, etag, err := GetGlobalUsers()
+	if err != nil || len(users) != 0 {
+		t.Errorf("GetGlobalUsers: %v %v", users, err)
+	}
+
+	present, _ := NewPermissions("present")
+	err = UpdateGlobalUser("alice", "", &UserDescription{
+		Permissions: present,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGlobalUser: %v", err)
+	}
+	err = UpdateGlobalUser("alice", "", &UserDescription{
+		Permissions: present,
+	})
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("UpdateGlobalUser (exists): %v", err)
+	}
+	err = UpdateGlobalUser("../bob", "", &UserDescription{})
+	if err == nil {
+		t.Errorf("UpdateGlobalUser (bad name) succeeded")
+	}
+	err = UpdateGlobalUser("bob", "", &UserDescription{
+		Permissions: present,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGlobalUser: %v", err)
+	}
+
+	users, etag2, err := GetGlobalUsers()
+	if err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Errorf("GetGlobalUsers: %v %v", users, err)
+	}
+	if etag2 == etag {
+		t.Errorf("ETag didn't change")
+	}
+
+	key := "secret"
+	err = SetGlobalUserPassword("alice", Password{Type: "plain", Key: &key})
+	if err != nil {
+		t.Fatalf("SetGlobalUserPassword: %v", err)
+	}
+	err = SetGlobalUserPassword("carol", Password{Type: "plain", Key: &key})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("SetGlobalUserPassword (unknown user): %v", err)
+	}
+	u, etag, err := GetSanitisedGlobalUser("alice")
+	if err != nil || u.Password.Type != "" || u.Password.Key != nil {
+		t.Errorf("GetSanitisedGlobalUser: %v %v", u, err)
+	}
+
+	// updating keeps the password
+	op, _ := NewPermissions("op")
+	err = UpdateGlobalUser("alice", etag, &UserDescription{
+		Permissions: op,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGlobalUser: %v", err)
+	}
+	ok, err := MatchGlobalUser("alice", "secret")
+	if err != nil || !ok {
+		t.Errorf("MatchGlobalUser: %v %v", ok, err)
+	}
+
+	err = os.WriteFile(filepath.Join(Directory, "test.json"), []byte(`{
+	    "users": {"bob": {"password": "local", "permissions": "observe"}},
+	    "global-users": {
+	        "alice": {},
+	        "bob": {},
+	        "carol": {"permissions": "op"}
+	    }
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(Directory, "other.json"), []byte(`{
+	    "global-users": {"alice": {"permissions": "present"}}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := Add("test", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer Delete("test")
+	other, err := Add("other", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer Delete("other")
+
+	tests := []struct {
+		g                  *Group
+		username, password string
+		perms              []string
+	}{
+		{g, "alice", "secret",
+			[]string{"op", "present", "message", "caption", "token"}},
+		{g, "alice", "bad", nil},
+		{other, "alice", "secret", []string{"present", "message"}},
+		// local users take precedence
+		{g, "bob", "local", []string{}},
+		// unknown global users
+		{g, "carol", "secret", nil},
+	}
+	for _, test := range tests {
+		username := test.username
+		_, perms, err := test.g.GetPermission(ClientCredentials{
+			Username: &username,
+			Password: test.password,
+		})
+		if test.perms == nil {
+			if err == nil {
+				t.Errorf("%v %v: got %v", test.g.Name(),
+					test.username, perms)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %v: %v", test.g.Name(), test.username, err)
+			continue
+		}
+		if !reflect.DeepEqual(perms, test.perms) {
+			t.Errorf("%v %v: got %v, expected %v", test.g.Name(),
+				test.username, perms, test.perms)
+		}
+	}
+
+	if !g.UserExists("alice") || other.UserExists("bob") {
+		t.Errorf("UserExists")
+	}
+
+	_, etag, err = GetSanitisedGlobalUser("alice")
+	if err != nil {
+		t.Fatalf("GetSanitisedGlobalUser: %v", err)
+	}
+	err = DeleteGlobalUser("alice", "\"bad\"")
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("DeleteGlobalUser (bad etag): %v", err)
+	}
+	err = DeleteGlobalUser("alice", etag)
+	if err != nil {
+		t.Errorf("DeleteGlobalUser: %v", err)
+	}
+	username := "alice"
+	_, _, err = g.GetPermission(ClientCredentials{
+		Username: &username,
+		Password: "secret",
+	})
+	if err == nil {
+		t.Errorf("GetPermission succeeded after delete")
+	}
+}
@@ -4,27 +4,34 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"maps"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/pion/ice/v4"
 	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 
+	"github.com/jech/galene/codecs"
+	"github.com/jech/galene/fips"
+	"github.com/jech/galene/ldap"
 	"github.com/jech/galene/token"
 )
 
 var Directory, DataDirectory string
 var UseMDNS bool
+
+// In LAN mode, we resolve the mDNS names of the host candidates of
+// clients even when UseMDNS is false.
+var LANMode bool
 var UDPMin, UDPMax uint16
 var udpMux ice.UDPMux
 
@@ -96,10 +103,19 @@ type Group struct {
 	mu          sync.Mutex
 	description *Description
 	locked      *string
+	draining    bool
+	rehearsal   bool
+	floor       floorState
+	dropbox     *dropBox
 	clients     map[string]Client
 	history     []ChatHistoryEntry
-	timestamp   time.Time
-	data        map[string]interface{}
+	// whether the on-disk history has been read, and its size in lines
+	historyLoaded bool
+	historyLines  int
+	// recent captions, see captions.go
+	captions  []Caption
+	timestamp time.Time
+	data      map[string]interface{}
 }
 
 func (g *Group) Name() string {
@@ -131,6 +147,69 @@ func (g *Group) SetLocked(locked bool, message string) {
 	}
 }
 
+// Draining returns true if the group is being drained.
+func (g *Group) Draining() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.draining
+}
+
+// SetDraining sets or clears drain mode.  While a group is being drained,
+// all new joins are refused, including those of operators, but the
+// clients already in the group may stay.  A draining group is not
+// expired, so that it remains in drain mode after it becomes empty.
+func (g *Group) SetDraining(draining bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.draining = draining
+}
+
+// Rehearsal returns true if the group is in rehearsal mode.
+func (g *Group) Rehearsal() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rehearsal
+}
+
+// SetRehearsal sets or clears rehearsal mode.  In rehearsal mode,
+// presenters and operators may publish and see each other's streams, while
+// other clients are held back and receive no media.
+func (g *Group) SetRehearsal(rehearsal bool) {
+	g.mu.Lock()
+	if g.rehearsal == rehearsal {
+		g.mu.Unlock()
+		return
+	}
+	g.rehearsal = rehearsal
+	clients := g.getClientsUnlocked(nil)
+	g.mu.Unlock()
+
+	for _, c := range clients {
+		c.Joined(g.Name(), "change")
+	}
+}
+
+// HeldBack returns true if a client with the given permissions should
+// not receive any media.
+func (g *Group) HeldBack(perms []string) bool {
+	if member("present", perms) || member("op", perms) {
+		return false
+	}
+	return g.Rehearsal()
+}
+
+// CanScreenshare returns true if a client with the given permissions may
+// publish a stream labelled "screenshare" or more than one video track.
+func (g *Group) CanScreenshare(perms []string) bool {
+	if !member("present", perms) {
+		return false
+	}
+	if member("op", perms) || member("screenshare", perms) {
+		return true
+	}
+	return !g.Description().RestrictScreenshare
+}
+
 func (g *Group) Data() map[string]interface{} {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -173,7 +252,7 @@ func (g *Group) mayExpire() bool {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if g.description.Public {
+	if g.description.Public || g.draining {
 		return false
 	}
 	if len(g.clients) > 0 {
@@ -192,7 +271,49 @@ func (g *Group) API() (*webrtc.API, error) {
 	codecs := g.description.Codecs
 	g.mu.Unlock()
 
-	return APIFromNames(codecs)
+	return apiFromCodecs(codecsFromNames(codecs), nil, g.ICEPolicy())
+}
+
+// Codecs returns the codecs allowed in the group, in order of preference.
+func (g *Group) Codecs() []webrtc.RTPCodecParameters {
+	g.mu.Lock()
+	names := g.description.Codecs
+	g.mu.Unlock()
+
+	return codecsFromNames(names)
+}
+
+// DownAPI is like API, but is used for down connections.  If the group
+// requests audio redundancy and Opus is enabled, then it additionally
+// registers RedCodec, and the second return value is true.  If the group
+// requests forward error correction, then it registers FlexFECCodec.  If
+// bwe is not nil, it is called with the TWCC bandwidth estimator of every
+// peer connection created by the API.
+func (g *Group) DownAPI(bwe func(cc.BandwidthEstimator)) (*webrtc.API, bool, error) {
+	g.mu.Lock()
+	names := g.description.Codecs
+	redundancy := g.description.AudioRedundancy
+	fec := g.description.FlexFEC
+	g.mu.Unlock()
+
+	codecs := codecsFromNames(names)
+	red := false
+	if redundancy {
+		for _, c := range codecs {
+			if strings.EqualFold(c.MimeType, webrtc.MimeTypeOpus) {
+				red = true
+				break
+			}
+		}
+	}
+	if red {
+		codecs = append(codecs, RedCodec)
+	}
+	if fec {
+		codecs = append(codecs, FlexFECCodec)
+	}
+	api, err := apiFromCodecs(codecs, bwe, g.ICEPolicy())
+	return api, red, err
 }
 
 func fmtpValue(fmtp, key string) string {
@@ -243,6 +364,8 @@ func CodecPayloadType(codec webrtc.RTPCodecCapability) (webrtc.PayloadType, erro
 		}
 	case "audio/opus":
 		return 111, nil
+	case "audio/red":
+		return 63, nil
 	case "audio/g722":
 		return 9, nil
 	case "audio/pcmu":
@@ -266,6 +389,48 @@ var VideoRTCPFeedback = []webrtc.RTCPFeedback{
 // AudioRTCPFeedback is like VideoRTCPFeedback but for audio tracks.
 var AudioRTCPFeedback = []webrtc.RTCPFeedback(nil)
 
+// RedCodec is the codec used for audio redundancy (RFC 2198) on down
+// connections.  It always encapsulates Opus.
+var RedCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		"audio/red", 48000, 2,
+		"111/111",
+		AudioRTCPFeedback,
+	},
+	PayloadType: 63,
+}
+
+// FlexFECCodec is the codec used for forward error correction on down
+// connections.
+var FlexFECCodec = webrtc.RTPCodecParameters{
+	RTPCodecCapability: webrtc.RTPCodecCapability{
+		webrtc.MimeTypeFlexFEC03, 90000, 0,
+		"repair-window=10000000",
+		nil,
+	},
+	PayloadType: 118,
+}
+
+// RTXCodec returns the codec used for retransmissions (RFC 4588) of the
+// given codec.  Its payload type immediately follows the codec's.  The
+// boolean is false if the codec is not a video codec.
+func RTXCodec(codec webrtc.RTPCodecParameters) (webrtc.RTPCodecParameters, bool) {
+	mime := strings.ToLower(codec.MimeType)
+	if !strings.HasPrefix(mime, "video/") ||
+		mime == webrtc.MimeTypeRTX ||
+		strings.HasPrefix(mime, webrtc.MimeTypeFlexFEC) {
+		return webrtc.RTPCodecParameters{}, false
+	}
+	return webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			webrtc.MimeTypeRTX, 90000, 0,
+			fmt.Sprintf("apt=%v", codec.PayloadType),
+			nil,
+		},
+		PayloadType: codec.PayloadType + 1,
+	}, true
+}
+
 func codecsFromName(name string) ([]webrtc.RTPCodecParameters, error) {
 	var codecs []webrtc.RTPCodecCapability
 
@@ -359,23 +524,36 @@ func codecsFromName(name string) ([]webrtc.RTPCodecParameters, error) {
 	return parms, nil
 }
 
+// SetUDPMux causes all peer connections to share a single UDP port.  The
+// global ICE policy is applied to the addresses on which the port is
+// opened.
 func SetUDPMux(port int) error {
 	var err error
-	udpMux, err = ice.NewMultiUDPMuxFromPort(port)
+	udpMux, err = ice.NewMultiUDPMuxFromPort(
+		port, globalICEPolicy().udpMuxOptions()...,
+	)
 	return err
 }
 
 func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
+	return apiFromCodecs(codecs, nil, globalICEPolicy())
+}
+
+// apiFromCodecs is like APIFromCodecs.  If bwe is not nil, then TWCC is
+// negotiated, and bwe is called with the bandwidth estimator of every
+// new peer connection.  The ICE policy may be nil.
+func apiFromCodecs(cs []webrtc.RTPCodecParameters, bwe func(cc.BandwidthEstimator), policy *ICEPolicy) (*webrtc.API, error) {
 	s := webrtc.SettingEngine{}
 	s.SetSRTPReplayProtectionWindow(512)
 	s.DisableActiveTCP(true)
-	if !UseMDNS {
-		s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
-	}
+	s.SetICEBindingRequestHandler(roamingHandler)
+	fips.ConfigureDTLS(&s)
+	policy.configure(&s)
 
 	m := webrtc.MediaEngine{}
 
-	for _, codec := range codecs {
+	av1 := false
+	for _, codec := range cs {
 		tpe := webrtc.RTPCodecTypeVideo
 		if strings.HasPrefix(strings.ToLower(codec.MimeType), "audio/") {
 			tpe = webrtc.RTPCodecTypeAudio
@@ -385,6 +563,16 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 			log.Printf("%v", err)
 			continue
 		}
+		if strings.EqualFold(codec.MimeType, "video/av1") {
+			av1 = true
+		}
+		rtx, ok := RTXCodec(codec)
+		if ok {
+			err := m.RegisterCodec(rtx, tpe)
+			if err != nil {
+				log.Printf("%v", err)
+			}
+		}
 	}
 
 	if udpMux != nil {
@@ -398,8 +586,37 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 		return nil, err
 	}
 
+	// used for AV1 layer switching
+	if av1 {
+		err = m.RegisterHeaderExtension(
+			webrtc.RTPHeaderExtensionCapability{
+				URI: codecs.DependencyDescriptorURI,
+			},
+			webrtc.RTPCodecTypeVideo,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// used for speech-gated video
+	err = m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI},
+		webrtc.RTPCodecTypeAudio,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	ir := interceptor.Registry{}
 
+	if bwe != nil {
+		err := configureBWE(&m, &ir, bwe)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return webrtc.NewAPI(
 		webrtc.WithSettingEngine(s),
 		webrtc.WithMediaEngine(&m),
@@ -407,9 +624,12 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 	), nil
 }
 
-func APIFromNames(names []string) (*webrtc.API, error) {
+// defaultCodecs are the codecs used when a group doesn't specify any.
+var defaultCodecs = []string{"vp8", "opus"}
+
+func codecsFromNames(names []string) []webrtc.RTPCodecParameters {
 	if len(names) == 0 {
-		names = []string{"vp8", "opus"}
+		names = defaultCodecs
 	}
 	var codecs []webrtc.RTPCodecParameters
 	for _, n := range names {
@@ -420,8 +640,11 @@ func APIFromNames(names []string) (*webrtc.API, error) {
 		}
 		codecs = append(codecs, cs...)
 	}
+	return codecs
+}
 
-	return APIFromCodecs(codecs)
+func APIFromNames(names []string) (*webrtc.API, error) {
+	return APIFromCodecs(codecsFromNames(names))
 }
 
 func Add(name string, desc *Description) (*Group, error) {
@@ -443,11 +666,17 @@ func validGroupName(name string) bool {
 	}
 
 	s := path.Clean("/" + name)
-	if s == "/" {
+	if s == "/" || s != "/"+name {
 		return false
 	}
 
-	return s == "/"+name
+	// hidden directories, such as the archive, are not groups
+	for _, c := range strings.Split(name, "/") {
+		if strings.HasPrefix(c, ".") {
+			return false
+		}
+	}
+	return true
 }
 
 func add(name string, desc *Description) (*Group, []Client, error) {
@@ -467,7 +696,7 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 	g := groups.groups[name]
 	if g == nil {
 		if desc == nil {
-			desc, err = readDescription(name, true)
+			desc, err = cachedDescription(name)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -505,6 +734,7 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 	}
 
 	autoLockKick(g)
+	indexPublic(g)
 
 	var clients []Client
 	if notify {
@@ -588,6 +818,9 @@ func deleteUnlocked(g *Group) bool {
 	}
 
 	delete(groups.groups, g.name)
+	unindexPublic(g)
+	// don't hold the locks while accessing the filesystem
+	go removeDropBox(g.clearFiles())
 	return true
 }
 
@@ -600,72 +833,132 @@ func member(v string, l []string) bool {
 	return false
 }
 
+// limiter is implemented by clients that enforce the limits carried by
+// tokens.
+type limiter interface {
+	SetLimits(limits *token.Limits)
+}
+
+// checkJoin returns an error if a client with the given permissions is
+// not allowed to join the group.
+//
+// called locked
+func (g *Group) checkJoin(clients []Client, perms []string) error {
+	err := checkMaintenance(time.Now())
+	if err != nil {
+		return err
+	}
+
+	if g.draining {
+		return UserError("this group is closing")
+	}
+
+	if !member("op", perms) {
+		if g.locked != nil {
+			m := *g.locked
+			if m == "" {
+				m = "this group is locked"
+			}
+			return UserError(m)
+		}
+		if g.description.NotBefore != nil ||
+			g.description.Expires != nil {
+			now := time.Now()
+			if g.description.NotBefore != nil &&
+				g.description.NotBefore.After(now) {
+				return UserError("this group is not open yet")
+			}
+			if g.description.Expires != nil &&
+				g.description.Expires.Before(now) {
+				return UserError("this group is closed")
+			}
+		}
+		if !scheduleOpen(g.description, time.Now()) {
+			return UserError("this group is closed at this time")
+		}
+		if g.description.Autokick {
+			ops := false
+			for _, c := range clients {
+				if member("op", c.Permissions()) {
+					ops = true
+					break
+				}
+			}
+			if !ops {
+				return UserError(
+					"there are no operators in this group",
+				)
+			}
+		}
+	}
+
+	if !member("op", perms) && g.description.MaxClients > 0 {
+		if len(g.clients) >= g.description.MaxClients {
+			return UserError("too many users")
+		}
+	}
+	return nil
+}
+
+// CheckJoin returns the username and permissions that a client would be
+// given if it joined the group with the given credentials, or the error
+// that would cause the join to fail.  It doesn't join the group.
+func CheckJoin(group string, creds ClientCredentials) (string, []string, error) {
+	g, err := Add(group, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ldap := g.ldapAuthenticate(creds)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	username, perms, _, err := g.getPermission(creds, ldap)
+	if err != nil {
+		return "", nil, err
+	}
+	err = g.checkJoin(g.getClientsUnlocked(nil), perms)
+	if err != nil {
+		return "", nil, err
+	}
+	return username, perms, nil
+}
+
 func AddClient(group string, c Client, creds ClientCredentials) (*Group, error) {
 	g, err := Add(group, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	var ldap *ldapResult
+	if !member("system", c.Permissions()) {
+		ldap = g.ldapAuthenticate(creds)
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
 	clients := g.getClientsUnlocked(nil)
 
 	if !member("system", c.Permissions()) {
-		username, perms, err := g.getPermission(creds)
+		username, perms, limits, err := g.getPermission(creds, ldap)
 		if err != nil {
 			return nil, err
 		}
 
 		c.SetUsername(username)
 		c.SetPermissions(perms)
-
-		if !member("op", perms) {
-			if g.locked != nil {
-				m := *g.locked
-				if m == "" {
-					m = "this group is locked"
-				}
-				return nil, UserError(m)
-			}
-			if g.description.NotBefore != nil ||
-				g.description.Expires != nil {
-				now := time.Now()
-				if g.description.NotBefore != nil &&
-					g.description.NotBefore.After(now) {
-					return nil, UserError(
-						"this group is not open yet",
-					)
-				}
-				if g.description.Expires != nil &&
-					g.description.Expires.Before(now) {
-					return nil, UserError(
-						"this group is closed",
-					)
-				}
-			}
-			if g.description.Autokick {
-				ops := false
-				for _, c := range clients {
-					if member("op", c.Permissions()) {
-						ops = true
-						break
-					}
-				}
-				if !ops {
-					return nil, UserError(
-						"there are no operators " +
-							"in this group",
-					)
-				}
-			}
+		if l, ok := c.(limiter); ok {
+			l.SetLimits(limits)
 		}
 
-		if !member("op", perms) && g.description.MaxClients > 0 {
-			if len(g.clients) >= g.description.MaxClients {
-				return nil, UserError("too many users")
-			}
+		err = g.checkJoin(clients, perms)
+		if err != nil {
+			return nil, err
 		}
+	} else if g.draining {
+		// don't restart RTSP sources or cascaded streams
+		return nil, UserError("this group is closing")
 	}
 	id := c.Id()
 	if id == "" {
@@ -675,7 +968,47 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 		return nil, ProtocolError("duplicate client id")
 	}
 	g.clients[id] = c
+	if creds.Token != "" && !member("system", c.Permissions()) {
+		err := token.NoteUse(creds.Token)
+		if err != nil {
+			log.Printf("Record use of token: %v", err)
+		}
+	}
 	g.timestamp = time.Now()
+	if !member("system", c.Permissions()) {
+		noteActivity(activityName(g), g.timestamp)
+		first := true
+		for _, cc := range clients {
+			if !member("system", cc.Permissions()) {
+				first = false
+				break
+			}
+		}
+		if first {
+			runHook("first-join", g.name, c.Username())
+		}
+		notifyWebhooks(g.name, g.description, WebhookEvent{
+			Kind:     "join",
+			Time:     g.timestamp,
+			Id:       id,
+			Username: c.Username(),
+		})
+		if creds.Token != "" {
+			// only stateful tokens have a meaningful identity; the
+			// token itself is a secret, and is not sent
+			_, _, err := token.Get(creds.Token)
+			if err == nil {
+				notifyWebhooks(g.name, g.description,
+					WebhookEvent{
+						Kind:     "token",
+						Time:     g.timestamp,
+						Id:       id,
+						Username: c.Username(),
+					},
+				)
+			}
+		}
+	}
 
 	c.Joined(g.Name(), "join")
 
@@ -743,14 +1076,41 @@ func DelClient(c Client) {
 	delete(g.clients, c.Id())
 	g.timestamp = time.Now()
 	clients := g.getClientsUnlocked(nil)
+	var dropbox string
+	if !member("system", c.Permissions()) {
+		notifyWebhooks(g.name, g.description, WebhookEvent{
+			Kind:     "leave",
+			Time:     g.timestamp,
+			Id:       c.Id(),
+			Username: c.Username(),
+		})
+		empty := true
+		for _, cc := range clients {
+			if !member("system", cc.Permissions()) {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			notifyWebhooks(g.name, g.description, WebhookEvent{
+				Kind: "empty",
+				Time: g.timestamp,
+			})
+			runHook("last-leave", g.name, c.Username())
+			dropbox = g.clearFiles()
+		}
+	}
 	g.mu.Unlock()
 
+	removeDropBox(dropbox)
+
 	c.Joined(g.Name(), "leave")
 	for _, cc := range clients {
 		cc.PushClient(
 			g.Name(), "delete", c.Id(), c.Username(), nil, nil,
 		)
 	}
+	g.ReleaseFloor(c.Id())
 	autoLockKick(g)
 }
 
@@ -858,28 +1218,33 @@ func deleteFunc[S ~[]E, E any](s S, f func(E) bool) S {
 }
 
 func (g *Group) ClearChatHistory(id string, userId string) {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	if id == "" && userId == "" {
 		g.history = nil
-		return
+	} else {
+		g.history = deleteFunc(g.history, func(e ChatHistoryEntry) bool {
+			return e.Source == userId && (id == "" || e.Id == id)
+		})
 	}
-	g.history = deleteFunc(g.history, func(e ChatHistoryEntry) bool {
-		return e.Source == userId && (id == "" || e.Id == id)
-	})
+	g.rewriteHistoryUnlocked()
 }
 
 func (g *Group) AddToChatHistory(id, source string, user *string, time time.Time, kind string, value interface{}) {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	if len(g.history) >= maxChatHistory {
+	max := maxHistorySize(g.description)
+	for len(g.history) >= max {
 		copy(g.history, g.history[1:])
 		g.history = g.history[:len(g.history)-1]
 	}
 	g.history = append(g.history,
 		ChatHistoryEntry{Id: id, Source: source, User: user, Time: time, Kind: kind, Value: value},
 	)
+	g.saveHistoryUnlocked()
 }
 
 func discardObsoleteHistory(h []ChatHistoryEntry, duration time.Duration) []ChatHistoryEntry {
@@ -898,6 +1263,7 @@ func discardObsoleteHistory(h []ChatHistoryEntry, duration time.Duration) []Chat
 }
 
 func (g *Group) GetChatHistory() []ChatHistoryEntry {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
@@ -910,6 +1276,52 @@ func (g *Group) GetChatHistory() []ChatHistoryEntry {
 	return h
 }
 
+// A ServerUser is a server-wide user defined in the configuration file.
+// Besides the permissions "admin", "stats" and "announce", it may be
+// granted a list of API scopes, which allow it to perform a restricted
+// set of administrative tasks.
+type ServerUser struct {
+	UserDescription
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func (u *ServerUser) UnmarshalJSON(b []byte) error {
+	var uu struct {
+		Password    Password        `json:"password"`
+		Permissions json.RawMessage `json:"permissions"`
+		Scopes      []string        `json:"scopes"`
+	}
+	err := json.Unmarshal(b, &uu)
+	if err != nil {
+		return err
+	}
+	*u = ServerUser{
+		UserDescription: UserDescription{Password: uu.Password},
+		Scopes:          uu.Scopes,
+	}
+	if uu.Permissions == nil {
+		return nil
+	}
+	var name string
+	err = json.Unmarshal(uu.Permissions, &name)
+	if err == nil {
+		_, ok := serverPermissionsMap[name]
+		if ok {
+			u.Permissions = Permissions{name: name}
+			return nil
+		}
+	}
+	return u.Permissions.UnmarshalJSON(uu.Permissions)
+}
+
+func (u ServerUser) MarshalJSON() ([]byte, error) {
+	uu := u.UserDescription.fields()
+	if len(u.Scopes) > 0 {
+		uu["scopes"] = u.Scopes
+	}
+	return json.Marshal(uu)
+}
+
 // Configuration represents the contents of the data/config.json file.
 type Configuration struct {
 	// The modtime and size of the file.  These are used to detect
@@ -917,12 +1329,53 @@ type Configuration struct {
 	modTime  time.Time `json:"-"`
 	fileSize int64     `json:"-"`
 
-	CanonicalHost    string                     `json:"canonicalHost,omitempty"`
-	AllowOrigin      []string                   `json:"allowOrigin,omitempty"`
-	AllowAdminOrigin []string                   `json:"allowAdminOrigin,omitempty"`
-	ProxyURL         string                     `json:"proxyURL,omitempty"`
-	WritableGroups   bool                       `json:"writableGroups,omitempty"`
-	Users            map[string]UserDescription `json:"users,omitempty"`
+	CanonicalHost    string                `json:"canonicalHost,omitempty"`
+	AllowOrigin      []string              `json:"allowOrigin,omitempty"`
+	AllowAdminOrigin []string              `json:"allowAdminOrigin,omitempty"`
+	ProxyURL         string                `json:"proxyURL,omitempty"`
+	WritableGroups   bool                  `json:"writableGroups,omitempty"`
+	Users            map[string]ServerUser `json:"users,omitempty"`
+
+	// The number of days without joins after which a group is
+	// archived, never if 0.
+	ArchiveAfter int `json:"archiveAfter,omitempty"`
+
+	// The tolerance, in seconds, for clock skew when checking the
+	// validity period of tokens.
+	ClockTolerance int `json:"clockTolerance,omitempty"`
+
+	// The number of days during which expired tokens are kept
+	// before being removed, 7 if 0.
+	TokenRetention int `json:"tokenRetention,omitempty"`
+
+	// Announcement channels, mapping a channel name to a list of
+	// patterns matching group names.
+	Announcements map[string][]string `json:"announcements,omitempty"`
+
+	// Webhooks that receive the events of all groups.
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+
+	// The standby server to which state is replicated.
+	Standby *StandbyConfig `json:"standby,omitempty"`
+
+	// External commands run on group events, indexed by event.
+	Hooks map[string][]string `json:"hooks,omitempty"`
+
+	// The maximum number of hooks that run concurrently.
+	MaxHooks int `json:"maxHooks,omitempty"`
+
+	// Where group definitions are stored, the groups directory if nil.
+	Storage *StoreConfig `json:"storage,omitempty"`
+
+	// The directory used to authenticate the users of groups that
+	// define ldap-users.
+	LDAP *ldap.Config `json:"ldap,omitempty"`
+
+	// Restrictions on the host candidates advertised by the server.
+	ICEPolicy *ICEPolicy `json:"icePolicy,omitempty"`
+
+	// The frequency of RTCP reports.
+	RTCP *RTCPPolicy `json:"rtcp,omitempty"`
 
 	// obsolete fields
 	Admin []ClientPattern `json:"admin,omitempty"`
@@ -952,6 +1405,9 @@ func GetConfiguration() (*Configuration, error) {
 		if errors.Is(err, os.ErrNotExist) {
 			if !configuration.configuration.Zero() {
 				configuration.configuration = &Configuration{}
+				token.SetClockTolerance(0)
+				token.SetRetention(0)
+				setGlobalRTCPPolicy(nil)
 			}
 			return configuration.configuration, nil
 		}
@@ -980,12 +1436,33 @@ func GetConfiguration() (*Configuration, error) {
 		log.Printf("%v: field \"admin\" is obsolete, ignored", filename)
 		conf.Admin = nil
 	}
+	for name, u := range conf.Users {
+		err = CheckScopes(u.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("user %v: %w", name, err)
+		}
+	}
+	err = conf.ICEPolicy.Check()
+	if err != nil {
+		return nil, err
+	}
+	err = conf.RTCP.Check()
+	if err != nil {
+		return nil, err
+	}
 	configuration.configuration = &conf
+	token.SetClockTolerance(
+		time.Duration(conf.ClockTolerance) * time.Second,
+	)
+	token.SetRetention(
+		time.Duration(conf.TokenRetention) * 24 * time.Hour,
+	)
+	setGlobalRTCPPolicy(conf.RTCP)
 	return configuration.configuration, nil
 }
 
 // called locked
-func (g *Group) getPasswordPermission(creds ClientCredentials) (Permissions, error) {
+func (g *Group) getPasswordPermission(creds ClientCredentials, ldap *ldapResult) (Permissions, error) {
 	desc := g.description
 
 	if creds.Username == nil {
@@ -1005,6 +1482,26 @@ func (g *Group) getPasswordPermission(creds ClientCredentials) (Permissions, err
 		}
 	}
 
+	p, found, err := globalUserPermission(
+		desc, *creds.Username, creds.Password,
+	)
+	if found {
+		return p, err
+	} else if err != nil {
+		return Permissions{}, err
+	}
+
+	if len(desc.LDAPUsers) > 0 {
+		p, err := ldapPermission(desc, ldap)
+		if err == nil {
+			return p, nil
+		}
+		var autherr *NotAuthorisedError
+		if !errors.As(err, &autherr) {
+			return Permissions{}, err
+		}
+	}
+
 	if desc.WildcardUser != nil {
 		ok, _ := desc.WildcardUser.Password.Match(creds.Password)
 		if ok {
@@ -1025,10 +1522,10 @@ func (g *Group) UserExists(username string) bool {
 // called locked
 func (g *Group) userExists(username string) bool {
 	desc := g.description
-	if desc.Users == nil {
-		return false
+	if _, found := desc.Users[username]; found {
+		return true
 	}
-	_, found := desc.Users[username]
+	_, found := desc.GlobalUsers[username]
 	return found
 }
 
@@ -1041,57 +1538,106 @@ func validUsername(username string) bool {
 	return username == "" || validGroupName(username)
 }
 
+// getPermission returns the username and permissions granted by creds.
+// Ldap is the result of ldapAuthenticate, which must be called before
+// locking the group.
 // called locked
-func (g *Group) getPermission(creds ClientCredentials) (string, []string, error) {
+func (g *Group) getPermission(creds ClientCredentials, ldap *ldapResult) (string, []string, *token.Limits, error) {
 	desc := g.description
 	var username string
 	var perms []string
+	var limits *token.Limits
 	if creds.Token != "" {
-		tok, err := token.Parse(creds.Token, desc.AuthKeys)
+		// this sets the clock tolerance used by token.Parse
+		conf, err := GetConfiguration()
 		if err != nil {
-			return "", nil, &NotAuthorisedError{err: err}
+			return "", nil, nil, err
 		}
 
-		conf, err := GetConfiguration()
+		tok, err := token.Parse(creds.Token, desc.AuthKeys)
 		if err != nil {
-			return "", nil, err
+			return "", nil, nil, &NotAuthorisedError{err: err}
 		}
 
 		username, perms, err =
 			tok.Check(conf.CanonicalHost, g.name, creds.Username)
 		if err != nil {
-			return "", nil, &NotAuthorisedError{err: err}
+			return "", nil, nil, &NotAuthorisedError{err: err}
 		}
+		limits = tok.GetLimits()
 		if username == "" && creds.Username != nil {
 			if g.userExists(*creds.Username) {
-				return "", nil, ErrDuplicateUsername
+				return "", nil, nil, ErrDuplicateUsername
+			}
+			username = *creds.Username
+		}
+	} else if creds.PIN != "" {
+		if !checkPIN(desc, creds.PIN) {
+			return "", nil, nil, &NotAuthorisedError{
+				errors.New("bad PIN"),
+			}
+		}
+		if creds.Username != nil {
+			// chosen by the caller, don't allow impersonation
+			if g.userExists(*creds.Username) {
+				return "", nil, nil, ErrDuplicateUsername
 			}
 			username = *creds.Username
 		}
+		perms = []string{"present"}
 	} else if creds.Username != nil {
 		username = *creds.Username
-		ps, err := g.getPasswordPermission(creds)
-		if err != nil {
-			return "", nil, err
+		ok := false
+		if creds.Password == "" {
+			perms, ok = g.getSessionPermission(
+				creds.Sessions, username,
+			)
+		}
+		if !ok {
+			ps, err := g.getPasswordPermission(creds, ldap)
+			if err != nil {
+				return "", nil, nil, err
+			}
+			perms = ps.Permissions(desc)
 		}
-		perms = ps.Permissions(desc)
 	} else {
-		return "", nil, errors.New("neither username nor token provided")
+		return "", nil, nil, errors.New("neither username nor token provided")
 	}
 
 	if !validUsername(username) {
-		return "", nil, &NotAuthorisedError{
+		return "", nil, nil, &NotAuthorisedError{
 			errors.New("invalid username"),
 		}
 	}
 
-	return username, perms, nil
+	return username, perms, limits, nil
+}
+
+var ErrTokenQuota = UserError("too many outstanding invitations")
+
+// AddToken saves a new token created by a user of the group.  It returns
+// ErrTokenQuota if users are not allowed to create any more tokens in
+// this group, and limits the token's expiration time to the group's
+// maximum token lifetime.
+func (g *Group) AddToken(tok *token.Stateful, now time.Time) (*token.Stateful, error) {
+	desc := g.Description()
+	max := now.Add(maxTokenLifetime(desc))
+	if tok.Expires == nil || tok.Expires.After(max) {
+		tok.Expires = &max
+	}
+	t, err := token.Add(tok, desc.MaxTokens, now)
+	if errors.Is(err, token.ErrQuota) {
+		return nil, ErrTokenQuota
+	}
+	return t, err
 }
 
 func (g *Group) GetPermission(creds ClientCredentials) (string, []string, error) {
+	ldap := g.ldapAuthenticate(creds)
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	return g.getPermission(creds)
+	username, perms, _, err := g.getPermission(creds, ldap)
+	return username, perms, err
 }
 
 type Status struct {
@@ -1104,8 +1650,16 @@ type Status struct {
 	AuthServer        string `json:"authServer,omitempty"`
 	AuthPortal        string `json:"authPortal,omitempty"`
 	Locked            bool   `json:"locked,omitempty"`
+	Rehearsal         bool   `json:"rehearsal,omitempty"`
+	Floor             string `json:"floor,omitempty"`
+	Files             []File `json:"files,omitempty"`
 	ClientCount       *int   `json:"clientCount,omitempty"`
 	CanChangePassword bool   `json:"canChangePassword,omitempty"`
+
+	// the start of the scheduled maintenance window
+	Maintenance *time.Time `json:"maintenance,omitempty"`
+	// whether joins are refused due to maintenance
+	MaintenanceLockout bool `json:"maintenanceLockout,omitempty"`
 }
 
 // Status returns a group's status.
@@ -1154,15 +1708,26 @@ func (g *Group) Status(authentified bool, base *url.URL) Status {
 		AuthPortal:  desc.AuthPortal,
 		Description: desc.Description,
 	}
+	if d.AuthPortal == "" && desc.OIDC != nil && location != "" {
+		d.AuthPortal = location + ".oidc/login"
+	}
+
+	if m := GetMaintenance(); m != nil {
+		d.Maintenance = &m.Start
+		d.MaintenanceLockout = m.active(time.Now())
+	}
 
 	if authentified || desc.Public {
 		// these are considered private information
 		locked, _ := g.Locked()
 		count := g.ClientCount()
 		d.Locked = locked
+		d.Rehearsal = g.Rehearsal()
 		d.ClientCount = &count
 	}
 	if authentified {
+		d.Floor = g.Floor()
+		d.Files = g.Files()
 		conf, err := GetConfiguration()
 		if err == nil {
 			d.CanChangePassword = conf.WritableGroups
@@ -1171,25 +1736,11 @@ func (g *Group) Status(authentified bool, base *url.URL) Status {
 	return d
 }
 
-func GetPublic(base *url.URL) []Status {
-	gs := make([]Status, 0)
-	Range(func(g *Group) bool {
-		if g.Description().Public {
-			gs = append(gs, g.Status(false, base))
-		}
-		return true
-	})
-	sort.Slice(gs, func(i, j int) bool {
-		return gs[i].Name < gs[j].Name
-	})
-	return gs
-}
-
 // Update checks that all in-memory groups are up-to-date and updates the
 // list of public groups.  It also removes from memory any non-public
 // groups that haven't been accessed in maxHistoryAge.
 func Update() {
-	_, err := GetConfiguration()
+	conf, err := GetConfiguration()
 	if err != nil {
 		log.Printf("%v: %v",
 			filepath.Join(DataDirectory, "config.json"),
@@ -1197,6 +1748,12 @@ func Update() {
 		)
 	}
 
+	if conf != nil && conf.ArchiveAfter > 0 {
+		ArchiveInactive(
+			time.Duration(conf.ArchiveAfter) * 24 * time.Hour,
+		)
+	}
+
 	names := GetNames()
 	for _, name := range names {
 		g := Get(name)
@@ -1216,55 +1773,20 @@ func Update() {
 		}
 	}
 
-	err = filepath.WalkDir(
-		Directory,
-		func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
-				return nil
-			}
-			if d.IsDir() {
-				base := filepath.Base(path)
-				if base[0] == '.' {
-					log.Printf(
-						"Ignoring group directory %v",
-						path,
-					)
-					return fs.SkipDir
-				}
-				return nil
-			}
-			filename, err := filepath.Rel(Directory, path)
-			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
-				return nil
-			}
-			if !strings.HasSuffix(filename, ".json") {
-				log.Printf(
-					"Unexpected extension for group file %v",
-					path,
-				)
-				return nil
-			}
-			base := filepath.Base(filename)
-			if base[0] == '.' {
-				log.Printf("Ignoring group file %v", filename)
-				return nil
-			}
-			name := strings.TrimSuffix(filename, ".json")
-			desc, err := GetDescription(name)
-			if err != nil {
-				log.Printf("Group file %v: %v", path, err)
-				return nil
-			}
-			if desc.Public {
-				Add(name, desc)
-			}
-			return nil
-		},
-	)
+	// even if we fail, there's no point in waiting any longer
+	defer ready.Store(true)
 
+	names, err = GetDescriptionNames()
 	if err != nil {
 		log.Printf("Couldn't read groups: %v", err)
+		return
+	}
+	missing := make([]string, 0, len(names))
+	for _, name := range names {
+		if Get(name) == nil {
+			// running groups were already updated above
+			missing = append(missing, name)
+		}
 	}
+	addPublic(missing)
 }
@@ -36,6 +36,8 @@ func TestConstantTimeCompare(t *testing.T) {
 
 func TestGroup(t *testing.T) {
 	groups.groups = nil
+	publicGroups.groups = nil
+	publicGroups.sorted = nil
 	Add("group", &Description{})
 	Add("group/subgroup", &Description{Public: true})
 	if len(groups.groups) != 2 {
@@ -73,6 +75,94 @@ func TestGroup(t *testing.T) {
 	}
 }
 
+func TestRehearsal(t *testing.T) {
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+		clients:     make(map[string]Client),
+	}
+	if g.Rehearsal() || g.HeldBack(nil) {
+		t.Errorf("Group started in rehearsal mode")
+	}
+	g.SetRehearsal(true)
+	if !g.Rehearsal() {
+		t.Errorf("Rehearsal: expected true")
+	}
+	if !g.HeldBack(nil) || !g.HeldBack([]string{"message"}) {
+		t.Errorf("Attendee not held back")
+	}
+	if g.HeldBack([]string{"present"}) || g.HeldBack([]string{"op"}) {
+		t.Errorf("Presenter held back")
+	}
+	if !g.Status(true, nil).Rehearsal {
+		t.Errorf("Status doesn't indicate rehearsal mode")
+	}
+	g.SetRehearsal(false)
+	if g.HeldBack(nil) {
+		t.Errorf("Attendee held back after rehearsal")
+	}
+}
+
+func TestDraining(t *testing.T) {
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+		clients:     make(map[string]Client),
+	}
+	if g.Draining() || g.checkJoin(nil, nil) != nil {
+		t.Errorf("Group started in drain mode")
+	}
+	if !g.mayExpire() {
+		t.Errorf("Empty group doesn't expire")
+	}
+
+	g.SetDraining(true)
+	if !g.Draining() {
+		t.Errorf("Draining: expected true")
+	}
+	if g.checkJoin(nil, nil) == nil ||
+		g.checkJoin(nil, []string{"op"}) == nil {
+		t.Errorf("Join allowed in drain mode")
+	}
+	if g.mayExpire() {
+		t.Errorf("Draining group expires")
+	}
+
+	g.SetDraining(false)
+	if g.checkJoin(nil, nil) != nil {
+		t.Errorf("Join refused after drain")
+	}
+}
+
+func TestCanScreenshare(t *testing.T) {
+	g := &Group{
+		name:        "test",
+		description: &Description{},
+		clients:     make(map[string]Client),
+	}
+	presenter := []string{"present", "message"}
+	sharer := []string{"present", "message", "screenshare"}
+	op := []string{"op", "present", "message"}
+
+	if !g.CanScreenshare(presenter) {
+		t.Errorf("Presenter cannot share by default")
+	}
+	if g.CanScreenshare([]string{"message", "screenshare"}) {
+		t.Errorf("Non-presenter can share")
+	}
+
+	g.description = &Description{RestrictScreenshare: true}
+	if g.CanScreenshare(presenter) {
+		t.Errorf("Presenter can share in restricted group")
+	}
+	if !g.CanScreenshare(sharer) || !g.CanScreenshare(op) {
+		t.Errorf("Authorised user cannot share")
+	}
+	if !g.Capabilities(nil).RestrictScreenshare {
+		t.Errorf("Capabilities don't indicate restriction")
+	}
+}
+
 func TestChatHistory(t *testing.T) {
 	g := Group{
 		description: &Description{},
@@ -296,6 +386,12 @@ func TestExtraPermissions(t *testing.T) {
 	})
 	doit("john", []string{"token", "present", "message"})
 	doit("james", []string{})
+
+	d.PrivacyMode = true
+
+	doit("jch", []string{"op", "token", "present", "message", "caption"})
+	doit("john", []string{"token", "present", "message"})
+	doit("james", []string{})
 }
 
 func TestUsernameTaken(t *testing.T) {
@@ -404,3 +500,35 @@ func TestPayloadTypeDistinct(t *testing.T) {
 		m[pt] = n
 	}
 }
+
+func TestRTXPayloadTypeDistinct(t *testing.T) {
+	codecs := append(
+		codecsFromNames([]string{
+			"vp8", "vp9", "av1", "h264",
+			"opus", "g722", "pcmu", "pcma",
+		}),
+		RedCodec, FlexFECCodec,
+	)
+	for _, c := range codecs {
+		rtx, ok := RTXCodec(c)
+		if !ok {
+			continue
+		}
+		if rtx.SDPFmtpLine != fmt.Sprintf("apt=%v", c.PayloadType) {
+			t.Errorf("Bad fmtp %v for %v", rtx.SDPFmtpLine, c.MimeType)
+		}
+		codecs = append(codecs, rtx)
+	}
+
+	m := make(map[webrtc.PayloadType]string)
+	for _, c := range codecs {
+		if other, ok := m[c.PayloadType]; ok {
+			t.Errorf(
+				"Duplicate ptype %v: %v and %v",
+				c.PayloadType, c.MimeType, other,
+			)
+			continue
+		}
+		m[c.PayloadType] = c.MimeType
+	}
+}
@@ -0,0 +1,102 @@
+package group
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Hooks are external commands that are run when something happens to a
+// group.  They are configured in config.json, since they run with the
+// privileges of the server.  The event is described by environment
+// variables, and at most a fixed number of hooks run concurrently; the
+// others are queued.
+
+const (
+	defaultMaxHooks = 4
+	maxPendingHooks = 256
+	hookTimeout     = time.Minute
+)
+
+type hookInvocation struct {
+	argv []string
+	env  []string
+}
+
+var hookState struct {
+	mu      sync.Mutex
+	running int
+	pending []hookInvocation
+}
+
+func (inv hookInvocation) run() {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, inv.argv[0], inv.argv[1:]...)
+	cmd.Env = append(os.Environ(), inv.env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Hook %v: %v", inv.argv[0], err)
+		if len(out) > 0 {
+			log.Printf("Hook %v: %s", inv.argv[0], out)
+		}
+	}
+}
+
+func hookLoop(inv hookInvocation) {
+	for {
+		inv.run()
+		hookState.mu.Lock()
+		if len(hookState.pending) == 0 {
+			hookState.running--
+			hookState.mu.Unlock()
+			return
+		}
+		inv = hookState.pending[0]
+		hookState.pending = hookState.pending[1:]
+		hookState.mu.Unlock()
+	}
+}
+
+// runHook runs the command configured for a given event, if any.  It
+// doesn't wait for the command to complete, and may be called with the
+// group locked.
+func runHook(event, group, username string) {
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config.json: %v", err)
+		return
+	}
+	argv := conf.Hooks[event]
+	if len(argv) == 0 {
+		return
+	}
+	max := conf.MaxHooks
+	if max <= 0 {
+		max = defaultMaxHooks
+	}
+
+	inv := hookInvocation{
+		argv: argv,
+		env: []string{
+			"GALENE_EVENT=" + event,
+			"GALENE_GROUP=" + group,
+			"GALENE_USERNAME=" + username,
+			"GALENE_TIME=" + time.Now().Format(time.RFC3339),
+		},
+	}
+
+	hookState.mu.Lock()
+	defer hookState.mu.Unlock()
+	if hookState.running < max {
+		hookState.running++
+		go hookLoop(inv)
+	} else if len(hookState.pending) < maxPendingHooks {
+		hookState.pending = append(hookState.pending, inv)
+	} else {
+		log.Printf("Hook %v: too many pending hooks, dropped", event)
+	}
+}
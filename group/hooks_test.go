@@ -0,0 +1,60 @@
+package group
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	sh, err := os.Stat("/bin/sh")
+	if err != nil || sh.IsDir() {
+		t.Skip("no shell")
+	}
+
+	Directory = t.TempDir()
+	DataDirectory = t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+
+	script := `echo "$GALENE_EVENT $GALENE_GROUP $GALENE_USERNAME" >> ` + out
+	conf, err := json.Marshal(map[string]any{
+		"writableGroups": true,
+		"maxHooks":       1,
+		"hooks": map[string][]string{
+			"create":     {"/bin/sh", "-c", script},
+			"first-join": {"/bin/sh", "-c", script},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(
+		filepath.Join(DataDirectory, "config.json"), conf, 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateDescription("test", "", &Description{})
+	if err != nil {
+		t.Fatalf("UpdateDescription: %v", err)
+	}
+	runHook("first-join", "test", "alice")
+	runHook("first-join", "test", "bob")
+	runHook("last-leave", "test", "bob")
+
+	expected := "create test \nfirst-join test alice\nfirst-join test bob\n"
+	var data []byte
+	for i := 0; i < 100; i++ {
+		data, _ = os.ReadFile(out)
+		if len(data) >= len(expected) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
@@ -0,0 +1,52 @@
+package group
+
+import (
+	"slices"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/ice"
+)
+
+// ICEConfiguration returns the ICE configuration used by clients of the
+// group, which is the global configuration unless the group defines its
+// own ICE servers.
+func (g *Group) ICEConfiguration() *webrtc.Configuration {
+	return ice.GroupConfiguration(g.Description().ICEServers)
+}
+
+// hideICECredentials returns a copy of servers without the credentials,
+// which are not returned by the administrative API.
+func hideICECredentials(servers []ice.Server) []ice.Server {
+	if servers == nil {
+		return nil
+	}
+	result := make([]ice.Server, len(servers))
+	for i, s := range servers {
+		s.URLs = append([]string(nil), s.URLs...)
+		s.Credential = nil
+		result[i] = s
+	}
+	return result
+}
+
+// keepICECredentials fills in the missing credentials of servers from
+// the servers with the same URLs in old.
+func keepICECredentials(servers, old []ice.Server) []ice.Server {
+	if servers == nil {
+		return nil
+	}
+	result := make([]ice.Server, len(servers))
+	for i, s := range servers {
+		if s.Credential == nil {
+			for _, o := range old {
+				if slices.Equal(s.URLs, o.URLs) {
+					s.Credential = o.Credential
+					break
+				}
+			}
+		}
+		result[i] = s
+	}
+	return result
+}
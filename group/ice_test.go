@@ -0,0 +1,49 @@
+package group
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jech/galene/ice"
+)
+
+func TestICECredentials(t *testing.T) {
+	servers := []ice.Server{
+		{
+			URLs:           []string{"turn:a.example.org"},
+			Credential:     "secret",
+			CredentialType: "hmac-sha1",
+		},
+		{
+			URLs:       []string{"turn:b.example.org"},
+			Username:   "user",
+			Credential: "password",
+		},
+	}
+
+	hidden := hideICECredentials(servers)
+	for _, s := range hidden {
+		if s.Credential != nil {
+			t.Errorf("Credential not hidden: %v", s)
+		}
+	}
+	if servers[0].Credential != "secret" {
+		t.Errorf("hideICECredentials modified its argument")
+	}
+
+	kept := keepICECredentials(hidden, servers)
+	if !reflect.DeepEqual(kept, servers) {
+		t.Errorf("Expected %v, got %v", servers, kept)
+	}
+
+	changed := []ice.Server{{
+		URLs:       []string{"turn:b.example.org"},
+		Credential: "new",
+	}, {
+		URLs: []string{"turn:c.example.org"},
+	}}
+	kept = keepICECredentials(changed, servers)
+	if kept[0].Credential != "new" || kept[1].Credential != nil {
+		t.Errorf("keepICECredentials: %v", kept)
+	}
+}
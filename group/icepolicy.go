@@ -0,0 +1,229 @@
+package group
+
+import (
+	"errors"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+// An ICEPolicy restricts the host candidates advertised by the server,
+// which is useful on multi-homed servers, where some addresses are not
+// reachable by clients.
+type ICEPolicy struct {
+	// If not empty, only the addresses of the interfaces matching
+	// one of these patterns are advertised.
+	Interfaces []string `json:"interfaces,omitempty"`
+	// The addresses of the interfaces matching one of these patterns
+	// are never advertised.
+	Exclude []string `json:"exclude,omitempty"`
+	// The address families that are advertised, "ipv4" or "ipv6",
+	// both if empty.
+	Families []string `json:"families,omitempty"`
+	// The address family whose candidates are advertised with
+	// a higher priority, if any.
+	Prefer string `json:"prefer,omitempty"`
+	// Whether to hide host addresses behind mDNS names.  If false,
+	// mDNS is disabled altogether.  If unset, the -mdns command-line
+	// flag applies.
+	MDNS *bool `json:"mdns,omitempty"`
+}
+
+func checkFamily(family string) error {
+	if family != "ipv4" && family != "ipv6" {
+		return errors.New("unknown address family " + family)
+	}
+	return nil
+}
+
+// Check returns an error if the policy is invalid.
+func (p *ICEPolicy) Check() error {
+	if p == nil {
+		return nil
+	}
+	for _, l := range [][]string{p.Interfaces, p.Exclude} {
+		for _, pattern := range l {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return errors.New("bad interface pattern " + pattern)
+			}
+		}
+	}
+	for _, f := range p.Families {
+		err := checkFamily(f)
+		if err != nil {
+			return err
+		}
+	}
+	if p.Prefer != "" {
+		err := checkFamily(p.Prefer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		ok, _ := path.Match(pattern, name)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+func (p *ICEPolicy) interfaceAllowed(name string) bool {
+	if len(p.Interfaces) > 0 && !matchAny(p.Interfaces, name) {
+		return false
+	}
+	return !matchAny(p.Exclude, name)
+}
+
+func (p *ICEPolicy) ipAllowed(ip net.IP) bool {
+	return len(p.Families) == 0 || member(ipFamily(ip), p.Families)
+}
+
+// configure applies the policy to a setting engine.  Filters are not
+// applied to the shared UDP socket, see udpMuxOptions.
+func (p *ICEPolicy) configure(s *webrtc.SettingEngine) {
+	if p == nil || p.MDNS == nil {
+		if LANMode && !UseMDNS {
+			s.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryOnly)
+		} else if !UseMDNS {
+			s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+		}
+		if p == nil {
+			return
+		}
+	} else if *p.MDNS {
+		s.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryAndGather)
+	} else {
+		s.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	}
+	if len(p.Interfaces) > 0 || len(p.Exclude) > 0 {
+		s.SetInterfaceFilter(p.interfaceAllowed)
+	}
+	if len(p.Families) > 0 {
+		s.SetIPFilter(p.ipAllowed)
+	}
+}
+
+// udpMuxOptions returns the options that apply the policy to the shared
+// UDP socket.
+func (p *ICEPolicy) udpMuxOptions() []ice.UDPMuxFromPortOption {
+	if p == nil {
+		return nil
+	}
+	var options []ice.UDPMuxFromPortOption
+	if len(p.Interfaces) > 0 || len(p.Exclude) > 0 {
+		options = append(options,
+			ice.UDPMuxFromPortWithInterfaceFilter(p.interfaceAllowed),
+		)
+	}
+	if len(p.Families) > 0 {
+		options = append(options,
+			ice.UDPMuxFromPortWithIPFilter(p.ipAllowed),
+		)
+	}
+	return options
+}
+
+// Candidate returns the candidate line c as it should be advertised,
+// or the empty string if it should not be advertised.  The shared UDP
+// socket is not subject to the filters of group policies, so we filter
+// again here.  If a family is preferred, the host candidates of the other
+// family are advertised with a lower priority, which causes clients to
+// prefer the candidates of the preferred family.
+func (p *ICEPolicy) Candidate(c string) string {
+	if p == nil {
+		return c
+	}
+	fields := strings.Fields(c)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return c
+	}
+	ip := net.ParseIP(fields[4])
+	if ip == nil {
+		// mDNS name
+		return c
+	}
+	if !p.ipAllowed(ip) {
+		return ""
+	}
+	if p.Prefer == "" || fields[7] != "host" || p.Prefer == ipFamily(ip) {
+		return c
+	}
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return c
+	}
+	// decrease the local preference, which occupies bits 8 to 23,
+	// without changing the type preference
+	local := (priority >> 8) & 0xFFFF
+	if local < 0x100 {
+		return c
+	}
+	local -= 0x100
+	priority = (priority &^ (0xFFFF << 8)) | (local << 8)
+	fields[3] = strconv.FormatUint(priority, 10)
+	return strings.Join(fields, " ")
+}
+
+// SDP applies Candidate to the candidates embedded in the session
+// description s, which are sent to the client along with the offer or
+// answer rather than trickled.
+func (p *ICEPolicy) SDP(s string) string {
+	if p == nil {
+		return s
+	}
+	lines := strings.SplitAfter(s, "\n")
+	out := lines[:0]
+	for _, l := range lines {
+		line := strings.TrimRight(l, "\r\n")
+		c, found := strings.CutPrefix(line, "a=")
+		if !found || !strings.HasPrefix(c, "candidate:") {
+			out = append(out, l)
+			continue
+		}
+		c = p.Candidate(c)
+		if c == "" {
+			continue
+		}
+		out = append(out, "a="+c+l[len(line):])
+	}
+	return strings.Join(out, "")
+}
+
+// globalICEPolicy returns the policy of the global configuration.
+func globalICEPolicy() *ICEPolicy {
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config.json: %v", err)
+		return nil
+	}
+	return conf.ICEPolicy
+}
+
+// ICEPolicy returns the policy that applies to the group, which is the
+// global policy unless the group defines its own.  It may return nil.
+func (g *Group) ICEPolicy() *ICEPolicy {
+	desc := g.Description()
+	if desc.ICEPolicy != nil {
+		return desc.ICEPolicy
+	}
+	return globalICEPolicy()
+}
@@ -0,0 +1,121 @@
+package group
+
+import (
+	"net"
+	"testing"
+)
+
+func TestICEPolicyCheck(t *testing.T) {
+	good := []ICEPolicy{
+		{},
+		{Interfaces: []string{"eth*"}, Exclude: []string{"docker0"}},
+		{Families: []string{"ipv6"}, Prefer: "ipv6"},
+	}
+	for _, p := range good {
+		err := p.Check()
+		if err != nil {
+			t.Errorf("Check %v: %v", p, err)
+		}
+	}
+
+	bad := []ICEPolicy{
+		{Interfaces: []string{"eth["}},
+		{Families: []string{"ipv5"}},
+		{Prefer: "ip"},
+	}
+	for _, p := range bad {
+		err := p.Check()
+		if err == nil {
+			t.Errorf("Check %v succeeded", p)
+		}
+	}
+
+	var p *ICEPolicy
+	if err := p.Check(); err != nil {
+		t.Errorf("Check nil: %v", err)
+	}
+}
+
+func TestICEPolicyFilters(t *testing.T) {
+	p := ICEPolicy{
+		Interfaces: []string{"eth*", "wlan0"},
+		Exclude:    []string{"eth1"},
+		Families:   []string{"ipv6"},
+	}
+	interfaces := map[string]bool{
+		"eth0": true, "eth1": false, "wlan0": true, "docker0": false,
+	}
+	for name, expected := range interfaces {
+		if p.interfaceAllowed(name) != expected {
+			t.Errorf("Interface %v: expected %v", name, expected)
+		}
+	}
+	if p.ipAllowed(net.ParseIP("192.0.2.1")) {
+		t.Errorf("IPv4 address allowed")
+	}
+	if !p.ipAllowed(net.ParseIP("2001:db8::1")) {
+		t.Errorf("IPv6 address not allowed")
+	}
+}
+
+func TestICEPolicyCandidate(t *testing.T) {
+	v4 := "candidate:1 1 udp 2130706431 192.0.2.1 1234 typ host"
+	v6 := "candidate:2 1 udp 2130706431 2001:db8::1 1234 typ host"
+	mdns := "candidate:3 1 udp 2130706431 abcd.local 1234 typ host"
+	srflx := "candidate:4 1 udp 1694498815 198.51.100.1 1234 typ srflx raddr 0.0.0.0 rport 1234"
+
+	var nilPolicy *ICEPolicy
+	if nilPolicy.Candidate(v4) != v4 {
+		t.Errorf("Nil policy changed candidate")
+	}
+
+	p := &ICEPolicy{Prefer: "ipv6"}
+	if p.Candidate(v6) != v6 || p.Candidate(mdns) != mdns ||
+		p.Candidate(srflx) != srflx {
+		t.Errorf("Candidate changed unexpectedly")
+	}
+	expected := "candidate:1 1 udp 2130640895 192.0.2.1 1234 typ host"
+	if c := p.Candidate(v4); c != expected {
+		t.Errorf("Expected %v, got %v", expected, c)
+	}
+
+	p = &ICEPolicy{Families: []string{"ipv4"}}
+	if p.Candidate(v6) != "" {
+		t.Errorf("IPv6 candidate not filtered")
+	}
+	if p.Candidate(v4) != v4 || p.Candidate(srflx) != srflx {
+		t.Errorf("IPv4 candidate filtered")
+	}
+}
+
+func TestICEPolicySDP(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.1 1234 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 2001:db8::1 1234 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+
+	var nilPolicy *ICEPolicy
+	if nilPolicy.SDP(sdp) != sdp {
+		t.Errorf("Nil policy changed SDP")
+	}
+
+	p := &ICEPolicy{Families: []string{"ipv4"}}
+	expected := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130706431 192.0.2.1 1234 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+	if s := p.SDP(sdp); s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+
+	p = &ICEPolicy{Prefer: "ipv6"}
+	expected = "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130640895 192.0.2.1 1234 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 2001:db8::1 1234 typ host\r\n" +
+		"a=end-of-candidates\r\n"
+	if s := p.SDP(sdp); s != expected {
+		t.Errorf("Expected %q, got %q", expected, s)
+	}
+}
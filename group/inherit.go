@@ -0,0 +1,188 @@
+package group
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// A group description may inherit from another one by setting the field
+// "inherits" to the name of the other group.  The two descriptions are
+// merged at the JSON level, with the semantics of JSON Merge Patch
+// (RFC 7396): objects are merged recursively, other values in the
+// inheriting description override the inherited ones, and a null value
+// removes an inherited field.  Inheritance may be chained.
+//
+// Alternatively, a description may set the field "template" to the name
+// of a template, which is merged in the same way.  Templates are stored
+// in the hidden directory ".templates" of the store, so that they are not
+// groups themselves.
+
+const maxInheritanceDepth = 16
+
+const templateDirectory = ".templates"
+
+// descriptionStamp identifies the version of an inherited definition.
+type descriptionStamp struct {
+	name    string
+	version string
+}
+
+// expandedETag returns an ETag for the expanded form of d, which changes
+// whenever one of the inherited definitions changes.  It never matches
+// the ETag of the stored definition, so it cannot be used to update it.
+func expandedETag(d *Description) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "expanded %q %q\n", d.definition, d.version)
+	for _, s := range d.inherited {
+		fmt.Fprintf(h, "%q %q\n", s.name, s.version)
+	}
+	return makeETag(
+		base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12]),
+	)
+}
+
+func decodeJSONObject(data []byte) (map[string]any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	// avoid converting large integers to floating point
+	d.UseNumber()
+	var m map[string]any
+	err := d.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("description is not a JSON object")
+	}
+	return m, nil
+}
+
+// mergeJSON merges patch into base, which is not modified.
+func mergeJSON(base, patch map[string]any) map[string]any {
+	result := make(map[string]any, len(base)+len(patch))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		pm, ok1 := v.(map[string]any)
+		bm, ok2 := result[k].(map[string]any)
+		if ok1 && ok2 {
+			result[k] = mergeJSON(bm, pm)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// expandDescription resolves the inheritance chain of the description
+// contained in data, which is the definition called name.  It returns
+// the merged description together with the versions of the inherited
+// definitions.
+func expandDescription(s Store, data []byte, name string) ([]byte, []descriptionStamp, error) {
+	seen := map[string]bool{name: true}
+	var stamps []descriptionStamp
+
+	var expand func(data []byte, name string, depth int) (map[string]any, error)
+	expand = func(data []byte, name string, depth int) (map[string]any, error) {
+		m, err := decodeJSONObject(data)
+		if err != nil {
+			return nil, err
+		}
+		base, err := parentDefinition(m, name)
+		if err != nil || base == "" {
+			return m, err
+		}
+		if depth >= maxInheritanceDepth {
+			return nil, fmt.Errorf("%v: inheritance chain too long",
+				name)
+		}
+
+		if seen[base] {
+			return nil, fmt.Errorf("%v: inheritance loop", name)
+		}
+		seen[base] = true
+
+		baseData, version, err := s.Get(base)
+		if err != nil {
+			// don't wrap, we don't want to return ErrNotExist
+			return nil, fmt.Errorf("%v: inherits from %v: %v",
+				name, base, err)
+		}
+		stamps = append(stamps, descriptionStamp{base, version})
+
+		bm, err := expand(baseData, base, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return mergeJSON(bm, m), nil
+	}
+
+	m, err := expand(data, name, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	merged, err := json.Marshal(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, stamps, nil
+}
+
+// parentDefinition returns the name of the definition that the decoded
+// description m inherits from, or the empty string if none.
+func parentDefinition(m map[string]any, name string) (string, error) {
+	inherits, ok1 := m["inherits"]
+	template, ok2 := m["template"]
+	if ok1 && ok2 {
+		return "", fmt.Errorf("%v: both inherits and template are set",
+			name)
+	}
+	if ok1 {
+		base, ok := inherits.(string)
+		if !ok || base == "" || !validGroupName(base) {
+			return "", fmt.Errorf("%v: bad value for inherits", name)
+		}
+		return base, nil
+	}
+	if ok2 {
+		base, ok := template.(string)
+		if !ok || base == "" || !validGroupName(base) {
+			return "", fmt.Errorf("%v: bad value for template", name)
+		}
+		return path.Join(templateDirectory, base), nil
+	}
+	return "", nil
+}
+
+// inheritedUnchanged returns true if none of the definitions inherited by
+// desc have changed since it was read.
+func inheritedUnchanged(s Store, desc *Description) bool {
+	for _, st := range desc.inherited {
+		version, err := s.Version(st.name)
+		if err != nil || version != st.version {
+			return false
+		}
+	}
+	return true
+}
+
+func stampsMatch(s1, s2 []descriptionStamp) bool {
+	if len(s1) != len(s2) {
+		return false
+	}
+	for i := range s1 {
+		if s1[i] != s2[i] {
+			return false
+		}
+	}
+	return true
+}
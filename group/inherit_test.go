@@ -0,0 +1,220 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeJSON(t *testing.T) {
+	base := map[string]any{
+		"a": "base",
+		"b": map[string]any{"x": 1, "y": 2},
+		"c": []any{1, 2},
+		"d": true,
+	}
+	patch := map[string]any{
+		"a": "patch",
+		"b": map[string]any{"y": 3, "z": 4},
+		"c": []any{3},
+		"d": nil,
+	}
+	expected := map[string]any{
+		"a": "patch",
+		"b": map[string]any{"x": 1, "y": 3, "z": 4},
+		"c": []any{3},
+	}
+	m := mergeJSON(base, patch)
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+	if base["a"] != "base" || len(base["b"].(map[string]any)) != 2 {
+		t.Errorf("Base modified: %v", base)
+	}
+}
+
+func writeGroupFile(t *testing.T, name, contents string) {
+	t.Helper()
+	filename := filepath.Join(Directory, name+".json")
+	err := os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	err = os.WriteFile(filename, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestInherits(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	writeGroupFile(t, "base", `{
+    "max-clients": 10,
+    "allow-recording": true,
+    "users": {
+        "bob": {"password": "pw", "permissions": "op"}
+    }
+}`)
+	writeGroupFile(t, "middle", `{
+    "inherits": "base",
+    "max-history-age": 60,
+    "users": {
+        "alice": {"password": "pw", "permissions": "present"}
+    }
+}`)
+	writeGroupFile(t, "test", `{
+    "inherits": "middle",
+    "displayName": "Test",
+    "allow-recording": null,
+    "users": {
+        "bob": {"permissions": "present"}
+    }
+}`)
+
+	desc, err := GetDescription("test")
+	if err != nil {
+		t.Fatalf("GetDescription: %v", err)
+	}
+	if desc.MaxClients != 10 || desc.MaxHistoryAge != 60 ||
+		desc.AllowRecording || desc.Inherits != "middle" {
+		t.Errorf("Unexpected description %#v", desc)
+	}
+	if len(desc.Users) != 2 {
+		t.Errorf("Expected 2 users, got %v", desc.Users)
+	}
+	bob := desc.Users["bob"]
+	if bob.Permissions.String() != "present" {
+		t.Errorf("Expected present, got %v", bob.Permissions.String())
+	}
+	if ok, _ := bob.Password.Match("pw"); !ok {
+		t.Errorf("Inherited password doesn't match")
+	}
+
+	raw, rawTag, err := GetSanitisedDescription("test", false)
+	if err != nil {
+		t.Fatalf("GetSanitisedDescription: %v", err)
+	}
+	if raw.MaxClients != 0 || raw.Inherits != "middle" {
+		t.Errorf("Raw description is expanded: %#v", raw)
+	}
+	expanded, expandedTag, err := GetSanitisedDescription("test", true)
+	if err != nil {
+		t.Fatalf("GetSanitisedDescription: %v", err)
+	}
+	if expanded.MaxClients != 10 || expanded.Users != nil {
+		t.Errorf("Unexpected expanded description: %#v", expanded)
+	}
+	if expandedTag == rawTag {
+		t.Errorf("Expanded description has the raw ETag %v", rawTag)
+	}
+
+	// changing an inherited file invalidates the cached description
+	g, err := Add("test", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer Delete("test")
+	if !descriptionUnchanged("test", g.Description()) {
+		t.Errorf("Description changed")
+	}
+	writeGroupFile(t, "base", `{"max-clients": 20}`)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(Directory, "base.json"), future, future)
+	if descriptionUnchanged("test", g.Description()) {
+		t.Errorf("Description unchanged")
+	}
+	desc, err = GetDescription("test")
+	if err != nil || desc.MaxClients != 20 {
+		t.Errorf("Expected 20, got %v (%v)", desc.MaxClients, err)
+	}
+
+	_, tag, err := GetSanitisedDescription("test", false)
+	if err != nil || tag != rawTag {
+		t.Errorf("Raw ETag changed: %v %v (%v)", rawTag, tag, err)
+	}
+	_, tag, err = GetSanitisedDescription("test", true)
+	if err != nil || tag == expandedTag {
+		t.Errorf("Expanded ETag unchanged: %v (%v)", tag, err)
+	}
+}
+
+func TestInheritsErrors(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	writeGroupFile(t, "a", `{"inherits": "b"}`)
+	writeGroupFile(t, "b", `{"inherits": "a"}`)
+	_, err = GetDescription("a")
+	if err == nil {
+		t.Errorf("Inheritance loop accepted")
+	}
+
+	writeGroupFile(t, "c", `{"inherits": "nonexistent"}`)
+	_, err = GetDescription("c")
+	if err == nil || os.IsNotExist(err) {
+		t.Errorf("Expected error, got %v", err)
+	}
+
+	writeGroupFile(t, "d", `{"inherits": "e"}`)
+	writeGroupFile(t, "e", `{"unknown-field": true}`)
+	_, err = GetDescription("d")
+	if err == nil {
+		t.Errorf("Unknown inherited field accepted")
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	writeGroupFile(t, ".templates/classroom", `{
+    "codecs": ["vp8", "opus"],
+    "max-clients": 30,
+    "presenter": [{"password": "teacher"}]
+}`)
+	writeGroupFile(t, "room1", `{
+    "template": "classroom",
+    "max-clients": 40
+}`)
+
+	desc, err := GetDescription("room1")
+	if err != nil {
+		t.Fatalf("GetDescription: %v", err)
+	}
+	if desc.MaxClients != 40 || len(desc.Codecs) != 2 ||
+		desc.Template != "classroom" {
+		t.Errorf("Bad description %v", desc)
+	}
+
+	// templates are not groups
+	_, err = Add(".templates/classroom", nil)
+	if err == nil {
+		t.Errorf("Template is a group")
+	}
+	names, err := GetDescriptionNames()
+	if err != nil || len(names) != 1 || names[0] != "room1" {
+		t.Errorf("Expected [room1], got %v (%v)", names, err)
+	}
+
+	writeGroupFile(t, "room2", `{"template": "classroom", "inherits": "room1"}`)
+	_, err = GetDescription("room2")
+	if err == nil {
+		t.Errorf("Both template and inherits accepted")
+	}
+
+	writeGroupFile(t, "room3", `{"template": "../room1"}`)
+	_, err = GetDescription("room3")
+	if err == nil {
+		t.Errorf("Bad template name accepted")
+	}
+}
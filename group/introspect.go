@@ -0,0 +1,170 @@
+package group
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+// TokenInfo describes a token, as returned by IntrospectToken.
+type TokenInfo struct {
+	Valid            bool          `json:"valid"`
+	Type             string        `json:"type,omitempty"`
+	Error            string        `json:"error,omitempty"`
+	Group            string        `json:"group,omitempty"`
+	IncludeSubgroups bool          `json:"includeSubgroups,omitempty"`
+	Username         *string       `json:"username,omitempty"`
+	Permissions      []string      `json:"permissions,omitempty"`
+	Expires          *time.Time    `json:"expires,omitempty"`
+	NotBefore        *time.Time    `json:"not-before,omitempty"`
+	IssuedAt         *time.Time    `json:"issuedAt,omitempty"`
+	IssuedBy         *string       `json:"issuedBy,omitempty"`
+	Limits           *token.Limits `json:"limits,omitempty"`
+	Uses             uint64        `json:"uses,omitempty"`
+}
+
+// IntrospectToken returns a description of a token, which may be either
+// a stateful token or a JWT.  An invalid token is not an error: it is
+// reported in the Valid and Error fields of the result.
+func IntrospectToken(tok string) (*TokenInfo, error) {
+	// this sets the clock tolerance used by the token package
+	conf, err := GetConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := token.Get(tok)
+	if err == nil {
+		return introspectStateful(s, time.Now()), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	claims, err := token.ParseUnverified(tok)
+	if err != nil {
+		return &TokenInfo{Error: "unknown token"}, nil
+	}
+	return introspectJWT(tok, claims, conf.CanonicalHost), nil
+}
+
+func introspectStateful(s *token.Stateful, now time.Time) *TokenInfo {
+	info := &TokenInfo{
+		Type:             "stateful",
+		Group:            s.Group,
+		IncludeSubgroups: s.IncludeSubgroups,
+		Username:         s.Username,
+		Permissions:      s.Permissions,
+		Expires:          s.Expires,
+		NotBefore:        s.NotBefore,
+		IssuedAt:         s.IssuedAt,
+		IssuedBy:         s.IssuedBy,
+		Limits:           s.Limits,
+		Uses:             s.Uses,
+	}
+	err := s.CheckTime(now)
+	if err == nil && s.Group != "" {
+		_, err = GetDescription(s.Group)
+		if errors.Is(err, os.ErrNotExist) {
+			err = errors.New("group does not exist")
+		}
+	}
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Valid = true
+	return info
+}
+
+func claimTime(claims map[string]any, name string) *time.Time {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return nil
+	}
+	t := time.Unix(int64(v), 0).UTC()
+	return &t
+}
+
+// audienceGroups returns the names of the groups in a token's audience.
+func audienceGroups(claims map[string]any) []string {
+	var aud []string
+	switch a := claims["aud"].(type) {
+	case string:
+		aud = []string{a}
+	case []any:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+	}
+
+	var groups []string
+	for _, a := range aud {
+		u, err := url.Parse(a)
+		if err != nil {
+			continue
+		}
+		name, found := strings.CutPrefix(u.Path, "/group/")
+		name = strings.TrimSuffix(name, "/")
+		if !found || name == "" {
+			continue
+		}
+		groups = append(groups, name)
+	}
+	return groups
+}
+
+func introspectJWT(tok string, claims map[string]any, host string) *TokenInfo {
+	info := &TokenInfo{
+		Type:      "jwt",
+		Expires:   claimTime(claims, "exp"),
+		NotBefore: claimTime(claims, "nbf"),
+		IssuedAt:  claimTime(claims, "iat"),
+	}
+	if sub, ok := claims["sub"].(string); ok {
+		info.Username = &sub
+	}
+	if iss, ok := claims["iss"].(string); ok {
+		info.IssuedBy = &iss
+	}
+
+	err := errors.New("token for unknown group")
+	for _, name := range audienceGroups(claims) {
+		if info.Group == "" {
+			info.Group = name
+		}
+		var desc *Description
+		desc, err = GetDescription(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				err = errors.New("group does not exist")
+			}
+			continue
+		}
+		var t token.Token
+		t, err = token.Parse(tok, desc.AuthKeys)
+		if err == nil && t == nil {
+			err = errors.New("couldn't parse token")
+		}
+		if err != nil {
+			continue
+		}
+		var perms []string
+		_, perms, err = t.Check(host, name, nil)
+		if err != nil {
+			continue
+		}
+		info.Valid = true
+		info.Group = name
+		info.Permissions = perms
+		info.Limits = t.GetLimits()
+		return info
+	}
+	info.Error = err.Error()
+	return info
+}
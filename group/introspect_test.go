@@ -0,0 +1,110 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestIntrospectToken(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+	token.SetStatefulFilename(filepath.Join(DataDirectory, "tokens.jsonl"))
+
+	err = os.WriteFile(filepath.Join(Directory, "test.json"),
+		[]byte(`{"authKeys": [{
+		    "kty": "oct",
+		    "alg": "HS256",
+		    "k": "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ"
+		}]}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+	user := "bob"
+	for _, tok := range []*token.Stateful{
+		{Token: "valid", Group: "test", Username: &user,
+			Permissions: []string{"present"}, Expires: &future},
+		{Token: "expired", Group: "test", Expires: &past},
+		{Token: "nogroup", Group: "nonexistent", Expires: &future},
+	} {
+		_, err := token.Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	key := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ",
+	}
+	otherKey := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "MYz3IfCq4Yq-UmPdNqWEOdPl4C_m9imHHs9uveDUJGQ",
+	}
+	sign := func(key map[string]any, aud string) string {
+		jwt, err := token.SignJWT(key, map[string]any{
+			"sub":         "alice",
+			"aud":         aud,
+			"permissions": []string{"op"},
+			"iat":         now.Unix(),
+			"exp":         future.Unix(),
+		})
+		if err != nil {
+			t.Fatalf("SignJWT: %v", err)
+		}
+		return jwt
+	}
+
+	tests := []struct {
+		token string
+		typ   string
+		valid bool
+	}{
+		{"valid", "stateful", true},
+		{"expired", "stateful", false},
+		{"nogroup", "stateful", false},
+		{"unknown", "", false},
+		{sign(key, "https://galene.org/group/test/"), "jwt", true},
+		{sign(otherKey, "https://galene.org/group/test/"), "jwt", false},
+		{sign(key, "https://galene.org/group/other/"), "jwt", false},
+	}
+
+	for _, test := range tests {
+		info, err := IntrospectToken(test.token)
+		if err != nil {
+			t.Errorf("IntrospectToken %v: %v", test.token, err)
+			continue
+		}
+		if info.Type != test.typ || info.Valid != test.valid {
+			t.Errorf("IntrospectToken %v: got %v %v, expected %v %v",
+				test.token, info.Type, info.Valid,
+				test.typ, test.valid)
+		}
+		if !info.Valid && info.Error == "" {
+			t.Errorf("IntrospectToken %v: no error", test.token)
+		}
+		if info.Valid && (info.Username == nil || info.Group != "test") {
+			t.Errorf("IntrospectToken %v: got %v", test.token, info)
+		}
+	}
+
+	err = token.NoteUse("valid")
+	if err != nil {
+		t.Fatalf("NoteUse: %v", err)
+	}
+	info, err := IntrospectToken("valid")
+	if err != nil || info.Uses != 1 {
+		t.Errorf("Expected 1 use, got %v %v", info, err)
+	}
+}
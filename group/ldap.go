@@ -0,0 +1,89 @@
+package group
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/jech/galene/ldap"
+)
+
+// An LDAPRule grants permissions to the directory users that belong to
+// the directory group Group, a DN.  A rule with an empty group matches
+// all directory users.  The first rule that matches applies.
+type LDAPRule struct {
+	Group       string      `json:"group,omitempty"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// An ldapResult is the result of authenticating a user against the
+// directory.  Since this requires network access, it is done before
+// locking the group, and the result is passed to getPermission.
+type ldapResult struct {
+	groups []string
+	err    error
+}
+
+// ldapAuthenticate authenticates the user of creds against the directory
+// defined in the server configuration.  It returns nil if the group's
+// LDAP rules don't apply to creds.  Called unlocked.
+func (g *Group) ldapAuthenticate(creds ClientCredentials) *ldapResult {
+	if creds.Token != "" || creds.PIN != "" ||
+		creds.Username == nil || creds.Password == "" {
+		return nil
+	}
+
+	g.mu.Lock()
+	desc := g.description
+	apply := len(desc.LDAPUsers) > 0 && !g.userExists(*creds.Username)
+	g.mu.Unlock()
+	if !apply {
+		return nil
+	}
+
+	conf, err := GetConfiguration()
+	if err != nil {
+		return &ldapResult{err: err}
+	}
+	if conf.LDAP == nil {
+		return &ldapResult{err: &NotAuthorisedError{
+			err: errors.New("no LDAP directory configured"),
+		}}
+	}
+
+	groups, err := ldap.Authenticate(
+		conf.LDAP, *creds.Username, creds.Password,
+	)
+	if err != nil {
+		if errors.Is(err, ldap.ErrInvalidCredentials) {
+			return &ldapResult{err: &NotAuthorisedError{}}
+		}
+		log.Printf("LDAP: %v", err)
+		return &ldapResult{err: err}
+	}
+	return &ldapResult{groups: groups}
+}
+
+// ldapPermission returns the permissions granted by the group's LDAP
+// rules to a user authenticated by ldapAuthenticate.
+func ldapPermission(desc *Description, r *ldapResult) (Permissions, error) {
+	if r == nil {
+		// the description changed after we authenticated
+		return Permissions{}, &NotAuthorisedError{}
+	}
+	if r.err != nil {
+		return Permissions{}, r.err
+	}
+
+	for _, rule := range desc.LDAPUsers {
+		if rule.Group == "" {
+			return rule.Permissions, nil
+		}
+		for _, g := range r.groups {
+			if strings.EqualFold(g, rule.Group) {
+				return rule.Permissions, nil
+			}
+		}
+	}
+	return Permissions{}, &NotAuthorisedError{}
+}
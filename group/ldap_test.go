@@ -0,0 +1,67 @@
+package group
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLDAPPermission(t *testing.T) {
+	op, _ := NewPermissions("op")
+	present, _ := NewPermissions("present")
+	desc := &Description{
+		LDAPUsers: []LDAPRule{
+			{
+				Group:       "cn=staff,dc=example,dc=org",
+				Permissions: op,
+			},
+			{
+				Permissions: present,
+			},
+		},
+	}
+
+	p, err := ldapPermission(desc, &ldapResult{
+		groups: []string{"CN=Staff,DC=example,DC=org"},
+	})
+	if err != nil || p.String() != "op" {
+		t.Errorf("Expected op, got %v (%v)", p, err)
+	}
+
+	p, err = ldapPermission(desc, &ldapResult{})
+	if err != nil || p.String() != "present" {
+		t.Errorf("Expected present, got %v (%v)", p, err)
+	}
+
+	var autherr *NotAuthorisedError
+	_, err = ldapPermission(desc, nil)
+	if !errors.As(err, &autherr) {
+		t.Errorf("Expected not authorised, got %v", err)
+	}
+
+	_, err = ldapPermission(desc, &ldapResult{err: &NotAuthorisedError{}})
+	if !errors.As(err, &autherr) {
+		t.Errorf("Expected not authorised, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticateNotApplicable(t *testing.T) {
+	g := &Group{description: &Description{
+		Users: map[string]UserDescription{"bob": {}},
+	}}
+	bob := "bob"
+	if g.ldapAuthenticate(ClientCredentials{
+		Username: &bob, Password: "pw",
+	}) != nil {
+		t.Errorf("LDAP used without rules")
+	}
+
+	g.description.LDAPUsers = []LDAPRule{{}}
+	if g.ldapAuthenticate(ClientCredentials{
+		Username: &bob, Password: "pw",
+	}) != nil {
+		t.Errorf("LDAP used for local user")
+	}
+	if g.ldapAuthenticate(ClientCredentials{Token: "token"}) != nil {
+		t.Errorf("LDAP used for token")
+	}
+}
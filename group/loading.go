@@ -0,0 +1,76 @@
+package group
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// At startup, we parse all group definitions in order to find the public
+// groups, which takes a long time on servers with many groups.  The
+// definitions are parsed in parallel, and the server becomes ready once
+// the first scan has completed.  Groups that are joined in the meantime
+// are loaded on demand.
+
+const loadProgressInterval = 10 * time.Second
+
+var ready atomic.Bool
+
+// Ready returns true once the group definitions have been scanned.
+func Ready() bool {
+	return ready.Load()
+}
+
+// addIfPublic adds the group name if its description is public.
+func addIfPublic(name string) {
+	desc, err := cachedDescription(name)
+	if err != nil {
+		log.Printf("Group %v: %v", name, err)
+		return
+	}
+	if desc.Public {
+		Add(name, desc)
+	}
+}
+
+// addPublic adds the public groups among names, in parallel.
+func addPublic(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	workers := min(runtime.GOMAXPROCS(0), len(names))
+	start := time.Now()
+
+	ch := make(chan string)
+	var count atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range ch {
+				addIfPublic(name)
+				count.Add(1)
+			}
+		}()
+	}
+
+	last := start
+	for _, name := range names {
+		ch <- name
+		if !Ready() && time.Since(last) >= loadProgressInterval {
+			log.Printf("Loaded %v of %v group definitions",
+				count.Load(), len(names))
+			last = time.Now()
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if !Ready() && time.Since(start) >= loadProgressInterval {
+		log.Printf("Loaded %v group definitions in %v",
+			len(names), time.Since(start).Round(time.Second))
+	}
+}
@@ -0,0 +1,47 @@
+package group
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddPublic(t *testing.T) {
+	dir := t.TempDir()
+	err := setupTest(dir, t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+
+	var names []string
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("group-%v", i)
+		desc := `{}`
+		if i%5 == 0 {
+			desc = `{"public": true}`
+		}
+		err := os.WriteFile(
+			filepath.Join(dir, name+".json"), []byte(desc), 0600,
+		)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		names = append(names, name)
+		defer Delete(name)
+	}
+
+	addPublic(append(names, "missing"))
+
+	for i, name := range names {
+		g := Get(name)
+		if (i%5 == 0) != (g != nil) {
+			t.Errorf("Group %v: %v", name, g)
+		}
+	}
+
+	Update()
+	if !Ready() {
+		t.Errorf("Not ready after update")
+	}
+}
@@ -0,0 +1,273 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// A maintenance window is scheduled by the administrator.  Until its
+// start, the clients of all groups receive countdown warnings, and new
+// joins are refused during the final minutes (the lockout) and until the
+// window is over.  If Drain is set, all clients are disconnected when
+// the window starts, or immediately if it has already started.  The
+// window ends at End, by default DefaultMaintenanceDuration after its
+// start, or when it is cancelled; it is not persisted across restarts.
+
+const (
+	// the default duration of the lockout
+	DefaultMaintenanceLockout = 5 * time.Minute
+	// the default duration of a maintenance window
+	DefaultMaintenanceDuration = time.Hour
+)
+
+// the times before the start of a maintenance window at which warnings
+// are sent
+var maintenanceWarnings = []time.Duration{
+	time.Hour, 30 * time.Minute, 15 * time.Minute, 10 * time.Minute,
+	5 * time.Minute, 2 * time.Minute, time.Minute,
+}
+
+// Maintenance describes a maintenance window.  Lockout is in seconds.
+type Maintenance struct {
+	Start   time.Time  `json:"start"`
+	End     *time.Time `json:"end,omitempty"`
+	Lockout int        `json:"lockout,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Drain   bool       `json:"drain,omitempty"`
+}
+
+var maintenance struct {
+	mu      sync.Mutex
+	current *Maintenance
+	cancel  chan struct{}
+}
+
+// lockout returns the duration of the lockout of m.
+func (m *Maintenance) lockout() time.Duration {
+	if m.Lockout <= 0 {
+		return DefaultMaintenanceLockout
+	}
+	return time.Duration(m.Lockout) * time.Second
+}
+
+// active returns true if joins are refused at time now.
+func (m *Maintenance) active(now time.Time) bool {
+	if m.End != nil && !now.Before(*m.End) {
+		return false
+	}
+	return !now.Before(m.Start.Add(-m.lockout()))
+}
+
+// GetMaintenance returns the scheduled maintenance window, or nil if
+// there is none.
+func GetMaintenance() *Maintenance {
+	maintenance.mu.Lock()
+	defer maintenance.mu.Unlock()
+	if maintenance.current == nil {
+		return nil
+	}
+	m := *maintenance.current
+	return &m
+}
+
+// SetMaintenance schedules a maintenance window, replacing any previously
+// scheduled window.
+func SetMaintenance(m *Maintenance) error {
+	now := time.Now()
+	if m.Start.IsZero() {
+		return errors.New("maintenance start not specified")
+	}
+	mm := *m
+	if mm.End == nil {
+		end := mm.Start.Add(DefaultMaintenanceDuration)
+		mm.End = &end
+	}
+	if !mm.End.After(mm.Start) {
+		return errors.New("maintenance ends before it starts")
+	}
+	if !mm.End.After(now) {
+		return errors.New("maintenance has already ended")
+	}
+
+	cancel := make(chan struct{})
+
+	maintenance.mu.Lock()
+	if maintenance.cancel != nil {
+		close(maintenance.cancel)
+	}
+	maintenance.current = &mm
+	maintenance.cancel = cancel
+	maintenance.mu.Unlock()
+
+	go maintenanceLoop(&mm, cancel)
+	maintenanceChanged()
+	return nil
+}
+
+// CancelMaintenance cancels the scheduled maintenance window.  It returns
+// false if there was none.
+func CancelMaintenance() bool {
+	maintenance.mu.Lock()
+	found := maintenance.current != nil
+	if found {
+		close(maintenance.cancel)
+		maintenance.current = nil
+		maintenance.cancel = nil
+	}
+	maintenance.mu.Unlock()
+
+	if found {
+		broadcastAnnouncement(
+			"The scheduled maintenance has been cancelled.",
+		)
+		maintenanceChanged()
+	}
+	return found
+}
+
+// checkMaintenance returns an error if joining is not allowed at time now.
+func checkMaintenance(now time.Time) error {
+	maintenance.mu.Lock()
+	defer maintenance.mu.Unlock()
+	m := maintenance.current
+	if m != nil && m.active(now) {
+		if m.Start.After(now) {
+			return UserError(
+				"the server is about to undergo maintenance",
+			)
+		}
+		return UserError("the server is undergoing maintenance")
+	}
+	return nil
+}
+
+// maintenanceWarning returns the warning sent at the given time before
+// the start of m.
+func maintenanceWarning(m *Maintenance, remaining time.Duration) string {
+	var s string
+	if remaining <= 0 {
+		s = "The server is now undergoing maintenance."
+	} else {
+		minutes := int((remaining + 30*time.Second) / time.Minute)
+		plural := "s"
+		if minutes == 1 {
+			plural = ""
+		}
+		s = fmt.Sprintf(
+			"The server will undergo maintenance in %v minute%v.",
+			minutes, plural,
+		)
+	}
+	if m.Message != "" {
+		s = s + "  " + m.Message
+	}
+	return s
+}
+
+// sleepUntil waits until time t, and returns false if cancel was closed
+// in the meantime.
+func sleepUntil(t time.Time, cancel <-chan struct{}) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+func maintenanceLoop(m *Maintenance, cancel <-chan struct{}) {
+	now := time.Now()
+	if m.Start.After(now) {
+		broadcastAnnouncement(
+			maintenanceWarning(m, m.Start.Sub(now)),
+		)
+	}
+	for _, d := range maintenanceWarnings {
+		t := m.Start.Add(-d)
+		if !t.After(now.Add(time.Minute / 2)) {
+			continue
+		}
+		if !sleepUntil(t, cancel) {
+			return
+		}
+		broadcastAnnouncement(maintenanceWarning(m, d))
+	}
+
+	lockout := m.Start.Add(-m.lockout())
+	if lockout.After(time.Now()) {
+		if !sleepUntil(lockout, cancel) {
+			return
+		}
+		// inform clients that joins are now refused
+		maintenanceChanged()
+	}
+
+	if m.Start.After(time.Now()) {
+		if !sleepUntil(m.Start, cancel) {
+			return
+		}
+	}
+	if m.Drain {
+		log.Printf("Maintenance: disconnecting all clients")
+		message := maintenanceWarning(m, 0)
+		Range(func(g *Group) bool {
+			for _, c := range g.GetClients(nil) {
+				if member("system", c.Permissions()) {
+					continue
+				}
+				c.Kick("", nil, message)
+			}
+			return true
+		})
+	} else {
+		broadcastAnnouncement(maintenanceWarning(m, 0))
+	}
+
+	if !sleepUntil(*m.End, cancel) {
+		return
+	}
+	maintenance.mu.Lock()
+	done := maintenance.current == m
+	if done {
+		maintenance.current = nil
+		maintenance.cancel = nil
+	}
+	maintenance.mu.Unlock()
+	if done {
+		log.Printf("Maintenance is over")
+		maintenanceChanged()
+	}
+}
+
+// broadcastAnnouncement sends a message to the clients of all groups.
+func broadcastAnnouncement(message string) {
+	Range(func(g *Group) bool {
+		for _, c := range g.GetClients(nil) {
+			a, ok := c.(announcer)
+			if !ok {
+				continue
+			}
+			err := a.Announce("", message)
+			if err != nil {
+				log.Printf("Announce: %v", err)
+			}
+		}
+		return true
+	})
+}
+
+// maintenanceChanged informs the clients of all groups that their status
+// has changed.
+func maintenanceChanged() {
+	Range(func(g *Group) bool {
+		for _, c := range g.GetClients(nil) {
+			c.Joined(g.Name(), "change")
+		}
+		return true
+	})
+}
@@ -0,0 +1,98 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceActive(t *testing.T) {
+	now := time.Now()
+	end := now.Add(2 * time.Hour)
+	m := &Maintenance{Start: now.Add(time.Hour), End: &end}
+
+	tests := []struct {
+		time   time.Time
+		active bool
+	}{
+		{now, false},
+		{now.Add(54 * time.Minute), false},
+		{now.Add(56 * time.Minute), true},
+		{now.Add(90 * time.Minute), true},
+		{end, false},
+	}
+	for _, tt := range tests {
+		if m.active(tt.time) != tt.active {
+			t.Errorf("%v: expected %v", tt.time.Sub(now), tt.active)
+		}
+	}
+
+	m.Lockout = 20 * 60
+	if !m.active(now.Add(45 * time.Minute)) {
+		t.Errorf("Lockout not applied")
+	}
+}
+
+func TestMaintenanceWarning(t *testing.T) {
+	m := &Maintenance{Message: "Back soon."}
+	w := maintenanceWarning(m, 10*time.Minute)
+	if w != "The server will undergo maintenance in 10 minutes.  Back soon." {
+		t.Errorf("Got %q", w)
+	}
+	w = maintenanceWarning(m, 59*time.Second)
+	if w != "The server will undergo maintenance in 1 minute.  Back soon." {
+		t.Errorf("Got %q", w)
+	}
+	w = maintenanceWarning(&Maintenance{}, 0)
+	if w != "The server is now undergoing maintenance." {
+		t.Errorf("Got %q", w)
+	}
+}
+
+func TestSetMaintenance(t *testing.T) {
+	defer CancelMaintenance()
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	err := SetMaintenance(&Maintenance{Start: now, End: &past})
+	if err == nil {
+		t.Errorf("Maintenance ending before start accepted")
+	}
+	err = SetMaintenance(&Maintenance{})
+	if err == nil {
+		t.Errorf("Maintenance without start accepted")
+	}
+	if GetMaintenance() != nil {
+		t.Errorf("Bad maintenance was scheduled")
+	}
+
+	err = SetMaintenance(&Maintenance{Start: now.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("SetMaintenance: %v", err)
+	}
+	if m := GetMaintenance(); m == nil || !m.Start.Equal(now.Add(time.Hour)) {
+		t.Errorf("GetMaintenance: %v", m)
+	}
+	if err := checkMaintenance(now); err != nil {
+		t.Errorf("checkMaintenance: %v", err)
+	}
+	if err := checkMaintenance(now.Add(58 * time.Minute)); err == nil {
+		t.Errorf("Join allowed during lockout")
+	}
+	end := now.Add(time.Hour + DefaultMaintenanceDuration)
+	if m := GetMaintenance(); m == nil || m.End == nil || !m.End.Equal(end) {
+		t.Errorf("Expected end %v, got %v", end, m)
+	}
+	if err := checkMaintenance(end); err != nil {
+		t.Errorf("Join refused after the default end: %v", err)
+	}
+
+	if !CancelMaintenance() {
+		t.Errorf("CancelMaintenance failed")
+	}
+	if CancelMaintenance() {
+		t.Errorf("CancelMaintenance succeeded twice")
+	}
+	if err := checkMaintenance(now.Add(58 * time.Minute)); err != nil {
+		t.Errorf("checkMaintenance after cancel: %v", err)
+	}
+}
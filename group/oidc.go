@@ -0,0 +1,59 @@
+package group
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+// OIDC describes how users log into a group through an OpenID Connect
+// provider.  The webserver performs the authorisation code flow, and the
+// claims of the resulting ID token are mapped to a stateful token.
+type OIDC struct {
+	// The issuer, used to discover the provider's endpoints.
+	Issuer   string `json:"issuer"`
+	ClientID string `json:"client-id"`
+	// The client secret, empty for public clients.  It is never
+	// returned by the administrative API.
+	ClientSecret string `json:"client-secret,omitempty"`
+	// Scopes requested in addition to "openid".
+	Scopes []string `json:"scopes,omitempty"`
+	// The claim that holds the username, "preferred_username" by
+	// default.
+	UsernameClaim string `json:"username-claim,omitempty"`
+	// The time, in seconds, for which the stateful token is valid.
+	Validity int `json:"validity,omitempty"`
+	// The rules that map claims to permissions.  The first rule that
+	// matches applies.
+	Rules []ExchangeRule `json:"rules"`
+}
+
+// OIDCToken validates an ID token obtained from the group's OpenID
+// Connect provider, and returns a new stateful token for the group.
+// Keys is the provider's key set, and nonce the nonce sent in the
+// authentication request.  The returned token has not been saved.
+func OIDCToken(g *Group, idToken string, keys []map[string]any, nonce string, now time.Time) (*token.Stateful, error) {
+	oidc := g.Description().OIDC
+	if oidc == nil {
+		return nil, ErrExchangeDenied
+	}
+
+	claims, err := token.VerifyJWT(
+		idToken, keys, oidc.Issuer, oidc.ClientID,
+	)
+	if err != nil {
+		return nil, &NotAuthorisedError{err: err}
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return nil, &NotAuthorisedError{err: errors.New("bad nonce")}
+	}
+
+	usernameClaim := oidc.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	return claimsToken(g, claims, usernameClaim, oidc.Rules,
+		oidc.Validity, now,
+	)
+}
@@ -0,0 +1,86 @@
+package group
+
+import (
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// The list of public groups is requested by every client that loads the
+// landing page, so we maintain an index of public groups that is updated
+// whenever a group is created, modified or deleted, rather than walking
+// all groups on every request.  Client counts are not indexed, they are
+// read from the groups themselves.
+
+var publicGroups struct {
+	mu     sync.Mutex
+	groups map[string]*Group
+	// the groups sorted by name, nil if it needs to be recomputed
+	sorted []*Group
+}
+
+// indexPublic updates the index of public groups after the description
+// of g has changed.
+//
+// Called with g.mu taken.
+func indexPublic(g *Group) {
+	public := g.description.Public
+
+	publicGroups.mu.Lock()
+	defer publicGroups.mu.Unlock()
+
+	old, indexed := publicGroups.groups[g.name]
+	if public == indexed && old == g {
+		return
+	}
+	if public {
+		if publicGroups.groups == nil {
+			publicGroups.groups = make(map[string]*Group)
+		}
+		publicGroups.groups[g.name] = g
+	} else {
+		delete(publicGroups.groups, g.name)
+	}
+	publicGroups.sorted = nil
+}
+
+// unindexPublic removes g from the index of public groups.
+func unindexPublic(g *Group) {
+	publicGroups.mu.Lock()
+	defer publicGroups.mu.Unlock()
+
+	if publicGroups.groups[g.name] != g {
+		return
+	}
+	delete(publicGroups.groups, g.name)
+	publicGroups.sorted = nil
+}
+
+// getPublicGroups returns the public groups sorted by name.  The
+// returned slice must not be modified.
+func getPublicGroups() []*Group {
+	publicGroups.mu.Lock()
+	defer publicGroups.mu.Unlock()
+
+	if publicGroups.sorted == nil {
+		gs := make([]*Group, 0, len(publicGroups.groups))
+		for _, g := range publicGroups.groups {
+			gs = append(gs, g)
+		}
+		sort.Slice(gs, func(i, j int) bool {
+			return gs[i].name < gs[j].name
+		})
+		publicGroups.sorted = gs
+	}
+	return publicGroups.sorted
+}
+
+// GetPublic returns the status of all public groups, sorted by name.
+func GetPublic(base *url.URL) []Status {
+	groups := getPublicGroups()
+	gs := make([]Status, 0, len(groups))
+	for _, g := range groups {
+		gs = append(gs, g.Status(false, base))
+	}
+	return gs
+}
@@ -0,0 +1,52 @@
+package group
+
+import (
+	"testing"
+)
+
+func TestPublicIndex(t *testing.T) {
+	groups.groups = nil
+	publicGroups.groups = nil
+	publicGroups.sorted = nil
+
+	names := func() []string {
+		var ns []string
+		for _, s := range GetPublic(nil) {
+			ns = append(ns, s.Name)
+		}
+		return ns
+	}
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	Add("public-b", &Description{Public: true})
+	Add("private", &Description{})
+	Add("public-a", &Description{Public: true, DisplayName: "A"})
+	if ns := names(); !equal(ns, []string{"public-a", "public-b"}) {
+		t.Errorf("Expected [public-a public-b], got %v", ns)
+	}
+
+	// descriptions with the same version are considered unchanged
+	Add("private", &Description{Public: true, version: "2"})
+	Add("public-b", &Description{version: "2"})
+	if ns := names(); !equal(ns, []string{"private", "public-a"}) {
+		t.Errorf("Expected [private public-a], got %v", ns)
+	}
+
+	Delete("private")
+	public := GetPublic(nil)
+	if len(public) != 1 || public[0].Name != "public-a" ||
+		public[0].DisplayName != "A" ||
+		public[0].ClientCount == nil || *public[0].ClientCount != 0 {
+		t.Errorf("Bad public groups %v", public)
+	}
+}
@@ -0,0 +1,264 @@
+package group
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+// A hot standby is a second server that holds a copy of the group
+// definitions and stateful tokens of the primary, so that it may take
+// over if the primary fails.  The primary pushes its state to the
+// standby, using the administrative API, whenever it changes.  Once the
+// standby has been promoted, it no longer accepts replicated state.
+
+// StandbyConfig describes the standby server to which the state of this
+// server is replicated.
+type StandbyConfig struct {
+	// The base URL of the standby server.
+	URL string `json:"url"`
+	// The credentials of an administrator of the standby.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// A Replica is the state that is replicated to a standby server.
+type Replica struct {
+	// The contents of the definition of every group, indexed by
+	// group name.
+	Groups map[string][]byte `json:"groups"`
+	// All stateful tokens.
+	Tokens []*token.Stateful `json:"tokens"`
+}
+
+// ReplicaStatus describes the replication state of a server.
+type ReplicaStatus struct {
+	Standby bool       `json:"standby"`
+	Updated *time.Time `json:"updated,omitempty"`
+}
+
+// ErrNotStandby is returned when replicated state is pushed to a server
+// that is not a standby.
+var ErrNotStandby = errors.New("this server is not a standby")
+
+var standby atomic.Bool
+
+// on the standby, the time of the last update
+var replicaUpdated atomic.Pointer[time.Time]
+
+// on the primary, the digest of the last state pushed
+var replication struct {
+	mu     sync.Mutex
+	digest [sha256.Size]byte
+}
+
+// SetStandby sets whether this server accepts replicated state.
+func SetStandby(v bool) {
+	standby.Store(v)
+}
+
+// GetReplicaStatus returns the replication state of this server.
+func GetReplicaStatus() ReplicaStatus {
+	return ReplicaStatus{
+		Standby: standby.Load(),
+		Updated: replicaUpdated.Load(),
+	}
+}
+
+// Promote turns a standby into a primary, which will ignore any further
+// replicated state.
+func Promote() error {
+	if !standby.CompareAndSwap(true, false) {
+		return ErrNotStandby
+	}
+	log.Printf("Promoted to primary")
+	return nil
+}
+
+// GetReplica returns the state of this server that is replicated to
+// a standby.
+func GetReplica() (*Replica, error) {
+	s, err := getStore()
+	if err != nil {
+		return nil, err
+	}
+	names, err := s.List("")
+	if err != nil {
+		return nil, err
+	}
+	r := &Replica{Groups: make(map[string][]byte, len(names))}
+	for _, name := range names {
+		data, _, err := s.Get(name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		r.Groups[name] = data
+	}
+
+	ts, _, err := token.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	// make the result deterministic, so that it may be compared
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Token < ts[j].Token
+	})
+	r.Tokens = ts
+	return r, nil
+}
+
+// ApplyReplica replaces the group definitions and stateful tokens of this
+// server with those of a replica.  It fails with ErrNotStandby if this
+// server is not a standby.
+func ApplyReplica(r *Replica) error {
+	if !standby.Load() {
+		return ErrNotStandby
+	}
+	for name := range r.Groups {
+		if !validGroupName(name) {
+			return UserError("illegal group name " + name)
+		}
+	}
+
+	err := func() error {
+		groups.mu.Lock()
+		defer groups.mu.Unlock()
+
+		s, err := getStore()
+		if err != nil {
+			return err
+		}
+		names, err := s.List("")
+		if err != nil {
+			return err
+		}
+		for name, data := range r.Groups {
+			old, version, err := s.Get(name)
+			if err == nil && bytes.Equal(old, data) {
+				continue
+			} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			err = s.Put(name, data, version)
+			if err != nil {
+				return err
+			}
+			forgetMissing()
+		}
+		for _, name := range names {
+			_, ok := r.Groups[name]
+			if ok {
+				continue
+			}
+			version, err := s.Version(name)
+			if err == nil {
+				err = s.Delete(name, version)
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	err = token.Replace(r.Tokens)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	replicaUpdated.Store(&now)
+	return nil
+}
+
+var replicationClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+func pushReplica(conf *StandbyConfig, body []byte) error {
+	req, err := http.NewRequest("PUT",
+		strings.TrimRight(conf.URL, "/")+"/galene-api/v0/.replica",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if conf.Username != "" || conf.Password != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+	resp, err := replicationClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// Replicate pushes the state of this server to the standby configured in
+// config.json, if any, unless it hasn't changed since the last time it
+// was pushed successfully.
+func Replicate() {
+	if !replication.mu.TryLock() {
+		// a previous push is still in progress
+		return
+	}
+	defer replication.mu.Unlock()
+
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config.json: %v", err)
+		return
+	}
+	if conf.Standby == nil || conf.Standby.URL == "" {
+		return
+	}
+
+	r, err := GetReplica()
+	if err != nil {
+		log.Printf("Replicate: %v", err)
+		return
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Replicate: %v", err)
+		return
+	}
+
+	h := sha256.New()
+	h.Write([]byte(conf.Standby.URL))
+	h.Write([]byte{0})
+	h.Write(body)
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	if digest == replication.digest {
+		return
+	}
+
+	err = pushReplica(conf.Standby, body)
+	if err != nil {
+		log.Printf("Replicate to %v: %v", conf.Standby.URL, err)
+		return
+	}
+	replication.digest = digest
+}
@@ -0,0 +1,120 @@
+package group
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestReplica(t *testing.T) {
+	Directory = t.TempDir()
+	token.SetStatefulFilename(filepath.Join(t.TempDir(), "tokens.jsonl"))
+	defer token.SetStatefulFilename("")
+	defer SetStandby(false)
+
+	files := map[string]string{
+		"a":     `{"public": true}`,
+		"sub/b": `{"max-clients": 3}`,
+	}
+	for name, data := range files {
+		filename := filepath.Join(Directory, name+".json")
+		err := os.MkdirAll(filepath.Dir(filename), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filename, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	future := time.Now().Add(time.Hour)
+	_, err := token.Update(&token.Stateful{
+		Token:       "tok",
+		Group:       "a",
+		Permissions: []string{"present"},
+		Expires:     &future,
+	}, "")
+	if err != nil {
+		t.Fatalf("Update token: %v", err)
+	}
+
+	r, err := GetReplica()
+	if err != nil {
+		t.Fatalf("GetReplica: %v", err)
+	}
+	if len(r.Groups) != 2 || len(r.Tokens) != 1 {
+		t.Errorf("Unexpected replica %v", r)
+	}
+
+	// the standby has a group that has been deleted on the primary
+	Directory = t.TempDir()
+	token.SetStatefulFilename(filepath.Join(t.TempDir(), "tokens.jsonl"))
+	err = os.WriteFile(
+		filepath.Join(Directory, "c.json"), []byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ApplyReplica(r)
+	if !errors.Is(err, ErrNotStandby) {
+		t.Errorf("ApplyReplica on primary: %v", err)
+	}
+
+	SetStandby(true)
+	err = ApplyReplica(r)
+	if err != nil {
+		t.Fatalf("ApplyReplica: %v", err)
+	}
+
+	names, err := GetDescriptionNames()
+	if err != nil {
+		t.Fatalf("GetDescriptionNames: %v", err)
+	}
+	for i := range names {
+		names[i] = filepath.ToSlash(names[i])
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a", "sub/b"}) {
+		t.Errorf("Unexpected groups %v", names)
+	}
+	for name, data := range files {
+		d, err := os.ReadFile(filepath.Join(Directory, name+".json"))
+		if err != nil || !bytes.Equal(d, []byte(data)) {
+			t.Errorf("Group %v: %v %v", name, string(d), err)
+		}
+	}
+	tok, _, err := token.Get("tok")
+	if err != nil || tok.Group != "a" {
+		t.Errorf("Get token: %v %v", tok, err)
+	}
+	if GetReplicaStatus().Updated == nil {
+		t.Errorf("Update time not set")
+	}
+
+	bad := &Replica{Groups: map[string][]byte{"../x": []byte("{}")}}
+	err = ApplyReplica(bad)
+	if err == nil {
+		t.Errorf("ApplyReplica with bad name succeeded")
+	}
+
+	err = Promote()
+	if err != nil {
+		t.Errorf("Promote: %v", err)
+	}
+	err = ApplyReplica(r)
+	if !errors.Is(err, ErrNotStandby) {
+		t.Errorf("ApplyReplica after promotion: %v", err)
+	}
+	err = Promote()
+	if !errors.Is(err, ErrNotStandby) {
+		t.Errorf("Promote twice: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package group
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/stun/v3"
+)
+
+// When a client changes networks (for example from Wi-Fi to cellular),
+// the candidate pair in use stops carrying traffic, and the client starts
+// sending connectivity checks from its new address.  Instead of waiting
+// for ICE to fail and performing a full restart, we switch to the new
+// pair as soon as the old one has lost consent.
+
+// roamingTimeout is the time after which a candidate pair that hasn't
+// received any traffic is considered to have lost consent.
+const roamingTimeout = 3 * time.Second
+
+// roamingExpiry is the time after which we forget about an ICE session.
+const roamingExpiry = time.Minute
+
+type roamingSession struct {
+	current *ice.CandidatePair
+	time    time.Time
+}
+
+type roamingState struct {
+	mu       sync.Mutex
+	sessions map[string]*roamingSession
+	expired  time.Time
+}
+
+var roaming roamingState
+
+func pairLastReceived(pair *ice.CandidatePair) time.Time {
+	t := pair.Remote.LastReceived()
+	if tt := pair.LastResponseReceivedAt(); tt.After(t) {
+		t = tt
+	}
+	return t
+}
+
+// bindingRequest is called by the ICE agent whenever it receives a binding
+// request.  It returns true if the agent should switch to the pair on
+// which the request was received.
+func (r *roamingState) bindingRequest(m *stun.Message, pair *ice.CandidatePair, now time.Time) bool {
+	var username stun.Username
+	err := username.GetFrom(m)
+	if err != nil {
+		return false
+	}
+	key := username.String()
+	if key == "" || !strings.Contains(key, ":") {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.expired) > roamingExpiry {
+		for k, s := range r.sessions {
+			if now.Sub(s.time) > roamingExpiry {
+				delete(r.sessions, k)
+			}
+		}
+		r.expired = now
+	}
+
+	if r.sessions == nil {
+		r.sessions = make(map[string]*roamingSession)
+	}
+	s := r.sessions[key]
+	if s == nil {
+		r.sessions[key] = &roamingSession{current: pair, time: now}
+		return false
+	}
+	s.time = now
+
+	if s.current == pair {
+		return false
+	}
+	if now.Sub(pairLastReceived(s.current)) < roamingTimeout {
+		// the current pair is still alive, this is just a check
+		return false
+	}
+	s.current = pair
+	return true
+}
+
+func roamingHandler(m *stun.Message, local, remote ice.Candidate, pair *ice.CandidatePair) bool {
+	return roaming.bindingRequest(m, pair, time.Now())
+}
@@ -0,0 +1,66 @@
+package group
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/stun/v3"
+)
+
+func TestRoaming(t *testing.T) {
+	candidate := func(address string) ice.Candidate {
+		c, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+			Network:   "udp",
+			Address:   address,
+			Port:      1234,
+			Component: 1,
+		})
+		if err != nil {
+			t.Fatalf("NewCandidateHost: %v", err)
+		}
+		return c
+	}
+	message := func(username string) *stun.Message {
+		m, err := stun.Build(
+			stun.BindingRequest, stun.NewUsername(username),
+		)
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		return m
+	}
+
+	local := candidate("192.0.2.1")
+	pair1 := &ice.CandidatePair{Local: local, Remote: candidate("192.0.2.2")}
+	pair2 := &ice.CandidatePair{Local: local, Remote: candidate("192.0.2.3")}
+
+	var r roamingState
+	now := time.Now()
+	m := message("abcd:efgh")
+
+	if r.bindingRequest(m, pair1, now) {
+		t.Errorf("Switched on first request")
+	}
+
+	pair1.UpdateRoundTripTime(time.Millisecond)
+	if r.bindingRequest(m, pair2, time.Now()) {
+		t.Errorf("Switched while current pair is alive")
+	}
+
+	if !r.bindingRequest(m, pair2, time.Now().Add(2*roamingTimeout)) {
+		t.Errorf("Didn't switch after current pair lost consent")
+	}
+	if r.bindingRequest(m, pair2, time.Now().Add(2*roamingTimeout)) {
+		t.Errorf("Switched twice")
+	}
+
+	if r.bindingRequest(message("ijkl:mnop"), pair1, now) {
+		t.Errorf("Switched on first request of a new session")
+	}
+
+	r.bindingRequest(m, pair2, now.Add(2*roamingExpiry))
+	if len(r.sessions) != 1 {
+		t.Errorf("Expected 1 session, got %v", len(r.sessions))
+	}
+}
@@ -0,0 +1,119 @@
+package group
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// The default intervals between the periodic RTCP reports.
+const (
+	DefaultUpRTCPInterval   = time.Second
+	DefaultDownRTCPInterval = time.Second / 2
+)
+
+// When the bandwidth share would require a longer interval, we never
+// go above this, since receiver reports carry congestion control
+// feedback.
+const maxRTCPInterval = 5 * time.Second
+
+// An RTCPPolicy controls the periodic RTCP reports sent by the server,
+// which can amount to significant overhead in large groups.
+type RTCPPolicy struct {
+	// The interval, in milliseconds, between the receiver reports
+	// sent on up connections.
+	Up int `json:"up,omitempty"`
+	// The interval, in milliseconds, between the sender reports sent
+	// on down connections.
+	Down int `json:"down,omitempty"`
+	// The maximum share of the media bandwidth, in percent, used by
+	// periodic reports.  If reports would use more, the interval is
+	// increased.  No limit if zero.
+	Bandwidth float64 `json:"bandwidth,omitempty"`
+}
+
+// Check returns an error if the policy is invalid.
+func (p *RTCPPolicy) Check() error {
+	if p == nil {
+		return nil
+	}
+	if p.Up < 0 || p.Down < 0 {
+		return errors.New("negative RTCP interval")
+	}
+	max := int(maxRTCPInterval / time.Millisecond)
+	if p.Up > max || p.Down > max {
+		return errors.New("RTCP interval too large")
+	}
+	if p.Bandwidth < 0 || p.Bandwidth > 100 {
+		return errors.New("RTCP bandwidth out of range")
+	}
+	return nil
+}
+
+// Interval returns the interval between reports of size bytes sent on
+// a connection carrying rate bits per second.  If up is true, it applies
+// to receiver reports on up connections, otherwise to sender reports
+// on down connections.  The policy may be nil.
+func (p *RTCPPolicy) Interval(up bool, size int, rate uint64) time.Duration {
+	interval := DefaultDownRTCPInterval
+	if up {
+		interval = DefaultUpRTCPInterval
+	}
+	if p == nil {
+		return interval
+	}
+	if up && p.Up > 0 {
+		interval = time.Duration(p.Up) * time.Millisecond
+	} else if !up && p.Down > 0 {
+		interval = time.Duration(p.Down) * time.Millisecond
+	}
+
+	if p.Bandwidth <= 0 || size <= 0 || rate == 0 {
+		return interval
+	}
+	// the interval at which reports use exactly the allotted share
+	d := time.Duration(
+		float64(size*8) * 100 / (p.Bandwidth * float64(rate)) *
+			float64(time.Second),
+	)
+	return max(interval, min(d, maxRTCPInterval))
+}
+
+// The policy of the global configuration is consulted whenever a report
+// is sent, so we cache it rather than calling GetConfiguration.  It is
+// updated whenever the configuration is reloaded.
+type cachedRTCPPolicy struct {
+	policy *RTCPPolicy
+}
+
+var globalRTCP atomic.Pointer[cachedRTCPPolicy]
+
+// setGlobalRTCPPolicy is called whenever the configuration is loaded.
+func setGlobalRTCPPolicy(p *RTCPPolicy) {
+	globalRTCP.Store(&cachedRTCPPolicy{policy: p})
+}
+
+// globalRTCPPolicy returns the policy of the global configuration.
+func globalRTCPPolicy() *RTCPPolicy {
+	if c := globalRTCP.Load(); c != nil {
+		return c.policy
+	}
+	// the configuration has never been loaded
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config.json: %v", err)
+		return nil
+	}
+	return conf.RTCP
+}
+
+// RTCPPolicy returns the policy that applies to the group, which is the
+// global policy unless the group defines its own.  It may return nil.
+func (g *Group) RTCPPolicy() *RTCPPolicy {
+	desc := g.Description()
+	if desc.RTCP != nil {
+		return desc.RTCP
+	}
+	return globalRTCPPolicy()
+}
@@ -0,0 +1,57 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTCPInterval(t *testing.T) {
+	var p *RTCPPolicy
+	if p.Interval(true, 100, 1000000) != DefaultUpRTCPInterval ||
+		p.Interval(false, 100, 1000000) != DefaultDownRTCPInterval {
+		t.Errorf("Bad default intervals")
+	}
+
+	p = &RTCPPolicy{Up: 2000, Down: 250}
+	if p.Interval(true, 100, 1000) != 2*time.Second ||
+		p.Interval(false, 100, 1000) != 250*time.Millisecond {
+		t.Errorf("Configured intervals not honoured")
+	}
+
+	p = &RTCPPolicy{Bandwidth: 5}
+	// 100 bytes every second is 800 bit/s, 5% of 16 kbit/s
+	i := p.Interval(true, 100, 16000)
+	if i != time.Second {
+		t.Errorf("Expected 1s, got %v", i)
+	}
+	i = p.Interval(true, 100, 8000)
+	if i != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", i)
+	}
+	i = p.Interval(true, 100, 100)
+	if i != maxRTCPInterval {
+		t.Errorf("Expected %v, got %v", maxRTCPInterval, i)
+	}
+	i = p.Interval(false, 100, 1000000)
+	if i != DefaultDownRTCPInterval {
+		t.Errorf("Expected %v, got %v", DefaultDownRTCPInterval, i)
+	}
+}
+
+func TestRTCPCheck(t *testing.T) {
+	good := []RTCPPolicy{{}, {Up: 1000, Down: 1000, Bandwidth: 5}}
+	for _, p := range good {
+		if err := p.Check(); err != nil {
+			t.Errorf("Check %v: %v", p, err)
+		}
+	}
+	bad := []RTCPPolicy{
+		{Up: -1}, {Down: -1}, {Bandwidth: 101},
+		{Up: 5001}, {Down: 60000},
+	}
+	for _, p := range bad {
+		if err := p.Check(); err == nil {
+			t.Errorf("Check %v succeeded", p)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// An RTSPSource is an RTSP stream, typically the stream of an IP camera,
+// that the server pulls and publishes in the group.  Any credentials are
+// included in the URL.
+type RTSPSource struct {
+	// The URL of the stream, with scheme rtsp or rtsps.
+	URL string `json:"url"`
+	// The username under which the stream appears in the group.
+	Username string `json:"username,omitempty"`
+}
+
+// CheckRTSPURL returns an error if u is not a valid RTSP URL.
+func CheckRTSPURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme != "rtsp" && scheme != "rtsps" {
+		return errors.New("RTSP URL must have scheme rtsp or rtsps")
+	}
+	if parsed.Host == "" {
+		return errors.New("RTSP URL has no host")
+	}
+	return nil
+}
+
+func checkRTSPSources(sources []RTSPSource) error {
+	for i, s := range sources {
+		err := CheckRTSPURL(s.URL)
+		if err != nil {
+			return fmt.Errorf("rtsp-sources[%v]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// redactRTSPURL returns u with the password replaced by "xxxxx".
+func redactRTSPURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
+
+// hideRTSPCredentials returns a copy of sources with the passwords
+// redacted, which are not returned by the administrative API.
+func hideRTSPCredentials(sources []RTSPSource) []RTSPSource {
+	if sources == nil {
+		return nil
+	}
+	result := make([]RTSPSource, len(sources))
+	for i, s := range sources {
+		s.URL = redactRTSPURL(s.URL)
+		result[i] = s
+	}
+	return result
+}
+
+// keepRTSPCredentials replaces the redacted URLs in sources with the
+// corresponding URLs in old.
+func keepRTSPCredentials(sources, old []RTSPSource) []RTSPSource {
+	if sources == nil {
+		return nil
+	}
+	result := make([]RTSPSource, len(sources))
+	for i, s := range sources {
+		for _, o := range old {
+			if redactRTSPURL(o.URL) == s.URL {
+				s.URL = o.URL
+				break
+			}
+		}
+		result[i] = s
+	}
+	return result
+}
@@ -0,0 +1,157 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A ScheduleWindow is a weekly interval during which a group is open.
+type ScheduleWindow struct {
+	// The days of the week, as three-letter English abbreviations.
+	// Every day if empty.
+	Days []string `json:"days,omitempty"`
+	// The opening and closing times, in the form "HH:MM".  If close
+	// is earlier than open, the window extends past midnight.
+	Open  string `json:"open"`
+	Close string `json:"close"`
+
+	days        [7]bool
+	open, close time.Duration
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	n, err := fmt.Sscanf(s, "%d:%d", &h, &m)
+	if err != nil || n != 2 || h < 0 || h > 24 || m < 0 || m > 59 ||
+		(h == 24 && m != 0) {
+		return 0, fmt.Errorf("bad time of day %v", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func (w *ScheduleWindow) UnmarshalJSON(b []byte) error {
+	type window ScheduleWindow
+	var ww window
+	err := json.Unmarshal(b, &ww)
+	if err != nil {
+		return err
+	}
+	*w = ScheduleWindow(ww)
+
+	if len(w.Days) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, d := range w.Days {
+		found := false
+		for i, wd := range weekdays {
+			if strings.EqualFold(d, wd) {
+				w.days[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("bad day of the week %v", d)
+		}
+	}
+
+	w.open, err = parseTimeOfDay(w.Open)
+	if err != nil {
+		return err
+	}
+	w.close, err = parseTimeOfDay(w.Close)
+	if err != nil {
+		return err
+	}
+	if w.open == w.close {
+		return errors.New("empty schedule window")
+	}
+	return nil
+}
+
+// contains returns true if the window contains the given time, which
+// must be expressed in the schedule's time zone.
+func (w *ScheduleWindow) contains(t time.Time) bool {
+	// the wall clock time, which is not the time elapsed since
+	// midnight on days with a DST transition
+	tod := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute
+	day := int(t.Weekday())
+	if w.open < w.close {
+		return w.days[day] && tod >= w.open && tod < w.close
+	}
+	// the window extends past midnight
+	if w.days[day] && tod >= w.open {
+		return true
+	}
+	return w.days[(day+6)%7] && tod < w.close
+}
+
+func scheduleLocation(desc *Description) (*time.Location, error) {
+	if desc.ScheduleTimezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(desc.ScheduleTimezone)
+}
+
+// checkSchedule returns an error if the schedule of a group description
+// is invalid.  It caches the schedule's time zone in the description.
+func checkSchedule(desc *Description) error {
+	loc, err := scheduleLocation(desc)
+	if err != nil {
+		return err
+	}
+	desc.scheduleLocation = loc
+	return nil
+}
+
+// scheduleOpen returns true if the schedule of the given description
+// allows joining at the given time.
+func scheduleOpen(desc *Description, now time.Time) bool {
+	if len(desc.Schedule) == 0 {
+		return true
+	}
+	loc := desc.scheduleLocation
+	if loc == nil {
+		// checkSchedule has not been called
+		var err error
+		loc, err = scheduleLocation(desc)
+		if err != nil {
+			loc = time.Local
+		}
+	}
+	t := now.In(loc)
+	for i := range desc.Schedule {
+		if desc.Schedule[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSchedules kicks out non-operators from groups that have been
+// closed by their schedule, if requested by the group description.
+func CheckSchedules() {
+	now := time.Now()
+	Range(func(g *Group) bool {
+		desc := g.Description()
+		if !desc.ScheduleKick || scheduleOpen(desc, now) {
+			return true
+		}
+		for _, c := range g.GetClients(nil) {
+			perms := c.Permissions()
+			if member("op", perms) || member("system", perms) {
+				continue
+			}
+			c.Kick("", nil, "this group is closed")
+		}
+		return true
+	})
+}
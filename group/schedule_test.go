@@ -0,0 +1,108 @@
+package group
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	var desc Description
+	err := json.Unmarshal([]byte(`{
+            "schedule": [
+                {"days": ["mon", "Wed"], "open": "08:00", "close": "12:30"},
+                {"days": ["fri"], "open": "22:00", "close": "02:00"}
+            ],
+            "schedule-timezone": "UTC"
+        }`), &desc)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	err = checkSchedule(&desc)
+	if err != nil {
+		t.Fatalf("checkSchedule: %v", err)
+	}
+
+	type test struct {
+		time string
+		open bool
+	}
+	tests := []test{
+		{"2025-09-01T07:59:00Z", false}, // Monday
+		{"2025-09-01T08:00:00Z", true},
+		{"2025-09-01T12:29:00Z", true},
+		{"2025-09-01T12:30:00Z", false},
+		{"2025-09-02T10:00:00Z", false}, // Tuesday
+		{"2025-09-03T10:00:00+02:00", true},
+		{"2025-09-05T23:00:00Z", true}, // Friday
+		{"2025-09-06T01:59:00Z", true},
+		{"2025-09-06T02:00:00Z", false},
+		{"2025-09-06T23:00:00Z", false},
+	}
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.time)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		open := scheduleOpen(&desc, now)
+		if open != tt.open {
+			t.Errorf("%v: expected %v, got %v", tt.time, tt.open, open)
+		}
+	}
+
+	if !scheduleOpen(&Description{}, time.Now()) {
+		t.Errorf("Empty schedule is closed")
+	}
+
+	bad := []string{
+		`{"schedule": [{"days": ["sunday"], "open": "08:00", "close": "09:00"}]}`,
+		`{"schedule": [{"open": "25:00", "close": "09:00"}]}`,
+		`{"schedule": [{"open": "08:00", "close": "08:00"}]}`,
+		`{"schedule": [{"open": "08:00"}]}`,
+	}
+	for _, b := range bad {
+		var d Description
+		err := json.Unmarshal([]byte(b), &d)
+		if err == nil {
+			t.Errorf("%v: no error", b)
+		}
+	}
+
+	d := Description{ScheduleTimezone: "Not/A_Zone"}
+	if checkSchedule(&d) == nil {
+		t.Errorf("Bad time zone accepted")
+	}
+
+	// on the day DST starts, 12:30 local time is only 11h30 after
+	// midnight
+	var paris Description
+	err = json.Unmarshal([]byte(`{
+            "schedule": [
+                {"open": "12:00", "close": "13:00"}
+            ],
+            "schedule-timezone": "Europe/Paris"
+        }`), &paris)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	err = checkSchedule(&paris)
+	if err != nil {
+		t.Skipf("checkSchedule: %v", err)
+	}
+	dst := []test{
+		{"2025-03-30T10:30:00Z", true}, // 12:30 CEST
+		{"2025-03-30T09:59:00Z", false},
+		{"2025-10-26T11:59:00Z", true}, // 12:59 CET
+		{"2025-10-26T12:00:00Z", false},
+	}
+	for _, tt := range dst {
+		now, err := time.Parse(time.RFC3339, tt.time)
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		open := scheduleOpen(&paris, now)
+		if open != tt.open {
+			t.Errorf("%v: expected %v, got %v", tt.time, tt.open, open)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package group
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session cookies allow a user who has logged into a group with a
+// password to join it again for a short time without providing the
+// password, for example after reloading the page or a brief network
+// outage.  A session is a signed value that the client stores in an
+// HttpOnly cookie, which is sent along with the WebSocket handshake.
+// Sessions are signed with a key that is generated when the server
+// starts, so restarting the server invalidates all sessions.
+//
+// A session only records the username, and the user's permissions are
+// computed anew whenever the session is used, so that changes to the
+// user's entry take effect immediately.  Since the permissions of users
+// authenticated by LDAP cannot be computed without their password,
+// sessions are not accepted for such users.
+
+// SessionLifetime is the time during which a session remains valid.
+const SessionLifetime = 4 * time.Hour
+
+var ErrBadSession = errors.New("bad session")
+
+type session struct {
+	Group    string    `json:"group"`
+	Username string    `json:"username"`
+	IssuedAt time.Time `json:"iat"`
+	Expires  time.Time `json:"exp"`
+}
+
+type sessionUser struct {
+	group, username string
+}
+
+var sessions struct {
+	mu  sync.Mutex
+	key []byte
+	// sessions issued no later than the given time are revoked
+	revoked       map[sessionUser]time.Time
+	revokedGroups map[string]time.Time
+	revokedUsers  map[string]time.Time
+}
+
+// called locked
+func sessionKey() []byte {
+	if sessions.key == nil {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			panic(err)
+		}
+		sessions.key = key
+	}
+	return sessions.key
+}
+
+func sessionMAC(payload string) []byte {
+	sessions.mu.Lock()
+	key := sessionKey()
+	sessions.mu.Unlock()
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// SessionCookiePrefix is the common prefix of the names of session
+// cookies.
+const SessionCookiePrefix = "galene-session-"
+
+// SessionCookieName returns the name of the cookie that holds the
+// session for a given group.
+func SessionCookieName(group string) string {
+	h := sha256.Sum256([]byte(group))
+	return SessionCookiePrefix + hex.EncodeToString(h[:8])
+}
+
+// newSession returns a new session value.
+func newSession(group, username string, now time.Time) (string, time.Time, error) {
+	s := session{
+		Group:    group,
+		Username: username,
+		IssuedAt: now,
+		Expires:  now.Add(SessionLifetime),
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := base64.RawURLEncoding.EncodeToString(sessionMAC(payload))
+	return payload + "." + mac, s.Expires, nil
+}
+
+// parseSession checks the signature and the validity of a session.
+func parseSession(value string, now time.Time) (*session, error) {
+	payload, mac, found := strings.Cut(value, ".")
+	if !found {
+		return nil, ErrBadSession
+	}
+	m, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(m, sessionMAC(payload)) {
+		return nil, ErrBadSession
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrBadSession
+	}
+	var s session
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, ErrBadSession
+	}
+	if !now.Before(s.Expires) {
+		return nil, ErrBadSession
+	}
+
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	for _, revoked := range []time.Time{
+		sessions.revoked[sessionUser{s.Group, s.Username}],
+		sessions.revokedGroups[s.Group],
+		sessions.revokedUsers[s.Username],
+	} {
+		if !revoked.IsZero() && !s.IssuedAt.After(revoked) {
+			return nil, ErrBadSession
+		}
+	}
+	return &s, nil
+}
+
+// NewSession returns a session for a user who has just logged into
+// group g, together with its expiry time.  It returns an empty string
+// if the group doesn't allow sessions, or if the session couldn't be
+// used by this user.
+func NewSession(g *Group, username string) (string, time.Time, error) {
+	g.mu.Lock()
+	_, ok := g.sessionUserPermission(username)
+	ok = ok && !g.description.NoSessionCookies
+	g.mu.Unlock()
+	if !ok {
+		return "", time.Time{}, nil
+	}
+	return newSession(g.Name(), username, time.Now())
+}
+
+// CheckSession returns an error if value is not a valid session for
+// the given group.
+func CheckSession(group, value string) (time.Time, error) {
+	s, err := parseSession(value, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if s.Group != group {
+		return time.Time{}, ErrBadSession
+	}
+	return s.Expires, nil
+}
+
+// revoke records in m that the sessions for key issued until now are
+// revoked, and forgets the revocations that no longer matter.
+// called locked
+func revoke[K comparable](m map[K]time.Time, key K) map[K]time.Time {
+	now := time.Now()
+	if m == nil {
+		m = make(map[K]time.Time)
+	}
+	for k, v := range m {
+		if now.Sub(v) > SessionLifetime {
+			delete(m, k)
+		}
+	}
+	m[key] = now
+	return m
+}
+
+// RevokeSessions invalidates all the sessions of a user in a group that
+// were issued until now.
+func RevokeSessions(group, username string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	sessions.revoked = revoke(
+		sessions.revoked, sessionUser{group, username},
+	)
+}
+
+// RevokeGroupSessions invalidates all the sessions of all users in
+// a group that were issued until now.
+func RevokeGroupSessions(group string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	sessions.revokedGroups = revoke(sessions.revokedGroups, group)
+}
+
+// RevokeUserSessions invalidates all the sessions of a user in all
+// groups that were issued until now.
+func RevokeUserSessions(username string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	sessions.revokedUsers = revoke(sessions.revokedUsers, username)
+}
+
+// sessionUserPermission returns the permissions of a user who
+// authenticated with a session.
+// called locked
+func (g *Group) sessionUserPermission(username string) (Permissions, bool) {
+	desc := g.description
+	if c, found := desc.Users[username]; found {
+		return c.Permissions, true
+	}
+	if ref, found := desc.GlobalUsers[username]; found {
+		u, err := getGlobalUser(username)
+		if err == nil {
+			if ref.Permissions != nil {
+				return *ref.Permissions, true
+			}
+			return u.Permissions, true
+		}
+	}
+	if len(desc.LDAPUsers) > 0 {
+		// we don't know whether this user was authenticated by LDAP
+		return Permissions{}, false
+	}
+	if desc.WildcardUser != nil {
+		return desc.WildcardUser.Permissions, true
+	}
+	return Permissions{}, false
+}
+
+// called locked
+func (g *Group) getSessionPermission(values []string, username string) ([]string, bool) {
+	if g.description.NoSessionCookies {
+		return nil, false
+	}
+	now := time.Now()
+	for _, v := range values {
+		s, err := parseSession(v, now)
+		if err != nil {
+			continue
+		}
+		if s.Group == g.name && s.Username == username {
+			p, ok := g.sessionUserPermission(username)
+			if !ok {
+				return nil, false
+			}
+			return p.Permissions(g.description), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,147 @@
+package group
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	now := time.Now()
+	s, expires, err := newSession("test", "jch", now)
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+	if !expires.Equal(now.Add(SessionLifetime)) {
+		t.Errorf("Expected %v, got %v", now.Add(SessionLifetime), expires)
+	}
+
+	session, err := parseSession(s, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("parseSession: %v", err)
+	}
+	if session.Group != "test" || session.Username != "jch" {
+		t.Errorf("Bad session %v", session)
+	}
+
+	_, err = parseSession(s, now.Add(SessionLifetime))
+	if !errors.Is(err, ErrBadSession) {
+		t.Errorf("Expired session: got %v", err)
+	}
+
+	payload, mac, _ := strings.Cut(s, ".")
+	bad := []string{
+		"",
+		payload,
+		payload + ".",
+		payload + "x." + mac,
+		strings.ToUpper(payload) + "." + mac,
+		payload + "." + strings.ToUpper(mac),
+	}
+	for _, b := range bad {
+		_, err := parseSession(b, now)
+		if !errors.Is(err, ErrBadSession) {
+			t.Errorf("parseSession %v: got %v", b, err)
+		}
+	}
+}
+
+func TestSessionPermission(t *testing.T) {
+	op, _ := NewPermissions("op")
+	g := &Group{name: "test", description: &Description{
+		Users: map[string]UserDescription{"jch": {Permissions: op}},
+	}}
+	now := time.Now()
+	s1, _, _ := newSession("test", "jch", now)
+	s2, _, _ := newSession("other", "john", now)
+
+	perms, ok := g.getSessionPermission([]string{s2, s1}, "jch")
+	if !ok || !slices.Contains(perms, "op") {
+		t.Errorf("Expected op, got %v %v", perms, ok)
+	}
+
+	_, ok = g.getSessionPermission([]string{s2, s1}, "john")
+	if ok {
+		t.Errorf("Session accepted for the wrong group")
+	}
+
+	g.description.NoSessionCookies = true
+	_, ok = g.getSessionPermission([]string{s1}, "jch")
+	if ok {
+		t.Errorf("Session accepted despite no-session-cookies")
+	}
+	g.description.NoSessionCookies = false
+
+	username := "jch"
+	creds := ClientCredentials{Username: &username, Sessions: []string{s1}}
+	u, p, err := g.GetPermission(creds)
+	if err != nil || u != "jch" || !slices.Contains(p, "op") {
+		t.Errorf("GetPermission: %v %v %v", u, p, err)
+	}
+
+	creds.Password = "wrong"
+	_, _, err = g.GetPermission(creds)
+	if err == nil {
+		t.Errorf("Session used despite a password being given")
+	}
+
+	RevokeSessions("test", "jch")
+	_, ok = g.getSessionPermission([]string{s1}, "jch")
+	if ok {
+		t.Errorf("Revoked session accepted")
+	}
+
+	s3, _, _ := newSession("test", "jch", time.Now().Add(time.Millisecond))
+	_, ok = g.getSessionPermission([]string{s3}, "jch")
+	if !ok {
+		t.Errorf("New session not accepted after revocation")
+	}
+
+	present, _ := NewPermissions("present")
+	g.description.Users["jch"] = UserDescription{Permissions: present}
+	perms, ok = g.getSessionPermission([]string{s3}, "jch")
+	if !ok || slices.Contains(perms, "op") {
+		t.Errorf("Permissions not recomputed: %v %v", perms, ok)
+	}
+
+	delete(g.description.Users, "jch")
+	_, ok = g.getSessionPermission([]string{s3}, "jch")
+	if ok {
+		t.Errorf("Session accepted for a deleted user")
+	}
+
+	g.description.WildcardUser = &UserDescription{Permissions: present}
+	_, ok = g.getSessionPermission([]string{s3}, "jch")
+	if !ok {
+		t.Errorf("Session not accepted for the wildcard user")
+	}
+
+	g.description.LDAPUsers = []LDAPRule{{Permissions: present}}
+	_, ok = g.getSessionPermission([]string{s3}, "jch")
+	if ok {
+		t.Errorf("Session accepted for a possible LDAP user")
+	}
+}
+
+func TestSessionRevocation(t *testing.T) {
+	s1, _, _ := newSession("revoke-group", "jch", time.Now())
+	s2, _, _ := newSession("revoke-user", "john", time.Now())
+	s3, _, _ := newSession("revoke-user", "jch", time.Now())
+
+	RevokeGroupSessions("revoke-group")
+	RevokeUserSessions("john")
+	_, err := parseSession(s1, time.Now())
+	if err == nil {
+		t.Errorf("Session accepted after revoking the group")
+	}
+	_, err = parseSession(s2, time.Now())
+	if err == nil {
+		t.Errorf("Session accepted after revoking the user")
+	}
+	_, err = parseSession(s3, time.Now())
+	if err != nil {
+		t.Errorf("Unrelated session revoked: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Group definitions are kept in a Store.  By default, they are stored as
+// JSON files in the groups directory, but they may be stored in an SQL
+// database or in etcd, which allows multiple servers to share them, as
+// configured by the "storage" field of config.json.
+
+// StoreConfig describes where group definitions are stored.
+type StoreConfig struct {
+	// One of "files" (the default), "sql" or "etcd".
+	Type string `json:"type"`
+
+	// For the sql store, the name of the database driver and the
+	// driver-specific data source name.
+	Driver     string `json:"driver,omitempty"`
+	DataSource string `json:"dataSource,omitempty"`
+
+	// For the etcd store, the base URLs of the etcd servers, a prefix
+	// prepended to all keys, and optional credentials.
+	Endpoints []string `json:"endpoints,omitempty"`
+	Prefix    string   `json:"prefix,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Password  string   `json:"password,omitempty"`
+}
+
+// A Store holds group definitions, indexed by group name.  Every
+// definition has an opaque version, which changes whenever the
+// definition is modified.
+type Store interface {
+	// Get returns the contents of a definition and its version.  It
+	// returns an error wrapping os.ErrNotExist if the definition
+	// doesn't exist.
+	Get(name string) ([]byte, string, error)
+	// Version returns the version of a definition.
+	Version(name string) (string, error)
+	// Put writes a definition.  It fails with ErrTagMismatch unless
+	// version is the current version of the definition, or the empty
+	// string if the definition doesn't exist.
+	Put(name string, data []byte, version string) error
+	// Delete deletes a definition.  It fails with ErrTagMismatch
+	// unless version is the current version of the definition.
+	Delete(name string, version string) error
+	// List returns the names of the definitions below dir, relative
+	// to dir.  Names with a component starting with a dot are omitted.
+	List(dir string) ([]string, error)
+}
+
+var store struct {
+	mu     sync.Mutex
+	config *StoreConfig
+	store  Store
+}
+
+// getStore returns the store configured in config.json.
+func getStore() (Store, error) {
+	conf, err := GetConfiguration()
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.store != nil && reflect.DeepEqual(store.config, conf.Storage) {
+		return store.store, nil
+	}
+
+	s, err := newStore(conf.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := store.store.(io.Closer); ok {
+		c.Close()
+	}
+	store.config = conf.Storage
+	store.store = s
+	return s, nil
+}
+
+func newStore(conf *StoreConfig) (Store, error) {
+	if conf == nil {
+		return fileStore{}, nil
+	}
+	switch conf.Type {
+	case "", "files":
+		return fileStore{}, nil
+	case "sql":
+		return newSQLStore(conf)
+	case "etcd":
+		return newEtcdStore(conf)
+	default:
+		return nil, fmt.Errorf("unknown storage type %v", conf.Type)
+	}
+}
+
+// hiddenName returns true if a component of name starts with a dot.
+func hiddenName(name string) bool {
+	for _, c := range strings.Split(name, "/") {
+		if c == "" || c[0] == '.' {
+			return true
+		}
+	}
+	return false
+}
+
+// fileStore stores definitions as files in the groups directory.
+type fileStore struct{}
+
+func (fileStore) filename(name string) string {
+	return filepath.Join(Directory, path.Clean("/"+name)+".json")
+}
+
+func fileVersion(fi os.FileInfo) string {
+	return fmt.Sprintf("%v-%v", fi.Size(), fi.ModTime().UnixNano())
+}
+
+func (s fileStore) Get(name string) ([]byte, string, error) {
+	f, err := os.Open(s.filename(name))
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fileVersion(fi), nil
+}
+
+func (s fileStore) Version(name string) (string, error) {
+	fi, err := os.Stat(s.filename(name))
+	if err != nil {
+		return "", err
+	}
+	return fileVersion(fi), nil
+}
+
+func (s fileStore) Put(name string, data []byte, version string) error {
+	old, err := s.Version(name)
+	if errors.Is(err, os.ErrNotExist) {
+		old = ""
+	} else if err != nil {
+		return err
+	}
+	if old != version {
+		return ErrTagMismatch
+	}
+
+	filename := s.filename(name)
+	dir := filepath.Dir(filename)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "*.temp")
+	if err != nil {
+		return err
+	}
+	temp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(temp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	err = os.Rename(temp, filename)
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+func (s fileStore) Delete(name string, version string) error {
+	old, err := s.Version(name)
+	if err != nil {
+		return err
+	}
+	if old != version {
+		return ErrTagMismatch
+	}
+	return os.Remove(s.filename(name))
+}
+
+func (s fileStore) List(dir string) ([]string, error) {
+	names, err := descriptionNames(filepath.Join(Directory, dir))
+	if err != nil {
+		return nil, err
+	}
+	for i := range names {
+		names[i] = filepath.ToSlash(names[i])
+	}
+	return names, nil
+}
+
+// listPrefixed implements List for stores that keep flat keys.
+func listPrefixed(keys []string, dir string) []string {
+	var names []string
+	prefix := ""
+	if dir != "" {
+		prefix = strings.TrimSuffix(dir, "/") + "/"
+	}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		name := k[len(prefix):]
+		if hiddenName(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,281 @@
+package group
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// etcdStore stores definitions in etcd, using the JSON gateway of the v3
+// API, under keys of the form prefix + name.  The version of a definition
+// is the revision at which its key was last modified.
+
+type etcdStore struct {
+	endpoints []string
+	prefix    string
+	username  string
+	password  string
+	client    *http.Client
+
+	mu    sync.Mutex
+	token string
+}
+
+func newEtcdStore(conf *StoreConfig) (*etcdStore, error) {
+	if len(conf.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints configured")
+	}
+	prefix := conf.Prefix
+	if prefix == "" {
+		prefix = "/galene/groups/"
+	}
+	return &etcdStore{
+		endpoints: conf.Endpoints,
+		prefix:    prefix,
+		username:  conf.Username,
+		password:  conf.Password,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type etcdKV struct {
+	Key         []byte `json:"key"`
+	Value       []byte `json:"value"`
+	ModRevision string `json:"mod_revision"`
+}
+
+type etcdError struct {
+	status  int
+	message string
+}
+
+func (e etcdError) Error() string {
+	if e.message != "" {
+		return "etcd: " + e.message
+	}
+	return "etcd: " + http.StatusText(e.status)
+}
+
+func (s *etcdStore) post(endpoint, path, token string, req, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest("POST",
+		strings.TrimRight(endpoint, "/")+path, bytes.NewReader(body),
+	)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		r.Header.Set("Authorization", token)
+	}
+	rr, err := s.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer rr.Body.Close()
+	if rr.StatusCode != http.StatusOK {
+		var e struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(rr.Body).Decode(&e)
+		return etcdError{rr.StatusCode, e.Message}
+	}
+	return json.NewDecoder(rr.Body).Decode(resp)
+}
+
+// call performs a request, trying every endpoint in turn, and
+// authenticating if necessary.
+func (s *etcdStore) call(path string, req, resp any) error {
+	var err error
+	for _, endpoint := range s.endpoints {
+		var token string
+		token, err = s.getToken(endpoint, false)
+		if err != nil {
+			continue
+		}
+		err = s.post(endpoint, path, token, req, resp)
+		var e etcdError
+		if errors.As(err, &e) && e.status == http.StatusUnauthorized &&
+			s.username != "" {
+			// the token has probably expired
+			token, err = s.getToken(endpoint, true)
+			if err != nil {
+				continue
+			}
+			err = s.post(endpoint, path, token, req, resp)
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.As(err, &e) && e.status < 500 {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *etcdStore) getToken(endpoint string, refresh bool) (string, error) {
+	if s.username == "" {
+		return "", nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && !refresh {
+		return s.token, nil
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := s.post(endpoint, "/v3/auth/authenticate", "",
+		map[string]string{
+			"name":     s.username,
+			"password": s.password,
+		}, &resp,
+	)
+	if err != nil {
+		return "", err
+	}
+	s.token = resp.Token
+	return s.token, nil
+}
+
+func (s *etcdStore) key(name string) []byte {
+	return []byte(s.prefix + name)
+}
+
+func (s *etcdStore) get(name string, keysOnly bool) (*etcdKV, error) {
+	var resp struct {
+		Kvs []etcdKV `json:"kvs"`
+	}
+	err := s.call("/v3/kv/range", map[string]any{
+		"key":       s.key(name),
+		"keys_only": keysOnly,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &resp.Kvs[0], nil
+}
+
+func (s *etcdStore) Get(name string) ([]byte, string, error) {
+	kv, err := s.get(name, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return kv.Value, kv.ModRevision, nil
+}
+
+func (s *etcdStore) Version(name string) (string, error) {
+	kv, err := s.get(name, true)
+	if err != nil {
+		return "", err
+	}
+	return kv.ModRevision, nil
+}
+
+// txn performs an operation on a key if its version matches.
+func (s *etcdStore) txn(name string, version string, op map[string]any) error {
+	var compare map[string]any
+	if version == "" {
+		compare = map[string]any{
+			"key":             s.key(name),
+			"target":          "CREATE",
+			"create_revision": "0",
+		}
+	} else {
+		_, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return ErrTagMismatch
+		}
+		compare = map[string]any{
+			"key":          s.key(name),
+			"target":       "MOD",
+			"mod_revision": version,
+		}
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := s.call("/v3/kv/txn", map[string]any{
+		"compare": []any{compare},
+		"success": []any{op},
+	}, &resp)
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrTagMismatch
+	}
+	return nil
+}
+
+func (s *etcdStore) Put(name string, data []byte, version string) error {
+	return s.txn(name, version, map[string]any{
+		"request_put": map[string]any{
+			"key":   s.key(name),
+			"value": data,
+		},
+	})
+}
+
+func (s *etcdStore) Delete(name string, version string) error {
+	err := s.txn(name, version, map[string]any{
+		"request_delete_range": map[string]any{
+			"key": s.key(name),
+		},
+	})
+	if errors.Is(err, ErrTagMismatch) {
+		_, err2 := s.Version(name)
+		if err2 != nil {
+			return err2
+		}
+	}
+	return err
+}
+
+// prefixEnd returns the smallest key that is larger than all keys
+// starting with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all keys
+	return []byte{0}
+}
+
+func (s *etcdStore) List(dir string) ([]string, error) {
+	prefix := []byte(s.prefix)
+	var resp struct {
+		Kvs []etcdKV `json:"kvs"`
+	}
+	err := s.call("/v3/kv/range", map[string]any{
+		"key":       prefix,
+		"range_end": prefixEnd(prefix),
+		"keys_only": true,
+	}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %w", err)
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, strings.TrimPrefix(string(kv.Key), s.prefix))
+	}
+	return listPrefixed(keys, dir), nil
+}
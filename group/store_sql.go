@@ -0,0 +1,188 @@
+package group
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sqlStore stores definitions in the table galene_groups of an SQL
+// database.  The driver must be registered with database/sql by a package
+// linked into the binary; building with the tag "postgres" links the
+// PostgreSQL driver "postgres".
+
+type sqlStore struct {
+	db *sql.DB
+	// whether the driver uses numbered placeholders
+	numbered bool
+}
+
+func newSQLStore(conf *StoreConfig) (*sqlStore, error) {
+	if conf.Driver == "" {
+		return nil, errors.New("no SQL driver configured")
+	}
+	db, err := sql.Open(conf.Driver, conf.DataSource)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlStore{
+		db: db,
+		numbered: conf.Driver == "postgres" ||
+			conf.Driver == "pgx",
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS galene_groups (
+		name VARCHAR(1024) PRIMARY KEY,
+		data TEXT NOT NULL,
+		version BIGINT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// query converts the placeholders in q to the syntax of the driver.
+func (s *sqlStore) query(q string) string {
+	if !s.numbered {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) Get(name string) ([]byte, string, error) {
+	var data string
+	var version int64
+	err := s.db.QueryRow(
+		s.query("SELECT data, version FROM galene_groups WHERE name = ?"),
+		name,
+	).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", os.ErrNotExist
+	} else if err != nil {
+		return nil, "", err
+	}
+	return []byte(data), strconv.FormatInt(version, 10), nil
+}
+
+func (s *sqlStore) Version(name string) (string, error) {
+	var version int64
+	err := s.db.QueryRow(
+		s.query("SELECT version FROM galene_groups WHERE name = ?"),
+		name,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", os.ErrNotExist
+	} else if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(version, 10), nil
+}
+
+func (s *sqlStore) Put(name string, data []byte, version string) error {
+	if version == "" {
+		_, err := s.Version(name)
+		if err == nil {
+			return ErrTagMismatch
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		_, err = s.db.Exec(s.query(
+			"INSERT INTO galene_groups (name, data, version) "+
+				"VALUES (?, ?, 1)",
+		), name, string(data))
+		if err != nil {
+			// most likely a concurrent insert
+			_, err2 := s.Version(name)
+			if err2 == nil {
+				return ErrTagMismatch
+			}
+			return err
+		}
+		return nil
+	}
+
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		return ErrTagMismatch
+	}
+	res, err := s.db.Exec(s.query(
+		"UPDATE galene_groups SET data = ?, version = ? "+
+			"WHERE name = ? AND version = ?",
+	), string(data), v+1, name, v)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTagMismatch
+	}
+	return nil
+}
+
+func (s *sqlStore) Delete(name string, version string) error {
+	v, err := strconv.ParseInt(version, 10, 64)
+	if err != nil {
+		v = -1
+	}
+	res, err := s.db.Exec(s.query(
+		"DELETE FROM galene_groups WHERE name = ? AND version = ?",
+	), name, v)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.Version(name)
+		if err != nil {
+			return err
+		}
+		return ErrTagMismatch
+	}
+	return nil
+}
+
+func (s *sqlStore) List(dir string) ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM galene_groups")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, name)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("list groups: %w", err)
+	}
+	return listPrefixed(keys, dir), nil
+}
@@ -0,0 +1,243 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func testStore(t *testing.T, s Store) {
+	_, _, err := s.Get("test")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get: expected ErrNotExist, got %v", err)
+	}
+
+	err = s.Put("test", []byte("one"), "")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	err = s.Put("test", []byte("two"), "")
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Put: expected ErrTagMismatch, got %v", err)
+	}
+
+	data, version, err := s.Get("test")
+	if err != nil || string(data) != "one" {
+		t.Fatalf("Get: %v %v", string(data), err)
+	}
+	v, err := s.Version("test")
+	if err != nil || v != version {
+		t.Errorf("Version: got %v %v, expected %v", v, err, version)
+	}
+
+	err = s.Put("test", []byte("three"), version)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	err = s.Put("test", []byte("four"), version)
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Put: expected ErrTagMismatch, got %v", err)
+	}
+	err = s.Delete("test", version)
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Delete: expected ErrTagMismatch, got %v", err)
+	}
+
+	err = s.Put("sub/test", []byte("five"), "")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	err = s.Put(".hidden/test", []byte("six"), "")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	names, err := s.List("")
+	sort.Strings(names)
+	if err != nil || !reflect.DeepEqual(names, []string{"sub/test", "test"}) {
+		t.Errorf("List: got %v %v", names, err)
+	}
+	names, err = s.List(".hidden")
+	if err != nil || !reflect.DeepEqual(names, []string{"test"}) {
+		t.Errorf("List: got %v %v", names, err)
+	}
+
+	data, version, err = s.Get("test")
+	if err != nil || string(data) != "three" {
+		t.Fatalf("Get: %v %v", string(data), err)
+	}
+	err = s.Delete("test", version)
+	if err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	_, err = s.Version("test")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Version: expected ErrNotExist, got %v", err)
+	}
+	err = s.Delete("test", version)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete: expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	Directory = t.TempDir()
+	testStore(t, fileStore{})
+}
+
+func TestListPrefixed(t *testing.T) {
+	keys := []string{
+		"a", "b/c", ".d/e", "f/.g", ".archive/h", ".archive/i/j",
+	}
+	tests := []struct {
+		dir    string
+		result []string
+	}{
+		{"", []string{"a", "b/c"}},
+		{"b", []string{"c"}},
+		{".archive", []string{"h", "i/j"}},
+		{".archive/", []string{"h", "i/j"}},
+		{"x", nil},
+	}
+	for _, test := range tests {
+		result := listPrefixed(keys, test.dir)
+		if !reflect.DeepEqual(result, test.result) {
+			t.Errorf("listPrefixed %#v: got %#v, expected %#v",
+				test.dir, result, test.result)
+		}
+	}
+}
+
+// fakeEtcd implements just enough of the etcd JSON gateway for etcdStore.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	revision int64
+	kvs      map[string]fakeKV
+}
+
+type fakeKV struct {
+	value       []byte
+	modRevision int64
+}
+
+type fakeCompare struct {
+	Key            []byte `json:"key"`
+	Target         string `json:"target"`
+	CreateRevision string `json:"create_revision"`
+	ModRevision    string `json:"mod_revision"`
+}
+
+func (e *fakeEtcd) compare(c fakeCompare) bool {
+	kv, ok := e.kvs[string(c.Key)]
+	switch c.Target {
+	case "CREATE":
+		return !ok && c.CreateRevision == "0"
+	case "MOD":
+		return ok &&
+			c.ModRevision == strconv.FormatInt(kv.modRevision, 10)
+	}
+	return false
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var resp any
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		var req struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		kvs := []etcdKV{}
+		for k, kv := range e.kvs {
+			if k == string(req.Key) || (req.RangeEnd != nil &&
+				k >= string(req.Key) && k < string(req.RangeEnd)) {
+				kvs = append(kvs, etcdKV{
+					Key:   []byte(k),
+					Value: kv.value,
+					ModRevision: strconv.FormatInt(
+						kv.modRevision, 10,
+					),
+				})
+			}
+		}
+		resp = map[string]any{"kvs": kvs}
+	case "/v3/kv/txn":
+		var req struct {
+			Compare []fakeCompare `json:"compare"`
+			Success []struct {
+				Put *struct {
+					Key   []byte `json:"key"`
+					Value []byte `json:"value"`
+				} `json:"request_put"`
+				Delete *struct {
+					Key []byte `json:"key"`
+				} `json:"request_delete_range"`
+			} `json:"success"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		ok := true
+		for _, c := range req.Compare {
+			ok = ok && e.compare(c)
+		}
+		if ok {
+			e.revision++
+			for _, op := range req.Success {
+				if op.Put != nil {
+					e.kvs[string(op.Put.Key)] = fakeKV{
+						op.Put.Value, e.revision,
+					}
+				}
+				if op.Delete != nil {
+					delete(e.kvs, string(op.Delete.Key))
+				}
+			}
+		}
+		resp = map[string]any{"succeeded": ok}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestEtcdStore(t *testing.T) {
+	server := httptest.NewServer(&fakeEtcd{kvs: make(map[string]fakeKV)})
+	defer server.Close()
+
+	s, err := newEtcdStore(&StoreConfig{
+		Type:      "etcd",
+		Endpoints: []string{server.URL},
+	})
+	if err != nil {
+		t.Fatalf("newEtcdStore: %v", err)
+	}
+	testStore(t, s)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix, end string
+	}{
+		{"/galene/", "/galene0"},
+		{"a\xff", "b"},
+		{"\xff\xff", "\x00"},
+	}
+	for _, test := range tests {
+		end := string(prefixEnd([]byte(test.prefix)))
+		if end != test.end {
+			t.Errorf("prefixEnd %q: got %q, expected %q",
+				test.prefix, end, test.end)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package group
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+// A TokenTemplate provides default values for the stateful tokens created
+// for a group, so that administrators don't need to specify the same
+// combination of permissions and expiry time every time.
+type TokenTemplate struct {
+	// The permissions granted by the token.
+	Permissions *Permissions `json:"permissions,omitempty"`
+	// The time, in seconds, for which the token is valid.
+	Validity int `json:"validity,omitempty"`
+	// The username encoded in the token.  A "*" is replaced with
+	// a random string.
+	Username *string `json:"username,omitempty"`
+	// Whether the token is valid for subgroups.
+	IncludeSubgroups bool `json:"include-subgroups,omitempty"`
+	// Limits applied to clients that log in with the token.
+	Limits *token.Limits `json:"limits,omitempty"`
+}
+
+// ErrUnknownTemplate is returned when a token template doesn't exist.
+var ErrUnknownTemplate = UserError("unknown token template")
+
+func expandUsername(pattern string) string {
+	if !strings.Contains(pattern, "*") {
+		return pattern
+	}
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	return strings.ReplaceAll(pattern, "*", hex.EncodeToString(buf))
+}
+
+// ApplyTokenTemplate fills the fields of tok that have not been set by
+// the caller with the values from the named template of the group.
+func ApplyTokenTemplate(desc *Description, name string, tok *token.Stateful, now time.Time) error {
+	tmpl, ok := desc.TokenTemplates[name]
+	if !ok {
+		return ErrUnknownTemplate
+	}
+
+	if tok.Permissions == nil && tmpl.Permissions != nil {
+		perms := tmpl.Permissions.Permissions(nil)
+		tok.Permissions = make([]string, len(perms))
+		copy(tok.Permissions, perms)
+	}
+	if tok.Expires == nil && tmpl.Validity > 0 {
+		e := now.Add(time.Duration(tmpl.Validity) * time.Second)
+		tok.Expires = &e
+	}
+	if tok.Username == nil && tmpl.Username != nil {
+		u := expandUsername(*tmpl.Username)
+		tok.Username = &u
+	}
+	if tmpl.IncludeSubgroups {
+		tok.IncludeSubgroups = true
+	}
+	if tok.Limits == nil {
+		tok.Limits = tmpl.Limits.Clone()
+	}
+	return nil
+}
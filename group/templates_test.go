@@ -0,0 +1,88 @@
+package group
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestApplyTokenTemplate(t *testing.T) {
+	var desc Description
+	err := json.Unmarshal([]byte(`{
+	    "token-templates": {
+	        "lecture": {
+	            "permissions": "present",
+	            "validity": 7200,
+	            "username": "student-*",
+	            "limits": {"maxTracks": 2}
+	        },
+	        "empty": {}
+	    }
+	}`), &desc)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	now := time.Now()
+
+	var tok token.Stateful
+	err = ApplyTokenTemplate(&desc, "lecture", &tok, now)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !reflect.DeepEqual(tok.Permissions, []string{"present", "message"}) {
+		t.Errorf("Permissions: %v", tok.Permissions)
+	}
+	if tok.Expires == nil || !tok.Expires.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("Expires: %v", tok.Expires)
+	}
+	if tok.Username == nil ||
+		!strings.HasPrefix(*tok.Username, "student-") ||
+		len(*tok.Username) <= len("student-") {
+		t.Errorf("Username: %v", tok.Username)
+	}
+	if tok.Limits == nil || tok.Limits.MaxTracks != 2 {
+		t.Errorf("Limits: %v", tok.Limits)
+	}
+	if tok.Limits == desc.TokenTemplates["lecture"].Limits {
+		t.Errorf("Limits not copied")
+	}
+
+	// explicit values take precedence
+	user := "bob"
+	expires := now.Add(time.Minute)
+	tok = token.Stateful{
+		Permissions: []string{"message"},
+		Username:    &user,
+		Expires:     &expires,
+	}
+	err = ApplyTokenTemplate(&desc, "lecture", &tok, now)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !reflect.DeepEqual(tok.Permissions, []string{"message"}) ||
+		tok.Username == nil || *tok.Username != "bob" ||
+		!tok.Expires.Equal(expires) {
+		t.Errorf("Overridden: %v %v %v",
+			tok.Permissions, tok.Username, tok.Expires)
+	}
+
+	tok = token.Stateful{}
+	err = ApplyTokenTemplate(&desc, "empty", &tok, now)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if tok.Permissions != nil || tok.Expires != nil ||
+		tok.Username != nil || tok.Limits != nil {
+		t.Errorf("Empty template: %v", tok)
+	}
+
+	err = ApplyTokenTemplate(&desc, "unknown", &tok, now)
+	if err != ErrUnknownTemplate {
+		t.Errorf("Unknown template: %v", err)
+	}
+}
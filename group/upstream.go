@@ -0,0 +1,72 @@
+package group
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// An Upstream is a group on another server whose streams are received by
+// this server and redistributed in the local group.  This allows the
+// audience of a large event to be spread over multiple servers.
+type Upstream struct {
+	// The location of the group on the other server, such as
+	// https://galene.example.org/group/event/.
+	URL string `json:"url"`
+	// The credentials used to join the upstream group, either a
+	// username and password or a token.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// CheckUpstream returns an error if u is not a valid upstream group.
+func CheckUpstream(u *Upstream) error {
+	if u == nil {
+		return nil
+	}
+	parsed, err := url.Parse(u.URL)
+	if err != nil {
+		return err
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return errors.New("upstream URL must have scheme http or https")
+	}
+	if parsed.Host == "" {
+		return errors.New("upstream URL has no host")
+	}
+	if u.Token != "" && (u.Username != "" || u.Password != "") {
+		return errors.New("upstream has both a token and a username")
+	}
+	return nil
+}
+
+// hideUpstreamCredentials returns a copy of u without the password and
+// token, which are not returned by the administrative API.
+func hideUpstreamCredentials(u *Upstream) *Upstream {
+	if u == nil {
+		return nil
+	}
+	result := *u
+	result.Password = ""
+	result.Token = ""
+	return &result
+}
+
+// keepUpstreamCredentials fills in the missing password or token of u
+// from old, as long as they refer to the same upstream group.
+func keepUpstreamCredentials(u, old *Upstream) *Upstream {
+	if u == nil || old == nil || u.URL != old.URL {
+		return u
+	}
+	result := *u
+	if result.Username != "" {
+		if result.Password == "" && result.Username == old.Username {
+			result.Password = old.Password
+		}
+	} else if result.Token == "" {
+		result.Token = old.Token
+	}
+	return &result
+}
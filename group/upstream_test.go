@@ -0,0 +1,75 @@
+package group
+
+import (
+	"testing"
+)
+
+func TestCheckUpstream(t *testing.T) {
+	good := []*Upstream{
+		nil,
+		{URL: "https://galene.example.org/group/event/"},
+		{URL: "http://192.0.2.1:8443/group/event", Username: "edge"},
+		{URL: "HTTPS://[2001:db8::1]/group/a/b/", Token: "tok"},
+	}
+	bad := []*Upstream{
+		{URL: ""},
+		{URL: "wss://galene.example.org/ws"},
+		{URL: "https:///group/event/"},
+		{URL: "https://%zz/"},
+		{
+			URL:      "https://galene.example.org/group/event/",
+			Username: "edge",
+			Token:    "tok",
+		},
+	}
+	for _, u := range good {
+		err := CheckUpstream(u)
+		if err != nil {
+			t.Errorf("%v: %v", u, err)
+		}
+	}
+	for _, u := range bad {
+		err := CheckUpstream(u)
+		if err == nil {
+			t.Errorf("%v accepted", u)
+		}
+	}
+}
+
+func TestUpstreamCredentials(t *testing.T) {
+	old := &Upstream{
+		URL:      "https://galene.example.org/group/event/",
+		Username: "edge",
+		Password: "pw",
+	}
+	h := hideUpstreamCredentials(old)
+	if h.Password != "" || h.Username != "edge" || old.Password != "pw" {
+		t.Errorf("Unexpected %v %v", h, old)
+	}
+
+	k := keepUpstreamCredentials(h, old)
+	if *k != *old {
+		t.Errorf("Expected %v, got %v", old, k)
+	}
+
+	other := &Upstream{URL: old.URL, Username: "other"}
+	k = keepUpstreamCredentials(other, old)
+	if k.Password != "" {
+		t.Errorf("Password kept for different user")
+	}
+
+	moved := &Upstream{
+		URL:      "https://evil.example.org/group/event/",
+		Username: "edge",
+	}
+	k = keepUpstreamCredentials(moved, old)
+	if k.Password != "" {
+		t.Errorf("Password kept for different URL")
+	}
+
+	oldtok := &Upstream{URL: old.URL, Token: "tok"}
+	k = keepUpstreamCredentials(&Upstream{URL: old.URL}, oldtok)
+	if k.Token != "tok" {
+		t.Errorf("Token not kept")
+	}
+}
@@ -0,0 +1,110 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The data of a client is a dictionary that is set by the client when it
+// joins and updated with the setdata user action, and that is sent to all
+// the members of the group.  In order to prevent clients from using it as
+// an unbounded data channel, only the keys declared in the user-data field
+// of the group description are kept, and the size of values is limited.
+
+const (
+	// the maximum number of keys in a schema
+	MaxUserDataKeys = 32
+	// the maximum length of a string value, in bytes
+	MaxUserDataStringLength = 256
+	// the maximum size of the JSON encoding of a client's data
+	MaxUserDataSize = 4096
+)
+
+// UserDataSchema maps the keys that a client may set in its data to the
+// type of their values, one of "boolean", "number" or "string".
+type UserDataSchema map[string]string
+
+// the schema used when the group description doesn't specify one
+var defaultUserDataSchema = UserDataSchema{
+	"raisehand": "boolean",
+}
+
+var ErrUserDataTooLarge = UserError("user data too large")
+
+// Check returns an error if the schema is invalid.
+func (schema UserDataSchema) Check() error {
+	if len(schema) > MaxUserDataKeys {
+		return errors.New("too many keys in user-data")
+	}
+	for k, v := range schema {
+		if k == "" {
+			return errors.New("empty key in user-data")
+		}
+		switch v {
+		case "boolean", "number", "string":
+		default:
+			return fmt.Errorf("unknown type %v for user-data %v", v, k)
+		}
+	}
+	return nil
+}
+
+func userDataSchema(desc *Description) UserDataSchema {
+	if desc == nil || desc.UserData == nil {
+		return defaultUserDataSchema
+	}
+	return desc.UserData
+}
+
+// SanitiseUserData returns the subset of data that is allowed by the
+// schema of the given group description, which may be nil.  Unknown keys
+// are dropped, and null values, which are used to delete a key, are kept.
+// It returns an error if a value has the wrong type or if the data is too
+// large.
+func SanitiseUserData(desc *Description, data map[string]any) (map[string]any, error) {
+	if data == nil {
+		return nil, nil
+	}
+	schema := userDataSchema(desc)
+	d := make(map[string]any, len(data))
+	for k, v := range data {
+		tpe, ok := schema[k]
+		if !ok {
+			continue
+		}
+		if v == nil {
+			d[k] = nil
+			continue
+		}
+		switch tpe {
+		case "boolean":
+			_, ok = v.(bool)
+		case "number":
+			_, ok = v.(float64)
+		case "string":
+			var s string
+			s, ok = v.(string)
+			if ok && len(s) > MaxUserDataStringLength {
+				return nil, ErrUserDataTooLarge
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, UserError(
+				fmt.Sprintf("bad value for user data %v", k),
+			)
+		}
+		d[k] = v
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxUserDataSize {
+		return nil, ErrUserDataTooLarge
+	}
+	return d, nil
+}
@@ -0,0 +1,74 @@
+package group
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSanitiseUserData(t *testing.T) {
+	d, err := SanitiseUserData(nil, map[string]any{
+		"raisehand": true,
+		"unknown":   "value",
+	})
+	if err != nil || len(d) != 1 || d["raisehand"] != true {
+		t.Errorf("Default schema: %v %v", d, err)
+	}
+
+	d, err = SanitiseUserData(nil, map[string]any{"raisehand": nil})
+	if v, ok := d["raisehand"]; err != nil || !ok || v != nil {
+		t.Errorf("Null value: %v %v", d, err)
+	}
+
+	_, err = SanitiseUserData(nil, map[string]any{"raisehand": "yes"})
+	if err == nil {
+		t.Errorf("Bad type accepted")
+	}
+
+	var desc Description
+	err = json.Unmarshal([]byte(`{
+            "user-data": {"pronouns": "string", "score": "number"}
+        }`), &desc)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	err = desc.UserData.Check()
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	d, err = SanitiseUserData(&desc, map[string]any{
+		"raisehand": true,
+		"pronouns":  "they/them",
+		"score":     42.0,
+	})
+	if err != nil || len(d) != 2 || d["pronouns"] != "they/them" {
+		t.Errorf("Custom schema: %v %v", d, err)
+	}
+
+	_, err = SanitiseUserData(&desc, map[string]any{
+		"pronouns": strings.Repeat("a", MaxUserDataStringLength+1),
+	})
+	if err != ErrUserDataTooLarge {
+		t.Errorf("Expected ErrUserDataTooLarge, got %v", err)
+	}
+}
+
+func TestUserDataSchemaCheck(t *testing.T) {
+	if err := (UserDataSchema{"a": "object"}).Check(); err == nil {
+		t.Errorf("Unknown type accepted")
+	}
+	if err := (UserDataSchema{"": "string"}).Check(); err == nil {
+		t.Errorf("Empty key accepted")
+	}
+	schema := make(UserDataSchema)
+	for i := 0; i <= MaxUserDataKeys; i++ {
+		schema[strings.Repeat("a", i+1)] = "string"
+	}
+	if err := schema.Check(); err == nil {
+		t.Errorf("Too many keys accepted")
+	}
+	if err := UserDataSchema(nil).Check(); err != nil {
+		t.Errorf("Check(nil): %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+package group
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/jech/galene/ice"
+)
+
+// The configuration files and the group descriptions are re-read
+// periodically.  On systems that support it, we additionally watch the
+// data and groups directories, and reload as soon as they change.
+
+// the delay between the last change and reloading, which avoids
+// reloading multiple times when a file is written in multiple steps
+const reloadDelay = 500 * time.Millisecond
+
+// Reload causes the global configuration, the ICE servers and the
+// descriptions of all running groups to be re-read.
+func Reload() {
+	Update()
+	ice.Update()
+}
+
+// relevantFile returns true if the change of the file at filename, in
+// the data directory if data is true, should trigger a reload.
+func relevantFile(filename string, data bool) bool {
+	base := filepath.Base(filename)
+	if data {
+		return base == "config.json" || base == "ice-servers.json"
+	}
+	return strings.HasSuffix(base, ".json") && !strings.HasPrefix(base, ".")
+}
+
+// debouncer calls a function once no event has been signalled for
+// a given delay.
+type debouncer struct {
+	delay time.Duration
+	f     func()
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func (d *debouncer) signal() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Reset(d.delay)
+		return
+	}
+	d.timer = time.AfterFunc(d.delay, func() {
+		d.mu.Lock()
+		d.timer = nil
+		d.mu.Unlock()
+		d.f()
+	})
+}
+
+type watcher struct {
+	w *fsnotify.Watcher
+
+	mu sync.Mutex
+	// maps each watched directory to whether it is the data directory
+	dirs map[string]bool
+}
+
+// add starts watching dir.  Unless data is true, subdirectories are
+// watched too.
+func (w *watcher) add(dir string, data bool) error {
+	err := w.w.Add(dir)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.dirs[dir] = data
+	w.mu.Unlock()
+
+	if data {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			err := w.add(filepath.Join(dir, e.Name()), false)
+			if err != nil {
+				log.Printf("Watch %v: %v", e.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *watcher) run(changed func()) {
+	for {
+		select {
+		case event, ok := <-w.w.Events:
+			if !ok {
+				return
+			}
+			w.handle(event, changed)
+		case err, ok := <-w.w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watch: %v", err)
+		}
+	}
+}
+
+func (w *watcher) handle(event fsnotify.Event, changed func()) {
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+
+	w.mu.Lock()
+	data, ok := w.dirs[filepath.Dir(event.Name)]
+	_, isDir := w.dirs[event.Name]
+	if isDir && event.Has(fsnotify.Remove|fsnotify.Rename) {
+		delete(w.dirs, event.Name)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if event.Has(fsnotify.Create) {
+		fi, err := os.Stat(event.Name)
+		isDir = err == nil && fi.IsDir()
+	}
+	if isDir {
+		if data || strings.HasPrefix(filepath.Base(event.Name), ".") {
+			return
+		}
+		if event.Has(fsnotify.Create) {
+			err := w.add(event.Name, false)
+			if err != nil {
+				log.Printf("Watch %v: %v", event.Name, err)
+			}
+		}
+		changed()
+		return
+	}
+	if relevantFile(event.Name, data) {
+		changed()
+	}
+}
+
+func watch(data, groups string, changed func()) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w := &watcher{w: fw, dirs: make(map[string]bool)}
+	err = w.add(data, true)
+	if err == nil && groups != "" {
+		err = w.add(groups, false)
+	}
+	if err != nil {
+		fw.Close()
+		return err
+	}
+	go w.run(changed)
+	return nil
+}
+
+// Watch starts watching the data and groups directories, and reloads
+// the configuration whenever a relevant file changes.
+func Watch() error {
+	d := &debouncer{
+		delay: reloadDelay,
+		f: func() {
+			log.Printf("Configuration changed, reloading")
+			Reload()
+		},
+	}
+	return watch(DataDirectory, Directory, d.signal)
+}
@@ -0,0 +1,104 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRelevantFile(t *testing.T) {
+	tests := []struct {
+		filename string
+		data     bool
+		result   bool
+	}{
+		{"data/config.json", true, true},
+		{"data/ice-servers.json", true, true},
+		{"data/cert.pem", true, false},
+		{"data/config.json~", true, false},
+		{"groups/city-watch.json", false, true},
+		{"groups/nested/night-watch.json", false, true},
+		{"groups/.city-watch.json.swp", false, false},
+		{"groups/.city-watch.json", false, false},
+		{"groups/notes.txt", false, false},
+	}
+	for _, test := range tests {
+		r := relevantFile(test.filename, test.data)
+		if r != test.result {
+			t.Errorf("%v %v: expected %v, got %v",
+				test.filename, test.data, test.result, r)
+		}
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	var count atomic.Int32
+	d := &debouncer{
+		delay: 50 * time.Millisecond,
+		f:     func() { count.Add(1) },
+	}
+	for i := 0; i < 5; i++ {
+		d.signal()
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if c := count.Load(); c != 1 {
+		t.Errorf("Expected 1, got %v", c)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("not supported")
+	}
+	data := t.TempDir()
+	groups := t.TempDir()
+	err := os.Mkdir(filepath.Join(groups, "nested"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 16)
+	err = watch(data, groups, func() { changed <- struct{}{} })
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	check := func(filename string, expected bool) {
+		t.Helper()
+		err := os.WriteFile(filename, []byte("{}"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changed:
+			if !expected {
+				t.Errorf("%v: unexpected change", filename)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if expected {
+				t.Errorf("%v: no change", filename)
+			}
+		}
+		for len(changed) > 0 {
+			<-changed
+		}
+	}
+
+	check(filepath.Join(data, "config.json"), true)
+	check(filepath.Join(data, "cert.pem"), false)
+	check(filepath.Join(groups, "city-watch.json"), true)
+	check(filepath.Join(groups, "nested", "night-watch.json"), true)
+
+	err = os.Mkdir(filepath.Join(groups, "new"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-changed
+	// give the watcher time to add the new directory
+	time.Sleep(50 * time.Millisecond)
+	check(filepath.Join(groups, "new", "day-watch.json"), true)
+}
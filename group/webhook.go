@@ -0,0 +1,259 @@
+package group
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhooks notify external services of the events that happen in a
+// group, such as users joining and leaving, which avoids the need to
+// poll the API.  Events are delivered asynchronously, in order, and are
+// dropped if the receiver cannot keep up.
+//
+// Every destination URL has its own queue and delivery goroutine, so that
+// a slow or unreachable receiver only delays its own events.  After
+// a failure, delivery to that destination is suspended for a time that
+// grows with the number of consecutive failures, and events that don't
+// fit in its queue in the meantime are dropped.  The goroutine exits once
+// its queue has been empty for a while.
+
+// A Webhook is a URL to which events are posted.
+type Webhook struct {
+	// The URL to which events are posted.
+	URL string `json:"url"`
+	// If not empty, the key used to sign the events.
+	Secret string `json:"secret,omitempty"`
+	// The kinds of events sent to this webhook, all of them if empty.
+	Events []string `json:"events,omitempty"`
+}
+
+// A WebhookEvent is the body of the requests sent to webhooks.
+type WebhookEvent struct {
+	Kind     string    `json:"kind"`
+	Group    string    `json:"group"`
+	Time     time.Time `json:"time"`
+	Id       string    `json:"id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// hideWebhookSecrets returns a copy of hooks without the secrets, which
+// are not returned by the administrative API.
+func hideWebhookSecrets(hooks []Webhook) []Webhook {
+	if hooks == nil {
+		return nil
+	}
+	result := make([]Webhook, len(hooks))
+	for i, h := range hooks {
+		h.Events = append([]string(nil), h.Events...)
+		h.Secret = ""
+		result[i] = h
+	}
+	return result
+}
+
+// keepWebhookSecrets fills in the missing secrets of hooks from the
+// webhooks with the same URL in old.
+func keepWebhookSecrets(hooks, old []Webhook) []Webhook {
+	if hooks == nil {
+		return nil
+	}
+	result := make([]Webhook, len(hooks))
+	for i, h := range hooks {
+		if h.Secret == "" {
+			for _, o := range old {
+				if o.URL == h.URL {
+					h.Secret = o.Secret
+					break
+				}
+			}
+		}
+		result[i] = h
+	}
+	return result
+}
+
+// WebhookSignatureHeader is the header that carries the HMAC-SHA256 of
+// the body of a request, keyed with the secret of the webhook.
+const WebhookSignatureHeader = "X-Galene-Signature"
+
+type webhookDelivery struct {
+	hook Webhook
+	body []byte
+}
+
+const (
+	webhookQueueLength = 64
+	webhookTimeout     = 10 * time.Second
+	webhookIdleTime    = time.Minute
+	webhookMinBackoff  = time.Second
+	webhookMaxBackoff  = 5 * time.Minute
+)
+
+// webhookWorker delivers the events sent to a single URL.
+type webhookWorker struct {
+	queue chan webhookDelivery
+}
+
+var webhookState struct {
+	mu      sync.Mutex
+	workers map[string]*webhookWorker
+	client  *http.Client
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(client *http.Client, hook Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set(
+			WebhookSignatureHeader, signWebhook(hook.Secret, body),
+		)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// webhookBackoff returns the time during which delivery is suspended
+// after n consecutive failures.
+func webhookBackoff(n int) time.Duration {
+	backoff := webhookMinBackoff
+	for i := 1; i < n && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
+
+func webhookLoop(url string, w *webhookWorker, client *http.Client) {
+	failures := 0
+	timer := time.NewTimer(webhookIdleTime)
+	defer timer.Stop()
+	for {
+		select {
+		case d := <-w.queue:
+			err := postWebhook(client, d.hook, d.body)
+			if err == nil {
+				failures = 0
+			} else {
+				failures++
+				log.Printf("Webhook %v: %v", url, err)
+				time.Sleep(webhookBackoff(failures))
+			}
+		case <-timer.C:
+			webhookState.mu.Lock()
+			if len(w.queue) == 0 {
+				delete(webhookState.workers, url)
+				webhookState.mu.Unlock()
+				return
+			}
+			webhookState.mu.Unlock()
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(webhookIdleTime)
+	}
+}
+
+// queueWebhook queues a delivery, starting a worker for its URL if
+// necessary.  It never blocks.
+func queueWebhook(d webhookDelivery) {
+	webhookState.mu.Lock()
+	defer webhookState.mu.Unlock()
+
+	if webhookState.client == nil {
+		webhookState.client = &http.Client{Timeout: webhookTimeout}
+	}
+	if webhookState.workers == nil {
+		webhookState.workers = make(map[string]*webhookWorker)
+	}
+	w := webhookState.workers[d.hook.URL]
+	if w == nil {
+		w = &webhookWorker{
+			queue: make(chan webhookDelivery, webhookQueueLength),
+		}
+		webhookState.workers[d.hook.URL] = w
+		go webhookLoop(d.hook.URL, w, webhookState.client)
+	}
+
+	select {
+	case w.queue <- d:
+	default:
+		log.Printf("Webhook %v: queue full, event dropped", d.hook.URL)
+	}
+}
+
+// webhooks returns the webhooks that apply to a group, the server-wide
+// ones followed by those of the group.
+func webhooks(desc *Description) []Webhook {
+	var hooks []Webhook
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config.json: %v", err)
+	} else {
+		hooks = append(hooks, conf.Webhooks...)
+	}
+	if desc != nil {
+		hooks = append(hooks, desc.Webhooks...)
+	}
+	return hooks
+}
+
+// notifyWebhooks queues an event for delivery to the webhooks of a group.
+// It doesn't block, and may be called with the group locked.
+func notifyWebhooks(name string, desc *Description, e WebhookEvent) {
+	hooks := webhooks(desc)
+	if len(hooks) == 0 {
+		return
+	}
+
+	e.Group = name
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Webhook: %v", err)
+		return
+	}
+
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !member(e.Kind, hook.Events) {
+			continue
+		}
+		queueWebhook(webhookDelivery{hook, body})
+	}
+}
+
+// NotifyWebhooks sends an event to the webhooks of the group.
+func (g *Group) NotifyWebhooks(e WebhookEvent) {
+	notifyWebhooks(g.Name(), g.Description(), e)
+}
@@ -0,0 +1,170 @@
+package group
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	type received struct {
+		event     WebhookEvent
+		signature string
+		valid     bool
+	}
+	ch := make(chan received, 8)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Errorf("Read: %v", err)
+				return
+			}
+			var e WebhookEvent
+			err = json.Unmarshal(body, &e)
+			if err != nil {
+				t.Errorf("Unmarshal: %v", err)
+			}
+			sig := r.Header.Get(WebhookSignatureHeader)
+			ch <- received{
+				event:     e,
+				signature: sig,
+				valid:     sig == signWebhook("secret", body),
+			}
+		},
+	))
+	defer server.Close()
+
+	desc := &Description{
+		Webhooks: []Webhook{
+			{URL: server.URL + "/all", Secret: "secret"},
+			{URL: server.URL + "/leave", Events: []string{"leave"}},
+		},
+	}
+
+	notifyWebhooks("test", desc, WebhookEvent{
+		Kind:     "join",
+		Id:       "id",
+		Username: "alice",
+	})
+	notifyWebhooks("test", desc, WebhookEvent{
+		Kind: "leave",
+		Id:   "id",
+	})
+
+	var events []received
+	timeout := time.After(5 * time.Second)
+	for len(events) < 3 {
+		select {
+		case r := <-ch:
+			events = append(events, r)
+		case <-timeout:
+			t.Fatalf("Timeout, got %v", events)
+		}
+	}
+
+	// events to different URLs may be delivered in any order
+	signed, unsigned := 0, 0
+	for _, r := range events {
+		if r.event.Kind == "join" {
+			if r.event.Group != "test" ||
+				r.event.Username != "alice" ||
+				r.event.Time.IsZero() {
+				t.Errorf("Unexpected event %v", r.event)
+			}
+			if !r.valid {
+				t.Errorf("Bad signature %v", r.signature)
+			}
+			continue
+		}
+		if r.event.Kind != "leave" {
+			t.Errorf("Unexpected event %v", r.event)
+		}
+		if r.signature == "" {
+			unsigned++
+		} else if r.valid {
+			signed++
+		}
+	}
+	if signed != 1 || unsigned != 1 {
+		t.Errorf("Expected one signed and one unsigned, got %v %v",
+			signed, unsigned)
+	}
+}
+
+func TestWebhookSlowReceiver(t *testing.T) {
+	block := make(chan struct{})
+	fast := make(chan struct{}, 8)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				<-block
+				return
+			}
+			fast <- struct{}{}
+		},
+	))
+	defer server.Close()
+	defer close(block)
+
+	desc := &Description{
+		Webhooks: []Webhook{
+			{URL: server.URL + "/slow"},
+			{URL: server.URL + "/fast"},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		notifyWebhooks("test", desc, WebhookEvent{Kind: "join"})
+	}
+
+	timeout := time.After(2 * time.Second)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-fast:
+		case <-timeout:
+			t.Fatalf("Fast receiver delayed by slow one")
+		}
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	if b := webhookBackoff(1); b != webhookMinBackoff {
+		t.Errorf("Expected %v, got %v", webhookMinBackoff, b)
+	}
+	if b := webhookBackoff(3); b != 4*webhookMinBackoff {
+		t.Errorf("Expected %v, got %v", 4*webhookMinBackoff, b)
+	}
+	if b := webhookBackoff(100); b != webhookMaxBackoff {
+		t.Errorf("Expected %v, got %v", webhookMaxBackoff, b)
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	old := []Webhook{
+		{URL: "https://a.example.org/", Secret: "a"},
+		{URL: "https://b.example.org/", Events: []string{"join"}},
+	}
+	hidden := hideWebhookSecrets(old)
+	if hidden[0].Secret != "" || old[0].Secret != "a" ||
+		hidden[1].Events[0] != "join" {
+		t.Errorf("Unexpected %v %v", hidden, old)
+	}
+
+	kept := keepWebhookSecrets(append(hidden,
+		Webhook{URL: "https://c.example.org/", Secret: "c"},
+	), old)
+	if kept[0].Secret != "a" || kept[1].Secret != "" ||
+		kept[2].Secret != "c" {
+		t.Errorf("Unexpected %v", kept)
+	}
+
+	kept = keepWebhookSecrets([]Webhook{
+		{URL: "https://a.example.org/", Secret: "new"},
+	}, old)
+	if kept[0].Secret != "new" {
+		t.Errorf("Secret not replaced")
+	}
+}
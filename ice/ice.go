@@ -35,6 +35,9 @@ type Server struct {
 	Username       string      `json:"username,omitempty"`
 	Credential     interface{} `json:"credential,omitempty"`
 	CredentialType string      `json:"credentialType,omitempty"`
+	// The lifetime, in seconds, of the credentials generated for
+	// hmac-sha1 servers.  One day if 0.
+	Lifetime int `json:"lifetime,omitempty"`
 }
 
 func getServer(server Server) (webrtc.ICEServer, error) {
@@ -54,7 +57,11 @@ func getServer(server Server) (webrtc.ICEServer, error) {
 			return webrtc.ICEServer{},
 				errors.New("credential is not a string")
 		}
-		ts := time.Now().Unix() + 86400
+		lifetime := int64(server.Lifetime)
+		if lifetime <= 0 {
+			lifetime = 86400
+		}
+		ts := time.Now().Unix() + lifetime
 		var username string
 		if server.Username == "" {
 			username = fmt.Sprintf("%d", ts)
@@ -79,6 +86,10 @@ func getServer(server Server) (webrtc.ICEServer, error) {
 var ICEFilename string
 var ICERelayOnly bool
 
+// In LAN mode, ice-servers.json and the ICE servers defined by groups are
+// ignored, since external servers are usually unreachable.
+var LANMode bool
+
 type configuration struct {
 	conf      webrtc.Configuration
 	timestamp time.Time
@@ -91,7 +102,7 @@ func Update() *configuration {
 	var cf webrtc.Configuration
 
 	found := false
-	if ICEFilename != "" {
+	if ICEFilename != "" && !LANMode {
 		found = true
 		file, err := os.Open(ICEFilename)
 		if err != nil {
@@ -149,6 +160,28 @@ func ICEConfiguration() *webrtc.Configuration {
 	return &conf.conf
 }
 
+// GroupConfiguration returns the ICE configuration for a group that
+// defines its own ICE servers, which override the global configuration.
+// If servers is empty or in LAN mode, it returns the global configuration.
+func GroupConfiguration(servers []Server) *webrtc.Configuration {
+	if len(servers) == 0 || LANMode {
+		return ICEConfiguration()
+	}
+	var cf webrtc.Configuration
+	for _, s := range servers {
+		ss, err := getServer(s)
+		if err != nil {
+			log.Printf("parse ICE server: %v", err)
+			continue
+		}
+		cf.ICEServers = append(cf.ICEServers, ss)
+	}
+	if ICERelayOnly {
+		cf.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	return &cf
+}
+
 func RelayTest(timeout time.Duration) (time.Duration, error) {
 
 	conf := ICEConfiguration()
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -67,6 +68,49 @@ func TestHMAC(t *testing.T) {
 	}
 }
 
+func TestHMACLifetime(t *testing.T) {
+	s := Server{
+		URLs:           []string{"turn:turn.example.org"},
+		Credential:     "secret",
+		CredentialType: "hmac-sha1",
+		Lifetime:       600,
+	}
+	now := time.Now().Unix()
+	ss, err := getServer(s)
+	if err != nil {
+		t.Fatalf("getServer: %v", err)
+	}
+	ts, err := strconv.ParseInt(ss.Username, 10, 64)
+	if err != nil || ts < now+600 || ts > now+601 {
+		t.Errorf("Expected expiry %v, got %v", now+600, ss.Username)
+	}
+}
+
+func TestGroupConfiguration(t *testing.T) {
+	ICEFilename = "/tmp/no/such/file"
+	turnserver.Address = ""
+
+	if conf := GroupConfiguration(nil); conf != ICEConfiguration() {
+		t.Errorf("GroupConfiguration(nil) is not the global configuration")
+	}
+
+	conf := GroupConfiguration([]Server{
+		{
+			URLs:       []string{"turn:turn.example.org"},
+			Username:   "jch",
+			Credential: "secret",
+		},
+		{
+			URLs:           []string{"turn:bad.example.org"},
+			CredentialType: "unknown",
+		},
+	})
+	if len(conf.ICEServers) != 1 ||
+		conf.ICEServers[0].URLs[0] != "turn:turn.example.org" {
+		t.Errorf("GroupConfiguration: %v", conf.ICEServers)
+	}
+}
+
 func TestICEConfiguration(t *testing.T) {
 	ICEFilename = "/tmp/no/such/file"
 	turnserver.Address = ""
@@ -0,0 +1,161 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// A minimal implementation of the subset of BER used by LDAP.  Only
+// single-byte tags and definite lengths are supported.
+
+const maxElementLength = 1024 * 1024
+
+var errBadBER = errors.New("malformed LDAP message")
+
+type element struct {
+	tag     byte
+	content []byte
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for n > 0 {
+		b = append([]byte{byte(n)}, b...)
+		n >>= 8
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func tlv(tag byte, content ...[]byte) []byte {
+	var c []byte
+	for _, x := range content {
+		c = append(c, x...)
+	}
+	b := append([]byte{tag}, encodeLength(len(c))...)
+	return append(b, c...)
+}
+
+func berString(tag byte, s string) []byte {
+	return tlv(tag, []byte(s))
+}
+
+// berInt encodes a non-negative integer.
+func berInt(tag byte, v int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 && b[0]&0x80 == 0 {
+			break
+		}
+	}
+	return tlv(tag, b)
+}
+
+func (e element) int() (int, error) {
+	if len(e.content) == 0 || len(e.content) > 4 {
+		return 0, errBadBER
+	}
+	v := int(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		v = v<<8 | int(b)
+	}
+	return v, nil
+}
+
+// parseLength parses a length at the start of buf, and returns it
+// together with the number of bytes consumed.
+func parseLength(buf []byte) (int, int, error) {
+	if len(buf) < 1 {
+		return 0, 0, errBadBER
+	}
+	if buf[0] < 0x80 {
+		return int(buf[0]), 1, nil
+	}
+	n := int(buf[0] & 0x7F)
+	if n == 0 || n > 3 || len(buf) < 1+n {
+		return 0, 0, errBadBER
+	}
+	l := 0
+	for _, b := range buf[1 : 1+n] {
+		l = l<<8 | int(b)
+	}
+	return l, 1 + n, nil
+}
+
+// parseElement parses the element at the start of buf, and returns it
+// together with the rest of the buffer.
+func parseElement(buf []byte) (element, []byte, error) {
+	if len(buf) < 2 || buf[0]&0x1F == 0x1F {
+		return element{}, nil, errBadBER
+	}
+	tag := buf[0]
+	l, n, err := parseLength(buf[1:])
+	if err != nil {
+		return element{}, nil, err
+	}
+	buf = buf[1+n:]
+	if len(buf) < l {
+		return element{}, nil, errBadBER
+	}
+	return element{tag, buf[:l]}, buf[l:], nil
+}
+
+// parseElements parses the elements of a constructed value.
+func parseElements(buf []byte) ([]element, error) {
+	var elts []element
+	for len(buf) > 0 {
+		var e element
+		var err error
+		e, buf, err = parseElement(buf)
+		if err != nil {
+			return nil, err
+		}
+		elts = append(elts, e)
+	}
+	return elts, nil
+}
+
+// readElement reads a single element from r.
+func readElement(r *bufio.Reader) (element, error) {
+	var header [5]byte
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	if tag&0x1F == 0x1F {
+		return element{}, errBadBER
+	}
+	header[0], err = r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	n := 1
+	if header[0] >= 0x80 {
+		n += int(header[0] & 0x7F)
+		if n > len(header) {
+			return element{}, errBadBER
+		}
+		_, err = io.ReadFull(r, header[1:n])
+		if err != nil {
+			return element{}, err
+		}
+	}
+	l, _, err := parseLength(header[:n])
+	if err != nil {
+		return element{}, err
+	}
+	if l > maxElementLength {
+		return element{}, errors.New("LDAP message too large")
+	}
+	content := make([]byte, l)
+	_, err = io.ReadFull(r, content)
+	if err != nil {
+		return element{}, err
+	}
+	return element{tag, content}, nil
+}
@@ -0,0 +1,339 @@
+// Package ldap implements just enough of the LDAP protocol to check a
+// user's password against a directory and to obtain the groups the user
+// belongs to.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/fips"
+)
+
+// Config describes a directory server.
+type Config struct {
+	// The URL of the server, either ldap://host:port or
+	// ldaps://host:port.
+	URL string `json:"url"`
+	// The DN and password used to search for users.  If empty, the
+	// search is performed anonymously.
+	BindDN       string `json:"bindDN,omitempty"`
+	BindPassword string `json:"bindPassword,omitempty"`
+	// The DN under which users are searched.
+	Base string `json:"base"`
+	// The attribute that holds the username, "uid" by default.
+	// Active Directory uses "sAMAccountName".
+	UserAttribute string `json:"userAttribute,omitempty"`
+	// The attribute that holds the DNs of the groups the user belongs
+	// to, "memberOf" by default.
+	GroupAttribute string `json:"groupAttribute,omitempty"`
+}
+
+// Timeout is the maximum time taken by an authentication.
+var Timeout = 10 * time.Second
+
+// ErrInvalidCredentials is returned when the user doesn't exist in the
+// directory or the password is incorrect.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// LDAP result codes
+const (
+	resultSuccess            = 0
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+)
+
+// LDAP protocol operations, with their BER tags
+const (
+	opBindRequest     = 0x60
+	opBindResponse    = 0x61
+	opUnbindRequest   = 0x42
+	opSearchRequest   = 0x63
+	opSearchEntry     = 0x64
+	opSearchDone      = 0x65
+	opSearchReference = 0x73
+)
+
+type resultError struct {
+	code    int
+	message string
+}
+
+func (err *resultError) Error() string {
+	if err.message != "" {
+		return fmt.Sprintf("LDAP error %v: %v", err.code, err.message)
+	}
+	return fmt.Sprintf("LDAP error %v", err.code)
+}
+
+type conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+func dial(conf *Config) (*conn, error) {
+	u, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	dialer := &net.Dialer{Timeout: Timeout}
+	var c net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		c, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		tlsConf := &tls.Config{ServerName: u.Hostname()}
+		fips.ConfigureTLS(tlsConf)
+		c, err = tls.DialWithDialer(dialer, "tcp", host, tlsConf)
+	default:
+		return nil, errors.New("unknown LDAP URL scheme " + u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(Timeout))
+	return &conn{conn: c, r: bufio.NewReader(c)}, nil
+}
+
+func (c *conn) close() error {
+	c.send(tlv(opUnbindRequest))
+	return c.conn.Close()
+}
+
+func (c *conn) send(op []byte) (int, error) {
+	c.id++
+	_, err := c.conn.Write(tlv(0x30, berInt(0x02, c.id), op))
+	return c.id, err
+}
+
+// receive returns the protocol operation of the next message with the
+// given id.
+func (c *conn) receive(id int) (element, error) {
+	for {
+		msg, err := readElement(c.r)
+		if err != nil {
+			return element{}, err
+		}
+		if msg.tag != 0x30 {
+			return element{}, errBadBER
+		}
+		elts, err := parseElements(msg.content)
+		if err != nil {
+			return element{}, err
+		}
+		if len(elts) < 2 {
+			return element{}, errBadBER
+		}
+		mid, err := elts[0].int()
+		if err != nil {
+			return element{}, err
+		}
+		if mid == 0 {
+			// notice of disconnection
+			return element{},
+				errors.New("LDAP server closed connection")
+		}
+		if mid == id {
+			return elts[1], nil
+		}
+	}
+}
+
+// parseResult parses an LDAPResult.
+func parseResult(op element) error {
+	elts, err := parseElements(op.content)
+	if err != nil {
+		return err
+	}
+	if len(elts) < 3 {
+		return errBadBER
+	}
+	code, err := elts[0].int()
+	if err != nil {
+		return err
+	}
+	if code == resultSuccess {
+		return nil
+	}
+	return &resultError{code: code, message: string(elts[2].content)}
+}
+
+func (c *conn) bind(dn, password string) error {
+	id, err := c.send(tlv(opBindRequest,
+		berInt(0x02, 3),
+		berString(0x04, dn),
+		berString(0x80, password),
+	))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return errBadBER
+	}
+	err = parseResult(op)
+	var rerr *resultError
+	if errors.As(err, &rerr) && rerr.code == resultInvalidCredentials {
+		return ErrInvalidCredentials
+	}
+	return err
+}
+
+type entry struct {
+	dn string
+	// indexed by the lowercase attribute name
+	attributes map[string][]string
+}
+
+func parseEntry(op element) (entry, error) {
+	elts, err := parseElements(op.content)
+	if err != nil {
+		return entry{}, err
+	}
+	if len(elts) < 2 {
+		return entry{}, errBadBER
+	}
+	e := entry{
+		dn:         string(elts[0].content),
+		attributes: make(map[string][]string),
+	}
+	attrs, err := parseElements(elts[1].content)
+	if err != nil {
+		return entry{}, err
+	}
+	for _, a := range attrs {
+		av, err := parseElements(a.content)
+		if err != nil {
+			return entry{}, err
+		}
+		if len(av) < 2 {
+			return entry{}, errBadBER
+		}
+		vals, err := parseElements(av[1].content)
+		if err != nil {
+			return entry{}, err
+		}
+		name := strings.ToLower(string(av[0].content))
+		for _, v := range vals {
+			e.attributes[name] = append(e.attributes[name],
+				string(v.content))
+		}
+	}
+	return e, nil
+}
+
+// search returns the entries under base whose attribute attr is equal
+// to value.  At most two entries are returned, which is enough to check
+// that a user is unique.
+func (c *conn) search(base, attr, value string, attrs []string) ([]entry, error) {
+	var attributes [][]byte
+	for _, a := range attrs {
+		attributes = append(attributes, berString(0x04, a))
+	}
+	id, err := c.send(tlv(opSearchRequest,
+		berString(0x04, base),
+		berInt(0x0a, 2), // wholeSubtree
+		berInt(0x0a, 0), // neverDerefAliases
+		berInt(0x02, 2), // sizeLimit
+		berInt(0x02, 0), // timeLimit
+		tlv(0x01, []byte{0}),
+		tlv(0xa3, berString(0x04, attr), berString(0x04, value)),
+		tlv(0x30, attributes...),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchEntry:
+			e, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case opSearchReference:
+			// we don't follow referrals
+		case opSearchDone:
+			err := parseResult(op)
+			var rerr *resultError
+			if errors.As(err, &rerr) &&
+				rerr.code == resultSizeLimitExceeded {
+				err = nil
+			}
+			return entries, err
+		default:
+			return nil, errBadBER
+		}
+	}
+}
+
+// Authenticate checks a user's password against the directory, and
+// returns the DNs of the groups that the user belongs to.
+func Authenticate(conf *Config, username, password string) ([]string, error) {
+	// an empty password would be an unauthenticated bind, which
+	// always succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	userAttribute := conf.UserAttribute
+	if userAttribute == "" {
+		userAttribute = "uid"
+	}
+	groupAttribute := conf.GroupAttribute
+	if groupAttribute == "" {
+		groupAttribute = "memberOf"
+	}
+
+	c, err := dial(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if conf.BindDN != "" {
+		err = c.bind(conf.BindDN, conf.BindPassword)
+		if err != nil {
+			return nil, fmt.Errorf("bind as %v: %v", conf.BindDN, err)
+		}
+	}
+
+	entries, err := c.search(
+		conf.Base, userAttribute, username, []string{groupAttribute},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	err = c.bind(entries[0].dn, password)
+	if err != nil {
+		return nil, err
+	}
+	return entries[0].attributes[strings.ToLower(groupAttribute)], nil
+}
@@ -0,0 +1,158 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestBERInt(t *testing.T) {
+	for _, v := range []int{0, 1, 127, 128, 255, 256, 65535, 1 << 24} {
+		e, rest, err := parseElement(berInt(0x02, v))
+		if err != nil || len(rest) != 0 {
+			t.Errorf("parseElement %v: %v %v", v, rest, err)
+			continue
+		}
+		w, err := e.int()
+		if err != nil || w != v {
+			t.Errorf("Expected %v, got %v %v", v, w, err)
+		}
+	}
+}
+
+func TestBERLength(t *testing.T) {
+	for _, l := range []int{0, 1, 127, 128, 300, 70000} {
+		buf := tlv(0x04, make([]byte, l))
+		e, err := readElement(bufio.NewReader(bytes.NewReader(buf)))
+		if err != nil || e.tag != 0x04 || len(e.content) != l {
+			t.Errorf("readElement %v: %v %v", l, len(e.content), err)
+		}
+	}
+	_, _, err := parseElement([]byte{0x04, 0x05, 0x00})
+	if err == nil {
+		t.Errorf("Truncated element accepted")
+	}
+}
+
+type fakeUser struct {
+	dn, uid, password string
+	groups            []string
+}
+
+// fakeServer implements just enough of an LDAP server for Authenticate.
+func fakeServer(l net.Listener, users []fakeUser) {
+	result := func(op byte, code int) []byte {
+		return tlv(op, berInt(0x0a, code),
+			berString(0x04, ""), berString(0x04, ""))
+	}
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(c net.Conn) {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				msg, err := readElement(r)
+				if err != nil {
+					return
+				}
+				elts, _ := parseElements(msg.content)
+				id, _ := elts[0].int()
+				reply := func(op []byte) {
+					c.Write(tlv(0x30, berInt(0x02, id), op))
+				}
+				op := elts[1]
+				args, _ := parseElements(op.content)
+				switch op.tag {
+				case opBindRequest:
+					code := resultInvalidCredentials
+					dn := string(args[1].content)
+					pw := string(args[2].content)
+					if dn == "cn=admin" && pw == "secret" {
+						code = resultSuccess
+					}
+					for _, u := range users {
+						if u.dn == dn && u.password == pw {
+							code = resultSuccess
+						}
+					}
+					reply(result(opBindResponse, code))
+				case opSearchRequest:
+					filter, _ := parseElements(args[6].content)
+					uid := string(filter[1].content)
+					for _, u := range users {
+						if u.uid != uid {
+							continue
+						}
+						var vals [][]byte
+						for _, g := range u.groups {
+							vals = append(vals,
+								berString(0x04, g))
+						}
+						reply(tlv(opSearchEntry,
+							berString(0x04, u.dn),
+							tlv(0x30, tlv(0x30,
+								berString(0x04,
+									"memberOf"),
+								tlv(0x31, vals...),
+							)),
+						))
+					}
+					reply(result(opSearchDone, resultSuccess))
+				case opUnbindRequest:
+					return
+				}
+			}
+		}(c)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	go fakeServer(l, []fakeUser{
+		{"uid=vimes,dc=example", "vimes", "pw", []string{"cn=watch"}},
+		{"uid=dup1,dc=example", "dup", "pw", nil},
+		{"uid=dup2,dc=example", "dup", "pw", nil},
+	})
+
+	conf := &Config{
+		URL:          "ldap://" + l.Addr().String(),
+		BindDN:       "cn=admin",
+		BindPassword: "secret",
+		Base:         "dc=example",
+	}
+
+	groups, err := Authenticate(conf, "vimes", "pw")
+	if err != nil || !reflect.DeepEqual(groups, []string{"cn=watch"}) {
+		t.Errorf("Authenticate: %v %v", groups, err)
+	}
+
+	tests := []struct{ username, password string }{
+		{"vimes", "wrong"},
+		{"vimes", ""},
+		{"nobody", "pw"},
+		{"dup", "pw"},
+	}
+	for _, test := range tests {
+		_, err = Authenticate(conf, test.username, test.password)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate %v %v: got %v",
+				test.username, test.password, err)
+		}
+	}
+
+	conf.BindPassword = "wrong"
+	_, err = Authenticate(conf, "vimes", "pw")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate (bad bind password): got %v", err)
+	}
+}
@@ -61,6 +61,13 @@ func (m *Map) Map(seqno uint16, pid uint16) (bool, uint16, uint16) {
 	return m.direct(seqno)
 }
 
+// Reset forgets all mappings.
+func (m *Map) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset()
+}
+
 func (m *Map) reset() {
 	m.next = 0
 	m.nextPid = 0
@@ -0,0 +1,69 @@
+package rtpconn
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// The Opus encoder parameters of a sender are adjusted according to the
+// loss measured on its uplink: in-band FEC is enabled when losses become
+// significant, and longer packets are used when losses are high.
+
+const (
+	// the loss rate above which in-band FEC is enabled
+	fecEnableLoss = 0.03
+	// the loss rate below which in-band FEC is disabled
+	fecDisableLoss = 0.01
+	// the loss rate above which longer packets are used
+	longPTimeLoss = 0.10
+	// the loss rate below which we switch back to normal packets
+	shortPTimeLoss = 0.05
+	// the weight of a new sample in the loss average
+	lossWeight = 0.25
+)
+
+type audioParams struct {
+	FEC   bool `json:"fec"`
+	PTime int  `json:"ptime"`
+}
+
+var defaultAudioParams = audioParams{FEC: false, PTime: 20}
+
+// chooseAudioParams returns the parameters that should be used given
+// a smoothed loss rate.  The thresholds have some hysteresis in order to
+// avoid oscillating.
+func chooseAudioParams(loss float64, old audioParams) audioParams {
+	p := old
+	if loss >= fecEnableLoss {
+		p.FEC = true
+	} else if loss < fecDisableLoss {
+		p.FEC = false
+	}
+	if loss >= longPTimeLoss {
+		p.PTime = 40
+	} else if loss < shortPTimeLoss {
+		p.PTime = 20
+	}
+	return p
+}
+
+// updateAudioParams records a sample of the loss rate, expressed as
+// a fraction of 256, and returns the new parameters if they changed.
+// Only called by sendUpRTCP.
+func (t *rtpUpTrack) updateAudioParams(fractionLost uint32) (audioParams, bool) {
+	if !strings.EqualFold(t.track.Codec().MimeType, webrtc.MimeTypeOpus) {
+		return audioParams{}, false
+	}
+
+	loss := float64(fractionLost) / 256
+	t.lossAverage = lossWeight*loss + (1-lossWeight)*t.lossAverage
+
+	old := t.audioParams
+	if old == (audioParams{}) {
+		old = defaultAudioParams
+	}
+	p := chooseAudioParams(t.lossAverage, old)
+	t.audioParams = p
+	return p, p != old
+}
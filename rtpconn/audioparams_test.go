@@ -0,0 +1,28 @@
+package rtpconn
+
+import (
+	"testing"
+)
+
+func TestChooseAudioParams(t *testing.T) {
+	tests := []struct {
+		loss     float64
+		old, new audioParams
+	}{
+		{0, defaultAudioParams, defaultAudioParams},
+		{0.02, defaultAudioParams, defaultAudioParams},
+		{0.05, defaultAudioParams, audioParams{true, 20}},
+		{0.02, audioParams{true, 20}, audioParams{true, 20}},
+		{0.005, audioParams{true, 20}, defaultAudioParams},
+		{0.2, defaultAudioParams, audioParams{true, 40}},
+		{0.07, audioParams{true, 40}, audioParams{true, 40}},
+		{0.04, audioParams{true, 40}, audioParams{true, 20}},
+	}
+	for _, test := range tests {
+		p := chooseAudioParams(test.loss, test.old)
+		if p != test.new {
+			t.Errorf("chooseAudioParams(%v, %v) = %v, expected %v",
+				test.loss, test.old, p, test.new)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package rtpconn
+
+import (
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/rtptime"
+)
+
+// audioAllowance is the bandwidth reserved for every audio track when
+// sharing the TWCC estimate of a connection between its tracks.
+const audioAllowance = 64 * 1000
+
+// twccShare returns the share of the connection's TWCC estimate that is
+// available to a single video track, or ^uint64(0) if there is no recent
+// estimate.
+func (down *rtpDownConnection) twccShare(now uint64) uint64 {
+	r := down.twcc.Get(now)
+	if r == 0 || r == ^uint64(0) {
+		return ^uint64(0)
+	}
+	audio := uint64(down.audioTracks.Load()) * audioAllowance
+	video := uint64(down.videoTracks.Load())
+	if video == 0 {
+		return r
+	}
+	if r < audio+video*minLossRate {
+		return minLossRate
+	}
+	return (r - audio) / video
+}
+
+// trackCount updates the number of tracks of a given kind.
+func (down *rtpDownConnection) trackCount(kind webrtc.RTPCodecType, delta int32) {
+	if kind == webrtc.RTPCodecTypeVideo {
+		down.videoTracks.Add(delta)
+	} else {
+		down.audioTracks.Add(delta)
+	}
+}
+
+// Switching layers up as soon as the estimate allows it causes quality
+// to flap on links with fluctuating throughput.  We therefore require the
+// estimate to stay high for a while before switching up, and refrain from
+// switching up for a while after switching down.  When a switch up is
+// quickly followed by a switch down, the hold-down time is doubled.
+const (
+	layerUpDelay       = 2 * rtptime.JiffiesPerSec
+	minLayerHoldDown   = 4 * rtptime.JiffiesPerSec
+	maxLayerHoldDown   = 60 * rtptime.JiffiesPerSec
+	layerStableTimeout = 2 * maxLayerHoldDown
+)
+
+type layerHysteresis struct {
+	upSince  uint64
+	lastUp   uint64
+	lastDown uint64
+	holdDown uint64
+}
+
+// up is called whenever the estimate would allow switching up.  It
+// returns true if we should actually switch up.
+func (h *layerHysteresis) up(now uint64) bool {
+	if h.holdDown == 0 {
+		h.holdDown = minLayerHoldDown
+	}
+	if h.upSince == 0 {
+		h.upSince = now
+	}
+	if now-h.upSince < layerUpDelay {
+		return false
+	}
+	if h.lastDown != 0 && now-h.lastDown < h.holdDown {
+		return false
+	}
+	if h.lastDown == 0 || now-h.lastDown > layerStableTimeout {
+		h.holdDown = minLayerHoldDown
+	}
+	h.upSince = 0
+	h.lastUp = now
+	return true
+}
+
+// steady is called when the estimate requires no change.
+func (h *layerHysteresis) steady(now uint64) {
+	h.upSince = 0
+}
+
+// down is called when switching down.
+func (h *layerHysteresis) down(now uint64) {
+	if h.holdDown == 0 {
+		h.holdDown = minLayerHoldDown
+	}
+	if h.lastUp != 0 && now-h.lastUp < h.holdDown {
+		// we switched up too early
+		h.holdDown *= 2
+		if h.holdDown > maxLayerHoldDown {
+			h.holdDown = maxLayerHoldDown
+		}
+	}
+	h.upSince = 0
+	h.lastDown = now
+}
@@ -0,0 +1,110 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestTwccShare(t *testing.T) {
+	now := rtptime.Jiffies()
+	var down rtpDownConnection
+
+	if r := down.twccShare(now); r != ^uint64(0) {
+		t.Errorf("No estimate: got %v", r)
+	}
+
+	down.twcc.Set(1000000, now)
+	if r := down.twccShare(now); r != 1000000 {
+		t.Errorf("No tracks: got %v", r)
+	}
+
+	down.trackCount(webrtc.RTPCodecTypeAudio, 1)
+	down.trackCount(webrtc.RTPCodecTypeVideo, 1)
+	down.trackCount(webrtc.RTPCodecTypeVideo, 1)
+	expected := uint64((1000000 - audioAllowance) / 2)
+	if r := down.twccShare(now); r != expected {
+		t.Errorf("Expected %v, got %v", expected, r)
+	}
+
+	down.twcc.Set(10000, now)
+	if r := down.twccShare(now); r != minLossRate {
+		t.Errorf("Low estimate: expected %v, got %v", minLossRate, r)
+	}
+
+	down.trackCount(webrtc.RTPCodecTypeVideo, -1)
+	if down.videoTracks.Load() != 1 || down.audioTracks.Load() != 1 {
+		t.Errorf("Track count: %v %v",
+			down.videoTracks.Load(), down.audioTracks.Load())
+	}
+
+	if r := down.twccShare(now + 60*rtptime.JiffiesPerSec); r != ^uint64(0) {
+		t.Errorf("Stale estimate: got %v", r)
+	}
+}
+
+func TestLayerHysteresis(t *testing.T) {
+	var h layerHysteresis
+	now := uint64(1000 * rtptime.JiffiesPerSec)
+	sec := uint64(rtptime.JiffiesPerSec)
+
+	if h.up(now) {
+		t.Errorf("Switched up immediately")
+	}
+	if h.up(now + sec) {
+		t.Errorf("Switched up too early")
+	}
+	h.steady(now + sec)
+	if h.up(now + 2*sec) {
+		t.Errorf("Switched up after steady")
+	}
+	if !h.up(now + 4*sec) {
+		t.Errorf("Didn't switch up")
+	}
+	now += 4 * sec
+
+	// switching down soon after switching up doubles the hold-down
+	h.down(now + sec)
+	if h.holdDown != 2*minLayerHoldDown {
+		t.Errorf("Expected hold-down %v, got %v",
+			2*minLayerHoldDown, h.holdDown)
+	}
+	now += sec
+
+	h.up(now + sec)
+	if h.up(now + 2*minLayerHoldDown - sec) {
+		t.Errorf("Switched up during hold-down")
+	}
+	if !h.up(now + 2*minLayerHoldDown) {
+		t.Errorf("Didn't switch up after hold-down")
+	}
+	now += 2 * minLayerHoldDown
+
+	for i := 0; i < 10; i++ {
+		h.down(now + sec)
+		now += sec
+		h.up(now + h.holdDown - layerUpDelay)
+		if !h.up(now + h.holdDown) {
+			t.Fatalf("Didn't switch up")
+		}
+		now += h.holdDown
+	}
+	if h.holdDown != maxLayerHoldDown {
+		t.Errorf("Expected hold-down %v, got %v",
+			maxLayerHoldDown, h.holdDown)
+	}
+
+	// a long stable period resets the hold-down
+	h.down(now + maxLayerHoldDown)
+	now += maxLayerHoldDown
+	h.up(now + layerStableTimeout)
+	if !h.up(now + layerStableTimeout + layerUpDelay) {
+		t.Errorf("Didn't switch up after stable period")
+	}
+	if h.holdDown != minLayerHoldDown {
+		t.Errorf("Expected hold-down %v, got %v",
+			minLayerHoldDown, h.holdDown)
+	}
+}
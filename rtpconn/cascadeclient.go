@@ -0,0 +1,235 @@
+package rtpconn
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+// A CascadeClient represents, in a local group, a client of a group on
+// another server whose streams are redistributed locally.  The streams
+// are negotiated with the upstream server by the cascade package, which
+// passes the offers to GotOffer; they are received through ordinary up
+// connections, which send NACKs and receiver reports to the upstream
+// server.  A CascadeClient is a system client, and never receives any
+// media.
+type CascadeClient struct {
+	group    *group.Group
+	addr     net.Addr
+	id       string
+	username string
+	done     chan struct{}
+
+	mu     sync.Mutex
+	up     map[string]*rtpUpConnection
+	closed bool
+}
+
+// NewCascadeClient creates a client that mirrors the client with the
+// given id and username of a group on the server at address addr.
+func NewCascadeClient(g *group.Group, id, username string, addr net.Addr) *CascadeClient {
+	return &CascadeClient{
+		group:    g,
+		addr:     addr,
+		id:       id,
+		username: username,
+		done:     make(chan struct{}),
+		up:       make(map[string]*rtpUpConnection),
+	}
+}
+
+func (c *CascadeClient) Group() *group.Group {
+	return c.group
+}
+
+func (c *CascadeClient) Addr() net.Addr {
+	return c.addr
+}
+
+func (c *CascadeClient) Id() string {
+	return c.id
+}
+
+func (c *CascadeClient) Username() string {
+	return c.username
+}
+
+func (c *CascadeClient) SetUsername(username string) {
+}
+
+func (c *CascadeClient) Permissions() []string {
+	return []string{"system"}
+}
+
+func (c *CascadeClient) SetPermissions(perms []string) {
+}
+
+func (c *CascadeClient) Data() map[string]interface{} {
+	return nil
+}
+
+// Done returns a channel that is closed when the client is closed, for
+// example because it was kicked.
+func (c *CascadeClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// GotOffer applies an offer for the stream with the given id received
+// from the upstream server, creating the stream if necessary, and
+// returns the answer, which includes all of our ICE candidates.  The
+// function failed is called if the ICE connection of the stream fails.
+// It must be called after the client has joined the group.
+func (c *CascadeClient) GotOffer(ctx context.Context, id, label, offer string, failed func()) (string, error) {
+	c.mu.Lock()
+	up := c.up[id]
+	c.mu.Unlock()
+
+	if up == nil {
+		var err error
+		up, err = newUpConn(c, id, label, offer)
+		if err != nil {
+			return "", err
+		}
+		up.pc.OnICEConnectionStateChange(
+			func(state webrtc.ICEConnectionState) {
+				switch state {
+				case webrtc.ICEConnectionStateConnected:
+					if up.recorded.CompareAndSwap(false, true) {
+						recordConnection(up.pc, c.addr)
+					}
+				case webrtc.ICEConnectionStateFailed:
+					failed()
+				}
+			})
+		c.mu.Lock()
+		if c.closed || c.up[id] != nil {
+			c.mu.Unlock()
+			c.closeConn(up)
+			return "", errors.New("duplicate connection")
+		}
+		c.up[id] = up
+		c.mu.Unlock()
+	}
+
+	err := up.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	})
+	if err != nil {
+		return "", err
+	}
+	err = up.flushICECandidates()
+	if err != nil {
+		log.Printf("ICE: %v", err)
+	}
+	answer, err := localDescription(ctx, up.pc, false)
+	if err != nil {
+		return "", err
+	}
+	return answer.SDP, nil
+}
+
+// GotICE adds a remote ICE candidate to the stream with the given id.
+func (c *CascadeClient) GotICE(id string, candidate *webrtc.ICECandidateInit) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	up := c.up[id]
+	if up == nil {
+		return os.ErrNotExist
+	}
+	return up.addICECandidate(candidate)
+}
+
+// Unpublish closes the stream with the given id, if any.
+func (c *CascadeClient) Unpublish(id string) {
+	c.mu.Lock()
+	up := c.up[id]
+	if up == nil {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.up, id)
+	c.mu.Unlock()
+
+	c.closeConn(up)
+}
+
+func (c *CascadeClient) closeConn(up *rtpUpConnection) {
+	up.mu.Lock()
+	up.closed = true
+	up.mu.Unlock()
+	up.pc.OnICEConnectionStateChange(nil)
+	up.pc.Close()
+	updateSpeakers(c.group)
+	for _, cc := range c.group.GetClients(c) {
+		cc.PushConn(c.group, up.Id(), nil, nil, "")
+	}
+}
+
+func (c *CascadeClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+func (c *CascadeClient) PushClient(group, kind, id, username string, permissions []string, status map[string]interface{}) error {
+	return nil
+}
+
+func (c *CascadeClient) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *CascadeClient) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	var ups []*rtpUpConnection
+	for _, up := range c.up {
+		if id == "" || id == up.Id() {
+			ups = append(ups, up)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, up := range ups {
+		tracks := up.getTracks()
+		ts := make([]conn.UpTrack, len(tracks))
+		for i, t := range tracks {
+			ts[i] = t
+		}
+		target.PushConn(g, up.Id(), up, ts, "")
+	}
+	return nil
+}
+
+func (c *CascadeClient) Kick(id string, user *string, message string) error {
+	return c.Close()
+}
+
+func (c *CascadeClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	ups := c.up
+	c.up = make(map[string]*rtpUpConnection)
+	c.mu.Unlock()
+
+	for _, up := range ups {
+		c.closeConn(up)
+	}
+	group.DelClient(c)
+	return nil
+}
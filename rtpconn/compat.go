@@ -0,0 +1,172 @@
+package rtpconn
+
+import (
+	"strings"
+
+	"github.com/pion/sdp/v3"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/stats"
+)
+
+// Some clients, typically old browsers, lack features that Galene relies
+// on.  We detect them whenever we receive a session description from
+// a client, adapt further negotiation on that client, and keep count of
+// the affected clients in the connection statistics.
+
+const (
+	// the client uses Plan B rather than Unified Plan
+	quirkPlanB = 1 << iota
+	// the client doesn't do transport-wide congestion control
+	quirkNoTransportCC
+	// the client doesn't send NACKs for video
+	quirkNoNACK
+	// the client doesn't send audio levels
+	quirkNoAudioLevel
+)
+
+var quirkNames = []string{
+	"plan-b", "no-transport-cc", "no-nack", "no-audio-level",
+}
+
+var ErrPlanB = group.UserError(
+	"your browser uses an obsolete version of WebRTC, please upgrade it",
+)
+
+// sdpHasRtcpFb returns true if a media section has the given feedback
+// type, with no parameter.
+func sdpHasRtcpFb(m *sdp.MediaDescription, typ string) bool {
+	for _, a := range m.Attributes {
+		if a.Key != "rtcp-fb" {
+			continue
+		}
+		f := strings.Fields(a.Value)
+		if len(f) == 2 && strings.EqualFold(f[1], typ) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasExtmap(m *sdp.MediaDescription, uri string) bool {
+	for _, a := range m.Attributes {
+		if a.Key != "extmap" {
+			continue
+		}
+		f := strings.Fields(a.Value)
+		if len(f) >= 2 && f[1] == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// streamCount returns the number of distinct media streams carried by
+// a media section.  This is never more than one with Unified Plan.
+func streamCount(m *sdp.MediaDescription) int {
+	streams := make(map[string]struct{})
+	for _, a := range m.Attributes {
+		var msid string
+		switch a.Key {
+		case "msid":
+			f := strings.Fields(a.Value)
+			if len(f) > 0 {
+				msid = f[0]
+			}
+		case "ssrc":
+			f := strings.Fields(a.Value)
+			if len(f) >= 2 && strings.HasPrefix(f[1], "msid:") {
+				msid = strings.TrimPrefix(f[1], "msid:")
+			}
+		}
+		if msid != "" && msid != "-" {
+			streams[msid] = struct{}{}
+		}
+	}
+	return len(streams)
+}
+
+// sdpQuirks returns the set of quirks exhibited by a session description.
+func sdpQuirks(desc string) (uint32, error) {
+	var s sdp.SessionDescription
+	err := s.Unmarshal([]byte(desc))
+	if err != nil {
+		return 0, err
+	}
+	var quirks uint32
+	for _, m := range s.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		_, inactive := m.Attribute("inactive")
+		if inactive {
+			continue
+		}
+		if streamCount(m) > 1 {
+			quirks |= quirkPlanB
+		}
+		switch m.MediaName.Media {
+		case "audio":
+			if !hasExtmap(m, sdp.AudioLevelURI) {
+				quirks |= quirkNoAudioLevel
+			}
+		case "video":
+			if !sdpHasRtcpFb(m, "transport-cc") {
+				quirks |= quirkNoTransportCC
+			}
+			if !sdpHasRtcpFb(m, "nack") {
+				quirks |= quirkNoNACK
+			}
+		}
+	}
+	return quirks, nil
+}
+
+// hasQuirk returns true if the client has been seen to exhibit the quirk.
+func (c *webClient) hasQuirk(quirk uint32) bool {
+	return c.quirks.Load()&quirk != 0
+}
+
+// checkQuirks records the quirks exhibited by a session description
+// received from the client, and returns an error if the client cannot
+// be served.  If desc is an answer, offer is the offer that we sent, and
+// features that we didn't offer are not held against the client.
+func checkQuirks(c *webClient, desc, offer string) error {
+	quirks, err := sdpQuirks(desc)
+	if err != nil {
+		return err
+	}
+	if offer != "" {
+		q, err := sdpQuirks(offer)
+		if err != nil {
+			return err
+		}
+		quirks &^= q
+	}
+	for {
+		old := c.quirks.Load()
+		if c.quirks.CompareAndSwap(old, old|quirks) {
+			for i, name := range quirkNames {
+				if quirks&^old&(1<<i) != 0 {
+					stats.AddLegacyClient(name, 1)
+				}
+			}
+			break
+		}
+	}
+	if quirks&quirkPlanB != 0 {
+		return ErrPlanB
+	}
+	return nil
+}
+
+// forgetQuirks removes the client from the statistics.  It is called
+// when the client disconnects.
+func (c *webClient) forgetQuirks() {
+	quirks := c.quirks.Swap(0)
+	for i, name := range quirkNames {
+		if quirks&(1<<i) != 0 {
+			stats.AddLegacyClient(name, -1)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/stats"
+)
+
+const compatHeader = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n"
+
+const modernAudio = "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=extmap:1 urn:ietf:params:rtp-hdrext:ssrc-audio-level\r\n" +
+	"a=msid:stream audio\r\n"
+
+const modernVideo = "m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"a=rtcp-fb:96 transport-cc\r\n" +
+	"a=rtcp-fb:96 nack\r\n" +
+	"a=rtcp-fb:96 nack pli\r\n" +
+	"a=msid:stream video\r\n"
+
+const legacyVideo = "m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"a=rtcp-fb:96 nack pli\r\n" +
+	"a=rtcp-fb:96 goog-remb\r\n" +
+	"a=ssrc:1 msid:stream1 video1\r\n" +
+	"a=ssrc:2 msid:stream2 video2\r\n"
+
+func TestSDPQuirks(t *testing.T) {
+	tests := []struct {
+		sdp    string
+		quirks uint32
+	}{
+		{compatHeader + modernAudio + modernVideo, 0},
+		{
+			compatHeader + "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n",
+			quirkNoAudioLevel,
+		},
+		{
+			compatHeader + modernAudio + legacyVideo,
+			quirkPlanB | quirkNoTransportCC | quirkNoNACK,
+		},
+		{
+			compatHeader + modernAudio +
+				"m=video 0 UDP/TLS/RTP/SAVPF 96\r\n",
+			0,
+		},
+	}
+	for _, test := range tests {
+		quirks, err := sdpQuirks(test.sdp)
+		if err != nil || quirks != test.quirks {
+			t.Errorf("sdpQuirks: got %v %v, expected %v",
+				quirks, err, test.quirks)
+		}
+	}
+}
+
+func TestCheckQuirks(t *testing.T) {
+	c := &webClient{}
+	offer := compatHeader + "m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+		"a=rtcp-fb:96 nack\r\n"
+	answer := compatHeader + "m=video 9 UDP/TLS/RTP/SAVPF 96\r\n"
+
+	// we didn't offer transport-cc
+	err := checkQuirks(c, answer, offer)
+	if err != nil {
+		t.Fatalf("checkQuirks: %v", err)
+	}
+	if c.hasQuirk(quirkNoTransportCC) || !c.hasQuirk(quirkNoNACK) {
+		t.Errorf("Unexpected quirks %v", c.quirks.Load())
+	}
+	if stats.GetConnections().Legacy["no-nack"] != 1 {
+		t.Errorf("Legacy client not counted: %v",
+			stats.GetConnections().Legacy)
+	}
+
+	err = checkQuirks(c, compatHeader+modernAudio+legacyVideo, "")
+	if err != ErrPlanB {
+		t.Errorf("Expected ErrPlanB, got %v", err)
+	}
+	// counted once only
+	if stats.GetConnections().Legacy["no-nack"] != 1 {
+		t.Errorf("Legacy client counted twice: %v",
+			stats.GetConnections().Legacy)
+	}
+
+	c.forgetQuirks()
+	if l := stats.GetConnections().Legacy; len(l) != 0 {
+		t.Errorf("Legacy clients remain: %v", l)
+	}
+}
@@ -0,0 +1,76 @@
+package rtpconn
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/codecs"
+)
+
+// AV1 senders describe their temporal and spatial layers in the
+// dependency descriptor header extension rather than in the payload.  We
+// keep this extension in the packets that we cache, parse it when
+// forwarding, and strip it before sending packets downstream.  The
+// dependency structure is only sent with some frames, so readLoop
+// remembers the latest one.
+
+// dependencyExtension returns the id of the dependency descriptor
+// extension negotiated on a receiver for a given codec, or 0 if none.
+func dependencyExtension(receiver *webrtc.RTPReceiver, codec string) uint8 {
+	if !strings.EqualFold(codec, "video/av1") {
+		return 0
+	}
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == codecs.DependencyDescriptorURI {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+// gotDependencyDescriptor is called by readLoop for every packet.  It
+// records the dependency structure, if any, and strips all header
+// extensions except the dependency descriptor.  It returns true if the
+// packet was modified.
+func (up *rtpUpTrack) gotDependencyDescriptor(packet *rtp.Packet) bool {
+	if !packet.Extension {
+		return false
+	}
+	ext := packet.GetExtension(up.ddExtension)
+	if ext != nil {
+		d, err := codecs.ParseDependencyDescriptor(
+			ext, up.ddStructure.Load(),
+		)
+		if err == nil && d.Structure != nil {
+			up.ddStructure.Store(d.Structure)
+		}
+		ext = append([]byte(nil), ext...)
+	}
+	if ext != nil && len(packet.Extensions) == 1 {
+		return false
+	}
+	packet.Extension = false
+	packet.Extensions = nil
+	if ext != nil {
+		packet.SetExtension(up.ddExtension, ext)
+	}
+	return true
+}
+
+// packetFlags returns the flags of a packet, using the dependency
+// descriptor if available.
+func (down *rtpDownTrack) packetFlags(codec string, buf []byte) (codecs.Flags, error) {
+	up, ok := down.getRemote().(*rtpUpTrack)
+	if !ok || up.ddExtension == 0 {
+		return codecs.PacketFlags(codec, buf)
+	}
+	flags, _, err := codecs.DependencyFlags(
+		codec, buf, up.ddExtension, up.ddStructure.Load(),
+	)
+	if err != nil {
+		return codecs.PacketFlags(codec, buf)
+	}
+	return flags, nil
+}
@@ -0,0 +1,40 @@
+package rtpconn
+
+// Clients that implement end-to-end encryption (SFrame or insertable
+// streams) encrypt the media frames before packetisation, so the payload
+// headers that we normally parse (keyframe bits, temporal and spatial
+// layer indices, picture ids) are meaningless.  The sender lists the ids
+// of such tracks in the offer, and we forward their packets opaquely:
+// we don't drop layers, we don't rewrite picture ids, and we request a
+// keyframe instead of waiting for one whenever a receiver needs to
+// resynchronise.
+
+// setEncrypted records the ids of the tracks that carry encrypted
+// frames.
+func (up *rtpUpConnection) setEncrypted(ids []string) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	up.encrypted = ids
+	for _, t := range up.tracks {
+		t.encrypted.Store(member(t.track.ID(), ids))
+	}
+}
+
+// encrypted returns true if the down track carries encrypted frames.
+func (down *rtpDownTrack) encrypted() bool {
+	up, ok := down.getRemote().(*rtpUpTrack)
+	return ok && up.encrypted.Load()
+}
+
+// encryptedTracks returns the ids of the tracks of down that carry
+// encrypted frames, which are the same as the ids of the corresponding
+// up tracks.
+func encryptedTracks(down *rtpDownConnection) []string {
+	var ids []string
+	for _, t := range down.getTracks() {
+		if t.encrypted() && !member(t.track.ID(), ids) {
+			ids = append(ids, t.track.ID())
+		}
+	}
+	return ids
+}
@@ -0,0 +1,82 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/estimator"
+	"github.com/jech/galene/unbounded"
+)
+
+type vp8Track struct {
+	conn.UpTrack
+}
+
+func (t vp8Track) Codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: "video/VP8"}
+}
+
+func TestEncryptedWrite(t *testing.T) {
+	newDown := func(remote conn.UpTrack) *rtpDownTrack {
+		local, err := webrtc.NewTrackLocalStaticRTP(
+			webrtc.RTPCodecCapability{
+				MimeType:  "video/VP8",
+				ClockRate: 90000,
+			}, "video", "s",
+		)
+		if err != nil {
+			t.Fatalf("NewTrackLocalStaticRTP: %v", err)
+		}
+		down := &rtpDownTrack{
+			track:   local,
+			remote:  remote,
+			rate:    estimator.New(time.Second),
+			atomics: &downTrackAtomics{},
+		}
+		down.setLayerInfo(layerInfo{maxTid: 2})
+		return down
+	}
+
+	// two VP8 delta frames, in temporal layers 0 and 2, the latter of
+	// which is not being forwarded.  If the frames are encrypted, the
+	// payload is garbage.
+	first := []byte{
+		0x80, 0xe0, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x01,
+		0x90, 0xa0, 0x04, 0x00, 0x01, 0x42,
+	}
+	packet := []byte{
+		0x80, 0xe0, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x01,
+		0x90, 0xa0, 0x05, 0x80, 0x01, 0x42,
+	}
+
+	down := newDown(vp8Track{})
+	n, err := down.Write(first)
+	if err != nil || n != len(first) {
+		t.Errorf("Expected %v, got %v %v", len(first), n, err)
+	}
+	n, err = down.Write(packet)
+	if err != nil || n != 0 {
+		t.Errorf("Plain packet: expected 0, got %v %v", n, err)
+	}
+
+	up := &rtpUpTrack{
+		track:   &webrtc.TrackRemote{},
+		actions: unbounded.New[trackAction](),
+	}
+	up.encrypted.Store(true)
+	down = newDown(up)
+	if !down.encrypted() {
+		t.Errorf("Track is not encrypted")
+	}
+	down.Write(first)
+	n, err = down.Write(packet)
+	if err != nil || n != len(packet) {
+		t.Errorf("Encrypted packet: expected %v, got %v %v",
+			len(packet), n, err)
+	}
+}
@@ -0,0 +1,203 @@
+package rtpconn
+
+import (
+	"log"
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+// A group may allow multiple video codecs, and not all receivers are
+// able to decode all of them.  Receivers declare the codecs that they can
+// decode when they join; if a receiver cannot decode the video of
+// a stream, we ask the sender to publish a fallback copy of the stream
+// encoded with a codec that the receiver supports.  The fallback is only
+// sent to the receivers that need it, and it is aborted when no such
+// receivers remain.
+
+// setCodecs records the codecs that a client is able to decode.  An
+// empty list means that the client didn't say, in which case we assume
+// that it can decode everything.
+func (c *webClient) setCodecs(codecs []string) {
+	var cs []string
+	for _, codec := range codecs {
+		cs = append(cs, strings.ToLower(codec))
+	}
+	c.mu.Lock()
+	c.codecs = cs
+	c.mu.Unlock()
+}
+
+func (c *webClient) getCodecs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.codecs
+}
+
+// decodes returns true if a client that declared codecs is able to decode
+// a given codec.
+func decodes(codecs []string, mimeType string) bool {
+	return len(codecs) == 0 || member(strings.ToLower(mimeType), codecs)
+}
+
+// canDecode returns true if c is able to decode the track t.
+func (c *webClient) canDecode(t conn.UpTrack) bool {
+	codecs := c.getCodecs()
+	if len(codecs) == 0 {
+		return true
+	}
+	return decodes(codecs, t.Codec().MimeType)
+}
+
+// canDecodeVideo returns true if c is able to decode at least one of the
+// video tracks of a stream, or if the stream has no video.
+func canDecodeVideo(c *webClient, tracks []*rtpUpTrack) bool {
+	video := false
+	for _, t := range tracks {
+		if t.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if c.canDecode(t) {
+			return true
+		}
+		video = true
+	}
+	return !video
+}
+
+// fallbackFor returns the id of the stream that up is a fallback for, or
+// the empty string if it is an ordinary stream.
+func (up *rtpUpConnection) fallbackFor() string {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	return up.fallback
+}
+
+// findFallback returns the fallback of the stream with the given id
+// published by c, if any.
+func findFallback(c *webClient, id string) *rtpUpConnection {
+	for _, u := range getUpConns(c) {
+		if u.fallbackFor() == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// fallbackRecipient returns false if up is a fallback and c is not a web
+// client, for example a disk writer, which has no use for it.
+func fallbackRecipient(up *rtpUpConnection, c group.Client) bool {
+	if up.fallbackFor() == "" {
+		return true
+	}
+	_, ok := c.(*webClient)
+	return ok
+}
+
+// fallbackTracks is called when pushing the stream up to c.  It returns
+// the tracks that should be sent to c, which may be empty if c should
+// get a fallback instead.
+func fallbackTracks(c *webClient, up conn.Up, requested []conn.UpTrack) []conn.UpTrack {
+	u, ok := up.(*rtpUpConnection)
+	if !ok {
+		return requested
+	}
+	sender, ok := u.client.(*webClient)
+	if !ok {
+		return requested
+	}
+
+	if fallback := u.fallbackFor(); fallback != "" {
+		orig := getUpConn(sender, fallback)
+		if orig == nil || canDecodeVideo(c, orig.getTracks()) {
+			return nil
+		}
+		id := downConnId(c, orig.id)
+		if len(requested) > 0 && getDownConn(c, id) != nil {
+			// c gets the fallback instead of the original
+			closeDownConn(c, id, "")
+		}
+		return requested
+	}
+
+	if canDecodeVideo(c, u.getTracks()) {
+		return requested
+	}
+	if findFallback(sender, u.id) != nil {
+		return nil
+	}
+	requestFallback(c, sender, u)
+	return requested
+}
+
+// requestFallback asks the sender of up to publish a fallback that c is
+// able to decode.
+func requestFallback(c *webClient, sender *webClient, up *rtpUpConnection) {
+	g := c.group
+	if g == nil {
+		return
+	}
+	codecs := c.getCodecs()
+	codec := ""
+	for _, cc := range g.Codecs() {
+		if strings.HasPrefix(strings.ToLower(cc.MimeType), "video/") &&
+			decodes(codecs, cc.MimeType) {
+			codec = cc.MimeType
+			break
+		}
+	}
+	if codec == "" {
+		return
+	}
+
+	up.mu.Lock()
+	requested := up.fallbackRequested
+	up.fallbackRequested = true
+	up.mu.Unlock()
+	if requested {
+		return
+	}
+
+	err := sender.write(clientMessage{
+		Type:  "fallback",
+		Id:    up.id,
+		Value: codec,
+	})
+	if err != nil {
+		log.Printf("Request fallback: %v", err)
+	}
+}
+
+// checkFallbacks aborts the fallbacks published by c that are no longer
+// needed.  Called from c's client loop.
+func checkFallbacks(c *webClient, g *group.Group) {
+	clients := g.GetClients(c)
+outer:
+	for _, u := range getUpConns(c) {
+		fallback := u.fallbackFor()
+		if fallback == "" {
+			continue
+		}
+		orig := getUpConn(c, fallback)
+		if orig != nil {
+			tracks := orig.getTracks()
+			for _, cc := range clients {
+				wc, ok := cc.(*webClient)
+				if ok && !canDecodeVideo(wc, tracks) {
+					continue outer
+				}
+			}
+			orig.mu.Lock()
+			orig.fallbackRequested = false
+			orig.mu.Unlock()
+		}
+		delUpConn(c, u.id, c.id, true)
+		c.write(clientMessage{
+			Type: "abort",
+			Id:   u.id,
+		})
+	}
+}
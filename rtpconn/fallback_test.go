@@ -0,0 +1,53 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+)
+
+type codecTrack struct {
+	conn.UpTrack
+	kind     webrtc.RTPCodecType
+	mimeType string
+}
+
+func (t codecTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+func (t codecTrack) Codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: t.mimeType}
+}
+
+func TestRequestedTracksCodecs(t *testing.T) {
+	audio := codecTrack{kind: webrtc.RTPCodecTypeAudio, mimeType: "audio/opus"}
+	av1 := codecTrack{kind: webrtc.RTPCodecTypeVideo, mimeType: "video/AV1"}
+	vp8 := codecTrack{kind: webrtc.RTPCodecTypeVideo, mimeType: "video/VP8"}
+	tracks := []conn.UpTrack{audio, av1, vp8}
+	req := []string{"audio", "video"}
+
+	c := &webClient{}
+	ts, _ := requestedTracks(c, req, tracks)
+	if len(ts) != 2 || ts[1] != av1 {
+		t.Errorf("Expected audio and AV1, got %v", ts)
+	}
+
+	c.setCodecs([]string{"audio/opus", "video/vp8"})
+	if !decodes(c.getCodecs(), "video/VP8") ||
+		decodes(c.getCodecs(), "video/AV1") {
+		t.Errorf("Bad codecs %v", c.getCodecs())
+	}
+	ts, _ = requestedTracks(c, req, tracks)
+	if len(ts) != 2 || ts[1] != vp8 {
+		t.Errorf("Expected audio and VP8, got %v", ts)
+	}
+
+	c.setCodecs([]string{"audio/opus", "video/h264"})
+	ts, _ = requestedTracks(c, req, tracks)
+	if len(ts) != 1 || ts[0] != audio {
+		t.Errorf("Expected audio only, got %v", ts)
+	}
+}
@@ -0,0 +1,184 @@
+package rtpconn
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/interceptor/pkg/flexfec"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Forward error correction (FlexFEC, in the flexfec-03 dialect
+// implemented by Chromium) allows a receiver to recover lost packets
+// without waiting for a retransmission, which avoids freezes at receivers
+// with a large RTT.  After every fecMediaPackets video packets, we send
+// a number of repair packets on a separate SSRC.  Since repair packets
+// take bandwidth, their number depends on the loss rate reported by the
+// receiver, and none are sent while the receiver reports no loss.
+
+// fecMediaPackets is the number of media packets protected together.
+const fecMediaPackets = 10
+
+// maxFECPackets is the maximum number of repair packets sent for every
+// fecMediaPackets media packets.
+const maxFECPackets = 5
+
+// fecPackets returns the number of repair packets to send for every
+// fecMediaPackets media packets, given the fraction of packets lost
+// reported by the receiver, in units of 1/256.  We aim to send roughly
+// twice as many repair packets as are lost.
+func fecPackets(loss uint8) uint32 {
+	if loss < 3 {
+		// less than 1%, NACK is good enough
+		return 0
+	}
+	n := (uint32(loss)*2*fecMediaPackets + 255) / 256
+	return min(n, maxFECPackets)
+}
+
+// fecPayloadType returns the negotiated payload type of FlexFEC.
+func fecPayloadType(codecs []webrtc.RTPCodecParameters) (uint8, bool) {
+	for _, c := range codecs {
+		if strings.EqualFold(c.MimeType, webrtc.MimeTypeFlexFEC03) {
+			return uint8(c.PayloadType), true
+		}
+	}
+	return 0, false
+}
+
+// fecTrack is a local video track that protects the packets it sends
+// with FlexFEC if the receiver supports it.
+type fecTrack struct {
+	*rtxTrack
+	count atomic.Uint32
+
+	fecMu   sync.Mutex
+	ssrc    uint32
+	ptype   uint8
+	encoder flexfec.FlexEncoder
+	writer  webrtc.TrackLocalWriter
+	packets []rtp.Packet
+}
+
+func newFECTrack(codec webrtc.RTPCodecCapability, id, msid string) (*fecTrack, error) {
+	local, err := newRTXTrack(codec, id, msid)
+	if err != nil {
+		return nil, err
+	}
+	return &fecTrack{rtxTrack: local}, nil
+}
+
+func (t *fecTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.rtxTrack.Bind(ctx)
+	if err != nil {
+		return codec, err
+	}
+
+	t.fecMu.Lock()
+	defer t.fecMu.Unlock()
+	t.writer = nil
+	t.packets = nil
+	ptype, ok := fecPayloadType(ctx.CodecParameters())
+	if ok && ctx.SSRCForwardErrorCorrection() != 0 {
+		t.ssrc = uint32(ctx.SSRC())
+		t.ptype = uint8(codec.PayloadType)
+		t.encoder = flexfec.NewFlexEncoder03(
+			ptype, uint32(ctx.SSRCForwardErrorCorrection()),
+		)
+		t.writer = ctx.WriteStream()
+	}
+	return codec, nil
+}
+
+func (t *fecTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.fecMu.Lock()
+	t.writer = nil
+	t.packets = nil
+	t.fecMu.Unlock()
+	return t.rtxTrack.Unbind(ctx)
+}
+
+// setLoss adapts the amount of redundancy to the loss rate reported by
+// the receiver.
+func (t *fecTrack) setLoss(loss uint8) {
+	t.count.Store(fecPackets(loss))
+}
+
+// mediaBitrate returns the part of the bitrate r that remains for media
+// packets once the repair packets have been sent.
+func (t *fecTrack) mediaBitrate(r uint64) uint64 {
+	count := uint64(t.count.Load())
+	t.fecMu.Lock()
+	active := t.writer != nil
+	t.fecMu.Unlock()
+	if !active || count == 0 {
+		return r
+	}
+	return r / (fecMediaPackets + count) * fecMediaPackets
+}
+
+func (t *fecTrack) Write(buf []byte) (int, error) {
+	n, err := t.rtxTrack.Write(buf)
+	if err != nil {
+		return n, err
+	}
+	t.protect(buf)
+	return n, nil
+}
+
+// protect records a packet that has just been sent, and sends repair
+// packets whenever enough packets have been recorded.  FEC is best
+// effort, so errors are ignored.
+func (t *fecTrack) protect(buf []byte) {
+	count := t.count.Load()
+
+	t.fecMu.Lock()
+	if t.writer == nil || count == 0 {
+		t.packets = nil
+		t.fecMu.Unlock()
+		return
+	}
+
+	var packet rtp.Packet
+	err := packet.Unmarshal(append([]byte(nil), buf...))
+	if err != nil {
+		t.fecMu.Unlock()
+		return
+	}
+
+	if n := len(t.packets); n > 0 {
+		delta := packet.SequenceNumber - t.packets[n-1].SequenceNumber
+		if delta == 0 || delta >= 0x8000 {
+			// a retransmission, it is already protected
+			t.fecMu.Unlock()
+			return
+		}
+		if delta != 1 {
+			// the encoder requires consecutive packets
+			t.packets = nil
+		}
+	}
+
+	// the receiver sees the SSRC and payload type of the binding
+	packet.SSRC = t.ssrc
+	packet.PayloadType = t.ptype
+	t.packets = append(t.packets, packet)
+	if len(t.packets) < fecMediaPackets {
+		t.fecMu.Unlock()
+		return
+	}
+
+	repair := t.encoder.EncodeFec(t.packets, count)
+	t.packets = nil
+	writer := t.writer
+	t.fecMu.Unlock()
+
+	for _, p := range repair {
+		_, err := writer.WriteRTP(&p.Header, p.Payload)
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package rtpconn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/interceptor/pkg/flexfec"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+)
+
+func TestFECPackets(t *testing.T) {
+	tests := []struct {
+		loss  uint8
+		count uint32
+	}{
+		{0, 0}, {2, 0}, {3, 1}, {13, 2}, {26, 3}, {51, 4}, {255, 5},
+	}
+	for _, test := range tests {
+		count := fecPackets(test.loss)
+		if count != test.count {
+			t.Errorf("Loss %v: expected %v, got %v",
+				test.loss, test.count, count)
+		}
+	}
+}
+
+type fecTestWriter struct {
+	packets []rtp.Packet
+}
+
+func (w *fecTestWriter) WriteRTP(header *rtp.Header, payload []byte) (int, error) {
+	w.packets = append(w.packets, rtp.Packet{
+		Header:  *header,
+		Payload: append([]byte(nil), payload...),
+	})
+	return len(payload), nil
+}
+
+func (w *fecTestWriter) Write(b []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(b)
+	if err != nil {
+		return 0, err
+	}
+	return w.WriteRTP(&p.Header, p.Payload)
+}
+
+func TestFECProtect(t *testing.T) {
+	local, err := newFECTrack(
+		webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeVP8, ClockRate: 90000,
+		},
+		"video", "stream",
+	)
+	if err != nil {
+		t.Fatalf("newFECTrack: %v", err)
+	}
+	writer := &fecTestWriter{}
+	local.ssrc = 17
+	local.ptype = 96
+	local.encoder = flexfec.NewFlexEncoder03(118, 42)
+	local.writer = writer
+
+	write := func(seqno uint16) {
+		packet := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    100,
+				SequenceNumber: seqno,
+				Timestamp:      uint32(seqno) * 3000,
+				SSRC:           1,
+			},
+			Payload: []byte{1, 2, 3, byte(seqno)},
+		}
+		buf, err := packet.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		_, err = local.Write(buf)
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	for i := uint16(0); i < fecMediaPackets; i++ {
+		write(100 + i)
+	}
+	if len(writer.packets) != 0 {
+		t.Errorf("FEC without loss")
+	}
+
+	local.setLoss(26)
+	for i := uint16(0); i < fecMediaPackets-1; i++ {
+		write(200 + i)
+	}
+	// retransmissions are ignored
+	write(203)
+	if len(writer.packets) != 0 {
+		t.Errorf("Early FEC: %v", len(writer.packets))
+	}
+	write(200 + fecMediaPackets - 1)
+	if len(writer.packets) != 3 {
+		t.Fatalf("Expected 3 FEC packets, got %v", len(writer.packets))
+	}
+	for _, p := range writer.packets {
+		if p.SSRC != 42 || p.PayloadType != 118 {
+			t.Errorf("Bad FEC header %v", p.Header)
+		}
+	}
+
+	// 3 repair packets for every 10 media packets
+	if r := local.mediaBitrate(1300000); r != 1000000 {
+		t.Errorf("Expected 1000000, got %v", r)
+	}
+	local.setLoss(0)
+	if r := local.mediaBitrate(1300000); r != 1300000 {
+		t.Errorf("Expected 1300000 without FEC, got %v", r)
+	}
+}
+
+func TestFECNegotiation(t *testing.T) {
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			"video/VP8", 90000, 0, "", group.VideoRTCPFeedback,
+		},
+		PayloadType: 96,
+	}
+	codecs := []webrtc.RTPCodecParameters{codec, group.FlexFECCodec}
+	api, err := group.APIFromCodecs(codecs)
+	if err != nil {
+		t.Fatalf("APIFromCodecs: %v", err)
+	}
+	pc1, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc1.Close()
+	pc2, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc2.Close()
+
+	local, err := newFECTrack(codec.RTPCodecCapability, "video", "stream")
+	if err != nil {
+		t.Fatalf("newFECTrack: %v", err)
+	}
+	tr, err := pc1.AddTransceiverFromTrack(local,
+		webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly,
+		},
+	)
+	if err != nil {
+		t.Fatalf("AddTransceiverFromTrack: %v", err)
+	}
+	err = tr.SetCodecPreferences(codecs)
+	if err != nil {
+		t.Fatalf("SetCodecPreferences: %v", err)
+	}
+
+	sdp := offerAnswer(t, pc1, pc2)
+	if !strings.Contains(sdp, "flexfec-03") ||
+		!strings.Contains(sdp, "a=ssrc-group:FEC-FR") {
+		t.Errorf("FEC not offered: %v", sdp)
+	}
+
+	local.fecMu.Lock()
+	defer local.fecMu.Unlock()
+	if local.writer == nil || local.ptype != 96 || local.ssrc == 0 {
+		t.Errorf("FEC not bound: %v %v", local.ptype, local.ssrc)
+	}
+}
@@ -0,0 +1,90 @@
+package rtpconn
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// In floor-control mode, only the audio of the client holding the floor
+// is forwarded.  The holder of the floor is maintained by the group, see
+// group/floor.go; we only keep a flag in each up connection that
+// indicates whether its audio is dropped.
+
+// the minimum interval between two notifications of speech to the group
+const floorUpdateInterval = rtptime.JiffiesPerSec / 4
+
+func (up *rtpUpConnection) isAudioGated() bool {
+	return atomic.LoadUint32(&up.audioGated) != 0
+}
+
+func (up *rtpUpConnection) setAudioGated(gated bool) {
+	var v uint32
+	if gated {
+		v = 1
+	}
+	atomic.StoreUint32(&up.audioGated, v)
+}
+
+// claimFloor notifies the group that the connection is carrying speech,
+// which gives it the floor if it is free.
+func (up *rtpUpConnection) claimFloor(now uint64) {
+	last := atomic.LoadUint64(&up.floorUpdated)
+	if now-last < floorUpdateInterval {
+		return
+	}
+	if !atomic.CompareAndSwapUint64(&up.floorUpdated, last, now) {
+		return
+	}
+	g := up.client.Group()
+	if g == nil {
+		return
+	}
+	if !g.FloorControl() {
+		// floor control has been disabled since the last update
+		if up.isAudioGated() {
+			go updateFloor(g)
+		}
+		return
+	}
+	if g.SpeakFloor(up.client.Id(), time.Now()) {
+		go updateFloor(g)
+	}
+}
+
+var floorMu sync.Mutex
+
+// updateFloor recomputes the set of connections whose audio is dropped
+// in group g.
+func updateFloor(g *group.Group) {
+	if g == nil {
+		return
+	}
+
+	floorMu.Lock()
+	defer floorMu.Unlock()
+
+	control := g.FloorControl()
+	holder := g.Floor()
+	for _, c := range g.GetClients(nil) {
+		gated := control && c.Id() != holder
+		for _, up := range upConnections(c) {
+			up.setAudioGated(gated)
+		}
+	}
+}
+
+// audioGated returns true if a packet should be dropped because the
+// sender doesn't hold the floor.  Called from Write.
+func (down *rtpDownTrack) audioGated() bool {
+	up, ok := down.getRemote().(*rtpUpTrack)
+	if !ok || up.Kind() != webrtc.RTPCodecTypeAudio {
+		return false
+	}
+	return up.conn.isAudioGated()
+}
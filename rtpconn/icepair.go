@@ -0,0 +1,102 @@
+package rtpconn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/stats"
+)
+
+// The selected ICE candidate pair of a connection may change during
+// a call, for example when a client falls back to TURN after losing
+// connectivity on its direct path.  Since this is often correlated with
+// a drop in quality, we record such changes, expose them in the
+// statistics, and inform the client.
+
+// maxPairChanges is the number of changes remembered for each connection.
+const maxPairChanges = 8
+
+type pairChanges struct {
+	mu sync.Mutex
+	// the type of the current remote candidate, empty if no pair has
+	// been selected yet
+	remote  string
+	changes []stats.PairChange
+}
+
+// selected records that pair has been selected.  It returns the type of
+// the previous remote candidate and the change, or false if this is the
+// initial selection.
+func (p *pairChanges) selected(pair *webrtc.ICECandidatePair, now time.Time) (string, stats.PairChange, bool) {
+	if pair == nil || pair.Local == nil || pair.Remote == nil {
+		return "", stats.PairChange{}, false
+	}
+	change := stats.PairChange{
+		Time:     now,
+		Local:    pair.Local.Typ.String(),
+		Remote:   pair.Remote.Typ.String(),
+		Protocol: pair.Remote.Protocol.String(),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	from := p.remote
+	p.remote = change.Remote
+	if from == "" {
+		return "", stats.PairChange{}, false
+	}
+	if len(p.changes) >= maxPairChanges {
+		copy(p.changes, p.changes[1:])
+		p.changes = p.changes[:len(p.changes)-1]
+	}
+	p.changes = append(p.changes, change)
+	return from, change, true
+}
+
+func (p *pairChanges) get() []stats.PairChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.changes) == 0 {
+		return nil
+	}
+	changes := make([]stats.PairChange, len(p.changes))
+	copy(changes, p.changes)
+	return changes
+}
+
+// watchCandidatePair arranges for the changes of the selected candidate
+// pair of pc to be recorded in p and in the server-wide statistics.  If
+// f is not nil, it is called after every change.
+func watchCandidatePair(pc *webrtc.PeerConnection, p *pairChanges, f func(stats.PairChange)) {
+	pc.SCTP().Transport().ICETransport().OnSelectedCandidatePairChange(
+		func(pair *webrtc.ICECandidatePair) {
+			from, change, ok := p.selected(pair, time.Now())
+			if !ok {
+				return
+			}
+			stats.RecordPairChange(from, change.Remote)
+			if f != nil {
+				f(change)
+			}
+		},
+	)
+}
+
+type pairChangeMessage struct {
+	Id string `json:"id"`
+	stats.PairChange
+}
+
+// reportPairChange informs c that the selected candidate pair of the
+// connection id has changed.
+func reportPairChange(c *webClient, id string, change stats.PairChange) {
+	c.write(clientMessage{
+		Type:       "usermessage",
+		Kind:       "candidate-pair",
+		Dest:       c.id,
+		Privileged: true,
+		Value:      pairChangeMessage{id, change},
+	})
+}
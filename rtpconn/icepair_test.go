@@ -0,0 +1,57 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestPairChanges(t *testing.T) {
+	pair := func(local, remote webrtc.ICECandidateType) *webrtc.ICECandidatePair {
+		return &webrtc.ICECandidatePair{
+			Local: &webrtc.ICECandidate{
+				Typ: local, Protocol: webrtc.ICEProtocolUDP,
+			},
+			Remote: &webrtc.ICECandidate{
+				Typ: remote, Protocol: webrtc.ICEProtocolUDP,
+			},
+		}
+	}
+
+	var p pairChanges
+	now := time.Now()
+	_, _, ok := p.selected(
+		pair(webrtc.ICECandidateTypeHost, webrtc.ICECandidateTypeSrflx),
+		now,
+	)
+	if ok || p.get() != nil {
+		t.Errorf("Initial selection recorded as a change")
+	}
+
+	from, change, ok := p.selected(
+		pair(webrtc.ICECandidateTypeHost, webrtc.ICECandidateTypeRelay),
+		now,
+	)
+	if !ok || from != "srflx" || change.Local != "host" ||
+		change.Remote != "relay" || change.Protocol != "udp" {
+		t.Errorf("Bad change %v %v %v", from, change, ok)
+	}
+
+	for i := 0; i < 2*maxPairChanges; i++ {
+		p.selected(
+			pair(webrtc.ICECandidateTypeHost,
+				webrtc.ICECandidateTypeRelay),
+			now.Add(time.Duration(i+1)*time.Second),
+		)
+	}
+	changes := p.get()
+	if len(changes) != maxPairChanges {
+		t.Errorf("Expected %v, got %v", maxPairChanges, len(changes))
+	}
+	last := now.Add(2 * maxPairChanges * time.Second)
+	if !changes[len(changes)-1].Time.Equal(last) {
+		t.Errorf("Expected %v, got %v",
+			last, changes[len(changes)-1].Time)
+	}
+}
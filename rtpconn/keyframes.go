@@ -0,0 +1,64 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/rtptime"
+)
+
+// Keyframe requests from all the receivers of a track are coalesced and
+// rate-limited, so that churn in a large group doesn't cause a storm of
+// PLIs towards the sender.  A request made on behalf of a new receiver
+// is forced: it is sent almost immediately, since the receiver cannot
+// display anything until the next keyframe.
+
+const (
+	// the minimum interval between two keyframe requests
+	keyframeInterval = rtptime.JiffiesPerSec
+	// the minimum interval before a forced request
+	forcedKeyframeInterval = rtptime.JiffiesPerSec / 10
+)
+
+// keyframeLimiter decides when to send keyframe requests.  It is only
+// accessed by readLoop.
+type keyframeLimiter struct {
+	// whether a keyframe is needed, and whether the need is urgent
+	needed, forced bool
+	// the time at which we last sent a request
+	requested uint64
+}
+
+// request notes that a receiver needs a keyframe.
+func (k *keyframeLimiter) request(forced bool) {
+	k.needed = true
+	if forced {
+		k.forced = true
+	}
+}
+
+// keyframe is called when we receive a keyframe, which satisfies all
+// pending requests.
+func (k *keyframeLimiter) keyframe() {
+	k.needed = false
+	k.forced = false
+}
+
+// cancel is called when we cannot send keyframe requests.
+func (k *keyframeLimiter) cancel() {
+	k.keyframe()
+}
+
+// check returns true if a keyframe request should be sent now.
+func (k *keyframeLimiter) check(now uint64) bool {
+	if !k.needed {
+		return false
+	}
+	interval := uint64(keyframeInterval)
+	if k.forced {
+		interval = forcedKeyframeInterval
+	}
+	if k.requested != 0 && now-k.requested < interval {
+		return false
+	}
+	k.requested = now
+	k.forced = false
+	return true
+}
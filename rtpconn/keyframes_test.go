@@ -0,0 +1,61 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestKeyframeLimiter(t *testing.T) {
+	var k keyframeLimiter
+	now := uint64(1000 * rtptime.JiffiesPerSec)
+	ms := uint64(rtptime.JiffiesPerSec / 1000)
+
+	if k.check(now) {
+		t.Errorf("Request sent with no need")
+	}
+
+	// many receivers ask for a keyframe at the same time
+	for i := 0; i < 10; i++ {
+		k.request(false)
+	}
+	if !k.check(now) {
+		t.Errorf("First request was not sent")
+	}
+	k.request(false)
+	if k.check(now+500*ms) || k.check(now+999*ms) {
+		t.Errorf("Request was not rate-limited")
+	}
+	if !k.check(now + 1000*ms) {
+		t.Errorf("Request was not resent")
+	}
+	now += 1000 * ms
+
+	// the keyframe satisfies everyone
+	k.keyframe()
+	k.request(false)
+	k.keyframe()
+	if k.check(now + 2000*ms) {
+		t.Errorf("Request sent after keyframe")
+	}
+
+	// a new receiver joins
+	k.request(false)
+	k.request(true)
+	if k.check(now + 50*ms) {
+		t.Errorf("Forced request sent too early")
+	}
+	if !k.check(now + 100*ms) {
+		t.Errorf("Forced request was not sent")
+	}
+	now += 100 * ms
+	k.request(false)
+	if k.check(now + 200*ms) {
+		t.Errorf("Forced request was not cleared")
+	}
+
+	k.cancel()
+	if k.check(now + 5000*ms) {
+		t.Errorf("Request sent after cancel")
+	}
+}
@@ -0,0 +1,366 @@
+package rtpconn
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/pion/sdp/v3"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+// Tokens may carry limits on the media that their bearer publishes.
+// The number and kind of tracks are checked when an offer is received,
+// the bitrate is enforced using REMB.
+
+var ErrAudioOnly = errors.New("only audio may be published")
+var ErrTooManyTracks = errors.New("too many tracks")
+
+// Since the server cannot tell a camera from a screen, a user who is not
+// allowed to share the screen may publish a single video track, whatever
+// the label of its stream.
+
+var ErrScreenshare = errors.New("not authorised to share the screen")
+
+func (c *webClient) SetLimits(limits *token.Limits) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = limits
+}
+
+func (c *WhipClient) SetLimits(limits *token.Limits) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limits = limits
+}
+
+// clientLimits returns the limits that apply to a client, or nil.
+func clientLimits(c group.Client) *token.Limits {
+	switch c := c.(type) {
+	case *webClient:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.limits
+	case *WhipClient:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.limits
+	}
+	return nil
+}
+
+// countMedia returns the number of audio and video tracks sent by the
+// offerer of a session description.
+func countMedia(offer string) (int, int, error) {
+	var s sdp.SessionDescription
+	err := s.Unmarshal([]byte(offer))
+	if err != nil {
+		return 0, 0, err
+	}
+	var audio, video int
+	for _, m := range s.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		_, inactive := m.Attribute("inactive")
+		_, recvonly := m.Attribute("recvonly")
+		if inactive || recvonly {
+			continue
+		}
+		switch m.MediaName.Media {
+		case "audio":
+			audio++
+		case "video":
+			video++
+		}
+	}
+	return audio, video, nil
+}
+
+// checkLimits checks whether publishing an offer is allowed.  Others is
+// the number of tracks already published by the client.
+func checkLimits(limits *token.Limits, offer string, others int) error {
+	if limits == nil {
+		return nil
+	}
+	audio, video, err := countMedia(offer)
+	if err != nil {
+		return err
+	}
+	if limits.AudioOnly && video > 0 {
+		return ErrAudioOnly
+	}
+	if limits.MaxTracks > 0 && others+audio+video > limits.MaxTracks {
+		return ErrTooManyTracks
+	}
+	return nil
+}
+
+// checkWebLimits checks whether a web client is allowed to publish an
+// offer for the connection id, possibly replacing the connection replace.
+func checkWebLimits(c *webClient, id, replace, offer string) error {
+	c.mu.Lock()
+	limits := c.limits
+	var descs []string
+	for _, up := range c.up {
+		if up.id == id || up.id == replace {
+			continue
+		}
+		if d := up.pc.RemoteDescription(); d != nil {
+			descs = append(descs, d.SDP)
+		}
+	}
+	c.mu.Unlock()
+
+	if limits == nil {
+		return nil
+	}
+
+	others := 0
+	for _, d := range descs {
+		a, v, err := countMedia(d)
+		if err != nil {
+			return err
+		}
+		others += a + v
+	}
+	return checkLimits(limits, offer, others)
+}
+
+// checkVideoCount returns ErrScreenshare if a client that may not share
+// the screen publishes more than one video track.  Others is the number
+// of video tracks already published by the client.
+func checkVideoCount(g *group.Group, perms []string, offer string, others int) error {
+	if g == nil || g.CanScreenshare(perms) {
+		return nil
+	}
+	_, video, err := countMedia(offer)
+	if err != nil {
+		return err
+	}
+	if others+video > 1 {
+		return ErrScreenshare
+	}
+	return nil
+}
+
+// checkWebScreenshare is like checkVideoCount for a web client publishing
+// the connection id, possibly replacing the connection replace.  Fallback
+// streams, which carry a copy of an existing stream, are not counted.
+// Called from the client loop.
+func checkWebScreenshare(c *webClient, id, replace, offer, fallback string) error {
+	g := c.group
+	if g == nil || g.CanScreenshare(c.permissions) {
+		return nil
+	}
+	if fallback != "" {
+		orig := getUpConn(c, fallback)
+		if orig == nil || orig.fallbackFor() != "" {
+			return ErrScreenshare
+		}
+		return checkVideoCount(g, c.permissions, offer, 0)
+	}
+
+	others := 0
+	for _, up := range getUpConns(c) {
+		if up.id == id || up.id == replace || up.fallbackFor() != "" {
+			continue
+		}
+		d := up.pc.RemoteDescription()
+		if d == nil {
+			continue
+		}
+		_, v, err := countMedia(d.SDP)
+		if err != nil {
+			return err
+		}
+		others += v
+	}
+	return checkVideoCount(g, c.permissions, offer, others)
+}
+
+// screenshareConns returns the up connections of a client that lost the
+// right to share the screen that must be closed: the streams labelled
+// "screenshare", and all video streams but one, preferably the camera.
+func screenshareConns(ups []*rtpUpConnection) []*rtpUpConnection {
+	var result, video []*rtpUpConnection
+	for _, up := range ups {
+		if up.fallbackFor() != "" {
+			continue
+		}
+		if up.label == "screenshare" {
+			result = append(result, up)
+			continue
+		}
+		d := up.pc.RemoteDescription()
+		if d == nil {
+			continue
+		}
+		_, v, err := countMedia(d.SDP)
+		if err != nil || v == 0 {
+			continue
+		}
+		if up.label == "camera" {
+			video = append([]*rtpUpConnection{up}, video...)
+		} else {
+			video = append(video, up)
+		}
+	}
+	if len(video) > 1 {
+		result = append(result, video[1:]...)
+	}
+	return result
+}
+
+// limitBitrate applies the client's bitrate limit to a rate.
+func limitBitrate(c group.Client, rate uint64) uint64 {
+	limits := clientLimits(c)
+	if limits != nil && limits.MaxBitrate > 0 && rate > limits.MaxBitrate {
+		rate = limits.MaxBitrate
+	}
+	if cc := clientCeilings(c); cc != nil {
+		up := atomic.LoadUint64(&cc.up)
+		if up > 0 && rate > up {
+			rate = up
+		}
+	}
+	stream, user := groupBitrates(c.Group())
+	if stream > 0 && rate > stream {
+		rate = stream
+	}
+	if user > 0 {
+		// the user's allowance is shared between its up connections
+		if n := len(upConnections(c)); n > 1 {
+			user /= uint64(n)
+		}
+		if rate > user {
+			rate = user
+		}
+	}
+	return rate
+}
+
+// Groups may cap the bitrate of every stream and the total bitrate sent
+// and received by every user.  Like the ceilings below, the caps are
+// enforced using REMB upstream and by the layer selector downstream.
+
+// groupBitrates returns the maximum bitrate of a stream and of a user in
+// group g, where 0 means unlimited.
+func groupBitrates(g *group.Group) (uint64, uint64) {
+	if g == nil {
+		return 0, 0
+	}
+	desc := g.Description()
+	return desc.MaxBitrate, desc.MaxUserBitrate
+}
+
+// Operators may additionally set temporary bandwidth ceilings on a
+// given client, which last until they are changed or the client leaves.
+// The upstream ceiling is enforced using REMB, the downstream ceiling is
+// shared between the client's down connections and enforced by the
+// layer selector.
+
+type ceilings struct {
+	up        uint64 // atomic
+	down      uint64 // atomic
+	downConns int32  // atomic
+}
+
+// downShare returns the downstream ceiling of a single down connection,
+// or 0 if unlimited.
+func (cc *ceilings) downShare() uint64 {
+	return cc.share(atomic.LoadUint64(&cc.down))
+}
+
+// share returns the share of a downstream bitrate allotted to a single
+// down connection, or 0 if down is 0.
+func (cc *ceilings) share(down uint64) uint64 {
+	if down == 0 {
+		return 0
+	}
+	n := atomic.LoadInt32(&cc.downConns)
+	if n <= 1 {
+		return down
+	}
+	return down / uint64(n)
+}
+
+func clientCeilings(c group.Client) *ceilings {
+	switch c := c.(type) {
+	case *webClient:
+		return &c.ceilings
+	case *WhipClient:
+		return &c.ceilings
+	}
+	return nil
+}
+
+// setBandwidth sets the bandwidth ceilings of the client with the given
+// id.  A value of 0 removes the corresponding ceiling.
+func setBandwidth(g *group.Group, id string, up, down uint64) error {
+	client := g.GetClient(id)
+	if client == nil {
+		return group.UserError("no such user")
+	}
+	cc := clientCeilings(client)
+	if cc == nil {
+		return group.UserError("this is not a real user")
+	}
+	if _, ok := client.(*WhipClient); ok && down != 0 {
+		return group.UserError("this user doesn't receive media")
+	}
+	atomic.StoreUint64(&cc.up, up)
+	atomic.StoreUint64(&cc.down, down)
+	return nil
+}
+
+// parseBandwidth parses the value of a "bandwidth" user action.
+func parseBandwidth(value interface{}) (uint64, uint64, error) {
+	if value == nil {
+		return 0, 0, nil
+	}
+	v, ok := value.(map[string]interface{})
+	if !ok {
+		return 0, 0, group.UserError("bad value in bandwidth")
+	}
+	get := func(key string) (uint64, error) {
+		r, ok := v[key]
+		if !ok || r == nil {
+			return 0, nil
+		}
+		rr, ok := r.(float64)
+		if !ok || rr < 0 {
+			return 0, group.UserError("bad value in bandwidth")
+		}
+		if rr == 0 {
+			return 0, nil
+		}
+		if rr < group.MinBitrate {
+			return group.MinBitrate, nil
+		}
+		return uint64(rr), nil
+	}
+	up, err := get("up")
+	if err != nil {
+		return 0, 0, err
+	}
+	down, err := get("down")
+	if err != nil {
+		return 0, 0, err
+	}
+	return up, down, nil
+}
+
+// clientCapabilities returns the capabilities of a client in a group,
+// taking into account its limits and bandwidth ceiling.
+func clientCapabilities(c group.Client, g *group.Group) *group.Capabilities {
+	caps := g.Capabilities(clientLimits(c))
+	if cc := clientCeilings(c); cc != nil {
+		up := atomic.LoadUint64(&cc.up)
+		if up > 0 && (caps.MaxBitrate == 0 || up < caps.MaxBitrate) {
+			caps.MaxBitrate = up
+		}
+	}
+	return &caps
+}
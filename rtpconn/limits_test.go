@@ -0,0 +1,155 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+const limitsOffer = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=-\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+	"a=sendonly\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"a=sendonly\r\n" +
+	"m=video 9 UDP/TLS/RTP/SAVPF 96\r\n" +
+	"a=inactive\r\n"
+
+func TestCheckLimits(t *testing.T) {
+	audio, video, err := countMedia(limitsOffer)
+	if err != nil || audio != 1 || video != 1 {
+		t.Errorf("countMedia: %v %v %v", audio, video, err)
+	}
+
+	tests := []struct {
+		limits *token.Limits
+		others int
+		err    error
+	}{
+		{nil, 10, nil},
+		{&token.Limits{}, 10, nil},
+		{&token.Limits{AudioOnly: true}, 0, ErrAudioOnly},
+		{&token.Limits{MaxTracks: 2}, 0, nil},
+		{&token.Limits{MaxTracks: 2}, 1, ErrTooManyTracks},
+	}
+	for _, test := range tests {
+		err := checkLimits(test.limits, limitsOffer, test.others)
+		if err != test.err {
+			t.Errorf("checkLimits(%v, %v): %v, expected %v",
+				test.limits, test.others, err, test.err)
+		}
+	}
+}
+
+func TestBandwidthCeilings(t *testing.T) {
+	c := &webClient{
+		limits: &token.Limits{MaxBitrate: 1000000},
+	}
+	if r := limitBitrate(c, 2000000); r != 1000000 {
+		t.Errorf("limitBitrate: got %v", r)
+	}
+	c.ceilings.up = 500000
+	if r := limitBitrate(c, 2000000); r != 500000 {
+		t.Errorf("limitBitrate: got %v", r)
+	}
+	if r := limitBitrate(c, 200000); r != 200000 {
+		t.Errorf("limitBitrate: got %v", r)
+	}
+
+	if s := c.ceilings.downShare(); s != 0 {
+		t.Errorf("downShare: got %v", s)
+	}
+	c.ceilings.down = 900000
+	if s := c.ceilings.downShare(); s != 900000 {
+		t.Errorf("downShare: got %v", s)
+	}
+	c.ceilings.downConns = 3
+	if s := c.ceilings.downShare(); s != 300000 {
+		t.Errorf("downShare: got %v", s)
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		up, down uint64
+		ok       bool
+	}{
+		{nil, 0, 0, true},
+		{map[string]interface{}{}, 0, 0, true},
+		{map[string]interface{}{"up": 300000.0}, 300000, 0, true},
+		{map[string]interface{}{"down": 1000.0}, 0, group.MinBitrate, true},
+		{map[string]interface{}{"up": 0.0, "down": nil}, 0, 0, true},
+		{map[string]interface{}{"up": -1.0}, 0, 0, false},
+		{map[string]interface{}{"up": "fast"}, 0, 0, false},
+		{42.0, 0, 0, false},
+	}
+	for _, test := range tests {
+		up, down, err := parseBandwidth(test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("parseBandwidth(%v): %v", test.value, err)
+			continue
+		}
+		if err == nil && (up != test.up || down != test.down) {
+			t.Errorf("parseBandwidth(%v): got %v %v, expected %v %v",
+				test.value, up, down, test.up, test.down)
+		}
+	}
+}
+
+func TestGroupBitrates(t *testing.T) {
+	g, err := group.Add("group-bitrates-test", &group.Description{
+		MaxBitrate:     1000000,
+		MaxUserBitrate: 1500000,
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	c := &webClient{group: g}
+	if r := limitBitrate(c, 2000000); r != 1000000 {
+		t.Errorf("limitBitrate: got %v", r)
+	}
+
+	c.up = map[string]*rtpUpConnection{
+		"a": {}, "b": {},
+	}
+	if r := limitBitrate(c, 2000000); r != 750000 {
+		t.Errorf("limitBitrate with two connections: got %v", r)
+	}
+
+	c.ceilings.downConns = 3
+	if s := c.ceilings.share(1500000); s != 500000 {
+		t.Errorf("share: got %v", s)
+	}
+}
+
+func TestCheckVideoCount(t *testing.T) {
+	g, err := group.Add("video-count-test", &group.Description{
+		RestrictScreenshare: true,
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("video-count-test")
+
+	tests := []struct {
+		perms  []string
+		others int
+		err    error
+	}{
+		{[]string{"present"}, 0, nil},
+		{[]string{"present"}, 1, ErrScreenshare},
+		{[]string{"present", "screenshare"}, 1, nil},
+		{[]string{"op", "present"}, 3, nil},
+	}
+	for _, test := range tests {
+		err := checkVideoCount(g, test.perms, limitsOffer, test.others)
+		if err != test.err {
+			t.Errorf("checkVideoCount(%v, %v): %v, expected %v",
+				test.perms, test.others, err, test.err)
+		}
+	}
+}
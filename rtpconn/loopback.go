@@ -0,0 +1,111 @@
+package rtpconn
+
+import (
+	"context"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+)
+
+// Media that doesn't come from a WebRTC peer, such as the audio of a
+// telephone call or the stream of an RTSP camera, is sent to the group
+// through a local peer connection, so that it is handled just like the
+// media of any other client.
+
+// loopbackConn creates an up connection for the client c that receives
+// the given tracks from a new local peer connection.  The function
+// closed is called when the connection fails.  It returns the up
+// connection and the local peer connection.
+func loopbackConn(ctx context.Context, c group.Client, id, label string, tracks []webrtc.TrackLocal, closed func()) (*rtpUpConnection, *webrtc.PeerConnection, error) {
+	api, err := c.Group().API()
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, track := range tracks {
+		_, err = pc.AddTransceiverFromTrack(track,
+			webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+			},
+		)
+		if err != nil {
+			pc.Close()
+			return nil, nil, err
+		}
+	}
+
+	offer, err := localDescription(ctx, pc, true)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+
+	up, err := newUpConn(c, id, label, offer.SDP)
+	if err != nil {
+		pc.Close()
+		return nil, nil, err
+	}
+	fail := func(err error) (*rtpUpConnection, *webrtc.PeerConnection, error) {
+		up.pc.Close()
+		pc.Close()
+		return nil, nil, err
+	}
+
+	err = up.pc.SetRemoteDescription(offer)
+	if err != nil {
+		return fail(err)
+	}
+	answer, err := localDescription(ctx, up.pc, false)
+	if err != nil {
+		return fail(err)
+	}
+	err = pc.SetRemoteDescription(answer)
+	if err != nil {
+		return fail(err)
+	}
+
+	up.pc.OnICEConnectionStateChange(
+		func(state webrtc.ICEConnectionState) {
+			switch state {
+			case webrtc.ICEConnectionStateConnected:
+				if up.recorded.CompareAndSwap(false, true) {
+					recordConnection(up.pc, c.Addr())
+				}
+			case webrtc.ICEConnectionStateFailed,
+				webrtc.ICEConnectionStateClosed:
+				closed()
+			}
+		})
+
+	return up, pc, nil
+}
+
+// localDescription creates an offer or an answer, sets it as the local
+// description of pc, and waits for ICE gathering to complete.
+func localDescription(ctx context.Context, pc *webrtc.PeerConnection, offer bool) (webrtc.SessionDescription, error) {
+	var d webrtc.SessionDescription
+	var err error
+	if offer {
+		d, err = pc.CreateOffer(nil)
+	} else {
+		d, err = pc.CreateAnswer(nil)
+	}
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	err = pc.SetLocalDescription(d)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return webrtc.SessionDescription{}, ctx.Err()
+	case <-gathered:
+	}
+	return *pc.LocalDescription(), nil
+}
@@ -0,0 +1,83 @@
+package rtpconn
+
+import (
+	"time"
+)
+
+// Chat messages, captions, user messages and actions sent by a client
+// are subject to a token bucket, configured by the max-message-rate and
+// message-burst fields of the group description.  Operators are exempt,
+// as are the user messages that carry the signalling of file transfers,
+// which are sent in bursts and are driven by the file transfer protocol
+// rather than by the user.
+// Updates to a client's data, which are sent to all the members of the
+// group, are subject to a separate, fixed limit that applies to all
+// clients.
+
+const defaultMessageBurst = 10
+
+const (
+	userDataRate  = 1.0
+	userDataBurst = 5
+)
+
+// messageLimiter is a token bucket.  It is only accessed by the client
+// loop.
+type messageLimiter struct {
+	tokens float64
+	time   time.Time
+}
+
+// allow returns true if a message may be sent at time now, given the
+// rate in messages per second and the burst size.  A rate of zero or
+// less means that there is no limit.
+func (l *messageLimiter) allow(now time.Time, rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = defaultMessageBurst
+	}
+	if l.time.IsZero() {
+		l.tokens = float64(burst)
+	} else {
+		l.tokens += now.Sub(l.time).Seconds() * rate
+		if l.tokens > float64(burst) {
+			l.tokens = float64(burst)
+		}
+	}
+	l.time = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// rateLimited returns true if m is subject to the message rate limit.
+func rateLimited(m clientMessage) bool {
+	switch m.Type {
+	case "chat", "caption", "groupaction", "useraction":
+		return true
+	case "usermessage":
+		return m.Kind != "filetransfer"
+	}
+	return false
+}
+
+// checkMessageRate returns false if c has exceeded the message rate of
+// its group.
+func checkMessageRate(c *webClient) bool {
+	if c.group == nil || member("op", c.permissions) {
+		return true
+	}
+	desc := c.group.Description()
+	return c.messageLimiter.allow(
+		time.Now(), desc.MaxMessageRate, desc.MessageBurst,
+	)
+}
+
+// checkDataRate returns false if c has updated its data too often.
+func checkDataRate(c *webClient) bool {
+	return c.dataLimiter.allow(time.Now(), userDataRate, userDataBurst)
+}
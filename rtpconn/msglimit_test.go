@@ -0,0 +1,73 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMessageLimiter(t *testing.T) {
+	var l messageLimiter
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allow(now, 1, 3) {
+			t.Errorf("Message %v refused", i)
+		}
+	}
+	if l.allow(now, 1, 3) {
+		t.Errorf("Burst exceeded")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if l.allow(now, 1, 3) {
+		t.Errorf("Rate exceeded")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !l.allow(now, 1, 3) {
+		t.Errorf("Message refused after refill")
+	}
+
+	// tokens don't accumulate beyond the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !l.allow(now, 1, 3) {
+			t.Errorf("Message %v refused", i)
+		}
+	}
+	if l.allow(now, 1, 3) {
+		t.Errorf("Burst exceeded")
+	}
+}
+
+func TestMessageLimiterUnlimited(t *testing.T) {
+	var l messageLimiter
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		if !l.allow(now, 0, 0) {
+			t.Fatalf("Message %v refused", i)
+		}
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	tests := []struct {
+		tpe, kind string
+		limited   bool
+	}{
+		{"chat", "", true},
+		{"chat", "me", true},
+		{"caption", "", true},
+		{"usermessage", "", true},
+		{"usermessage", "filetransfer", false},
+		{"groupaction", "clearchat", true},
+		{"useraction", "kick", true},
+		{"request", "", false},
+		{"ice", "", false},
+	}
+	for _, tt := range tests {
+		m := clientMessage{Type: tt.tpe, Kind: tt.kind}
+		if rateLimited(m) != tt.limited {
+			t.Errorf("%v %v: expected %v", tt.tpe, tt.kind, tt.limited)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/group"
+)
+
+// Clients may ask the server not to send them some classes of events,
+// which is useful for users of assistive technologies in large groups.
+// The classes are stored in a bitmask that is accessed atomically, since
+// chat messages are broadcast from other clients' goroutines.
+//
+// Users joining and leaving are always sent, since the client needs
+// them in order to maintain its list of users; the "users" class is
+// implemented by the client.
+
+const (
+	// hand raises
+	notifyRaisehand uint32 = 1 << iota
+	// unprivileged public chat messages
+	notifyChat
+)
+
+var notificationClasses = map[string]uint32{
+	"raisehand": notifyRaisehand,
+	"chat":      notifyChat,
+}
+
+// parseNotifications parses the value of a notifications message, and
+// returns the set of suppressed classes.  Classes that are not mentioned
+// are enabled, unknown classes are ignored.
+func parseNotifications(value any) (uint32, error) {
+	if value == nil {
+		return 0, nil
+	}
+	v, ok := value.(map[string]any)
+	if !ok {
+		return 0, group.ProtocolError("bad value in notifications")
+	}
+	var suppressed uint32
+	for k, vv := range v {
+		enabled, ok := vv.(bool)
+		if !ok {
+			return 0, group.ProtocolError(
+				"bad value in notifications",
+			)
+		}
+		if !enabled {
+			suppressed |= notificationClasses[k]
+		}
+	}
+	return suppressed, nil
+}
+
+func (c *webClient) suppressed(class uint32) bool {
+	return c.suppressedNotifications.Load()&class != 0
+}
+
+// filterClient returns the user message that should be sent to c.
+func filterClient(c *webClient, m clientMessage) clientMessage {
+	if m.Id == c.id {
+		return m
+	}
+	if c.suppressed(notifyRaisehand) && m.Data != nil {
+		if _, ok := m.Data["raisehand"]; ok {
+			data := make(map[string]any, len(m.Data))
+			for k, v := range m.Data {
+				if k != "raisehand" {
+					data[k] = v
+				}
+			}
+			m.Data = data
+		}
+	}
+	return m
+}
+
+// filterChat returns true if the public chat message m should be sent to c.
+func filterChat(c *webClient, m *clientMessage) bool {
+	if m.Type != "chat" || m.Dest != "" || m.Privileged ||
+		m.Source == c.id {
+		return true
+	}
+	return !c.suppressed(notifyChat)
+}
@@ -0,0 +1,76 @@
+package rtpconn
+
+import (
+	"testing"
+)
+
+func TestParseNotifications(t *testing.T) {
+	s, err := parseNotifications(map[string]any{
+		"users": false, "chat": false, "raisehand": true,
+		"unknown": false,
+	})
+	if err != nil || s != notifyChat {
+		t.Errorf("Expected %v, got %v (%v)", notifyChat, s, err)
+	}
+
+	s, err = parseNotifications(nil)
+	if err != nil || s != 0 {
+		t.Errorf("Expected 0, got %v (%v)", s, err)
+	}
+
+	_, err = parseNotifications(map[string]any{"users": "no"})
+	if err == nil {
+		t.Errorf("Bad value accepted")
+	}
+	_, err = parseNotifications([]any{"users"})
+	if err == nil {
+		t.Errorf("Bad value accepted")
+	}
+}
+
+func TestFilterClient(t *testing.T) {
+	c := &webClient{id: "me"}
+	c.suppressedNotifications.Store(notifyRaisehand)
+
+	data := map[string]any{"raisehand": true, "other": 1}
+	m := filterClient(c, clientMessage{
+		Type: "user", Kind: "change", Id: "other", Data: data,
+	})
+	if _, ok := m.Data["raisehand"]; ok || m.Data["other"] != 1 {
+		t.Errorf("Unexpected data %v", m.Data)
+	}
+	if _, ok := data["raisehand"]; !ok {
+		t.Errorf("Original data modified")
+	}
+
+	m = filterClient(c, clientMessage{
+		Type: "user", Kind: "change", Id: "me", Data: data,
+	})
+	if _, ok := m.Data["raisehand"]; !ok {
+		t.Errorf("Own raisehand suppressed")
+	}
+}
+
+func TestFilterChat(t *testing.T) {
+	c := &webClient{id: "me"}
+	m := clientMessage{Type: "chat", Source: "other"}
+	if !filterChat(c, &m) {
+		t.Errorf("Chat suppressed by default")
+	}
+
+	c.suppressedNotifications.Store(notifyChat)
+	if filterChat(c, &m) {
+		t.Errorf("Chat not suppressed")
+	}
+
+	for _, mm := range []clientMessage{
+		{Type: "chat", Source: "me"},
+		{Type: "chat", Source: "other", Privileged: true},
+		{Type: "chat", Source: "other", Dest: "me"},
+		{Type: "usermessage", Source: "other"},
+	} {
+		if !filterChat(c, &mm) {
+			t.Errorf("Message %v suppressed", mm)
+		}
+	}
+}
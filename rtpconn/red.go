@@ -0,0 +1,177 @@
+package rtpconn
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+)
+
+// Audio redundancy (RFC 2198) allows a receiver to recover from the loss
+// of a few consecutive audio packets without waiting for a retransmission.
+// Every packet carries, in addition to the current audio frame, copies of
+// the previous ones.  Since we don't know in advance whether the receiver
+// supports RED, we offer both RED and plain Opus, and choose the encoding
+// when the track is bound.
+
+// redDistance is the number of previous frames carried by every packet.
+const redDistance = 2
+
+// localTrack is the interface implemented by the local side of a down
+// track.
+type localTrack interface {
+	webrtc.TrackLocal
+	Codec() webrtc.RTPCodecCapability
+	Write([]byte) (int, error)
+}
+
+type redBlock struct {
+	valid   bool
+	seqno   uint16
+	ts      uint32
+	payload []byte
+}
+
+// redHistory keeps the last few frames sent on a track.
+type redHistory struct {
+	blocks  [redDistance]redBlock
+	started bool
+	last    uint16
+}
+
+// encode encapsulates a frame, together with any suitable previous frames,
+// into a RED payload, which is appended to buf.  The frame is then
+// recorded in the history.  Retransmitted and reordered frames are sent
+// without redundancy, and are not recorded.
+func (h *redHistory) encode(buf []byte, ptype uint8, seqno uint16, ts uint32, payload []byte) []byte {
+	if h.started && int16(seqno-h.last) <= 0 {
+		buf = append(buf, ptype&0x7F)
+		return append(buf, payload...)
+	}
+	h.started = true
+	h.last = seqno
+
+	var blocks [redDistance]*redBlock
+	n := 0
+	for d := redDistance; d >= 1; d-- {
+		b := &h.blocks[int(seqno-uint16(d))%redDistance]
+		if !b.valid || b.seqno != seqno-uint16(d) {
+			continue
+		}
+		offset := ts - b.ts
+		if offset == 0 || offset >= 1<<14 ||
+			len(b.payload) == 0 || len(b.payload) >= 1<<10 {
+			continue
+		}
+		blocks[n] = b
+		n++
+	}
+
+	for _, b := range blocks[:n] {
+		offset := ts - b.ts
+		l := len(b.payload)
+		buf = append(buf,
+			0x80|ptype,
+			byte(offset>>6),
+			byte(offset<<2)|byte(l>>8),
+			byte(l),
+		)
+	}
+	buf = append(buf, ptype&0x7F)
+	for _, b := range blocks[:n] {
+		buf = append(buf, b.payload...)
+	}
+	buf = append(buf, payload...)
+
+	b := &h.blocks[int(seqno)%redDistance]
+	b.valid = true
+	b.seqno = seqno
+	b.ts = ts
+	b.payload = append(b.payload[:0], payload...)
+
+	return buf
+}
+
+// redTrack is a local track that sends RED if the receiver supports it,
+// and plain Opus otherwise.
+type redTrack struct {
+	*webrtc.TrackLocalStaticRTP
+	red    *webrtc.TrackLocalStaticRTP
+	ptype  uint8
+	useRed atomic.Bool
+
+	mu      sync.Mutex
+	history redHistory
+	buf     []byte
+}
+
+func newRedTrack(codec webrtc.RTPCodecCapability, ptype webrtc.PayloadType, id, msid string) (*redTrack, error) {
+	local, err := webrtc.NewTrackLocalStaticRTP(codec, id, msid)
+	if err != nil {
+		return nil, err
+	}
+	red, err := webrtc.NewTrackLocalStaticRTP(
+		group.RedCodec.RTPCodecCapability, id, msid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &redTrack{
+		TrackLocalStaticRTP: local,
+		red:                 red,
+		ptype:               uint8(ptype),
+	}, nil
+}
+
+func (t *redTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.red.Bind(ctx)
+	if err == nil {
+		t.useRed.Store(true)
+		return codec, nil
+	}
+	if !errors.Is(err, webrtc.ErrUnsupportedCodec) {
+		return codec, err
+	}
+	// the receiver doesn't support RED, fall back to plain Opus
+	t.useRed.Store(false)
+	return t.TrackLocalStaticRTP.Bind(ctx)
+}
+
+func (t *redTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	if t.useRed.Load() {
+		return t.red.Unbind(ctx)
+	}
+	return t.TrackLocalStaticRTP.Unbind(ctx)
+}
+
+func (t *redTrack) Write(buf []byte) (int, error) {
+	if !t.useRed.Load() {
+		return t.TrackLocalStaticRTP.Write(buf)
+	}
+
+	var packet rtp.Packet
+	err := packet.Unmarshal(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = t.history.encode(
+		t.buf[:0], t.ptype,
+		packet.SequenceNumber, packet.Timestamp, packet.Payload,
+	)
+	packet.Payload = t.buf
+	packet.Padding = false
+	packet.PaddingSize = 0
+	err = t.red.WriteRTP(&packet)
+	if err != nil {
+		return 0, err
+	}
+	return packet.MarshalSize(), nil
+}
@@ -0,0 +1,120 @@
+package rtpconn
+
+import (
+	"bytes"
+	"testing"
+)
+
+type redTestBlock struct {
+	offset  uint32
+	payload []byte
+}
+
+func parseRed(t *testing.T, buf []byte) ([]redTestBlock, []byte) {
+	var headers []redTestBlock
+	for {
+		if len(buf) < 1 {
+			t.Fatalf("truncated RED header")
+		}
+		if buf[0]&0x80 == 0 {
+			if buf[0] != 111 {
+				t.Errorf("primary ptype %v", buf[0])
+			}
+			buf = buf[1:]
+			break
+		}
+		if len(buf) < 4 {
+			t.Fatalf("truncated RED header")
+		}
+		if buf[0]&0x7F != 111 {
+			t.Errorf("block ptype %v", buf[0]&0x7F)
+		}
+		offset := uint32(buf[1])<<6 | uint32(buf[2])>>2
+		l := int(buf[2]&3)<<8 | int(buf[3])
+		headers = append(headers, redTestBlock{offset, make([]byte, l)})
+		buf = buf[4:]
+	}
+	for i := range headers {
+		l := len(headers[i].payload)
+		if len(buf) < l {
+			t.Fatalf("truncated RED block")
+		}
+		copy(headers[i].payload, buf[:l])
+		buf = buf[l:]
+	}
+	return headers, buf
+}
+
+func TestRedEncode(t *testing.T) {
+	var h redHistory
+	var buf []byte
+
+	buf = h.encode(buf[:0], 111, 65535, 1000, []byte{1})
+	blocks, primary := parseRed(t, buf)
+	if len(blocks) != 0 || !bytes.Equal(primary, []byte{1}) {
+		t.Errorf("first packet: %v %v", blocks, primary)
+	}
+
+	buf = h.encode(buf[:0], 111, 0, 1960, []byte{2, 2})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 1 ||
+		blocks[0].offset != 960 ||
+		!bytes.Equal(blocks[0].payload, []byte{1}) ||
+		!bytes.Equal(primary, []byte{2, 2}) {
+		t.Errorf("second packet: %v %v", blocks, primary)
+	}
+
+	buf = h.encode(buf[:0], 111, 1, 2920, []byte{3, 3, 3})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 2 ||
+		blocks[0].offset != 1920 ||
+		!bytes.Equal(blocks[0].payload, []byte{1}) ||
+		blocks[1].offset != 960 ||
+		!bytes.Equal(blocks[1].payload, []byte{2, 2}) ||
+		!bytes.Equal(primary, []byte{3, 3, 3}) {
+		t.Errorf("third packet: %v %v", blocks, primary)
+	}
+
+	// a gap in sequence numbers
+	buf = h.encode(buf[:0], 111, 3, 4840, []byte{4})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 1 ||
+		blocks[0].offset != 1920 ||
+		!bytes.Equal(blocks[0].payload, []byte{3, 3, 3}) ||
+		!bytes.Equal(primary, []byte{4}) {
+		t.Errorf("packet after gap: %v %v", blocks, primary)
+	}
+
+	// a timestamp jump that doesn't fit in the offset field
+	buf = h.encode(buf[:0], 111, 4, 4840+20000, []byte{5})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 0 || !bytes.Equal(primary, []byte{5}) {
+		t.Errorf("packet after jump: %v %v", blocks, primary)
+	}
+
+	// a payload that is too large to be carried redundantly
+	buf = h.encode(buf[:0], 111, 5, 4840+20960, make([]byte, 1024))
+	buf = h.encode(buf[:0], 111, 6, 4840+21920, []byte{6})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 1 ||
+		blocks[0].offset != 1920 ||
+		!bytes.Equal(blocks[0].payload, []byte{5}) ||
+		!bytes.Equal(primary, []byte{6}) {
+		t.Errorf("packet after large payload: %v %v", blocks, primary)
+	}
+
+	// a retransmission is sent alone, and doesn't affect the history
+	buf = h.encode(buf[:0], 111, 4, 4840+20000, []byte{5})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 0 || !bytes.Equal(primary, []byte{5}) {
+		t.Errorf("retransmitted packet: %v %v", blocks, primary)
+	}
+	buf = h.encode(buf[:0], 111, 7, 4840+22880, []byte{7})
+	blocks, primary = parseRed(t, buf)
+	if len(blocks) != 1 ||
+		blocks[0].offset != 960 ||
+		!bytes.Equal(blocks[0].payload, []byte{6}) ||
+		!bytes.Equal(primary, []byte{7}) {
+		t.Errorf("packet after retransmission: %v %v", blocks, primary)
+	}
+}
@@ -0,0 +1,186 @@
+package rtpconn
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/packetmap"
+	"github.com/jech/galene/rtptime"
+)
+
+// When a stream is replaced by another one (for example when a presenter
+// switches from camera to screenshare), the down tracks are handed over
+// to the new up tracks instead of being recreated.  The rewriter then
+// makes sure that the sequence numbers and timestamps seen by the
+// receiver continue smoothly across the change of source.
+
+type rewriter struct {
+	mu sync.Mutex
+	// the SSRC of the current source, and of the previous one
+	ssrc, prevSsrc     uint32
+	haveSsrc, havePrev bool
+	// whether we have sent a packet from the current source
+	started bool
+	// the deltas applied to the current source
+	seqnoDelta uint16
+	tsDelta    uint32
+	// the last packet sent
+	lastSeqno uint16
+	lastTs    uint32
+	lastTime  time.Time
+}
+
+// check is called for every incoming packet.  It returns false if the
+// packet comes from a previous source and should be dropped.  If the
+// packet comes from a new source, the packetmap is reset and the deltas
+// are recomputed when the packet is rewritten.
+func (r *rewriter) check(ssrc uint32, pm *packetmap.Map) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.haveSsrc && ssrc == r.ssrc {
+		return true
+	}
+	if r.havePrev && ssrc == r.prevSsrc {
+		return false
+	}
+	if r.haveSsrc {
+		r.prevSsrc = r.ssrc
+		r.havePrev = true
+		pm.Reset()
+	}
+	r.ssrc = ssrc
+	r.haveSsrc = true
+	r.started = false
+	return true
+}
+
+// selected is called when the down track is handed over to the source
+// with the given SSRC.  Packets from the previous source are dropped in
+// order to ignore packets that were in flight at the time of the switch,
+// which must not happen if the previous source is selected again, for
+// example when switching between simulcast layers.
+func (r *rewriter) selected(ssrc uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.havePrev && ssrc == r.prevSsrc {
+		r.havePrev = false
+	}
+}
+
+// rewrite returns the sequence number and timestamp that should be sent
+// for a packet from the current source.
+func (r *rewriter) rewrite(seqno uint16, ts uint32, clockrate uint32) (uint16, uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if !r.started {
+		r.started = true
+		if r.lastTime.IsZero() {
+			r.seqnoDelta = 0
+			r.tsDelta = 0
+		} else {
+			r.seqnoDelta = r.lastSeqno + 1 - seqno
+			delay := rtptime.FromDuration(
+				now.Sub(r.lastTime), clockrate,
+			)
+			if delay < 1 {
+				delay = 1
+			}
+			r.tsDelta = r.lastTs + uint32(delay) - ts
+		}
+	}
+
+	seqno += r.seqnoDelta
+	ts += r.tsDelta
+
+	// don't go backwards when resending packets
+	if r.lastTime.IsZero() || int16(seqno-r.lastSeqno) > 0 {
+		r.lastSeqno = seqno
+		r.lastTs = ts
+		r.lastTime = now
+	}
+	return seqno, ts
+}
+
+// reverse maps a sequence number sent to the receiver back to the
+// sequence number space of the packetmap.
+func (r *rewriter) reverse(seqno uint16) uint16 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return seqno - r.seqnoDelta
+}
+
+// timestampDelta returns the delta applied to timestamps.
+func (r *rewriter) timestampDelta() uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tsDelta
+}
+
+// compatibleTracks returns true if down may be handed over to up without
+// renegotiation.
+func compatibleTracks(down *rtpDownTrack, up *rtpUpTrack) bool {
+	c1 := down.track.Codec()
+	c2 := up.Codec()
+	return down.track.Kind() == up.Kind() &&
+		c1.MimeType == c2.MimeType &&
+		c1.ClockRate == c2.ClockRate &&
+		c1.Channels == c2.Channels &&
+		c1.SDPFmtpLine == c2.SDPFmtpLine
+}
+
+// handOverTrack makes down forward the packets of up.  Called with the
+// down connection locked.
+func handOverTrack(down *rtpDownTrack, up *rtpUpTrack) {
+	old := down.getRemote()
+	attached := old.DelLocal(down)
+	down.setRemote(up)
+	down.rewriter.selected(uint32(up.track.SSRC()))
+	layer := down.getLayerInfo()
+	down.setLayerInfo(layerInfo{limitSid: layer.limitSid})
+	if attached {
+		up.AddLocal(down)
+	}
+}
+
+// handOverConn makes the down connection with id replace forward the
+// stream up.  It returns nil if there is no such connection.
+func handOverConn(c *webClient, up conn.Up, replace string) *rtpDownConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if a, ok := c.downAliases[replace]; ok {
+		replace = a
+	}
+	down := c.down[replace]
+	if down == nil {
+		return nil
+	}
+	if other := c.down[up.Id()]; other != nil && other != down {
+		return nil
+	}
+
+	if up.AddLocal(down) != nil {
+		// the up connection has been closed in the meantime
+		return nil
+	}
+	down.remote.DelLocal(down)
+	down.remote = up
+
+	if c.downAliases == nil {
+		c.downAliases = make(map[string]string)
+	}
+	for k, v := range c.downAliases {
+		if v == down.id {
+			delete(c.downAliases, k)
+		}
+	}
+	if up.Id() != down.id {
+		c.downAliases[up.Id()] = down.id
+	}
+	return down
+}
@@ -0,0 +1,92 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/packetmap"
+)
+
+func TestRewriter(t *testing.T) {
+	var r rewriter
+	var pm packetmap.Map
+
+	for i := uint16(0); i < 5; i++ {
+		if !r.check(1, &pm) {
+			t.Fatalf("Packet %v dropped", i)
+		}
+		seqno, ts := r.rewrite(100+i, 1000+uint32(i)*3000, 90000)
+		if seqno != 100+i || ts != 1000+uint32(i)*3000 {
+			t.Errorf("Expected %v %v, got %v %v",
+				100+i, 1000+uint32(i)*3000, seqno, ts)
+		}
+	}
+
+	if !r.check(2, &pm) {
+		t.Fatalf("New source dropped")
+	}
+	seqno, ts := r.rewrite(40000, 5, 90000)
+	if seqno != 105 {
+		t.Errorf("Expected 105, got %v", seqno)
+	}
+	if int32(ts-13000) <= 0 {
+		t.Errorf("Timestamp went backwards: %v", ts)
+	}
+	if r.reverse(105) != 40000 {
+		t.Errorf("Expected 40000, got %v", r.reverse(105))
+	}
+
+	if r.check(1, &pm) {
+		t.Errorf("Packet from old source accepted")
+	}
+
+	if !r.check(2, &pm) {
+		t.Fatalf("Packet dropped")
+	}
+	seqno2, ts2 := r.rewrite(40001, 3005, 90000)
+	if seqno2 != 106 || ts2 != ts+3000 {
+		t.Errorf("Expected 106 %v, got %v %v", ts+3000, seqno2, ts2)
+	}
+
+	// resent packets don't move the reference backwards
+	r.rewrite(40000, 5, 90000)
+	if r.lastSeqno != 106 {
+		t.Errorf("Expected 106, got %v", r.lastSeqno)
+	}
+}
+
+func TestRewriterSwitchBack(t *testing.T) {
+	var r rewriter
+	var pm packetmap.Map
+
+	// A
+	if !r.check(1, &pm) {
+		t.Fatalf("Packet dropped")
+	}
+	r.rewrite(100, 1000, 90000)
+
+	// A -> B
+	r.selected(2)
+	if !r.check(2, &pm) {
+		t.Fatalf("New source dropped")
+	}
+	r.rewrite(5000, 7, 90000)
+	if r.check(1, &pm) {
+		t.Errorf("Late packet from old source accepted")
+	}
+
+	// B -> A
+	r.selected(1)
+	if !r.check(1, &pm) {
+		t.Fatalf("Reselected source dropped")
+	}
+	seqno, _ := r.rewrite(101, 4000, 90000)
+	if seqno != 102 {
+		t.Errorf("Expected 102, got %v", seqno)
+	}
+	if r.check(2, &pm) {
+		t.Errorf("Late packet from old source accepted")
+	}
+	if !r.check(1, &pm) {
+		t.Errorf("Packet dropped")
+	}
+}
@@ -0,0 +1,29 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+
+	"github.com/jech/galene/group"
+)
+
+// rtcpSize returns the size on the wire of a compound RTCP packet.
+func rtcpSize(packets []rtcp.Packet) int {
+	size := 0
+	for _, p := range packets {
+		size += p.MarshalSize()
+	}
+	return size
+}
+
+// rtcpInterval returns the interval until the next periodic report sent
+// to client c, given the size of the previous report and the bitrate
+// of the connection.
+func rtcpInterval(c group.Client, up bool, size int, rate uint64) time.Duration {
+	var policy *group.RTCPPolicy
+	if g := c.Group(); g != nil {
+		policy = g.RTCPPolicy()
+	}
+	return policy.Interval(up, size, rate)
+}
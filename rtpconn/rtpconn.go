@@ -1,6 +1,7 @@
 package rtpconn
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/rtcp"
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
@@ -18,7 +20,6 @@ import (
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/estimator"
 	"github.com/jech/galene/group"
-	"github.com/jech/galene/ice"
 	"github.com/jech/galene/jitter"
 	"github.com/jech/galene/packetcache"
 	"github.com/jech/galene/packetmap"
@@ -81,18 +82,36 @@ type downTrackAtomics struct {
 }
 
 type rtpDownTrack struct {
-	track          *webrtc.TrackLocalStaticRTP
+	track          localTrack
 	sender         *webrtc.RTPSender
 	conn           *rtpDownConnection
-	remote         conn.UpTrack
+	remoteMu       sync.Mutex
+	remote         conn.UpTrack // protected by remoteMu
 	ssrc           webrtc.SSRC
 	packetmap      packetmap.Map
+	rewriter       rewriter
 	maxBitrate     *bitrate
 	maxREMBBitrate *bitrate
 	rate           *estimator.Estimator
 	stats          *receiverStats
 	atomics        *downTrackAtomics
 	cname          atomic.Value
+	resync         bool           // only accessed by Write
+	thumbnail      thumbnailState // only accessed by Write
+	adjustMu       sync.Mutex
+	hysteresis     layerHysteresis // protected by adjustMu
+}
+
+func (down *rtpDownTrack) getRemote() conn.UpTrack {
+	down.remoteMu.Lock()
+	defer down.remoteMu.Unlock()
+	return down.remote
+}
+
+func (down *rtpDownTrack) setRemote(remote conn.UpTrack) {
+	down.remoteMu.Lock()
+	defer down.remoteMu.Unlock()
+	down.remote = remote
 }
 
 func (down *rtpDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
@@ -180,9 +199,33 @@ type rtpDownConnection struct {
 	iceCandidates     []*webrtc.ICECandidateInit
 	negotiationNeeded int
 	requested         []string
+	ceilings          *ceilings
+	group             *group.Group
+	red               bool
+	// whether to protect video with FlexFEC, see fec.go
+	fec bool
+	// whether to send thumbnails of single-layer video, see thumbnails.go
+	thumbnails bool
+	// the TWCC bandwidth estimator, nil if not available
+	bwe cc.BandwidthEstimator
+	// the latest TWCC estimate, updated when we receive feedback
+	twcc        bitrate
+	videoTracks atomic.Int32
+	audioTracks atomic.Int32
+	// whether the connection has been counted in the statistics
+	recorded atomic.Bool
+	// the changes of the selected candidate pair, see icepair.go
+	pairs pairChanges
 
 	mu     sync.Mutex
 	tracks []*rtpDownTrack
+
+	// spare transceivers, see spare.go; protected by mu
+	spareCount   int
+	spareSerial  int
+	spare        []*webrtc.RTPTransceiver
+	retired      []*webrtc.RTPSender
+	trackChanges []trackChange
 }
 
 func (down *rtpDownConnection) getTracks() []*rtpDownTrack {
@@ -194,11 +237,21 @@ func (down *rtpDownConnection) getTracks() []*rtpDownTrack {
 }
 
 func newDownConn(c group.Client, id string, remote conn.Up) (*rtpDownConnection, error) {
-	api, err := c.Group().API()
+	var bwe cc.BandwidthEstimator
+	bweCallback := func(e cc.BandwidthEstimator) {
+		bwe = e
+	}
+	if wc, ok := c.(*webClient); ok && wc.hasQuirk(quirkNoTransportCC) {
+		// don't negotiate the TWCC header extension and feedback
+		// with clients that don't do transport-cc
+		bweCallback = nil
+	}
+	api, red, err := c.Group().DownAPI(bweCallback)
 	if err != nil {
 		return nil, err
 	}
-	pc, err := api.NewPeerConnection(*ice.ICEConfiguration())
+	// this calls the callback above
+	pc, err := api.NewPeerConnection(*c.Group().ICEConfiguration())
 	if err != nil {
 		return nil, err
 	}
@@ -208,9 +261,15 @@ func newDownConn(c group.Client, id string, remote conn.Up) (*rtpDownConnection,
 	})
 
 	conn := &rtpDownConnection{
-		id:     id,
-		pc:     pc,
-		remote: remote,
+		id:         id,
+		pc:         pc,
+		remote:     remote,
+		ceilings:   clientCeilings(c),
+		group:      c.Group(),
+		red:        red,
+		bwe:        bwe,
+		thumbnails: c.Group().Description().Thumbnails,
+		fec:        c.Group().Description().FlexFEC,
 	}
 
 	return conn, nil
@@ -223,13 +282,41 @@ var packetBufPool = sync.Pool{
 }
 
 func (down *rtpDownTrack) Write(buf []byte) (int, error) {
-	codec := down.remote.Codec().MimeType
+	return down.writePacket(buf, false)
+}
 
-	flags, err := codecs.PacketFlags(codec, buf)
+// writePacket maps a packet to the down track and sends it.  If
+// retransmit is true, the packet is a retransmission requested by the
+// receiver.
+func (down *rtpDownTrack) writePacket(buf []byte, retransmit bool) (int, error) {
+	remote := down.getRemote()
+	codec := remote.Codec().MimeType
+	var flags codecs.Flags
+	var err error
+	if down.encrypted() {
+		// don't parse the payload, see encrypted.go
+		codec = ""
+		flags, err = codecs.PacketFlags(codec, buf)
+	} else {
+		flags, err = down.packetFlags(codec, buf)
+	}
 	if err != nil {
 		return 0, err
 	}
 
+	if len(buf) < 12 {
+		return 0, nil
+	}
+	ssrc := binary.BigEndian.Uint32(buf[8:12])
+	if !down.rewriter.check(ssrc, &down.packetmap) {
+		return 0, nil
+	}
+
+	if down.videoGated(flags) || down.audioGated() {
+		down.packetmap.Drop(flags.Seqno, flags.Pid)
+		return 0, nil
+	}
+
 	layer := down.getLayerInfo()
 
 	if flags.Tid > layer.maxTid || flags.Sid > layer.maxSid {
@@ -272,10 +359,17 @@ func (down *rtpDownTrack) Write(buf []byte) (int, error) {
 			layer.sid = layer.wantedSid
 			down.setLayerInfo(layer)
 		} else {
-			down.remote.RequestKeyframe()
+			remote.RequestKeyframe()
 		}
 	}
 
+	ts := binary.BigEndian.Uint32(buf[4:8])
+
+	if down.thumbnailGated(flags, layer, ts, len(buf)) {
+		down.packetmap.Drop(flags.Seqno, flags.Pid)
+		return 0, nil
+	}
+
 	if flags.Tid > layer.tid || flags.Sid > layer.sid ||
 		(flags.Sid < layer.sid && flags.SidNonReference) {
 		ok := down.packetmap.Drop(flags.Seqno, flags.Pid)
@@ -289,25 +383,46 @@ func (down *rtpDownTrack) Write(buf []byte) (int, error) {
 		return 0, nil
 	}
 
+	newseqno, newts := down.rewriter.rewrite(
+		newseqno, ts, down.track.Codec().ClockRate,
+	)
+
 	setMarker := flags.Sid == layer.sid && flags.End && !flags.Marker
+	// the dependency descriptor, see dd.go
+	extension := (buf[0] & 0x10) != 0
 
-	if !setMarker && newseqno == flags.Seqno && piddelta == 0 {
-		return down.write(buf)
+	if !setMarker && newseqno == flags.Seqno && newts == ts &&
+		piddelta == 0 && !extension {
+		return down.write(buf, retransmit)
 	}
 
 	ibuf2 := packetBufPool.Get()
 	defer packetBufPool.Put(ibuf2)
 	buf2 := ibuf2.([]byte)
 
-	n := copy(buf2, buf)
+	n, err := codecs.RemoveExtension(buf2, buf)
+	if err != nil {
+		return 0, err
+	}
 	err = codecs.RewritePacket(codec, buf2[:n], setMarker, newseqno, piddelta)
 	if err != nil {
 		return 0, err
 	}
-	return down.write(buf2[:n])
+	binary.BigEndian.PutUint32(buf2[4:8], newts)
+	return down.write(buf2[:n], retransmit)
 }
 
-func (down *rtpDownTrack) write(buf []byte) (int, error) {
+func (down *rtpDownTrack) write(buf []byte, retransmit bool) (int, error) {
+	if retransmit {
+		if rtx, ok := down.track.(retransmitter); ok {
+			// RTX packets are not counted in the sender reports
+			// of the media stream
+			n, sent, err := rtx.WriteRetransmission(buf)
+			if sent || err != nil {
+				return n, err
+			}
+		}
+	}
 	n, err := down.track.Write(buf)
 	if err == nil {
 		down.rate.Accumulate(uint32(n))
@@ -326,6 +441,32 @@ func (t *rtpDownTrack) GetMaxBitrate() (uint64, int, int) {
 	if rr != 0 && rr < r {
 		r = rr
 	}
+	if t.conn != nil && t.track.Kind() == webrtc.RTPCodecTypeVideo {
+		tw := t.conn.twccShare(now)
+		if tw < r {
+			r = tw
+		}
+		if t.conn.ceilings != nil {
+			c := t.conn.ceilings.downShare()
+			if c != 0 && c < r {
+				r = c
+			}
+		}
+		stream, user := groupBitrates(t.conn.group)
+		if stream != 0 && stream < r {
+			r = stream
+		}
+		if user != 0 && t.conn.ceilings != nil {
+			u := t.conn.ceilings.share(user)
+			if u < r {
+				r = u
+			}
+		}
+		// repair packets are sent on top of the media
+		if fec, ok := t.track.(*fecTrack); ok {
+			r = fec.mediaBitrate(r)
+		}
+	}
 	return r, int(layer.sid), int(layer.tid)
 }
 
@@ -333,12 +474,23 @@ func (t *rtpDownTrack) GetMaxBitrate() (uint64, int, int) {
 // adjusts the layer by one step.  It prefers temporal layers, and only
 // uses spatial layers as a last resort.
 func (t *rtpDownTrack) adjustLayer() {
+	// called both by rtcpDownListener and by Write
+	t.adjustMu.Lock()
+	defer t.adjustMu.Unlock()
+
+	now := rtptime.Jiffies()
 	max, _, _ := t.GetMaxBitrate()
 	r, _ := t.rate.Estimate()
 	rate := uint64(r) * 8
 	if rate < max*7/8 {
 		// switch up
 		layer := t.getLayerInfo()
+		canUp := (layer.limitSid && layer.wantedSid != 0) ||
+			(!layer.limitSid && layer.sid < layer.maxSid) ||
+			layer.tid < layer.maxTid
+		if !canUp || !t.hysteresis.up(now) {
+			return
+		}
 		if layer.limitSid && layer.wantedSid != 0 {
 			layer.wantedSid = 0
 			t.setLayerInfo(layer)
@@ -355,6 +507,7 @@ func (t *rtpDownTrack) adjustLayer() {
 		if layer.tid > 0 {
 			layer.wantedTid = layer.tid - 1
 			t.setLayerInfo(layer)
+			t.hysteresis.down(now)
 		} else if layer.sid > 0 {
 			if layer.limitSid {
 				layer.wantedSid = 0
@@ -362,7 +515,10 @@ func (t *rtpDownTrack) adjustLayer() {
 				layer.wantedSid = layer.sid - 1
 			}
 			t.setLayerInfo(layer)
+			t.hysteresis.down(now)
 		}
+	} else {
+		t.hysteresis.steady(now)
 	}
 }
 
@@ -407,12 +563,27 @@ type rtpUpTrack struct {
 	actions    *unbounded.Channel[trackAction]
 	readerDone chan struct{}
 
+	// whether the track carries end-to-end encrypted frames,
+	// see encrypted.go
+	encrypted atomic.Bool
+	// the id of the dependency descriptor extension, and the latest
+	// dependency structure, see dd.go
+	ddExtension uint8
+	ddStructure atomic.Pointer[codecs.DependencyStructure]
+	// whether the track exceeds the video limits of the group, see
+	// videolimits.go
+	overLimits atomic.Bool
+
 	mu            sync.Mutex
 	srTime        uint64
 	srNTPTime     uint64
 	srRTPTime     uint32
 	local         []conn.DownTrack
 	bufferedNACKs []uint16
+
+	// only accessed by sendUpRTCP, see audioparams.go
+	lossAverage float64
+	audioParams audioParams
 }
 
 type trackActionKind int
@@ -421,6 +592,7 @@ const (
 	trackActionAdd trackActionKind = iota
 	trackActionDel
 	trackActionKeyframe
+	trackActionForcedKeyframe
 )
 
 type trackAction struct {
@@ -459,6 +631,12 @@ func (up *rtpUpTrack) RequestKeyframe() error {
 	return nil
 }
 
+// forceKeyframe requests a keyframe on behalf of a new receiver, see
+// keyframes.go.
+func (up *rtpUpTrack) forceKeyframe() {
+	up.action(trackActionForcedKeyframe, nil)
+}
+
 func (up *rtpUpTrack) DelLocal(local conn.DownTrack) bool {
 	up.mu.Lock()
 	for i, l := range up.local {
@@ -508,6 +686,20 @@ type rtpUpConnection struct {
 	label         string
 	pc            *webrtc.PeerConnection
 	iceCandidates []*webrtc.ICECandidateInit
+	created       uint64
+
+	// accessed atomically, see speakers.go and floor.go
+	lastSpoke       uint64
+	speakersUpdated uint64
+	videoGated      uint32
+	floorUpdated    uint64
+	audioGated      uint32
+	// accessed atomically, see whipfailover.go
+	lastPacket uint64
+	// whether the connection has been counted in the statistics
+	recorded atomic.Bool
+	// the changes of the selected candidate pair, see icepair.go
+	pairs pairChanges
 
 	mu      sync.Mutex
 	closed  bool
@@ -515,6 +707,13 @@ type rtpUpConnection struct {
 	replace string
 	tracks  []*rtpUpTrack
 	local   []conn.Down
+	viewers viewerStats
+	// the ids of the tracks that carry encrypted frames
+	encrypted []string
+	// the id of the stream that this is a fallback for, and whether
+	// we have requested a fallback for this stream, see fallback.go
+	fallback          string
+	fallbackRequested bool
 }
 
 func (up *rtpUpConnection) getTracks() []*rtpUpTrack {
@@ -561,6 +760,7 @@ func (up *rtpUpConnection) AddLocal(local conn.Down) error {
 		}
 	}
 	up.local = append(up.local, local)
+	up.updateViewers(time.Now())
 	return nil
 }
 
@@ -570,6 +770,7 @@ func (up *rtpUpConnection) DelLocal(local conn.Down) bool {
 	for i, l := range up.local {
 		if l == local {
 			up.local = append(up.local[:i], up.local[i+1:]...)
+			up.updateViewers(time.Now())
 			return true
 		}
 	}
@@ -610,7 +811,17 @@ func pushConnNow(up *rtpUpConnection, g *group.Group, cs []group.Client) {
 	}
 	up.mu.Unlock()
 
+	updateSpeakers(g)
+	updateFloor(g)
+
+	if c, ok := up.client.(*WhipClient); ok && c.isStandby() {
+		return
+	}
+
 	for _, c := range cs {
+		if !fallbackRecipient(up, c) {
+			continue
+		}
 		c.PushConn(g, up.id, up, tracks, replace)
 	}
 }
@@ -644,7 +855,7 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 	if err != nil {
 		return nil, err
 	}
-	pc, err := api.NewPeerConnection(*ice.ICEConfiguration())
+	pc, err := api.NewPeerConnection(*c.Group().ICEConfiguration())
 	if err != nil {
 		return nil, err
 	}
@@ -662,7 +873,13 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 		}
 	}
 
-	up := &rtpUpConnection{id: id, client: c, label: label, pc: pc}
+	up := &rtpUpConnection{
+		id:      id,
+		client:  c,
+		label:   label,
+		pc:      pc,
+		created: rtptime.Jiffies(),
+	}
 
 	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		up.mu.Lock()
@@ -676,7 +893,11 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 			jitter:     jitter.New(remote.Codec().ClockRate),
 			actions:    unbounded.New[trackAction](),
 			readerDone: make(chan struct{}),
+			ddExtension: dependencyExtension(
+				receiver, remote.Codec().MimeType,
+			),
 		}
+		track.encrypted.Store(member(remote.ID(), up.encrypted))
 
 		up.tracks = append(up.tracks, track)
 
@@ -767,15 +988,17 @@ func gotNACK(track *rtpDownTrack, p *rtcp.TransportLayerNack) {
 	buf := make([]byte, packetcache.BufSize)
 	for _, nack := range p.Nacks {
 		nack.Range(func(s uint16) bool {
-			ok, seqno, _ := track.packetmap.Reverse(s)
+			ok, seqno, _ := track.packetmap.Reverse(
+				track.rewriter.reverse(s),
+			)
 			if !ok {
 				return true
 			}
-			l := track.remote.GetPacket(seqno, buf, true)
+			l := track.getRemote().GetPacket(seqno, buf, true)
 			if l == 0 {
 				return true
 			}
-			_, err := track.Write(buf[:l])
+			_, err := track.writePacket(buf[:l], true)
 			if err != nil {
 				log.Printf("Write: %v", err)
 				return false
@@ -869,7 +1092,7 @@ func rtcpUpListener(track *rtpUpTrack) {
 			for _, l := range local {
 				l, ok := l.(*rtpDownConnection)
 				if ok {
-					err := sendSR(l)
+					_, _, err := sendSR(l)
 					if err != nil {
 						log.Printf("sendSR: %v", err)
 					}
@@ -929,22 +1152,27 @@ func maxUpBitrate(t *rtpUpTrack) uint64 {
 	return maxrate
 }
 
-func sendUpRTCP(up *rtpUpConnection) error {
+// sendUpRTCP sends receiver reports on an up connection.  It returns
+// the size of the reports and the total bitrate of the connection.
+func sendUpRTCP(up *rtpUpConnection) (int, uint64, error) {
 	tracks := up.getTracks()
 
 	if len(up.tracks) == 0 {
 		state := up.pc.ConnectionState()
 		if state == webrtc.PeerConnectionStateClosed {
-			return io.ErrClosedPipe
+			return 0, 0, io.ErrClosedPipe
 		}
-		return nil
+		return 0, 0, nil
 	}
 
 	now := rtptime.Jiffies()
 
+	var bitrate uint64
 	reports := make([]rtcp.ReceptionReport, 0, len(up.tracks))
 	for _, t := range tracks {
 		updateUpTrack(t)
+		r, _ := t.rate.Estimate()
+		bitrate += uint64(r) * 8
 		stats := t.cache.GetStats(true)
 		var totalLost uint32
 		if stats.TotalExpected > stats.TotalReceived {
@@ -959,6 +1187,17 @@ func sendUpRTCP(up *rtpUpConnection) error {
 			}
 		}
 
+		if stats.Expected > 0 {
+			p, changed := t.updateAudioParams(fractionLost)
+			if c, ok := up.client.(*webClient); ok && changed {
+				c.write(clientMessage{
+					Type:  "audioParams",
+					Id:    up.id,
+					Value: p,
+				})
+			}
+		}
+
 		t.mu.Lock()
 		srTime := t.srTime
 		srNTPTime := t.srNTPTime
@@ -1006,6 +1245,7 @@ func sendUpRTCP(up *rtpUpConnection) error {
 	if rate > group.MaxBitrate {
 		rate = group.MaxBitrate
 	}
+	rate = limitBitrate(up.client, rate)
 	if len(ssrcs) > 0 {
 		packets = append(packets,
 			&rtcp.ReceiverEstimatedMaximumBitrate{
@@ -1014,23 +1254,27 @@ func sendUpRTCP(up *rtpUpConnection) error {
 			},
 		)
 	}
-	return up.pc.WriteRTCP(packets)
+	return rtcpSize(packets), bitrate, up.pc.WriteRTCP(packets)
 }
 
 func rtcpUpSender(conn *rtpUpConnection) {
+	interval := group.DefaultUpRTCPInterval
 	for {
-		time.Sleep(time.Second)
-		err := sendUpRTCP(conn)
+		time.Sleep(interval)
+		size, rate, err := sendUpRTCP(conn)
 		if err != nil {
 			if err == io.EOF || err == io.ErrClosedPipe {
 				return
 			}
 			log.Printf("sendUpRTCP: %v", err)
 		}
+		interval = rtcpInterval(conn.client, true, size, rate)
 	}
 }
 
-func sendSR(conn *rtpDownConnection) error {
+// sendSR sends sender reports on a down connection.  It returns the size
+// of the reports and the total bitrate of the connection.
+func sendSR(conn *rtpDownConnection) (int, uint64, error) {
 	tracks := conn.getTracks()
 
 	packets := make([]rtcp.Packet, 0, len(tracks))
@@ -1039,8 +1283,11 @@ func sendSR(conn *rtpDownConnection) error {
 	nowNTP := rtptime.TimeToNTP(now)
 	jiffies := rtptime.TimeToJiffies(now)
 
+	var bitrate uint64
 	for _, t := range tracks {
 		clockrate := t.track.Codec().ClockRate
+		r, _ := t.rate.Estimate()
+		bitrate += uint64(r) * 8
 
 		var nowRTP uint32
 
@@ -1052,7 +1299,8 @@ func sendSR(conn *rtpDownConnection) error {
 				delay := rtptime.FromDuration(
 					d, clockrate,
 				)
-				nowRTP = remoteRTP + uint32(delay)
+				nowRTP = remoteRTP + uint32(delay) +
+					t.rewriter.timestampDelta()
 			}
 
 			p, b := t.rate.Totals()
@@ -1089,24 +1337,26 @@ func sendSR(conn *rtpDownConnection) error {
 	if len(packets) == 0 {
 		state := conn.pc.ConnectionState()
 		if state == webrtc.PeerConnectionStateClosed {
-			return io.ErrClosedPipe
+			return 0, 0, io.ErrClosedPipe
 		}
-		return nil
+		return 0, 0, nil
 	}
 
-	return conn.pc.WriteRTCP(packets)
+	return rtcpSize(packets), bitrate, conn.pc.WriteRTCP(packets)
 }
 
-func rtcpDownSender(conn *rtpDownConnection) {
+func rtcpDownSender(c group.Client, conn *rtpDownConnection) {
+	interval := group.DefaultDownRTCPInterval
 	for {
-		time.Sleep(time.Second / 2)
-		err := sendSR(conn)
+		time.Sleep(interval)
+		size, rate, err := sendSR(conn)
 		if err != nil {
 			if err == io.EOF || err == io.ErrClosedPipe {
 				return
 			}
 			log.Printf("sendSR: %v", err)
 		}
+		interval = rtcpInterval(c, false, size, rate)
 	}
 }
 
@@ -1171,7 +1421,7 @@ func rtcpDownListener(track *rtpDownTrack) {
 		for _, p := range ps {
 			switch p := p.(type) {
 			case *rtcp.PictureLossIndication:
-				track.remote.RequestKeyframe()
+				track.getRemote().RequestKeyframe()
 			case *rtcp.FullIntraRequest:
 				found := false
 				var seqno uint8
@@ -1188,7 +1438,7 @@ func rtcpDownListener(track *rtpDownTrack) {
 				}
 
 				if seqno != lastFirSeqno {
-					track.remote.RequestKeyframe()
+					track.getRemote().RequestKeyframe()
 				}
 			case *rtcp.ReceiverEstimatedMaximumBitrate:
 				rate := uint64(p.Bitrate + 0.5)
@@ -1209,6 +1459,15 @@ func rtcpDownListener(track *rtpDownTrack) {
 				}
 			case *rtcp.TransportLayerNack:
 				gotNACK(track, p)
+			case *rtcp.TransportLayerCC:
+				// the estimator has already seen this packet
+				if bwe := track.conn.bwe; bwe != nil {
+					track.conn.twcc.Set(
+						uint64(bwe.GetTargetBitrate()),
+						jiffies,
+					)
+					adjust = true
+				}
 			}
 		}
 		if adjust {
@@ -1220,6 +1479,9 @@ func rtcpDownListener(track *rtpDownTrack) {
 func handleReport(track *rtpDownTrack, report rtcp.ReceptionReport, jiffies uint64) {
 	track.stats.Set(report.FractionLost, report.Jitter, jiffies)
 	track.updateRate(report.FractionLost, jiffies)
+	if fec, ok := track.track.(*fecTrack); ok {
+		fec.setLoss(report.FractionLost)
+	}
 
 	if report.LastSenderReport != 0 {
 		jiffies := rtptime.Jiffies()
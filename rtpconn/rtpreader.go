@@ -3,7 +3,7 @@ package rtpconn
 import (
 	"io"
 	"log"
-	"time"
+	"sync/atomic"
 
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
@@ -24,8 +24,13 @@ func readLoop(track *rtpUpTrack) {
 	codec := track.track.Codec()
 	sendNACK := track.hasRtcpFb("nack", "")
 	sendPLI := track.hasRtcpFb("nack", "pli")
-	var kfNeeded bool
-	var kfRequested time.Time
+	var audioLevel uint8
+	if !isvideo {
+		audioLevel = audioLevelExtension(track.receiver)
+	}
+	var speech speechDetector
+	var keyframes keyframeLimiter
+	var meter videoMeter
 	buf := make([]byte, packetcache.BufSize)
 	var packet rtp.Packet
 	for {
@@ -47,7 +52,9 @@ func readLoop(track *rtpUpTrack) {
 						)
 					}
 				case trackActionKeyframe:
-					kfNeeded = true
+					keyframes.request(false)
+				case trackActionForcedKeyframe:
+					keyframes.request(true)
 				default:
 					log.Printf("Unknown action")
 				}
@@ -71,12 +78,41 @@ func readLoop(track *rtpUpTrack) {
 		}
 
 		track.jitter.Accumulate(packet.Timestamp)
-
-		kf, kfKnown := codecs.Keyframe(codec.MimeType, &packet)
-		if kf || !kfKnown {
-			kfNeeded = false
+		atomic.StoreUint64(&track.conn.lastPacket, rtptime.Jiffies())
+
+		encrypted := track.encrypted.Load()
+		var kf bool
+		if !encrypted {
+			var kfKnown bool
+			kf, kfKnown = codecs.Keyframe(codec.MimeType, &packet)
+			if kf || !kfKnown {
+				keyframes.keyframe()
+			}
+			if kf && isvideo {
+				meter.keyframe(codecs.KeyframeDimensions(
+					codec.MimeType, &packet,
+				))
+			}
+		}
+		if isvideo && meter.packet(packet.Timestamp, rtptime.Jiffies()) {
+			track.checkVideoLimits(&meter)
+		}
+		if audioLevel != 0 && isSpeech(&packet, audioLevel) {
+			now := rtptime.Jiffies()
+			if speech.speaking(now) {
+				track.conn.spoke(now)
+			}
 		}
-		if packet.Extension {
+
+		if track.ddExtension != 0 {
+			if track.gotDependencyDescriptor(&packet) {
+				bytes, err = packet.MarshalTo(buf)
+				if err != nil {
+					log.Printf("%v", err)
+					continue
+				}
+			}
+		} else if packet.Extension {
 			packet.Extension = false
 			packet.Extensions = nil
 			bytes, err = packet.MarshalTo(buf)
@@ -133,18 +169,19 @@ func readLoop(track *rtpUpTrack) {
 		writers.write(packet.SequenceNumber, index, delay,
 			isvideo, packet.Marker)
 
-		now := time.Now()
-		if kfNeeded && now.Sub(kfRequested) > time.Second/2 {
+		if keyframes.check(rtptime.Jiffies()) {
 			if sendPLI {
 				err := track.sendPLI()
 				if err != nil {
 					log.Printf("sendPLI: %v", err)
-					kfNeeded = false
+					keyframes.cancel()
+				} else if encrypted {
+					// we won't see the keyframe
+					keyframes.keyframe()
 				}
 			} else {
-				kfNeeded = false
+				keyframes.cancel()
 			}
-			kfRequested = now
 		}
 	}
 }
@@ -1,9 +1,12 @@
 package rtpconn
 
 import (
+	"net"
 	"sort"
 	"time"
 
+	"github.com/pion/webrtc/v4"
+
 	"github.com/jech/galene/rtptime"
 	"github.com/jech/galene/stats"
 )
@@ -13,12 +16,17 @@ func (c *webClient) GetStats() *stats.Client {
 	defer c.mu.Unlock()
 
 	cs := stats.Client{
-		Id: c.id,
+		Id:          c.id,
+		ClockOffset: stats.Duration(c.clockOffset),
 	}
 
+	now := time.Now()
 	for _, up := range c.up {
+		viewers := up.getViewers(now)
 		conns := stats.Conn{
-			Id: up.id,
+			Id:          up.id,
+			Viewers:     &viewers,
+			PairChanges: up.pairs.get(),
 		}
 		tracks := up.getTracks()
 		for _, t := range tracks {
@@ -47,7 +55,8 @@ func (c *webClient) GetStats() *stats.Client {
 	jiffies := rtptime.Jiffies()
 	for _, down := range c.down {
 		conns := stats.Conn{
-			Id: down.id,
+			Id:          down.id,
+			PairChanges: down.pairs.get(),
 		}
 		for _, t := range down.tracks {
 			layer := t.getLayerInfo()
@@ -82,3 +91,40 @@ func (c *webClient) GetStats() *stats.Client {
 
 	return &cs
 }
+
+// recordConnection records the metadata of a newly established connection
+// in the server-wide connection statistics.
+func recordConnection(pc *webrtc.PeerConnection, addr net.Addr) {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+
+	var transport, candidate string
+	for _, t := range pc.GetTransceivers() {
+		var dtls *webrtc.DTLSTransport
+		if r := t.Receiver(); r != nil {
+			dtls = r.Transport()
+		}
+		if dtls == nil {
+			if s := t.Sender(); s != nil {
+				dtls = s.Transport()
+			}
+		}
+		if dtls == nil {
+			continue
+		}
+		pair, err := dtls.ICETransport().GetSelectedCandidatePair()
+		if err != nil || pair == nil || pair.Remote == nil {
+			continue
+		}
+		transport = pair.Remote.Protocol.String()
+		candidate = pair.Remote.Typ.String()
+		break
+	}
+
+	stats.RecordConnection(ip, transport, candidate)
+}
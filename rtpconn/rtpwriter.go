@@ -265,7 +265,7 @@ func rtpWriterLoop(writer *rtpWriter, track *rtpUpTrack) {
 							track.cache,
 						)
 					} else {
-						track.RequestKeyframe()
+						track.forceKeyframe()
 					}
 				} else {
 					// no keyframe yet, one should
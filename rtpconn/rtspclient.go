@@ -0,0 +1,202 @@
+package rtpconn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+// An RtspClient publishes the stream of an RTSP camera in a group.  The
+// RTSP session is handled by the rtsp package, which passes the packets
+// received from the camera to WriteRTP; they are sent to the group
+// through a local peer connection, see loopbackConn.  An RtspClient is
+// a system client, and never receives any media.
+type RtspClient struct {
+	group    *group.Group
+	addr     net.Addr
+	id       string
+	username string
+	done     chan struct{}
+
+	mu         sync.Mutex
+	connection *rtpUpConnection
+	pc         *webrtc.PeerConnection
+	tracks     []*webrtc.TrackLocalStaticRTP
+	closed     bool
+}
+
+// NewRtspClient creates a client for a camera at address addr.
+func NewRtspClient(g *group.Group, id, username string, addr net.Addr) *RtspClient {
+	return &RtspClient{
+		group:    g,
+		addr:     addr,
+		id:       id,
+		username: username,
+		done:     make(chan struct{}),
+	}
+}
+
+func (c *RtspClient) Group() *group.Group {
+	return c.group
+}
+
+func (c *RtspClient) Addr() net.Addr {
+	return c.addr
+}
+
+func (c *RtspClient) Id() string {
+	return c.id
+}
+
+func (c *RtspClient) Username() string {
+	return c.username
+}
+
+func (c *RtspClient) SetUsername(username string) {
+}
+
+func (c *RtspClient) Permissions() []string {
+	return []string{"system"}
+}
+
+func (c *RtspClient) SetPermissions(perms []string) {
+}
+
+func (c *RtspClient) Data() map[string]interface{} {
+	return nil
+}
+
+// Done returns a channel that is closed when the client is closed, for
+// example because it was kicked.  The RTSP session should then be torn
+// down.
+func (c *RtspClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Connect creates the connection that carries the camera's stream to the
+// group, with one track for every codec.  It must be called after the
+// client has joined the group.
+func (c *RtspClient) Connect(ctx context.Context, codecs []webrtc.RTPCodecCapability) error {
+	tracks := make([]*webrtc.TrackLocalStaticRTP, 0, len(codecs))
+	locals := make([]webrtc.TrackLocal, 0, len(codecs))
+	for _, codec := range codecs {
+		kind, _, _ := strings.Cut(strings.ToLower(codec.MimeType), "/")
+		track, err := webrtc.NewTrackLocalStaticRTP(codec, kind, c.id)
+		if err != nil {
+			return err
+		}
+		tracks = append(tracks, track)
+		locals = append(locals, track)
+	}
+
+	up, pc, err := loopbackConn(ctx, c, c.id, "camera", locals,
+		func() { c.Close() },
+	)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.connection != nil {
+		up.pc.Close()
+		pc.Close()
+		return errors.New("duplicate connection")
+	}
+	c.connection = up
+	c.pc = pc
+	c.tracks = tracks
+	return nil
+}
+
+// WriteRTP sends a packet to the group on the track with index i in the
+// list of codecs passed to Connect.
+func (c *RtspClient) WriteRTP(i int, p *rtp.Packet) error {
+	c.mu.Lock()
+	var track *webrtc.TrackLocalStaticRTP
+	if i < len(c.tracks) {
+		track = c.tracks[i]
+	}
+	c.mu.Unlock()
+	if track == nil {
+		return nil
+	}
+	return track.WriteRTP(p)
+}
+
+func (c *RtspClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+func (c *RtspClient) PushClient(group, kind, id, username string, permissions []string, status map[string]interface{}) error {
+	return nil
+}
+
+func (c *RtspClient) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *RtspClient) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	up := c.connection
+	c.mu.Unlock()
+	if up == nil || (id != "" && id != up.Id()) {
+		return nil
+	}
+	tracks := up.getTracks()
+	ts := make([]conn.UpTrack, len(tracks))
+	for i, t := range tracks {
+		ts[i] = t
+	}
+	target.PushConn(g, up.Id(), up, ts, "")
+	return nil
+}
+
+func (c *RtspClient) Kick(id string, user *string, message string) error {
+	return c.Close()
+}
+
+func (c *RtspClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	up := c.connection
+	c.connection = nil
+	pc := c.pc
+	c.pc = nil
+	c.tracks = nil
+	c.mu.Unlock()
+
+	g := c.group
+	if up != nil {
+		up.mu.Lock()
+		up.closed = true
+		up.mu.Unlock()
+		up.pc.OnICEConnectionStateChange(nil)
+		up.pc.Close()
+		for _, cc := range g.GetClients(c) {
+			cc.PushConn(g, up.Id(), nil, nil, "")
+		}
+	}
+	if pc != nil {
+		pc.Close()
+	}
+	group.DelClient(c)
+	return nil
+}
@@ -0,0 +1,125 @@
+package rtpconn
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+// Retransmissions (RFC 4588) are sent on a separate SSRC, so that the
+// receiver doesn't count them in its jitter and loss statistics, and so
+// that the bandwidth estimator can tell them apart from media.  A
+// retransmitted packet carries the original sequence number in the first
+// two bytes of its payload.  If the receiver doesn't support RTX, we
+// retransmit on the original SSRC.
+
+// retransmitter is implemented by the local tracks that may send
+// retransmissions on a separate stream.
+type retransmitter interface {
+	WriteRetransmission(buf []byte) (int, bool, error)
+}
+
+// rtxTrack is a local video track that may send retransmissions as RTX.
+type rtxTrack struct {
+	*webrtc.TrackLocalStaticRTP
+
+	mu     sync.Mutex
+	ssrc   uint32
+	ptype  uint8
+	seqno  uint16
+	writer webrtc.TrackLocalWriter
+}
+
+func newRTXTrack(codec webrtc.RTPCodecCapability, id, msid string) (*rtxTrack, error) {
+	local, err := webrtc.NewTrackLocalStaticRTP(codec, id, msid)
+	if err != nil {
+		return nil, err
+	}
+	return &rtxTrack{
+		TrackLocalStaticRTP: local,
+		seqno:               uint16(rand.Uint32()),
+	}, nil
+}
+
+// rtxPayloadType returns the RTX payload type associated with ptype.
+func rtxPayloadType(codecs []webrtc.RTPCodecParameters, ptype webrtc.PayloadType) (uint8, bool) {
+	apt := "apt=" + strconv.Itoa(int(ptype))
+	for _, c := range codecs {
+		if !strings.EqualFold(c.MimeType, webrtc.MimeTypeRTX) {
+			continue
+		}
+		for _, f := range strings.Split(c.SDPFmtpLine, ";") {
+			if strings.TrimSpace(f) == apt {
+				return uint8(c.PayloadType), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (t *rtxTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codec, err := t.TrackLocalStaticRTP.Bind(ctx)
+	if err != nil {
+		return codec, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writer = nil
+	ptype, ok := rtxPayloadType(ctx.CodecParameters(), codec.PayloadType)
+	if ok && ctx.SSRCRetransmission() != 0 {
+		t.ssrc = uint32(ctx.SSRCRetransmission())
+		t.ptype = ptype
+		t.writer = ctx.WriteStream()
+	}
+	return codec, nil
+}
+
+func (t *rtxTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	t.mu.Lock()
+	t.writer = nil
+	t.mu.Unlock()
+	return t.TrackLocalStaticRTP.Unbind(ctx)
+}
+
+// rtxEncode turns packet into an RTX packet.
+func rtxEncode(packet *rtp.Packet, ssrc uint32, ptype uint8, seqno uint16) {
+	payload := make([]byte, 2+len(packet.Payload))
+	binary.BigEndian.PutUint16(payload, packet.SequenceNumber)
+	copy(payload[2:], packet.Payload)
+	packet.SSRC = ssrc
+	packet.PayloadType = ptype
+	packet.SequenceNumber = seqno
+	packet.Payload = payload
+	packet.Padding = false
+	packet.PaddingSize = 0
+}
+
+// WriteRetransmission sends a retransmitted packet on the RTX stream.  The
+// boolean is false if the receiver doesn't support RTX, in which case
+// nothing was sent.
+func (t *rtxTrack) WriteRetransmission(buf []byte) (int, bool, error) {
+	var packet rtp.Packet
+	err := packet.Unmarshal(buf)
+	if err != nil {
+		return 0, false, err
+	}
+
+	t.mu.Lock()
+	writer := t.writer
+	if writer == nil {
+		t.mu.Unlock()
+		return 0, false, nil
+	}
+	rtxEncode(&packet, t.ssrc, t.ptype, t.seqno)
+	t.seqno++
+	t.mu.Unlock()
+
+	n, err := writer.WriteRTP(&packet.Header, packet.Payload)
+	return n, true, err
+}
@@ -0,0 +1,197 @@
+package rtpconn
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+)
+
+func TestRTXEncode(t *testing.T) {
+	packet := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         true,
+			PayloadType:    96,
+			SequenceNumber: 4242,
+			Timestamp:      1234567,
+			SSRC:           17,
+		},
+		Payload: []byte{1, 2, 3, 4},
+	}
+	rtxEncode(&packet, 18, 97, 65535)
+
+	if packet.SSRC != 18 || packet.PayloadType != 97 ||
+		packet.SequenceNumber != 65535 {
+		t.Errorf("Bad header %v", packet.Header)
+	}
+	if !packet.Marker || packet.Timestamp != 1234567 {
+		t.Errorf("Header not preserved: %v", packet.Header)
+	}
+	if !bytes.Equal(packet.Payload, []byte{0x10, 0x92, 1, 2, 3, 4}) {
+		t.Errorf("Bad payload %v", packet.Payload)
+	}
+}
+
+func TestRTXPayloadType(t *testing.T) {
+	codecs := []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType: webrtc.MimeTypeVP8, ClockRate: 90000,
+			},
+			PayloadType: 96,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType: webrtc.MimeTypeRTX, ClockRate: 90000,
+				SDPFmtpLine: "apt=96",
+			},
+			PayloadType: 97,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType: webrtc.MimeTypeRTX, ClockRate: 90000,
+				SDPFmtpLine: "apt=102",
+			},
+			PayloadType: 125,
+		},
+	}
+	pt, ok := rtxPayloadType(codecs, 96)
+	if !ok || pt != 97 {
+		t.Errorf("Expected 97, got %v %v", pt, ok)
+	}
+	pt, ok = rtxPayloadType(codecs, 102)
+	if !ok || pt != 125 {
+		t.Errorf("Expected 125, got %v %v", pt, ok)
+	}
+	_, ok = rtxPayloadType(codecs, 98)
+	if ok {
+		t.Errorf("Found RTX for unknown ptype")
+	}
+}
+
+func negotiateRTX(t *testing.T, pc2 *webrtc.PeerConnection) (*rtxTrack, string) {
+	api, err := group.APIFromNames([]string{"vp8"})
+	if err != nil {
+		t.Fatalf("APIFromNames: %v", err)
+	}
+	pc1, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	t.Cleanup(func() { pc1.Close() })
+
+	codec := webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			"video/VP8", 90000, 0, "", group.VideoRTCPFeedback,
+		},
+		PayloadType: 96,
+	}
+	local, err := newRTXTrack(codec.RTPCodecCapability, "video", "stream")
+	if err != nil {
+		t.Fatalf("newRTXTrack: %v", err)
+	}
+	tr, err := pc1.AddTransceiverFromTrack(local,
+		webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly,
+		},
+	)
+	if err != nil {
+		t.Fatalf("AddTransceiverFromTrack: %v", err)
+	}
+	rtx, ok := group.RTXCodec(codec)
+	if !ok {
+		t.Fatalf("No RTX codec")
+	}
+	err = tr.SetCodecPreferences([]webrtc.RTPCodecParameters{codec, rtx})
+	if err != nil {
+		t.Fatalf("SetCodecPreferences: %v", err)
+	}
+
+	return local, offerAnswer(t, pc1, pc2)
+}
+
+// offerAnswer negotiates between pc1 and pc2, and returns the offer.
+func offerAnswer(t *testing.T, pc1, pc2 *webrtc.PeerConnection) string {
+	offer, err := pc1.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	err = pc1.SetLocalDescription(offer)
+	if err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	err = pc2.SetRemoteDescription(offer)
+	if err != nil {
+		t.Fatalf("SetRemoteDescription: %v", err)
+	}
+	answer, err := pc2.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer: %v", err)
+	}
+	err = pc2.SetLocalDescription(answer)
+	if err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	err = pc1.SetRemoteDescription(answer)
+	if err != nil {
+		t.Fatalf("SetRemoteDescription: %v", err)
+	}
+	return offer.SDP
+}
+
+func TestRTXNegotiation(t *testing.T) {
+	api, err := group.APIFromNames([]string{"vp8"})
+	if err != nil {
+		t.Fatalf("APIFromNames: %v", err)
+	}
+	pc2, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc2.Close()
+
+	local, sdp := negotiateRTX(t, pc2)
+	if !strings.Contains(sdp, "a=fmtp:97 apt=96") ||
+		!strings.Contains(sdp, "a=ssrc-group:FID") {
+		t.Errorf("RTX not offered: %v", sdp)
+	}
+
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	if local.writer == nil || local.ptype != 97 || local.ssrc == 0 {
+		t.Errorf("RTX not bound: %v %v", local.ptype, local.ssrc)
+	}
+}
+
+func TestRTXUnsupported(t *testing.T) {
+	m := &webrtc.MediaEngine{}
+	err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: webrtc.MimeTypeVP8, ClockRate: 90000,
+		},
+		PayloadType: 96,
+	}, webrtc.RTPCodecTypeVideo)
+	if err != nil {
+		t.Fatalf("RegisterCodec: %v", err)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(m))
+	pc2, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc2.Close()
+
+	local, _ := negotiateRTX(t, pc2)
+
+	_, sent, err := local.WriteRetransmission(
+		[]byte{0x80, 96, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 42},
+	)
+	if sent || err != nil {
+		t.Errorf("Expected fallback, got %v %v", sent, err)
+	}
+}
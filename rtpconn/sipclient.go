@@ -0,0 +1,520 @@
+package rtpconn
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// A SipClient is a telephone call bridged into a group by the SIP gateway.
+// The caller's audio is sent to the group through a local peer connection,
+// see loopbackConn.  In the other direction, if the call uses G.711, the
+// audio of all the participants that use G.711 is mixed, see sipmixer.go;
+// otherwise, the audio of the most recent speaker is forwarded to the
+// caller, as long as it uses the same codec as the call.
+
+// the time during which a speaker keeps the line after they stop speaking
+const sipSpeakerHold = rtptime.JiffiesPerSec
+
+type SipClient struct {
+	group *group.Group
+	addr  net.Addr
+	id    string
+	codec webrtc.RTPCodecCapability
+	pt    uint8
+	out   io.Writer
+	done  chan struct{}
+	// non-nil if the call uses G.711
+	mixer *sipMixer
+
+	mu          sync.Mutex
+	username    string
+	permissions []string
+	connection  *rtpUpConnection
+	pc          *webrtc.PeerConnection
+	track       *webrtc.TrackLocalStaticRTP
+	down        map[string]*sipDown
+	closed      bool
+
+	// the state of the stream sent to the caller, protected by mu
+	speaker  *rtpUpConnection
+	ssrc     uint32
+	seqno    uint16
+	tsOffset uint32
+	lastTs   uint32
+	lastTime uint64
+	sent     bool
+}
+
+// NewSipClient creates a client for a call that uses the given codec and
+// payload type.  The RTP packets destined to the caller are written to
+// out.
+func NewSipClient(g *group.Group, id string, addr net.Addr, codec webrtc.RTPCodecCapability, pt uint8, out io.Writer) *SipClient {
+	var ssrc [4]byte
+	crand.Read(ssrc[:])
+	c := &SipClient{
+		group: g,
+		addr:  addr,
+		id:    id,
+		codec: codec,
+		pt:    pt,
+		out:   out,
+		done:  make(chan struct{}),
+		ssrc:  binary.BigEndian.Uint32(ssrc[:]),
+	}
+	if isG711(codec) {
+		c.mixer = &sipMixer{}
+	}
+	return c
+}
+
+func (c *SipClient) Group() *group.Group {
+	return c.group
+}
+
+func (c *SipClient) Addr() net.Addr {
+	return c.addr
+}
+
+func (c *SipClient) Id() string {
+	return c.id
+}
+
+func (c *SipClient) Username() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.username
+}
+
+func (c *SipClient) SetUsername(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+}
+
+func (c *SipClient) Permissions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.permissions
+}
+
+func (c *SipClient) SetPermissions(perms []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.permissions = perms
+}
+
+func (c *SipClient) Data() map[string]interface{} {
+	return nil
+}
+
+// Done returns a channel that is closed when the client is closed, for
+// example because it was kicked.  The gateway should then hang up.
+func (c *SipClient) Done() <-chan struct{} {
+	return c.done
+}
+
+var ErrCodecNotAllowed = group.UserError(
+	"the codec of the call is not allowed in this group",
+)
+
+// Connect creates the connection that carries the caller's audio to the
+// group.  It must be called after the client has joined the group.
+func (c *SipClient) Connect(ctx context.Context) error {
+	allowed := false
+	for _, codec := range c.group.Codecs() {
+		if strings.EqualFold(codec.MimeType, c.codec.MimeType) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return ErrCodecNotAllowed
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(c.codec, "audio", c.id)
+	if err != nil {
+		return err
+	}
+	up, pc, err := loopbackConn(ctx, c, c.id, "",
+		[]webrtc.TrackLocal{track}, func() { c.Close() },
+	)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.connection != nil {
+		up.pc.Close()
+		pc.Close()
+		return errors.New("duplicate connection")
+	}
+	c.connection = up
+	c.pc = pc
+	c.track = track
+	if c.mixer != nil {
+		go c.mixLoop()
+	}
+	return nil
+}
+
+// WriteRTP sends a packet received from the caller to the group.
+func (c *SipClient) WriteRTP(p *rtp.Packet) error {
+	c.mu.Lock()
+	track := c.track
+	c.mu.Unlock()
+	if track == nil {
+		return nil
+	}
+	return track.WriteRTP(p)
+}
+
+func (c *SipClient) PushClient(group, kind, id, username string, permissions []string, status map[string]interface{}) error {
+	return nil
+}
+
+func (c *SipClient) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *SipClient) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	up := c.connection
+	c.mu.Unlock()
+	if up == nil {
+		return nil
+	}
+	tracks := up.getTracks()
+	ts := make([]conn.UpTrack, len(tracks))
+	for i, t := range tracks {
+		ts[i] = t
+	}
+	target.PushConn(g, up.Id(), up, ts, "")
+	return nil
+}
+
+func (c *SipClient) Kick(id string, user *string, message string) error {
+	return c.Close()
+}
+
+func (c *SipClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	up := c.connection
+	c.connection = nil
+	pc := c.pc
+	c.pc = nil
+	c.track = nil
+	down := c.down
+	c.down = nil
+	c.speaker = nil
+	c.mu.Unlock()
+
+	for _, d := range down {
+		d.close()
+	}
+
+	g := c.group
+	if up != nil {
+		up.mu.Lock()
+		up.closed = true
+		up.mu.Unlock()
+		up.pc.OnICEConnectionStateChange(nil)
+		up.pc.Close()
+		for _, cc := range g.GetClients(c) {
+			cc.PushConn(g, up.Id(), nil, nil, "")
+		}
+		go updateSpeakers(g)
+	}
+	if pc != nil {
+		pc.Close()
+	}
+	group.DelClient(c)
+	return nil
+}
+
+// sipDown is the set of tracks of a remote connection that are forwarded
+// to the caller.
+type sipDown struct {
+	remote conn.Up
+	tracks []*sipDownTrack
+}
+
+func (d *sipDown) close() {
+	d.remote.DelLocal(d)
+	for _, t := range d.tracks {
+		t.remote.DelLocal(t)
+	}
+}
+
+type sipDownTrack struct {
+	client *SipClient
+	remote conn.UpTrack
+	conn   *rtpUpConnection
+}
+
+func (c *SipClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	if replace != "" {
+		c.delDown(replace)
+	}
+	c.delDown(id)
+
+	if up == nil {
+		return nil
+	}
+
+	rup, _ := up.(*rtpUpConnection)
+	d := &sipDown{remote: up}
+	for _, t := range tracks {
+		if t.Kind() != webrtc.RTPCodecTypeAudio {
+			continue
+		}
+		if c.mixer != nil {
+			if !isG711(t.Codec()) {
+				continue
+			}
+		} else if !strings.EqualFold(
+			t.Codec().MimeType, c.codec.MimeType,
+		) {
+			continue
+		}
+		d.tracks = append(d.tracks, &sipDownTrack{
+			client: c,
+			remote: t,
+			conn:   rup,
+		})
+	}
+	if len(d.tracks) == 0 {
+		return nil
+	}
+
+	err := up.AddLocal(d)
+	if err != nil {
+		return err
+	}
+	for _, t := range d.tracks {
+		err := t.remote.AddLocal(t)
+		if err != nil {
+			d.close()
+			return err
+		}
+	}
+	if c.down == nil {
+		c.down = make(map[string]*sipDown)
+	}
+	c.down[id] = d
+	return nil
+}
+
+// called locked
+func (c *SipClient) delDown(id string) {
+	d := c.down[id]
+	if d == nil {
+		return
+	}
+	d.close()
+	delete(c.down, id)
+	if c.mixer != nil {
+		for _, t := range d.tracks {
+			c.mixer.remove(t)
+		}
+	}
+	if c.speaker != nil && c.speaker.Id() == id {
+		// let the next packet choose a new speaker
+		c.speaker = nil
+	}
+}
+
+func (t *sipDownTrack) Write(buf []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(buf)
+	if err != nil {
+		return 0, err
+	}
+	if t.client.mixer != nil {
+		t.client.mixer.write(t, p.Payload)
+		return len(buf), nil
+	}
+	err = t.client.forward(t.conn, &p)
+	if err != nil {
+		return 0, err
+	}
+	return len(buf), nil
+}
+
+func (t *sipDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (t *sipDownTrack) SetCname(string) {
+}
+
+func (t *sipDownTrack) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+// lastSpoke returns the time at which up last carried speech.
+func lastSpoke(up *rtpUpConnection) uint64 {
+	if up == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&up.lastSpoke)
+}
+
+// forward forwards a packet to the caller if it belongs to the current
+// speaker, switching speakers if required.
+func (c *SipClient) forward(up *rtpUpConnection, p *rtp.Packet) error {
+	now := rtptime.Jiffies()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	switched := false
+	if c.speaker != up {
+		current := lastSpoke(c.speaker)
+		if c.speaker != nil && now-current < sipSpeakerHold {
+			return nil
+		}
+		if c.speaker != nil && now-lastSpoke(up) >= sipSpeakerHold {
+			return nil
+		}
+		c.speaker = up
+		switched = true
+	}
+
+	if switched {
+		c.tsOffset = 0
+		if c.sent {
+			// keep the timestamps of the outgoing stream continuous
+			elapsed := rtptime.FromDuration(
+				rtptime.ToDuration(
+					int64(now-c.lastTime),
+					rtptime.JiffiesPerSec,
+				),
+				c.codec.ClockRate,
+			)
+			c.tsOffset = c.lastTs + uint32(elapsed) - p.Timestamp
+		}
+	}
+
+	if len(p.Payload) == 0 {
+		return nil
+	}
+
+	c.seqno++
+	out := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         p.Marker || switched,
+			PayloadType:    c.pt,
+			SequenceNumber: c.seqno,
+			Timestamp:      p.Timestamp + c.tsOffset,
+			SSRC:           c.ssrc,
+		},
+		Payload: p.Payload,
+	}
+	buf, err := out.Marshal()
+	if err != nil {
+		return err
+	}
+	c.lastTs = out.Timestamp
+	c.lastTime = now
+	c.sent = true
+	_, err = c.out.Write(buf)
+	return err
+}
+
+// mixLoop sends the mixed audio to the caller until the client is closed.
+func (c *SipClient) mixLoop() {
+	ticker := time.NewTicker(sipPtime)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		err := c.sendMixed(c.mixer.mix(sipFrameSamples))
+		if err != nil {
+			log.Printf("SIP: %v", err)
+		}
+	}
+}
+
+// sendMixed sends a packet of mixed samples to the caller.  If samples is
+// nil, nothing is sent, but the timestamp still advances.
+func (c *SipClient) sendMixed(samples []int16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	ts := c.lastTs + sipFrameSamples
+	c.lastTs = ts
+	if samples == nil {
+		c.sent = false
+		return nil
+	}
+
+	c.seqno++
+	out := rtp.Packet{
+		Header: rtp.Header{
+			Version: 2,
+			// the start of a talkspurt
+			Marker:         !c.sent,
+			PayloadType:    c.pt,
+			SequenceNumber: c.seqno,
+			Timestamp:      ts,
+			SSRC:           c.ssrc,
+		},
+		Payload: encodeG711(c.codec.MimeType, samples),
+	}
+	buf, err := out.Marshal()
+	if err != nil {
+		return err
+	}
+	c.sent = true
+	_, err = c.out.Write(buf)
+	return err
+}
@@ -0,0 +1,137 @@
+package rtpconn
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/g711"
+)
+
+// When a call uses G.711, the audio of all the participants that send
+// G.711 is decoded, mixed and encoded again, so that the caller hears
+// everyone at once.  Opus cannot be decoded by the server, so the audio
+// of participants that send Opus is not heard by such a caller.
+
+const (
+	// the duration of the packets sent to the caller
+	sipPtime = 20 * time.Millisecond
+	// the number of samples in a packet at 8kHz
+	sipFrameSamples = 160
+	// the amount of audio buffered before a source is mixed, which
+	// absorbs the jitter of the source
+	sipMixerPrebuffer = 2 * sipFrameSamples
+	// the maximum amount of audio buffered for a source, which bounds
+	// the latency when the source's clock is faster than ours
+	sipMixerMaxBuffer = 10 * sipFrameSamples
+)
+
+// isG711 returns true if codec is PCMU or PCMA.
+func isG711(codec webrtc.RTPCodecCapability) bool {
+	return codec.ClockRate == 8000 &&
+		(strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMU) ||
+			strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMA))
+}
+
+func decodeG711(mimeType string, payload []byte, samples []int16) []int16 {
+	if strings.EqualFold(mimeType, webrtc.MimeTypePCMA) {
+		for _, b := range payload {
+			samples = append(samples, g711.DecodeAlaw(b))
+		}
+		return samples
+	}
+	for _, b := range payload {
+		samples = append(samples, g711.DecodeUlaw(b))
+	}
+	return samples
+}
+
+func encodeG711(mimeType string, samples []int16) []byte {
+	payload := make([]byte, len(samples))
+	if strings.EqualFold(mimeType, webrtc.MimeTypePCMA) {
+		for i, s := range samples {
+			payload[i] = g711.EncodeAlaw(s)
+		}
+		return payload
+	}
+	for i, s := range samples {
+		payload[i] = g711.EncodeUlaw(s)
+	}
+	return payload
+}
+
+type mixerSource struct {
+	samples []int16
+	// whether the source has buffered enough to be mixed
+	active bool
+}
+
+// A sipMixer mixes the G.711 audio of multiple sources.
+type sipMixer struct {
+	mu      sync.Mutex
+	sources map[*sipDownTrack]*mixerSource
+}
+
+// write adds the payload of a packet received from track.
+func (m *sipMixer) write(track *sipDownTrack, payload []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sources == nil {
+		m.sources = make(map[*sipDownTrack]*mixerSource)
+	}
+	s := m.sources[track]
+	if s == nil {
+		s = &mixerSource{}
+		m.sources[track] = s
+	}
+	s.samples = decodeG711(track.remote.Codec().MimeType, payload, s.samples)
+	if n := len(s.samples) - sipMixerMaxBuffer; n > 0 {
+		s.samples = append(s.samples[:0], s.samples[n:]...)
+	}
+	if len(s.samples) >= sipMixerPrebuffer {
+		s.active = true
+	}
+}
+
+// remove removes a source.
+func (m *sipMixer) remove(track *sipDownTrack) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sources, track)
+}
+
+// mix returns n mixed samples, or nil if no source is active.
+func (m *sipMixer) mix(n int) []int16 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sum []int32
+	for _, s := range m.sources {
+		if !s.active {
+			continue
+		}
+		if sum == nil {
+			sum = make([]int32, n)
+		}
+		l := min(n, len(s.samples))
+		for i := 0; i < l; i++ {
+			sum[i] += int32(s.samples[i])
+		}
+		s.samples = append(s.samples[:0], s.samples[l:]...)
+		if len(s.samples) == 0 {
+			// buffer again before resuming
+			s.active = false
+		}
+	}
+	if sum == nil {
+		return nil
+	}
+
+	samples := make([]int16, n)
+	for i, v := range sum {
+		samples[i] = int16(max(-32768, min(32767, v)))
+	}
+	return samples
+}
@@ -0,0 +1,140 @@
+package rtpconn
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/g711"
+	"github.com/jech/galene/group"
+)
+
+func g711Frame(mimeType string, v int16) []byte {
+	samples := make([]int16, sipFrameSamples)
+	for i := range samples {
+		samples[i] = v
+	}
+	return encodeG711(mimeType, samples)
+}
+
+func TestSipMixer(t *testing.T) {
+	var m sipMixer
+	a := &sipDownTrack{
+		remote: codecTrack{mimeType: webrtc.MimeTypePCMU},
+	}
+	b := &sipDownTrack{
+		remote: codecTrack{mimeType: webrtc.MimeTypePCMA},
+	}
+
+	if m.mix(sipFrameSamples) != nil {
+		t.Errorf("Mixed nothing")
+	}
+
+	m.write(a, g711Frame(webrtc.MimeTypePCMU, 1000))
+	if m.mix(sipFrameSamples) != nil {
+		t.Errorf("Mixed before prebuffering")
+	}
+	m.write(a, g711Frame(webrtc.MimeTypePCMU, 1000))
+	m.write(b, g711Frame(webrtc.MimeTypePCMA, 2000))
+	m.write(b, g711Frame(webrtc.MimeTypePCMA, 2000))
+
+	expected := g711.DecodeUlaw(g711.EncodeUlaw(1000)) +
+		g711.DecodeAlaw(g711.EncodeAlaw(2000))
+	for i := 0; i < 2; i++ {
+		s := m.mix(sipFrameSamples)
+		if len(s) != sipFrameSamples || s[0] != expected ||
+			s[sipFrameSamples-1] != expected {
+			t.Fatalf("Expected %v, got %v", expected, s)
+		}
+	}
+
+	if m.mix(sipFrameSamples) != nil {
+		t.Errorf("Mixed after underrun")
+	}
+
+	m.write(a, g711Frame(webrtc.MimeTypePCMU, 30000))
+	m.write(a, g711Frame(webrtc.MimeTypePCMU, 30000))
+	m.write(b, g711Frame(webrtc.MimeTypePCMA, 30000))
+	m.write(b, g711Frame(webrtc.MimeTypePCMA, 30000))
+	s := m.mix(sipFrameSamples)
+	if s[0] != 32767 {
+		t.Errorf("Expected clipping, got %v", s[0])
+	}
+
+	m.remove(b)
+	s = m.mix(sipFrameSamples)
+	if s[0] != g711.DecodeUlaw(g711.EncodeUlaw(30000)) {
+		t.Errorf("Unexpected %v after removal", s[0])
+	}
+
+	for i := 0; i < 20; i++ {
+		m.write(a, g711Frame(webrtc.MimeTypePCMU, 0))
+	}
+	if l := len(m.sources[a].samples); l > sipMixerMaxBuffer {
+		t.Errorf("Buffered %v samples", l)
+	}
+}
+
+func TestSipSendMixed(t *testing.T) {
+	var out bytes.Buffer
+	c := NewSipClient(&group.Group{}, "id", nil,
+		webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypePCMA,
+			ClockRate: 8000,
+			Channels:  1,
+		}, 8, &out,
+	)
+	if c.mixer == nil {
+		t.Fatalf("No mixer for G.711")
+	}
+
+	var ts uint32
+	for i, silent := range []bool{false, false, true, false} {
+		out.Reset()
+		var samples []int16
+		if !silent {
+			samples = make([]int16, sipFrameSamples)
+		}
+		err := c.sendMixed(samples)
+		if err != nil {
+			t.Fatalf("sendMixed: %v", err)
+		}
+		if silent {
+			if out.Len() != 0 {
+				t.Errorf("Packet sent during silence")
+			}
+			continue
+		}
+		var p rtp.Packet
+		err = p.Unmarshal(out.Bytes())
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		if p.PayloadType != 8 || len(p.Payload) != sipFrameSamples ||
+			p.Payload[0] != g711.EncodeAlaw(0) {
+			t.Errorf("Unexpected packet %v", p)
+		}
+		if i == 0 {
+			ts = p.Timestamp
+		} else if p.Timestamp != ts+uint32(i*sipFrameSamples) {
+			// the timestamp advances during silence
+			t.Errorf("Unexpected timestamp %v", p.Timestamp)
+		}
+		if p.Marker != (i == 0 || i == 3) {
+			t.Errorf("Unexpected marker at %v", i)
+		}
+	}
+
+	c = NewSipClient(&group.Group{}, "id", nil,
+		webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeOpus,
+			ClockRate: 48000,
+			Channels:  2,
+		}, 111, &out,
+	)
+	if c.mixer != nil {
+		t.Errorf("Mixer for Opus")
+	}
+}
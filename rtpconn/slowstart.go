@@ -0,0 +1,188 @@
+package rtpconn
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+// When a client requests streams, typically just after joining, the
+// streams already present in the group are not pushed all at once.
+// Instead, they are queued and pushed in order of priority (audio, then
+// full video, then thumbnails), with pacing based on the client's
+// estimated bandwidth.
+
+const (
+	// the bandwidth we assume if the client didn't provide an estimate
+	defaultBandwidth = 2 * 1024 * 1024
+	// the estimated bitrates of new tracks
+	audioCost    = 64 * 1024
+	videoCost    = 512 * 1024
+	videoLowCost = group.LowBitrate
+	// the time during which we collect connections before pushing the
+	// first one
+	slowStartDelay = 100 * time.Millisecond
+)
+
+const (
+	priorityAudio = iota
+	priorityVideo
+	priorityVideoLow
+	priorityGated
+)
+
+type pendingConn struct {
+	action    pushConnAction
+	priority  int
+	cost      uint64
+	audioOnly bool
+}
+
+type pacingAction struct{}
+
+// requestedFor returns the list of track types requested by client c for
+// the up connection up.
+func requestedFor(c *webClient, up conn.Up) []string {
+	req, ok := c.requested[up.Label()]
+	if !ok {
+		req = c.requested[""]
+	}
+	return req
+}
+
+func audioTracks(tracks []conn.UpTrack) []conn.UpTrack {
+	var ts []conn.UpTrack
+	for _, t := range tracks {
+		if t.Kind() == webrtc.RTPCodecTypeAudio {
+			ts = append(ts, t)
+		}
+	}
+	return ts
+}
+
+// delPending removes any pending entries for the connection with the
+// given id.
+func delPending(c *webClient, id string) {
+	pending := c.pending[:0]
+	for _, p := range c.pending {
+		if p.action.id != id {
+			pending = append(pending, p)
+		}
+	}
+	c.pending = pending
+}
+
+// queuePending queues a new connection.  It returns false if the
+// connection should be pushed immediately.
+func queuePending(c *webClient, a pushConnAction) bool {
+	if !c.slowStart || a.conn == nil || a.replace != "" ||
+		getDownConn(c, downConnId(c, a.conn.Id())) != nil {
+		return false
+	}
+
+	req := requestedFor(c, a.conn)
+	tracks, _ := requestedTracks(c, req, a.tracks)
+	var audio, video bool
+	for _, t := range tracks {
+		if t.Kind() == webrtc.RTPCodecTypeAudio {
+			audio = true
+		} else {
+			video = true
+		}
+	}
+	if !audio && !video {
+		return false
+	}
+
+	delPending(c, a.id)
+
+	if audio {
+		c.pending = append(c.pending, pendingConn{
+			action:    a,
+			priority:  priorityAudio,
+			cost:      audioCost,
+			audioOnly: video,
+		})
+	}
+	if video {
+		p := pendingConn{
+			action:   a,
+			priority: priorityVideo,
+			cost:     videoCost,
+		}
+		if up, ok := a.conn.(*rtpUpConnection); ok && up.isVideoGated() {
+			p.priority = priorityGated
+			p.cost = 0
+		} else if !member("video", req) {
+			p.priority = priorityVideoLow
+			p.cost = videoLowCost
+		}
+		c.pending = append(c.pending, p)
+	}
+
+	sort.SliceStable(c.pending, func(i, j int) bool {
+		return c.pending[i].priority < c.pending[j].priority
+	})
+
+	if !c.pacingScheduled {
+		c.pacingScheduled = true
+		if c.pacingTime.Before(time.Now()) {
+			c.pacingTime = time.Now().Add(slowStartDelay)
+		}
+		schedulePacing(c)
+	}
+	return true
+}
+
+// pacingDelay returns the time needed to ramp up a flow of the given
+// cost over a link with the given bandwidth.
+func pacingDelay(cost, bandwidth uint64) time.Duration {
+	if bandwidth == 0 {
+		bandwidth = defaultBandwidth
+	}
+	return time.Duration(cost) * time.Second / time.Duration(bandwidth)
+}
+
+func schedulePacing(c *webClient) {
+	time.AfterFunc(time.Until(c.pacingTime), func() {
+		c.action(pacingAction{})
+	})
+}
+
+// processPending pushes pending connections until we run out of
+// bandwidth budget.
+func processPending(c *webClient) error {
+	c.pacingScheduled = false
+
+	now := time.Now()
+	for len(c.pending) > 0 && !now.Before(c.pacingTime) {
+		p := c.pending[0]
+		c.pending = c.pending[1:]
+		if c.group == nil || c.group != p.action.group {
+			continue
+		}
+		tracks := p.action.tracks
+		if p.audioOnly {
+			tracks = audioTracks(tracks)
+		}
+		err := pushDownConn(c, p.action.id, p.action.conn, tracks, "")
+		if err != nil {
+			return err
+		}
+		c.pacingTime = now.Add(pacingDelay(p.cost, c.bandwidth))
+	}
+
+	if len(c.pending) == 0 {
+		c.pending = nil
+		c.slowStart = false
+		return nil
+	}
+
+	c.pacingScheduled = true
+	schedulePacing(c)
+	return nil
+}
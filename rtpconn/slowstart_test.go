@@ -0,0 +1,46 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestPacingDelay(t *testing.T) {
+	tests := []struct {
+		cost, bandwidth uint64
+		delay           time.Duration
+	}{
+		{0, 1024 * 1024, 0},
+		{512 * 1024, 1024 * 1024, time.Second / 2},
+		{64 * 1024, 256 * 1024, time.Second / 4},
+		{defaultBandwidth, 0, time.Second},
+	}
+	for _, test := range tests {
+		d := pacingDelay(test.cost, test.bandwidth)
+		if d != test.delay {
+			t.Errorf("pacingDelay(%v, %v) = %v, expected %v",
+				test.cost, test.bandwidth, d, test.delay)
+		}
+	}
+}
+
+func TestSlowStartOnce(t *testing.T) {
+	c := &webClient{group: &group.Group{}}
+	err := c.setRequested(map[string][]string{"": {"audio"}}, 0)
+	if err != nil {
+		t.Fatalf("setRequested: %v", err)
+	}
+	if !c.slowStart {
+		t.Errorf("No slow start on the first request")
+	}
+	c.slowStart = false
+	err = c.setRequested(map[string][]string{"": {"audio", "video"}}, 0)
+	if err != nil {
+		t.Fatalf("setRequested: %v", err)
+	}
+	if c.slowStart {
+		t.Errorf("Slow start on a later request")
+	}
+}
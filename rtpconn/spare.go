@@ -0,0 +1,327 @@
+package rtpconn
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+)
+
+// A down connection may contain spare transceivers, which are negotiated
+// in sendonly mode with a placeholder track that is not associated with
+// any stream.  When a track is added to the connection, it is attached to
+// a spare transceiver with ReplaceTrack, and the client is informed with
+// a "track" message, which avoids a round trip of offer and answer.
+// Similarly, a track that is removed is merely detached from its sender,
+// and the sender is only removed at the next negotiation, at which point
+// the pool of spare transceivers is replenished.
+//
+// Spare transceivers are only used with clients that have requested them
+// in their join message, and only if the spare-transceivers field of the
+// group description is set.
+//
+// Since every stream is sent over its own down connection, a new stream,
+// such as a screenshare, would still require a full round of negotiation.
+// In order to avoid that, we keep a standby connection, which carries no
+// stream and only contains spare transceivers, and is negotiated in
+// advance.  When a new stream is pushed, it is handed over to the standby
+// connection, the client is informed with a "stream" message, its tracks
+// are attached to the spare transceivers, and a new standby connection
+// is negotiated in the background.
+
+const maxSpareTransceivers = 4
+
+// spareTrack is the placeholder track of a spare transceiver.  It accepts
+// any codec, and never sends any data.
+type spareTrack struct {
+	id   string
+	kind webrtc.RTPCodecType
+}
+
+func (t *spareTrack) Bind(ctx webrtc.TrackLocalContext) (webrtc.RTPCodecParameters, error) {
+	codecs := ctx.CodecParameters()
+	if len(codecs) == 0 {
+		return webrtc.RTPCodecParameters{}, webrtc.ErrUnsupportedCodec
+	}
+	return codecs[0], nil
+}
+
+func (t *spareTrack) Unbind(ctx webrtc.TrackLocalContext) error {
+	return nil
+}
+
+func (t *spareTrack) ID() string {
+	return t.id
+}
+
+func (t *spareTrack) RID() string {
+	return ""
+}
+
+func (t *spareTrack) StreamID() string {
+	// no associated stream
+	return "-"
+}
+
+func (t *spareTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+// trackChange is a track that was attached to or detached from
+// a transceiver without renegotiation.
+type trackChange struct {
+	kind string // "add" or "remove"
+	mid  string
+}
+
+// spareTransceivers returns the number of spare transceivers of each kind
+// that should be allocated in the down connections of c.
+func spareTransceivers(c *webClient) int {
+	if !c.spareTransceivers || c.group == nil {
+		return 0
+	}
+	n := c.group.Description().SpareTransceivers
+	if n > maxSpareTransceivers {
+		n = maxSpareTransceivers
+	}
+	return n
+}
+
+// takeSpare removes a spare transceiver of the given kind from the pool.
+// It returns nil if none is available.
+// called locked
+func (down *rtpDownConnection) takeSpare(kind webrtc.RTPCodecType) *webrtc.RTPTransceiver {
+	// the client must have applied the offer that contains the
+	// transceiver before we refer to it
+	if down.pc.SignalingState() != webrtc.SignalingStateStable {
+		return nil
+	}
+	for i, t := range down.spare {
+		if t.Kind() == kind && t.Mid() != "" {
+			down.spare = append(down.spare[:i], down.spare[i+1:]...)
+			return t
+		}
+	}
+	return nil
+}
+
+// attachSpare attaches a local track to a spare transceiver.  It returns
+// nil if no spare transceiver is available.
+// called locked
+func (down *rtpDownConnection) attachSpare(local localTrack) *webrtc.RTPTransceiver {
+	t := down.takeSpare(local.Kind())
+	if t == nil {
+		return nil
+	}
+	err := t.Sender().ReplaceTrack(local)
+	if err != nil {
+		// probably a codec that was not negotiated
+		down.retired = append(down.retired, t.Sender())
+		return nil
+	}
+	down.trackChanges = append(down.trackChanges, trackChange{
+		kind: "add",
+		mid:  t.Mid(),
+	})
+	return t
+}
+
+// detachSender detaches the track of a sender without renegotiation.  It
+// returns false if the caller should remove the sender instead.
+// called locked
+func (down *rtpDownConnection) detachSender(sender *webrtc.RTPSender) bool {
+	if down.spareCount <= 0 ||
+		down.pc.SignalingState() != webrtc.SignalingStateStable {
+		return false
+	}
+	var mid string
+	for _, t := range down.pc.GetTransceivers() {
+		if t.Sender() == sender {
+			mid = t.Mid()
+			break
+		}
+	}
+	if mid == "" {
+		return false
+	}
+	err := sender.ReplaceTrack(nil)
+	if err != nil {
+		return false
+	}
+	down.retired = append(down.retired, sender)
+	down.trackChanges = append(down.trackChanges, trackChange{
+		kind: "remove",
+		mid:  mid,
+	})
+	return true
+}
+
+// prepareOffer removes the senders of detached tracks and replenishes the
+// pool of spare transceivers.  It is called before creating an offer.
+func (down *rtpDownConnection) prepareOffer() error {
+	down.mu.Lock()
+	defer down.mu.Unlock()
+
+	for _, s := range down.retired {
+		err := down.pc.RemoveTrack(s)
+		if err != nil {
+			return err
+		}
+	}
+	down.retired = nil
+
+	kinds := []webrtc.RTPCodecType{
+		webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo,
+	}
+	for _, kind := range kinds {
+		n := 0
+		for _, t := range down.spare {
+			if t.Kind() == kind {
+				n++
+			}
+		}
+		for ; n < down.spareCount; n++ {
+			down.spareSerial++
+			track := &spareTrack{
+				id:   fmt.Sprintf("spare-%v", down.spareSerial),
+				kind: kind,
+			}
+			t, err := down.pc.AddTransceiverFromTrack(track,
+				webrtc.RTPTransceiverInit{
+					Direction: webrtc.RTPTransceiverDirectionSendonly,
+				},
+			)
+			if err != nil {
+				return err
+			}
+			down.spare = append(down.spare, t)
+		}
+	}
+	return nil
+}
+
+// sendTrackChanges informs the client of the tracks that have been
+// attached or detached without renegotiation.
+func sendTrackChanges(c *webClient, down *rtpDownConnection) error {
+	down.mu.Lock()
+	changes := down.trackChanges
+	down.trackChanges = nil
+	down.mu.Unlock()
+
+	for _, ch := range changes {
+		err := c.write(clientMessage{
+			Type:  "track",
+			Kind:  ch.kind,
+			Id:    down.id,
+			Value: ch.mid,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// standbyUp is the placeholder stream of a standby connection.
+type standbyUp struct {
+	id string
+}
+
+func (up *standbyUp) AddLocal(conn.Down) error {
+	return nil
+}
+
+func (up *standbyUp) DelLocal(conn.Down) bool {
+	return false
+}
+
+func (up *standbyUp) Id() string {
+	return up.id
+}
+
+func (up *standbyUp) Label() string {
+	return ""
+}
+
+func (up *standbyUp) User() (string, string) {
+	return "", ""
+}
+
+// isStandby returns true if down is a standby connection.
+// called locked, or from the client loop
+func isStandby(down *rtpDownConnection) bool {
+	_, ok := down.remote.(*standbyUp)
+	return ok
+}
+
+// getStandby returns the standby connection of c, or nil if there is none.
+func getStandby(c *webClient) *rtpDownConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, down := range c.down {
+		if isStandby(down) {
+			return down
+		}
+	}
+	return nil
+}
+
+// addStandby creates and negotiates a standby connection, unless c
+// already has one or doesn't use spare transceivers.
+func addStandby(c *webClient) error {
+	if spareTransceivers(c) <= 0 || getStandby(c) != nil {
+		return nil
+	}
+
+	buf := make([]byte, 9)
+	crand.Read(buf)
+	up := &standbyUp{
+		id: "standby-" + base64.RawURLEncoding.EncodeToString(buf),
+	}
+	down, _, err := addDownConn(c, up)
+	if err != nil {
+		return err
+	}
+	err = negotiate(c, down, false, "")
+	if err != nil {
+		closeDownConn(c, down.id, err.Error())
+		return err
+	}
+	return nil
+}
+
+// takeStandby hands the stream up over to the standby connection of c.
+// It returns nil if there is no standby connection, or if it has not
+// been negotiated yet.
+func takeStandby(c *webClient, up conn.Up) *rtpDownConnection {
+	down := getStandby(c)
+	if down == nil ||
+		down.pc.SignalingState() != webrtc.SignalingStateStable {
+		return nil
+	}
+	down = handOverConn(c, up, down.id)
+	if down == nil {
+		return nil
+	}
+	atomic.AddInt32(&c.ceilings.downConns, 1)
+	return down
+}
+
+// sendStream informs the client that a former standby connection now
+// carries a stream.  It must be sent before the corresponding track
+// changes.
+func sendStream(c *webClient, down *rtpDownConnection) error {
+	source, username := down.remote.User()
+	return c.write(clientMessage{
+		Type:      "stream",
+		Id:        down.id,
+		Label:     down.remote.Label(),
+		Source:    source,
+		Username:  &username,
+		Encrypted: encryptedTracks(down),
+	})
+}
@@ -0,0 +1,241 @@
+package rtpconn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+)
+
+func countSpare(down *rtpDownConnection, kind webrtc.RTPCodecType) int {
+	n := 0
+	for _, t := range down.spare {
+		if t.Kind() == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func negotiateSpare(t *testing.T, down *rtpDownConnection, pc *webrtc.PeerConnection) string {
+	err := down.prepareOffer()
+	if err != nil {
+		t.Fatalf("prepareOffer: %v", err)
+	}
+	offer, err := down.pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("CreateOffer: %v", err)
+	}
+	err = down.pc.SetLocalDescription(offer)
+	if err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	err = pc.SetRemoteDescription(offer)
+	if err != nil {
+		t.Fatalf("SetRemoteDescription: %v", err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		t.Fatalf("CreateAnswer: %v", err)
+	}
+	err = pc.SetLocalDescription(answer)
+	if err != nil {
+		t.Fatalf("SetLocalDescription: %v", err)
+	}
+	err = down.pc.SetRemoteDescription(answer)
+	if err != nil {
+		t.Fatalf("SetRemoteDescription: %v", err)
+	}
+	return offer.SDP
+}
+
+func TestSpareTransceivers(t *testing.T) {
+	pc1, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc1.Close()
+	pc2, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc2.Close()
+
+	down := &rtpDownConnection{id: "down", pc: pc1, spareCount: 1}
+
+	sdp := negotiateSpare(t, down, pc2)
+	if countSpare(down, webrtc.RTPCodecTypeAudio) != 1 ||
+		countSpare(down, webrtc.RTPCodecTypeVideo) != 1 {
+		t.Fatalf("Expected 1 spare of each kind, got %v", len(down.spare))
+	}
+	if strings.Count(sdp, "a=msid:- spare-") != 2 {
+		t.Errorf("Spare transceivers not in offer: %v", sdp)
+	}
+
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus,
+			ClockRate: 48000, Channels: 2},
+		"audio", "stream",
+	)
+	if err != nil {
+		t.Fatalf("NewTrackLocalStaticRTP: %v", err)
+	}
+	tr := down.attachSpare(local)
+	if tr == nil {
+		t.Fatalf("attachSpare failed")
+	}
+	if tr.Sender().Track() != local {
+		t.Errorf("Track not attached")
+	}
+	if countSpare(down, webrtc.RTPCodecTypeAudio) != 0 {
+		t.Errorf("Spare not removed from pool")
+	}
+	if len(down.trackChanges) != 1 ||
+		down.trackChanges[0] != (trackChange{"add", tr.Mid()}) {
+		t.Errorf("Bad track changes %v", down.trackChanges)
+	}
+	if down.attachSpare(local) != nil {
+		t.Errorf("attachSpare succeeded with empty pool")
+	}
+
+	if !down.detachSender(tr.Sender()) {
+		t.Fatalf("detachSender failed")
+	}
+	if tr.Sender().Track() != nil || len(down.retired) != 1 {
+		t.Errorf("Track not detached")
+	}
+	if len(down.trackChanges) != 2 ||
+		down.trackChanges[1] != (trackChange{"remove", tr.Mid()}) {
+		t.Errorf("Bad track changes %v", down.trackChanges)
+	}
+
+	negotiateSpare(t, down, pc2)
+	if len(down.retired) != 0 {
+		t.Errorf("Retired senders not removed")
+	}
+	if countSpare(down, webrtc.RTPCodecTypeAudio) != 1 {
+		t.Errorf("Pool not replenished")
+	}
+}
+
+func TestNoSpareTransceivers(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc.Close()
+
+	down := &rtpDownConnection{id: "down", pc: pc}
+	err = down.prepareOffer()
+	if err != nil || len(pc.GetTransceivers()) != 0 {
+		t.Errorf("Spare transceivers allocated: %v", err)
+	}
+	if down.detachSender(nil) {
+		t.Errorf("detachSender succeeded")
+	}
+}
+
+type testUp struct {
+	id, user string
+}
+
+func (up *testUp) AddLocal(conn.Down) error {
+	return nil
+}
+
+func (up *testUp) DelLocal(conn.Down) bool {
+	return false
+}
+
+func (up *testUp) Id() string {
+	return up.id
+}
+
+func (up *testUp) Label() string {
+	return "screenshare"
+}
+
+func (up *testUp) User() (string, string) {
+	return up.user, up.user
+}
+
+func TestStandby(t *testing.T) {
+	pc1, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc1.Close()
+	pc2, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	defer pc2.Close()
+
+	down := &rtpDownConnection{
+		id:         "standby",
+		pc:         pc1,
+		remote:     &standbyUp{id: "standby"},
+		spareCount: 1,
+	}
+	c := &webClient{
+		down:    map[string]*rtpDownConnection{down.id: down},
+		writeCh: make(chan interface{}, 10),
+	}
+	if getStandby(c) != down {
+		t.Fatalf("Standby connection not found")
+	}
+	negotiateSpare(t, down, pc2)
+
+	// a new stream is sent without a new offer
+	if takeStandby(c, &testUp{id: "up", user: "bob"}) != down {
+		t.Fatalf("takeStandby failed")
+	}
+	if isStandby(down) || getStandby(c) != nil {
+		t.Errorf("Connection still in standby")
+	}
+	if id := downConnId(c, "up"); id != down.id {
+		t.Errorf("Expected alias %v, got %v", down.id, id)
+	}
+	if c.ceilings.downConns != 1 {
+		t.Errorf("Expected 1 connection, got %v", c.ceilings.downConns)
+	}
+
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8,
+			ClockRate: 90000},
+		"video", "up",
+	)
+	if err != nil {
+		t.Fatalf("NewTrackLocalStaticRTP: %v", err)
+	}
+	tr := down.attachSpare(local)
+	if tr == nil {
+		t.Fatalf("attachSpare failed")
+	}
+	err = sendStream(c, down)
+	if err != nil {
+		t.Fatalf("sendStream: %v", err)
+	}
+	err = sendTrackChanges(c, down)
+	if err != nil {
+		t.Fatalf("sendTrackChanges: %v", err)
+	}
+
+	if len(c.writeCh) != 2 {
+		t.Fatalf("Expected 2 messages, got %v", len(c.writeCh))
+	}
+	m := (<-c.writeCh).(clientMessage)
+	if m.Type != "stream" || m.Id != down.id ||
+		m.Label != "screenshare" || m.Source != "bob" {
+		t.Errorf("Bad stream message %#v", m)
+	}
+	m = (<-c.writeCh).(clientMessage)
+	if m.Type != "track" || m.Kind != "add" || m.Value != tr.Mid() {
+		t.Errorf("Bad track message %#v", m)
+	}
+	if pc1.SignalingState() != webrtc.SignalingStateStable {
+		t.Errorf("Connection was renegotiated")
+	}
+}
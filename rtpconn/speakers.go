@@ -0,0 +1,219 @@
+package rtpconn
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/codecs"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// In speech-gated mode, only the video of the most recent speakers is
+// forwarded.  Speech is detected using the audio level header extension
+// (RFC 6464).
+
+const (
+	// the audio level, in -dBov, below which we consider that
+	// a packet carries speech.
+	speechLevel = 50
+	// the duration of continuous speech required for a speaker to
+	// become active, this avoids switching on a cough.
+	speechMinimum = rtptime.JiffiesPerSec / 2
+	// the duration of silence after which speech is no longer continuous
+	speechGap = rtptime.JiffiesPerSec / 4
+	// the minimum interval between two updates triggered by a speaker
+	speakersUpdateInterval = rtptime.JiffiesPerSec / 2
+)
+
+// audioLevelExtension returns the id of the audio level header extension
+// negotiated on a receiver, or 0 if none.
+func audioLevelExtension(receiver *webrtc.RTPReceiver) uint8 {
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == sdp.AudioLevelURI {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+// isSpeech returns true if a packet carries an audio level extension that
+// indicates speech.
+func isSpeech(packet *rtp.Packet, id uint8) bool {
+	buf := packet.GetExtension(id)
+	if buf == nil {
+		return false
+	}
+	var ext rtp.AudioLevelExtension
+	err := ext.Unmarshal(buf)
+	if err != nil {
+		return false
+	}
+	return ext.Level < speechLevel
+}
+
+// speechDetector tracks continuous speech on a single audio track.
+// It is only accessed by the reader loop.
+type speechDetector struct {
+	start, last uint64
+}
+
+// speaking records a packet carrying speech and returns true if the
+// speech has lasted long enough.
+func (s *speechDetector) speaking(now uint64) bool {
+	if now-s.last > speechGap {
+		s.start = now
+	}
+	s.last = now
+	return now-s.start >= speechMinimum
+}
+
+// spoke records the fact that a connection is carrying speech, and
+// triggers an update of the set of active speakers if required.
+func (up *rtpUpConnection) spoke(now uint64) {
+	atomic.StoreUint64(&up.lastSpoke, now)
+	up.claimFloor(now)
+	if !up.isVideoGated() {
+		return
+	}
+	last := atomic.LoadUint64(&up.speakersUpdated)
+	if now-last < speakersUpdateInterval {
+		return
+	}
+	if !atomic.CompareAndSwapUint64(&up.speakersUpdated, last, now) {
+		return
+	}
+	go updateSpeakers(up.client.Group())
+}
+
+func (up *rtpUpConnection) isVideoGated() bool {
+	return atomic.LoadUint32(&up.videoGated) != 0
+}
+
+func (up *rtpUpConnection) setVideoGated(gated bool) {
+	var v uint32
+	if gated {
+		v = 1
+	}
+	old := atomic.SwapUint32(&up.videoGated, v)
+	if old != 0 && !gated {
+		for _, t := range up.getTracks() {
+			if t.Kind() == webrtc.RTPCodecTypeVideo {
+				t.RequestKeyframe()
+			}
+		}
+	}
+}
+
+func (up *rtpUpConnection) hasVideo() bool {
+	for _, t := range up.getTracks() {
+		if t.Kind() == webrtc.RTPCodecTypeVideo {
+			return true
+		}
+	}
+	return false
+}
+
+// upConnections returns the up connections of a client.
+func upConnections(c group.Client) []*rtpUpConnection {
+	var ups []*rtpUpConnection
+	switch c := c.(type) {
+	case *webClient:
+		c.mu.Lock()
+		for _, up := range c.up {
+			ups = append(ups, up)
+		}
+		c.mu.Unlock()
+	case *WhipClient:
+		c.mu.Lock()
+		if c.connection != nil {
+			ups = append(ups, c.connection)
+		}
+		c.mu.Unlock()
+	}
+	return ups
+}
+
+var speakersMu sync.Mutex
+
+// updateSpeakers recomputes the set of connections whose video is
+// forwarded in group g.
+func updateSpeakers(g *group.Group) {
+	if g == nil {
+		return
+	}
+
+	speakersMu.Lock()
+	defer speakersMu.Unlock()
+
+	n := g.Description().ActiveSpeakers
+
+	var ups []*rtpUpConnection
+	for _, c := range g.GetClients(nil) {
+		for _, up := range upConnections(c) {
+			if up.hasVideo() {
+				ups = append(ups, up)
+			}
+		}
+	}
+
+	if n <= 0 {
+		for _, up := range ups {
+			up.setVideoGated(false)
+		}
+		return
+	}
+
+	// most recent speakers first, then connections that have never
+	// spoken in order of creation.
+	sort.Slice(ups, func(i, j int) bool {
+		si := atomic.LoadUint64(&ups[i].lastSpoke)
+		sj := atomic.LoadUint64(&ups[j].lastSpoke)
+		if si != sj {
+			return si > sj
+		}
+		return ups[i].created < ups[j].created
+	})
+
+	for i, up := range ups {
+		up.setVideoGated(i >= n)
+	}
+}
+
+// videoGated returns true if a packet should be dropped because the
+// sender is not among the active speakers.  After the sender becomes
+// active again, packets are dropped until the next keyframe.
+// Called from Write.
+func (down *rtpDownTrack) videoGated(flags codecs.Flags) bool {
+	up, ok := down.getRemote().(*rtpUpTrack)
+	if !ok || up.Kind() != webrtc.RTPCodecTypeVideo {
+		return false
+	}
+	if up.conn.isVideoGated() {
+		down.resync = true
+		return true
+	}
+	if down.resync {
+		if up.encrypted.Load() {
+			// we cannot see keyframes, ask for one and hope
+			// for the best
+			down.resync = false
+			up.RequestKeyframe()
+			return false
+		}
+		if flags.Start && flags.Keyframe {
+			down.resync = false
+			return false
+		}
+		if flags.Start {
+			up.RequestKeyframe()
+		}
+		return true
+	}
+	return false
+}
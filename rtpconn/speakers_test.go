@@ -0,0 +1,55 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestIsSpeech(t *testing.T) {
+	for _, test := range []struct {
+		level  uint8
+		speech bool
+	}{{10, true}, {49, true}, {50, false}, {127, false}} {
+		ext := rtp.AudioLevelExtension{Level: test.level}
+		buf, err := ext.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		packet := rtp.Packet{}
+		err = packet.SetExtension(1, buf)
+		if err != nil {
+			t.Fatalf("SetExtension: %v", err)
+		}
+		if isSpeech(&packet, 1) != test.speech {
+			t.Errorf("Level %v: expected %v", test.level, test.speech)
+		}
+		if isSpeech(&packet, 2) {
+			t.Errorf("Level %v: found bad extension", test.level)
+		}
+	}
+}
+
+func TestSpeechDetector(t *testing.T) {
+	var s speechDetector
+	now := uint64(1000 * rtptime.JiffiesPerSec)
+	step := uint64(rtptime.JiffiesPerSec / 50)
+
+	if s.speaking(now) {
+		t.Errorf("Speaking after one packet")
+	}
+	for i := 0; i < 30; i++ {
+		now += step
+		s.speaking(now)
+	}
+	if !s.speaking(now) {
+		t.Errorf("Not speaking after continuous speech")
+	}
+
+	now += rtptime.JiffiesPerSec
+	if s.speaking(now) {
+		t.Errorf("Speaking after a gap")
+	}
+}
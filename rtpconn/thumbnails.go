@@ -0,0 +1,134 @@
+package rtpconn
+
+import (
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/codecs"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// When a client asks for the low rendition of a stream (video-low) and
+// the sender provides neither simulcast nor spatial layers, we would
+// otherwise forward the full stream, which doesn't scale to large
+// galleries.  This is not a transcoder: we don't include a video encoder,
+// so the thumbnail rendition merely samples the sender's keyframes,
+// spaced so that the average bitrate fits within the bandwidth available
+// to the receiver.  The resolution is that of the original stream.
+//
+// Requesting keyframes degrades the stream for all the other receivers,
+// so we first give the sender a chance to produce one by itself, but the
+// refresh is bounded: once a thumbnail is due, we request a keyframe
+// after at most thumbnailRequestDelay, so that a thumbnail is never older
+// than maxThumbnailInterval + thumbnailRequestDelay plus a round trip.
+// Requests from multiple receivers are coalesced by the up track.
+
+const (
+	// the target bitrate of a thumbnail
+	thumbnailBitrate = group.LowBitrate / 2
+	// the bounds on the interval between two thumbnails
+	minThumbnailInterval = rtptime.JiffiesPerSec
+	maxThumbnailInterval = 30 * rtptime.JiffiesPerSec
+	// the time we wait for a keyframe before requesting one
+	thumbnailRequestDelay = 2 * rtptime.JiffiesPerSec
+)
+
+// thumbnailState is only accessed by Write.
+type thumbnailState struct {
+	// whether we are in thumbnail mode
+	active bool
+	// whether we are forwarding a keyframe, and its timestamp and size
+	forwarding bool
+	ts         uint32
+	bytes      int
+	// the time at which we may forward the next keyframe
+	next uint64
+	// the time at which we last requested a keyframe
+	requested uint64
+}
+
+// thumbnailInterval returns the interval between two thumbnails of the
+// given size.
+func thumbnailInterval(bytes int, rate uint64) uint64 {
+	if rate > thumbnailBitrate {
+		rate = thumbnailBitrate
+	}
+	if rate == 0 {
+		return maxThumbnailInterval
+	}
+	interval := uint64(bytes) * 8 * rtptime.JiffiesPerSec / rate
+	if interval < minThumbnailInterval {
+		return minThumbnailInterval
+	} else if interval > maxThumbnailInterval {
+		return maxThumbnailInterval
+	}
+	return interval
+}
+
+// wantsThumbnail returns true if a down track should be in thumbnail mode.
+func (down *rtpDownTrack) wantsThumbnail(layer layerInfo) bool {
+	return down.conn != nil && down.conn.thumbnails &&
+		down.track.Kind() == webrtc.RTPCodecTypeVideo &&
+		layer.limitSid && layer.maxSid == 0 && !down.encrypted()
+}
+
+// thumbnailGated returns true if a packet should be dropped because the
+// track is in thumbnail mode.  Called from Write.
+func (down *rtpDownTrack) thumbnailGated(flags codecs.Flags, layer layerInfo, ts uint32, size int) bool {
+	t := &down.thumbnail
+	if !down.wantsThumbnail(layer) {
+		if t.active {
+			// we've been dropping frames, wait for a keyframe
+			*t = thumbnailState{}
+			down.resync = true
+			if !(flags.Start && flags.Keyframe) {
+				down.getRemote().RequestKeyframe()
+				return true
+			}
+			down.resync = false
+		}
+		return false
+	}
+
+	now := rtptime.Jiffies()
+	if !t.active {
+		*t = thumbnailState{active: true}
+	}
+
+	if t.forwarding {
+		if ts == t.ts {
+			t.bytes += size
+			return false
+		}
+		// the keyframe is complete
+		t.forwarding = false
+		rate, _, _ := down.GetMaxBitrate()
+		t.next = now + thumbnailInterval(t.bytes, rate)
+	}
+
+	if now < t.next || !flags.Start {
+		return true
+	}
+
+	if flags.Keyframe {
+		t.forwarding = true
+		t.ts = ts
+		t.bytes = size
+		return false
+	}
+
+	if t.requestDue(now) {
+		t.requested = now
+		down.getRemote().RequestKeyframe()
+	}
+	return true
+}
+
+// requestDue returns true if we have waited long enough for a keyframe.
+func (t *thumbnailState) requestDue(now uint64) bool {
+	if t.requested == 0 && t.next == 0 {
+		// this is the first thumbnail
+		return true
+	}
+	return now >= max(t.next, t.requested)+thumbnailRequestDelay
+}
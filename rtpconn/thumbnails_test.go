@@ -0,0 +1,123 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/codecs"
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/rtptime"
+)
+
+type keyframeCounter struct {
+	conn.UpTrack
+	count int
+}
+
+func (t *keyframeCounter) RequestKeyframe() error {
+	t.count++
+	return nil
+}
+
+func TestThumbnailInterval(t *testing.T) {
+	tests := []struct {
+		bytes    int
+		rate     uint64
+		interval uint64
+	}{
+		{1000, 1000000, minThumbnailInterval},
+		{25 * 1024, 1000000, 4 * rtptime.JiffiesPerSec},
+		{25 * 1024, 40 * 1024, 5 * rtptime.JiffiesPerSec},
+		{1000000, 1000000, maxThumbnailInterval},
+		{1000, 0, maxThumbnailInterval},
+	}
+	for _, test := range tests {
+		interval := thumbnailInterval(test.bytes, test.rate)
+		if interval != test.interval {
+			t.Errorf("%v %v: expected %v, got %v",
+				test.bytes, test.rate, test.interval, interval)
+		}
+	}
+}
+
+func TestThumbnailGated(t *testing.T) {
+	local, err := webrtc.NewTrackLocalStaticRTP(
+		webrtc.RTPCodecCapability{MimeType: "video/VP8"}, "video", "s",
+	)
+	if err != nil {
+		t.Fatalf("NewTrackLocalStaticRTP: %v", err)
+	}
+	remote := &keyframeCounter{}
+	down := &rtpDownTrack{
+		track:          local,
+		conn:           &rtpDownConnection{thumbnails: true},
+		remote:         remote,
+		maxBitrate:     new(bitrate),
+		maxREMBBitrate: new(bitrate),
+		atomics:        &downTrackAtomics{},
+	}
+	layer := layerInfo{limitSid: true}
+
+	keyframe := codecs.Flags{Start: true, Keyframe: true}
+	delta := codecs.Flags{Start: true}
+	middle := codecs.Flags{}
+
+	if !down.thumbnailGated(delta, layer, 1, 100) {
+		t.Errorf("Delta frame was forwarded")
+	}
+	if remote.count != 1 {
+		t.Errorf("Expected 1 keyframe request, got %v", remote.count)
+	}
+	if down.thumbnailGated(keyframe, layer, 2, 1000) ||
+		down.thumbnailGated(middle, layer, 2, 1000) {
+		t.Errorf("Keyframe was dropped")
+	}
+	if !down.thumbnailGated(delta, layer, 3, 100) ||
+		!down.thumbnailGated(keyframe, layer, 4, 1000) {
+		t.Errorf("Frame was forwarded too early")
+	}
+	if remote.count != 1 {
+		t.Errorf("Expected 1 keyframe request, got %v", remote.count)
+	}
+
+	// the next thumbnail is due, wait for a keyframe
+	down.thumbnail.next = rtptime.Jiffies()
+	if !down.thumbnailGated(delta, layer, 5, 100) || remote.count != 1 {
+		t.Errorf("Keyframe requested too early (%v)", remote.count)
+	}
+
+	// the client asks for the full stream
+	layer.limitSid = false
+	if !down.thumbnailGated(delta, layer, 6, 100) {
+		t.Errorf("Delta frame was forwarded after thumbnail")
+	}
+	if !down.resync || remote.count != 2 {
+		t.Errorf("Expected resync, got %v %v",
+			down.resync, remote.count)
+	}
+	if down.thumbnailGated(delta, layer, 7, 100) {
+		t.Errorf("Frame was gated outside of thumbnail mode")
+	}
+}
+
+func TestThumbnailRequestDue(t *testing.T) {
+	var s thumbnailState
+	if !s.requestDue(1) {
+		t.Errorf("First thumbnail not requested")
+	}
+	s.requested = 100
+	if s.requestDue(100 + thumbnailRequestDelay - 1) {
+		t.Errorf("Keyframe requested too early after a request")
+	}
+	if !s.requestDue(100 + thumbnailRequestDelay) {
+		t.Errorf("Keyframe not requested after a request")
+	}
+	s.next = 200
+	if s.requestDue(200 + thumbnailRequestDelay - 1) {
+		t.Errorf("Keyframe requested too early after a thumbnail")
+	}
+	if !s.requestDue(200 + thumbnailRequestDelay) {
+		t.Errorf("Keyframe not requested after a thumbnail")
+	}
+}
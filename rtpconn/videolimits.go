@@ -0,0 +1,110 @@
+package rtpconn
+
+import (
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// Groups may cap the dimensions and the framerate of the video published
+// by their members.  The caps are sent to the clients in their
+// capabilities, and well-behaved clients scale their video accordingly.
+// For the others, we measure the dimensions of keyframes (for VP8 and
+// VP9 only) and the framerate of every video track.  A track that
+// exceeds the caps is no longer forwarded: receivers of a simulcast
+// stream get a lower layer instead, and receivers of other streams get
+// no video.
+
+// the period over which the framerate is measured
+const framerateInterval = 2 * rtptime.JiffiesPerSec
+
+type videoLimits struct {
+	width, height, framerate int
+}
+
+func groupVideoLimits(g *group.Group) videoLimits {
+	if g == nil {
+		return videoLimits{}
+	}
+	desc := g.Description()
+	return videoLimits{
+		desc.MaxVideoWidth, desc.MaxVideoHeight, desc.MaxVideoFramerate,
+	}
+}
+
+// videoMeter measures the dimensions and the framerate of an up track.
+// It is only accessed by the reader loop.
+type videoMeter struct {
+	width, height uint32
+	framerate     int
+
+	start     uint64
+	frames    int
+	timestamp uint32
+}
+
+// keyframe records the dimensions of a keyframe, which are zero if
+// unknown.
+func (m *videoMeter) keyframe(width, height uint32) {
+	if width != 0 && height != 0 {
+		m.width = width
+		m.height = height
+	}
+}
+
+// packet records a packet, and returns true when a new measurement of
+// the framerate is available.
+func (m *videoMeter) packet(timestamp uint32, now uint64) bool {
+	if m.start == 0 {
+		m.start = now
+		m.frames = 1
+		m.timestamp = timestamp
+		return false
+	}
+	if timestamp != m.timestamp {
+		m.frames++
+		m.timestamp = timestamp
+	}
+	if now-m.start < framerateInterval {
+		return false
+	}
+	m.framerate = int(uint64(m.frames-1) * rtptime.JiffiesPerSec /
+		(now - m.start))
+	m.start = now
+	m.frames = 1
+	return true
+}
+
+// exceeds returns true if the measured video exceeds the limits.  Since
+// the framerate is measured imprecisely, we allow some slack.
+func (m *videoMeter) exceeds(limits videoLimits) bool {
+	if limits.width > 0 && m.width > uint32(limits.width) {
+		return true
+	}
+	if limits.height > 0 && m.height > uint32(limits.height) {
+		return true
+	}
+	if limits.framerate > 0 &&
+		m.framerate > limits.framerate+limits.framerate/4+1 {
+		return true
+	}
+	return false
+}
+
+// checkVideoLimits updates the state of the track after a measurement,
+// and causes the connection to be pushed again if it changed.
+func (track *rtpUpTrack) checkVideoLimits(m *videoMeter) {
+	g := track.conn.client.Group()
+	over := m.exceeds(groupVideoLimits(g))
+	if track.overLimits.Swap(over) == over || g == nil {
+		return
+	}
+	pushConn(track.conn, g, g.GetClients(track.conn.client))
+}
+
+// overVideoLimits returns true if t is a video track that exceeds the
+// limits of its group.
+func overVideoLimits(t conn.UpTrack) bool {
+	up, ok := t.(*rtpUpTrack)
+	return ok && up.overLimits.Load()
+}
@@ -0,0 +1,72 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/rtptime"
+)
+
+func TestVideoMeterFramerate(t *testing.T) {
+	var m videoMeter
+	now := uint64(1000)
+	ts := uint32(0)
+	done := false
+	for i := 0; i < 100 && !done; i++ {
+		// three packets per frame at 30fps
+		for j := 0; j < 3; j++ {
+			if m.packet(ts, now) {
+				done = true
+			}
+		}
+		ts += 90000 / 30
+		now += rtptime.JiffiesPerSec / 30
+	}
+	if !done {
+		t.Fatalf("No measurement")
+	}
+	if m.framerate < 29 || m.framerate > 31 {
+		t.Errorf("Expected 30, got %v", m.framerate)
+	}
+}
+
+func TestVideoMeterExceeds(t *testing.T) {
+	m := videoMeter{framerate: 30}
+	m.keyframe(1280, 720)
+	m.keyframe(0, 0)
+	if m.width != 1280 || m.height != 720 {
+		t.Errorf("Expected 1280x720, got %vx%v", m.width, m.height)
+	}
+
+	tests := []struct {
+		limits  videoLimits
+		exceeds bool
+	}{
+		{videoLimits{}, false},
+		{videoLimits{1280, 720, 30}, false},
+		{videoLimits{0, 0, 25}, false},
+		{videoLimits{640, 0, 0}, true},
+		{videoLimits{0, 480, 0}, true},
+		{videoLimits{0, 0, 15}, true},
+	}
+	for _, test := range tests {
+		if m.exceeds(test.limits) != test.exceeds {
+			t.Errorf("%v: expected %v", test.limits, test.exceeds)
+		}
+	}
+}
+
+func TestOverVideoLimits(t *testing.T) {
+	track := &rtpUpTrack{}
+	if overVideoLimits(track) {
+		t.Errorf("Fresh track over limits")
+	}
+	track.overLimits.Store(true)
+	if !overVideoLimits(track) {
+		t.Errorf("Track not over limits")
+	}
+	if overVideoLimits(kindTrack{kind: webrtc.RTPCodecTypeVideo}) {
+		t.Errorf("Foreign track over limits")
+	}
+}
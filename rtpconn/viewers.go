@@ -0,0 +1,109 @@
+package rtpconn
+
+import (
+	"time"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/stats"
+)
+
+// We keep track of the number of receivers of each stream, so that
+// publishers and operators can tell how many users are actually watching.
+// Disk writers and other non-WebRTC receivers are not counted.
+
+type viewerStats struct {
+	current int
+	peak    int
+	// the number of subscriptions since the stream was created
+	total int
+	// the sum over all receivers of the time they have been subscribed
+	time  time.Duration
+	since time.Time
+	// the number last reported to the publisher, only accessed by the
+	// publisher's client loop
+	reported int
+}
+
+func isViewer(down conn.Down) bool {
+	_, ok := down.(*rtpDownConnection)
+	return ok
+}
+
+// updateViewers updates the viewer statistics after up.local has changed.
+// Called locked.
+func (up *rtpUpConnection) updateViewers(now time.Time) {
+	count := 0
+	for _, l := range up.local {
+		if isViewer(l) {
+			count++
+		}
+	}
+	v := &up.viewers
+	if !v.since.IsZero() {
+		v.time += time.Duration(v.current) * now.Sub(v.since)
+	}
+	v.since = now
+	if count > v.current {
+		v.total += count - v.current
+	}
+	v.current = count
+	if count > v.peak {
+		v.peak = count
+	}
+}
+
+// getViewers returns the viewer statistics of a stream.
+func (up *rtpUpConnection) getViewers(now time.Time) stats.Viewers {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	v := up.viewers
+	t := v.time
+	if !v.since.IsZero() {
+		t += time.Duration(v.current) * now.Sub(v.since)
+	}
+	return stats.Viewers{
+		Current: v.current,
+		Peak:    v.peak,
+		Total:   v.total,
+		Time:    stats.Duration(t),
+	}
+}
+
+// viewersChanged returns the number of viewers of a stream if it has
+// changed since the last call.
+func (up *rtpUpConnection) viewersChanged() (int, bool) {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	if up.viewers.current == up.viewers.reported {
+		return 0, false
+	}
+	up.viewers.reported = up.viewers.current
+	return up.viewers.current, true
+}
+
+type viewersMessage struct {
+	Id      string `json:"id"`
+	Viewers int    `json:"viewers"`
+}
+
+// reportViewers informs a publisher of the number of viewers of the
+// streams that have changed.
+func reportViewers(c *webClient) error {
+	var changed []viewersMessage
+	for _, up := range getUpConns(c) {
+		n, ok := up.viewersChanged()
+		if ok {
+			changed = append(changed, viewersMessage{up.id, n})
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return c.write(clientMessage{
+		Type:       "usermessage",
+		Kind:       "viewers",
+		Dest:       c.id,
+		Privileged: true,
+		Value:      changed,
+	})
+}
@@ -0,0 +1,45 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestViewers(t *testing.T) {
+	up := &rtpUpConnection{id: "up"}
+	d1 := &rtpDownConnection{id: "d1"}
+	d2 := &rtpDownConnection{id: "d2"}
+
+	now := time.Now()
+	up.local = append(up.local, d1)
+	up.updateViewers(now)
+	up.local = append(up.local, d2)
+	up.updateViewers(now.Add(time.Second))
+
+	n, ok := up.viewersChanged()
+	if !ok || n != 2 {
+		t.Errorf("Expected 2 true, got %v %v", n, ok)
+	}
+	n, ok = up.viewersChanged()
+	if ok {
+		t.Errorf("Expected no change, got %v", n)
+	}
+
+	up.local = up.local[1:]
+	up.updateViewers(now.Add(3 * time.Second))
+	up.local = append(up.local, d1)
+	up.updateViewers(now.Add(3 * time.Second))
+
+	v := up.getViewers(now.Add(4 * time.Second))
+	if v.Current != 2 || v.Peak != 2 || v.Total != 3 ||
+		time.Duration(v.Time) != 7*time.Second {
+		t.Errorf("Expected 2 2 3 7s, got %v %v %v %v",
+			v.Current, v.Peak, v.Total, time.Duration(v.Time))
+	}
+
+	// the count has changed and then changed back
+	n, ok = up.viewersChanged()
+	if ok {
+		t.Errorf("Expected no change, got %v", n)
+	}
+}
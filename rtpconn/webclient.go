@@ -12,6 +12,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -22,6 +23,7 @@ import (
 	"github.com/jech/galene/estimator"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
+	"github.com/jech/galene/stats"
 	"github.com/jech/galene/token"
 	"github.com/jech/galene/unbounded"
 )
@@ -72,9 +74,52 @@ type webClient struct {
 	writerDone  chan struct{}
 	actions     *unbounded.Channel[any]
 
+	// slow-start state, only accessed by the client loop
+	bandwidth       uint64
+	slowStart       bool
+	slowStarted     bool // whether slow start was used since joining
+	pending         []pendingConn
+	pacingTime      time.Time
+	pacingScheduled bool
+
+	// rate limiting of messages, only accessed by the client loop
+	messageLimiter messageLimiter
+	dataLimiter    messageLimiter
+
+	// whether the client understands spare transceivers, see spare.go
+	spareTransceivers bool
+
+	// whether the client only receives audio; nil means the group's
+	// default.  Only accessed by the client loop.
+	audioOnly *bool
+
+	// classes of events that are not sent, see notifications.go
+	suppressedNotifications atomic.Uint32
+
+	// the features that the client lacks, see compat.go
+	quirks atomic.Uint32
+
+	// the session cookies sent with the handshake, immutable
+	sessions []string
+	// a session to send in the next joined message, only accessed
+	// by the client loop
+	session string
+
 	mu   sync.Mutex
 	down map[string]*rtpDownConnection
-	up   map[string]*rtpUpConnection
+	// maps the id of an up connection to the id of the down
+	// connection it has been handed over to
+	downAliases map[string]string
+	up          map[string]*rtpUpConnection
+	limits      *token.Limits
+	// the codecs that the client is able to decode, see fallback.go
+	codecs []string
+
+	ceilings ceilings
+
+	// the difference between the client's clock and ours, measured
+	// at handshake time; immutable
+	clockOffset time.Duration
 }
 
 func (c *webClient) Group() *group.Group {
@@ -141,6 +186,13 @@ type clientMessage struct {
 	Label            string                   `json:"label,omitempty"`
 	Request          interface{}              `json:"request,omitempty"`
 	RTCConfiguration *webrtc.Configuration    `json:"rtcConfiguration,omitempty"`
+	Capabilities     *group.Capabilities      `json:"capabilities,omitempty"`
+	Session          string                   `json:"session,omitempty"`
+	Encrypted        []string                 `json:"encrypted,omitempty"`
+	Codecs           []string                 `json:"codecs,omitempty"`
+	Fallback         string                   `json:"fallback,omitempty"`
+
+	SpareTransceivers bool `json:"spareTransceivers,omitempty"`
 }
 
 type closeMessage struct {
@@ -195,11 +247,22 @@ func addUpConn(c *webClient, id, label string, offer string) (*rtpUpConnection,
 	})
 
 	conn.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		if state == webrtc.ICEConnectionStateFailed {
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			if conn.recorded.CompareAndSwap(false, true) {
+				recordConnection(conn.pc, c.addr)
+			}
+		case webrtc.ICEConnectionStateFailed:
 			c.action(connectionFailedAction{id: id})
 		}
 	})
 
+	watchCandidatePair(conn.pc, &conn.pairs,
+		func(change stats.PairChange) {
+			reportPairChange(c, id, change)
+		},
+	)
+
 	return conn, true, nil
 }
 
@@ -238,6 +301,10 @@ func delUpConn(c *webClient, id string, userId string, push bool) error {
 
 	conn.pc.Close()
 
+	if g != nil {
+		updateSpeakers(g)
+	}
+
 	if push && g != nil {
 		for _, c := range g.GetClients(c) {
 			err := c.PushConn(g, id, nil, nil, replace)
@@ -250,6 +317,17 @@ func delUpConn(c *webClient, id string, userId string, push bool) error {
 	return nil
 }
 
+// downConnId returns the id of the down connection that carries the
+// up connection with the given id.
+func downConnId(c *webClient, id string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if a, ok := c.downAliases[id]; ok {
+		return a
+	}
+	return id
+}
+
 func getDownConn(c *webClient, id string) *rtpDownConnection {
 	if c.down == nil {
 		return nil
@@ -264,6 +342,19 @@ func getDownConn(c *webClient, id string) *rtpDownConnection {
 	return conn
 }
 
+// getDownRemote returns the stream carried by the down connection with
+// the given id, or nil if there is no such connection.  The stream may
+// change when a connection is handed over, see handOverConn.
+func getDownRemote(c *webClient, id string) conn.Up {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	down := c.down[id]
+	if down == nil {
+		return nil
+	}
+	return down.remote
+}
+
 func getConn(c *webClient, id string) iceConnection {
 	up := getUpConn(c, id)
 	if up != nil {
@@ -282,6 +373,10 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if a, ok := c.downAliases[id]; ok {
+		id = a
+	}
+
 	if c.up != nil && c.up[id] != nil {
 		return nil, false, errors.New("adding duplicate connection")
 	}
@@ -298,17 +393,29 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 	if err != nil {
 		return nil, false, err
 	}
+	down.spareCount = spareTransceivers(c)
 
 	down.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		sendICE(c, down.id, candidate)
 	})
 
 	down.pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
-		if state == webrtc.ICEConnectionStateFailed {
+		switch state {
+		case webrtc.ICEConnectionStateConnected:
+			if down.recorded.CompareAndSwap(false, true) {
+				recordConnection(down.pc, c.addr)
+			}
+		case webrtc.ICEConnectionStateFailed:
 			c.action(connectionFailedAction{id: down.id})
 		}
 	})
 
+	watchCandidatePair(down.pc, &down.pairs,
+		func(change stats.PairChange) {
+			reportPairChange(c, down.id, change)
+		},
+	)
+
 	err = remote.AddLocal(down)
 	if err != nil {
 		down.pc.Close()
@@ -316,8 +423,11 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 	}
 
 	c.down[down.id] = down
+	if !isStandby(down) {
+		atomic.AddInt32(&c.ceilings.downConns, 1)
+	}
 
-	go rtcpDownSender(down)
+	go rtcpDownSender(c, down)
 
 	return down, true, nil
 }
@@ -347,22 +457,32 @@ func delDownConnHelper(c *webClient, id string) *rtpDownConnection {
 	for _, track := range conn.tracks {
 		// we only insert the track after we get an answer, so
 		// ignore errors here.
-		track.remote.DelLocal(track)
+		track.getRemote().DelLocal(track)
 	}
 	delete(c.down, id)
+	if !isStandby(conn) {
+		atomic.AddInt32(&c.ceilings.downConns, -1)
+	}
+	for k, v := range c.downAliases {
+		if v == id {
+			delete(c.downAliases, k)
+		}
+	}
 	return conn
 }
 
 var errUnexpectedTrackType = errors.New("unexpected track type, this shouldn't happen")
 
-func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) error {
+// addDownTrackUnlocked adds a down track to conn, and returns true if
+// the connection needs to be renegotiated.
+func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) (bool, error) {
 	for _, t := range conn.tracks {
-		tt, ok := t.remote.(*rtpUpTrack)
+		tt, ok := t.getRemote().(*rtpUpTrack)
 		if !ok {
-			return errUnexpectedTrackType
+			return false, errUnexpectedTrackType
 		}
 		if tt == remoteTrack {
-			return os.ErrExist
+			return false, os.ErrExist
 		}
 	}
 
@@ -390,37 +510,79 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 	} else {
 		remoteCodec.RTCPFeedback = group.AudioRTCPFeedback
 	}
+	if conn.bwe != nil {
+		remoteCodec.RTCPFeedback = append(
+			append([]webrtc.RTCPFeedback(nil),
+				remoteCodec.RTCPFeedback...),
+			webrtc.RTCPFeedback{Type: webrtc.TypeRTCPFBTransportCC},
+		)
+	}
 
-	local, err := webrtc.NewTrackLocalStaticRTP(
-		remoteCodec, id, msid,
-	)
+	ptype, ptypeErr := group.CodecPayloadType(remoteCodec)
+	red := conn.red && ptypeErr == nil &&
+		strings.EqualFold(remoteCodec.MimeType, webrtc.MimeTypeOpus)
+
+	video := strings.HasPrefix(strings.ToLower(remoteCodec.MimeType), "video/")
+
+	var local localTrack
+	var err error
+	if red {
+		local, err = newRedTrack(remoteCodec, ptype, id, msid)
+	} else if video && conn.fec {
+		local, err = newFECTrack(remoteCodec, id, msid)
+	} else if video {
+		local, err = newRTXTrack(remoteCodec, id, msid)
+	} else {
+		local, err = webrtc.NewTrackLocalStaticRTP(
+			remoteCodec, id, msid,
+		)
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	transceiver, err := conn.pc.AddTransceiverFromTrack(local,
-		webrtc.RTPTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionSendonly,
-		},
-	)
-	if err != nil {
-		return err
+	renegotiate := false
+	transceiver := conn.attachSpare(local)
+	if transceiver == nil {
+		renegotiate = true
+		transceiver, err = conn.pc.AddTransceiverFromTrack(local,
+			webrtc.RTPTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionSendonly,
+			},
+		)
+		if err != nil {
+			return false, err
+		}
 	}
 
 	codec := local.Codec()
-	ptype, err := group.CodecPayloadType(local.Codec())
-	if err != nil {
+	if !renegotiate {
+		// the codecs of a spare transceiver have already been
+		// negotiated
+	} else if ptypeErr != nil {
 		log.Printf("Couldn't determine ptype for codec %v: %v",
-			codec.MimeType, err)
+			codec.MimeType, ptypeErr)
 	} else {
-		err := transceiver.SetCodecPreferences(
-			[]webrtc.RTPCodecParameters{
-				{
-					RTPCodecCapability: codec,
-					PayloadType:        ptype,
-				},
+		codecs := []webrtc.RTPCodecParameters{
+			{
+				RTPCodecCapability: codec,
+				PayloadType:        ptype,
 			},
-		)
+		}
+		if red {
+			// prefer RED, but allow falling back to Opus
+			codecs = append(
+				[]webrtc.RTPCodecParameters{group.RedCodec},
+				codecs...,
+			)
+		}
+		if rtx, ok := group.RTXCodec(codecs[0]); ok {
+			codecs = append(codecs, rtx)
+		}
+		if video && conn.fec {
+			codecs = append(codecs, group.FlexFECCodec)
+		}
+		err := transceiver.SetCodecPreferences(codecs)
 		if err != nil {
 			log.Printf("Couldn't set ptype for codec %v: %v",
 				codec.MimeType, err)
@@ -429,7 +591,7 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 
 	parms := transceiver.Sender().GetParameters()
 	if len(parms.Encodings) != 1 {
-		return errors.New("got multiple encodings")
+		return false, errors.New("got multiple encodings")
 	}
 
 	track := &rtpDownTrack{
@@ -446,22 +608,29 @@ func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) erro
 	}
 
 	conn.tracks = append(conn.tracks, track)
+	conn.trackCount(local.Kind(), 1)
 
 	go rtcpDownListener(track)
 
-	return nil
+	return renegotiate, nil
 }
 
-func delDownTrackUnlocked(conn *rtpDownConnection, track *rtpDownTrack) error {
+// delDownTrackUnlocked removes a down track from conn, and returns true
+// if the connection needs to be renegotiated.
+func delDownTrackUnlocked(conn *rtpDownConnection, track *rtpDownTrack) (bool, error) {
 	for i := range conn.tracks {
 		if conn.tracks[i] == track {
-			track.remote.DelLocal(track)
+			track.getRemote().DelLocal(track)
 			conn.tracks =
 				append(conn.tracks[:i], conn.tracks[i+1:]...)
-			return conn.pc.RemoveTrack(track.sender)
+			conn.trackCount(track.track.Kind(), -1)
+			if conn.detachSender(track.sender) {
+				return false, nil
+			}
+			return true, conn.pc.RemoveTrack(track.sender)
 		}
 	}
-	return os.ErrNotExist
+	return false, os.ErrNotExist
 }
 
 func replaceTracks(conn *rtpDownConnection, remote []conn.UpTrack, limitSid bool) (bool, error) {
@@ -478,7 +647,7 @@ outer:
 			return false, errUnexpectedTrackType
 		}
 		for _, track := range conn.tracks {
-			rt2, ok := track.remote.(*rtpUpTrack)
+			rt2, ok := track.getRemote().(*rtpUpTrack)
 			if !ok {
 				return false, errUnexpectedTrackType
 			}
@@ -491,7 +660,7 @@ outer:
 
 outer2:
 	for _, track := range conn.tracks {
-		rt, ok := track.remote.(*rtpUpTrack)
+		rt, ok := track.getRemote().(*rtpUpTrack)
 		if !ok {
 			return false, errUnexpectedTrackType
 		}
@@ -518,25 +687,40 @@ outer2:
 		}
 	}()
 
-	if len(del) == 0 && len(add) == 0 {
-		return false, nil
+	// hand over down tracks to compatible up tracks, which avoids
+	// a renegotiation and keeps the receiver's decoder state.
+	var add2 []*rtpUpTrack
+outer3:
+	for _, rt := range add {
+		for i, t := range del {
+			if compatibleTracks(t, rt) {
+				handOverTrack(t, rt)
+				del = append(del[:i], del[i+1:]...)
+				continue outer3
+			}
+		}
+		add2 = append(add2, rt)
 	}
+	add = add2
 
+	renegotiate := false
 	for _, t := range del {
-		err := delDownTrackUnlocked(conn, t)
+		r, err := delDownTrackUnlocked(conn, t)
 		if err != nil {
 			return false, err
 		}
+		renegotiate = renegotiate || r
 	}
 
 	for _, rt := range add {
-		err := addDownTrackUnlocked(conn, rt)
+		r, err := addDownTrackUnlocked(conn, rt)
 		if err != nil {
 			return false, err
 		}
+		renegotiate = renegotiate || r
 	}
 
-	return true, nil
+	return renegotiate, nil
 }
 
 func negotiate(c *webClient, down *rtpDownConnection, restartIce bool, replace string) error {
@@ -552,6 +736,11 @@ func negotiate(c *webClient, down *rtpDownConnection, restartIce bool, replace s
 
 	down.negotiationNeeded = negotiationUnneeded
 
+	err := down.prepareOffer()
+	if err != nil {
+		return err
+	}
+
 	options := webrtc.OfferOptions{ICERestart: restartIce}
 	offer, err := down.pc.CreateOffer(&options)
 	if err != nil {
@@ -564,23 +753,45 @@ func negotiate(c *webClient, down *rtpDownConnection, restartIce bool, replace s
 	}
 
 	source, username := down.remote.User()
+	var kind string
+	if isStandby(down) {
+		kind = "standby"
+	}
 
 	return c.write(clientMessage{
-		Type:     "offer",
-		Id:       down.id,
-		Label:    down.remote.Label(),
-		Replace:  replace,
-		Source:   source,
-		Username: &username,
-		SDP:      down.pc.LocalDescription().SDP,
+		Type:      "offer",
+		Kind:      kind,
+		Id:        down.id,
+		Label:     down.remote.Label(),
+		Replace:   replace,
+		Source:    source,
+		Username:  &username,
+		SDP:       localSDP(c, down.pc),
+		Encrypted: encryptedTracks(down),
 	})
 }
 
+// localSDP returns the local description of pc, without the candidates
+// that the group's ICE policy forbids advertising.
+func localSDP(c group.Client, pc *webrtc.PeerConnection) string {
+	sdp := pc.LocalDescription().SDP
+	if g := c.Group(); g != nil {
+		sdp = g.ICEPolicy().SDP(sdp)
+	}
+	return sdp
+}
+
 func sendICE(c *webClient, id string, candidate *webrtc.ICECandidate) error {
 	if candidate == nil {
 		return nil
 	}
 	cand := candidate.ToJSON()
+	if g := c.Group(); g != nil {
+		cand.Candidate = g.ICEPolicy().Candidate(cand.Candidate)
+		if cand.Candidate == "" {
+			return nil
+		}
+	}
 	return c.write(clientMessage{
 		Type:      "ice",
 		Id:        id,
@@ -588,11 +799,32 @@ func sendICE(c *webClient, id string, candidate *webrtc.ICECandidate) error {
 	})
 }
 
-func gotOffer(c *webClient, id, label string, sdp string, replace string) error {
-	up, _, err := addUpConn(c, id, label, sdp)
+func gotOffer(c *webClient, id, label string, sdp string, replace string, encrypted []string, fallback string) error {
+	err := checkQuirks(c, sdp, "")
+	if err != nil {
+		return err
+	}
+
+	err = checkWebLimits(c, id, replace, sdp)
+	if err != nil {
+		return err
+	}
+
+	err = checkWebScreenshare(c, id, replace, sdp, fallback)
+	if err != nil {
+		return err
+	}
+
+	up, isnew, err := addUpConn(c, id, label, sdp)
 	if err != nil {
 		return err
 	}
+	up.setEncrypted(encrypted)
+	if isnew && fallback != "" {
+		up.mu.Lock()
+		up.fallback = fallback
+		up.mu.Unlock()
+	}
 
 	if replace != "" {
 		up.replace = replace
@@ -625,7 +857,7 @@ func gotOffer(c *webClient, id, label string, sdp string, replace string) error
 	return c.write(clientMessage{
 		Type: "answer",
 		Id:   id,
-		SDP:  up.pc.LocalDescription().SDP,
+		SDP:  localSDP(c, up.pc),
 	})
 }
 
@@ -637,7 +869,16 @@ func gotAnswer(c *webClient, id string, sdp string) error {
 		return ErrUnknownId
 	}
 
-	err := down.pc.SetRemoteDescription(webrtc.SessionDescription{
+	var offer string
+	if d := down.pc.LocalDescription(); d != nil {
+		offer = d.SDP
+	}
+	err := checkQuirks(c, sdp, offer)
+	if err != nil {
+		return err
+	}
+
+	err = down.pc.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
 		SDP:  sdp,
 	})
@@ -653,7 +894,7 @@ func gotAnswer(c *webClient, id string, sdp string) error {
 	add := func() {
 		down.pc.OnConnectionStateChange(nil)
 		for _, t := range down.tracks {
-			err := t.remote.AddLocal(t)
+			err := t.getRemote().AddLocal(t)
 			if err != nil && err != os.ErrClosed {
 				log.Printf("Add track: %v", err)
 			}
@@ -725,11 +966,20 @@ func parseRequested(r interface{}) (map[string][]string, error) {
 	return rrr, nil
 }
 
-func (c *webClient) setRequested(requested map[string][]string) error {
+func (c *webClient) setRequested(requested map[string][]string, bandwidth uint64) error {
 	if c.group == nil {
 		return errors.New("attempted to request with no group joined")
 	}
 	c.requested = requested
+	if bandwidth > 0 {
+		c.bandwidth = bandwidth
+	}
+	// only the initial request pushes many streams at once; later
+	// requests are served immediately
+	if !c.slowStarted {
+		c.slowStart = true
+		c.slowStarted = true
+	}
 
 	requestConns(c, c.group, "")
 	return nil
@@ -742,6 +992,10 @@ func (c *webClient) setRequestedStream(down *rtpDownConnection, requested []stri
 		remoteClient = remote.client
 	}
 	down.requested = requested
+	if remoteClient == nil {
+		// a standby connection
+		return nil
+	}
 	return remoteClient.RequestConns(c, c.group, remote.id)
 }
 
@@ -757,6 +1011,27 @@ func requestConns(target group.Client, g *group.Group, id string) {
 	}
 }
 
+// rtcConfiguration returns the ICE configuration sent to clients of g,
+// which may be nil.
+func rtcConfiguration(g *group.Group) *webrtc.Configuration {
+	if g == nil {
+		return ice.ICEConfiguration()
+	}
+	return g.ICEConfiguration()
+}
+
+// receiveAudioOnly returns true if video should not be forwarded to c.
+func receiveAudioOnly(c *webClient) bool {
+	if c.audioOnly != nil {
+		return *c.audioOnly
+	}
+	g := c.group
+	if g == nil {
+		return false
+	}
+	return g.Description().ReceiveAudioOnly
+}
+
 func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([]conn.UpTrack, bool) {
 	if len(requested) == 0 {
 		return nil, false
@@ -774,12 +1049,17 @@ func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([
 			log.Printf("client requested unknown value %v", s)
 		}
 	}
+	if receiveAudioOnly(c) {
+		video = false
+		videoLow = false
+	}
 
 	find := func(kind webrtc.RTPCodecType, last bool) (conn.UpTrack, int) {
 		var track conn.UpTrack
 		count := 0
 		for _, t := range tracks {
-			if t.Kind() != kind {
+			if t.Kind() != kind || !c.canDecode(t) ||
+				overVideoLimits(t) {
 				continue
 			}
 			track = t
@@ -834,7 +1114,7 @@ func readMessage(conn *websocket.Conn, m *clientMessage) error {
 
 const protocolVersion = "2"
 
-func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
+func StartClient(conn *websocket.Conn, addr net.Addr, sessions []string) (err error) {
 	var m clientMessage
 
 	err = readMessage(conn, &m)
@@ -865,13 +1145,16 @@ func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
 	}
 
 	c := &webClient{
-		addr:    addr,
-		id:      m.Id,
-		actions: unbounded.New[any](),
-		done:    make(chan struct{}),
+		addr:        addr,
+		sessions:    sessions,
+		id:          m.Id,
+		actions:     unbounded.New[any](),
+		done:        make(chan struct{}),
+		clockOffset: clockOffset(m.Time, time.Now()),
 	}
 
 	defer close(c.done)
+	defer c.forgetQuirks()
 
 	c.writeCh = make(chan interface{}, 100)
 	c.writerDone = make(chan struct{})
@@ -934,6 +1217,36 @@ type kickAction struct {
 
 var errEmptyId = group.ProtocolError("empty id")
 
+// recordingOptions parses the value of a record action, which is either
+// a string, the container format, or a dictionary with optional fields
+// format and layout.
+func recordingOptions(value any) (string, string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", "", true
+	case string:
+		return v, "", true
+	case map[string]any:
+		var format, layout string
+		var ok bool
+		if f, found := v["format"]; found {
+			format, ok = f.(string)
+			if !ok {
+				return "", "", false
+			}
+		}
+		if l, found := v["layout"]; found {
+			layout, ok = l.(string)
+			if !ok {
+				return "", "", false
+			}
+		}
+		return format, layout, true
+	default:
+		return "", "", false
+	}
+}
+
 func member(v string, l []string) bool {
 	for _, w := range l {
 		if v == w {
@@ -975,6 +1288,7 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 	err := c.write(clientMessage{
 		Type:    "handshake",
 		Version: []string{protocolVersion},
+		Time:    time.Now().Format(time.RFC3339Nano),
 	})
 	if err != nil {
 		return err
@@ -1020,6 +1334,10 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 			if time.Since(readTime) > 45*time.Second {
 				return errors.New("client is dead")
 			}
+			err := reportViewers(c)
+			if err != nil {
+				return err
+			}
 			// Some reverse proxies timeout connexions at 60
 			// seconds, make sure we generate some activity
 			if time.Since(readTime) > 20*time.Second {
@@ -1035,6 +1353,21 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 }
 
 func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	// the stream may have been handed over to a different connection
+	upId := id
+	id = downConnId(c, id)
+	if replace != "" {
+		replace = downConnId(c, replace)
+	}
+
+	if up == nil {
+		// ignore the closing of a stream that has been replaced
+		remote := getDownRemote(c, id)
+		if remote != nil && remote.Id() != upId {
+			return nil
+		}
+	}
+
 	var requested []conn.UpTrack
 	limitSid := false
 	if up != nil {
@@ -1042,20 +1375,43 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 		if replace != "" {
 			old = getDownConn(c, replace)
 		} else {
-			old = getDownConn(c, up.Id())
+			old = getDownConn(c, id)
 		}
 		var req []string
 		if old != nil {
 			req = old.requested
 		}
 		if req == nil {
-			var ok bool
-			req, ok = c.requested[up.Label()]
-			if !ok {
-				req = c.requested[""]
-			}
+			req = requestedFor(c, up)
 		}
 		requested, limitSid = requestedTracks(c, req, tracks)
+		requested = fallbackTracks(c, up, requested)
+		if c.group != nil && c.group.HeldBack(c.permissions) {
+			requested = nil
+		}
+	}
+
+	if replace != "" && len(requested) > 0 {
+		down := handOverConn(c, up, replace)
+		if down != nil {
+			_, err := replaceTracks(down, requested, limitSid)
+			if err != nil {
+				return err
+			}
+			err = sendTrackChanges(c, down)
+			if err != nil {
+				return err
+			}
+			// renegotiate even if no tracks changed, in order
+			// to inform the client of the new label and user
+			err = negotiate(c, down, false, "")
+			if err != nil {
+				log.Printf("Negotiation failed: %v", err)
+				closeDownConn(c, down.id, err.Error())
+				return err
+			}
+			return nil
+		}
 	}
 
 	if replace != "" {
@@ -1077,6 +1433,33 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 		return nil
 	}
 
+	if replace == "" && getDownConn(c, id) == nil {
+		if down := takeStandby(c, up); down != nil {
+			done, err := replaceTracks(down, requested, limitSid)
+			if err != nil {
+				return err
+			}
+			err = sendStream(c, down)
+			if err != nil {
+				return err
+			}
+			err = sendTrackChanges(c, down)
+			if err != nil {
+				return err
+			}
+			if done {
+				// not enough spare transceivers
+				err = negotiate(c, down, false, "")
+				if err != nil {
+					log.Printf("Negotiation failed: %v", err)
+					closeDownConn(c, down.id, err.Error())
+					return err
+				}
+			}
+			return addStandby(c)
+		}
+	}
+
 	down, _, err := addDownConn(c, up)
 	if err != nil {
 		if errors.Is(err, os.ErrClosed) {
@@ -1085,6 +1468,10 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 		return err
 	}
 	done, err := replaceTracks(down, requested, limitSid)
+	if err != nil {
+		return err
+	}
+	err = sendTrackChanges(c, down)
 	if err != nil || !done {
 		return err
 	}
@@ -1105,7 +1492,16 @@ func handleAction(c *webClient, a any) error {
 			log.Printf("Got connectsions for wrong group")
 			return nil
 		}
+		if queuePending(c, a) {
+			return nil
+		}
+		delPending(c, a.id)
+		if a.replace != "" {
+			delPending(c, a.replace)
+		}
 		return pushDownConn(c, a.id, a.conn, a.tracks, a.replace)
+	case pacingAction:
+		return processPending(c)
 	case requestConnsAction:
 		g := c.group
 		if g == nil || a.group != g {
@@ -1116,6 +1512,9 @@ func handleAction(c *webClient, a any) error {
 			if a.id != "" && a.id != u.id {
 				continue
 			}
+			if !fallbackRecipient(u, a.target) {
+				continue
+			}
 			tracks := u.getTracks()
 			replace := u.getReplace(false)
 
@@ -1134,15 +1533,22 @@ func handleAction(c *webClient, a any) error {
 			if err != nil {
 				return err
 			}
+			remote := getDownRemote(c, a.id)
+			if remote == nil {
+				return nil
+			}
+			if _, ok := remote.(*standbyUp); ok {
+				return nil
+			}
 			tracks := make(
 				[]conn.UpTrack, len(down.tracks),
 			)
 			for i, t := range down.tracks {
-				tracks[i] = t.remote
+				tracks[i] = t.getRemote()
 			}
 			c.PushConn(
 				c.group,
-				down.remote.Id(), down.remote,
+				remote.Id(), remote,
 				tracks, "",
 			)
 		} else if up := getUpConn(c, a.id); up != nil {
@@ -1162,7 +1568,7 @@ func handleAction(c *webClient, a any) error {
 		}
 		perms := append([]string(nil), a.permissions...)
 		username := a.username
-		return c.write(clientMessage{
+		m := filterClient(c, clientMessage{
 			Type:        "user",
 			Kind:        a.kind,
 			Id:          a.id,
@@ -1170,20 +1576,32 @@ func handleAction(c *webClient, a any) error {
 			Permissions: perms,
 			Data:        a.data,
 		})
+		if a.kind == "delete" || a.kind == "change" {
+			// the receiver that needed a fallback may be gone
+			checkFallbacks(c, c.group)
+		}
+		return c.write(m)
 	case joinedAction:
 		var status *group.Status
 		var data map[string]interface{}
+		var caps *group.Capabilities
 		var g *group.Group
 		if a.group != "" {
 			g = group.Get(a.group)
 			if g != nil {
-				s := g.Status(true, nil)
+				s := clientStatus(c, g)
 				status = &s
 				data = g.Data()
+				caps = clientCapabilities(c, g)
 			}
 		}
 		perms := append([]string(nil), c.permissions...)
 		username := c.username
+		var session string
+		if a.kind == "join" {
+			session = c.session
+			c.session = ""
+		}
 		err := c.write(clientMessage{
 			Type:             "joined",
 			Kind:             a.kind,
@@ -1192,11 +1610,19 @@ func handleAction(c *webClient, a any) error {
 			Permissions:      perms,
 			Status:           status,
 			Data:             data,
-			RTCConfiguration: ice.ICEConfiguration(),
+			RTCConfiguration: rtcConfiguration(g),
+			Capabilities:     caps,
+			Session:          session,
 		})
 		if err != nil {
 			return err
 		}
+		if a.kind == "change" && g != nil {
+			// the group's definition changed, for example because
+			// a user was updated or a password changed, which may
+			// have changed the set of receivers
+			checkFallbacks(c, g)
+		}
 		if a.kind == "join" {
 			if g == nil {
 				log.Println("g is null when joining" +
@@ -1218,6 +1644,22 @@ func handleAction(c *webClient, a any) error {
 					return err
 				}
 			}
+			for _, m := range g.GetCaptions(time.Now()) {
+				err := c.write(clientMessage{
+					Type:     "caption",
+					Source:   m.Source,
+					Username: m.User,
+					Time:     m.Time.Format(time.RFC3339),
+					Value:    m.Value,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			err = addStandby(c)
+			if err != nil {
+				log.Printf("Standby connection: %v", err)
+			}
 		}
 	case permissionsChangedAction:
 		g := c.Group()
@@ -1225,7 +1667,7 @@ func handleAction(c *webClient, a any) error {
 			return errors.New("Permissions changed in no group")
 		}
 		perms := append([]string(nil), c.permissions...)
-		status := g.Status(true, nil)
+		status := clientStatus(c, g)
 		username := c.username
 		c.write(clientMessage{
 			Type:             "joined",
@@ -1234,10 +1676,16 @@ func handleAction(c *webClient, a any) error {
 			Username:         &username,
 			Permissions:      perms,
 			Status:           &status,
-			RTCConfiguration: ice.ICEConfiguration(),
+			RTCConfiguration: g.ICEConfiguration(),
+			Capabilities:     clientCapabilities(c, g),
 		})
-		if !member("present", c.permissions) {
+		present := member("present", c.permissions)
+		if !present || !g.CanScreenshare(c.permissions) {
 			up := getUpConns(c)
+			if present {
+				// a presenter only loses their screen shares
+				up = screenshareConns(up)
+			}
 			for _, u := range up {
 				err := delUpConn(
 					c, u.id, c.id, true,
@@ -1250,6 +1698,9 @@ func handleAction(c *webClient, a any) error {
 				}
 			}
 		}
+		if g.Rehearsal() {
+			requestConns(c, g, "")
+		}
 		id := c.Id()
 		user := c.Username()
 		d := c.Data()
@@ -1311,6 +1762,12 @@ func leaveGroup(c *webClient) {
 	c.permissions = nil
 	c.data = nil
 	c.requested = make(map[string][]string)
+	c.pending = nil
+	c.slowStart = false
+	c.slowStarted = false
+	// the ceilings only last until the client leaves
+	atomic.StoreUint64(&c.ceilings.up, 0)
+	atomic.StoreUint64(&c.ceilings.down, 0)
 	c.group = nil
 }
 
@@ -1349,7 +1806,8 @@ func setPermissions(g *group.Group, id string, perm string) error {
 	switch perm {
 	case "op":
 		c.permissions = addnew("op", c.permissions)
-		if g.Description().AllowRecording {
+		desc := g.Description()
+		if desc.AllowRecording && !desc.PrivacyMode {
 			c.permissions = addnew("record", c.permissions)
 		}
 	case "unop":
@@ -1359,6 +1817,10 @@ func setPermissions(g *group.Group, id string, perm string) error {
 		c.permissions = addnew("present", c.permissions)
 	case "unpresent":
 		c.permissions = remove("present", c.permissions)
+	case "screenshare":
+		c.permissions = addnew("screenshare", c.permissions)
+	case "unscreenshare":
+		c.permissions = remove("screenshare", c.permissions)
 	case "shutup":
 		c.permissions = remove("message", c.permissions)
 	case "unshutup":
@@ -1380,12 +1842,23 @@ func (c *webClient) Joined(group, kind string) error {
 	return nil
 }
 
+// clientStatus returns the status of a group as seen by a client.
+func clientStatus(c *webClient, g *group.Group) group.Status {
+	s := g.Status(true, nil)
+	if s.Files != nil {
+		s.Files = g.ClientFiles(c.Id())
+	}
+	return s
+}
+
 func kickClient(g *group.Group, id string, user *string, dest string, message string) error {
 	client := g.GetClient(dest)
 	if client == nil {
 		return group.UserError("no such user")
 	}
 
+	// don't let the user back in without a password
+	group.RevokeSessions(g.Name(), client.Username())
 	return client.Kick(id, user, message)
 }
 
@@ -1404,6 +1877,12 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		}
 	}
 
+	if rateLimited(m) && !checkMessageRate(c) {
+		return c.error(group.UserError(
+			"too many messages, please slow down",
+		))
+	}
+
 	switch m.Type {
 	case "join":
 		if m.Kind == "leave" {
@@ -1423,12 +1902,21 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				"cannot join multiple groups",
 			)
 		}
-		c.data = m.Data
+		desc, err := group.GetDescription(m.Group)
+		if err == nil {
+			c.data, err = group.SanitiseUserData(desc, m.Data)
+			if err != nil {
+				return c.error(err)
+			}
+		}
+		c.setCodecs(m.Codecs)
+		c.spareTransceivers = m.SpareTransceivers
 		g, err := group.AddClient(m.Group, c,
 			group.ClientCredentials{
 				Username: m.Username,
 				Password: m.Password,
 				Token:    m.Token,
+				Sessions: c.sessions,
 			},
 		)
 		if err != nil {
@@ -1475,12 +1963,24 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			})
 		}
 		c.group = g
+		if m.Password != "" {
+			// the joined message is still in the queue
+			session, _, err := group.NewSession(g, c.username)
+			if err != nil {
+				log.Printf("NewSession: %v", err)
+			}
+			c.session = session
+		}
 	case "request":
 		requested, err := parseRequested(m.Request)
 		if err != nil {
 			return err
 		}
-		return c.setRequested(requested)
+		var bandwidth uint64
+		if v, ok := m.Value.(float64); ok && v > 0 {
+			bandwidth = uint64(v)
+		}
+		return c.setRequested(requested, bandwidth)
 	case "requestStream":
 		down := getDownConn(c, m.Id)
 		if down == nil {
@@ -1505,7 +2005,23 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			})
 			return c.error(group.UserError("not authorised"))
 		}
-		err := gotOffer(c, m.Id, m.Label, m.SDP, m.Replace)
+		if m.Label == "screenshare" &&
+			(c.group == nil || !c.group.CanScreenshare(c.permissions)) {
+			if m.Replace != "" {
+				delUpConn(c, m.Replace, c.id, true)
+			}
+			c.write(clientMessage{
+				Type: "abort",
+				Id:   m.Id,
+			})
+			return c.error(group.UserError(
+				"not authorised to share the screen",
+			))
+		}
+		err := gotOffer(
+			c, m.Id, m.Label, m.SDP, m.Replace, m.Encrypted,
+			m.Fallback,
+		)
 		if err != nil {
 			log.Printf("gotOffer: %v", err)
 			return failUpConnection(c, m.Id, err.Error())
@@ -1589,6 +2105,12 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		if !member(required, c.permissions) {
 			return c.error(group.UserError("not authorised"))
 		}
+		if m.Type == "usermessage" && m.Kind == "filetransfer" &&
+			g.Description().PrivacyMode {
+			return c.error(group.UserError(
+				"file transfer is disabled in this group",
+			))
+		}
 
 		id := m.Id
 		if m.Type == "chat" && m.Dest == "" && id == "" {
@@ -1640,6 +2162,31 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			}
 			ccc.write(mm)
 		}
+	case "caption":
+		g := c.group
+		if g == nil {
+			return c.error(group.UserError("join a group first"))
+		}
+		if !member("caption", c.permissions) {
+			return c.error(group.UserError("not authorised"))
+		}
+		now := time.Now()
+		g.AddCaption(m.Source, m.Username, now, m.Value)
+		var except group.Client
+		if m.NoEcho {
+			except = c
+		}
+		err := broadcast(g.GetClients(except), clientMessage{
+			Type:     "caption",
+			Source:   m.Source,
+			Username: m.Username,
+			Time:     now.Format(time.RFC3339),
+			NoEcho:   m.NoEcho,
+			Value:    m.Value,
+		})
+		if err != nil {
+			log.Printf("broadcast(caption): %v", err)
+		}
 	case "groupaction":
 		g := c.group
 		if g == nil {
@@ -1687,37 +2234,81 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				message = v
 			}
 			g.SetLocked(m.Kind == "lock", message)
+		case "takefloor", "releasefloor", "givefloor":
+			if !g.FloorControl() {
+				return c.error(group.UserError(
+					"this group is not in floor-control mode",
+				))
+			}
+			switch m.Kind {
+			case "takefloor":
+				if !member("present", c.permissions) {
+					return c.error(group.UserError("not authorised"))
+				}
+				err := g.TakeFloor(c.id, false, time.Now())
+				if err != nil {
+					return c.error(err)
+				}
+			case "releasefloor":
+				g.ReleaseFloor(c.id)
+			case "givefloor":
+				if !member("op", c.permissions) {
+					return c.error(group.UserError("not authorised"))
+				}
+				id, ok := m.Value.(string)
+				if !ok {
+					return group.ProtocolError("bad value for givefloor")
+				}
+				if id != "" && g.GetClient(id) == nil {
+					return c.error(group.UserError("no such user"))
+				}
+				g.TakeFloor(id, true, time.Now())
+			}
+			updateFloor(g)
+		case "rehearsal":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			rehearsal, ok := m.Value.(bool)
+			if !ok {
+				return group.ProtocolError("bad value for rehearsal")
+			}
+			g.SetRehearsal(rehearsal)
+			for _, cc := range g.GetClients(nil) {
+				perms := cc.Permissions()
+				if !member("present", perms) && !member("op", perms) {
+					requestConns(cc, g, "")
+				}
+			}
 		case "record":
 			if !member("record", c.permissions) {
 				return c.error(group.UserError("not authorised"))
 			}
-			for _, cc := range g.GetClients(c) {
-				_, ok := cc.(*diskwriter.Client)
-				if ok {
-					return c.error(group.UserError("already recording"))
-				}
+			format, layout, ok := recordingOptions(m.Value)
+			if !ok {
+				return c.error(group.UserError(
+					"bad recording format",
+				))
 			}
-			disk := diskwriter.New(g)
-			_, err := group.AddClient(g.Name(), disk,
-				group.ClientCredentials{
-					System: true,
-				},
-			)
+			_, err := diskwriter.Start(g, format, layout)
 			if err != nil {
-				disk.Close()
 				return c.error(err)
 			}
-			requestConns(disk, c.group, "")
+			g.NotifyWebhooks(group.WebhookEvent{
+				Kind:     "record",
+				Id:       c.id,
+				Username: c.username,
+			})
 		case "unrecord":
 			if !member("record", c.permissions) {
 				return c.error(group.UserError("not authorised"))
 			}
-			for _, cc := range g.GetClients(c) {
-				disk, ok := cc.(*diskwriter.Client)
-				if ok {
-					disk.Close()
-					group.DelClient(disk)
-				}
+			if diskwriter.Stop(g) {
+				g.NotifyWebhooks(group.WebhookEvent{
+					Kind:     "unrecord",
+					Id:       c.id,
+					Username: c.username,
+				})
 			}
 		case "subgroups":
 			if !member("op", c.permissions) {
@@ -1764,7 +2355,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			if !member("token", c.permissions) {
 				return terror("not-authorised", "not authorised")
 			}
-			tok, err := parseStatefulToken(m.Value)
+			tok, err := parseStatefulToken(m.Value, c.clockOffset)
 			if err != nil {
 				return terror("error", err.Error())
 			}
@@ -1812,7 +2403,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			now := time.Now().UTC()
 			tok.IssuedAt = &now
 
-			new, err := token.Update(tok, "")
+			new, err := c.group.AddToken(tok, now)
 			if err != nil {
 				return terror("error", err.Error())
 			}
@@ -1836,7 +2427,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				!member("token", c.permissions) {
 				return terror("not-authorised", "not authorised")
 			}
-			tok, err := parseStatefulToken(m.Value)
+			tok, err := parseStatefulToken(m.Value, c.clockOffset)
 			if err != nil {
 				return terror("error", err.Error())
 			}
@@ -1844,7 +2435,8 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				tok.IncludeSubgroups ||
 				tok.Permissions != nil ||
 				tok.IssuedBy != nil ||
-				tok.IssuedAt != nil {
+				tok.IssuedAt != nil ||
+				tok.Limits != nil {
 				return terror(
 					"error", "this field cannot be edited",
 				)
@@ -1905,7 +2497,8 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			return c.error(group.UserError("join a group first"))
 		}
 		switch m.Kind {
-		case "op", "unop", "present", "unpresent", "shutup", "unshutup":
+		case "op", "unop", "present", "unpresent",
+			"screenshare", "unscreenshare", "shutup", "unshutup":
 			if !member("op", c.permissions) {
 				return c.error(group.UserError("not authorised"))
 			}
@@ -1959,26 +2552,54 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			if err != nil {
 				return c.error(err)
 			}
+		case "bandwidth":
+			if !member("op", c.permissions) {
+				return c.error(group.UserError("not authorised"))
+			}
+			up, down, err := parseBandwidth(m.Value)
+			if err != nil {
+				return c.error(err)
+			}
+			err = setBandwidth(g, m.Dest, up, down)
+			if err != nil {
+				return c.error(err)
+			}
 		case "setdata":
 			if m.Dest != c.Id() {
 				return c.error(group.UserError("not authorised"))
 			}
+			if !checkDataRate(c) {
+				return c.error(group.UserError(
+					"too many data updates, please slow down",
+				))
+			}
 			data, ok := m.Value.(map[string]interface{})
 			if !ok {
 				return c.error(group.UserError(
 					"Bad value in setdata",
 				))
 			}
-			if c.data == nil {
-				c.data = make(map[string]interface{})
+			desc := g.Description()
+			data, err := group.SanitiseUserData(desc, data)
+			if err != nil {
+				return c.error(err)
+			}
+			d := maps.Clone(c.data)
+			if d == nil {
+				d = make(map[string]interface{})
 			}
 			for k, v := range data {
 				if v == nil {
-					delete(c.data, k)
+					delete(d, k)
 				} else {
-					c.data[k] = v
+					d[k] = v
 				}
 			}
+			d, err = group.SanitiseUserData(desc, d)
+			if err != nil {
+				return c.error(err)
+			}
+			c.data = d
 			id := c.Id()
 			user := c.Username()
 			perms := c.Permissions()
@@ -1994,6 +2615,25 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 		default:
 			return group.UserError("unknown user action")
 		}
+	case "notifications":
+		suppressed, err := parseNotifications(m.Value)
+		if err != nil {
+			return err
+		}
+		c.suppressedNotifications.Store(suppressed)
+	case "audioOnly":
+		var audioOnly *bool
+		if m.Value != nil {
+			v, ok := m.Value.(bool)
+			if !ok {
+				return group.ProtocolError("bad value for audioOnly")
+			}
+			audioOnly = &v
+		}
+		c.audioOnly = audioOnly
+		if c.group != nil {
+			requestConns(c, c.group, "")
+		}
 	case "pong":
 		// nothing
 	case "ping":
@@ -2007,7 +2647,24 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 	return nil
 }
 
-func parseStatefulToken(value interface{}) (*token.Stateful, error) {
+// clockOffset returns the difference between the time sent by a client
+// in its handshake and the local time.  This includes the one-way latency
+// of the connection, which is negligible for our purposes.
+func clockOffset(clientTime string, now time.Time) time.Duration {
+	if clientTime == "" {
+		return 0
+	}
+	t, err := time.Parse(time.RFC3339, clientTime)
+	if err != nil {
+		return 0
+	}
+	return t.Sub(now)
+}
+
+// parseStatefulToken parses a token sent by a client.  Absolute times are
+// converted to server time if offset, the client's clock offset, exceeds
+// the clock tolerance.
+func parseStatefulToken(value interface{}, offset time.Duration) (*token.Stateful, error) {
 	data, ok := value.(map[string]interface{})
 	if !ok || data == nil {
 		return nil, errors.New("bad token value")
@@ -2053,6 +2710,9 @@ func parseStatefulToken(value interface{}) (*token.Stateful, error) {
 			if err != nil {
 				return nil, errors.New("bad time value")
 			}
+			if offset.Abs() > token.ClockTolerance() {
+				vv = vv.Add(-offset)
+			}
 			return &vv, nil
 		case float64: // relative time
 			vv := time.Now().Add(time.Duration(v) * time.Millisecond)
@@ -2182,6 +2842,22 @@ func (c *webClient) Warn(oponly bool, message string) error {
 	})
 }
 
+// Announce sends a server-wide announcement to the client.
+func (c *webClient) Announce(username, message string) error {
+	m := clientMessage{
+		Type:       "usermessage",
+		Kind:       "announcement",
+		Dest:       c.id,
+		Privileged: true,
+		Time:       time.Now().Format(time.RFC3339),
+		Value:      message,
+	}
+	if username != "" {
+		m.Username = &username
+	}
+	return c.write(m)
+}
+
 var ErrClientDead = errors.New("client is dead")
 
 func (c *webClient) action(a interface{}) {
@@ -2204,7 +2880,7 @@ func broadcast(cs []group.Client, m clientMessage) error {
 	}
 	for _, c := range cs {
 		cc, ok := c.(*webClient)
-		if !ok {
+		if !ok || !filterChat(cc, &m) {
 			continue
 		}
 		select {
@@ -4,8 +4,13 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
 	"github.com/jech/galene/token"
+
+	"github.com/pion/webrtc/v4"
 )
 
 var tokens = []string{
@@ -41,7 +46,7 @@ func TestParseStatefulToken(t *testing.T) {
 			t.Errorf("Unmarshal (map) %v: %v", i, err)
 			continue
 		}
-		t2, err := parseStatefulToken(m)
+		t2, err := parseStatefulToken(m, 0)
 		if err != nil {
 			t.Errorf("parseStatefulToken %v: %v", i, err)
 		}
@@ -50,3 +55,117 @@ func TestParseStatefulToken(t *testing.T) {
 		}
 	}
 }
+
+func TestClockOffset(t *testing.T) {
+	now := time.Now()
+	if o := clockOffset("", now); o != 0 {
+		t.Errorf("Empty: got %v", o)
+	}
+	if o := clockOffset("garbage", now); o != 0 {
+		t.Errorf("Garbage: got %v", o)
+	}
+	client := now.Add(-time.Hour).Format(time.RFC3339Nano)
+	o := clockOffset(client, now)
+	if o > -time.Hour+time.Millisecond || o < -time.Hour-time.Millisecond {
+		t.Errorf("Expected -1h, got %v", o)
+	}
+
+	// a client that is one hour behind asks for a token that is
+	// valid from its own time
+	m := map[string]interface{}{
+		"not-before": client,
+	}
+	tok, err := parseStatefulToken(m, o)
+	if err != nil {
+		t.Fatalf("parseStatefulToken: %v", err)
+	}
+	if d := tok.NotBefore.Sub(now).Abs(); d > time.Millisecond {
+		t.Errorf("Expected %v, got %v", now, tok.NotBefore)
+	}
+
+	// small offsets are ignored
+	tok, err = parseStatefulToken(m, time.Second)
+	if err != nil {
+		t.Fatalf("parseStatefulToken: %v", err)
+	}
+	if d := tok.NotBefore.Sub(now.Add(-time.Hour)).Abs(); d > time.Millisecond {
+		t.Errorf("Expected %v, got %v", now.Add(-time.Hour), tok.NotBefore)
+	}
+}
+
+type kindTrack struct {
+	conn.UpTrack
+	kind webrtc.RTPCodecType
+}
+
+func (t kindTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+func TestRequestedTracksAudioOnly(t *testing.T) {
+	audio := kindTrack{kind: webrtc.RTPCodecTypeAudio}
+	video := kindTrack{kind: webrtc.RTPCodecTypeVideo}
+	tracks := []conn.UpTrack{audio, video}
+	req := []string{"audio", "video"}
+
+	c := &webClient{}
+	ts, _ := requestedTracks(c, req, tracks)
+	if len(ts) != 2 {
+		t.Errorf("Expected 2 tracks, got %v", len(ts))
+	}
+
+	yes := true
+	c.audioOnly = &yes
+	ts, _ = requestedTracks(c, req, tracks)
+	if len(ts) != 1 || ts[0].Kind() != webrtc.RTPCodecTypeAudio {
+		t.Errorf("Expected audio only, got %v", ts)
+	}
+	ts, _ = requestedTracks(c, []string{"video-low"}, tracks)
+	if len(ts) != 0 {
+		t.Errorf("Expected no tracks, got %v", ts)
+	}
+
+	g, err := group.Add("audio-only-test",
+		&group.Description{ReceiveAudioOnly: true},
+	)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("audio-only-test")
+	c = &webClient{group: g}
+	ts, _ = requestedTracks(c, req, tracks)
+	if len(ts) != 1 {
+		t.Errorf("Expected group default, got %v", ts)
+	}
+	no := false
+	c.audioOnly = &no
+	ts, _ = requestedTracks(c, req, tracks)
+	if len(ts) != 2 {
+		t.Errorf("Expected override, got %v", ts)
+	}
+}
+
+func TestRecordingOptions(t *testing.T) {
+	tests := []struct {
+		value          any
+		format, layout string
+		ok             bool
+	}{
+		{nil, "", "", true},
+		{"mp4", "mp4", "", true},
+		{map[string]any{"layout": "tracks"}, "", "tracks", true},
+		{map[string]any{"format": "webm", "layout": "muxed"},
+			"webm", "muxed", true},
+		{map[string]any{"format": 42.0}, "", "", false},
+		{true, "", "", false},
+	}
+	for _, test := range tests {
+		format, layout, ok := recordingOptions(test.value)
+		if format != test.format || layout != test.layout ||
+			ok != test.ok {
+			t.Errorf("%v: expected %v %v %v, got %v %v %v",
+				test.value, test.format, test.layout, test.ok,
+				format, layout, ok)
+		}
+	}
+}
@@ -2,13 +2,19 @@ package rtpconn
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/sdpfrag"
+	"github.com/jech/galene/token"
 
 	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
@@ -20,17 +26,51 @@ type WhipClient struct {
 	id       string
 	token    string
 	username string
+	// the hash of the password used to authenticate, if any
+	password []byte
+	label    string
+	role     string
+	pair     whipPairKey
 
 	mu          sync.Mutex
 	permissions []string
+	limits      *token.Limits
 	connection  *rtpUpConnection
 	etag        string
+	// the local candidates already sent to the client
+	sentCandidates map[string]bool
+	sentEnd        bool
+	// whether we are waiting for the client to restart ICE
+	restartPending bool
+	// fires when the client fails to restart ICE in time
+	restartTimer *time.Timer
+
+	ceilings ceilings
 }
 
+// whipRestartTimeout is the time during which a failed WHIP connection
+// is kept alive in the hope that the client performs an ICE restart.
+const whipRestartTimeout = 15 * time.Second
+
 func NewWhipClient(g *group.Group, id string, token string, addr net.Addr) *WhipClient {
 	return &WhipClient{group: g, id: id, token: token, addr: addr}
 }
 
+// SetStream sets the label of the stream sent by c.  If role is
+// "primary" or "backup", then c is one of a pair of redundant publishers
+// for the given label.  It must be called before NewConnection.
+func (c *WhipClient) SetStream(label, role string) error {
+	switch role {
+	case "", "primary", "backup":
+	default:
+		return errors.New("unknown role " + role)
+	}
+	c.label = label
+	c.role = role
+	c.pair = whipPairKey{c.group.Name(), label}
+	return nil
+}
+
 func (c *WhipClient) Group() *group.Group {
 	return c.group
 }
@@ -47,6 +87,29 @@ func (c *WhipClient) Token() string {
 	return c.token
 }
 
+// SetPassword records the password that the client used to authenticate,
+// so that further requests may be checked with CheckPassword.  It must be
+// called before the client is added to a group.
+func (c *WhipClient) SetPassword(password string) {
+	h := sha256.Sum256([]byte(password))
+	c.password = h[:]
+}
+
+// HasPassword returns true if the client authenticated with a password.
+func (c *WhipClient) HasPassword() bool {
+	return c.password != nil
+}
+
+// CheckPassword returns true if password is the one that was passed to
+// SetPassword.
+func (c *WhipClient) CheckPassword(password string) bool {
+	if c.password == nil {
+		return false
+	}
+	h := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(c.password, h[:]) == 1
+}
+
 func (c *WhipClient) Username() string {
 	return c.username
 }
@@ -83,6 +146,75 @@ func (c *WhipClient) SetETag(etag string) {
 	c.etag = etag
 }
 
+// RequestRestart asks the client to perform an ICE restart.  Since the
+// server cannot send messages to a WHIP client, this changes the ETag of
+// the resource, which causes the client's next PATCH request to fail
+// with 412 Precondition Failed.
+func (c *WhipClient) RequestRestart() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.restartPending {
+		return
+	}
+	buf := make([]byte, 9)
+	crand.Read(buf)
+	c.etag = "\"" + base64.RawURLEncoding.EncodeToString(buf) + "\""
+	c.restartPending = true
+}
+
+// RestartPending returns true if the server is waiting for the client
+// to perform an ICE restart.
+func (c *WhipClient) RestartPending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.restartPending
+}
+
+// called locked
+func (c *WhipClient) stopRestartTimer() {
+	if c.restartTimer != nil {
+		c.restartTimer.Stop()
+		c.restartTimer = nil
+	}
+}
+
+func (c *WhipClient) iceStateChanged(conn *rtpUpConnection, state webrtc.ICEConnectionState) {
+	switch state {
+	case webrtc.ICEConnectionStateConnected:
+		if conn.recorded.CompareAndSwap(false, true) {
+			recordConnection(conn.pc, c.addr)
+		}
+		c.mu.Lock()
+		if c.connection == conn {
+			c.restartPending = false
+			c.stopRestartTimer()
+		}
+		c.mu.Unlock()
+	case webrtc.ICEConnectionStateDisconnected:
+		c.RequestRestart()
+	case webrtc.ICEConnectionStateFailed:
+		// give the client a chance to restart before tearing
+		// down the connection
+		c.RequestRestart()
+		c.mu.Lock()
+		if c.connection == conn && c.restartTimer == nil {
+			var timer *time.Timer
+			timer = time.AfterFunc(whipRestartTimeout, func() {
+				c.mu.Lock()
+				expired := c.restartTimer == timer
+				c.mu.Unlock()
+				if expired {
+					c.Close()
+				}
+			})
+			c.restartTimer = timer
+		}
+		c.mu.Unlock()
+	case webrtc.ICEConnectionStateClosed:
+		c.Close()
+	}
+}
+
 func (c *WhipClient) PushConn(g *group.Group, id string, conn conn.Up, tracks []conn.UpTrack, replace string) error {
 	return nil
 }
@@ -95,7 +227,7 @@ func (c *WhipClient) RequestConns(target group.Client, g *group.Group, id string
 	c.mu.Lock()
 	up := c.connection
 	c.mu.Unlock()
-	if up == nil {
+	if up == nil || c.isStandby() {
 		return nil
 	}
 	tracks := up.getTracks()
@@ -121,8 +253,20 @@ func (c *WhipClient) Kick(id string, user *string, message string) error {
 
 func (c *WhipClient) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	g := c.group
+	c.mu.Unlock()
+	var next *WhipClient
+	if g != nil {
+		next = unregisterPublisher(c)
+		if next != nil {
+			switchPublisher(g, c, next)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopRestartTimer()
+	g = c.group
 	if g == nil {
 		return nil
 	}
@@ -131,30 +275,53 @@ func (c *WhipClient) Close() error {
 		c.connection.pc.OnICEConnectionStateChange(nil)
 		c.connection.pc.Close()
 		c.connection = nil
-		for _, c := range g.GetClients(c) {
-			c.PushConn(g, id, nil, nil, "")
+		if next == nil {
+			for _, c := range g.GetClients(c) {
+				c.PushConn(g, id, nil, nil, "")
+			}
 		}
 		c.connection = nil
+		go updateSpeakers(g)
 	}
 	group.DelClient(c)
 	c.group = nil
 	return nil
 }
 
-func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) ([]byte, error) {
-	conn, err := newUpConn(c, c.id, "", string(offer))
+func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) (answer []byte, err error) {
+	err = checkLimits(clientLimits(c), string(offer), 0)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVideoCount(c.group, c.Permissions(), string(offer), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	displaced, err := registerPublisher(c)
+	if err != nil {
+		return nil, err
+	}
+	if displaced != nil {
+		hidePublisher(c.group, displaced)
+	}
+	// this runs after c.mu is released
+	defer func() {
+		if err != nil {
+			unregisterPublisher(c)
+		}
+	}()
+
+	conn, err := newUpConn(c, c.id, c.label, string(offer))
 	if err != nil {
 		return nil, err
 	}
 
 	conn.pc.OnICEConnectionStateChange(
 		func(state webrtc.ICEConnectionState) {
-			switch state {
-			case webrtc.ICEConnectionStateFailed,
-				webrtc.ICEConnectionStateClosed:
-				c.Close()
-			}
+			c.iceStateChanged(conn, state)
 		})
+	watchCandidatePair(conn.pc, &conn.pairs, nil)
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -165,7 +332,7 @@ func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) ([]byte, e
 	}
 	c.connection = conn
 
-	answer, err := c.gotOffer(ctx, offer)
+	answer, err = c.gotOffer(ctx, offer)
 	if err != nil {
 		conn.pc.OnICEConnectionStateChange(nil)
 		conn.pc.Close()
@@ -219,7 +386,15 @@ func (c *WhipClient) gotOffer(ctx context.Context, offer []byte) ([]byte, error)
 		return nil, err
 	}
 
-	gatherComplete := webrtc.GatheringCompletePromise(conn.pc)
+	// We don't wait for gathering to complete, since that may take
+	// a long time on hosts with many interfaces.  Instead, we reply
+	// as soon as we have a candidate, and send the remaining ones
+	// in reply to PATCH requests.
+	var once sync.Once
+	gathered := make(chan struct{})
+	conn.pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		once.Do(func() { close(gathered) })
+	})
 
 	err = conn.pc.SetLocalDescription(answer)
 	if err != nil {
@@ -231,10 +406,78 @@ func (c *WhipClient) gotOffer(ctx context.Context, offer []byte) ([]byte, error)
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
-	case <-gatherComplete:
+	case <-gathered:
+	}
+
+	c.sentCandidates = nil
+	c.sentEnd = false
+	_, _, err = c.newCandidates()
+	if err != nil {
+		return nil, err
 	}
 
-	return []byte(conn.pc.CurrentLocalDescription().SDP), nil
+	return []byte(localSDP(c, conn.pc)), nil
+}
+
+// NewCandidates returns an SDP fragment containing the local candidates
+// that have not been sent to the client yet.  The boolean is false if
+// there is nothing new.
+func (c *WhipClient) NewCandidates() (sdpfrag.SDPFrag, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.newCandidates()
+}
+
+// called locked
+func (c *WhipClient) newCandidates() (sdpfrag.SDPFrag, bool, error) {
+	if c.connection == nil {
+		return sdpfrag.SDPFrag{}, false, errors.New("no connection")
+	}
+	ld := c.connection.pc.LocalDescription()
+	if ld == nil {
+		return sdpfrag.SDPFrag{}, false, errors.New("no local description")
+	}
+	var s sdp.SessionDescription
+	err := s.Unmarshal([]byte(c.group.ICEPolicy().SDP(ld.SDP)))
+	if err != nil {
+		return sdpfrag.SDPFrag{}, false, err
+	}
+	frag := sdpfrag.FromSDP(s)
+	if len(frag.MediaDescriptions) == 0 {
+		return sdpfrag.SDPFrag{}, false, nil
+	}
+
+	// all media descriptions are bundled, only send the first one
+	m := frag.MediaDescriptions[0]
+	frag.MediaDescriptions = frag.MediaDescriptions[:1]
+	frag.Candidates = nil
+	if frag.UsernameFragment == "" {
+		frag.UsernameFragment = m.UsernameFragment
+		frag.Password = m.Password
+	}
+	m.UsernameFragment = ""
+	m.Password = ""
+
+	if c.sentCandidates == nil {
+		c.sentCandidates = make(map[string]bool)
+	}
+	cs := m.Candidates
+	m.Candidates = nil
+	for _, cand := range cs {
+		if c.sentCandidates[cand.Candidate] {
+			continue
+		}
+		c.sentCandidates[cand.Candidate] = true
+		m.Candidates = append(m.Candidates, cand)
+	}
+	if m.EndOfCandidates && c.sentEnd {
+		m.EndOfCandidates = false
+	} else if m.EndOfCandidates {
+		c.sentEnd = true
+	}
+	frag.MediaDescriptions[0] = m
+
+	return frag, len(m.Candidates) > 0 || m.EndOfCandidates, nil
 }
 
 func (c *WhipClient) GotICECandidate(init webrtc.ICECandidateInit) error {
@@ -293,9 +536,25 @@ func (c *WhipClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag
 
 	sdpAnswer2 := conn.pc.LocalDescription()
 	var answer2 sdp.SessionDescription
-	err = answer2.Unmarshal([]byte(sdpAnswer2.SDP))
+	err = answer2.Unmarshal(
+		[]byte(c.group.ICEPolicy().SDP(sdpAnswer2.SDP)),
+	)
 	if err != nil {
 		return sdpfrag.SDPFrag{}, err
 	}
+
+	// all candidates are included in the reply
+	c.mu.Lock()
+	if c.connection == conn {
+		c.sentCandidates = nil
+		c.sentEnd = false
+		c.newCandidates()
+		c.restartPending = false
+		// the state will go through checking again, which
+		// rearms the timer if the restart fails
+		c.stopRestartTimer()
+	}
+	c.mu.Unlock()
+
 	return sdpfrag.FromSDP(answer2), nil
 }
@@ -0,0 +1,244 @@
+package rtpconn
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+// A pair of WHIP publishers may send the same stream in primary/backup
+// mode.  Only the active publisher is forwarded; if it stops sending for
+// longer than the gap threshold, the server switches to the other one.
+
+// the default value of the whip-failover-gap group option
+const defaultFailoverGap = time.Second
+
+var ErrDuplicatePublisher = errors.New("duplicate publisher")
+
+type whipPairKey struct {
+	group string
+	label string
+}
+
+type whipPair struct {
+	primary *WhipClient
+	backup  *WhipClient
+	active  *WhipClient
+	done    chan struct{}
+}
+
+var whipPairsMu sync.Mutex
+var whipPairs = make(map[whipPairKey]*whipPair)
+
+func (p *whipPair) other(c *WhipClient) *WhipClient {
+	if c == p.primary {
+		return p.backup
+	}
+	return p.primary
+}
+
+func failoverGap(g *group.Group) time.Duration {
+	gap := g.Description().WhipFailoverGap
+	if gap > 0 {
+		return time.Duration(gap) * time.Millisecond
+	}
+	return defaultFailoverGap
+}
+
+// upConnection returns the up connection of a WHIP client, or nil.
+func (c *WhipClient) upConnection() *rtpUpConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connection
+}
+
+// isStandby returns true if c is a backup publisher that is not currently
+// being forwarded.
+func (c *WhipClient) isStandby() bool {
+	if c.role == "" {
+		return false
+	}
+	whipPairsMu.Lock()
+	defer whipPairsMu.Unlock()
+	p := whipPairs[c.pair]
+	return p != nil && p.active != c
+}
+
+// registerPublisher adds a redundant publisher to its pair.  It is called
+// before the client's connection is created.  If another publisher was
+// active, but has not sent any media yet, then c becomes active and the
+// other publisher is returned.
+func registerPublisher(c *WhipClient) (*WhipClient, error) {
+	if c.role == "" {
+		return nil, nil
+	}
+
+	whipPairsMu.Lock()
+	defer whipPairsMu.Unlock()
+
+	key := c.pair
+	p := whipPairs[key]
+	if p == nil {
+		p = &whipPair{done: make(chan struct{})}
+		whipPairs[key] = p
+		go monitorPair(c.group, p)
+	}
+
+	if c.role == "backup" {
+		if p.backup != nil {
+			return nil, ErrDuplicatePublisher
+		}
+		p.backup = c
+	} else {
+		if p.primary != nil {
+			return nil, ErrDuplicatePublisher
+		}
+		p.primary = c
+	}
+
+	// the first publisher to arrive is forwarded, unless it is replaced
+	// by the primary before any media have been received.
+	if p.active == nil {
+		p.active = c
+		return nil, nil
+	}
+	if c == p.primary && !receiving(p.active, 0) {
+		displaced := p.active
+		p.active = c
+		return displaced, nil
+	}
+	return nil, nil
+}
+
+// unregisterPublisher removes a publisher from its pair.  If it was
+// active, it returns the client that replaces it.
+func unregisterPublisher(c *WhipClient) *WhipClient {
+	if c.role == "" {
+		return nil
+	}
+
+	whipPairsMu.Lock()
+	defer whipPairsMu.Unlock()
+
+	key := c.pair
+	p := whipPairs[key]
+	if p == nil || (p.primary != c && p.backup != c) {
+		return nil
+	}
+
+	other := p.other(c)
+	if p.primary == c {
+		p.primary = nil
+	} else {
+		p.backup = nil
+	}
+
+	if other == nil {
+		close(p.done)
+		delete(whipPairs, key)
+		return nil
+	}
+
+	if p.active != c {
+		return nil
+	}
+	p.active = other
+	return other
+}
+
+// receiving returns true if c has received a packet within the last gap.
+// If gap is 0, it returns true if c has received any packets.
+func receiving(c *WhipClient, gap time.Duration) bool {
+	up := c.upConnection()
+	if up == nil {
+		return false
+	}
+	last := atomic.LoadUint64(&up.lastPacket)
+	if last == 0 {
+		return false
+	}
+	if gap == 0 {
+		return true
+	}
+	return rtptime.Jiffies()-last <= uint64(rtptime.FromDuration(
+		gap, rtptime.JiffiesPerSec,
+	))
+}
+
+// monitorPair switches between the publishers of a pair when the active
+// one stops sending.
+func monitorPair(g *group.Group, p *whipPair) {
+	gap := failoverGap(g)
+	interval := gap / 4
+	if interval < 50*time.Millisecond {
+		interval = 50 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		whipPairsMu.Lock()
+		old := p.active
+		var next *WhipClient
+		if old != nil && !receiving(old, gap) {
+			other := p.other(old)
+			if other != nil && receiving(other, gap) {
+				next = other
+				p.active = next
+			}
+		}
+		whipPairsMu.Unlock()
+
+		if next != nil {
+			switchPublisher(g, old, next)
+		}
+	}
+}
+
+// hidePublisher removes the stream of c from all clients of the group.
+func hidePublisher(g *group.Group, c *WhipClient) {
+	up := c.upConnection()
+	if up == nil {
+		return
+	}
+	for _, cc := range g.GetClients(c) {
+		cc.PushConn(g, up.Id(), nil, nil, "")
+	}
+}
+
+// switchPublisher replaces the stream of old with that of next in all
+// clients of the group.
+func switchPublisher(g *group.Group, old, next *WhipClient) {
+	up := next.upConnection()
+	if up == nil {
+		return
+	}
+	replace := ""
+	if old != nil {
+		if o := old.upConnection(); o != nil {
+			replace = o.Id()
+		}
+	}
+	tracks := up.getTracks()
+	ts := make([]conn.UpTrack, len(tracks))
+	for i, t := range tracks {
+		ts[i] = t
+	}
+	for _, c := range g.GetClients(next) {
+		if c == old {
+			continue
+		}
+		c.PushConn(g, up.Id(), up, ts, replace)
+	}
+}
@@ -0,0 +1,85 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestWhipPair(t *testing.T) {
+	g, err := group.Add("whip-pair-test", &group.Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	c1 := NewWhipClient(g, "1", "", nil)
+	c2 := NewWhipClient(g, "2", "", nil)
+	c3 := NewWhipClient(g, "3", "", nil)
+	if err := c1.SetStream("main", "backup"); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+	if err := c2.SetStream("main", "primary"); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+	if err := c3.SetStream("main", "primary"); err != nil {
+		t.Fatalf("SetStream: %v", err)
+	}
+	if err := c3.SetStream("main", "bad"); err == nil {
+		t.Errorf("SetStream succeeded with a bad role")
+	}
+
+	displaced, err := registerPublisher(c1)
+	if err != nil || displaced != nil {
+		t.Fatalf("register backup: %v %v", displaced, err)
+	}
+	if c1.isStandby() {
+		t.Errorf("First publisher is standby")
+	}
+
+	// the backup has not sent any media, so the primary takes over
+	displaced, err = registerPublisher(c2)
+	if err != nil || displaced != c1 {
+		t.Fatalf("register primary: %v %v", displaced, err)
+	}
+	if c2.isStandby() || !c1.isStandby() {
+		t.Errorf("Primary didn't take over")
+	}
+
+	_, err = registerPublisher(c3)
+	if err != ErrDuplicatePublisher {
+		t.Errorf("Duplicate primary: %v", err)
+	}
+
+	next := unregisterPublisher(c2)
+	if next != c1 {
+		t.Errorf("Expected failover to backup, got %v", next)
+	}
+	if c1.isStandby() {
+		t.Errorf("Backup is standby after failover")
+	}
+
+	next = unregisterPublisher(c1)
+	if next != nil {
+		t.Errorf("Expected nil, got %v", next)
+	}
+	whipPairsMu.Lock()
+	n := len(whipPairs)
+	whipPairsMu.Unlock()
+	if n != 0 {
+		t.Errorf("Pair was not removed")
+	}
+}
+
+func TestWhipPassword(t *testing.T) {
+	c := NewWhipClient(nil, "1", "", nil)
+	if c.HasPassword() || c.CheckPassword("") {
+		t.Errorf("Client without password")
+	}
+	c.SetPassword("secret")
+	if !c.HasPassword() {
+		t.Errorf("HasPassword returned false")
+	}
+	if !c.CheckPassword("secret") || c.CheckPassword("wrong") {
+		t.Errorf("CheckPassword failed")
+	}
+}
@@ -0,0 +1,46 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestWhipRequestRestart(t *testing.T) {
+	c := NewWhipClient(nil, "whip-restart-test", "", nil)
+	c.SetETag("\"initial\"")
+
+	if c.RestartPending() {
+		t.Errorf("restart pending initially")
+	}
+
+	c.iceStateChanged(nil, webrtc.ICEConnectionStateDisconnected)
+	if !c.RestartPending() {
+		t.Errorf("restart not pending after disconnection")
+	}
+	etag := c.ETag()
+	if etag == "\"initial\"" || etag == "" {
+		t.Errorf("ETag not changed: %v", etag)
+	}
+
+	c.RequestRestart()
+	if c.ETag() != etag {
+		t.Errorf("ETag changed twice: %v %v", etag, c.ETag())
+	}
+
+	c.iceStateChanged(nil, webrtc.ICEConnectionStateFailed)
+	c.mu.Lock()
+	armed := c.restartTimer != nil
+	c.mu.Unlock()
+	if !armed {
+		t.Errorf("restart timer not armed after failure")
+	}
+
+	c.Close()
+	c.mu.Lock()
+	armed = c.restartTimer != nil
+	c.mu.Unlock()
+	if armed {
+		t.Errorf("restart timer still armed after close")
+	}
+}
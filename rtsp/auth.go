@@ -0,0 +1,144 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cameras authenticate clients with either Basic or Digest authentication
+// (RFC 2617); the latter is more common, since RTSP is usually carried in
+// cleartext.  Only the MD5 algorithm is supported, since that's what
+// cameras implement.
+
+type authenticator struct {
+	username, password string
+
+	// whether we have received a challenge
+	active bool
+	digest bool
+	realm  string
+	nonce  string
+	opaque string
+	qop    string
+	nc     int
+}
+
+var ErrUnsupportedAuth = errors.New("unsupported authentication scheme")
+
+// authParams parses the parameters of a WWW-Authenticate header field.
+func authParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		k, rest, found := strings.Cut(s, "=")
+		if !found {
+			break
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		rest = strings.TrimLeft(rest, " ")
+		var v string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				v, s = rest[1:], ""
+			} else {
+				v, s = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			v, s, _ = strings.Cut(rest, ",")
+			v = strings.TrimSpace(v)
+		}
+		params[k] = v
+	}
+	return params
+}
+
+// challenge updates the authenticator from the WWW-Authenticate header
+// fields of a 401 response.  Digest is preferred over Basic.
+func (a *authenticator) challenge(values []string) error {
+	basic := false
+	for _, v := range values {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(v), " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			p := authParams(rest)
+			algorithm := p["algorithm"]
+			if algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+				continue
+			}
+			a.digest = true
+			a.realm = p["realm"]
+			a.nonce = p["nonce"]
+			a.opaque = p["opaque"]
+			a.qop = ""
+			for _, q := range strings.Split(p["qop"], ",") {
+				if strings.TrimSpace(q) == "auth" {
+					a.qop = "auth"
+				}
+			}
+			a.nc = 0
+			a.active = true
+			return nil
+		case "basic":
+			basic = true
+		}
+	}
+	if basic {
+		a.digest = false
+		a.active = true
+		return nil
+	}
+	return ErrUnsupportedAuth
+}
+
+func md5hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// authorization returns the value of the Authorization header field for
+// a request.
+func (a *authenticator) authorization(method, uri string) string {
+	if !a.digest {
+		return "Basic " + base64.StdEncoding.EncodeToString(
+			[]byte(a.username+":"+a.password),
+		)
+	}
+	var c [8]byte
+	crand.Read(c[:])
+	return a.digestAuthorization(method, uri, hex.EncodeToString(c[:]))
+}
+
+// digestAuthorization computes digest credentials with the given client
+// nonce, which is only used if the server requested a qop.
+func (a *authenticator) digestAuthorization(method, uri, cnonce string) string {
+	ha1 := md5hex(a.username + ":" + a.realm + ":" + a.password)
+	ha2 := md5hex(method + ":" + uri)
+	var b strings.Builder
+	fmt.Fprintf(&b,
+		`Digest username="%v", realm="%v", nonce="%v", uri="%v"`,
+		a.username, a.realm, a.nonce, uri,
+	)
+	if a.qop != "" {
+		a.nc++
+		nc := fmt.Sprintf("%08x", a.nc)
+		response := md5hex(
+			ha1 + ":" + a.nonce + ":" + nc + ":" + cnonce + ":" +
+				a.qop + ":" + ha2,
+		)
+		fmt.Fprintf(&b, `, qop=%v, nc=%v, cnonce="%v", response="%v"`,
+			a.qop, nc, cnonce, response)
+	} else {
+		response := md5hex(ha1 + ":" + a.nonce + ":" + ha2)
+		fmt.Fprintf(&b, `, response="%v"`, response)
+	}
+	if a.opaque != "" {
+		fmt.Fprintf(&b, `, opaque="%v"`, a.opaque)
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package rtsp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuthParams(t *testing.T) {
+	p := authParams(`realm="testrealm@host.com", qop="auth,auth-int", ` +
+		`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093",stale=FALSE`)
+	expected := map[string]string{
+		"realm": "testrealm@host.com",
+		"qop":   "auth,auth-int",
+		"nonce": "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+		"stale": "FALSE",
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("Expected %v, got %v", expected, p)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	// RFC 2617 Section 3.5
+	a := &authenticator{username: "Mufasa", password: "Circle Of Life"}
+	err := a.challenge([]string{
+		`Basic realm="testrealm@host.com"`,
+		`Digest realm="testrealm@host.com", qop="auth,auth-int", ` +
+			`nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", ` +
+			`opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+	})
+	if err != nil {
+		t.Fatalf("challenge: %v", err)
+	}
+	if !a.active || !a.digest || a.qop != "auth" {
+		t.Fatalf("Got %v %v %v", a.active, a.digest, a.qop)
+	}
+	auth := a.digestAuthorization("GET", "/dir/index.html", "0a4f113b")
+	for _, s := range []string{
+		`Digest username="Mufasa"`,
+		`nc=00000001`,
+		`response="6629fae49393a05397450978507c4ef1"`,
+		`opaque="5ccc069c403ebaf9f0171e9517f40e41"`,
+	} {
+		if !strings.Contains(auth, s) {
+			t.Errorf("%v not in %v", s, auth)
+		}
+	}
+	auth = a.digestAuthorization("GET", "/dir/index.html", "0a4f113b")
+	if !strings.Contains(auth, "nc=00000002") {
+		t.Errorf("Nonce count not incremented: %v", auth)
+	}
+}
+
+func TestBasic(t *testing.T) {
+	a := &authenticator{username: "Aladdin", password: "open sesame"}
+	err := a.challenge([]string{`Basic realm="camera"`})
+	if err != nil {
+		t.Fatalf("challenge: %v", err)
+	}
+	auth := a.authorization("DESCRIBE", "rtsp://192.0.2.1/")
+	if auth != "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ==" {
+		t.Errorf("Got %v", auth)
+	}
+
+	err = a.challenge([]string{`Digest realm="camera", algorithm=SHA-256`})
+	if err == nil {
+		t.Errorf("Unsupported algorithm accepted")
+	}
+}
@@ -0,0 +1,152 @@
+package rtsp
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+// the maximum size of the payload of the packets that we send
+const h264MTU = 1200
+
+// An h264Packetizer depacketizes the H.264 stream received from a camera
+// and packetizes it again.  This is necessary because cameras may send
+// packets that are too large for the network when RTP is carried over TCP,
+// and because they often only send the parameter sets in the session
+// description, while a browser needs them before every IDR picture.
+type h264Packetizer struct {
+	sps, pps []byte
+	// whether the parameter sets were sent for the current timestamp
+	paramsSent bool
+	timestamp  uint32
+
+	started bool
+	seqno   uint16
+	// the NAL unit being reassembled from FU-A packets, or nil
+	fu []byte
+
+	payloader codecs.H264Payloader
+	outSeqno  uint16
+}
+
+// newH264Packetizer creates a packetizer, using the parameter sets in the
+// fmtp attribute if any.
+func newH264Packetizer(fmtp string) *h264Packetizer {
+	h := &h264Packetizer{}
+	sets := fmtpParam(fmtp, "sprop-parameter-sets")
+	for _, s := range strings.Split(sets, ",") {
+		nal, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil || len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1F {
+		case 7:
+			h.sps = nal
+		case 8:
+			h.pps = nal
+		}
+	}
+	return h
+}
+
+// nals returns the complete NAL units carried by a packet.
+func (h *h264Packetizer) nals(p *rtp.Packet) [][]byte {
+	if h.started && p.SequenceNumber != h.seqno+1 {
+		// a packet was lost, drop any partial NAL unit
+		h.fu = nil
+	}
+	h.started = true
+	h.seqno = p.SequenceNumber
+
+	payload := p.Payload
+	if len(payload) == 0 {
+		return nil
+	}
+	switch payload[0] & 0x1F {
+	case 24: // STAP-A
+		var nals [][]byte
+		data := payload[1:]
+		for len(data) >= 2 {
+			size := int(binary.BigEndian.Uint16(data))
+			if size == 0 || len(data) < 2+size {
+				break
+			}
+			nals = append(nals, data[2:2+size])
+			data = data[2+size:]
+		}
+		return nals
+	case 28: // FU-A
+		if len(payload) < 2 {
+			return nil
+		}
+		if payload[1]&0x80 != 0 {
+			h.fu = []byte{payload[0]&0xE0 | payload[1]&0x1F}
+		} else if h.fu == nil {
+			return nil
+		}
+		h.fu = append(h.fu, payload[2:]...)
+		if payload[1]&0x40 == 0 {
+			return nil
+		}
+		nal := h.fu
+		h.fu = nil
+		return [][]byte{nal}
+	case 0, 25, 26, 27, 29, 30, 31:
+		// STAP-B, MTAP and FU-B are only used in interleaved mode
+		return nil
+	default:
+		return [][]byte{payload}
+	}
+}
+
+// packet depacketizes a packet received from the camera, and returns the
+// packets that should be sent instead.
+func (h *h264Packetizer) packet(p *rtp.Packet) []*rtp.Packet {
+	if p.Timestamp != h.timestamp {
+		h.timestamp = p.Timestamp
+		h.paramsSent = false
+	}
+
+	var payloads [][]byte
+	for _, nal := range h.nals(p) {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nal[0] & 0x1F {
+		case 7:
+			h.sps = append([]byte(nil), nal...)
+			h.paramsSent = true
+		case 8:
+			h.pps = append([]byte(nil), nal...)
+		case 5:
+			if !h.paramsSent && h.sps != nil && h.pps != nil {
+				payloads = append(payloads,
+					h.payloader.Payload(h264MTU, h.sps)...)
+				payloads = append(payloads,
+					h.payloader.Payload(h264MTU, h.pps)...)
+				h.paramsSent = true
+			}
+		}
+		payloads = append(payloads, h.payloader.Payload(h264MTU, nal)...)
+	}
+
+	packets := make([]*rtp.Packet, len(payloads))
+	for i, payload := range payloads {
+		h.outSeqno++
+		packets[i] = &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         p.Marker && i == len(payloads)-1,
+				PayloadType:    p.PayloadType,
+				SequenceNumber: h.outSeqno,
+				Timestamp:      p.Timestamp,
+				SSRC:           p.SSRC,
+			},
+			Payload: payload,
+		}
+	}
+	return packets
+}
@@ -0,0 +1,122 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+func h264Packet(seqno uint16, ts uint32, marker bool, payload []byte) *rtp.Packet {
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			Marker:         marker,
+			PayloadType:    96,
+			SequenceNumber: seqno,
+			Timestamp:      ts,
+			SSRC:           42,
+		},
+		Payload: payload,
+	}
+}
+
+func TestH264ParameterSets(t *testing.T) {
+	h := newH264Packetizer(
+		"packetization-mode=1;sprop-parameter-sets=Z0IAH5WoFAFuQA==,aM48gA==",
+	)
+	if len(h.sps) == 0 || h.sps[0]&0x1F != 7 ||
+		len(h.pps) == 0 || h.pps[0]&0x1F != 8 {
+		t.Fatalf("Bad parameter sets %v %v", h.sps, h.pps)
+	}
+
+	// a non-IDR picture is forwarded as is
+	packets := h.packet(h264Packet(1, 1000, true, []byte{0x41, 1, 2, 3}))
+	if len(packets) != 1 ||
+		!bytes.Equal(packets[0].Payload, []byte{0x41, 1, 2, 3}) ||
+		!packets[0].Marker || packets[0].Timestamp != 1000 {
+		t.Errorf("Got %v", packets)
+	}
+
+	// an IDR picture is preceded by the parameter sets
+	packets = h.packet(h264Packet(2, 4000, true, []byte{0x65, 4, 5, 6}))
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, got %v", len(packets))
+	}
+	if packets[0].Payload[0]&0x1F != 24 || packets[0].Marker {
+		t.Errorf("Expected STAP-A, got %v", packets[0])
+	}
+	if !bytes.Equal(packets[1].Payload, []byte{0x65, 4, 5, 6}) ||
+		!packets[1].Marker {
+		t.Errorf("Got %v", packets[1])
+	}
+	if packets[1].SequenceNumber != packets[0].SequenceNumber+1 {
+		t.Errorf("Bad seqnos %v %v",
+			packets[0].SequenceNumber, packets[1].SequenceNumber)
+	}
+
+	// but not if the camera sent them in-band
+	sps := append([]byte{0, byte(len(h.sps) >> 8), byte(len(h.sps))},
+		h.sps...)
+	sps[0] = 24
+	packets = h.packet(h264Packet(3, 7000, false, sps))
+	packets = append(packets,
+		h.packet(h264Packet(4, 7000, false, []byte{0x68, 0xce, 0x3c}))...)
+	packets = append(packets,
+		h.packet(h264Packet(5, 7000, true, []byte{0x65, 7}))...)
+	if len(packets) != 2 || packets[0].Payload[0]&0x1F != 24 {
+		t.Errorf("Got %v", packets)
+	}
+}
+
+func TestH264Fragmentation(t *testing.T) {
+	h := newH264Packetizer("")
+
+	nal := make([]byte, 3000)
+	nal[0] = 0x65
+	for i := 1; i < len(nal); i++ {
+		nal[i] = byte(i)
+	}
+
+	// a large NAL unit in a single packet is fragmented
+	packets := h.packet(h264Packet(1, 1000, true, nal))
+	if len(packets) < 3 {
+		t.Fatalf("Expected fragmentation, got %v packets", len(packets))
+	}
+	var nal2 []byte
+	for i, p := range packets {
+		if len(p.Payload) > h264MTU {
+			t.Errorf("Packet too large: %v", len(p.Payload))
+		}
+		if p.Payload[0]&0x1F != 28 {
+			t.Fatalf("Expected FU-A, got %v", p.Payload[0])
+		}
+		if i == 0 {
+			nal2 = []byte{p.Payload[0]&0xE0 | p.Payload[1]&0x1F}
+		}
+		nal2 = append(nal2, p.Payload[2:]...)
+		if p.Marker != (i == len(packets)-1) {
+			t.Errorf("Bad marker at %v", i)
+		}
+	}
+	if !bytes.Equal(nal, nal2) {
+		t.Errorf("NAL unit not preserved")
+	}
+
+	// FU-A packets are reassembled
+	packets = h.packet(h264Packet(2, 4000, false, []byte{0x7C, 0x81, 1, 2}))
+	packets = append(packets,
+		h.packet(h264Packet(3, 4000, true, []byte{0x7C, 0x41, 3}))...)
+	if len(packets) != 1 ||
+		!bytes.Equal(packets[0].Payload, []byte{0x61, 1, 2, 3}) {
+		t.Errorf("Got %v", packets)
+	}
+
+	// a NAL unit with a missing fragment is dropped
+	packets = h.packet(h264Packet(4, 7000, false, []byte{0x7C, 0x81, 1, 2}))
+	packets = append(packets,
+		h.packet(h264Packet(6, 7000, true, []byte{0x7C, 0x41, 3}))...)
+	if len(packets) != 0 {
+		t.Errorf("Got %v", packets)
+	}
+}
@@ -0,0 +1,118 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// the maximum size of the body of a response
+const maxBodySize = 64 * 1024
+
+// Response is an RTSP response.
+type Response struct {
+	StatusCode int
+	Reason     string
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+var ErrMalformed = errors.New("malformed RTSP message")
+
+// readResponse reads a response from r.
+func readResponse(r *bufio.Reader) (*Response, error) {
+	tr := textproto.NewReader(r)
+	line, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	proto, status, found := strings.Cut(line, " ")
+	if !found || !strings.HasPrefix(proto, "RTSP/") {
+		return nil, ErrMalformed
+	}
+	code, reason, _ := strings.Cut(status, " ")
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || statusCode < 100 || statusCode > 999 {
+		return nil, ErrMalformed
+	}
+
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{
+		StatusCode: statusCode,
+		Reason:     reason,
+		Header:     header,
+	}
+
+	if l := header.Get("Content-Length"); l != "" {
+		length, err := strconv.Atoi(l)
+		if err != nil || length < 0 || length > maxBodySize {
+			return nil, ErrMalformed
+		}
+		resp.Body = make([]byte, length)
+		_, err = io.ReadFull(r, resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// writeRequest writes a request without a body to w.
+func writeRequest(w io.Writer, method, uri string, cseq int, header textproto.MIMEHeader) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "CSeq: %v\r\n", cseq)
+	for k, vs := range header {
+		for _, v := range vs {
+			fmt.Fprintf(&b, "%v: %v\r\n", k, v)
+		}
+	}
+	b.WriteString("\r\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sessionHeader parses the value of a Session header field, and returns
+// the session identifier and the session timeout in seconds, or 0 if
+// unspecified.
+func sessionHeader(value string) (string, int) {
+	parts := strings.Split(value, ";")
+	id := strings.TrimSpace(parts[0])
+	timeout := 0
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(strings.TrimSpace(k), "timeout") {
+			t, err := strconv.Atoi(strings.TrimSpace(v))
+			if err == nil && t > 0 {
+				timeout = t
+			}
+		}
+	}
+	return id, timeout
+}
+
+// interleavedChannel returns the first channel of the interleaved
+// parameter of a Transport header field.
+func interleavedChannel(transport string) (uint8, bool) {
+	for _, p := range strings.Split(transport, ";") {
+		k, v, _ := strings.Cut(p, "=")
+		if !strings.EqualFold(strings.TrimSpace(k), "interleaved") {
+			continue
+		}
+		first, _, _ := strings.Cut(strings.TrimSpace(v), "-")
+		c, err := strconv.ParseUint(first, 10, 8)
+		if err != nil {
+			return 0, false
+		}
+		return uint8(c), true
+	}
+	return 0, false
+}
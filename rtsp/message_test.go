@@ -0,0 +1,116 @@
+package rtsp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadResponse(t *testing.T) {
+	data := "RTSP/1.0 200 OK\r\n" +
+		"CSeq: 2\r\n" +
+		"Content-Base: rtsp://192.0.2.1/stream/\r\n" +
+		"Content-Type: application/sdp\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"v=0\r\n" +
+		"RTSP/1.0 454 Session Not Found\r\n" +
+		"CSeq: 3\r\n" +
+		"\r\n"
+	r := bufio.NewReader(strings.NewReader(data))
+	resp, err := readResponse(r)
+	if err != nil {
+		t.Fatalf("readResponse: %v", err)
+	}
+	if resp.StatusCode != 200 || resp.Reason != "OK" {
+		t.Errorf("Got %v %v", resp.StatusCode, resp.Reason)
+	}
+	if resp.Header.Get("cseq") != "2" ||
+		resp.Header.Get("Content-Base") != "rtsp://192.0.2.1/stream/" {
+		t.Errorf("Got header %v", resp.Header)
+	}
+	if string(resp.Body) != "v=0\r\n" {
+		t.Errorf("Got body %q", resp.Body)
+	}
+
+	resp, err = readResponse(r)
+	if err != nil {
+		t.Fatalf("readResponse: %v", err)
+	}
+	if resp.StatusCode != 454 || resp.Reason != "Session Not Found" ||
+		resp.Body != nil {
+		t.Errorf("Got %v %v %q", resp.StatusCode, resp.Reason, resp.Body)
+	}
+}
+
+func TestReadResponseMalformed(t *testing.T) {
+	tests := []string{
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"RTSP/1.0 OK\r\n\r\n",
+		"RTSP/1.0 42 Too Small\r\n\r\n",
+		"RTSP/1.0 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"RTSP/1.0 200 OK\r\nContent-Length: 10000000\r\n\r\n",
+	}
+	for _, data := range tests {
+		r := bufio.NewReader(strings.NewReader(data))
+		_, err := readResponse(r)
+		if err == nil {
+			t.Errorf("%q accepted", data)
+		}
+	}
+}
+
+func TestWriteRequest(t *testing.T) {
+	var b strings.Builder
+	err := writeRequest(&b, "PLAY", "rtsp://192.0.2.1/stream", 4,
+		map[string][]string{"Session": {"12345678"}},
+	)
+	if err != nil {
+		t.Fatalf("writeRequest: %v", err)
+	}
+	expected := "PLAY rtsp://192.0.2.1/stream RTSP/1.0\r\n" +
+		"CSeq: 4\r\n" +
+		"Session: 12345678\r\n" +
+		"\r\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestSessionHeader(t *testing.T) {
+	tests := []struct {
+		value   string
+		id      string
+		timeout int
+	}{
+		{"12345678", "12345678", 0},
+		{"12345678;timeout=30", "12345678", 30},
+		{"ABCDEF; Timeout = 120", "ABCDEF", 120},
+		{"ABCDEF;timeout=junk", "ABCDEF", 0},
+	}
+	for _, test := range tests {
+		id, timeout := sessionHeader(test.value)
+		if id != test.id || timeout != test.timeout {
+			t.Errorf("%q: got %v %v", test.value, id, timeout)
+		}
+	}
+}
+
+func TestInterleavedChannel(t *testing.T) {
+	tests := []struct {
+		value   string
+		channel uint8
+		ok      bool
+	}{
+		{"RTP/AVP/TCP;unicast;interleaved=2-3", 2, true},
+		{"RTP/AVP/TCP;unicast;interleaved=4;ssrc=1234", 4, true},
+		{"RTP/AVP;unicast;client_port=5000-5001", 0, false},
+		{"RTP/AVP/TCP;interleaved=300-301", 0, false},
+	}
+	for _, test := range tests {
+		channel, ok := interleavedChannel(test.value)
+		if channel != test.channel || ok != test.ok {
+			t.Errorf("%q: got %v %v", test.value, channel, ok)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package rtsp
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+)
+
+// Media is a media stream described in the response to DESCRIBE.
+type Media struct {
+	// "audio" or "video"
+	Kind string
+	// the MIME type, such as "video/H264"
+	MimeType    string
+	ClockRate   uint32
+	Channels    uint16
+	PayloadType uint8
+	Fmtp        string
+
+	// the URL used to set up the stream
+	control string
+	// the interleaved channel that carries RTP
+	channel uint8
+}
+
+// controlURL resolves the control attribute of a media description.
+// Relative controls are relative to the base URL, even if it doesn't end
+// in a slash, which is what cameras expect.
+func controlURL(base *url.URL, control string) string {
+	if control == "" || control == "*" {
+		return base.String()
+	}
+	lc := strings.ToLower(control)
+	if strings.HasPrefix(lc, "rtsp://") || strings.HasPrefix(lc, "rtsps://") {
+		return control
+	}
+	if strings.HasPrefix(control, "/") {
+		u := *base
+		u.Path = ""
+		u.RawPath = ""
+		u.RawQuery = ""
+		return u.String() + control
+	}
+	b := base.String()
+	if !strings.HasSuffix(b, "/") {
+		b = b + "/"
+	}
+	return b + control
+}
+
+// staticPayloadTypes are the static payload types of RFC 3551 that may be
+// used without an rtpmap attribute.
+var staticPayloadTypes = map[uint8]Media{
+	0: {MimeType: "audio/PCMU", ClockRate: 8000, Channels: 1},
+	8: {MimeType: "audio/PCMA", ClockRate: 8000, Channels: 1},
+}
+
+// parseMedia parses a session description, and returns the media streams
+// that it describes.  Only the first format of every stream is
+// considered.
+func parseMedia(body []byte, base *url.URL) ([]*Media, error) {
+	var desc sdp.SessionDescription
+	err := desc.Unmarshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c, ok := desc.Attribute("control"); ok && c != "*" {
+		u, err := url.Parse(controlURL(base, c))
+		if err == nil {
+			base = u
+		}
+	}
+
+	var media []*Media
+	for _, md := range desc.MediaDescriptions {
+		kind := md.MediaName.Media
+		if (kind != "audio" && kind != "video") ||
+			len(md.MediaName.Formats) == 0 {
+			continue
+		}
+		pt, err := strconv.ParseUint(md.MediaName.Formats[0], 10, 7)
+		if err != nil {
+			continue
+		}
+		m := &Media{Kind: kind, PayloadType: uint8(pt)}
+		if s, ok := staticPayloadTypes[m.PayloadType]; ok {
+			m.MimeType = s.MimeType
+			m.ClockRate = s.ClockRate
+			m.Channels = s.Channels
+		}
+		for _, a := range md.Attributes {
+			switch a.Key {
+			case "rtpmap":
+				p, rest, _ := strings.Cut(a.Value, " ")
+				if p != md.MediaName.Formats[0] {
+					continue
+				}
+				fields := strings.Split(rest, "/")
+				m.MimeType = kind + "/" + fields[0]
+				m.ClockRate = 0
+				m.Channels = 0
+				if len(fields) > 1 {
+					r, err := strconv.ParseUint(fields[1], 10, 32)
+					if err == nil {
+						m.ClockRate = uint32(r)
+					}
+				}
+				if len(fields) > 2 {
+					c, err := strconv.ParseUint(fields[2], 10, 16)
+					if err == nil {
+						m.Channels = uint16(c)
+					}
+				}
+			case "fmtp":
+				p, rest, _ := strings.Cut(a.Value, " ")
+				if p == md.MediaName.Formats[0] {
+					m.Fmtp = strings.TrimSpace(rest)
+				}
+			case "control":
+				m.control = a.Value
+			}
+		}
+		m.control = controlURL(base, m.control)
+		media = append(media, m)
+	}
+	return media, nil
+}
+
+// fmtpParam returns the value of a parameter of an fmtp attribute.
+func fmtpParam(fmtp, name string) string {
+	for _, p := range strings.Split(fmtp, ";") {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
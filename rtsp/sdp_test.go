@@ -0,0 +1,111 @@
+package rtsp
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var cameraSDP = "v=0\r\n" +
+	"o=- 1 1 IN IP4 192.0.2.1\r\n" +
+	"s=Camera\r\n" +
+	"t=0 0\r\n" +
+	"a=control:*\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;profile-level-id=640028;" +
+	"sprop-parameter-sets=Z2QAKKzZQHgCJ+WEAAADAAQAAAMA8Dxgxlg=,aOvjyyLA\r\n" +
+	"a=control:trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 0\r\n" +
+	"a=control:trackID=2\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 opus/48000/2\r\n" +
+	"a=control:rtsp://192.0.2.1/other/trackID=3\r\n" +
+	"m=application 0 RTP/AVP 107\r\n" +
+	"a=rtpmap:107 vnd.onvif.metadata/90000\r\n"
+
+func TestParseMedia(t *testing.T) {
+	base, _ := url.Parse("rtsp://192.0.2.1/stream")
+	media, err := parseMedia([]byte(cameraSDP), base)
+	if err != nil {
+		t.Fatalf("parseMedia: %v", err)
+	}
+	if len(media) != 3 {
+		t.Fatalf("Expected 3 streams, got %v", len(media))
+	}
+
+	m := media[0]
+	if m.Kind != "video" || m.MimeType != "video/H264" ||
+		m.ClockRate != 90000 || m.PayloadType != 96 ||
+		m.control != "rtsp://192.0.2.1/stream/trackID=1" {
+		t.Errorf("Got %#v", m)
+	}
+	if fmtpParam(m.Fmtp, "profile-level-id") != "640028" {
+		t.Errorf("Got fmtp %v", m.Fmtp)
+	}
+
+	m = media[1]
+	if m.MimeType != "audio/PCMU" || m.ClockRate != 8000 ||
+		m.Channels != 1 || m.PayloadType != 0 {
+		t.Errorf("Got %#v", m)
+	}
+
+	m = media[2]
+	if m.MimeType != "audio/opus" || m.ClockRate != 48000 ||
+		m.Channels != 2 ||
+		m.control != "rtsp://192.0.2.1/other/trackID=3" {
+		t.Errorf("Got %#v", m)
+	}
+}
+
+func TestControlURL(t *testing.T) {
+	base, _ := url.Parse("rtsp://192.0.2.1:8554/live/stream?profile=1")
+	tests := []struct{ control, url string }{
+		{"", "rtsp://192.0.2.1:8554/live/stream?profile=1"},
+		{"*", "rtsp://192.0.2.1:8554/live/stream?profile=1"},
+		{"track1", "rtsp://192.0.2.1:8554/live/stream?profile=1/track1"},
+		{"/track1", "rtsp://192.0.2.1:8554/track1"},
+		{"RTSP://192.0.2.2/x", "RTSP://192.0.2.2/x"},
+	}
+	for _, test := range tests {
+		u := controlURL(base, test.control)
+		if u != test.url {
+			t.Errorf("%q: expected %v, got %v", test.control, test.url, u)
+		}
+	}
+}
+
+func TestSelectMedia(t *testing.T) {
+	base, _ := url.Parse("rtsp://192.0.2.1/stream")
+	media, err := parseMedia([]byte(cameraSDP), base)
+	if err != nil {
+		t.Fatalf("parseMedia: %v", err)
+	}
+
+	allowed := []webrtc.RTPCodecParameters{
+		{RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: "video/VP8", ClockRate: 90000,
+		}},
+		{RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: "audio/opus", ClockRate: 48000, Channels: 2,
+		}},
+	}
+	selected, codecs := selectMedia(media, allowed)
+	if len(selected) != 1 || selected[0] != media[2] ||
+		len(codecs) != 1 || codecs[0].MimeType != "audio/opus" {
+		t.Errorf("Got %v %v", selected, codecs)
+	}
+
+	allowed = append(allowed, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType: "video/H264", ClockRate: 90000,
+			SDPFmtpLine: "profile-level-id=42e01f",
+		},
+	})
+	selected, codecs = selectMedia(media, allowed)
+	if len(selected) != 2 || selected[0] != media[0] ||
+		codecs[0].SDPFmtpLine != "profile-level-id=42e01f" {
+		t.Errorf("Got %v %v", selected, codecs)
+	}
+}
@@ -0,0 +1,336 @@
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// A session carries the streams of a camera over the RTSP connection
+// itself (RFC 2326 Section 10.12), which avoids any issues with NAT and
+// firewalls, and doesn't require any ports to be allocated.
+
+const (
+	// the time we wait for the camera to respond, or to send a packet
+	readTimeout = 10 * time.Second
+	// the session timeout if the camera doesn't specify one
+	defaultSessionTimeout = 60 * time.Second
+	userAgent             = "Galene"
+)
+
+// ErrStatus is returned when the camera responds with an error.
+type ErrStatus struct {
+	Method     string
+	StatusCode int
+	Reason     string
+}
+
+func (err ErrStatus) Error() string {
+	return fmt.Sprintf("%v: %v %v", err.Method, err.StatusCode, err.Reason)
+}
+
+// A Session is an RTSP session with a camera.
+type Session struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	url    *url.URL
+	auth   *authenticator
+
+	// the URL used for aggregate control
+	base      *url.URL
+	cseq      int
+	session   string
+	timeout   time.Duration
+	keepalive time.Time
+
+	// protects writes to conn
+	mu     sync.Mutex
+	closed bool
+}
+
+// Dial connects to the camera at the given URL, which may contain
+// credentials.
+func Dial(ctx context.Context, rawURL string) (*Session, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	port := "554"
+	if scheme == "rtsps" {
+		port = "322"
+	} else if scheme != "rtsp" {
+		return nil, errors.New("unsupported scheme " + u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var conn net.Conn
+	if scheme == "rtsps" {
+		d := tls.Dialer{
+			Config: &tls.Config{ServerName: u.Hostname()},
+		}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: defaultSessionTimeout,
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		s.auth = &authenticator{
+			username: u.User.Username(),
+			password: password,
+		}
+	}
+	uu := *u
+	uu.User = nil
+	s.url = &uu
+	s.base = &uu
+	return s, nil
+}
+
+// RemoteAddr returns the address of the camera.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) write(method, uri string, header textproto.MIMEHeader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cseq++
+	if header == nil {
+		header = make(textproto.MIMEHeader)
+	}
+	header.Set("User-Agent", userAgent)
+	if s.session != "" {
+		header.Set("Session", s.session)
+	}
+	if s.auth != nil && s.auth.active {
+		header.Set("Authorization", s.auth.authorization(method, uri))
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(readTimeout))
+	return writeRequest(s.conn, method, uri, s.cseq, header)
+}
+
+// readResponse reads the response to the last request, skipping any
+// interleaved packets.
+func (s *Session) readResponse() (*Response, error) {
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		b, err := s.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '$' {
+			_, _, err := s.readFrame()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return readResponse(s.reader)
+	}
+}
+
+func (s *Session) request(method, uri string, header textproto.MIMEHeader) (*Response, error) {
+	err := s.write(method, uri, cloneHeader(header))
+	if err != nil {
+		return nil, err
+	}
+	return s.readResponse()
+}
+
+// do performs a request, authenticating if required.
+func (s *Session) do(method, uri string, header textproto.MIMEHeader) (*Response, error) {
+	resp, err := s.request(method, uri, header)
+	if err == nil && resp.StatusCode == 401 && s.auth != nil {
+		err = s.auth.challenge(resp.Header.Values("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+		resp, err = s.request(method, uri, header)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, ErrStatus{method, resp.StatusCode, resp.Reason}
+	}
+	return resp, nil
+}
+
+func cloneHeader(h textproto.MIMEHeader) textproto.MIMEHeader {
+	c := make(textproto.MIMEHeader, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// Describe retrieves the session description, and returns the media
+// streams offered by the camera.
+func (s *Session) Describe() ([]*Media, error) {
+	resp, err := s.do("DESCRIBE", s.url.String(), textproto.MIMEHeader{
+		"Accept": []string{"application/sdp"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range []string{"Content-Base", "Content-Location"} {
+		if v := resp.Header.Get(h); v != "" {
+			u, err := s.url.Parse(v)
+			if err == nil {
+				s.base = u
+				break
+			}
+		}
+	}
+	return parseMedia(resp.Body, s.base)
+}
+
+// Setup requests that a media stream be sent over the connection.  It
+// must be called in order for every stream that is played, with
+// increasing indices.
+func (s *Session) Setup(m *Media, index int) error {
+	channel := uint8(2 * index)
+	resp, err := s.do("SETUP", m.control, textproto.MIMEHeader{
+		"Transport": []string{fmt.Sprintf(
+			"RTP/AVP/TCP;unicast;interleaved=%v-%v",
+			channel, channel+1,
+		)},
+	})
+	if err != nil {
+		return err
+	}
+	if c, ok := interleavedChannel(resp.Header.Get("Transport")); ok {
+		channel = c
+	}
+	m.channel = channel
+
+	if s.session == "" {
+		id, timeout := sessionHeader(resp.Header.Get("Session"))
+		if id == "" {
+			return errors.New("SETUP: no session")
+		}
+		s.session = id
+		if timeout > 0 {
+			s.timeout = time.Duration(timeout) * time.Second
+		}
+	}
+	return nil
+}
+
+// Play starts the streams.
+func (s *Session) Play() error {
+	_, err := s.do("PLAY", s.base.String(), textproto.MIMEHeader{
+		"Range": []string{"npt=0.000-"},
+	})
+	if err != nil {
+		return err
+	}
+	s.keepalive = time.Now()
+	return nil
+}
+
+// readFrame reads an interleaved frame.
+func (s *Session) readFrame() (uint8, []byte, error) {
+	var header [4]byte
+	_, err := io.ReadFull(s.reader, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[0] != '$' {
+		return 0, nil, ErrMalformed
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	_, err = io.ReadFull(s.reader, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return header[1], data, nil
+}
+
+// ReadPacket returns the next RTP packet received from the camera, and the
+// index in media of the stream that carries it.  RTCP packets and
+// responses are skipped.  It also keeps the session alive.
+func (s *Session) ReadPacket(media []*Media) (int, *rtp.Packet, error) {
+	for {
+		if time.Since(s.keepalive) > s.timeout/2 {
+			s.keepalive = time.Now()
+			// the response is skipped below
+			err := s.write("OPTIONS", s.base.String(), nil)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		s.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		b, err := s.reader.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		if b[0] != '$' {
+			_, err := readResponse(s.reader)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		channel, data, err := s.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		for i, m := range media {
+			if m.channel != channel {
+				continue
+			}
+			var p rtp.Packet
+			err := p.Unmarshal(data)
+			if err != nil {
+				break
+			}
+			return i, &p, nil
+		}
+	}
+}
+
+// Close tears down the session and closes the connection.  It may be
+// called concurrently with ReadPacket, which then returns an error.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+	if s.session != "" {
+		s.write("TEARDOWN", s.base.String(), nil)
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,196 @@
+package rtsp
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+type fakeRequest struct {
+	method string
+	uri    string
+	header textproto.MIMEHeader
+}
+
+func readFakeRequest(r *bufio.Reader) (*fakeRequest, error) {
+	tr := textproto.NewReader(r)
+	line, err := tr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, ErrMalformed
+	}
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRequest{fields[0], fields[1], header}, nil
+}
+
+func interleave(channel uint8, data []byte) []byte {
+	frame := []byte{'$', channel, 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(data)))
+	return append(frame, data...)
+}
+
+// fakeCamera serves a single RTSP session, and returns the methods of the
+// requests it received.
+func fakeCamera(t *testing.T, l net.Listener, methods chan<- []string) {
+	conn, err := l.Accept()
+	if err != nil {
+		methods <- nil
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var received []string
+	defer func() { methods <- received }()
+
+	sdp := "v=0\r\no=- 1 1 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+		"a=control:video\r\n"
+	for {
+		req, err := readFakeRequest(r)
+		if err != nil {
+			return
+		}
+		received = append(received, req.method)
+		cseq := req.header.Get("Cseq")
+		var resp string
+		switch req.method {
+		case "DESCRIBE":
+			if !strings.HasPrefix(req.header.Get("Authorization"),
+				"Digest ") {
+				resp = "RTSP/1.0 401 Unauthorized\r\n" +
+					"CSeq: " + cseq + "\r\n" +
+					"WWW-Authenticate: Digest realm=\"camera\", " +
+					"nonce=\"1234\"\r\n\r\n"
+				break
+			}
+			resp = "RTSP/1.0 200 OK\r\n" +
+				"CSeq: " + cseq + "\r\n" +
+				"Content-Base: rtsp://127.0.0.1/base/\r\n" +
+				fmt.Sprintf("Content-Length: %v\r\n\r\n", len(sdp)) +
+				sdp
+		case "SETUP":
+			if req.uri != "rtsp://127.0.0.1/base/video" {
+				t.Errorf("SETUP %v", req.uri)
+			}
+			resp = "RTSP/1.0 200 OK\r\n" +
+				"CSeq: " + cseq + "\r\n" +
+				"Session: 42;timeout=30\r\n" +
+				"Transport: RTP/AVP/TCP;unicast;interleaved=6-7\r\n\r\n"
+		case "PLAY":
+			if req.header.Get("Session") != "42" {
+				t.Errorf("PLAY session %v",
+					req.header.Get("Session"))
+			}
+			resp = "RTSP/1.0 200 OK\r\n" +
+				"CSeq: " + cseq + "\r\n\r\n"
+			p := rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					PayloadType:    96,
+					SequenceNumber: 17,
+				},
+				Payload: []byte{0x41, 1, 2},
+			}
+			buf, _ := p.Marshal()
+			resp += string(interleave(7, []byte{0x80, 200, 0, 0}))
+			resp += "RTSP/1.0 200 OK\r\nCSeq: 99\r\n\r\n"
+			resp += string(interleave(6, buf))
+		case "TEARDOWN":
+			return
+		default:
+			resp = "RTSP/1.0 501 Not Implemented\r\n" +
+				"CSeq: " + cseq + "\r\n\r\n"
+		}
+		_, err = conn.Write([]byte(resp))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	methods := make(chan []string, 1)
+	go fakeCamera(t, l, methods)
+
+	s, err := Dial(context.Background(),
+		"rtsp://user:secret@"+l.Addr().String()+"/stream",
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	media, err := s.Describe()
+	if err != nil {
+		t.Fatalf("Describe: %v", err)
+	}
+	if len(media) != 1 {
+		t.Fatalf("Expected one stream, got %v", len(media))
+	}
+	err = s.Setup(media[0], 0)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if media[0].channel != 6 || s.timeout.Seconds() != 30 {
+		t.Errorf("Got channel %v, timeout %v",
+			media[0].channel, s.timeout)
+	}
+	err = s.Play()
+	if err != nil {
+		t.Fatalf("Play: %v", err)
+	}
+	i, p, err := s.ReadPacket(media)
+	if err != nil {
+		t.Fatalf("ReadPacket: %v", err)
+	}
+	if i != 0 || p.SequenceNumber != 17 || len(p.Payload) != 3 {
+		t.Errorf("Got %v %v", i, p)
+	}
+	s.Close()
+
+	expected := []string{"DESCRIBE", "DESCRIBE", "SETUP", "PLAY", "TEARDOWN"}
+	got := <-methods
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestSessionError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+	methods := make(chan []string, 1)
+	go fakeCamera(t, l, methods)
+
+	// no credentials
+	s, err := Dial(context.Background(),
+		"rtsp://"+l.Addr().String()+"/stream",
+	)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer s.Close()
+	_, err = s.Describe()
+	status, ok := err.(ErrStatus)
+	if !ok || status.StatusCode != 401 {
+		t.Errorf("Expected 401, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+// Package rtsp implements a client that pulls the streams of RTSP cameras
+// and publishes them in groups.  Only H.264 video and Opus audio are
+// forwarded, since these are the only codecs produced by common cameras
+// that browsers can decode; other streams are ignored.  Streams are
+// forwarded without transcoding, so the camera should be configured to
+// send keyframes at frequent intervals.
+package rtsp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+const (
+	// the delays before reconnecting to a camera after a failure
+	minRetryDelay = 2 * time.Second
+	maxRetryDelay = time.Minute
+	// the username of a source if none is configured
+	defaultUsername = "camera"
+)
+
+// ErrConfigured is returned when attempting to remove a source that is
+// configured in the group description.
+var ErrConfigured = group.UserError(
+	"this source is configured in the group description",
+)
+
+// Status describes a source.
+type Status struct {
+	Id string `json:"id"`
+	// the URL of the source, with any password redacted
+	URL        string `json:"url"`
+	Username   string `json:"username,omitempty"`
+	Configured bool   `json:"configured,omitempty"`
+	Connected  bool   `json:"connected"`
+	Error      string `json:"error,omitempty"`
+}
+
+// A source is an RTSP stream that is published in a group.
+type source struct {
+	id         string
+	group      string
+	src        group.RTSPSource
+	username   string
+	configured bool
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu        sync.Mutex
+	connected bool
+	err       error
+}
+
+var sources struct {
+	mu      sync.Mutex
+	sources map[string]*source
+}
+
+func randomId() string {
+	b := make([]byte, 9)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return u.Redacted()
+}
+
+// called locked
+func start(g string, src group.RTSPSource, configured bool) *source {
+	username := src.Username
+	if username == "" {
+		username = defaultUsername
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &source{
+		id:         randomId(),
+		group:      g,
+		src:        src,
+		username:   username,
+		configured: configured,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	if sources.sources == nil {
+		sources.sources = make(map[string]*source)
+	}
+	sources.sources[s.id] = s
+	go s.run(ctx)
+	return s
+}
+
+// stop stops a source and waits for it to terminate.  It must be called
+// after the source has been removed from sources.
+func (s *source) stop() {
+	s.cancel()
+	<-s.done
+}
+
+// Add starts publishing the stream at the given URL in a group, and
+// returns the identifier of the new source.
+func Add(g string, src group.RTSPSource) (string, error) {
+	err := group.CheckRTSPURL(src.URL)
+	if err != nil {
+		return "", group.UserError(err.Error())
+	}
+	_, err = group.GetDescription(g)
+	if err != nil {
+		return "", err
+	}
+
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	return start(g, src, false).id, nil
+}
+
+// Remove stops a source that was created by Add.
+func Remove(g, id string) error {
+	sources.mu.Lock()
+	s := sources.sources[id]
+	if s == nil || s.group != g {
+		sources.mu.Unlock()
+		return os.ErrNotExist
+	}
+	if s.configured {
+		sources.mu.Unlock()
+		return ErrConfigured
+	}
+	delete(sources.sources, id)
+	sources.mu.Unlock()
+
+	s.stop()
+	return nil
+}
+
+func (s *source) status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{
+		Id:         s.id,
+		URL:        redact(s.src.URL),
+		Username:   s.username,
+		Configured: s.configured,
+		Connected:  s.connected,
+	}
+	if s.err != nil {
+		status.Error = s.err.Error()
+	}
+	return status
+}
+
+// List returns the status of the sources of a group.
+func List(g string) []Status {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	l := make([]Status, 0)
+	for _, s := range sources.sources {
+		if s.group == g {
+			l = append(l, s.status())
+		}
+	}
+	return l
+}
+
+// Get returns the status of a single source.
+func Get(g, id string) (Status, error) {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	s := sources.sources[id]
+	if s == nil || s.group != g {
+		return Status{}, os.ErrNotExist
+	}
+	return s.status(), nil
+}
+
+type configuredSource struct {
+	group string
+	src   group.RTSPSource
+}
+
+// Update starts and stops sources in order to match the group
+// descriptions.
+func Update() {
+	names, err := group.GetDescriptionNames()
+	if err != nil {
+		log.Printf("RTSP: couldn't read groups: %v", err)
+		return
+	}
+	wanted := make(map[configuredSource]bool)
+	for _, name := range names {
+		desc, err := group.GetDescription(name)
+		if err != nil {
+			continue
+		}
+		for _, src := range desc.RTSPSources {
+			wanted[configuredSource{name, src}] = true
+		}
+	}
+
+	var stopped []*source
+	sources.mu.Lock()
+	for id, s := range sources.sources {
+		if !s.configured {
+			continue
+		}
+		src := configuredSource{s.group, s.src}
+		if wanted[src] {
+			delete(wanted, src)
+			continue
+		}
+		delete(sources.sources, id)
+		stopped = append(stopped, s)
+	}
+	for src := range wanted {
+		start(src.group, src.src, true)
+	}
+	sources.mu.Unlock()
+
+	for _, s := range stopped {
+		s.stop()
+	}
+}
+
+// Stop stops all sources.
+func Stop() {
+	sources.mu.Lock()
+	ss := sources.sources
+	sources.sources = nil
+	sources.mu.Unlock()
+
+	for _, s := range ss {
+		s.stop()
+	}
+}
+
+func (s *source) setStatus(connected bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = connected
+	s.err = err
+}
+
+func (s *source) run(ctx context.Context) {
+	defer close(s.done)
+
+	delay := minRetryDelay
+	for {
+		start := time.Now()
+		err := s.play(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, os.ErrNotExist) && !s.configured {
+			log.Printf("RTSP %v: group %v no longer exists",
+				redact(s.src.URL), s.group)
+			sources.mu.Lock()
+			if sources.sources[s.id] == s {
+				delete(sources.sources, s.id)
+			}
+			sources.mu.Unlock()
+			return
+		}
+		s.setStatus(false, err)
+		if err != nil {
+			log.Printf("RTSP %v: %v", redact(s.src.URL), err)
+		}
+		if time.Since(start) > maxRetryDelay {
+			delay = minRetryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// selectMedia selects the streams that will be forwarded, at most one of
+// each kind, and returns them together with the codecs used to forward
+// them.
+func selectMedia(media []*Media, allowed []webrtc.RTPCodecParameters) ([]*Media, []webrtc.RTPCodecCapability) {
+	var selected []*Media
+	var codecs []webrtc.RTPCodecCapability
+	for _, kind := range []string{"video", "audio"} {
+	mediaLoop:
+		for _, m := range media {
+			if m.Kind != kind {
+				continue
+			}
+			if !strings.EqualFold(m.MimeType, webrtc.MimeTypeH264) &&
+				!strings.EqualFold(m.MimeType, webrtc.MimeTypeOpus) {
+				continue
+			}
+			for _, c := range allowed {
+				if strings.EqualFold(c.MimeType, m.MimeType) &&
+					c.ClockRate == m.ClockRate {
+					selected = append(selected, m)
+					codecs = append(codecs, c.RTPCodecCapability)
+					break mediaLoop
+				}
+			}
+		}
+	}
+	return selected, codecs
+}
+
+// play connects to the camera and forwards its streams until an error
+// occurs or the context is cancelled.
+func (s *source) play(ctx context.Context) error {
+	g, err := group.Add(s.group, nil)
+	if err != nil {
+		return err
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, readTimeout)
+	session, err := Dial(dctx, s.src.URL)
+	cancel()
+	if err != nil {
+		return err
+	}
+	closed := make(chan struct{})
+	defer func() {
+		close(closed)
+		session.Close()
+	}()
+
+	media, err := session.Describe()
+	if err != nil {
+		return err
+	}
+	selected, codecs := selectMedia(media, g.Codecs())
+	if len(selected) == 0 {
+		return errors.New("no stream uses a codec allowed in the group")
+	}
+	for i, m := range selected {
+		err := session.Setup(m, i)
+		if err != nil {
+			return err
+		}
+	}
+
+	client := rtpconn.NewRtspClient(
+		g, s.id, s.username, session.RemoteAddr(),
+	)
+	_, err = group.AddClient(g.Name(), client,
+		group.ClientCredentials{System: true},
+	)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	cctx, cancel := context.WithTimeout(ctx, readTimeout)
+	err = client.Connect(cctx, codecs)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	err = session.Play()
+	if err != nil {
+		return err
+	}
+	s.setStatus(true, nil)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-client.Done():
+		case <-closed:
+			return
+		}
+		// causes ReadPacket to fail
+		session.Close()
+	}()
+
+	packetizers := make([]*h264Packetizer, len(selected))
+	for i, m := range selected {
+		if strings.EqualFold(m.MimeType, webrtc.MimeTypeH264) {
+			packetizers[i] = newH264Packetizer(m.Fmtp)
+		}
+	}
+
+	for {
+		i, p, err := session.ReadPacket(selected)
+		if err != nil {
+			select {
+			case <-client.Done():
+				return errors.New("kicked from the group")
+			default:
+			}
+			return err
+		}
+		if packetizers[i] == nil {
+			// header extensions are not negotiated
+			p.Extension = false
+			p.Extensions = nil
+			err = client.WriteRTP(i, p)
+		} else {
+			for _, q := range packetizers[i].packet(p) {
+				err = client.WriteRTP(i, q)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+}
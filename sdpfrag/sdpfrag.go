@@ -23,6 +23,7 @@ type MediaDescription struct {
 	Mid                        string
 	UsernameFragment, Password string
 	Candidates                 []webrtc.ICECandidateInit
+	EndOfCandidates            bool
 }
 
 func (s *SDPFrag) Unmarshal(value []byte) error {
@@ -61,6 +62,11 @@ func (s *SDPFrag) Unmarshal(value []byte) error {
 				return errors.New("unexpected mid")
 			}
 			mediaDescription.Mid = string(l[len("a=mid:"):])
+		} else if bytes.Equal(l, []byte("a=end-of-candidates")) {
+			if mediaDescription == nil {
+				return errors.New("unexpected end-of-candidates")
+			}
+			mediaDescription.EndOfCandidates = true
 		} else if bytes.HasPrefix(l, []byte("a=candidate:")) {
 			init := webrtc.ICECandidateInit{
 				Candidate: string(l[len("a=candidate:"):]),
@@ -116,6 +122,9 @@ func (s *SDPFrag) Marshal() ([]byte, error) {
 		for _, c := range m.Candidates {
 			fmt.Fprintf(w, "a=candidate:%v\r\n", c.Candidate)
 		}
+		if m.EndOfCandidates {
+			fmt.Fprintf(w, "a=end-of-candidates\r\n")
+		}
 	}
 
 	return w.Bytes(), nil
@@ -269,6 +278,7 @@ func FromSDP(s sdp.SessionDescription) SDPFrag {
 		mm.Candidates = getCandidates(
 			m.Attributes, &mid, &i, ufrag,
 		)
+		_, mm.EndOfCandidates = m.Attribute("end-of-candidates")
 		f.MediaDescriptions = append(f.MediaDescriptions, mm)
 	}
 	return f
@@ -212,6 +212,16 @@ func TestParseSDPFrag(t *testing.T) {
 		*c.UsernameFragment != "qiKa" {
 		t.Errorf("Got %v", c)
 	}
+
+	var frag2 SDPFrag
+	err = frag2.Unmarshal([]byte(sdpfragEndOfCandidates))
+	if err != nil {
+		t.Errorf("Unmarshal: %v", err)
+	}
+	if len(frag2.MediaDescriptions) != 1 ||
+		!frag2.MediaDescriptions[0].EndOfCandidates {
+		t.Errorf("Expected end-of-candidates, got %v", frag2)
+	}
 }
 
 func testRoundtrip(t *testing.T, sdpfrag string) {
@@ -0,0 +1,84 @@
+package sip
+
+import (
+	"strings"
+)
+
+// DTMF digits are carried as RFC 4733 telephone events.  An event is
+// sent in a sequence of packets that share the same RTP timestamp, and
+// the final packet is repeated, so we only record an event the first
+// time we see its timestamp.
+
+// dtmfDigits maps RFC 4733 event codes to DTMF digits.
+const dtmfDigits = "0123456789*#ABCD"
+
+type dtmfDecoder struct {
+	seen      bool
+	timestamp uint32
+}
+
+// decode returns the digit carried by a telephone event packet, or 0 if
+// the packet doesn't start a new event.
+func (d *dtmfDecoder) decode(timestamp uint32, payload []byte) byte {
+	if len(payload) < 4 {
+		return 0
+	}
+	if d.seen && d.timestamp == timestamp {
+		return 0
+	}
+	event := payload[0]
+	if int(event) >= len(dtmfDigits) {
+		return 0
+	}
+	d.seen = true
+	d.timestamp = timestamp
+	return dtmfDigits[event]
+}
+
+// dtmfRelay parses the body of a SIP INFO request of type
+// application/dtmf-relay, which some phones use instead of RFC 4733.
+func dtmfRelay(body []byte) byte {
+	for _, line := range strings.Split(string(body), "\n") {
+		k, v, found := strings.Cut(line, "=")
+		if !found || !strings.EqualFold(strings.TrimSpace(k), "signal") {
+			continue
+		}
+		v = strings.ToUpper(strings.TrimSpace(v))
+		if len(v) == 1 && strings.IndexByte(dtmfDigits, v[0]) >= 0 {
+			return v[0]
+		}
+		if v == "10" {
+			return '*'
+		}
+		if v == "11" {
+			return '#'
+		}
+	}
+	return 0
+}
+
+// pinReader accumulates the digits of a PIN.  A PIN is terminated by '#',
+// and '*' starts over.  Digits beyond the maximum length of a PIN are
+// ignored.
+type pinReader struct {
+	digits []byte
+}
+
+const maxPINDigits = 16
+
+// digit adds a digit, and returns the PIN when it is complete.
+func (r *pinReader) digit(d byte) (string, bool) {
+	switch {
+	case d == '#':
+		pin := string(r.digits)
+		r.digits = nil
+		return pin, true
+	case d == '*':
+		r.digits = nil
+	case d >= '0' && d <= '9':
+		if len(r.digits) < maxPINDigits {
+			r.digits = append(r.digits, d)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,84 @@
+package sip
+
+import (
+	"testing"
+)
+
+func TestDTMFDecoder(t *testing.T) {
+	var d dtmfDecoder
+	event := func(digit byte, end bool) []byte {
+		flags := byte(10)
+		if end {
+			flags |= 0x80
+		}
+		return []byte{digit, flags, 0, 160}
+	}
+
+	if v := d.decode(1000, event(4, false)); v != '4' {
+		t.Errorf("Expected 4, got %v", v)
+	}
+	// continuation and retransmitted end packets
+	for _, end := range []bool{false, true, true, true} {
+		if v := d.decode(1000, event(4, end)); v != 0 {
+			t.Errorf("Expected 0, got %v", v)
+		}
+	}
+	// the same digit again
+	if v := d.decode(2000, event(4, false)); v != '4' {
+		t.Errorf("Expected 4, got %v", v)
+	}
+	if v := d.decode(3000, event(11, true)); v != '#' {
+		t.Errorf("Expected #, got %v", v)
+	}
+	if v := d.decode(4000, event(16, false)); v != 0 {
+		t.Errorf("Expected 0, got %v", v)
+	}
+	if v := d.decode(5000, []byte{1}); v != 0 {
+		t.Errorf("Expected 0, got %v", v)
+	}
+}
+
+func TestDTMFRelay(t *testing.T) {
+	tests := []struct {
+		body  string
+		digit byte
+	}{
+		{"Signal=5\r\nDuration=160\r\n", '5'},
+		{"signal = #\n", '#'},
+		{"Signal=10\r\n", '*'},
+		{"Signal=11\r\n", '#'},
+		{"Duration=160\r\n", 0},
+		{"Signal=X\r\n", 0},
+	}
+	for _, test := range tests {
+		if d := dtmfRelay([]byte(test.body)); d != test.digit {
+			t.Errorf("%q: expected %v, got %v", test.body, test.digit, d)
+		}
+	}
+}
+
+func TestPINReader(t *testing.T) {
+	tests := []struct {
+		digits string
+		pin    string
+	}{
+		{"1234#", "1234"},
+		{"99*1234#", "1234"},
+		{"#", ""},
+		{"12345678901234567890#", "1234567890123456"},
+	}
+	for _, test := range tests {
+		var r pinReader
+		var pin string
+		var complete bool
+		for i := 0; i < len(test.digits); i++ {
+			if complete {
+				t.Errorf("%v: complete too early", test.digits)
+			}
+			pin, complete = r.digit(test.digits[i])
+		}
+		if !complete || pin != test.pin {
+			t.Errorf("%v: got %v %v", test.digits, pin, complete)
+		}
+	}
+}
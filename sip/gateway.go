@@ -0,0 +1,718 @@
+// Package sip implements a minimal SIP gateway that allows telephones to
+// join a group as audio participants.  A caller dials the gateway, enters
+// the dial-in PIN of a group followed by '#', and is then bridged into the
+// group.
+package sip
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+// Address is the UDP address on which the gateway listens.  If empty, the
+// gateway is disabled.
+var Address string
+
+const (
+	// the maximum number of simultaneous calls
+	maxCalls = 64
+	// the time a caller has to enter a valid PIN
+	pinTimeout = 60 * time.Second
+	// the number of invalid PINs after which we hang up
+	maxPINAttempts = 3
+	// the SIP retransmission timers, RFC 3261 Section 17
+	timerT1 = 500 * time.Millisecond
+	timerT2 = 4 * time.Second
+)
+
+type gateway struct {
+	conn    *net.UDPConn
+	limiter pinLimiter
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+var server struct {
+	mu      sync.Mutex
+	gateway *gateway
+}
+
+// Start starts the gateway if Address is not empty.
+func Start() error {
+	if Address == "" {
+		return nil
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.gateway != nil {
+		return errors.New("SIP gateway already running")
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", Address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	gw := &gateway{
+		conn:  conn,
+		calls: make(map[string]*call),
+	}
+	server.gateway = gw
+	log.Printf("Starting SIP gateway on %v", conn.LocalAddr())
+	go gw.serve()
+	return nil
+}
+
+// Stop hangs up all calls and stops the gateway.
+func Stop() {
+	server.mu.Lock()
+	gw := server.gateway
+	server.gateway = nil
+	server.mu.Unlock()
+	if gw == nil {
+		return
+	}
+
+	gw.mu.Lock()
+	calls := make([]*call, 0, len(gw.calls))
+	for _, c := range gw.calls {
+		calls = append(calls, c)
+	}
+	gw.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range calls {
+		wg.Add(1)
+		go func(c *call) {
+			c.hangup()
+			wg.Done()
+		}(c)
+	}
+	wg.Wait()
+	gw.conn.Close()
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	crand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (gw *gateway) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := gw.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("SIP: %v", err)
+			}
+			return
+		}
+		m, err := Parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		gw.handle(m, addr)
+	}
+}
+
+func (gw *gateway) send(m *Message, addr *net.UDPAddr) {
+	_, err := gw.conn.WriteToUDP(m.Marshal(), addr)
+	if err != nil {
+		log.Printf("SIP: %v", err)
+	}
+}
+
+func (gw *gateway) respond(req *Message, addr *net.UDPAddr, code int, reason string) {
+	gw.send(NewResponse(req, code, reason, ""), addr)
+}
+
+func (gw *gateway) getCall(id string) *call {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	return gw.calls[id]
+}
+
+func (gw *gateway) delCall(c *call) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if gw.calls[c.id] == c {
+		delete(gw.calls, c.id)
+	}
+}
+
+const allowed = "INVITE, ACK, BYE, CANCEL, OPTIONS, INFO"
+
+func (gw *gateway) handle(m *Message, addr *net.UDPAddr) {
+	c := gw.getCall(m.Get("Call-ID"))
+
+	if !m.IsRequest() {
+		_, method, err := m.CSeq()
+		if err == nil && method == "BYE" && c != nil {
+			c.byeAnswered()
+		}
+		return
+	}
+
+	switch m.Method {
+	case "INVITE":
+		if c != nil {
+			c.reinvite(m, addr)
+			return
+		}
+		gw.invite(m, addr)
+	case "ACK":
+		if c != nil {
+			c.ack()
+		}
+	case "BYE":
+		if c == nil {
+			gw.respond(m, addr, 481, "Call/Transaction Does Not Exist")
+			return
+		}
+		gw.respond(m, addr, 200, "OK")
+		c.close()
+	case "CANCEL":
+		// we answer INVITEs immediately, so there is never a
+		// pending transaction to cancel
+		if c == nil {
+			gw.respond(m, addr, 481, "Call/Transaction Does Not Exist")
+			return
+		}
+		gw.respond(m, addr, 200, "OK")
+	case "INFO":
+		if c == nil {
+			gw.respond(m, addr, 481, "Call/Transaction Does Not Exist")
+			return
+		}
+		gw.respond(m, addr, 200, "OK")
+		ct := strings.ToLower(m.Get("Content-Type"))
+		if strings.HasPrefix(ct, "application/dtmf-relay") {
+			if d := dtmfRelay(m.Body); d != 0 {
+				c.digit(d)
+			}
+		}
+	case "OPTIONS":
+		resp := NewResponse(m, 200, "OK", "")
+		resp.Add("Allow", allowed)
+		resp.Add("Accept", "application/sdp")
+		gw.send(resp, addr)
+	default:
+		resp := NewResponse(m, 501, "Not Implemented", "")
+		resp.Add("Allow", allowed)
+		gw.send(resp, addr)
+	}
+}
+
+// localIP returns the local address used to reach a remote address.
+func (gw *gateway) localIP(remote net.IP) (net.IP, error) {
+	addr := gw.conn.LocalAddr().(*net.UDPAddr)
+	if !addr.IP.IsUnspecified() {
+		return addr.IP, nil
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: remote, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func (gw *gateway) invite(m *Message, addr *net.UDPAddr) {
+	if !gw.limiter.allowed(addr.IP, time.Now()) {
+		gw.respond(m, addr, 403, "Forbidden")
+		return
+	}
+
+	media, err := negotiate(m.Body)
+	if err != nil {
+		log.Printf("SIP: %v: %v", addr, err)
+		resp := NewResponse(m, 488, "Not Acceptable Here", "")
+		resp.Add("Accept", "application/sdp")
+		gw.send(resp, addr)
+		return
+	}
+
+	ip, err := gw.localIP(addr.IP)
+	if err != nil {
+		log.Printf("SIP: %v", err)
+		gw.respond(m, addr, 500, "Server Internal Error")
+		return
+	}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		log.Printf("SIP: %v", err)
+		gw.respond(m, addr, 500, "Server Internal Error")
+		return
+	}
+
+	c := &call{
+		gw:      gw,
+		id:      m.Get("Call-ID"),
+		source:  addr,
+		invite:  m,
+		tag:     randomString(9),
+		media:   media,
+		rtp:     rtpConn,
+		remote:  media.remote,
+		acked:   make(chan struct{}),
+		byeDone: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.local = &net.UDPAddr{
+		IP:   ip,
+		Port: gw.conn.LocalAddr().(*net.UDPAddr).Port,
+	}
+	c.cseq, _, _ = m.CSeq()
+
+	var id [8]byte
+	crand.Read(id[:])
+	c.answer = media.answer(
+		binary.BigEndian.Uint64(id[:])>>1,
+		rtpConn.LocalAddr().(*net.UDPAddr),
+	)
+
+	gw.mu.Lock()
+	if len(gw.calls) >= maxCalls {
+		gw.mu.Unlock()
+		rtpConn.Close()
+		gw.respond(m, addr, 486, "Busy Here")
+		return
+	}
+	gw.calls[c.id] = c
+	gw.mu.Unlock()
+
+	display, uri := SplitAddress(m.Get("From"))
+	log.Printf("SIP: call from %v (%v)", uri, display)
+
+	c.respondInvite(m)
+	go c.retransmit()
+	go c.readRTP()
+	go c.timeout()
+}
+
+// A call is a SIP dialog together with its RTP session.
+type call struct {
+	gw     *gateway
+	id     string
+	source *net.UDPAddr
+	local  *net.UDPAddr
+	invite *Message
+	tag    string
+	media  *media
+	rtp    *net.UDPConn
+	answer []byte
+
+	acked   chan struct{}
+	byeDone chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	response []byte
+	isAcked  bool
+	cseq     uint32
+	remote   *net.UDPAddr
+	latched  bool
+	dtmf     dtmfDecoder
+	pin      pinReader
+	joining  bool
+	attempts int
+	client   *rtpconn.SipClient
+	bye      bool
+	closed   bool
+}
+
+func (c *call) contact() string {
+	return fmt.Sprintf("<sip:galene@%v>",
+		net.JoinHostPort(c.local.IP.String(), fmt.Sprint(c.local.Port)))
+}
+
+// respondInvite sends the final response to an INVITE.
+func (c *call) respondInvite(m *Message) {
+	resp := NewResponse(m, 200, "OK", c.tag)
+	resp.Add("Contact", c.contact())
+	resp.Add("Allow", allowed)
+	resp.Add("Content-Type", "application/sdp")
+	resp.Body = c.answer
+	data := resp.Marshal()
+
+	c.mu.Lock()
+	c.response = data
+	c.mu.Unlock()
+
+	_, err := c.gw.conn.WriteToUDP(data, c.source)
+	if err != nil {
+		log.Printf("SIP: %v", err)
+	}
+}
+
+// reinvite handles a retransmitted INVITE or a re-INVITE.  We don't
+// support changing the parameters of a call, so we answer with the
+// original SDP.
+func (c *call) reinvite(m *Message, addr *net.UDPAddr) {
+	if Param(m.Get("To"), "tag") == "" {
+		c.mu.Lock()
+		data := c.response
+		c.mu.Unlock()
+		if data != nil {
+			c.gw.conn.WriteToUDP(data, addr)
+		}
+		return
+	}
+	c.respondInvite(m)
+}
+
+func (c *call) ack() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isAcked {
+		c.isAcked = true
+		close(c.acked)
+	}
+}
+
+// retransmit retransmits the 200 response to an INVITE until it is
+// acknowledged, and hangs up if it never is.
+func (c *call) retransmit() {
+	interval := timerT1
+	deadline := time.After(64 * timerT1)
+	for {
+		select {
+		case <-c.acked:
+			return
+		case <-c.done:
+			return
+		case <-deadline:
+			log.Printf("SIP: %v: no ACK", c.source)
+			c.hangup()
+			return
+		case <-time.After(interval):
+			c.mu.Lock()
+			data := c.response
+			c.mu.Unlock()
+			c.gw.conn.WriteToUDP(data, c.source)
+			interval = min(2*interval, timerT2)
+		}
+	}
+}
+
+func (c *call) timeout() {
+	timer := time.NewTimer(pinTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+	case <-timer.C:
+		c.mu.Lock()
+		joined := c.client != nil
+		c.mu.Unlock()
+		if !joined {
+			log.Printf("SIP: %v: no PIN entered", c.source)
+			c.hangup()
+		}
+	}
+}
+
+// Write sends an RTP packet to the caller.  It is called by the client.
+func (c *call) Write(buf []byte) (int, error) {
+	c.mu.Lock()
+	remote := c.remote
+	c.mu.Unlock()
+	return c.rtp.WriteToUDP(buf, remote)
+}
+
+func (c *call) readRTP() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := c.rtp.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("SIP: %v", err)
+			}
+			return
+		}
+
+		var p rtp.Packet
+		err = p.Unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		c.mu.Lock()
+		// callers behind NAT don't know their public address, so
+		// we send to the address from which they send, but only if
+		// it is the host announced in the SDP or the one that sent
+		// the INVITE, in order to prevent hijacking of the call
+		if !c.latched {
+			if !c.mayLatch(addr) {
+				c.mu.Unlock()
+				continue
+			}
+			c.remote = addr
+			c.latched = true
+		} else if !addrEqual(addr, c.remote) {
+			c.mu.Unlock()
+			continue
+		}
+		client := c.client
+		var d byte
+		if int(p.PayloadType) == c.media.dtmfPT {
+			d = c.dtmf.decode(p.Timestamp, p.Payload)
+		}
+		c.mu.Unlock()
+
+		if d != 0 {
+			c.digit(d)
+			continue
+		}
+		if client != nil && p.PayloadType == c.media.pt {
+			client.WriteRTP(&p)
+		}
+	}
+}
+
+// mayLatch returns true if we may start sending media to addr.  Called
+// locked.
+func (c *call) mayLatch(addr *net.UDPAddr) bool {
+	return (c.remote != nil && addr.IP.Equal(c.remote.IP)) ||
+		(c.source != nil && addr.IP.Equal(c.source.IP))
+}
+
+func addrEqual(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// digit handles a DTMF digit entered by the caller.
+func (c *call) digit(d byte) {
+	c.mu.Lock()
+	if c.client != nil || c.joining || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	pin, complete := c.pin.digit(d)
+	if complete {
+		c.joining = true
+	}
+	c.mu.Unlock()
+
+	if complete {
+		go c.join(pin)
+	}
+}
+
+func (c *call) username() string {
+	display, _ := SplitAddress(c.invite.Get("From"))
+	username := display
+	if username == "" {
+		return c.phoneName()
+	}
+	username = strings.ReplaceAll(username, "/", "-")
+	return strings.TrimLeft(username, ".")
+}
+
+// phoneName returns a username derived from the caller's number, which
+// is used when the display name is missing or belongs to a group member.
+func (c *call) phoneName() string {
+	_, uri := SplitAddress(c.invite.Get("From"))
+	user := URIUser(uri)
+	if len(user) >= 4 {
+		return "phone-" + user[len(user)-4:]
+	}
+	return "phone"
+}
+
+func (c *call) join(pin string) {
+	if !c.gw.limiter.allowed(c.source.IP, time.Now()) {
+		c.hangup()
+		return
+	}
+
+	err := c.doJoin(pin)
+	if err == nil {
+		return
+	}
+
+	log.Printf("SIP: %v: %v", c.source, err)
+
+	var autherr *group.NotAuthorisedError
+	if errors.Is(err, group.ErrDuplicateUsername) ||
+		(!errors.Is(err, group.ErrUnknownPIN) &&
+			!errors.As(err, &autherr)) {
+		c.hangup()
+		return
+	}
+
+	// slow down PIN guessing, see pinlimit.go
+	c.gw.limiter.failed(c.source.IP, time.Now())
+	time.Sleep(time.Second)
+
+	c.mu.Lock()
+	c.attempts++
+	c.joining = false
+	attempts := c.attempts
+	c.mu.Unlock()
+	if attempts >= maxPINAttempts {
+		c.hangup()
+	}
+}
+
+func (c *call) doJoin(pin string) error {
+	name, err := group.FindDialIn(pin)
+	if err != nil {
+		return err
+	}
+	g, err := group.Add(name, nil)
+	if err != nil {
+		return err
+	}
+
+	username := c.username()
+	client := rtpconn.NewSipClient(
+		g, randomString(12), c.source, c.media.codec, c.media.pt, c,
+	)
+	_, err = group.AddClient(g.Name(), client, group.ClientCredentials{
+		Username: &username,
+		PIN:      pin,
+	})
+	if errors.Is(err, group.ErrDuplicateUsername) {
+		// the display name is that of a group member
+		username = c.phoneName()
+		_, err = group.AddClient(g.Name(), client,
+			group.ClientCredentials{Username: &username, PIN: pin},
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = client.Connect(ctx)
+	if err != nil {
+		client.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		client.Close()
+		return nil
+	}
+	c.client = client
+	c.joining = false
+	c.mu.Unlock()
+
+	log.Printf("SIP: %v joined group %v", username, g.Name())
+
+	go func() {
+		select {
+		case <-client.Done():
+			c.hangup()
+		case <-c.done:
+		}
+	}()
+	return nil
+}
+
+// hangup terminates the call by sending a BYE.
+func (c *call) hangup() {
+	c.mu.Lock()
+	if c.closed || c.bye {
+		c.mu.Unlock()
+		return
+	}
+	c.bye = true
+	c.cseq++
+	cseq := c.cseq
+	c.mu.Unlock()
+
+	_, uri := SplitAddress(c.invite.Get("Contact"))
+	if uri == "" {
+		_, uri = SplitAddress(c.invite.Get("From"))
+	}
+	to := c.invite.Get("To")
+	if Param(to, "tag") == "" {
+		to = to + ";tag=" + c.tag
+	}
+
+	bye := &Message{Method: "BYE", URI: uri}
+	bye.Add("Via", fmt.Sprintf("SIP/2.0/UDP %v;branch=z9hG4bK%v;rport",
+		net.JoinHostPort(c.local.IP.String(), fmt.Sprint(c.local.Port)),
+		randomString(9)))
+	bye.Add("Max-Forwards", "70")
+	bye.Add("From", to)
+	bye.Add("To", c.invite.Get("From"))
+	bye.Add("Call-ID", c.id)
+	bye.Add("CSeq", fmt.Sprintf("%v BYE", cseq))
+
+	interval := timerT1
+	deadline := time.After(64 * timerT1)
+outer:
+	for {
+		c.gw.send(bye, c.source)
+		select {
+		case <-c.byeDone:
+			break outer
+		case <-c.done:
+			break outer
+		case <-deadline:
+			break outer
+		case <-time.After(interval):
+			interval = min(2*interval, timerT2)
+		}
+	}
+	c.close()
+}
+
+func (c *call) byeAnswered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bye {
+		select {
+		case <-c.byeDone:
+		default:
+			close(c.byeDone)
+		}
+	}
+}
+
+// close releases the resources associated with a call.
+func (c *call) close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	client := c.client
+	c.client = nil
+	close(c.done)
+	c.mu.Unlock()
+
+	c.gw.delCall(c)
+	c.rtp.Close()
+	if client != nil {
+		client.Close()
+	}
+	log.Printf("SIP: call from %v ended", c.source)
+}
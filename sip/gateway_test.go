@@ -0,0 +1,86 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	Address = "127.0.0.1:0"
+	defer func() {
+		Address = ""
+	}()
+	err := Start()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer Stop()
+
+	server.mu.Lock()
+	addr := server.gateway.conn.LocalAddr().(*net.UDPAddr)
+	server.mu.Unlock()
+
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	req := &Message{Method: "OPTIONS", URI: "sip:galene@127.0.0.1"}
+	req.Add("Via", "SIP/2.0/UDP 127.0.0.1;branch=z9hG4bK1")
+	req.Add("From", "<sip:test@127.0.0.1>;tag=1")
+	req.Add("To", "<sip:galene@127.0.0.1>")
+	req.Add("Call-ID", "options-test")
+	req.Add("CSeq", "1 OPTIONS")
+
+	for _, method := range []string{"OPTIONS", "REGISTER", "BYE"} {
+		req.Method = method
+		req.Set("CSeq", "1 "+method)
+		_, err = conn.Write(req.Marshal())
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		resp, err := Parse(buf[:n])
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		expected := map[string]int{
+			"OPTIONS": 200, "REGISTER": 501, "BYE": 481,
+		}[method]
+		if resp.StatusCode != expected {
+			t.Errorf("%v: expected %v, got %v %v",
+				method, expected, resp.StatusCode, resp.Reason)
+		}
+		if resp.Get("CSeq") != "1 "+method {
+			t.Errorf("%v: got CSeq %v", method, resp.Get("CSeq"))
+		}
+	}
+}
+
+func TestMayLatch(t *testing.T) {
+	c := &call{
+		source: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5060},
+		remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 4000},
+	}
+	tests := []struct {
+		ip string
+		ok bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.2", true},
+		{"198.51.100.7", false},
+	}
+	for _, tt := range tests {
+		addr := &net.UDPAddr{IP: net.ParseIP(tt.ip), Port: 12345}
+		if c.mayLatch(addr) != tt.ok {
+			t.Errorf("%v: expected %v", tt.ip, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package sip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Message is a SIP request or response.  Only the subset of RFC 3261 that
+// is required by the gateway is implemented: messages are carried over
+// UDP, and header fields are kept as opaque strings.
+type Message struct {
+	// the method and request URI of a request
+	Method string
+	URI    string
+	// the status code and reason phrase of a response
+	StatusCode int
+	Reason     string
+
+	Header []HeaderField
+	Body   []byte
+}
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// the compact forms of header field names, RFC 3261 Section 7.3.3
+var compactNames = map[string]string{
+	"i": "Call-ID",
+	"m": "Contact",
+	"e": "Content-Encoding",
+	"l": "Content-Length",
+	"c": "Content-Type",
+	"f": "From",
+	"s": "Subject",
+	"k": "Supported",
+	"t": "To",
+	"v": "Via",
+}
+
+var canonicalNames = map[string]string{
+	"call-id": "Call-ID",
+	"cseq":    "CSeq",
+}
+
+func canonicalName(name string) string {
+	if n, ok := compactNames[strings.ToLower(name)]; ok && len(name) == 1 {
+		return n
+	}
+	if n, ok := canonicalNames[strings.ToLower(name)]; ok {
+		return n
+	}
+	parts := strings.Split(strings.ToLower(name), "-")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+var ErrMalformed = errors.New("malformed SIP message")
+
+// Parse parses a SIP message received in a datagram.
+func Parse(data []byte) (*Message, error) {
+	head, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	if !found {
+		head, body, found = bytes.Cut(data, []byte("\n\n"))
+		if !found {
+			return nil, ErrMalformed
+		}
+	}
+
+	lines := strings.Split(
+		strings.ReplaceAll(string(head), "\r\n", "\n"), "\n",
+	)
+	if len(lines) == 0 {
+		return nil, ErrMalformed
+	}
+
+	var m Message
+	first := strings.SplitN(lines[0], " ", 3)
+	if len(first) != 3 {
+		return nil, ErrMalformed
+	}
+	if first[0] == "SIP/2.0" {
+		code, err := strconv.Atoi(first[1])
+		if err != nil || code < 100 || code > 699 {
+			return nil, ErrMalformed
+		}
+		m.StatusCode = code
+		m.Reason = first[2]
+	} else {
+		if first[2] != "SIP/2.0" || first[0] == "" || first[1] == "" {
+			return nil, ErrMalformed
+		}
+		m.Method = strings.ToUpper(first[0])
+		m.URI = first[1]
+	}
+
+	for _, line := range lines[1:] {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// continuation line
+			if len(m.Header) == 0 {
+				return nil, ErrMalformed
+			}
+			h := &m.Header[len(m.Header)-1]
+			h.Value = h.Value + " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, ErrMalformed
+		}
+		m.Header = append(m.Header, HeaderField{
+			Name:  canonicalName(strings.TrimSpace(name)),
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	if l := m.Get("Content-Length"); l != "" {
+		length, err := strconv.Atoi(l)
+		if err != nil || length < 0 || length > len(body) {
+			return nil, ErrMalformed
+		}
+		body = body[:length]
+	}
+	if len(body) > 0 {
+		m.Body = body
+	}
+
+	if m.Get("Call-ID") == "" || m.Get("CSeq") == "" {
+		return nil, ErrMalformed
+	}
+
+	return &m, nil
+}
+
+// IsRequest returns true if m is a request.
+func (m *Message) IsRequest() bool {
+	return m.Method != ""
+}
+
+// Get returns the value of the first header field with the given name.
+func (m *Message) Get(name string) string {
+	name = canonicalName(name)
+	for _, h := range m.Header {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// GetAll returns the values of all the header fields with the given name.
+func (m *Message) GetAll(name string) []string {
+	name = canonicalName(name)
+	var values []string
+	for _, h := range m.Header {
+		if h.Name == name {
+			values = append(values, h.Value)
+		}
+	}
+	return values
+}
+
+// Add adds a header field.
+func (m *Message) Add(name, value string) {
+	m.Header = append(m.Header, HeaderField{canonicalName(name), value})
+}
+
+// Set replaces the values of a header field.
+func (m *Message) Set(name, value string) {
+	name = canonicalName(name)
+	h := make([]HeaderField, 0, len(m.Header))
+	found := false
+	for _, f := range m.Header {
+		if f.Name == name {
+			if found {
+				continue
+			}
+			f.Value = value
+			found = true
+		}
+		h = append(h, f)
+	}
+	if !found {
+		h = append(h, HeaderField{name, value})
+	}
+	m.Header = h
+}
+
+// CSeq returns the sequence number and method of the CSeq header field.
+func (m *Message) CSeq() (uint32, string, error) {
+	n, method, found := strings.Cut(m.Get("CSeq"), " ")
+	if !found {
+		return 0, "", ErrMalformed
+	}
+	seqno, err := strconv.ParseUint(n, 10, 32)
+	if err != nil {
+		return 0, "", ErrMalformed
+	}
+	return uint32(seqno), strings.ToUpper(strings.TrimSpace(method)), nil
+}
+
+// Marshal returns the wire representation of m.  The Content-Length
+// header field is computed automatically.
+func (m *Message) Marshal() []byte {
+	var b bytes.Buffer
+	if m.IsRequest() {
+		fmt.Fprintf(&b, "%v %v SIP/2.0\r\n", m.Method, m.URI)
+	} else {
+		fmt.Fprintf(&b, "SIP/2.0 %v %v\r\n", m.StatusCode, m.Reason)
+	}
+	for _, h := range m.Header {
+		if h.Name == "Content-Length" {
+			continue
+		}
+		fmt.Fprintf(&b, "%v: %v\r\n", h.Name, h.Value)
+	}
+	fmt.Fprintf(&b, "Content-Length: %v\r\n\r\n", len(m.Body))
+	b.Write(m.Body)
+	return b.Bytes()
+}
+
+// NewResponse returns a response to the request req.  If tag is not
+// empty, it is added to the To header field unless it already has a tag.
+func NewResponse(req *Message, code int, reason, tag string) *Message {
+	resp := &Message{StatusCode: code, Reason: reason}
+	for _, v := range req.GetAll("Via") {
+		resp.Add("Via", v)
+	}
+	resp.Add("From", req.Get("From"))
+	to := req.Get("To")
+	if tag != "" && Param(to, "tag") == "" {
+		to = to + ";tag=" + tag
+	}
+	resp.Add("To", to)
+	resp.Add("Call-ID", req.Get("Call-ID"))
+	resp.Add("CSeq", req.Get("CSeq"))
+	return resp
+}
+
+// Param returns the value of a parameter of a header field value such as
+// From or To.  Parameters within the angle brackets of a name-addr belong
+// to the URI, and are ignored.
+func Param(value, name string) string {
+	if i := strings.LastIndexByte(value, '>'); i >= 0 {
+		value = value[i+1:]
+	}
+	for _, p := range strings.Split(value, ";")[1:] {
+		k, v, _ := strings.Cut(p, "=")
+		if strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// SplitAddress splits a header field value such as From, To or Contact into
+// its display name and URI.
+func SplitAddress(value string) (string, string) {
+	if i := strings.IndexByte(value, '<'); i >= 0 {
+		name := strings.TrimSpace(value[:i])
+		name = strings.Trim(name, "\"")
+		uri := value[i+1:]
+		if j := strings.IndexByte(uri, '>'); j >= 0 {
+			uri = uri[:j]
+		}
+		return name, uri
+	}
+	uri, _, _ := strings.Cut(value, ";")
+	return "", strings.TrimSpace(uri)
+}
+
+// URIUser returns the user part of a SIP URI.
+func URIUser(uri string) string {
+	_, rest, found := strings.Cut(uri, ":")
+	if !found {
+		return ""
+	}
+	user, _, found := strings.Cut(rest, "@")
+	if !found {
+		return ""
+	}
+	user, _, _ = strings.Cut(user, ";")
+	return user
+}
@@ -0,0 +1,139 @@
+package sip
+
+import (
+	"testing"
+)
+
+var invite = "INVITE sip:galene@192.0.2.1 SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 192.0.2.2:5060;branch=z9hG4bK776asdhds\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"To: <sip:galene@192.0.2.1>\r\n" +
+	"f: \"Alice\" <sip:+33123456789@192.0.2.2>;tag=1928301774\r\n" +
+	"i: a84b4c76e66710@192.0.2.2\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Contact: <sip:alice@192.0.2.2:5060>\r\n" +
+	"Subject: a very\r\n" +
+	"  long subject\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: 4\r\n" +
+	"\r\n" +
+	"v=0\r\ntrailing garbage"
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte(invite))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !m.IsRequest() || m.Method != "INVITE" ||
+		m.URI != "sip:galene@192.0.2.1" {
+		t.Errorf("Got %v %v", m.Method, m.URI)
+	}
+	if m.Get("Call-ID") != "a84b4c76e66710@192.0.2.2" {
+		t.Errorf("Call-ID: got %v", m.Get("Call-ID"))
+	}
+	if m.Get("from") != "\"Alice\" <sip:+33123456789@192.0.2.2>;tag=1928301774" {
+		t.Errorf("From: got %v", m.Get("From"))
+	}
+	if m.Get("Subject") != "a very long subject" {
+		t.Errorf("Subject: got %v", m.Get("Subject"))
+	}
+	if string(m.Body) != "v=0\r" {
+		t.Errorf("Body: got %q", m.Body)
+	}
+	seqno, method, err := m.CSeq()
+	if err != nil || seqno != 314159 || method != "INVITE" {
+		t.Errorf("CSeq: got %v %v %v", seqno, method, err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"INVITE sip:galene@192.0.2.1 SIP/2.0\r\n",
+		"INVITE sip:galene@192.0.2.1\r\n\r\n",
+		"SIP/2.0 99 Too Low\r\nCall-ID: x\r\nCSeq: 1 BYE\r\n\r\n",
+		"BYE sip:galene@192.0.2.1 SIP/2.0\r\nCSeq: 1 BYE\r\n\r\n",
+		"BYE sip:galene@192.0.2.1 SIP/2.0\r\nCall-ID: x\r\n" +
+			"CSeq: 1 BYE\r\nContent-Length: 10\r\n\r\nshort",
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test))
+		if err == nil {
+			t.Errorf("Parse %q succeeded", test)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	req, err := Parse([]byte(invite))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	resp := NewResponse(req, 200, "OK", "abc")
+	resp.Body = []byte("hello")
+
+	m, err := Parse(resp.Marshal())
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if m.IsRequest() || m.StatusCode != 200 || m.Reason != "OK" {
+		t.Errorf("Got %v %v", m.StatusCode, m.Reason)
+	}
+	if Param(m.Get("To"), "tag") != "abc" {
+		t.Errorf("To: got %v", m.Get("To"))
+	}
+	if m.Get("Via") != req.Get("Via") ||
+		m.Get("From") != req.Get("From") ||
+		m.Get("CSeq") != req.Get("CSeq") {
+		t.Errorf("Header mismatch")
+	}
+	if m.Get("Content-Length") != "5" || string(m.Body) != "hello" {
+		t.Errorf("Body: got %v %q", m.Get("Content-Length"), m.Body)
+	}
+
+	// the tag is not replaced within a dialog
+	resp = NewResponse(m, 200, "OK", "def")
+	if Param(resp.Get("To"), "tag") != "abc" {
+		t.Errorf("To: got %v", resp.Get("To"))
+	}
+}
+
+func TestSet(t *testing.T) {
+	var m Message
+	m.Add("Via", "a")
+	m.Add("via", "b")
+	m.Add("To", "c")
+	m.Set("Via", "d")
+	if len(m.Header) != 2 || m.Get("Via") != "d" || m.Get("To") != "c" {
+		t.Errorf("Got %v", m.Header)
+	}
+	m.Set("From", "e")
+	if m.Get("From") != "e" {
+		t.Errorf("Got %v", m.Header)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		value, display, uri, user, tag string
+	}{
+		{"\"Alice\" <sip:alice@example.org;transport=udp>;tag=1",
+			"Alice", "sip:alice@example.org;transport=udp", "alice", "1"},
+		{"Bob <sips:+33123@example.org>",
+			"Bob", "sips:+33123@example.org", "+33123", ""},
+		{"sip:example.org;tag=2",
+			"", "sip:example.org", "", "2"},
+	}
+	for _, test := range tests {
+		display, uri := SplitAddress(test.value)
+		if display != test.display || uri != test.uri {
+			t.Errorf("%v: got %q %q", test.value, display, uri)
+		}
+		if user := URIUser(uri); user != test.user {
+			t.Errorf("%v: got user %q", test.value, user)
+		}
+		if tag := Param(test.value, "tag"); tag != test.tag {
+			t.Errorf("%v: got tag %q", test.value, tag)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package sip
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Guessing PINs is made expensive in three ways: a call is hung up after
+// maxPINAttempts wrong PINs, an address from which maxAddressFailures
+// wrong PINs were entered recently may no longer call, and when
+// maxGlobalFailures wrong PINs were entered recently, no PINs are
+// accepted at all until the failures expire.  Since all the calls of
+// a SIP trunk come from the same address, a single caller guessing PINs
+// through a trunk locks out all of its callers.
+
+const (
+	// the time after which a wrong PIN is forgotten
+	failureWindow = 10 * time.Minute
+	// the number of wrong PINs after which an address is locked out
+	maxAddressFailures = 20
+	// the number of wrong PINs after which all PIN entry is suspended
+	maxGlobalFailures = 100
+)
+
+type pinLimiter struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	global   []time.Time
+}
+
+// expire removes the failures older than failureWindow from times.
+func expire(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= failureWindow {
+		i++
+	}
+	return times[i:]
+}
+
+// allowed returns true if PINs entered from ip may be checked.
+func (l *pinLimiter) allowed(ip net.IP, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.global = expire(l.global, now)
+	if len(l.global) >= maxGlobalFailures {
+		return false
+	}
+	key := ip.String()
+	f := expire(l.failures[key], now)
+	if len(f) == 0 {
+		delete(l.failures, key)
+		return true
+	}
+	l.failures[key] = f
+	return len(f) < maxAddressFailures
+}
+
+// failed records that a wrong PIN was entered from ip.
+func (l *pinLimiter) failed(ip net.IP, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failures == nil {
+		l.failures = make(map[string][]time.Time)
+	}
+	key := ip.String()
+	f := append(expire(l.failures[key], now), now)
+	if len(f) > maxAddressFailures {
+		f = f[len(f)-maxAddressFailures:]
+	}
+	l.failures[key] = f
+	if len(f) == maxAddressFailures {
+		log.Printf("SIP: %v: too many wrong PINs, locked out", key)
+	}
+
+	l.global = append(expire(l.global, now), now)
+	if len(l.global) > maxGlobalFailures {
+		l.global = l.global[len(l.global)-maxGlobalFailures:]
+	}
+	if len(l.global) == maxGlobalFailures {
+		log.Printf("SIP: too many wrong PINs, suspending dial-in")
+	}
+}
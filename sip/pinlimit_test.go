@@ -0,0 +1,44 @@
+package sip
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPINLimiter(t *testing.T) {
+	var l pinLimiter
+	now := time.Now()
+	a := net.ParseIP("192.0.2.1")
+	b := net.ParseIP("192.0.2.2")
+
+	for i := 0; i < maxAddressFailures; i++ {
+		if !l.allowed(a, now) {
+			t.Fatalf("Locked out after %v failures", i)
+		}
+		l.failed(a, now)
+	}
+	if l.allowed(a, now) {
+		t.Errorf("Not locked out")
+	}
+	if !l.allowed(b, now) {
+		t.Errorf("Other address locked out")
+	}
+	if !l.allowed(a, now.Add(failureWindow)) {
+		t.Errorf("Failures didn't expire")
+	}
+	if len(l.failures) != 0 {
+		t.Errorf("Expired failures kept: %v", l.failures)
+	}
+
+	for i := 0; i < maxGlobalFailures; i++ {
+		ip := net.IPv4(198, 51, 100, byte(i))
+		l.failed(ip, now)
+	}
+	if l.allowed(b, now) {
+		t.Errorf("Not globally locked out")
+	}
+	if !l.allowed(b, now.Add(failureWindow)) {
+		t.Errorf("Global failures didn't expire")
+	}
+}
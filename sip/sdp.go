@@ -0,0 +1,177 @@
+package sip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+)
+
+// media describes the audio stream of a call.
+type media struct {
+	codec webrtc.RTPCodecCapability
+	pt    uint8
+	// the payload type and clock rate of RFC 4733 events, -1 if none
+	dtmfPT   int
+	dtmfRate uint32
+	// the address to which the caller expects to receive RTP
+	remote *net.UDPAddr
+}
+
+var errNoCodec = errors.New("no supported codec")
+
+// the codecs that we accept, in order of preference
+var sipCodecs = []webrtc.RTPCodecCapability{
+	{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+	{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000, Channels: 1},
+	{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000, Channels: 1},
+}
+
+// rtpmap returns the encoding name and clock rate of a payload type.
+func rtpmap(m *sdp.MediaDescription, format string) (string, uint32) {
+	for _, a := range m.Attributes {
+		if a.Key != "rtpmap" {
+			continue
+		}
+		pt, value, found := strings.Cut(a.Value, " ")
+		if !found || pt != format {
+			continue
+		}
+		fields := strings.Split(value, "/")
+		if len(fields) < 2 {
+			return "", 0
+		}
+		rate, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return "", 0
+		}
+		return strings.ToLower(fields[0]), uint32(rate)
+	}
+	// static payload types, RFC 3551
+	switch format {
+	case "0":
+		return "pcmu", 8000
+	case "8":
+		return "pcma", 8000
+	}
+	return "", 0
+}
+
+// negotiate chooses the audio codec of a call given the SDP offer.
+func negotiate(offer []byte) (*media, error) {
+	var s sdp.SessionDescription
+	err := s.Unmarshal(offer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range s.MediaDescriptions {
+		if m.MediaName.Media != "audio" || m.MediaName.Port.Value == 0 {
+			continue
+		}
+		proto := strings.Join(m.MediaName.Protos, "/")
+		if proto != "RTP/AVP" && proto != "RTP/AVPF" {
+			continue
+		}
+
+		ci := m.ConnectionInformation
+		if ci == nil {
+			ci = s.ConnectionInformation
+		}
+		if ci == nil || ci.Address == nil {
+			return nil, errors.New("no connection address")
+		}
+		ip := net.ParseIP(ci.Address.Address)
+		if ip == nil {
+			return nil, errors.New("bad connection address")
+		}
+
+		result := media{dtmfPT: -1}
+		best := len(sipCodecs)
+		for _, f := range m.MediaName.Formats {
+			pt, err := strconv.ParseUint(f, 10, 7)
+			if err != nil {
+				continue
+			}
+			name, rate := rtpmap(m, f)
+			for i, c := range sipCodecs {
+				if i >= best {
+					break
+				}
+				if strings.EqualFold(name, c.MimeType[6:]) &&
+					rate == c.ClockRate {
+					best = i
+					result.codec = c
+					result.pt = uint8(pt)
+				}
+			}
+		}
+		if best >= len(sipCodecs) {
+			return nil, errNoCodec
+		}
+
+		for _, f := range m.MediaName.Formats {
+			pt, err := strconv.ParseUint(f, 10, 7)
+			if err != nil {
+				continue
+			}
+			name, rate := rtpmap(m, f)
+			if name != "telephone-event" {
+				continue
+			}
+			if result.dtmfPT < 0 || rate == result.codec.ClockRate {
+				result.dtmfPT = int(pt)
+				result.dtmfRate = rate
+			}
+		}
+
+		result.remote = &net.UDPAddr{
+			IP:   ip,
+			Port: m.MediaName.Port.Value,
+		}
+		return &result, nil
+	}
+	return nil, errNoCodec
+}
+
+// answer returns the SDP answer for a call that receives RTP at the given
+// address.
+func (m *media) answer(id uint64, local *net.UDPAddr) []byte {
+	family := "IP4"
+	if local.IP.To4() == nil {
+		family = "IP6"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=galene %v %v IN %v %v\r\n", id, id, family, local.IP)
+	fmt.Fprintf(&b, "s=Galene\r\n")
+	fmt.Fprintf(&b, "c=IN %v %v\r\n", family, local.IP)
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "m=audio %v RTP/AVP %v", local.Port, m.pt)
+	if m.dtmfPT >= 0 {
+		fmt.Fprintf(&b, " %v", m.dtmfPT)
+	}
+	fmt.Fprintf(&b, "\r\n")
+	channels := ""
+	if m.codec.Channels > 1 {
+		channels = fmt.Sprintf("/%v", m.codec.Channels)
+	}
+	fmt.Fprintf(&b, "a=rtpmap:%v %v/%v%v\r\n",
+		m.pt, m.codec.MimeType[6:], m.codec.ClockRate, channels)
+	if strings.EqualFold(m.codec.MimeType, webrtc.MimeTypeOpus) {
+		fmt.Fprintf(&b, "a=fmtp:%v useinbandfec=1\r\n", m.pt)
+	}
+	if m.dtmfPT >= 0 {
+		fmt.Fprintf(&b, "a=rtpmap:%v telephone-event/%v\r\n",
+			m.dtmfPT, m.dtmfRate)
+		fmt.Fprintf(&b, "a=fmtp:%v 0-15\r\n", m.dtmfPT)
+	}
+	fmt.Fprintf(&b, "a=ptime:20\r\n")
+	fmt.Fprintf(&b, "a=sendrecv\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,110 @@
+package sip
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		offer  string
+		mime   string
+		pt     uint8
+		dtmfPT int
+	}{
+		{
+			"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\n" +
+				"c=IN IP4 192.0.2.2\r\nt=0 0\r\n" +
+				"m=audio 4000 RTP/AVP 8 0 101\r\n" +
+				"a=rtpmap:101 telephone-event/8000\r\n",
+			webrtc.MimeTypePCMU, 0, 101,
+		},
+		{
+			"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\nt=0 0\r\n" +
+				"m=audio 4000 RTP/AVP 0 96 97 98\r\n" +
+				"c=IN IP4 192.0.2.2\r\n" +
+				"a=rtpmap:96 opus/48000/2\r\n" +
+				"a=rtpmap:97 telephone-event/8000\r\n" +
+				"a=rtpmap:98 telephone-event/48000\r\n",
+			webrtc.MimeTypeOpus, 96, 98,
+		},
+		{
+			"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\n" +
+				"c=IN IP4 192.0.2.2\r\nt=0 0\r\n" +
+				"m=audio 4000 RTP/AVP 8\r\n",
+			webrtc.MimeTypePCMA, 8, -1,
+		},
+	}
+	for i, test := range tests {
+		m, err := negotiate([]byte(test.offer))
+		if err != nil {
+			t.Errorf("%v: %v", i, err)
+			continue
+		}
+		if m.codec.MimeType != test.mime || m.pt != test.pt ||
+			m.dtmfPT != test.dtmfPT {
+			t.Errorf("%v: got %v %v %v", i,
+				m.codec.MimeType, m.pt, m.dtmfPT)
+		}
+		if m.remote.String() != "192.0.2.2:4000" {
+			t.Errorf("%v: got %v", i, m.remote)
+		}
+	}
+}
+
+func TestNegotiateFail(t *testing.T) {
+	tests := []string{
+		"garbage",
+		// no supported codec
+		"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\n" +
+			"c=IN IP4 192.0.2.2\r\nt=0 0\r\n" +
+			"m=audio 4000 RTP/AVP 18\r\n",
+		// encrypted
+		"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\n" +
+			"c=IN IP4 192.0.2.2\r\nt=0 0\r\n" +
+			"m=audio 4000 RTP/SAVP 0\r\n",
+		// no connection address
+		"v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\nt=0 0\r\n" +
+			"m=audio 4000 RTP/AVP 0\r\n",
+	}
+	for _, test := range tests {
+		_, err := negotiate([]byte(test))
+		if err == nil {
+			t.Errorf("negotiate %q succeeded", test)
+		}
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	offer := "v=0\r\no=- 1 1 IN IP4 192.0.2.2\r\ns=-\r\n" +
+		"c=IN IP4 192.0.2.2\r\nt=0 0\r\n" +
+		"m=audio 4000 RTP/AVP 111 101\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\n" +
+		"a=rtpmap:101 telephone-event/48000\r\n"
+	m, err := negotiate([]byte(offer))
+	if err != nil {
+		t.Fatalf("negotiate: %v", err)
+	}
+
+	local := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}
+	answer := m.answer(42, local)
+	if !strings.Contains(string(answer), "a=rtpmap:111 opus/48000/2\r\n") {
+		t.Errorf("No rtpmap in %q", answer)
+	}
+
+	// the answer is itself acceptable as an offer
+	a, err := negotiate(answer)
+	if err != nil {
+		t.Fatalf("negotiate answer: %v", err)
+	}
+	if a.codec.MimeType != m.codec.MimeType || a.pt != m.pt || a.dtmfPT != m.dtmfPT ||
+		a.dtmfRate != m.dtmfRate {
+		t.Errorf("Got %v, expected %v", a, m)
+	}
+	if a.remote.String() != local.String() {
+		t.Errorf("Got %v, expected %v", a.remote, local)
+	}
+}
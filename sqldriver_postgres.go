@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+// Link the PostgreSQL driver, which is used by the sql group store when
+// its driver is "postgres".
+import (
+	_ "github.com/lib/pq"
+)
@@ -57,6 +57,20 @@ let pwAuth = false;
  */
 let token = null;
 
+/**
+ * True if we are joining using a session cookie.
+ *
+ * @type {boolean}
+ */
+let sessionAuth = false;
+
+/**
+ * True if we have tried to reconnect since we last lost the connection.
+ *
+ * @type {boolean}
+ */
+let reconnecting = false;
+
 /**
  * The state of the login automaton.
  *
@@ -376,6 +390,11 @@ function setConnected(connected) {
     }
 }
 
+/**
+ * The clock offset above which we warn the user, in milliseconds.
+ */
+const maxClockOffset = 60 * 1000;
+
 /**
  * Called when we connect to the server.
  *
@@ -383,6 +402,16 @@ function setConnected(connected) {
  */
 async function gotConnected() {
     setConnected(true);
+    if(Math.abs(serverConnection.clockOffset) > maxClockOffset) {
+        let s = Math.round(Math.abs(serverConnection.clockOffset) / 1000);
+        displayWarning(
+            `Your clock is ${s} seconds ` +
+                (serverConnection.clockOffset > 0 ? 'behind' : 'ahead of') +
+                ' the server\'s, some features may not work correctly.'
+        );
+    }
+    if(quietNotifications.length > 0)
+        sendNotifications();
     await join();
 }
 
@@ -406,6 +435,78 @@ function setChangePassword(username) {
     }
 }
 
+/**
+ * Shows or hides the notice displayed to users that are held back while
+ * the group is in rehearsal mode.
+ *
+ * @param {Object} status
+ */
+function setRehearsal(status) {
+    let held = !!(status && status.rehearsal) && serverConnection &&
+        serverConnection.permissions.indexOf('present') < 0 &&
+        serverConnection.permissions.indexOf('op') < 0;
+    setVisibility('rehearsal', held);
+}
+
+/**
+ * Marks the user holding the floor in the user list.
+ *
+ * @param {Object} status
+ */
+function setFloor(status) {
+    let floor = (status && status.floor) || null;
+    let users = document.getElementById('users');
+    for(let i = 0; i < users.children.length; i++) {
+        let elt = users.children[i];
+        if(floor && elt.id === 'user-' + floor)
+            elt.classList.add('user-status-floor');
+        else
+            elt.classList.remove('user-status-floor');
+    }
+}
+
+/**
+ * The files in the group's drop box.
+ *
+ * @type {Array<Object>}
+ */
+let sharedFiles = [];
+
+/**
+ * The ids of the drop box files that have already been announced.
+ *
+ * @type {Set<string>}
+ */
+let announcedFiles = new Set();
+
+/**
+ * @param {Object} file
+ * @returns {HTMLDivElement}
+ */
+function formatFile(file) {
+    let url = new URL('.files/' + encodeURIComponent(file.id), location.href);
+    if(file.access)
+        url.searchParams.set('access', file.access);
+    let by = file.username ? ` by ${file.username}` : '';
+    return formatText(`${file.name} (shared${by}): ${url.href}`);
+}
+
+/**
+ * Announces the drop box files that haven't been announced yet.
+ *
+ * @param {Object} status
+ */
+function setFiles(status) {
+    let files = (status && status.files) || [];
+    sharedFiles = files;
+    files.forEach(file => {
+        if(announcedFiles.has(file.id))
+            return;
+        announcedFiles.add(file.id);
+        localMessage(formatFile(file));
+    });
+}
+
 /**
  * Join a group.
  */
@@ -444,7 +545,11 @@ async function join() {
         }
         let pw = getInputElement('password').value;
         getInputElement('password').value = '';
-        if(!groupStatus.authServer) {
+        sessionAuth = !pw && !!username && sessionUsername() === username;
+        if(sessionAuth) {
+            pwAuth = true;
+            credentials = {type: 'session'};
+        } else if(!groupStatus.authServer) {
             pwAuth = true;
             credentials = pw;
         } else {
@@ -467,6 +572,54 @@ async function join() {
     }
 }
 
+/**
+ * Returns the username associated with the session cookie for the
+ * current group, if any.
+ *
+ * @returns {string}
+ */
+function sessionUsername() {
+    try {
+        return window.localStorage.getItem('session:' + group);
+    } catch(e) {
+        console.warn("Couldn't access local storage:", e);
+        return null;
+    }
+}
+
+/**
+ * Asks the server to store a session in a cookie, so that we can rejoin
+ * the group without a password.
+ *
+ * @param {string} session
+ * @param {string} username
+ */
+async function saveSession(session, username) {
+    try {
+        let r = await fetch('.session', {
+            method: 'POST',
+            body: session,
+        });
+        if(!r.ok)
+            throw new Error(`${r.status} ${r.statusText}`);
+        window.localStorage.setItem('session:' + group, username);
+    } catch(e) {
+        console.warn("Couldn't save session:", e);
+    }
+}
+
+/**
+ * Discards the session cookie for the current group.
+ */
+async function forgetSession() {
+    try {
+        window.localStorage.removeItem('session:' + group);
+        await fetch('.session', {method: 'DELETE'});
+    } catch(e) {
+        console.warn("Couldn't discard session:", e);
+    }
+}
+
 /**
  * @this {ServerConnection}
  */
@@ -498,6 +651,44 @@ function gotClose(code, reason) {
     if(!(form instanceof HTMLFormElement))
         throw new Error('Bad type for loginform');
     form.active = true;
+    if(code != 1000 && !reconnecting && sessionUsername()) {
+        // try once to rejoin silently
+        reconnecting = true;
+        setTimeout(serverConnect, 2000);
+    }
+}
+
+/**
+ * Publishes a copy of an up stream with video encoded using the given
+ * codec, for the benefit of receivers that cannot decode the original.
+ *
+ * @this {ServerConnection}
+ * @param {Stream} c
+ * @param {string} codec
+ */
+function gotFallback(c, codec) {
+    if(!c.stream || !RTCRtpReceiver.getCapabilities)
+        return;
+    let caps = RTCRtpReceiver.getCapabilities('video');
+    let codecs = caps ? caps.codecs.filter(
+        cc => cc.mimeType.toLowerCase() === codec.toLowerCase(),
+    ) : [];
+    if(codecs.length === 0) {
+        console.warn(`Cannot send fallback using ${codec}`);
+        return;
+    }
+    let cf = newUpStream();
+    cf.label = c.label;
+    cf.fallback = c.id;
+    cf.setStream(c.stream);
+    c.stream.getTracks().forEach(t => {
+        let tr = cf.pc.addTransceiver(t, {
+            direction: 'sendonly',
+            streams: [c.stream],
+        });
+        if(t.kind === 'video')
+            tr.setCodecPreferences(codecs);
+    });
 }
 
 /**
@@ -592,7 +783,7 @@ function setButtonsVisibility() {
     let canShare = canWebrtc &&
         ('mediaDevices' in navigator) &&
         ('getDisplayMedia' in navigator.mediaDevices) &&
-        permissions.indexOf('present') >= 0;
+        canScreenshare(permissions);
     let local = !!findUpMedia('camera');
     let mediacount = document.getElementById('peers').childElementCount;
     let mobilelayout = isMobileLayout();
@@ -723,19 +914,51 @@ getSelectElement('filterselect').onchange = async function(e) {
  */
 function getMaxVideoThroughput() {
     let v = getSettings().send;
+    let bps;
     switch(v) {
     case 'lowest':
-        return 150000;
+        bps = 150000;
+        break;
     case 'low':
-        return 300000;
+        bps = 300000;
+        break;
     case 'normal':
-        return 700000;
+        bps = 700000;
+        break;
     case 'unlimited':
-        return null;
+        bps = null;
+        break;
     default:
         console.error('Unknown video quality', v);
-        return 700000;
+        bps = 700000;
+        break;
     }
+    // don't send more than the server is willing to accept
+    let caps = serverConnection && serverConnection.capabilities;
+    if(caps && caps.maxBitrate && (!bps || bps > caps.maxBitrate))
+        bps = caps.maxBitrate;
+    return bps;
+}
+
+/**
+ * Returns the factor by which a video track must be scaled down in order
+ * to fit within the dimensions accepted by the server, and the maximum
+ * framerate accepted by the server, or null if unlimited.
+ *
+ * @param {MediaStreamTrack} t
+ * @returns {{scale: number, framerate: number}}
+ */
+function videoLimits(t) {
+    let caps = serverConnection && serverConnection.capabilities;
+    let scale = 1;
+    if(!caps)
+        return {scale: scale, framerate: null};
+    let s = t.getSettings();
+    if(caps.maxVideoWidth && s.width > caps.maxVideoWidth)
+        scale = Math.max(scale, s.width / caps.maxVideoWidth);
+    if(caps.maxVideoHeight && s.height > caps.maxVideoHeight)
+        scale = Math.max(scale, s.height / caps.maxVideoHeight);
+    return {scale: scale, framerate: caps.maxVideoFramerate || null};
 }
 
 getSelectElement('sendselect').onchange = async function(e) {
@@ -793,11 +1016,26 @@ function mapRequestLabel(what, label) {
 }
 
 
+/**
+ * downlinkEstimate returns the browser's estimate of the downlink
+ * bandwidth in bits per second, or 0 if unknown.
+ *
+ * @returns {number}
+ */
+function downlinkEstimate() {
+    /** @type {any} */
+    let nav = navigator;
+    if(nav.connection && typeof nav.connection.downlink === 'number')
+        return Math.round(nav.connection.downlink * 1000000);
+    return 0;
+}
+
 getSelectElement('requestselect').onchange = function(e) {
     e.preventDefault();
     if(!(this instanceof HTMLSelectElement))
         throw new Error('Unexpected type for this');
     updateSettings({request: this.value});
+    serverConnection.setAudioOnly(this.value === 'audio');
     serverConnection.request(mapRequest(this.value));
     reconsiderDownRate();
 };
@@ -1087,6 +1325,8 @@ async function reconsiderSendParameters() {
     let promises = [];
     for(let id in serverConnection.up) {
         let c = serverConnection.up[id];
+        if(c.fallback)
+            continue;
         promises.push(setSendParameters(c, t, s));
     }
     await Promise.all(promises);
@@ -1565,6 +1805,7 @@ async function setUpStream(c, stream) {
         let simulcast = c.label !== 'screenshare' && doSimulcast();
         if(t.kind === 'video') {
             let bps = getMaxVideoThroughput();
+            let limits = videoLimits(t);
             // Firefox doesn't like us setting the RID if we're not
             // simulcasting.
             if(simulcast) {
@@ -1574,7 +1815,7 @@ async function setUpStream(c, stream) {
                 });
                 encodings.push({
                     rid: 'l',
-                    scaleResolutionDownBy: 2,
+                    scaleResolutionDownBy: 2 * limits.scale,
                     maxBitrate: simulcastRate,
                 });
             } else {
@@ -1582,6 +1823,11 @@ async function setUpStream(c, stream) {
                     maxBitrate: bps || unlimitedRate,
                 });
             }
+            // stay within the limits of the group
+            if(limits.scale > 1)
+                encodings[0].scaleResolutionDownBy = limits.scale;
+            if(limits.framerate)
+                encodings.forEach(e => e.maxFramerate = limits.framerate);
         } else {
             if(settings.hqaudio) {
                 encodings.push({
@@ -1689,7 +1935,7 @@ async function replaceUpStreams(label) {
     let promises = [];
     for(let id in serverConnection.up) {
         let c = serverConnection.up[id];
-        if(label && c.label !== label)
+        if(c.fallback || (label && c.label !== label))
             continue
         promises.push(replaceUpStream(c));
     }
@@ -1909,7 +2155,7 @@ function findUpMedia(label) {
         return null;
     for(let id in serverConnection.up) {
         let c = serverConnection.up[id];
-        if(c.label === label)
+        if(c.label === label && !c.fallback)
             return c;
     }
     return null;
@@ -2106,11 +2352,64 @@ async function setMedia(c, mirror, video) {
 
     setLabel(c);
     setMediaStatus(c);
+    setWatermark(c);
 
     showVideo();
     resizePeers();
 }
 
+/**
+ * Returns true if the server asked us to protect the group's contents.
+ *
+ * @returns {boolean}
+ */
+function privacyMode() {
+    let caps = serverConnection && serverConnection.capabilities;
+    return !!(caps && caps.privacy);
+}
+
+/**
+ * Returns true if a user with the given permissions may share their
+ * screen.  This mirrors the check performed by the server.
+ *
+ * @param {Array<string>} permissions
+ * @returns {boolean}
+ */
+function canScreenshare(permissions) {
+    if(permissions.indexOf('present') < 0)
+        return false;
+    let caps = serverConnection && serverConnection.capabilities;
+    if(!caps || !caps.restrictScreenshare)
+        return true;
+    return permissions.indexOf('op') >= 0 ||
+        permissions.indexOf('screenshare') >= 0;
+}
+
+/**
+ * In privacy mode, overlays the viewer's name over a video, so that
+ * screenshots can be traced back to the user who took them.
+ *
+ * @param {Stream} c
+ */
+function setWatermark(c) {
+    let div = document.getElementById('peer-' + c.localId);
+    if(!div)
+        return;
+    let watermark = document.getElementById('watermark-' + c.localId);
+    if(!privacyMode()) {
+        if(watermark)
+            watermark.remove();
+        return;
+    }
+    if(!watermark) {
+        watermark = document.createElement('div');
+        watermark.id = 'watermark-' + c.localId;
+        watermark.classList.add('watermark');
+        div.appendChild(watermark);
+    }
+    watermark.textContent = serverConnection.username || '';
+}
+
 
 /**
  * @param {Stream} c
@@ -2374,7 +2673,11 @@ function setLabel(c, fallback) {
     if(!label)
         return;
     let l = c.username;
-    if(l) {
+    if(l && typeof c.userdata.viewers === 'number') {
+        let v = c.userdata.viewers;
+        label.textContent = `${l} (${v} ${v === 1 ? 'viewer' : 'viewers'})`;
+        label.classList.remove('label-fallback');
+    } else if(l) {
         label.textContent = l;
         label.classList.remove('label-fallback');
     } else if(fallback) {
@@ -2391,7 +2694,7 @@ function resizePeers() {
     if (!serverConnection)
         return;
     let count =
-        Object.keys(serverConnection.up).length +
+        Object.values(serverConnection.up).filter(c => !c.fallback).length +
         Object.keys(serverConnection.down).length;
     let peers = document.getElementById('peers');
     let columns = Math.ceil(Math.sqrt(count));
@@ -2560,9 +2863,10 @@ function userMenu(elt) {
             items.push({label: 'Broadcast file', onClick: presentFile});
         items.push({label: 'Restart media', onClick: renegotiateStreams});
     } else {
-        items.push({label: 'Send file', onClick: () => {
-            sendFile(id);
-        }});
+        if(!privacyMode())
+            items.push({label: 'Send file', onClick: () => {
+                sendFile(id);
+            }});
         if(serverConnection.permissions.indexOf('op') >= 0) {
             items.push({type: 'seperator'}); // sic
             if(user.permissions.indexOf('present') >= 0)
@@ -2573,6 +2877,18 @@ function userMenu(elt) {
                 items.push({label: 'Allow presenting', onClick: () => {
                     serverConnection.userAction('present', id);
                 }});
+            let caps = serverConnection.capabilities;
+            if(caps && caps.restrictScreenshare &&
+               user.permissions.indexOf('op') < 0) {
+                if(user.permissions.indexOf('screenshare') >= 0)
+                    items.push({label: 'Forbid screen sharing', onClick: () => {
+                        serverConnection.userAction('unscreenshare', id);
+                    }});
+                else
+                    items.push({label: 'Allow screen sharing', onClick: () => {
+                        serverConnection.userAction('screenshare', id);
+                    }});
+            }
             items.push({label: 'Mute', onClick: () => {
                 serverConnection.userMessage('mute', id);
             }});
@@ -2796,6 +3112,10 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         if(probingState === 'probing' && error === 'need-username') {
             probingState = 'need-username';
             setVisibility('passwordform', false);
+        } else if(sessionAuth) {
+            // the session has expired or has been revoked
+            sessionAuth = false;
+            forgetSession();
         } else {
             token = null;
             displayError('The server said: ' + message);
@@ -2815,6 +3135,9 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         this.close();
         setButtonsVisibility();
         setChangePassword(null);
+        setVisibility('rehearsal', false);
+        sharedFiles = [];
+        announcedFiles = new Set();
         return;
     case 'join':
     case 'change':
@@ -2838,6 +3161,13 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         setChangePassword(pwAuth && !!groupStatus.canChangePassword &&
                           serverConnection.username
         );
+        setRehearsal(status);
+        setFloor(status);
+        setFiles(status);
+        if(kind === 'join')
+            reconnecting = false;
+        if(kind === 'join' && serverConnection.session)
+            saveSession(serverConnection.session, serverConnection.username);
         openSafariStream();
         if(kind === 'change')
             return;
@@ -2858,10 +3188,18 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
     if(status.locked)
         displayWarning('This group is locked');
 
+    if(privacyMode())
+        displayWarning('This group is in privacy mode, ' +
+                       'please do not capture its contents');
+
     if(typeof RTCPeerConnection === 'undefined')
         displayWarning("This browser doesn't support WebRTC");
-    else
-        this.request(mapRequest(getSettings().request));
+    else {
+        // otherwise, leave the group's default
+        if(getSettings().request === 'audio')
+            this.setAudioOnly(true);
+        this.request(mapRequest(getSettings().request), downlinkEstimate());
+    }
 
     if(('mediaDevices' in navigator) &&
        ('getUserMedia' in navigator.mediaDevices) &&
@@ -2893,6 +3231,10 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
  * @param {TransferredFile} f
  */
 function gotFileTransfer(f) {
+    if(privacyMode()) {
+        f.cancel('file transfer is disabled in this group');
+        return;
+    }
     f.onevent = gotFileTransferEvent;
     let p = document.createElement('p');
     if(f.up)
@@ -3088,6 +3430,16 @@ function gotUserMessage(id, dest, username, time, privileged, kind, error, messa
         let from = id ? (username || 'Anonymous') : 'The Server';
         displayError(`${from} said: ${message}`, kind);
         break;
+    case 'announcement': {
+        if(!privileged) {
+            console.error(`Got unprivileged message of kind ${kind}`);
+            return;
+        }
+        let from = username ? ` from ${username}` : '';
+        displayWarning(`Announcement${from}: ${message}`);
+        localMessage(`Announcement${from}: ${message}`);
+        break;
+    }
     case 'mute':
         if(!privileged) {
             console.error(`Got unprivileged message of kind ${kind}`);
@@ -3163,6 +3515,31 @@ function gotUserMessage(id, dest, username, time, privileged, kind, error, messa
             'unknown address';
         localMessage(`User ${message.id} has ${u} and ${a}.`);
         break;
+    case 'candidate-pair':
+        if(!privileged) {
+            console.error(`Got unprivileged message of kind ${kind}`);
+            return;
+        }
+        console.info(`Stream ${message.id} switched to ` +
+                     `${message.remote} candidate over ${message.protocol}`);
+        break;
+    case 'viewers':
+        if(!privileged) {
+            console.error(`Got unprivileged message of kind ${kind}`);
+            return;
+        }
+        if(!Array.isArray(message)) {
+            console.error('Unexpected type for viewers');
+            return;
+        }
+        message.forEach(v => {
+            let c = serverConnection.up[v.id];
+            if(!c)
+                return;
+            c.userdata.viewers = v.viewers;
+            setLabel(c);
+        });
+        break;
     default:
         console.warn(`Got unknown user message ${kind}`);
         break;
@@ -3533,6 +3910,13 @@ function recordingPredicate() {
     return 'You are not allowed to record';
 }
 
+function presentPredicate() {
+    if(serverConnection && serverConnection.permissions &&
+       serverConnection.permissions.indexOf('present') >= 0)
+        return null;
+    return 'You are not allowed to present';
+}
+
 commands.help = {
     description: 'display this help',
     f: (c, r) => {
@@ -3620,11 +4004,38 @@ commands.unlock = {
     }
 };
 
+commands.rehearsal = {
+    predicate: operatorPredicate,
+    description: 'enter rehearsal mode, only presenters receive media',
+    f: (c, r) => {
+        serverConnection.groupAction('rehearsal', true);
+    }
+};
+
+commands.live = {
+    predicate: operatorPredicate,
+    description: 'leave rehearsal mode, revert the effect of /rehearsal',
+    f: (c, r) => {
+        serverConnection.groupAction('rehearsal', false);
+    }
+};
+
 commands.record = {
+    parameters: '[webm|mp4] [muxed|tracks]',
     predicate: recordingPredicate,
     description: 'start recording',
     f: (c, r) => {
-        serverConnection.groupAction('record');
+        let format = undefined, layout = undefined;
+        for(let w of r.trim().split(/\s+/)) {
+            if(w === 'muxed' || w === 'tracks')
+                layout = w;
+            else if(w)
+                format = w;
+        }
+        if(layout)
+            serverConnection.groupAction('record', {format, layout});
+        else
+            serverConnection.groupAction('record', format);
     }
 };
 
@@ -3907,6 +4318,48 @@ function userMessage(c, r) {
     serverConnection.userMessage(c, id, p[1]);
 }
 
+commands.takefloor = {
+    predicate: presentPredicate,
+    description: 'take the floor in a group with floor control',
+    f: (c, r) => {
+        serverConnection.groupAction('takefloor');
+    }
+};
+
+commands.releasefloor = {
+    description: 'release the floor, revert the effect of /takefloor',
+    f: (c, r) => {
+        serverConnection.groupAction('releasefloor');
+    }
+};
+
+commands.givefloor = {
+    parameters: '[user]',
+    description: 'give the floor to a user, or free it',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let p = parseCommand(r);
+        let id = '';
+        if(p[0]) {
+            id = findUserId(p[0]);
+            if(!id)
+                throw new Error(`Unknown user ${p[0]}`);
+        }
+        serverConnection.groupAction('givefloor', id);
+    }
+};
+
+commands.files = {
+    description: 'list the shared files',
+    f: (c, r) => {
+        if(sharedFiles.length === 0) {
+            localMessage('No shared files');
+            return;
+        }
+        sharedFiles.forEach(file => localMessage(formatFile(file)));
+    }
+};
+
 commands.kick = {
     parameters: 'user [message]',
     description: 'kick out a user',
@@ -3949,6 +4402,20 @@ commands.unpresent = {
     f: userCommand,
 };
 
+commands.screenshare = {
+    parameters: 'user',
+    description: 'give user the right to share their screen',
+    predicate: operatorPredicate,
+    f: userCommand,
+};
+
+commands.unscreenshare = {
+    parameters: 'user',
+    description: 'revoke the right to share the screen',
+    predicate: operatorPredicate,
+    f: userCommand,
+};
+
 commands.shutup = {
     parameters: 'user',
     description: 'revoke the right to send chat messages',
@@ -3963,6 +4430,31 @@ commands.unshutup = {
     f: userCommand,
 };
 
+commands.bandwidth = {
+    parameters: 'user [up [down]]',
+    description: 'limit the bandwidth of a user, in kbit/s (0 for unlimited)',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let p = parseCommand(r);
+        if(!p[0])
+            throw new Error(`/${c} requires parameters`);
+        let id = findUserId(p[0]);
+        if(!id)
+            throw new Error(`Unknown user ${p[0]}`);
+        let rates = p[1].split(/\s+/).filter(s => s);
+        if(rates.length > 2)
+            throw new Error(`/${c} requires at most two rates`);
+        let value = {};
+        for(let i = 0; i < rates.length; i++) {
+            let v = parseFloat(rates[i]);
+            if(isNaN(v) || v < 0)
+                throw new Error(`Bad rate ${rates[i]}`);
+            value[i === 0 ? 'up' : 'down'] = Math.round(v * 1000);
+        }
+        serverConnection.userAction('bandwidth', id, value);
+    },
+};
+
 commands.mute = {
     parameters: 'user',
     description: 'mute a remote user',
@@ -4016,6 +4508,53 @@ commands.unraise = {
     }
 }
 
+/**
+ * The classes of events that the server is asked not to send.
+ *
+ * @type {Array<string>}
+ */
+let quietNotifications = [];
+
+const notificationClasses = ['users', 'raisehand', 'chat'];
+
+function sendNotifications() {
+    /** @type {Object<string,boolean>} */
+    let n = {};
+    for(let k of notificationClasses)
+        n[k] = quietNotifications.indexOf(k) < 0;
+    // the server always sends users joining and leaving, we only stop
+    // announcing them.
+    document.getElementById('users').setAttribute(
+        'aria-live', n.users ? 'polite' : 'off',
+    );
+    serverConnection.setNotifications(n);
+}
+
+commands.quiet = {
+    parameters: '[users] [raisehand] [chat]',
+    description: 'stop notifications of joins, raised hands or public chat',
+    f: (c, r) => {
+        let p = r.trim() ? r.trim().split(/\s+/) : notificationClasses;
+        for(let k of p) {
+            if(notificationClasses.indexOf(k) < 0)
+                throw new Error(`Unknown notification class ${k}`);
+        }
+        for(let k of p) {
+            if(quietNotifications.indexOf(k) < 0)
+                quietNotifications.push(k);
+        }
+        sendNotifications();
+    },
+};
+
+commands.unquiet = {
+    description: 'restore all notifications',
+    f: (c, r) => {
+        quietNotifications = [];
+        sendNotifications();
+    },
+};
+
 /** @returns {boolean} */
 function canFile() {
     let v =
@@ -4085,6 +4624,11 @@ function sendFile(id) {
 commands.sendfile = {
     parameters: 'user',
     description: 'send a file (this will disclose your IP address)',
+    predicate: () => {
+        if(privacyMode())
+            return 'File transfer is disabled in this group';
+        return null;
+    },
     f: (c, r) => {
         let p = parseCommand(r);
         if(!p[0])
@@ -4347,6 +4891,7 @@ document.getElementById('loginform').onsubmit = async function(e) {
 
 document.getElementById('disconnectbutton').onclick = function(e) {
     serverConnection.close();
+    forgetSession();
     closeNav();
 };
 
@@ -4420,9 +4965,13 @@ async function serverConnect() {
     serverConnection.onpeerconnection = onPeerConnection;
     serverConnection.onclose = gotClose;
     serverConnection.ondownstream = gotDownStream;
+    serverConnection.onfallback = gotFallback;
     serverConnection.onuser = gotUser;
     serverConnection.onjoined = gotJoined;
     serverConnection.onchat = addToChatbox;
+    serverConnection.oncaption = function(source, username, time, message) {
+        displayCaption(message);
+    };
     serverConnection.onusermessage = gotUserMessage;
     serverConnection.onfiletransfer = gotFileTransfer;
 
@@ -4479,6 +5028,9 @@ async function start() {
 
     if(token) {
         await serverConnect();
+    } else if(sessionUsername()) {
+        getInputElement('username').value = sessionUsername();
+        await serverConnect();
     } else if(groupStatus.authPortal) {
         window.location.href = groupStatus.authPortal;
     } else {
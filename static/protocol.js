@@ -124,12 +124,33 @@ function ServerConnection() {
      * @type {Object<string,Stream>}
      */
     this.down = {};
+    /**
+     * The set of standby down streams, which carry no media yet, indexed
+     * by their id.
+     *
+     * @type {Object<string,Stream>}
+     */
+    this.standby = {};
     /**
      * The ICE configuration used by all associated streams.
      *
      * @type {RTCConfiguration}
      */
     this.rtcConfiguration = null;
+    /**
+     * The features and limits advertised by the server when joining.
+     *
+     * @type {Object<string,any>}
+     */
+    this.capabilities = null;
+    /**
+     * A session that may be stored in a cookie in order to rejoin the
+     * group without a password.  This is only set after joining with
+     * a password.
+     *
+     * @type {string}
+     */
+    this.session = null;
     /**
      * The permissions granted to this connection.
      *
@@ -149,6 +170,13 @@ function ServerConnection() {
      * @type {number}
      */
     this.lastServerMessage = null;
+    /**
+     * The difference, in milliseconds, between the server's clock and
+     * ours, as measured during the handshake.
+     *
+     * @type {number}
+     */
+    this.clockOffset = 0;
     /**
      * The interval handler which checks for liveness.
      *
@@ -209,12 +237,28 @@ function ServerConnection() {
      * @type{(this: ServerConnection, stream: Stream) => void}
      */
     this.ondownstream = null;
+    /**
+     * onfallback is called when the server asks us to publish a copy of
+     * an up stream with video encoded using a given codec, for the
+     * benefit of receivers that cannot decode the original.  It should
+     * create a new up stream with its fallback field set to the id of
+     * the original stream.
+     *
+     * @type{(this: ServerConnection, stream: Stream, codec: string) => void}
+     */
+    this.onfallback = null;
     /**
      * onchat is called whenever a new chat message is received.
      *
      * @type {(this: ServerConnection, id: string, source: string, dest: string, username: string, time: Date, privileged: boolean, history: boolean, kind: string, message: string) => void}
      */
     this.onchat = null;
+    /**
+     * oncaption is called whenever a caption is received.
+     *
+     * @type {(this: ServerConnection, source: string, username: string, time: Date, message: string) => void}
+     */
+    this.oncaption = null;
     /**
      * onusermessage is called when an application-specific message is
      * received.  Id is null when the message originated at the server,
@@ -271,6 +315,10 @@ function ServerConnection() {
   * @property {string} [label]
   * @property {Object<string,Array<string>>|Array<string>} [request]
   * @property {Object<string,any>} [rtcConfiguration]
+  * @property {Object<string,any>} [capabilities]
+  * @property {Array<string>} [encrypted]
+  * @property {Array<string>} [codecs]
+  * @property {string} [fallback]
   */
 
 /**
@@ -344,6 +392,7 @@ ServerConnection.prototype.connect = function(url) {
                 type: 'handshake',
                 version: ['2'],
                 id: sc.id,
+                time: new Date().toISOString(),
             });
         } catch(e) {
             sc.error(e);
@@ -360,6 +409,10 @@ ServerConnection.prototype.connect = function(url) {
             let c = sc.down[id];
             c.close();
         }
+        for(let id in sc.standby) {
+            let c = sc.standby[id];
+            c.close();
+        }
         for(let id in sc.users) {
             delete(sc.users[id]);
             if(sc.onuser)
@@ -398,13 +451,20 @@ ServerConnection.prototype.connect = function(url) {
                 sc.error(new Error(`Unknown protocol version ${m.version}`));
                 return;
             }
+            let t = parseTime(m.time);
+            if(t)
+                sc.clockOffset = t.getTime() - Date.now();
             if(sc.onconnected)
                 sc.onconnected.call(sc);
             break;
         }
         case 'offer':
             sc.gotOffer(m.id, m.label, m.source, m.username,
-                        m.sdp, m.replace);
+                        m.sdp, m.replace, m.encrypted,
+                        m.kind === 'standby');
+            break;
+        case 'stream':
+            sc.gotStream(m.id, m.label, m.source, m.username, m.encrypted);
             break;
         case 'answer':
             sc.gotAnswer(m.id, m.sdp);
@@ -412,6 +472,12 @@ ServerConnection.prototype.connect = function(url) {
         case 'renegotiate':
             sc.gotRenegotiate(m.id);
             break;
+        case 'audioParams':
+            sc.gotAudioParams(m.id, m.value);
+            break;
+        case 'track':
+            sc.gotTrack(m.id, m.kind, m.value);
+            break;
         case 'close':
             sc.gotClose(m.id);
             break;
@@ -431,6 +497,8 @@ ServerConnection.prototype.connect = function(url) {
                 sc.username = null;
                 sc.permissions = [];
                 sc.rtcConfiguration = null;
+                sc.capabilities = null;
+                sc.session = null;
             } else if(m.kind === 'join' || m.kind == 'change') {
                 if(m.kind === 'join' && sc.group) {
                     throw new Error('Joined multiple groups');
@@ -442,6 +510,9 @@ ServerConnection.prototype.connect = function(url) {
                 sc.username = m.username;
                 sc.permissions = m.permissions || [];
                 sc.rtcConfiguration = m.rtcConfiguration || null;
+                sc.capabilities = m.capabilities || null;
+                if(m.kind === 'join')
+                    sc.session = m.session || null;
             }
             if(sc.onjoined)
                 sc.onjoined.call(sc, m.kind, m.group,
@@ -502,6 +573,16 @@ ServerConnection.prototype.connect = function(url) {
                     '' + m.value,
                 );
             break;
+        case 'caption':
+            if(sc.oncaption)
+                sc.oncaption.call(
+                    sc, m.source, m.username, parseTime(m.time),
+                    '' + m.value,
+                );
+            break;
+        case 'fallback':
+            sc.gotFallback(m.id, '' + m.value);
+            break;
         case 'usermessage':
             if(m.kind === 'filetransfer')
                 sc.fileTransfer(m.source, m.username, m.value);
@@ -551,7 +632,7 @@ function parseTime(value) {
  *
  * @param {string} group - The name of the group to join.
  * @param {string} username - the username to join as.
- * @param {string|Object} credentials - password or authServer.
+ * @param {string|Object} credentials - password, token, authServer or session.
  * @param {Object<string,any>} [data] - the initial associated data.
  */
 ServerConnection.prototype.join = async function(group, username, credentials, data) {
@@ -573,6 +654,9 @@ ServerConnection.prototype.join = async function(group, username, credentials, d
         case 'token':
             m.token = credentials.token;
             break;
+        case 'session':
+            // the session is sent in a cookie
+            break;
         case 'authServer':
             let r = await fetch(credentials.authServer, {
                 method: "POST",
@@ -625,9 +709,39 @@ ServerConnection.prototype.join = async function(group, username, credentials, d
     if(data)
         m.data = data;
 
+    let codecs = receiverCodecs();
+    if(codecs.length > 0)
+        m.codecs = codecs;
+
+    // we know how to handle track messages
+    m.spareTransceivers = true;
+
     this.send(m);
 };
 
+/**
+ * receiverCodecs returns the list of codecs that we are able to decode.
+ *
+ * @returns {Array<string>}
+ */
+function receiverCodecs() {
+    /** @type {Array<string>} */
+    let codecs = [];
+    if(!RTCRtpReceiver.getCapabilities)
+        return codecs;
+    for(let kind of ['audio', 'video']) {
+        let caps = RTCRtpReceiver.getCapabilities(kind);
+        if(!caps)
+            continue;
+        for(let c of caps.codecs) {
+            let mimeType = c.mimeType.toLowerCase();
+            if(codecs.indexOf(mimeType) < 0)
+                codecs.push(mimeType);
+        }
+    }
+    return codecs;
+}
+
 /**
  * leave leaves a group.  The onjoined callback will be called when we've
  * effectively left.
@@ -648,11 +762,42 @@ ServerConnection.prototype.leave = function(group) {
  * @param {Object<string,Array<string>>} what
  *     - A dictionary that maps labels to a sequence of 'audio', 'video'
  *       or 'video-low.  An entry with an empty label '' provides the default.
+ * @param {number} [bandwidth]
+ *     - An estimate of the downlink bandwidth, in bits per second.
  */
-ServerConnection.prototype.request = function(what) {
+ServerConnection.prototype.request = function(what, bandwidth) {
     this.send({
         type: 'request',
         request: what,
+        value: bandwidth || undefined,
+    });
+};
+
+/**
+ * setNotifications asks the server not to send some classes of events.
+ *
+ * @param {Object<string,boolean>} notifications
+ *     - A dictionary that maps a class of events ('users', 'raisehand' or
+ *       'chat') to false if the events should not be sent.
+ */
+ServerConnection.prototype.setNotifications = function(notifications) {
+    this.send({
+        type: 'notifications',
+        value: notifications,
+    });
+};
+
+/**
+ * setAudioOnly asks the server to only forward audio to this client,
+ * whatever the requests made with request or requestStream.
+ *
+ * @param {boolean|null} audioOnly
+ *     - If null, the group's default is used.
+ */
+ServerConnection.prototype.setAudioOnly = function(audioOnly) {
+    this.send({
+        type: 'audioOnly',
+        value: audioOnly,
     });
 };
 
@@ -769,10 +914,26 @@ ServerConnection.prototype.chat = function(kind, dest, value) {
     });
 };
 
+/**
+ * caption sends a caption to all the clients in the group.  This requires
+ * the 'caption' permission.
+ *
+ * @param {string} value - The text of the caption.
+ */
+ServerConnection.prototype.caption = function(value) {
+    this.send({
+        type: 'caption',
+        source: this.id,
+        username: this.username,
+        value: value,
+    });
+};
+
 /**
  * userAction sends a request to act on a user.
  *
- * @param {string} kind - One of "op", "unop", "kick", "present", "unpresent".
+ * @param {string} kind - One of "op", "unop", "kick", "present", "unpresent",
+ *                        "screenshare", "unscreenshare".
  * @param {string} dest - The id of the user to act upon.
  * @param {any} [value] - An action-dependent parameter.
  */
@@ -833,9 +994,11 @@ ServerConnection.prototype.groupAction = function(kind, data) {
  * @param {string} username
  * @param {string} sdp
  * @param {string} replace
+ * @param {Array<string>} encrypted
+ * @param {boolean} standby
  * @function
  */
-ServerConnection.prototype.gotOffer = async function(id, label, source, username, sdp, replace) {
+ServerConnection.prototype.gotOffer = async function(id, label, source, username, sdp, replace, encrypted, standby) {
     let sc = this;
 
     if(sc.up[id]) {
@@ -858,7 +1021,10 @@ ServerConnection.prototype.gotOffer = async function(id, label, source, username
             console.error("Replacing unknown stream");
     }
 
-    let c = sc.down[id];
+    // a standby stream is kept apart until the server sends a stream
+    // message, see gotStream.
+    let streams = standby ? sc.standby : sc.down;
+    let c = streams[id];
     if(c && oldLocalId)
         console.error("Replacing duplicate stream");
 
@@ -875,8 +1041,7 @@ ServerConnection.prototype.gotOffer = async function(id, label, source, username
             return;
         }
         c = new Stream(this, id, oldLocalId || newLocalId(), pc, false);
-        c.label = label;
-        sc.down[id] = c;
+        streams[id] = c;
 
         c.pc.onicecandidate = function(e) {
             if(!e.candidate)
@@ -897,26 +1062,32 @@ ServerConnection.prototype.gotOffer = async function(id, label, source, username
 
         c.pc.ontrack = function(e) {
             if(e.streams.length < 1) {
-                console.error("Got track with no stream");
+                // a spare transceiver, see gotTrack
                 return;
             }
             c.stream = e.streams[0];
-            let changed = recomputeUserStreams(sc, source);
+            let changed = recomputeUserStreams(sc, c.source);
             if(c.ondowntrack) {
                 c.ondowntrack.call(
                     c, e.track, e.transceiver, e.streams[0],
                 );
             }
             if(changed && sc.onuser)
-                sc.onuser.call(sc, source, "change");
+                sc.onuser.call(sc, c.source, "change");
         };
     }
 
-    c.source = source;
-    c.username = username;
+    if(!standby) {
+        // the server may reuse a stream when it is replaced, in which
+        // case the label and user change.
+        c.label = label;
+        c.source = source;
+        c.username = username;
+        c.encrypted = encrypted || [];
 
-    if(sc.ondownstream)
-        sc.ondownstream.call(sc, c);
+        if(sc.ondownstream)
+            sc.ondownstream.call(sc, c);
+    }
 
     try {
         await c.pc.setRemoteDescription({
@@ -951,6 +1122,80 @@ ServerConnection.prototype.gotOffer = async function(id, label, source, username
         c.onnegotiationcompleted.call(c);
 };
 
+/**
+ * gotStream is called when the server starts sending a stream over
+ * a standby connection.  Don't call this.
+ *
+ * @param {string} id
+ * @param {string} label
+ * @param {string} source
+ * @param {string} username
+ * @param {Array<string>} encrypted
+ * @function
+ */
+ServerConnection.prototype.gotStream = function(id, label, source, username, encrypted) {
+    let sc = this;
+    let c = sc.standby[id];
+    if(!c) {
+        console.warn('Got stream for unknown standby connection');
+        return;
+    }
+    delete(sc.standby[id]);
+    sc.down[id] = c;
+    c.label = label;
+    c.source = source;
+    c.username = username;
+    c.encrypted = encrypted || [];
+    if(sc.ondownstream)
+        sc.ondownstream.call(sc, c);
+};
+
+/**
+ * gotTrack is called when the server attaches a track to a spare
+ * transceiver or detaches it without renegotiation.  Don't call this.
+ *
+ * @param {string} id
+ * @param {string} kind
+ * @param {string} mid
+ * @function
+ */
+ServerConnection.prototype.gotTrack = function(id, kind, mid) {
+    let sc = this;
+    let c = sc.down[id];
+    if(!c) {
+        console.warn('Got track for unknown stream');
+        return;
+    }
+    let t = c.pc.getTransceivers().find(t => t.mid === mid);
+    if(!t) {
+        console.warn(`Got track for unknown transceiver ${mid}`);
+        return;
+    }
+    let track = t.receiver.track;
+    switch(kind) {
+    case 'add':
+        if(!c.stream)
+            c.stream = new MediaStream();
+        if(!c.stream.getTracks().includes(track))
+            c.stream.addTrack(track);
+        break;
+    case 'remove':
+        if(c.stream)
+            c.stream.removeTrack(track);
+        break;
+    default:
+        console.warn(`Unknown track kind ${kind}`);
+        return;
+    }
+    let changed = recomputeUserStreams(sc, c.source);
+    if(kind === 'add' && c.ondowntrack)
+        c.ondowntrack.call(c, track, t, c.stream);
+    if(c.onnegotiationcompleted)
+        c.onnegotiationcompleted.call(c);
+    if(changed && sc.onuser)
+        sc.onuser.call(sc, c.source, "change");
+};
+
 /**
  * gotAnswer is called when we receive an answer from the server.  Don't
  * call this.
@@ -963,6 +1208,8 @@ ServerConnection.prototype.gotAnswer = async function(id, sdp) {
     let c = this.up[id];
     if(!c)
         throw new Error('unknown up stream');
+    if(c.audioParams)
+        sdp = setAudioParams(sdp, c.audioParams);
     try {
         await c.pc.setRemoteDescription({
             type: 'answer',
@@ -982,6 +1229,69 @@ ServerConnection.prototype.gotAnswer = async function(id, sdp) {
         c.onnegotiationcompleted.call(c);
 };
 
+/**
+ * setAudioParams modifies the Opus parameters in an SDP description.
+ *
+ * @param {string} sdp
+ * @param {{fec: boolean, ptime: number}} params
+ * @returns {string}
+ */
+function setAudioParams(sdp, params) {
+    let lines = sdp.split('\r\n');
+    /** @type {Object<string,boolean>} */
+    let opus = {};
+    for(let line of lines) {
+        let m = /^a=rtpmap:(\d+) opus\//i.exec(line);
+        if(m)
+            opus[m[1]] = true;
+    }
+    let result = [];
+    let audio = false;
+    for(let line of lines) {
+        if(line.startsWith('m=')) {
+            audio = line.startsWith('m=audio');
+        } else if(audio && line.startsWith('a=ptime:')) {
+            continue;
+        }
+        let m = /^a=fmtp:(\d+) (.*)$/.exec(line);
+        if(m && opus[m[1]]) {
+            let fmtp = m[2].split(';').filter(
+                p => !p.trim().startsWith('useinbandfec='),
+            );
+            fmtp.push('useinbandfec=' + (params.fec ? '1' : '0'));
+            line = `a=fmtp:${m[1]} ${fmtp.join(';')}`;
+            result.push(line);
+            if(params.ptime)
+                result.push(`a=ptime:${params.ptime}`);
+            continue;
+        }
+        result.push(line);
+    }
+    return result.join('\r\n');
+}
+
+/**
+ * gotAudioParams is called when the server requests that we change the
+ * parameters of the Opus encoder.  Don't call this.
+ *
+ * @param {string} id
+ * @param {{fec: boolean, ptime: number}} params
+ */
+ServerConnection.prototype.gotAudioParams = function(id, params) {
+    let c = this.up[id];
+    if(!c)
+        return;
+    c.audioParams = params;
+    // if a negotiation is in progress, the parameters will be applied
+    // when we get the answer.
+    if(c.pc.signalingState !== 'stable')
+        return;
+    c.negotiate().catch(e => {
+        if(c.onerror)
+            c.onerror.call(c, e);
+    });
+};
+
 /**
  * gotRenegotiate is called when we receive a renegotiation request from
  * the server.  Don't call this.
@@ -996,6 +1306,23 @@ ServerConnection.prototype.gotRenegotiate = function(id) {
     c.restartIce();
 };
 
+/**
+ * gotFallback is called when the server asks us to publish a fallback for
+ * an up stream.  Don't call this.
+ *
+ * @param {string} id
+ * @param {string} codec
+ */
+ServerConnection.prototype.gotFallback = function(id, codec) {
+    let c = this.up[id];
+    if(!c) {
+        console.warn('unknown up stream', id);
+        return;
+    }
+    if(this.onfallback)
+        this.onfallback.call(this, c, codec);
+};
+
 /**
  * gotClose is called when we receive a close request from the server.
  * Don't call this.
@@ -1003,7 +1330,7 @@ ServerConnection.prototype.gotRenegotiate = function(id) {
  * @param {string} id
  */
 ServerConnection.prototype.gotClose = function(id) {
-    let c = this.down[id];
+    let c = this.down[id] || this.standby[id];
     if(!c) {
         console.warn('unknown down stream', id);
         return;
@@ -1036,6 +1363,8 @@ ServerConnection.prototype.gotRemoteIce = async function(id, candidate) {
     let c = this.up[id];
     if(!c)
         c = this.down[id];
+    if(!c)
+        c = this.standby[id];
     if(!c)
         throw new Error('unknown stream');
     if(c.pc.remoteDescription)
@@ -1118,12 +1447,33 @@ function Stream(sc, id, localId, pc, up) {
      * @type {string}
      */
     this.label = null;
+    /**
+     * The ids of the tracks that carry end-to-end encrypted frames, which
+     * the server forwards without parsing their payload.  For up streams,
+     * this must be set before the stream is negotiated.
+     *
+     * @type {Array<string>}
+     */
+    this.encrypted = [];
+    /**
+     * For up streams, the id of the stream that this stream is a fallback
+     * for, see ServerConnection.onfallback.
+     *
+     * @type {string}
+     */
+    this.fallback = null;
     /**
      * The id of the stream that we are currently replacing.
      *
      * @type {string}
      */
     this.replace = null;
+    /**
+     * For up streams, the Opus parameters requested by the server.
+     *
+     * @type {{fec: boolean, ptime: number}}
+     */
+    this.audioParams = null;
     /**
      * Indicates whether we have already sent a local description.
      *
@@ -1185,7 +1535,8 @@ function Stream(sc, id, localId, pc, up) {
     this.onerror = null;
     /**
      * onnegotiationcompleted is called whenever negotiation or
-     * renegotiation has completed.
+     * renegotiation has completed, and whenever the server has attached
+     * or detached a track without renegotiation.
      *
      * @type{(this: Stream) => void}
      */
@@ -1267,14 +1618,22 @@ Stream.prototype.close = function(replace) {
             delete(c.sc.up[c.id]);
         else
             console.warn('Closing unknown stream');
+        for(let id in c.sc.up) {
+            if(c.sc.up[id].fallback === c.id)
+                c.sc.up[id].close();
+        }
     } else {
         userid = c.source;
         if(c.sc.down[c.id] === c)
             delete(c.sc.down[c.id]);
-        else
+        else if(c.sc.standby[c.id] === c) {
+            // a standby stream belongs to no user
+            delete(c.sc.standby[c.id]);
+            userid = null;
+        } else
             console.warn('Closing unknown stream');
     }
-    let changed = recomputeUserStreams(c.sc, userid);
+    let changed = userid !== null && recomputeUserStreams(c.sc, userid);
     if(changed && c.sc.onuser)
         c.sc.onuser.call(c.sc, userid, "change");
 
@@ -1418,6 +1777,8 @@ Stream.prototype.negotiate = async function (restartIce) {
         replace: this.replace,
         label: c.label,
         sdp: c.pc.localDescription.sdp,
+        encrypted: c.encrypted.length > 0 ? c.encrypted : undefined,
+        fallback: c.fallback || undefined,
     });
     this.localDescriptionSent = true;
     this.replace = null;
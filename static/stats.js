@@ -58,6 +58,12 @@ function formatGroup(table, group) {
             let td2 = document.createElement('td');
             td2.textContent = client.id;
             tr2.appendChild(td2);
+            if(client.clockOffset && Math.abs(client.clockOffset) >= 1000) {
+                let td3 = document.createElement('td');
+                td3.textContent =
+                    `clock ${Math.round(client.clockOffset / 1000)}s`;
+                tr2.appendChild(td3);
+            }
             table.appendChild(tr2);
             if(client.up)
                 for(let j = 0; j < client.up.length; j++) {
@@ -86,6 +92,19 @@ function formatConn(table, direction, conn) {
     if(conn.maxBitrate)
         td3.textContent = `${conn.maxBitrate}`;
     tr.appendChild(td3);
+    if(conn.viewers) {
+        let td4 = document.createElement('td');
+        td4.textContent =
+            `${conn.viewers.current} viewers (peak ${conn.viewers.peak})`;
+        tr.appendChild(td4);
+    }
+    if(conn.pairChanges) {
+        let last = conn.pairChanges[conn.pairChanges.length - 1];
+        let td5 = document.createElement('td');
+        td5.textContent =
+            `${conn.pairChanges.length} pair changes (now ${last.remote})`;
+        tr.appendChild(td5);
+    }
     table.appendChild(tr);
     if(conn.tracks) {
         for(let i = 0; i < conn.tracks.length; i++)
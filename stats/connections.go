@@ -0,0 +1,274 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection statistics aggregate the metadata of the connections
+// established since the server started, in order to help administrators
+// decide where to place TURN servers.  Only counters are kept, and
+// counters that are too small to preserve the privacy of users are merged
+// before being exported.
+
+// GeoIPFilename is the name of a CSV file that maps networks, in CIDR
+// notation, to a country code and an autonomous system, one per line.
+// If it doesn't exist, countries and autonomous systems are unknown.
+var GeoIPFilename string
+
+// MinCount is the smallest value of a counter that is exported; smaller
+// counters are merged into the "other" counter.
+const MinCount = 5
+
+// ConnectionStats contains counters of connections.
+type ConnectionStats struct {
+	Total      uint64            `json:"total"`
+	Countries  map[string]uint64 `json:"countries,omitempty"`
+	ASNs       map[string]uint64 `json:"asns,omitempty"`
+	Transports map[string]uint64 `json:"transports,omitempty"`
+	Candidates map[string]uint64 `json:"candidates,omitempty"`
+	// the number of connected clients lacking a given feature
+	Legacy map[string]uint64 `json:"legacy,omitempty"`
+	// the number of changes of the selected candidate pair, indexed
+	// by the types of the old and new remote candidates
+	PairChanges map[string]uint64 `json:"pairChanges,omitempty"`
+}
+
+var connections struct {
+	mu    sync.Mutex
+	stats ConnectionStats
+}
+
+func increment(m *map[string]uint64, key string) {
+	if *m == nil {
+		*m = make(map[string]uint64)
+	}
+	(*m)[key]++
+}
+
+// RecordConnection records a connection established from the given
+// address, using the given transport ("udp" or "tcp") and remote
+// candidate type ("host", "srflx", "prflx" or "relay").
+func RecordConnection(ip net.IP, transport, candidate string) {
+	country, asn := "unknown", "unknown"
+	if ip != nil {
+		c, a := geoip.lookup(ip)
+		if c != "" {
+			country = c
+		}
+		if a != "" {
+			asn = a
+		}
+	}
+	if transport == "" {
+		transport = "unknown"
+	}
+	if candidate == "" {
+		candidate = "unknown"
+	}
+
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	s := &connections.stats
+	s.Total++
+	increment(&s.Countries, country)
+	increment(&s.ASNs, asn)
+	increment(&s.Transports, transport)
+	increment(&s.Candidates, candidate)
+}
+
+// RecordPairChange records a change of the selected candidate pair of
+// a connection from a remote candidate of type from to one of type to.
+func RecordPairChange(from, to string) {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	increment(&connections.stats.PairChanges, from+"->"+to)
+}
+
+// AddLegacyClient updates the number of connected clients that exhibit
+// the given quirk.
+func AddLegacyClient(quirk string, delta int) {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	s := &connections.stats
+	if s.Legacy == nil {
+		s.Legacy = make(map[string]uint64)
+	}
+	v := int64(s.Legacy[quirk]) + int64(delta)
+	if v <= 0 {
+		delete(s.Legacy, quirk)
+	} else {
+		s.Legacy[quirk] = uint64(v)
+	}
+}
+
+func mergeSmall(m map[string]uint64) map[string]uint64 {
+	if m == nil {
+		return nil
+	}
+	n := make(map[string]uint64, len(m))
+	for k, v := range m {
+		if v < MinCount {
+			k = "other"
+		}
+		n[k] += v
+	}
+	if n["other"] < MinCount {
+		// it might still identify a single user
+		delete(n, "other")
+	}
+	return n
+}
+
+// GetConnections returns the connection counters, with small counters
+// merged.  The counters of legacy clients and of candidate pair changes
+// are not merged, since they are not identifying.
+func GetConnections() ConnectionStats {
+	connections.mu.Lock()
+	defer connections.mu.Unlock()
+	s := connections.stats
+	return ConnectionStats{
+		Total:       s.Total,
+		Countries:   mergeSmall(s.Countries),
+		ASNs:        mergeSmall(s.ASNs),
+		Transports:  mergeSmall(s.Transports),
+		Candidates:  mergeSmall(s.Candidates),
+		Legacy:      maps.Clone(s.Legacy),
+		PairChanges: maps.Clone(s.PairChanges),
+	}
+}
+
+type geoipEntry struct {
+	first, last [16]byte
+	country     string
+	asn         string
+}
+
+type geoipDB struct {
+	mu       sync.Mutex
+	modTime  time.Time
+	fileSize int64
+	entries  []geoipEntry
+}
+
+var geoip geoipDB
+
+func to16(ip net.IP) [16]byte {
+	var a [16]byte
+	copy(a[:], ip.To16())
+	return a
+}
+
+func parseGeoIP(r io.Reader) ([]geoipEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	var entries []geoipEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			return nil, errors.New("bad GeoIP record")
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, err
+		}
+		first := to16(network.IP)
+		last := first
+		mask := network.Mask
+		offset := 16 - len(mask)
+		for i := range mask {
+			last[offset+i] |= ^mask[i]
+		}
+		e := geoipEntry{
+			first:   first,
+			last:    last,
+			country: strings.TrimSpace(record[1]),
+		}
+		if len(record) > 2 {
+			e.asn = strings.TrimSpace(record[2])
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].first[:], entries[j].first[:]) < 0
+	})
+	return entries, nil
+}
+
+// load updates the database from the file if it has changed.
+// Called locked.
+func (db *geoipDB) load() {
+	if GeoIPFilename == "" {
+		db.entries = nil
+		return
+	}
+	fi, err := os.Stat(GeoIPFilename)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Read GeoIP database: %v", err)
+		}
+		db.entries = nil
+		db.modTime = time.Time{}
+		db.fileSize = 0
+		return
+	}
+	if db.modTime.Equal(fi.ModTime()) && db.fileSize == fi.Size() {
+		return
+	}
+	db.modTime = fi.ModTime()
+	db.fileSize = fi.Size()
+
+	f, err := os.Open(GeoIPFilename)
+	if err != nil {
+		log.Printf("Read GeoIP database: %v", err)
+		db.entries = nil
+		return
+	}
+	defer f.Close()
+	entries, err := parseGeoIP(f)
+	if err != nil {
+		log.Printf("Read GeoIP database: %v", err)
+		db.entries = nil
+		return
+	}
+	db.entries = entries
+}
+
+func (db *geoipDB) lookup(ip net.IP) (string, string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.load()
+	return lookupEntries(db.entries, ip)
+}
+
+func lookupEntries(entries []geoipEntry, ip net.IP) (string, string) {
+	a := to16(ip)
+	// the first entry that starts after a
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].first[:], a[:]) > 0
+	})
+	if i == 0 {
+		return "", ""
+	}
+	e := entries[i-1]
+	if bytes.Compare(a[:], e.last[:]) > 0 {
+		return "", ""
+	}
+	return e.country, e.asn
+}
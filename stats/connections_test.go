@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeoIPLookup(t *testing.T) {
+	entries, err := parseGeoIP(strings.NewReader(
+		"# comment\n" +
+			"192.0.2.0/24,FR,AS64496\n" +
+			"10.0.0.0/8,DE\n" +
+			"2001:db8::/32,NL,AS64497\n",
+	))
+	if err != nil {
+		t.Fatalf("parseGeoIP: %v", err)
+	}
+
+	tests := []struct {
+		ip, country, asn string
+	}{
+		{"192.0.2.0", "FR", "AS64496"},
+		{"192.0.2.255", "FR", "AS64496"},
+		{"192.0.3.0", "", ""},
+		{"10.1.2.3", "DE", ""},
+		{"9.255.255.255", "", ""},
+		{"2001:db8::1", "NL", "AS64497"},
+		{"2001:db9::1", "", ""},
+	}
+	for _, tt := range tests {
+		c, a := lookupEntries(entries, net.ParseIP(tt.ip))
+		if c != tt.country || a != tt.asn {
+			t.Errorf("%v: expected %v %v, got %v %v",
+				tt.ip, tt.country, tt.asn, c, a)
+		}
+	}
+}
+
+func TestBadGeoIP(t *testing.T) {
+	_, err := parseGeoIP(strings.NewReader("192.0.2.0,FR\n"))
+	if err == nil {
+		t.Errorf("Bad network accepted")
+	}
+}
+
+func TestMergeSmall(t *testing.T) {
+	m := mergeSmall(map[string]uint64{
+		"FR": 10, "DE": 2, "NL": 2, "BE": 1,
+	})
+	expected := map[string]uint64{"FR": 10, "other": 5}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+
+	m = mergeSmall(map[string]uint64{"FR": 10, "DE": 1})
+	expected = map[string]uint64{"FR": 10}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Expected %v, got %v", expected, m)
+	}
+}
+
+func TestRecordConnection(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "geoip.csv")
+	err := os.WriteFile(filename, []byte("192.0.2.0/24,FR,AS64496\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	GeoIPFilename = filename
+	defer func() {
+		GeoIPFilename = ""
+	}()
+
+	for i := 0; i < MinCount; i++ {
+		RecordConnection(net.ParseIP("192.0.2.1"), "udp", "host")
+	}
+	RecordConnection(net.ParseIP("198.51.100.1"), "tcp", "relay")
+
+	s := GetConnections()
+	if s.Total != MinCount+1 {
+		t.Errorf("Expected %v, got %v", MinCount+1, s.Total)
+	}
+	if s.Countries["FR"] != MinCount || s.ASNs["AS64496"] != MinCount {
+		t.Errorf("Unexpected countries %v, ASNs %v",
+			s.Countries, s.ASNs)
+	}
+	if _, ok := s.Transports["tcp"]; ok {
+		t.Errorf("Small counter exported: %v", s.Transports)
+	}
+}
+
+func TestRecordPairChange(t *testing.T) {
+	RecordPairChange("srflx", "relay")
+	s := GetConnections()
+	if s.PairChanges["srflx->relay"] != 1 {
+		t.Errorf("Expected 1, got %v", s.PairChanges)
+	}
+}
@@ -14,9 +14,10 @@ type GroupStats struct {
 }
 
 type Client struct {
-	Id   string `json:"id"`
-	Up   []Conn `json:"up,omitempty"`
-	Down []Conn `json:"down,omitempty"`
+	Id          string   `json:"id"`
+	ClockOffset Duration `json:"clockOffset,omitempty"`
+	Up          []Conn   `json:"up,omitempty"`
+	Down        []Conn   `json:"down,omitempty"`
 }
 
 type Statable interface {
@@ -24,9 +25,32 @@ type Statable interface {
 }
 
 type Conn struct {
-	Id         string  `json:"id"`
-	MaxBitrate uint64  `json:"maxBitrate,omitempty"`
-	Tracks     []Track `json:"tracks"`
+	Id          string       `json:"id"`
+	MaxBitrate  uint64       `json:"maxBitrate,omitempty"`
+	Viewers     *Viewers     `json:"viewers,omitempty"`
+	PairChanges []PairChange `json:"pairChanges,omitempty"`
+	Tracks      []Track      `json:"tracks"`
+}
+
+// PairChange describes a change of the selected ICE candidate pair of
+// a connection.
+type PairChange struct {
+	Time time.Time `json:"time"`
+	// the types of the local and remote candidates
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	// "udp" or "tcp"
+	Protocol string `json:"protocol"`
+}
+
+// Viewers describes the receivers of an up connection.
+type Viewers struct {
+	Current int `json:"current"`
+	Peak    int `json:"peak"`
+	// the number of subscriptions since the connection was created
+	Total int `json:"total"`
+	// the total time spent by receivers watching the connection
+	Time Duration `json:"time"`
 }
 
 type Duration time.Duration
@@ -3,13 +3,13 @@ package token
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/rsa"
 	"encoding/base64"
 	"errors"
 	"math/big"
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -91,6 +91,27 @@ func ParseKey(key map[string]any) (any, error) {
 			X:     &x,
 			Y:     &y,
 		}, nil
+	case "RSA":
+		if alg != "RS256" && alg != "RS384" && alg != "RS512" {
+			return nil, errors.New("unknown alg")
+		}
+		nbytes, err := parseBase64("n", key)
+		if err != nil {
+			return nil, err
+		}
+		ebytes, err := parseBase64("e", key)
+		if err != nil {
+			return nil, err
+		}
+		var e big.Int
+		e.SetBytes(ebytes)
+		if len(nbytes) < 256 || !e.IsInt64() || e.Int64() < 3 ||
+			e.Int64() > 1<<31-1 {
+			return nil, errors.New("bad RSA key")
+		}
+		var n big.Int
+		n.SetBytes(nbytes)
+		return &rsa.PublicKey{N: &n, E: int(e.Int64())}, nil
 	default:
 		return nil, errors.New("unknown key type")
 	}
@@ -115,6 +136,38 @@ func ParseKeys(keys []map[string]any, alg, kid string) ([]jwt.VerificationKey, e
 	return ks, nil
 }
 
+// SignJWT creates a JWT with the given claims.  The key must contain
+// private key material.
+func SignJWT(key map[string]any, claims map[string]any) (string, error) {
+	alg, ok := key["alg"].(string)
+	if !ok {
+		return "", errors.New("alg not found")
+	}
+	k, err := ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+	if pub, ok := k.(*ecdsa.PublicKey); ok {
+		dbytes, err := parseBase64("d", key)
+		if err != nil {
+			return "", err
+		}
+		var d big.Int
+		d.SetBytes(dbytes)
+		k = &ecdsa.PrivateKey{PublicKey: *pub, D: &d}
+	}
+
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return "", errors.New("unknown alg")
+	}
+	t := jwt.NewWithClaims(method, jwt.MapClaims(claims))
+	if kid, ok := key["kid"].(string); ok {
+		t.Header["kid"] = kid
+	}
+	return t.SignedString(k)
+}
+
 func toStringArray(a interface{}) ([]string, bool) {
 	aa, ok := a.([]interface{})
 	if !ok {
@@ -132,29 +185,35 @@ func toStringArray(a interface{}) ([]string, bool) {
 	return b, true
 }
 
+// keyFunc returns a function that selects the keys suitable for
+// verifying a given token.
+func keyFunc(keys []map[string]any) jwt.Keyfunc {
+	return func(t *jwt.Token) (any, error) {
+		alg, _ := t.Header["alg"].(string)
+		if alg == "" {
+			return nil, errors.New("alg not found")
+		}
+		kid, _ := t.Header["kid"].(string)
+		ks, err := ParseKeys(keys, alg, kid)
+		if err != nil {
+			return nil, err
+		}
+		if len(ks) == 1 {
+			return ks[0], nil
+		}
+		return jwt.VerificationKeySet{Keys: ks}, nil
+	}
+}
+
 // parseJWT tries to parse a string as a JWT.
 // It returns (nil, nil) if the string does not look like a JWT.
 func parseJWT(token string, keys []map[string]any) (*JWT, error) {
 	t, err := jwt.Parse(
 		token,
-		func(t *jwt.Token) (any, error) {
-			alg, _ := t.Header["alg"].(string)
-			if alg == "" {
-				return nil, errors.New("alg not found")
-			}
-			kid, _ := t.Header["kid"].(string)
-			ks, err := ParseKeys(keys, alg, kid)
-			if err != nil {
-				return nil, err
-			}
-			if len(ks) == 1 {
-				return ks[0], nil
-			}
-			return jwt.VerificationKeySet{Keys: ks}, nil
-		},
+		keyFunc(keys),
 		jwt.WithExpirationRequired(),
 		jwt.WithIssuedAt(),
-		jwt.WithLeeway(5*time.Second),
+		jwt.WithLeeway(ClockTolerance()),
 	)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenMalformed) {
@@ -166,6 +225,50 @@ func parseJWT(token string, keys []map[string]any) (*JWT, error) {
 	return (*JWT)(t), nil
 }
 
+// VerifyJWT checks the signature and validity period of an externally
+// issued JWT, and returns its claims.  If issuer or audience are not
+// empty, then the corresponding claims must match.
+func VerifyJWT(token string, keys []map[string]any, issuer, audience string) (map[string]any, error) {
+	options := []jwt.ParserOption{
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(ClockTolerance()),
+	}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	t, err := jwt.Parse(
+		token,
+		keyFunc(keys),
+		options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("unexpected type for token")
+	}
+	return claims, nil
+}
+
+// ParseUnverified parses a JWT without checking its signature or its
+// validity.  This is only useful for displaying the contents of a token.
+func ParseUnverified(token string) (map[string]any, error) {
+	t, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("unexpected type for token")
+	}
+	return claims, nil
+}
+
 func (token *JWT) Check(host, group string, username *string) (string, []string, error) {
 	sub, err := token.Claims.GetSubject()
 	if err != nil {
@@ -214,5 +317,19 @@ func (token *JWT) Check(host, group string, username *string) (string, []string,
 		}
 	}
 
+	_, err = parseLimits(claims["limits"])
+	if err != nil {
+		return "", nil, err
+	}
+
 	return sub, perms, nil
 }
+
+func (token *JWT) GetLimits() *Limits {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	limits, _ := parseLimits(claims["limits"])
+	return limits
+}
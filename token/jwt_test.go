@@ -2,9 +2,17 @@ package token
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
 )
 
 func TestJWKHS256(t *testing.T) {
@@ -54,6 +62,47 @@ func TestJWKES256(t *testing.T) {
 	}
 }
 
+func TestJWKRS256(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	j := map[string]any{
+		"kty": "RSA",
+		"alg": "RS256",
+		"n": base64.RawURLEncoding.EncodeToString(
+			priv.PublicKey.N.Bytes(),
+		),
+		"e": base64.RawURLEncoding.EncodeToString(
+			big.NewInt(int64(priv.PublicKey.E)).Bytes(),
+		),
+	}
+	k, err := ParseKey(j)
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	kk, ok := k.(*rsa.PublicKey)
+	if !ok || !kk.Equal(&priv.PublicKey) {
+		t.Errorf("ParseKey: got %v", kk)
+	}
+
+	now := time.Now()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": "https://idp.example.org",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}).SignedString(priv)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	_, err = VerifyJWT(
+		tok, []map[string]any{j}, "https://idp.example.org", "",
+	)
+	if err != nil {
+		t.Errorf("VerifyJWT: %v", err)
+	}
+}
+
 func TestJWT(t *testing.T) {
 	key := `{"alg":"HS256","k":"H7pCkktUl5KyPCZ7CKw09y1j460tfIv4dRcS1XstUKY","key_ops":["sign","verify"],"kty":"oct"}`
 	var k map[string]interface{}
@@ -152,3 +201,108 @@ func TestJWT(t *testing.T) {
 		t.Errorf("noneToken is good")
 	}
 }
+
+func TestSignJWT(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	ecKey := map[string]any{
+		"kty": "EC",
+		"alg": "ES256",
+		"crv": "P-256",
+		"kid": "ec",
+		"x":   encode(priv.X.FillBytes(make([]byte, 32))),
+		"y":   encode(priv.Y.FillBytes(make([]byte, 32))),
+		"d":   encode(priv.D.FillBytes(make([]byte, 32))),
+	}
+	octKey := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ",
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"sub":         "john",
+		"aud":         "https://galene.org:8443/group/auth/",
+		"permissions": []string{"present"},
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Hour).Unix(),
+	}
+
+	for _, key := range []map[string]any{ecKey, octKey} {
+		s, err := SignJWT(key, claims)
+		if err != nil {
+			t.Errorf("SignJWT %v: %v", key["alg"], err)
+			continue
+		}
+		tok, err := Parse(s, []map[string]any{key})
+		if err != nil {
+			t.Errorf("Parse %v: %v", key["alg"], err)
+			continue
+		}
+		username, perms, err := tok.Check("galene.org:8443", "auth", nil)
+		if err != nil || username != "john" ||
+			!reflect.DeepEqual(perms, []string{"present"}) {
+			t.Errorf("Check %v: %v %v %v",
+				key["alg"], username, perms, err)
+		}
+	}
+
+	delete(ecKey, "d")
+	_, err = SignJWT(ecKey, claims)
+	if err == nil {
+		t.Errorf("SignJWT succeeded with public key")
+	}
+}
+
+func TestJWTLimits(t *testing.T) {
+	key := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ",
+	}
+	now := time.Now()
+	claims := map[string]any{
+		"aud":         "https://galene.org:8443/group/auth/",
+		"permissions": []string{"present"},
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Hour).Unix(),
+		"limits":      Limits{MaxTracks: 2, AudioOnly: true},
+	}
+
+	s, err := SignJWT(key, claims)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	tok, err := Parse(s, []map[string]any{key})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	_, _, err = tok.Check("galene.org:8443", "auth", nil)
+	if err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	limits := tok.GetLimits()
+	if limits == nil || *limits != (Limits{MaxTracks: 2, AudioOnly: true}) {
+		t.Errorf("Expected limits, got %v", limits)
+	}
+
+	claims["limits"] = "bad"
+	s, err = SignJWT(key, claims)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	tok, err = Parse(s, []map[string]any{key})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	_, _, err = tok.Check("galene.org:8443", "auth", nil)
+	if err == nil {
+		t.Errorf("Check succeeded with bad limits")
+	}
+}
@@ -0,0 +1,47 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Limits are constraints on the media published by the bearer of a token.
+type Limits struct {
+	// the maximum bitrate, in bits per second, of the bearer's streams
+	MaxBitrate uint64 `json:"maxBitrate,omitempty"`
+	// the maximum number of tracks that the bearer may publish
+	MaxTracks int `json:"maxTracks,omitempty"`
+	// whether the bearer is restricted to publishing audio
+	AudioOnly bool `json:"audioOnly,omitempty"`
+}
+
+func (l *Limits) Clone() *Limits {
+	if l == nil {
+		return nil
+	}
+	ll := *l
+	return &ll
+}
+
+// parseLimits parses the value of a JWT claim.
+func parseLimits(v any) (*Limits, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if _, ok := v.(map[string]any); !ok {
+		return nil, errors.New("invalid 'limits' field")
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var limits Limits
+	err = json.Unmarshal(b, &limits)
+	if err != nil {
+		return nil, errors.New("invalid 'limits' field")
+	}
+	if limits.MaxTracks < 0 {
+		return nil, errors.New("invalid 'limits' field")
+	}
+	return &limits, nil
+}
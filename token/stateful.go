@@ -26,6 +26,9 @@ type Stateful struct {
 	NotBefore        *time.Time `json:"not-before,omitempty"`
 	IssuedAt         *time.Time `json:"issuedAt,omitempty"`
 	IssuedBy         *string    `json:"issuedBy,omitempty"`
+	Limits           *Limits    `json:"limits,omitempty"`
+	// the number of times the token has been used to join a group
+	Uses uint64 `json:"uses,omitempty"`
 }
 
 func (token *Stateful) Clone() *Stateful {
@@ -39,12 +42,15 @@ func (token *Stateful) Clone() *Stateful {
 		NotBefore:        token.NotBefore,
 		IssuedAt:         token.IssuedAt,
 		IssuedBy:         token.IssuedBy,
+		Limits:           token.Limits.Clone(),
+		Uses:             token.Uses,
 	}
 }
 
 // A set of stateful tokens, kept in sync with a JSONL representation in
 // a file.  The synchronisation is slightly racy, so both reading and
-// modifying tokens are protected by a mutex.
+// modifying tokens are protected by a mutex.  The tokens of the server
+// are sharded by group across multiple such files, see store.go.
 type state struct {
 	filename string
 	mu       sync.Mutex
@@ -53,14 +59,14 @@ type state struct {
 	tokens   map[string]*Stateful
 }
 
-var tokens state
+var tokens store
 
+// SetStatefulFilename sets the file where stateful tokens were stored
+// by older versions.  Tokens are now stored in the directory with the
+// same name without the extension, and the file is migrated to the
+// directory when first accessed.
 func SetStatefulFilename(filename string) {
-	tokens.mu.Lock()
-	defer tokens.mu.Unlock()
-	tokens.filename = filename
-	tokens.fileSize = 0
-	tokens.modTime = time.Time{}
+	tokens.setFilename(filename)
 }
 
 func (state *state) Get(token string) (*Stateful, string, error) {
@@ -86,6 +92,37 @@ func Get(token string) (*Stateful, string, error) {
 	return tokens.Get(token)
 }
 
+// NoteUse records that a token has been used to join a group, and
+// stores the number of uses with the token.  It does nothing if the
+// token is not a stateful token.
+func NoteUse(token string) error {
+	return tokens.NoteUse(token)
+}
+
+// noteUse increments the number of uses of a token.
+func (state *state) noteUse(token string) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	_, err := state.load()
+	if err != nil {
+		return err
+	}
+	old := state.tokens[token]
+	if old == nil {
+		return os.ErrNotExist
+	}
+	t := old.Clone()
+	t.Uses++
+	state.tokens[token] = t
+	err = state.rewrite()
+	if err != nil {
+		state.tokens[token] = old
+		return err
+	}
+	return nil
+}
+
 func (token *Stateful) match(group string) bool {
 	if group == "" {
 		return false
@@ -106,12 +143,9 @@ func (token *Stateful) Check(host, group string, username *string) (string, []st
 	if !token.match(group) {
 		return "", nil, errors.New("token for bad group")
 	}
-	now := time.Now()
-	if token.Expires == nil || now.After(*token.Expires) {
-		return "", nil, errors.New("token has expired")
-	}
-	if token.NotBefore != nil && now.Before(*token.NotBefore) {
-		return "", nil, errors.New("token is in the future")
+	err := token.CheckTime(time.Now())
+	if err != nil {
+		return "", nil, err
 	}
 
 	// the username from the token overrides the one from the client.
@@ -125,6 +159,23 @@ func (token *Stateful) Check(host, group string, username *string) (string, []st
 	return user, token.Permissions, nil
 }
 
+// CheckTime returns an error if the token is not valid at time now.
+func (token *Stateful) CheckTime(now time.Time) error {
+	tolerance := ClockTolerance()
+	if token.Expires == nil || now.After(token.Expires.Add(tolerance)) {
+		return errors.New("token has expired")
+	}
+	if token.NotBefore != nil &&
+		now.Before(token.NotBefore.Add(-tolerance)) {
+		return errors.New("token is in the future")
+	}
+	return nil
+}
+
+func (token *Stateful) GetLimits() *Limits {
+	return token.Limits
+}
+
 func member(v string, l []string) bool {
 	for _, w := range l {
 		if v == w {
@@ -219,8 +270,8 @@ func (state *state) etag() string {
 // If etag is the empty string, it is added if it didn't exist.  If etag
 // is not empty, it is added if it matches the state's etag.
 func (state *state) Update(token *Stateful, etag string) (*Stateful, error) {
-	tokens.mu.Lock()
-	defer tokens.mu.Unlock()
+	state.mu.Lock()
+	defer state.mu.Unlock()
 
 	if state.filename == "" {
 		if etag != "" {
@@ -263,8 +314,8 @@ func Delete(token string, etag string) error {
 }
 
 func (state *state) Delete(token string, etag string) error {
-	tokens.mu.Lock()
-	defer tokens.mu.Unlock()
+	state.mu.Lock()
+	defer state.mu.Unlock()
 
 	if state.filename == "" {
 		return os.ErrNotExist
@@ -336,6 +387,49 @@ func Update(token *Stateful, etag string) (*Stateful, error) {
 	return tokens.Update(token, etag)
 }
 
+// ErrQuota is returned by Add when a group has too many unexpired tokens.
+var ErrQuota = errors.New("too many tokens")
+
+func (state *state) Add(token *Stateful, max int, now time.Time) (*Stateful, error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.filename == "" {
+		return nil, os.ErrNotExist
+	}
+
+	_, err := state.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := state.tokens[token.Token]; ok {
+		return nil, os.ErrExist
+	}
+
+	if max > 0 {
+		count := 0
+		for _, t := range state.tokens {
+			if t.Group == token.Group &&
+				t.Expires != nil && t.Expires.After(now) {
+				count++
+			}
+		}
+		if count >= max {
+			return nil, ErrQuota
+		}
+	}
+
+	return state.add(token)
+}
+
+// Add adds a new token.  If max is positive, it fails with ErrQuota if
+// the token's group already has max unexpired tokens; the check and the
+// insertion are done atomically.
+func Add(token *Stateful, max int, now time.Time) (*Stateful, error) {
+	return tokens.Add(token, max, now)
+}
+
 // called locked
 func (state *state) rewrite() error {
 	if state.tokens == nil || len(state.tokens) == 0 {
@@ -346,14 +440,20 @@ func (state *state) rewrite() error {
 		return err
 	}
 
-	dir := filepath.Dir(state.filename)
-	tmpfile, err := os.CreateTemp(dir, "tokens")
+	a, _, err := state.list("", true)
 	if err != nil {
 		return err
 	}
-	a, _, err := state.list("", true)
+	return state.write(a)
+}
+
+// write replaces the contents of the file with the tokens in a, which
+// must not be empty.
+// called locked
+func (state *state) write(a []*Stateful) error {
+	dir := filepath.Dir(state.filename)
+	tmpfile, err := os.CreateTemp(dir, "tokens")
 	if err != nil {
-		os.Remove(tmpfile.Name())
 		return err
 	}
 	encoder := json.NewEncoder(tmpfile)
@@ -410,6 +510,13 @@ func (state *state) list(group string, all bool) ([]*Stateful, string, error) {
 		}
 		a = append(a, t)
 	}
+	sortTokens(a)
+	return a, state.etag(), nil
+}
+
+// sortTokens sorts a list of tokens by expiration time, tokens that
+// never expire first.
+func sortTokens(a []*Stateful) {
 	sort.Slice(a, func(i, j int) bool {
 		if a[j].Expires == nil {
 			return false
@@ -419,7 +526,6 @@ func (state *state) list(group string, all bool) ([]*Stateful, string, error) {
 		}
 		return (*a[i].Expires).Before(*a[j].Expires)
 	})
-	return a, state.etag(), nil
 }
 
 func (state *state) List(group string) ([]*Stateful, string, error) {
@@ -432,35 +538,149 @@ func List(group string) ([]*Stateful, string, error) {
 	return tokens.List(group)
 }
 
-func (state *state) Expire() error {
+func (state *state) ListAll() ([]*Stateful, string, error) {
 	state.mu.Lock()
 	defer state.mu.Unlock()
+	return state.list("", true)
+}
 
-	_, err := state.load()
+// ListAll returns the stateful tokens of all groups, including global
+// tokens.
+func ListAll() ([]*Stateful, string, error) {
+	return tokens.ListAll()
+}
+
+func (state *state) Replace(ts []*Stateful) error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.filename == "" {
+		return errors.New("tokens file not configured")
+	}
+
+	m := make(map[string]*Stateful, len(ts))
+	a := make([]*Stateful, 0, len(ts))
+	for _, t := range ts {
+		if t.Token == "" {
+			return errors.New("empty token")
+		}
+		tt := t.Clone()
+		m[t.Token] = tt
+		a = append(a, tt)
+	}
+
+	if len(a) == 0 {
+		state.reset()
+		err := os.Remove(state.filename)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(state.filename), 0700)
 	if err != nil {
 		return err
 	}
+	err = state.write(a)
+	if err != nil {
+		// force rereading next time
+		state.reset()
+		return err
+	}
+	state.tokens = m
+	return nil
+}
+
+// Replace replaces the set of all stateful tokens.  The tokens of each
+// group are replaced atomically.
+func Replace(ts []*Stateful) error {
+	return tokens.Replace(ts)
+}
+
+func (state *state) RevokeUser(group, username string, now time.Time) (int, error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.filename == "" {
+		return 0, nil
+	}
+
+	_, err := state.load()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for k, t := range state.tokens {
+		if t.Group != group || t.Username == nil ||
+			*t.Username != username {
+			continue
+		}
+		if t.Expires != nil && !t.Expires.After(now) {
+			continue
+		}
+		tt := t.Clone()
+		tt.Expires = &now
+		state.tokens[k] = tt
+		count++
+	}
+
+	if count > 0 {
+		err := state.rewrite()
+		if err != nil {
+			// force rereading next time
+			state.reset()
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// RevokeUser revokes all the unexpired tokens for the given group that
+// encode the given username, and returns the number of revoked tokens.
+func RevokeUser(group, username string) (int, error) {
+	return tokens.RevokeUser(group, username, time.Now())
+}
+
+func (state *state) Expire() (int, error) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	expired, err := state.expire()
+	return len(expired), err
+}
+
+// expire removes tokens that have expired for longer than the retention
+// period, and returns the removed tokens.
+// called locked
+func (state *state) expire() ([]string, error) {
+	_, err := state.load()
+	if err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
-	cutoff := now.Add(-time.Hour * 24 * 7)
+	cutoff := now.Add(-Retention())
 
-	modified := false
+	var expired []string
 	for k, t := range state.tokens {
 		if t.Expires != nil && t.Expires.Before(cutoff) {
 			delete(state.tokens, k)
-			modified = true
+			expired = append(expired, k)
 		}
 	}
 
-	if modified {
+	if len(expired) > 0 {
 		err := state.rewrite()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	return nil
+	return expired, nil
 }
 
-func Expire() error {
+// Expire removes tokens that have expired for longer than the retention
+// period, and returns the number of removed tokens.
+func Expire() (int, error) {
 	return tokens.Expire()
 }
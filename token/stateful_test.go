@@ -435,11 +435,234 @@ func TestExpire(t *testing.T) {
 	expectTokens(t, s.tokens, tokens)
 	expectTokenFile(t, s.filename, tokens)
 
-	err := s.Expire()
-	if err != nil {
-		t.Errorf("Expire: %v", err)
+	n, err := s.Expire()
+	if err != nil || n != 1 {
+		t.Errorf("Expire: %v %v", n, err)
 	}
 
 	expectTokens(t, s.tokens, tokens[:len(tokens)-1])
 	expectTokenFile(t, s.filename, tokens[:len(tokens)-1])
 }
+
+func TestExpireRetention(t *testing.T) {
+	d := t.TempDir()
+	s := state{
+		filename: filepath.Join(d, "test.jsonl"),
+	}
+	now := time.Now()
+	past := now.Add(-time.Hour * 2)
+	user := "user"
+
+	tokens := []*Stateful{
+		{
+			Token:    "tok1",
+			Group:    "test",
+			Username: &user,
+			Expires:  &now,
+		},
+		{
+			Token:    "tok2",
+			Group:    "test",
+			Username: &user,
+			Expires:  &past,
+		},
+	}
+	for _, token := range tokens {
+		_, err := s.Update(token, "")
+		if err != nil {
+			t.Errorf("Add: %v", err)
+		}
+	}
+
+	defer SetRetention(0)
+	SetRetention(time.Hour)
+	if Retention() != time.Hour {
+		t.Errorf("Retention: got %v", Retention())
+	}
+
+	n, err := s.Expire()
+	if err != nil || n != 1 {
+		t.Errorf("Expire: %v %v", n, err)
+	}
+	expectTokens(t, s.tokens, tokens[:1])
+
+	SetRetention(0)
+	if Retention() != DefaultRetention {
+		t.Errorf("Retention: got %v", Retention())
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	d := t.TempDir()
+	s := state{
+		filename: filepath.Join(d, "test.jsonl"),
+	}
+	now := time.Now()
+	future := now.Add(time.Hour)
+	user := "user"
+	other := "other"
+
+	tokens := []*Stateful{
+		{Token: "tok1", Group: "test", Username: &user},
+		{Token: "tok2", Group: "test", Username: &user, Expires: &future},
+		{Token: "tok3", Group: "test", Username: &other},
+		{Token: "tok4", Group: "other", Username: &user},
+		{Token: "tok5", Group: "test"},
+	}
+	for _, token := range tokens {
+		_, err := s.Update(token, "")
+		if err != nil {
+			t.Errorf("Add: %v", err)
+		}
+	}
+
+	n, err := s.RevokeUser("test", "user", now)
+	if err != nil || n != 2 {
+		t.Errorf("RevokeUser: expected 2, got %v %v", n, err)
+	}
+	for _, tok := range []string{"tok1", "tok2"} {
+		e := s.tokens[tok].Expires
+		if e == nil || !e.Equal(now) {
+			t.Errorf("Token %v not revoked: %v", tok, e)
+		}
+	}
+	for _, tok := range []string{"tok3", "tok4", "tok5"} {
+		if s.tokens[tok].Expires != nil {
+			t.Errorf("Token %v revoked", tok)
+		}
+	}
+	if tokens[0].Expires != nil {
+		t.Errorf("Caller's token modified")
+	}
+
+	var s2 state
+	s2.filename = s.filename
+	_, err = s2.load()
+	if err != nil || s2.tokens["tok1"].Expires == nil {
+		t.Errorf("Revocation not written: %v", err)
+	}
+
+	n, err = s.RevokeUser("test", "user", now.Add(time.Second))
+	if err != nil || n != 0 {
+		t.Errorf("RevokeUser: expected 0, got %v %v", n, err)
+	}
+}
+
+func TestUses(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.jsonl")
+	SetStatefulFilename(filename)
+	defer SetStatefulFilename("")
+
+	_, err := Update(&Stateful{Token: "tok", Group: "test"}, "")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	for _, tok := range []string{"tok", "tok", "unknown"} {
+		err := NoteUse(tok)
+		if err != nil {
+			t.Errorf("NoteUse %v: %v", tok, err)
+		}
+	}
+	tok, _, err := Get("tok")
+	if err != nil || tok.Uses != 2 {
+		t.Errorf("Uses: expected 2, got %v %v", tok, err)
+	}
+
+	// the count survives a restart
+	SetStatefulFilename(filename)
+	tok, _, err = Get("tok")
+	if err != nil || tok.Uses != 2 {
+		t.Errorf("Uses after restart: expected 2, got %v %v", tok, err)
+	}
+}
+
+func TestStatefulClockTolerance(t *testing.T) {
+	defer SetClockTolerance(0)
+
+	now := time.Now()
+	past := now.Add(-10 * time.Second)
+	future := now.Add(10 * time.Second)
+	expired := &Stateful{
+		Token:   "token",
+		Group:   "group",
+		Expires: &past,
+	}
+	early := &Stateful{
+		Token:     "token",
+		Group:     "group",
+		Expires:   &future,
+		NotBefore: &future,
+	}
+	user := "user"
+
+	SetClockTolerance(0)
+	if ClockTolerance() != DefaultClockTolerance {
+		t.Errorf("Expected %v, got %v",
+			DefaultClockTolerance, ClockTolerance())
+	}
+	_, _, err := expired.Check("", "group", &user)
+	if err == nil {
+		t.Errorf("Expired token succeeded")
+	}
+	_, _, err = early.Check("", "group", &user)
+	if err == nil {
+		t.Errorf("Early token succeeded")
+	}
+
+	SetClockTolerance(time.Minute)
+	_, _, err = expired.Check("", "group", &user)
+	if err != nil {
+		t.Errorf("Expired token within tolerance: %v", err)
+	}
+	_, _, err = early.Check("", "group", &user)
+	if err != nil {
+		t.Errorf("Early token within tolerance: %v", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	d := t.TempDir()
+	s := state{
+		filename: filepath.Join(d, "var", "test.jsonl"),
+	}
+	future := time.Now().Add(time.Hour)
+
+	tokens := []*Stateful{
+		{
+			Token:       "tok1",
+			Group:       "test",
+			Permissions: []string{"present", "message"},
+			Expires:     &future,
+		},
+		{
+			Token:       "tok2",
+			Permissions: []string{"message"},
+			Expires:     &future,
+		},
+	}
+
+	err := s.Replace(tokens)
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	expectTokens(t, s.tokens, tokens)
+	expectTokenFile(t, s.filename, tokens)
+
+	all, _, err := s.ListAll()
+	if err != nil || len(all) != 2 {
+		t.Errorf("ListAll: %v %v", all, err)
+	}
+
+	err = s.Replace(tokens[1:])
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	expectTokens(t, s.tokens, tokens[1:])
+	expectTokenFile(t, s.filename, tokens[1:])
+
+	err = s.Replace([]*Stateful{{Group: "test"}})
+	if err == nil {
+		t.Errorf("Replace with empty token succeeded")
+	}
+}
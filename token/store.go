@@ -0,0 +1,585 @@
+package token
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The stateful tokens of the server are stored in a directory, with one
+// JSONL file (a shard) per group, so that listing, modifying or expiring
+// the tokens of a single group only requires reading and rewriting the
+// tokens of that group.  The store maintains an index from tokens to
+// groups, which is rebuilt whenever one of the files in the directory
+// changes.
+type store struct {
+	mu sync.Mutex
+	// the file used by older versions, migrated on first access
+	filename string
+	dir      string
+	migrated bool
+	// the modification time and size of the shards when the index
+	// was built
+	stamps map[string]fileStamp
+	shards map[string]*state
+	index  map[string]string
+}
+
+func (s *store) setFilename(filename string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filename = filename
+	s.dir = ""
+	if filename != "" {
+		ext := filepath.Ext(filename)
+		if ext != "" {
+			s.dir = strings.TrimSuffix(filename, ext)
+		} else {
+			s.dir = filename + ".d"
+		}
+	}
+	s.migrated = false
+	s.reset()
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// called locked
+func (s *store) reset() {
+	s.stamps = nil
+	s.shards = nil
+	s.index = nil
+}
+
+// shardName returns the name of the file that holds the tokens of
+// a given group.  Characters that might be special to the filesystem,
+// including uppercase letters, are escaped.
+func shardName(group string) string {
+	if group == "" {
+		return "_.jsonl"
+	}
+	var b strings.Builder
+	for i := 0; i < len(group); i++ {
+		c := group[i]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || (c == '.' && i > 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	b.WriteString(".jsonl")
+	return b.String()
+}
+
+// shardGroup is the inverse of shardName.  It returns false if name is
+// not the name of a shard.
+func shardGroup(name string) (string, bool) {
+	n, found := strings.CutSuffix(name, ".jsonl")
+	if !found {
+		return "", false
+	}
+	if n == "_" {
+		return "", true
+	}
+	group, err := url.PathUnescape(n)
+	if err != nil || shardName(group) != name {
+		return "", false
+	}
+	return group, true
+}
+
+// migrate moves the tokens stored in the file used by older versions
+// into the shards.
+// called locked
+func (s *store) migrate() error {
+	if s.migrated {
+		return nil
+	}
+
+	_, err := os.Stat(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		s.migrated = true
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	legacy := state{filename: s.filename}
+	ts, _, err := legacy.ListAll()
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]*Stateful)
+	for _, t := range ts {
+		groups[t.Group] = append(groups[t.Group], t)
+	}
+	for g, a := range groups {
+		st := &state{filename: filepath.Join(s.dir, shardName(g))}
+		old, _, err := st.ListAll()
+		if err != nil {
+			return err
+		}
+		// tokens already in the shard take precedence
+		for _, t := range old {
+			found := false
+			for i := range a {
+				if a[i].Token == t.Token {
+					a[i] = t
+					found = true
+					break
+				}
+			}
+			if !found {
+				a = append(a, t)
+			}
+		}
+		err = st.Replace(a)
+		if err != nil {
+			return err
+		}
+	}
+
+	// keep a copy in case the migration needs to be undone
+	err = os.Rename(s.filename, s.filename+".bak")
+	if err != nil {
+		return err
+	}
+	log.Printf("Migrated %v stateful tokens to %v, old file kept as %v",
+		len(ts), s.dir, s.filename+".bak")
+	s.migrated = true
+	s.reset()
+	return nil
+}
+
+// readStamps returns the modification times and sizes of the shards.
+// called locked
+func (s *store) readStamps() (map[string]fileStamp, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]fileStamp)
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if _, ok := shardGroup(e.Name()); !ok {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		stamps[e.Name()] = fileStamp{fi.ModTime(), fi.Size()}
+	}
+	return stamps, nil
+}
+
+// refresh updates the set of shards and the index if any of the shards
+// has been modified.
+// called locked
+func (s *store) refresh() error {
+	if s.dir == "" {
+		s.reset()
+		return nil
+	}
+
+	err := s.migrate()
+	if err != nil {
+		return err
+	}
+
+	stamps, err := s.readStamps()
+	if err != nil {
+		s.reset()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if s.shards != nil && reflect.DeepEqual(stamps, s.stamps) {
+		return nil
+	}
+
+	shards := make(map[string]*state)
+	index := make(map[string]string)
+	for name := range stamps {
+		g, _ := shardGroup(name)
+		st := s.shards[g]
+		if st == nil {
+			st = &state{filename: filepath.Join(s.dir, name)}
+		}
+		ts, _, err := st.ListAll()
+		if err != nil {
+			log.Printf("Read tokens for group %v: %v", g, err)
+			continue
+		}
+		shards[g] = st
+		for _, t := range ts {
+			index[t.Token] = g
+		}
+	}
+	s.shards = shards
+	s.index = index
+	s.stamps = stamps
+	return nil
+}
+
+// noteWrite records that we have modified a shard, which avoids
+// rebuilding the index on the next access.
+// called locked
+func (s *store) noteWrite() {
+	stamps, err := s.readStamps()
+	if err != nil {
+		s.reset()
+		return
+	}
+	s.stamps = stamps
+}
+
+// shard returns the shard for a given group, creating it if necessary.
+// called locked
+func (s *store) shard(group string) *state {
+	st := s.shards[group]
+	if st == nil {
+		st = &state{filename: filepath.Join(s.dir, shardName(group))}
+		if s.shards == nil {
+			s.shards = make(map[string]*state)
+		}
+		s.shards[group] = st
+	}
+	return st
+}
+
+// called locked
+func (s *store) forget(token string) {
+	delete(s.index, token)
+}
+
+func (s *store) Get(token string) (*Stateful, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return nil, "", err
+	}
+	g, ok := s.index[token]
+	if !ok {
+		return nil, "", os.ErrNotExist
+	}
+	t, etag, err := s.shard(g).Get(token)
+	if errors.Is(err, os.ErrNotExist) {
+		// the shard was modified behind our back
+		delete(s.index, token)
+	}
+	return t, etag, err
+}
+
+func (s *store) NoteUse(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return err
+	}
+	g, ok := s.index[token]
+	if !ok {
+		return nil
+	}
+	defer s.noteWrite()
+	err = s.shard(g).noteUse(token)
+	if errors.Is(err, os.ErrNotExist) {
+		// the shard was modified behind our back
+		delete(s.index, token)
+		return nil
+	}
+	return err
+}
+
+func (s *store) Update(token *Stateful, etag string) (*Stateful, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		if etag != "" {
+			return nil, ErrTagMismatch
+		}
+		return nil, os.ErrNotExist
+	}
+
+	err := s.refresh()
+	if err != nil {
+		return nil, err
+	}
+	defer s.noteWrite()
+
+	g, ok := s.index[token.Token]
+	if !ok || g == token.Group {
+		t, err := s.shard(token.Group).Update(token, etag)
+		if err != nil {
+			return nil, err
+		}
+		if s.index == nil {
+			s.index = make(map[string]string)
+		}
+		s.index[token.Token] = token.Group
+		return t, nil
+	}
+
+	// The token moves to a different group.  The etag is that of the
+	// old shard, so check it before adding the token to the new one.
+	old := s.shard(g)
+	_, oldEtag, err := old.Get(token.Token)
+	if err != nil {
+		return nil, err
+	}
+	if etag != oldEtag {
+		return nil, ErrTagMismatch
+	}
+	t, err := s.shard(token.Group).Update(token, "")
+	if err != nil {
+		return nil, err
+	}
+	s.index[token.Token] = token.Group
+	err = old.Delete(token.Token, etag)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *store) Add(token *Stateful, max int, now time.Time) (*Stateful, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		return nil, os.ErrNotExist
+	}
+
+	err := s.refresh()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.index[token.Token]; ok {
+		return nil, os.ErrExist
+	}
+	defer s.noteWrite()
+
+	t, err := s.shard(token.Group).Add(token, max, now)
+	if err != nil {
+		return nil, err
+	}
+	if s.index == nil {
+		s.index = make(map[string]string)
+	}
+	s.index[token.Token] = token.Group
+	return t, nil
+}
+
+func (s *store) Delete(token string, etag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return err
+	}
+	g, ok := s.index[token]
+	if !ok {
+		return os.ErrNotExist
+	}
+	defer s.noteWrite()
+	err = s.shard(g).Delete(token, etag)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		s.forget(token)
+	}
+	return err
+}
+
+func (s *store) List(group string) ([]*Stateful, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return nil, "", err
+	}
+	st := s.shards[group]
+	if st == nil {
+		return make([]*Stateful, 0), "", nil
+	}
+	a, etag, err := st.List(group)
+	if err != nil {
+		return nil, "", err
+	}
+	// the shard may have been appended to behind our back
+	for _, t := range a {
+		s.index[t.Token] = group
+	}
+	return a, etag, nil
+}
+
+func (s *store) ListAll() ([]*Stateful, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return nil, "", err
+	}
+
+	groups := make([]string, 0, len(s.shards))
+	for g := range s.shards {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	a := make([]*Stateful, 0)
+	if len(groups) == 0 {
+		return a, "", nil
+	}
+	h := sha256.New()
+	for _, g := range groups {
+		ts, etag, err := s.shards[g].ListAll()
+		if err != nil {
+			return nil, "", err
+		}
+		a = append(a, ts...)
+		fmt.Fprintf(h, "%v %v\n", shardName(g), etag)
+	}
+	sortTokens(a)
+	return a, fmt.Sprintf("\"%x\"", h.Sum(nil)[:16]), nil
+}
+
+func (s *store) Replace(ts []*Stateful) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dir == "" {
+		return errors.New("tokens file not configured")
+	}
+
+	groups := make(map[string][]*Stateful)
+	for _, t := range ts {
+		if t.Token == "" {
+			return errors.New("empty token")
+		}
+		groups[t.Group] = append(groups[t.Group], t)
+	}
+
+	err := s.refresh()
+	if err != nil {
+		return err
+	}
+	defer s.noteWrite()
+
+	for g := range s.shards {
+		if _, ok := groups[g]; !ok {
+			groups[g] = nil
+		}
+	}
+
+	for g, a := range groups {
+		st := s.shard(g)
+		old, _, err := st.ListAll()
+		if err != nil {
+			return err
+		}
+		if sameTokens(old, a) {
+			continue
+		}
+		err = st.Replace(a)
+		if err != nil {
+			return err
+		}
+		if len(a) == 0 {
+			delete(s.shards, g)
+		}
+	}
+
+	s.index = make(map[string]string, len(ts))
+	for _, t := range ts {
+		s.index[t.Token] = t.Group
+	}
+	return nil
+}
+
+// sameTokens returns true if a and b contain the same tokens, in any
+// order.
+func sameTokens(a, b []*Stateful) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]*Stateful, len(a))
+	for _, t := range a {
+		m[t.Token] = t
+	}
+	for _, t := range b {
+		if !reflect.DeepEqual(m[t.Token], t) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *store) RevokeUser(group, username string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return 0, err
+	}
+	st := s.shards[group]
+	if st == nil {
+		return 0, nil
+	}
+	defer s.noteWrite()
+	return st.RevokeUser(group, username, now)
+}
+
+func (s *store) Expire() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.refresh()
+	if err != nil {
+		return 0, err
+	}
+	defer s.noteWrite()
+
+	count := 0
+	for g, st := range s.shards {
+		st.mu.Lock()
+		expired, err := st.expire()
+		st.mu.Unlock()
+		if err != nil {
+			log.Printf("Expire tokens for group %v: %v", g, err)
+			continue
+		}
+		for _, t := range expired {
+			s.forget(t)
+		}
+		count += len(expired)
+	}
+	return count, nil
+}
@@ -0,0 +1,329 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShardName(t *testing.T) {
+	groups := []string{
+		"", "test", "Test", "a/b", "a_b", ".hidden", "a.b", "..",
+		"café", "100%",
+	}
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		name := shardName(g)
+		if filepath.Base(name) != name || name[0] == '.' {
+			t.Errorf("Bad shard name %q for %q", name, g)
+		}
+		if seen[name] {
+			t.Errorf("Duplicate shard name %q", name)
+		}
+		seen[name] = true
+		gg, ok := shardGroup(name)
+		if !ok || gg != g {
+			t.Errorf("Expected %q, got %q (%v)", g, gg, ok)
+		}
+	}
+
+	for _, name := range []string{"test", "tokens123", "Test.jsonl", "a%2fb.jsonl"} {
+		g, ok := shardGroup(name)
+		if ok {
+			t.Errorf("%q is not a shard, got %q", name, g)
+		}
+	}
+}
+
+func newTestStore(t *testing.T) (*store, string) {
+	d := t.TempDir()
+	var s store
+	s.setFilename(filepath.Join(d, "tokens.jsonl"))
+	return &s, filepath.Join(d, "tokens")
+}
+
+func TestStoreShards(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	for _, tok := range []*Stateful{
+		{Token: "a1", Group: "a"},
+		{Token: "a2", Group: "a"},
+		{Token: "b1", Group: "b"},
+		{Token: "g1", Group: ""},
+	} {
+		_, err := s.Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	for _, name := range []string{"a.jsonl", "b.jsonl", "_.jsonl"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Stat %v: %v", name, err)
+		}
+	}
+
+	a, etagA, err := s.List("a")
+	if err != nil || len(a) != 2 {
+		t.Errorf("List a: %v %v", a, err)
+	}
+	b, etagB, err := s.List("b")
+	if err != nil || len(b) != 1 {
+		t.Errorf("List b: %v %v", b, err)
+	}
+	all, _, err := s.ListAll()
+	if err != nil || len(all) != 4 {
+		t.Errorf("ListAll: %v %v", all, err)
+	}
+	c, etagC, err := s.List("c")
+	if err != nil || len(c) != 0 || etagC != "" {
+		t.Errorf("List c: %v %v %v", c, etagC, err)
+	}
+
+	// modifying a group doesn't change the etags of other groups
+	tok, etag, err := s.Get("a1")
+	if err != nil || etag != etagA {
+		t.Fatalf("Get: %v %v", etag, err)
+	}
+	tok = tok.Clone()
+	tok.Username = new(string)
+	*tok.Username = "user"
+	_, err = s.Update(tok, etag)
+	if err != nil {
+		t.Errorf("Update: %v", err)
+	}
+	_, etag, _ = s.List("b")
+	if etag != etagB {
+		t.Errorf("Etag changed: %v != %v", etag, etagB)
+	}
+	_, err = s.Update(tok, etagA)
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Update with old etag: %v", err)
+	}
+
+	// a fresh store rebuilds the index from the directory
+	var s2 store
+	s2.setFilename(s.filename)
+	tok, _, err = s2.Get("b1")
+	if err != nil || tok.Group != "b" {
+		t.Errorf("Get: %v %v", tok, err)
+	}
+	tok, _, err = s2.Get("a1")
+	if err != nil || tok.Username == nil || *tok.Username != "user" {
+		t.Errorf("Get: %v %v", tok, err)
+	}
+
+	_, etag, _ = s.Get("b1")
+	err = s.Delete("b1", etag)
+	if err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "b.jsonl"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Empty shard not removed: %v", err)
+	}
+	_, _, err = s2.Get("b1")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get deleted token: %v", err)
+	}
+
+	// modifying a shard in place doesn't change the directory
+	f, err := os.OpenFile(
+		filepath.Join(dir, "a.jsonl"), os.O_WRONLY|os.O_APPEND, 0,
+	)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	json.NewEncoder(f).Encode(&Stateful{Token: "a3", Group: "a"})
+	f.Close()
+	tok, _, err = s.Get("a3")
+	if err != nil || tok.Group != "a" {
+		t.Errorf("Get token added in place: %v %v", tok, err)
+	}
+}
+
+func TestStoreMove(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	_, err := s.Update(&Stateful{Token: "tok", Group: "a"}, "")
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	tok, etag, err := s.Get("tok")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	tok = tok.Clone()
+	tok.Group = "b"
+	_, err = s.Update(tok, "\"bad\"")
+	if !errors.Is(err, ErrTagMismatch) {
+		t.Errorf("Update with bad etag: %v", err)
+	}
+	_, err = s.Update(tok, etag)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	a, _, _ := s.List("a")
+	b, _, _ := s.List("b")
+	if len(a) != 0 || len(b) != 1 || b[0].Token != "tok" {
+		t.Errorf("Bad lists %v %v", a, b)
+	}
+	tok, _, err = s.Get("tok")
+	if err != nil || tok.Group != "b" {
+		t.Errorf("Get: %v %v", tok, err)
+	}
+}
+
+func TestStoreMigrate(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	f, err := os.Create(s.filename)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	encoder := json.NewEncoder(f)
+	for _, tok := range []*Stateful{
+		{Token: "a1", Group: "a", Permissions: []string{"message"}},
+		{Token: "b1", Group: "b", Permissions: []string{"message"}},
+		{Token: "b2", Group: "b", Permissions: []string{"message"}},
+	} {
+		encoder.Encode(tok)
+	}
+	f.Close()
+
+	all, _, err := s.ListAll()
+	if err != nil || len(all) != 3 {
+		t.Errorf("ListAll: %v %v", all, err)
+	}
+	_, err = os.Stat(s.filename)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Legacy file not removed: %v", err)
+	}
+	_, err = os.Stat(s.filename + ".bak")
+	if err != nil {
+		t.Errorf("Legacy file not kept: %v", err)
+	}
+	expectTokenFile(t, filepath.Join(dir, "b.jsonl"), []*Stateful{
+		{Token: "b1", Group: "b", Permissions: []string{"message"}},
+		{Token: "b2", Group: "b", Permissions: []string{"message"}},
+	})
+	tok, _, err := s.Get("a1")
+	if err != nil || tok.Group != "a" {
+		t.Errorf("Get: %v %v", tok, err)
+	}
+}
+
+func TestStoreReplace(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	err := s.Replace([]*Stateful{
+		{Token: "a1", Group: "a"},
+		{Token: "b1", Group: "b"},
+	})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	_, etagA, _ := s.List("a")
+
+	err = s.Replace([]*Stateful{
+		{Token: "a1", Group: "a"},
+		{Token: "c1", Group: "c"},
+	})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+
+	_, etag, _ := s.List("a")
+	if etag != etagA {
+		t.Errorf("Unchanged shard was rewritten")
+	}
+	_, err = os.Stat(filepath.Join(dir, "b.jsonl"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Shard not removed: %v", err)
+	}
+	_, _, err = s.Get("b1")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Get: %v", err)
+	}
+	tok, _, err := s.Get("c1")
+	if err != nil || tok.Group != "c" {
+		t.Errorf("Get: %v %v", tok, err)
+	}
+}
+
+func TestStoreExpire(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	now := time.Now()
+	old := now.Add(-time.Hour * 24 * 8)
+	for _, tok := range []*Stateful{
+		{Token: "a1", Group: "a", Expires: &old},
+		{Token: "a2", Group: "a", Expires: &now},
+		{Token: "b1", Group: "b", Expires: &old},
+	} {
+		_, err := s.Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+	_, etag, _ := s.Get("b1")
+
+	n, err := s.Expire()
+	if err != nil || n != 2 {
+		t.Fatalf("Expire: %v %v", n, err)
+	}
+
+	all, _, err := s.ListAll()
+	if err != nil || len(all) != 1 || all[0].Token != "a2" {
+		t.Errorf("ListAll: %v %v", all, err)
+	}
+	err = s.Delete("b1", etag)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete expired token: %v", err)
+	}
+}
+
+func TestStoreAdd(t *testing.T) {
+	s, _ := newTestStore(t)
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	for _, tok := range []*Stateful{
+		{Token: "a1", Group: "a", Expires: &future},
+		{Token: "a2", Group: "a", Expires: &past},
+		{Token: "a3", Group: "a", Expires: &future},
+		{Token: "b1", Group: "b", Expires: &future},
+	} {
+		_, err := s.Add(tok, 2, now)
+		if err != nil {
+			t.Fatalf("Add %v: %v", tok.Token, err)
+		}
+	}
+
+	_, err := s.Add(&Stateful{Token: "a4", Group: "a", Expires: &future},
+		2, now)
+	if !errors.Is(err, ErrQuota) {
+		t.Errorf("Expected ErrQuota, got %v", err)
+	}
+	_, err = s.Add(&Stateful{Token: "a4", Group: "a", Expires: &future},
+		0, now)
+	if err != nil {
+		t.Errorf("Add without quota: %v", err)
+	}
+	_, err = s.Add(&Stateful{Token: "b1", Group: "b", Expires: &future},
+		0, now)
+	if !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected ErrExist, got %v", err)
+	}
+
+	ts, _, err := s.List("a")
+	if err != nil || len(ts) != 4 {
+		t.Errorf("List: %v %v", len(ts), err)
+	}
+}
@@ -2,12 +2,63 @@ package token
 
 import (
 	"errors"
+	"sync/atomic"
+	"time"
 )
 
 var ErrUsernameRequired = errors.New("username required")
 
+// DefaultClockTolerance is the default value of the clock tolerance.
+const DefaultClockTolerance = 5 * time.Second
+
+var clockTolerance atomic.Int64
+
+// SetClockTolerance sets the amount by which a token's validity period
+// is extended on both sides in order to account for clock skew between
+// the issuer and the server.  A value of 0 restores the default.
+func SetClockTolerance(d time.Duration) {
+	if d <= 0 {
+		d = DefaultClockTolerance
+	}
+	clockTolerance.Store(int64(d))
+}
+
+// ClockTolerance returns the value set by SetClockTolerance.
+func ClockTolerance() time.Duration {
+	d := time.Duration(clockTolerance.Load())
+	if d <= 0 {
+		return DefaultClockTolerance
+	}
+	return d
+}
+
+// DefaultRetention is the default value of the retention period.
+const DefaultRetention = 7 * 24 * time.Hour
+
+var retention atomic.Int64
+
+// SetRetention sets the amount of time during which expired tokens are
+// kept before being removed by Expire.  A value of 0 restores the
+// default.
+func SetRetention(d time.Duration) {
+	if d <= 0 {
+		d = DefaultRetention
+	}
+	retention.Store(int64(d))
+}
+
+// Retention returns the value set by SetRetention.
+func Retention() time.Duration {
+	d := time.Duration(retention.Load())
+	if d <= 0 {
+		return DefaultRetention
+	}
+	return d
+}
+
 type Token interface {
 	Check(host, group string, username *string) (string, []string, error)
+	GetLimits() *Limits
 }
 
 func Parse(token string, keys []map[string]interface{}) (Token, error) {
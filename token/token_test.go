@@ -9,9 +9,8 @@ import (
 
 func TestToken(t *testing.T) {
 	d := t.TempDir()
-	tokens = state{
-		filename: filepath.Join(d, "test.jsonl"),
-	}
+	SetStatefulFilename(filepath.Join(d, "test.jsonl"))
+	defer SetStatefulFilename("")
 
 	f, err := os.OpenFile(tokens.filename,
 		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600,
@@ -0,0 +1,134 @@
+package webserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jech/cert"
+
+	"github.com/jech/galene/fips"
+)
+
+// The administrative API may be served by a separate listener, typically
+// bound to a loopback or management address, in which case it is no
+// longer reachable through the main web server.
+
+var adminServer *http.Server
+
+// adminSeparate is true if the API is served by a separate listener.
+var adminSeparate atomic.Bool
+
+// publicAPIHandler serves the API on the main web server, unless it is
+// served by a separate listener.
+func publicAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if adminSeparate.Load() {
+		http.NotFound(w, r)
+		return
+	}
+	apiHandler(w, r)
+}
+
+// adminTLSConfig returns the TLS configuration of the admin listener.
+// The certificate is read from the files admin-cert.pem and
+// admin-key.pem, falling back to the certificate of the main server.  If
+// the file admin-ca.pem exists, then clients must present a certificate
+// signed by one of the authorities that it contains.
+func adminTLSConfig(dataDir string) (*tls.Config, error) {
+	certFile := filepath.Join(dataDir, "admin-cert.pem")
+	keyFile := filepath.Join(dataDir, "admin-key.pem")
+	_, err := os.Stat(certFile)
+	if errors.Is(err, os.ErrNotExist) {
+		certFile = filepath.Join(dataDir, "cert.pem")
+		keyFile = filepath.Join(dataDir, "key.pem")
+	} else if err != nil {
+		return nil, err
+	}
+	certificate := cert.New(certFile, keyFile)
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate.Get()
+		},
+	}
+
+	ca, err := os.ReadFile(filepath.Join(dataDir, "admin-ca.pem"))
+	if err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificates found in admin-ca.pem")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	fips.ConfigureTLS(config)
+	return config, nil
+}
+
+// ServeAdmin serves the administrative API on a separate listener.
+func ServeAdmin(address string, dataDir string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/galene-api/", apiHandler)
+
+	s := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	if !Insecure {
+		config, err := adminTLSConfig(dataDir)
+		if err != nil {
+			return err
+		}
+		s.TLSConfig = config
+	}
+
+	proto := "tcp"
+	if strings.HasPrefix(address, "/") {
+		proto = "unix"
+	}
+
+	listener, err := net.Listen(proto, address)
+	if err != nil {
+		return err
+	}
+
+	adminServer = s
+	adminSeparate.Store(true)
+
+	go func() {
+		defer listener.Close()
+		var err error
+		if !Insecure {
+			err = s.ServeTLS(listener, "", "")
+		} else {
+			err = s.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("Admin server: %v", err)
+		}
+	}()
+	return nil
+}
+
+func shutdownAdmin(ctx context.Context) {
+	if adminServer == nil {
+		return
+	}
+	adminServer.Shutdown(ctx)
+	adminServer = nil
+}
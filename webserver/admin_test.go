@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCA(t *testing.T, filename string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, &template, &template, &key.PublicKey, key,
+	)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	err = os.WriteFile(filename,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		0600,
+	)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestAdminTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := adminTLSConfig(dir)
+	if err != nil {
+		t.Fatalf("adminTLSConfig: %v", err)
+	}
+	if config.ClientAuth != tls.NoClientCert || config.ClientCAs != nil {
+		t.Errorf("Client certificates required without a CA")
+	}
+
+	ca := filepath.Join(dir, "admin-ca.pem")
+	err = os.WriteFile(ca, []byte("garbage"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, err = adminTLSConfig(dir)
+	if err == nil {
+		t.Errorf("Bad CA file was accepted")
+	}
+
+	writeCA(t, ca)
+	config, err = adminTLSConfig(dir)
+	if err != nil {
+		t.Fatalf("adminTLSConfig: %v", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert ||
+		config.ClientCAs == nil {
+		t.Errorf("Client certificates not required")
+	}
+}
+
+func TestPublicAPIHandler(t *testing.T) {
+	adminSeparate.Store(true)
+	defer adminSeparate.Store(false)
+
+	r := httptest.NewRequest("GET", "/galene-api/v0/.stats", nil)
+	w := httptest.NewRecorder()
+	publicAPIHandler(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", w.Code)
+	}
+}
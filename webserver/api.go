@@ -3,26 +3,52 @@ package webserver
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"github.com/jech/galene/diskwriter"
 	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
 	"github.com/jech/galene/stats"
 	"github.com/jech/galene/token"
 )
 
-// checkAdmin checks whether the client authentifies as an administrator
+// checkAdmin checks whether the client authentifies as a server-wide
+// user or presents an API token whose scopes allow the request.
 func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
-	username, password, ok := r.BasicAuth()
-	if ok {
-		ok, _ = adminMatch(username, password)
+	return checkScope(w, r, "", "")
+}
+
+// checkStats is like checkAdmin, but also accepts clients that are
+// allowed to read statistics.
+func checkStats(w http.ResponseWriter, r *http.Request) bool {
+	return checkScope(w, r, "stats", "read")
+}
+
+// checkAnnounce is like checkAdmin, but also accepts clients that are
+// allowed to post to announcement channels.
+func checkAnnounce(w http.ResponseWriter, r *http.Request) bool {
+	return checkScope(w, r, "announce", "write")
+}
+
+func checkScope(w http.ResponseWriter, r *http.Request, resource, action string) bool {
+	ok, err := scopeMatch(r, resource, action)
+	if err != nil {
+		internalError(w, "Admin match: %v", err)
+		return false
 	}
 	if !ok {
 		failAuthentication(w, "/galene-api/")
@@ -31,21 +57,51 @@ func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
 	return true
 }
 
+// scopeMatch returns true if the credentials carried by r allow the
+// request, or, if resource is not empty, allow performing action on
+// resource in all groups.
+func scopeMatch(r *http.Request, resource, action string) (bool, error) {
+	res, act, g := requestScope(r)
+	_, _, ok := r.BasicAuth()
+	if !ok && res == "" {
+		// API tokens may only perform the requests known to
+		// requestScope
+		return false, nil
+	}
+	scopes, err := requestScopes(r)
+	if err != nil {
+		return false, err
+	}
+	if group.ScopesAllow(scopes, res, act, g) {
+		return true, nil
+	}
+	return resource != "" &&
+		group.ScopesAllow(scopes, resource, action, ""), nil
+}
+
+// requestScopes returns the API scopes granted by the credentials carried
+// by r, which are either those of a server-wide user or an API token.
+func requestScopes(r *http.Request) ([]string, error) {
+	username, password, ok := r.BasicAuth()
+	if ok {
+		return serverScopes(username, password)
+	}
+	return apiTokenScopes(r), nil
+}
+
 // checkPasswordAdmin checks whether the client authentifies as either an
 // administrator or the given user.  It is used to check whether the
 // client has the right to change user's password.
 func checkPasswordAdmin(w http.ResponseWriter, r *http.Request, groupname, user string, wildcard bool) bool {
-	username, password, ok := r.BasicAuth()
+	ok, err := scopeMatch(r, "", "")
+	if err != nil {
+		internalError(w, "Admin match: %v", err)
+		return false
+	}
 	if ok {
-		ok, err := adminMatch(username, password)
-		if err != nil {
-			internalError(w, "Admin match: %v", err)
-			return false
-		}
-		if ok {
-			return true
-		}
+		return true
 	}
+	username, password, ok := r.BasicAuth()
 	if ok && !wildcard && username == user {
 		desc, err := group.GetDescription(groupname)
 		if err != nil {
@@ -147,14 +203,14 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	switch kind {
 	case ".stats":
-		if rest != "" {
+		if rest != "" && rest != "/.connections" {
 			http.NotFound(w, r)
 			return
 		}
 		if apiCORS(w, r, "HEAD, GET") {
 			return
 		}
-		if !checkAdmin(w, r) {
+		if !checkStats(w, r) {
 			return
 		}
 		if r.Method != "HEAD" && r.Method != "GET" {
@@ -162,21 +218,572 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.Header().Set("cache-control", "no-cache")
+		if rest == "/.connections" {
+			sendJSON(w, r, stats.GetConnections())
+			return
+		}
 		sendJSON(w, r, stats.GetGroups())
 	case ".groups":
 		apiGroupHandler(w, r, rest)
+	case ".archive":
+		archiveHandler(w, r, rest)
+	case ".announce":
+		announceHandler(w, r, rest)
+	case ".replica":
+		replicaHandler(w, r, rest)
+	case ".api-tokens":
+		apiTokensHandler(w, r, rest)
+	case ".users":
+		globalUsersHandler(w, r, rest)
+	case ".reload":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		if apiCORS(w, r, "POST") {
+			return
+		}
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		group.Reload()
+		w.WriteHeader(http.StatusNoContent)
+	case ".expire-tokens":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		if apiCORS(w, r, "POST") {
+			return
+		}
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		n, err := token.Expire()
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sendJSON(w, r, map[string]int{"expired": n})
+	case ".health":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		healthHandler(w, r)
+	case ".ready":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		readyHandler(w, r)
+	case ".maintenance":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		maintenanceHandler(w, r)
+	case ".introspect":
+		if rest != "" {
+			http.NotFound(w, r)
+			return
+		}
+		introspectHandler(w, r)
 	default:
 		http.NotFound(w, r)
 	}
 }
 
-func apiGroupHandler(w http.ResponseWriter, r *http.Request, pth string) {
-	first, kind, rest := splitPath(pth)
-	g := ""
-	if first != "" {
-		g = first[1:]
-	}
-	if g == "" && kind == "" {
+func introspectHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "POST") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	done := getJSON(w, r, &req)
+	if done {
+		return
+	}
+	if req.Token == "" {
+		http.Error(w, "no token provided", http.StatusBadRequest)
+		return
+	}
+
+	info, err := group.IntrospectToken(req.Token)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("cache-control", "no-store")
+	sendJSON(w, r, info)
+}
+
+// listGroups returns the names of the groups that the client may read.
+// A client whose scopes are restricted to some groups only sees these
+// groups; if it may read none, the request fails.
+func listGroups(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	scopes, err := requestScopes(r)
+	if err != nil {
+		internalError(w, "Admin match: %v", err)
+		return nil, false
+	}
+	all := group.ScopesAllow(scopes, "groups", "read", "")
+	if !all && len(scopes) == 0 {
+		failAuthentication(w, "/galene-api/")
+		return nil, false
+	}
+
+	names, err := group.GetDescriptionNames()
+	if err != nil {
+		httpError(w, err)
+		return nil, false
+	}
+	if all {
+		return names, true
+	}
+	groups := make([]string, 0, len(names))
+	for _, g := range names {
+		if group.ScopesAllow(scopes, "groups", "read", g) {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		failAuthentication(w, "/galene-api/")
+		return nil, false
+	}
+	return groups, true
+}
+
+func apiGroupHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	first, kind, rest := splitPath(pth)
+	g := ""
+	if first != "" {
+		g = first[1:]
+	}
+	if g == "" && kind == "" {
+		if apiCORS(w, r, "HEAD, GET") {
+			return
+		}
+		groups, ok := listGroups(w, r)
+		if !ok {
+			return
+		}
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD, GET")
+			return
+		}
+		if r.URL.Query().Get("status") != "" {
+			w.Header().Set("cache-control", "no-cache")
+			sendJSON(w, r, groupsStatus(groups))
+			return
+		}
+		sendJSON(w, r, groups)
+		return
+	}
+
+	if kind == ".users" {
+		usersHandler(w, r, g, rest)
+		return
+	} else if kind == ".empty-user" {
+		specialUserHandler(w, r, g, rest, false)
+		return
+	} else if kind == ".wildcard-user" {
+		specialUserHandler(w, r, g, rest, true)
+		return
+	} else if kind == ".keys" && rest == "" {
+		keysHandler(w, r, g)
+		return
+	} else if kind == ".tokens" {
+		tokensHandler(w, r, g, rest)
+		return
+	} else if kind == ".clients" && rest == "/" {
+		clientsHandler(w, r, g)
+		return
+	} else if kind == ".archive" && rest == "" {
+		archiveGroupHandler(w, r, g)
+		return
+	} else if kind == ".drain" && rest == "" {
+		drainHandler(w, r, g)
+		return
+	} else if kind == ".prune-recordings" && rest == "" {
+		pruneRecordingsHandler(w, r, g)
+		return
+	} else if kind == ".recording" && rest == "" {
+		recordingHandler(w, r, g)
+		return
+	} else if kind == ".recordings" && rest != "" {
+		recordingsAPIHandler(w, r, g, rest)
+		return
+	} else if kind == ".rtsp" {
+		rtspHandler(w, r, g, rest)
+		return
+	} else if kind != "" {
+		if !checkAdmin(w, r) {
+			return
+		}
+		notFound(w)
+		return
+	}
+
+	if apiCORS(w, r, "HEAD, GET, PUT, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		desc, etag, err := group.GetSanitisedDescription(
+			g, r.URL.Query().Get("expand") != "",
+		)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		w.Header().Set("etag", etag)
+
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+
+		sendJSON(w, r, desc)
+		return
+	} else if r.Method == "PUT" {
+		etag, err := group.GetDescriptionTag(g)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+			etag = ""
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+
+		var newdesc group.Description
+		done = getJSON(w, r, &newdesc)
+		if done {
+			return
+		}
+		err = group.UpdateDescription(g, etag, &newdesc)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		if etag == "" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	} else if r.Method == "DELETE" {
+		etag, err := group.GetDescriptionTag(g)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+		err = group.DeleteDescription(g, etag)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
+	return
+}
+
+func archiveGroupHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "POST") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	err := group.ArchiveDescription(g)
+	if errors.Is(err, group.ErrBusy) {
+		http.Error(w, "group is busy", http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type apiDrain struct {
+	Draining bool `json:"draining"`
+}
+
+// drainHandler serves the drain mode of a group.  POST starts draining
+// the group, DELETE stops draining it.
+func drainHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, POST, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		var drain apiDrain
+		gg := group.Get(g)
+		if gg == nil {
+			// the group is not running, check whether it exists
+			_, err := group.GetDescriptionTag(g)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+		} else {
+			drain.Draining = gg.Draining()
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, drain)
+		return
+	} else if r.Method == "POST" || r.Method == "DELETE" {
+		gg, err := group.Add(g, nil)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		gg.SetDraining(r.Method == "POST")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, POST, DELETE")
+}
+
+func pruneRecordingsHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "POST") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	result, err := diskwriter.PruneGroup(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	sendJSON(w, r, result)
+}
+
+type apiRecordingStatus struct {
+	Recording bool   `json:"recording"`
+	Format    string `json:"format,omitempty"`
+	Layout    string `json:"layout,omitempty"`
+}
+
+// recordingHandler arms or disarms recording of a group, which doesn't
+// require any client to be connected.
+func recordingHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, POST, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		_, err := group.GetDescription(g)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		var status apiRecordingStatus
+		if gg := group.Get(g); gg != nil {
+			if disk := diskwriter.Recording(gg); disk != nil {
+				status.Recording = true
+				status.Format = disk.Format()
+				status.Layout = disk.Layout()
+			}
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, status)
+		return
+	} else if r.Method == "POST" {
+		gg, err := group.Add(g, nil)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		_, err = diskwriter.Start(gg,
+			r.URL.Query().Get("format"), r.URL.Query().Get("layout"),
+		)
+		if errors.Is(err, diskwriter.ErrAlreadyRecording) ||
+			errors.Is(err, diskwriter.ErrRecordingDisabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			var uerr group.UserError
+			if errors.As(err, &uerr) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			httpError(w, err)
+			return
+		}
+		gg.NotifyWebhooks(group.WebhookEvent{Kind: "record"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "DELETE" {
+		gg := group.Get(g)
+		if gg == nil || !diskwriter.Stop(gg) {
+			notFound(w)
+			return
+		}
+		gg.NotifyWebhooks(group.WebhookEvent{Kind: "unrecord"})
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, POST, DELETE")
+}
+
+type apiRecording struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// in seconds
+	Duration float64 `json:"duration,omitempty"`
+}
+
+func recordingsAPIHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
+	if pth == "/" {
+		if apiCORS(w, r, "HEAD, GET") {
+			return
+		}
+	} else {
+		if apiCORS(w, r, "HEAD, GET, DELETE") {
+			return
+		}
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	directory := filepath.Join(diskwriter.Directory, filepath.FromSlash(g))
+
+	if pth == "/" {
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD, GET")
+			return
+		}
+		entries, err := os.ReadDir(directory)
+		if errors.Is(err, os.ErrNotExist) {
+			// no recordings yet, check that the group exists
+			_, err = group.GetDescription(g)
+		}
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		recordings := make([]apiRecording, 0, len(entries))
+		for _, e := range entries {
+			if !e.Type().IsRegular() ||
+				strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				continue
+			}
+			rec := apiRecording{
+				Name:     e.Name(),
+				Size:     fi.Size(),
+				Modified: fi.ModTime(),
+			}
+			d, ok := diskwriter.CachedDuration(
+				filepath.Join(directory, e.Name()), fi,
+			)
+			if ok {
+				rec.Duration = d.Seconds()
+			}
+			recordings = append(recordings, rec)
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, recordings)
+		return
+	}
+
+	filename := pth[1:]
+	if filename == "" || strings.HasPrefix(filename, ".") ||
+		strings.ContainsRune(filename, '/') ||
+		strings.ContainsRune(filename, filepath.Separator) {
+		notFound(w)
+		return
+	}
+	fn := filepath.Join(directory, filename)
+	fi, err := os.Lstat(fn)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		notFound(w)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		f, err := os.Open(fn)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("cache-control", "no-cache")
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		return
+	} else if r.Method == "DELETE" {
+		err := os.Remove(fn)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, DELETE")
+}
+
+func archiveHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if pth == "/" {
 		if apiCORS(w, r, "HEAD, GET") {
 			return
 		}
@@ -187,113 +794,220 @@ func apiGroupHandler(w http.ResponseWriter, r *http.Request, pth string) {
 			methodNotAllowed(w, "HEAD, GET")
 			return
 		}
-		groups, err := group.GetDescriptionNames()
+		names, err := group.GetArchivedNames()
 		if err != nil {
 			httpError(w, err)
 			return
 		}
-		sendJSON(w, r, groups)
+		if names == nil {
+			names = []string{}
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, names)
 		return
 	}
 
-	if kind == ".users" {
-		usersHandler(w, r, g, rest)
-		return
-	} else if kind == ".empty-user" {
-		specialUserHandler(w, r, g, rest, false)
-		return
-	} else if kind == ".wildcard-user" {
-		specialUserHandler(w, r, g, rest, true)
-		return
-	} else if kind == ".keys" && rest == "" {
-		keysHandler(w, r, g)
-		return
-	} else if kind == ".tokens" {
-		tokensHandler(w, r, g, rest)
-		return
-	} else if kind != "" {
+	first, kind, rest := splitPath(pth)
+	if first == "" || kind != ".restore" || rest != "" {
 		if !checkAdmin(w, r) {
 			return
 		}
 		notFound(w)
 		return
 	}
-
-	if apiCORS(w, r, "HEAD, GET, PUT, DELETE") {
+	if apiCORS(w, r, "POST") {
 		return
 	}
 	if !checkAdmin(w, r) {
 		return
 	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	err := group.RestoreDescription(first[1:])
+	if errors.Is(err, os.ErrExist) {
+		http.Error(w, "group already exists", http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	if r.Method == "HEAD" || r.Method == "GET" {
-		desc, etag, err := group.GetSanitisedDescription(g)
-		if err != nil {
-			httpError(w, err)
-			return
-		}
-
-		w.Header().Set("etag", etag)
-
-		done := checkPreconditions(w, r, etag)
-		if done {
-			return
-		}
+type announcement struct {
+	Message string `json:"message"`
+}
 
-		sendJSON(w, r, desc)
-		return
-	} else if r.Method == "PUT" {
-		etag, err := group.GetDescriptionTag(g)
-		if errors.Is(err, os.ErrNotExist) {
-			err = nil
-			etag = ""
-		} else if err != nil {
-			httpError(w, err)
+func announceHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if pth == "/" {
+		if apiCORS(w, r, "HEAD, GET") {
 			return
 		}
-
-		done := checkPreconditions(w, r, etag)
-		if done {
+		if !checkAnnounce(w, r) {
 			return
 		}
-
-		var newdesc group.Description
-		done = getJSON(w, r, &newdesc)
-		if done {
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD, GET")
 			return
 		}
-		err = group.UpdateDescription(g, etag, &newdesc)
+		conf, err := group.GetConfiguration()
 		if err != nil {
 			httpError(w, err)
 			return
 		}
-		if etag == "" {
-			w.WriteHeader(http.StatusCreated)
-		} else {
-			w.WriteHeader(http.StatusNoContent)
+		names := make([]string, 0, len(conf.Announcements))
+		for name := range conf.Announcements {
+			names = append(names, name)
 		}
+		sort.Strings(names)
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, names)
 		return
-	} else if r.Method == "DELETE" {
-		etag, err := group.GetDescriptionTag(g)
-		if err != nil {
-			httpError(w, err)
+	}
+
+	first, kind, rest := splitPath(pth)
+	if first == "" || kind != "" || rest != "" {
+		if !checkAnnounce(w, r) {
 			return
 		}
+		notFound(w)
+		return
+	}
+	if apiCORS(w, r, "POST") {
+		return
+	}
+	if !checkAnnounce(w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+	var a announcement
+	done := getJSON(w, r, &a)
+	if done {
+		return
+	}
+	if a.Message == "" {
+		http.Error(w, "empty message", http.StatusBadRequest)
+		return
+	}
+	username, _, _ := r.BasicAuth()
+	_, err := group.Announce(first[1:], username, a.Message)
+	if errors.Is(err, group.ErrUnknownChannel) {
+		notFound(w)
+		return
+	} else if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		done := checkPreconditions(w, r, etag)
-		if done {
-			return
+// apiClient is the representation of a connected client in the API.
+type apiClient struct {
+	Id          string   `json:"id"`
+	Username    string   `json:"username,omitempty"`
+	Permissions []string `json:"permissions"`
+	Type        string   `json:"type"`
+	Address     string   `json:"address,omitempty"`
+}
+
+func clientType(c group.Client) string {
+	switch c.(type) {
+	case *rtpconn.WhipClient:
+		return "whip"
+	case *rtpconn.SipClient:
+		return "sip"
+	case *rtpconn.CascadeClient:
+		return "cascade"
+	case *rtpconn.RtspClient:
+		return "rtsp"
+	case *diskwriter.Client:
+		return "disk"
+	default:
+		return "websocket"
+	}
+}
+
+type apiGroupStatus struct {
+	Name         string `json:"name"`
+	Instantiated bool   `json:"instantiated"`
+	Clients      int    `json:"clients"`
+	Locked       bool   `json:"locked,omitempty"`
+	Draining     bool   `json:"draining,omitempty"`
+	Recording    bool   `json:"recording,omitempty"`
+}
+
+// groupsStatus returns the status of the groups with the given names.
+// Disk writers are not counted as clients.
+func groupsStatus(names []string) []apiGroupStatus {
+	groups := make([]apiGroupStatus, 0, len(names))
+	for _, name := range names {
+		status := apiGroupStatus{Name: name}
+		g := group.Get(name)
+		if g != nil {
+			status.Instantiated = true
+			status.Locked, _ = g.Locked()
+			status.Draining = g.Draining()
+			for _, c := range g.GetClients(nil) {
+				if _, ok := c.(*diskwriter.Client); ok {
+					status.Recording = true
+				} else {
+					status.Clients++
+				}
+			}
 		}
-		err = group.DeleteDescription(g, etag)
+		groups = append(groups, status)
+	}
+	return groups
+}
+
+func clientsHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD, GET")
+		return
+	}
+
+	clients := make([]apiClient, 0)
+	gg := group.Get(g)
+	if gg == nil {
+		// the group is not running, check whether it exists
+		_, err := group.GetDescriptionTag(g)
 		if err != nil {
 			httpError(w, err)
 			return
 		}
-		w.WriteHeader(http.StatusNoContent)
-		return
+	} else {
+		for _, c := range gg.GetClients(nil) {
+			client := apiClient{
+				Id:          c.Id(),
+				Username:    c.Username(),
+				Permissions: c.Permissions(),
+				Type:        clientType(c),
+			}
+			if client.Permissions == nil {
+				client.Permissions = []string{}
+			}
+			if addr := c.Addr(); addr != nil {
+				client.Address = addr.String()
+			}
+			clients = append(clients, client)
+		}
 	}
-	methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
-	return
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Id < clients[j].Id
+	})
+	w.Header().Set("cache-control", "no-cache")
+	sendJSON(w, r, clients)
 }
 
 func usersHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
@@ -302,14 +1016,18 @@ func usersHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 		return
 	}
 	if pth == "/" {
-		if apiCORS(w, r, "HEAD, GET") {
+		if apiCORS(w, r, "HEAD, GET, PUT") {
 			return
 		}
 		if !checkAdmin(w, r) {
 			return
 		}
+		if r.Method == "PUT" {
+			replaceUsers(w, r, g)
+			return
+		}
 		if r.Method != "HEAD" && r.Method != "GET" {
-			methodNotAllowed(w, "HEAD, GET")
+			methodNotAllowed(w, "HEAD, GET, PUT")
 			return
 		}
 		users, etag, err := group.GetUsers(g)
@@ -341,6 +1059,44 @@ func usersHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 	return
 }
 
+func replaceUsers(w http.ResponseWriter, r *http.Request, g string) {
+	_, etag, err := group.GetUsers(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	done := checkPreconditions(w, r, etag)
+	if done {
+		return
+	}
+
+	var roster []group.RosterEntry
+	done = getJSON(w, r, &roster)
+	if done {
+		return
+	}
+	seen := make(map[string]bool, len(roster))
+	for _, e := range roster {
+		if e.Username == "" || seen[e.Username] {
+			http.Error(w, "bad or duplicate username",
+				http.StatusBadRequest)
+			return
+		}
+		seen[e.Username] = true
+	}
+
+	err = group.ReplaceUsers(g, etag, roster)
+	if errors.Is(err, group.ErrTagMismatch) {
+		// the group was modified concurrently
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func specialUserHandler(w http.ResponseWriter, r *http.Request, g, pth string, wildcard bool) {
 	if pth == "" {
 		userHandler(w, r, g, "", wildcard)
@@ -402,6 +1158,7 @@ func userHandler(w http.ResponseWriter, r *http.Request, g, user string, wildcar
 			httpError(w, err)
 			return
 		}
+		revokeUserSessions(g, user, wildcard)
 		if etag == "" {
 			w.WriteHeader(http.StatusCreated)
 		} else {
@@ -409,6 +1166,13 @@ func userHandler(w http.ResponseWriter, r *http.Request, g, user string, wildcar
 		}
 		return
 	} else if r.Method == "DELETE" {
+		purge := r.URL.Query().Get("purge") != ""
+		if purge && wildcard {
+			http.Error(w, "cannot purge the wildcard user",
+				http.StatusBadRequest)
+			return
+		}
+
 		etag, err := group.GetUserTag(g, user, wildcard)
 		if err != nil {
 			httpError(w, err)
@@ -425,6 +1189,14 @@ func userHandler(w http.ResponseWriter, r *http.Request, g, user string, wildcar
 			httpError(w, err)
 			return
 		}
+		if purge {
+			_, err = token.RevokeUser(g, user)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			group.RevokeSessions(g, user)
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -433,20 +1205,59 @@ func userHandler(w http.ResponseWriter, r *http.Request, g, user string, wildcar
 }
 
 func passwordHandler(w http.ResponseWriter, r *http.Request, g, user string, wildcard bool) {
-	if apiCORS(w, r, "PUT, POST, DELETE") {
+	if apiCORS(w, r, "HEAD, GET, PUT, POST, DELETE") {
 		return
 	}
 	if !checkPasswordAdmin(w, r, g, user, wildcard) {
 		return
 	}
 
-	if r.Method == "PUT" {
+	handlePassword(w, r,
+		func() (string, error) {
+			return group.GetUserPasswordType(g, user, wildcard)
+		},
+		func(pw group.Password) error {
+			err := group.SetUserPassword(g, user, wildcard, pw)
+			if err != nil {
+				return err
+			}
+			revokeUserSessions(g, user, wildcard)
+			return nil
+		},
+	)
+}
+
+// revokeUserSessions revokes the sessions of a user whose entry was
+// changed.  Since any username may match the wildcard user, changing
+// the wildcard user revokes the sessions of all the users of the group.
+func revokeUserSessions(g, user string, wildcard bool) {
+	if wildcard {
+		group.RevokeGroupSessions(g)
+	} else {
+		group.RevokeSessions(g, user)
+	}
+}
+
+// handlePassword handles a request that reads, sets or deletes a password.
+// Reading only returns the type of the password, as obtained by calling
+// get; the password is stored by calling set.
+func handlePassword(w http.ResponseWriter, r *http.Request, get func() (string, error), set func(group.Password) error) {
+	if r.Method == "HEAD" || r.Method == "GET" {
+		tpe, err := get()
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		// group.Password marshals plain passwords as a string
+		sendJSON(w, r, map[string]string{"type": tpe})
+		return
+	} else if r.Method == "PUT" {
 		var pw group.Password
 		done := getJSON(w, r, &pw)
 		if done {
 			return
 		}
-		err := group.SetUserPassword(g, user, wildcard, pw)
+		err := set(pw)
 		if err != nil {
 			httpError(w, err)
 			return
@@ -469,7 +1280,7 @@ func passwordHandler(w http.ResponseWriter, r *http.Request, g, user string, wil
 			Type: "bcrypt",
 			Key:  &k,
 		}
-		err = group.SetUserPassword(g, user, wildcard, pw)
+		err = set(pw)
 		if err != nil {
 			httpError(w, err)
 			return
@@ -477,7 +1288,7 @@ func passwordHandler(w http.ResponseWriter, r *http.Request, g, user string, wil
 		w.WriteHeader(http.StatusNoContent)
 		return
 	} else if r.Method == "DELETE" {
-		err := group.SetUserPassword(g, user, wildcard, group.Password{})
+		err := set(group.Password{})
 		if err != nil {
 			httpError(w, err)
 			return
@@ -486,7 +1297,7 @@ func passwordHandler(w http.ResponseWriter, r *http.Request, g, user string, wil
 		return
 	}
 
-	methodNotAllowed(w, "PUT, POST, DELETE")
+	methodNotAllowed(w, "HEAD, GET, PUT, POST, DELETE")
 	return
 }
 
@@ -553,16 +1364,22 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 		return
 	}
 
+	var desc *group.Description
 	if g != "" {
 		// check that the group exists
-		_, err := group.GetDescription(g)
+		var err error
+		desc, err = group.GetDescription(g)
 		if err != nil {
 			httpError(w, err)
 			return
 		}
 	}
 	if pth == "/" {
-		if r.Method == "HEAD" || r.Method == "GET" {
+		if (r.Method == "HEAD" || r.Method == "GET") &&
+			r.URL.Query().Get("format") == "csv" {
+			tokensCSV(w, r, g)
+			return
+		} else if r.Method == "HEAD" || r.Method == "GET" {
 			tokens, etag, err := token.List(g)
 			if err != nil {
 				httpError(w, err)
@@ -589,6 +1406,21 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 					http.StatusBadRequest)
 				return
 			}
+			if tmpl := r.URL.Query().Get("template"); tmpl != "" {
+				if desc == nil {
+					http.Error(w, "unknown token template",
+						http.StatusBadRequest)
+					return
+				}
+				err := group.ApplyTokenTemplate(
+					desc, tmpl, &newtoken, time.Now(),
+				)
+				if err != nil {
+					http.Error(w, err.Error(),
+						http.StatusBadRequest)
+					return
+				}
+			}
 			buf := make([]byte, 8)
 			rand.Read(buf)
 			newtoken.Token =
@@ -641,7 +1473,7 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 			httpError(w, err)
 			return
 		}
-		if old.Group != g {
+		if old != nil && old.Group != g {
 			http.Error(w, "token exists in different group",
 				http.StatusConflict)
 			return
@@ -702,3 +1534,127 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 	methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
 	return
 }
+
+// tokensCSV sends the tokens of a group that have not expired as CSV,
+// together with the URLs that can be used to join the group.
+func tokensCSV(w http.ResponseWriter, r *http.Request, g string) {
+	tokens, _, err := token.List(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	var location string
+	if g != "" {
+		base, err := baseURL(r)
+		if err != nil {
+			internalError(w, "Parse ProxyURL: %v", err)
+			return
+		}
+		gg, err := group.Add(g, nil)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		location = gg.Status(false, base).Location
+	}
+
+	w.Header().Set("content-type", "text/csv; charset=utf-8")
+	w.Header().Set("cache-control", "no-cache")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	now := time.Now()
+	writer := csv.NewWriter(w)
+	writer.Write(
+		[]string{"token", "url", "username", "expires", "uses"},
+	)
+	for _, t := range tokens {
+		if t.Expires != nil && t.Expires.Before(now) {
+			continue
+		}
+		var u, username, expires string
+		if location != "" {
+			u = location + "?token=" + url.QueryEscape(t.Token)
+		}
+		if t.Username != nil {
+			username = *t.Username
+		}
+		if t.Expires != nil {
+			expires = t.Expires.Format(time.RFC3339)
+		}
+		writer.Write([]string{
+			t.Token, u, username, expires,
+			strconv.FormatUint(t.Uses, 10),
+		})
+	}
+	writer.Flush()
+}
+
+func replicaHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if pth == "/.promote" {
+		if apiCORS(w, r, "POST") {
+			return
+		}
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		err := group.Promote()
+		if errors.Is(err, group.ErrNotStandby) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if pth != "" {
+		if !checkAdmin(w, r) {
+			return
+		}
+		notFound(w)
+		return
+	}
+
+	if apiCORS(w, r, "HEAD, GET, PUT") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method == "HEAD" || r.Method == "GET" {
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, group.GetReplicaStatus())
+		return
+	} else if r.Method != "PUT" {
+		methodNotAllowed(w, "HEAD, GET, PUT")
+		return
+	}
+
+	var replica group.Replica
+	done := getJSON(w, r, &replica)
+	if done {
+		return
+	}
+	err := group.ApplyReplica(&replica)
+	if errors.Is(err, group.ErrNotStandby) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		var uerr group.UserError
+		if errors.As(err, &uerr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
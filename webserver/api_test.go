@@ -1,11 +1,14 @@
 package webserver
 
 import (
+	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
 	"mime"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,6 +18,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/jech/galene/diskwriter"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/token"
 )
@@ -166,6 +170,25 @@ func TestApi(t *testing.T) {
 		t.Errorf("Get groups: %v %v", err, groups)
 	}
 
+	var status []apiGroupStatus
+	err = getJSON("/galene-api/v0/.groups/?status=1", &status)
+	if err != nil || !reflect.DeepEqual(status,
+		[]apiGroupStatus{{Name: "test"}}) {
+		t.Errorf("Get groups status: %v %v", err, status)
+	}
+
+	var clients []any
+	err = getJSON("/galene-api/v0/.groups/test/.clients/", &clients)
+	if err != nil || len(clients) != 0 {
+		t.Errorf("Get clients: %v %v", err, clients)
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/nosuchgroup/.clients/",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get clients (bad group): %v %v", err, resp.StatusCode)
+	}
+
 	resp, err = do("PUT", "/galene-api/v0/.groups/test/.keys",
 		"application/jwk-set+json", "", "",
 		`{"keys": [{
@@ -216,6 +239,12 @@ func TestApi(t *testing.T) {
 		t.Errorf("Set password (PUT): %v %v", err, resp.StatusCode)
 	}
 
+	var tpe map[string]string
+	err = getJSON("/galene-api/v0/.groups/test/.users/jch/.password", &tpe)
+	if err != nil || len(tpe) != 1 || tpe["type"] != "plain" {
+		t.Errorf("Get plain password: %v %v", tpe, err)
+	}
+
 	resp, err = do("POST", "/galene-api/v0/.groups/test/.users/jch/.password",
 		"text/plain", "", "",
 		`toto`)
@@ -232,6 +261,12 @@ func TestApi(t *testing.T) {
 		t.Errorf("User not sanitised properly")
 	}
 
+	var pw group.Password
+	err = getJSON("/galene-api/v0/.groups/test/.users/jch/.password", &pw)
+	if err != nil || pw.Type != "bcrypt" || pw.Key != nil {
+		t.Errorf("Get password: %v %v", pw, err)
+	}
+
 	desc, err = group.GetDescription("test")
 	if err != nil {
 		t.Errorf("GetDescription: %v", err)
@@ -375,6 +410,34 @@ func TestApi(t *testing.T) {
 		t.Errorf("Got %v, expected %v (%v)", tok.Expires, e, err)
 	}
 
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.tokens/?format=csv",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Get tokens as CSV: %v %v", err, resp.StatusCode)
+	} else {
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		resp.Body.Close()
+		if err != nil || len(records) != 2 ||
+			records[1][0] != tokname ||
+			!strings.HasSuffix(records[1][1],
+				"/group/test/?token="+tokname) ||
+			records[1][4] != "0" {
+			t.Errorf("Tokens CSV: %v %v", records, err)
+		}
+	}
+
+	resp, err = do("PUT", "/galene-api/v0/.groups/test/.tokens/named",
+		"application/json", "", "*", "{}")
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Errorf("Create named token: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.tokens/named",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete named token: %v %v", err, resp.StatusCode)
+	}
+
 	resp, err = do("PUT", "/galene-api/v0/.groups/test2",
 		"application/json", "", "*", "{}")
 	if err != nil || resp.StatusCode != http.StatusCreated {
@@ -476,4 +539,1224 @@ func TestApiBadAuth(t *testing.T) {
 	do("GET", "/galene-api/v0/.groups/test/.tokens/token")
 	do("PUT", "/galene-api/v0/.groups/test/.tokens/token")
 	do("DELETE", "/galene-api/v0/.groups/test/.tokens/token")
+	do("POST", "/galene-api/v0/.groups/test/.archive")
+	do("GET", "/galene-api/v0/.archive/")
+	do("POST", "/galene-api/v0/.archive/test/.restore")
+}
+
+func TestApiArchive(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+
+	do := func(method, path string) int {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	getArchived := func() []string {
+		req, err := http.NewRequest("GET",
+			"http://localhost:1234/galene-api/v0/.archive/", nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Get archive: %v", err)
+		}
+		defer resp.Body.Close()
+		var names []string
+		err = json.NewDecoder(resp.Body).Decode(&names)
+		if err != nil {
+			t.Fatalf("Decode archive: %v", err)
+		}
+		return names
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "test.json"), []byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := getArchived(); len(names) != 0 {
+		t.Errorf("Expected [], got %v", names)
+	}
+
+	if s := do("POST", "/galene-api/v0/.groups/test/.archive"); s != http.StatusNoContent {
+		t.Errorf("Archive: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.groups/test/.archive"); s != http.StatusNotFound {
+		t.Errorf("Archive twice: %v", s)
+	}
+	if s := do("GET", "/galene-api/v0/.groups/test"); s != http.StatusNotFound {
+		t.Errorf("Get archived group: %v", s)
+	}
+	if names := getArchived(); !reflect.DeepEqual(names, []string{"test"}) {
+		t.Errorf("Expected [test], got %v", names)
+	}
+
+	if s := do("POST", "/galene-api/v0/.archive/test/.restore"); s != http.StatusNoContent {
+		t.Errorf("Restore: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.archive/test/.restore"); s != http.StatusNotFound {
+		t.Errorf("Restore twice: %v", s)
+	}
+	if s := do("GET", "/galene-api/v0/.groups/test"); s != http.StatusOK {
+		t.Errorf("Get restored group: %v", s)
+	}
+}
+
+func TestApiDrain(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+
+	do := func(method, path string) int {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	getDraining := func() bool {
+		req, err := http.NewRequest("GET",
+			"http://localhost:1234/galene-api/v0/.groups/drain/.drain",
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Get drain: %v", err)
+		}
+		defer resp.Body.Close()
+		var drain struct {
+			Draining bool `json:"draining"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&drain)
+		if err != nil {
+			t.Fatalf("Decode drain: %v", err)
+		}
+		return drain.Draining
+	}
+
+	if s := do("POST", "/galene-api/v0/.groups/nodrain/.drain"); s != http.StatusNotFound {
+		t.Errorf("Drain nonexistent group: %v", s)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "drain.json"),
+		[]byte(`{"wildcard-user": {"password": {"type": "wildcard"}}}`),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if getDraining() {
+		t.Errorf("Group started in drain mode")
+	}
+	if s := do("POST", "/galene-api/v0/.groups/drain/.drain"); s != http.StatusNoContent {
+		t.Errorf("Drain: %v", s)
+	}
+	if !getDraining() {
+		t.Errorf("Group is not draining")
+	}
+	_, _, err = group.CheckJoin("drain", group.ClientCredentials{
+		Username: new(string),
+	})
+	if err == nil {
+		t.Errorf("Join allowed in drain mode")
+	}
+
+	if s := do("DELETE", "/galene-api/v0/.groups/drain/.drain"); s != http.StatusNoContent {
+		t.Errorf("Undrain: %v", s)
+	}
+	if getDraining() {
+		t.Errorf("Group is still draining")
+	}
+	_, _, err = group.CheckJoin("drain", group.ClientCredentials{
+		Username: new(string),
+	})
+	if err != nil {
+		t.Errorf("Join refused after drain: %v", err)
+	}
+}
+
+func TestApiTokenTemplate(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "test.json"),
+		[]byte(`{
+		    "token-templates": {
+		        "lecture": {
+		            "permissions": "message",
+		            "validity": 3600,
+		            "username": "student"
+		        }
+		    }
+		}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+
+	post := func(template, body string) (int, string) {
+		u := "http://localhost:1234/galene-api/v0/.groups/test/.tokens/"
+		if template != "" {
+			u += "?template=" + template
+		}
+		req, err := http.NewRequest("POST", u, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Post token: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode, resp.Header.Get("location")
+	}
+
+	s, _ := post("unknown", "{}")
+	if s != http.StatusBadRequest {
+		t.Errorf("Unknown template: %v", s)
+	}
+
+	before := time.Now()
+	s, location := post("lecture", "{}")
+	if s != http.StatusCreated || location == "" {
+		t.Fatalf("Create token: %v %v", s, location)
+	}
+	tok, _, err := token.Get(location)
+	if err != nil {
+		t.Fatalf("Get token: %v", err)
+	}
+	if !reflect.DeepEqual(tok.Permissions, []string{"message"}) {
+		t.Errorf("Permissions: %v", tok.Permissions)
+	}
+	if tok.Username == nil || *tok.Username != "student" {
+		t.Errorf("Username: %v", tok.Username)
+	}
+	if tok.Expires == nil ||
+		tok.Expires.Before(before.Add(time.Hour)) ||
+		tok.Expires.After(time.Now().Add(time.Hour)) {
+		t.Errorf("Expires: %v", tok.Expires)
+	}
+
+	s, location = post("lecture", `{"username": "teacher"}`)
+	if s != http.StatusCreated {
+		t.Fatalf("Create token: %v", s)
+	}
+	tok, _, err = token.Get(location)
+	if err != nil {
+		t.Fatalf("Get token: %v", err)
+	}
+	if tok.Username == nil || *tok.Username != "teacher" {
+		t.Errorf("Username: %v", tok.Username)
+	}
+}
+
+func TestApiAnnounce(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.DataDirectory, "config.json"),
+		[]byte(`{
+		    "users": {
+		        "root": {"password": "pw", "permissions": "admin"},
+		        "dean": {"password": "pw", "permissions": "announce"},
+		        "monitor": {"password": "pw", "permissions": "stats"}
+		    },
+		    "announcements": {
+		        "campus": ["course-*"]
+		    }
+		}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+
+	do := func(method, path, username, body string) int {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth(username, "pw")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	message := `{"message": "The library is on fire"}`
+
+	if s := do("POST", "/galene-api/v0/.announce/campus", "monitor", message); s != http.StatusUnauthorized {
+		t.Errorf("Announce as monitor: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.announce/campus", "dean", message); s != http.StatusNoContent {
+		t.Errorf("Announce as dean: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.announce/campus", "root", message); s != http.StatusNoContent {
+		t.Errorf("Announce as root: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.announce/staff", "dean", message); s != http.StatusNotFound {
+		t.Errorf("Announce to unknown channel: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.announce/campus", "dean", `{}`); s != http.StatusBadRequest {
+		t.Errorf("Empty announcement: %v", s)
+	}
+	if s := do("GET", "/galene-api/v0/.announce/campus", "dean", ""); s != http.StatusMethodNotAllowed {
+		t.Errorf("Get channel: %v", s)
+	}
+	if s := do("GET", "/galene-api/v0/.announce/", "dean", ""); s != http.StatusOK {
+		t.Errorf("List channels: %v", s)
+	}
+}
+
+func TestApiReplica(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.SetStandby(false)
+
+	client := http.Client{}
+
+	do := func(method, path, body string) int {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	replica := marshalToString(group.Replica{
+		Groups: map[string][]byte{
+			"test": []byte(`{"public": true}`),
+		},
+	})
+
+	if s := do("PUT", "/galene-api/v0/.replica", replica); s != http.StatusConflict {
+		t.Errorf("Replicate to primary: %v", s)
+	}
+
+	group.SetStandby(true)
+
+	if s := do("PUT", "/galene-api/v0/.replica", replica); s != http.StatusNoContent {
+		t.Errorf("Replicate to standby: %v", s)
+	}
+	desc, err := group.GetDescription("test")
+	if err != nil || !desc.Public {
+		t.Errorf("Replicated group: %v %v", desc, err)
+	}
+
+	req, err := http.NewRequest("GET",
+		"http://localhost:1234/galene-api/v0/.replica", nil)
+	if err != nil {
+		t.Fatalf("New request: %v", err)
+	}
+	req.SetBasicAuth("root", "pw")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Get status: %v", err)
+	}
+	var status group.ReplicaStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil || !status.Standby || status.Updated == nil {
+		t.Errorf("Get status: %v %v", status, err)
+	}
+
+	if s := do("POST", "/galene-api/v0/.replica/.promote", ""); s != http.StatusNoContent {
+		t.Errorf("Promote: %v", s)
+	}
+	if s := do("PUT", "/galene-api/v0/.replica", replica); s != http.StatusConflict {
+		t.Errorf("Replicate after promotion: %v", s)
+	}
+	if s := do("POST", "/galene-api/v0/.replica/.promote", ""); s != http.StatusConflict {
+		t.Errorf("Promote twice: %v", s)
+	}
+}
+
+func TestApiReplaceUsers(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "roster.json"),
+		[]byte(`{
+		    "users": {
+		        "alice": {
+		            "permissions": "op",
+		            "password": "secret"
+		        },
+		        "bob": {"permissions": "present"}
+		    },
+		    "wildcard-user": {"permissions": "observe"}
+		}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+	u := "http://localhost:1234/galene-api/v0/.groups/roster/.users/"
+
+	do := func(method, etag, body string) *http.Response {
+		req, err := http.NewRequest(method, u, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("GET", "", "")
+	etag := resp.Header.Get("etag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("GET: %v %v", resp.StatusCode, etag)
+	}
+
+	roster := `[
+	    {"username": "alice", "permissions": "present"},
+	    {"username": "carol", "permissions": "op"}
+	]`
+
+	resp = do("PUT", "\"bad\"", roster)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with bad etag: %v", resp.StatusCode)
+	}
+
+	resp = do("PUT", etag, `[{"username": "dave"}, {"username": "dave"}]`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("PUT with duplicate user: %v", resp.StatusCode)
+	}
+
+	resp = do("PUT", etag, roster)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT: %v", resp.StatusCode)
+	}
+
+	desc, err := group.GetDescription("roster")
+	if err != nil {
+		t.Fatalf("GetDescription: %v", err)
+	}
+	if len(desc.Users) != 2 {
+		t.Errorf("Users: %v", desc.Users)
+	}
+	alice, ok := desc.Users["alice"]
+	if !ok || alice.Permissions.String() != "present" ||
+		alice.Password.Key == nil || *alice.Password.Key != "secret" {
+		t.Errorf("alice: %#v", alice)
+	}
+	carol, ok := desc.Users["carol"]
+	if !ok || carol.Permissions.String() != "op" ||
+		carol.Password.Type != "" {
+		t.Errorf("carol: %#v", carol)
+	}
+	if desc.WildcardUser == nil {
+		t.Errorf("wildcard user was removed")
+	}
+
+	resp = do("PUT", etag, roster)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with stale etag: %v", resp.StatusCode)
+	}
+}
+
+func TestApiRecordings(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := diskwriter.Directory
+	diskwriter.Directory = t.TempDir()
+	defer func() {
+		diskwriter.Directory = old
+	}()
+
+	client := http.Client{}
+
+	do := func(method, path string) (int, []byte) {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "test.json"), []byte("{}"), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, body := do("GET", "/galene-api/v0/.groups/test/.recordings/")
+	if s != http.StatusOK || string(body) != "[]\n" {
+		t.Errorf("List empty recordings: %v %q", s, body)
+	}
+	s, _ = do("GET", "/galene-api/v0/.groups/nosuch/.recordings/")
+	if s != http.StatusNotFound {
+		t.Errorf("List recordings of unknown group: %v", s)
+	}
+
+	dir := filepath.Join(diskwriter.Directory, "test")
+	err = os.MkdirAll(filepath.Join(dir, "subgroup"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "a.webm"), []byte("data"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recordings []apiRecording
+	s, body = do("GET", "/galene-api/v0/.groups/test/.recordings/")
+	err = json.Unmarshal(body, &recordings)
+	if s != http.StatusOK || err != nil || len(recordings) != 1 ||
+		recordings[0].Name != "a.webm" || recordings[0].Size != 4 {
+		t.Errorf("List recordings: %v %v %v", s, recordings, err)
+	}
+
+	s, body = do("GET", "/galene-api/v0/.groups/test/.recordings/a.webm")
+	if s != http.StatusOK || string(body) != "data" {
+		t.Errorf("Get recording: %v %q", s, body)
+	}
+	s, _ = do("GET", "/galene-api/v0/.groups/test/.recordings/subgroup")
+	if s != http.StatusNotFound {
+		t.Errorf("Get directory: %v", s)
+	}
+	s, _ = do("DELETE", "/galene-api/v0/.groups/test/.recordings/subgroup")
+	if s != http.StatusNotFound {
+		t.Errorf("Delete directory: %v", s)
+	}
+	s, _ = do("DELETE", "/galene-api/v0/.groups/test/.recordings/a.webm")
+	if s != http.StatusNoContent {
+		t.Errorf("Delete recording: %v", s)
+	}
+	s, _ = do("DELETE", "/galene-api/v0/.groups/test/.recordings/a.webm")
+	if s != http.StatusNotFound {
+		t.Errorf("Delete recording twice: %v", s)
+	}
+}
+
+func TestApiRecording(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := diskwriter.Directory
+	diskwriter.Directory = t.TempDir()
+	defer func() {
+		diskwriter.Directory = old
+	}()
+
+	client := http.Client{}
+
+	do := func(method, path string) int {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234"+path,
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	const path = "/galene-api/v0/.groups/test/.recording"
+
+	status := func() apiRecordingStatus {
+		req, err := http.NewRequest("GET",
+			"http://localhost:1234"+path, nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Get recording: %v", err)
+		}
+		defer resp.Body.Close()
+		var s apiRecordingStatus
+		err = json.NewDecoder(resp.Body).Decode(&s)
+		if err != nil {
+			t.Fatalf("Decode recording: %v", err)
+		}
+		return s
+	}
+
+	for _, name := range []string{"test", "private"} {
+		desc := "{}"
+		if name == "private" {
+			desc = `{"privacy-mode": true}`
+		}
+		err = os.WriteFile(
+			filepath.Join(group.Directory, name+".json"),
+			[]byte(desc), 0600,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s := status(); s.Recording {
+		t.Errorf("Recording before start: %v", s)
+	}
+	if s := do("POST", path+"?format=bad"); s != http.StatusBadRequest {
+		t.Errorf("Start with bad format: %v", s)
+	}
+	if s := do("POST", path+"?layout=bad"); s != http.StatusBadRequest {
+		t.Errorf("Start with bad layout: %v", s)
+	}
+	if s := do("POST", path+"?format=mp4"); s != http.StatusNoContent {
+		t.Errorf("Start: %v", s)
+	}
+	if s := status(); !s.Recording || s.Format != "mp4" ||
+		s.Layout != "muxed" {
+		t.Errorf("Recording after start: %v", s)
+	}
+	if s := do("POST", path); s != http.StatusConflict {
+		t.Errorf("Start twice: %v", s)
+	}
+	if s := do("DELETE", path); s != http.StatusNoContent {
+		t.Errorf("Stop: %v", s)
+	}
+	if s := do("DELETE", path); s != http.StatusNotFound {
+		t.Errorf("Stop twice: %v", s)
+	}
+	if s := status(); s.Recording {
+		t.Errorf("Recording after stop: %v", s)
+	}
+	if s := do("POST", path+"?layout=tracks"); s != http.StatusNoContent {
+		t.Errorf("Start with layout: %v", s)
+	}
+	if s := status(); !s.Recording || s.Layout != "tracks" {
+		t.Errorf("Recording after start with layout: %v", s)
+	}
+	if s := do("DELETE", path); s != http.StatusNoContent {
+		t.Errorf("Stop: %v", s)
+	}
+
+	s := do("POST", "/galene-api/v0/.groups/private/.recording")
+	if s != http.StatusConflict {
+		t.Errorf("Start in private group: %v", s)
+	}
+	s = do("POST", "/galene-api/v0/.groups/nosuch/.recording")
+	if s != http.StatusNotFound {
+		t.Errorf("Start in unknown group: %v", s)
+	}
+}
+
+func TestApiPurgeUser(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "test.json"),
+		[]byte(`{
+		    "users": {
+		        "alice": {"password": "pw", "permissions": "present"},
+		        "bob": {"password": "pw", "permissions": "present"}
+		    }
+		}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob := "alice", "bob"
+	for _, tok := range []*token.Stateful{
+		{Token: "a", Group: "test", Username: &alice},
+		{Token: "b", Group: "test", Username: &bob},
+	} {
+		_, err := token.Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	client := http.Client{}
+	del := func(path string) int {
+		req, err := http.NewRequest("DELETE",
+			"http://localhost:1234/galene-api/v0/.groups/test/"+path,
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if s := del(".wildcard-user?purge=1"); s != http.StatusBadRequest {
+		t.Errorf("Purge wildcard user: %v", s)
+	}
+
+	if s := del(".users/bob"); s != http.StatusNoContent {
+		t.Errorf("Delete bob: %v", s)
+	}
+	if s := del(".users/alice?purge=1"); s != http.StatusNoContent {
+		t.Errorf("Purge alice: %v", s)
+	}
+
+	users, _, err := group.GetUsers("test")
+	if err != nil || len(users) != 0 {
+		t.Errorf("Expected no users, got %v %v", users, err)
+	}
+
+	tok, _, err := token.Get("a")
+	if err != nil || tok.Expires == nil || tok.Expires.After(time.Now()) {
+		t.Errorf("Token a not revoked: %v %v", tok, err)
+	}
+	tok, _, err = token.Get("b")
+	if err != nil || tok.Expires != nil {
+		t.Errorf("Token b revoked: %v %v", tok, err)
+	}
+}
+
+func TestApiExpireTokens(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+	for _, tok := range []*token.Stateful{
+		{Token: "a", Group: "test", Expires: &old},
+		{Token: "b", Group: "test", Expires: &now},
+	} {
+		_, err := token.Update(tok, "")
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+	}
+
+	client := http.Client{}
+	do := func(method string) *http.Response {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234/galene-api/v0/.expire-tokens",
+			nil)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("root", "pw")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v", method, err)
+		}
+		return resp
+	}
+
+	resp := do("GET")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Get: %v", resp.StatusCode)
+	}
+
+	resp = do("POST")
+	var reply map[string]int
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || err != nil ||
+		reply["expired"] != 1 {
+		t.Errorf("Post: %v %v %v", resp.StatusCode, reply, err)
+	}
+
+	_, _, err = token.Get("a")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Token a not expired: %v", err)
+	}
+	_, _, err = token.Get("b")
+	if err != nil {
+		t.Errorf("Token b expired: %v", err)
+	}
+}
+
+func TestApiTokens(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "school.json"),
+		[]byte(`{}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+	do := func(method, path, auth, body string) *http.Response {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234/galene-api/v0/"+path,
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		if auth == "" {
+			req.SetBasicAuth("root", "pw")
+		} else {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		return resp
+	}
+
+	resp := do("POST", ".api-tokens/", "",
+		`{"scopes": ["stats:read", "tokens:create:school", "maintenance:write"]}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Create API token: %v", resp.StatusCode)
+	}
+	var reply struct {
+		Token string `json:"token"`
+		Id    string `json:"id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if err != nil || reply.Token == "" || reply.Id == "" {
+		t.Fatalf("Decode: %v %v", reply, err)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", ".stats", "", http.StatusOK},
+		{"GET", ".groups/", "", http.StatusUnauthorized},
+		{"GET", ".groups/school", "", http.StatusUnauthorized},
+		{"POST", ".groups/school/.tokens/", "{}", http.StatusCreated},
+		{"POST", ".groups/other/.tokens/", "{}", http.StatusUnauthorized},
+		{"GET", ".api-tokens/", "", http.StatusUnauthorized},
+		{"GET", ".maintenance", "", http.StatusNotFound},
+		{"DELETE", ".maintenance", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp := do(test.method, test.path, reply.Token, test.body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%v %v: expected %v, got %v",
+				test.method, test.path,
+				test.status, resp.StatusCode)
+		}
+	}
+
+	resp = do("GET", ".stats", "bad.token", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Bad token: got %v", resp.StatusCode)
+	}
+
+	resp = do("DELETE", ".api-tokens/"+reply.Id, "", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete API token: %v", resp.StatusCode)
+	}
+	resp = do("GET", ".stats", reply.Token, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Deleted token: got %v", resp.StatusCode)
+	}
+}
+
+func TestApiScopedUser(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(
+		filepath.Join(group.DataDirectory, "config.json"),
+		[]byte(`{
+    "writableGroups": true,
+    "users": {
+        "teacher": {
+            "password": "pw",
+            "scopes": ["groups:write:school", "stats:read"]
+        }
+    }
+}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []string{"school", "other"} {
+		err = os.WriteFile(
+			filepath.Join(group.Directory, g+".json"),
+			[]byte(`{}`), 0600,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := http.Client{}
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234/galene-api/v0/"+path,
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		req.SetBasicAuth("teacher", "pw")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", ".stats", "", http.StatusOK},
+		{"GET", ".maintenance", "", http.StatusNotFound},
+		{"GET", ".groups/school", "", http.StatusOK},
+		{"GET", ".groups/other", "", http.StatusUnauthorized},
+		{"PUT", ".groups/school/math", "{}", http.StatusCreated},
+		{"PUT", ".groups/schoolyard", "{}", http.StatusUnauthorized},
+		{"GET", ".groups/school/.users/", "", http.StatusUnauthorized},
+		{"POST", ".groups/school/.tokens/", "{}", http.StatusUnauthorized},
+		{"GET", ".api-tokens/", "", http.StatusUnauthorized},
+		{"POST", ".reload", "", http.StatusUnauthorized},
+		{"POST", ".expire-tokens", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		resp := do(test.method, test.path, test.body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status {
+			t.Errorf("%v %v: expected %v, got %v",
+				test.method, test.path,
+				test.status, resp.StatusCode)
+		}
+	}
+
+	resp := do("GET", ".groups/", "")
+	var groups []string
+	err = json.NewDecoder(resp.Body).Decode(&groups)
+	resp.Body.Close()
+	sort.Strings(groups)
+	if err != nil || !reflect.DeepEqual(groups, []string{"school", "school/math"}) {
+		t.Errorf("Get groups: %v %v", groups, err)
+	}
+}
+
+func TestApiHealth(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://localhost:1234/galene-api/v0/.health")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Health: %v", resp.StatusCode)
+	}
+	var health map[string]any
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	for _, k := range []string{
+		"version", "uptime", "groups", "clients", "ready",
+	} {
+		if _, ok := health[k]; !ok {
+			t.Errorf("Field %v missing", k)
+		}
+	}
+
+	req, err := http.NewRequest("POST",
+		"http://localhost:1234/galene-api/v0/.health", nil)
+	if err != nil {
+		t.Fatalf("New request: %v", err)
+	}
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Post health: %v", resp2.StatusCode)
+	}
+}
+
+func TestApiReady(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	group.Update()
+
+	resp, err := http.Get("http://localhost:1234/galene-api/v0/.ready")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Ready: %v", resp.StatusCode)
+	}
+}
+
+func TestApiMaintenance(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer group.CancelMaintenance()
+
+	client := http.Client{}
+	do := func(method, body string) (*http.Response, error) {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234/galene-api/v0/.maintenance",
+			strings.NewReader(body),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.SetBasicAuth("root", "pw")
+		return client.Do(req)
+	}
+
+	resp, err := do("GET", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get maintenance: %v %v", err, resp.StatusCode)
+	}
+
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+	resp, err = do("PUT", marshalToString(group.Maintenance{
+		Start:   start,
+		Message: "upgrade",
+	}))
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Set maintenance: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("GET", "")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Get maintenance: %v %v", err, resp.StatusCode)
+	}
+	var m group.Maintenance
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	resp.Body.Close()
+	if err != nil || !m.Start.Equal(start) || m.Message != "upgrade" {
+		t.Errorf("Got %v %v", m, err)
+	}
+
+	resp, err = http.Get("http://localhost:1234/galene-api/v0/.health")
+	if err != nil {
+		t.Fatalf("Get health: %v", err)
+	}
+	var h healthReply
+	err = json.NewDecoder(resp.Body).Decode(&h)
+	resp.Body.Close()
+	if err != nil || h.Maintenance == nil || !h.Maintenance.Equal(start) {
+		t.Errorf("Health: %v %v", h.Maintenance, err)
+	}
+
+	resp, err = do("DELETE", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Cancel maintenance: %v %v", err, resp.StatusCode)
+	}
+	resp, err = do("DELETE", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Cancel maintenance twice: %v %v",
+			err, resp.StatusCode)
+	}
+}
+
+func TestApiGlobalUsers(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+	do := func(method, path, ctype, inm, username, password, body string) *http.Response {
+		req, err := http.NewRequest(method,
+			"http://localhost:1234/galene-api/v0/.users/"+path,
+			strings.NewReader(body),
+		)
+		if err != nil {
+			t.Fatalf("New request: %v", err)
+		}
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		req.SetBasicAuth(username, password)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do("GET", "", "", "", "root", "pw", "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("etag") == "" {
+		t.Errorf("Get users: %v", resp.StatusCode)
+	}
+
+	resp = do("PUT", "alice", "application/json", "*", "root", "pw",
+		`{"permissions": "present"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Create user: %v", resp.StatusCode)
+	}
+	resp = do("PUT", "alice", "application/json", "*", "root", "pw",
+		`{"permissions": "present"}`)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Create user twice: %v", resp.StatusCode)
+	}
+
+	resp = do("POST", "alice/.password", "text/plain", "", "root", "pw",
+		"secret")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Set password: %v", resp.StatusCode)
+	}
+
+	// users may change their own password
+	resp = do("POST", "alice/.password", "text/plain", "",
+		"alice", "secret", "secret2")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Set own password: %v", resp.StatusCode)
+	}
+	resp = do("POST", "alice/.password", "text/plain", "",
+		"alice", "secret", "secret3")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Set own password (bad password): %v",
+			resp.StatusCode)
+	}
+	resp = do("GET", "alice", "", "", "alice", "secret2", "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Get user as user: %v", resp.StatusCode)
+	}
+
+	u, _, err := group.GetSanitisedGlobalUser("alice")
+	if err != nil || u.Permissions.String() != "present" {
+		t.Errorf("GetSanitisedGlobalUser: %v %v", u, err)
+	}
+	ok, err := group.MatchGlobalUser("alice", "secret2")
+	if err != nil || !ok {
+		t.Errorf("MatchGlobalUser: %v %v", ok, err)
+	}
+
+	resp = do("DELETE", "alice", "", "", "root", "pw", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete user: %v", resp.StatusCode)
+	}
+	resp = do("GET", "alice", "", "", "root", "pw", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get deleted user: %v", resp.StatusCode)
+	}
 }
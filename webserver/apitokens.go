@@ -0,0 +1,176 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// requestScope returns the resource, action and group that an API
+// request operates on, as used in the scopes of API tokens.  It returns
+// an empty resource if the request cannot be performed with an API
+// token.
+func requestScope(r *http.Request) (string, string, string) {
+	if !strings.HasPrefix(r.URL.Path, "/galene-api/") {
+		return "", "", ""
+	}
+	first, kind, rest := splitPath(r.URL.Path[len("/galene-api"):])
+	if first != "/v0" {
+		return "", "", ""
+	}
+
+	action := "write"
+	if r.Method == "HEAD" || r.Method == "GET" {
+		action = "read"
+	}
+
+	switch kind {
+	case ".stats":
+		return "stats", action, ""
+	case ".archive", ".reload":
+		return "groups", action, ""
+	case ".announce":
+		return "announce", action, ""
+	case ".replica":
+		return "replica", action, ""
+	case ".maintenance":
+		return "maintenance", action, ""
+	case ".introspect":
+		return "tokens", "read", ""
+	case ".expire-tokens":
+		return "tokens", action, ""
+	case ".api-tokens":
+		return "api-tokens", action, ""
+	case ".users":
+		return "users", action, ""
+	case ".groups":
+		first2, kind2, _ := splitPath(rest)
+		g := ""
+		if first2 != "" {
+			g = first2[1:]
+		}
+		switch kind2 {
+		case "", ".archive", ".drain", ".rtsp":
+			return "groups", action, g
+		case ".users", ".empty-user", ".wildcard-user":
+			return "users", action, g
+		case ".keys":
+			return "keys", action, g
+		case ".tokens":
+			if r.Method == "POST" {
+				action = "create"
+			}
+			return "tokens", action, g
+		case ".clients":
+			return "clients", action, g
+		case ".prune-recordings", ".recording", ".recordings":
+			return "recordings", action, g
+		}
+	}
+	return "", "", ""
+}
+
+// apiTokenScopes returns the scopes of the API token carried by r, or
+// nil if there is no valid token.
+func apiTokenScopes(r *http.Request) []string {
+	value := parseBearerToken(r.Header.Get("Authorization"))
+	if value == "" {
+		return nil
+	}
+	t, err := group.CheckAPIToken(value, time.Now())
+	if err != nil {
+		return nil
+	}
+	return t.Scopes
+}
+
+type apiTokenRequest struct {
+	Description string     `json:"description,omitempty"`
+	Scopes      []string   `json:"scopes"`
+	Expires     *time.Time `json:"expires,omitempty"`
+}
+
+type apiTokenReply struct {
+	Token string `json:"token"`
+	*group.APIToken
+}
+
+func apiTokensHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if pth == "/" {
+		if apiCORS(w, r, "HEAD, GET, POST") {
+			return
+		}
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method == "HEAD" || r.Method == "GET" {
+			tokens, err := group.ListAPITokens()
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			sendJSON(w, r, tokens)
+			return
+		} else if r.Method == "POST" {
+			var req apiTokenRequest
+			done := getJSON(w, r, &req)
+			if done {
+				return
+			}
+			value, t, err := group.CreateAPIToken(
+				req.Description, req.Scopes, req.Expires,
+			)
+			if err != nil {
+				httpError(w, err)
+				return
+			}
+			w.Header().Set("location", t.Id)
+			w.Header().Set("content-type", "application/json")
+			w.Header().Set("cache-control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			sendJSON(w, r, apiTokenReply{value, t})
+			return
+		}
+		methodNotAllowed(w, "HEAD, GET, POST")
+		return
+	}
+
+	id := ""
+	if strings.HasPrefix(pth, "/") && !strings.Contains(pth[1:], "/") {
+		id = pth[1:]
+	}
+	if id == "" {
+		if !checkAdmin(w, r) {
+			return
+		}
+		notFound(w)
+		return
+	}
+
+	if apiCORS(w, r, "HEAD, GET, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.Method == "HEAD" || r.Method == "GET" {
+		t, err := group.GetAPIToken(id)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		sendJSON(w, r, t)
+		return
+	} else if r.Method == "DELETE" {
+		err := group.DeleteAPIToken(id)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, DELETE")
+}
@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+// The token exchange endpoint trades a JWT issued by an external identity
+// provider for a stateful token, following RFC 8693.  Errors are reported
+// as in RFC 6749 Section 5.2.
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// tokenExchangeResponse is the reply to a successful token exchange
+// (RFC 8693 Section 2.2.1).
+type tokenExchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in,omitempty"`
+}
+
+func tokenExchangeError(w http.ResponseWriter, code, description string) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-store")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func tokenExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	pth, _, rest := splitPath(r.URL.Path)
+	if rest != "" {
+		notFound(w)
+		return
+	}
+
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	g, err := group.Add(name, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	CheckOrigin(w, r, false)
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods",
+			"OPTIONS, POST",
+		)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 16384)
+	err = r.ParseForm()
+	if err != nil {
+		var mberr *http.MaxBytesError
+		if errors.As(err, &mberr) {
+			httpError(w, err)
+			return
+		}
+		tokenExchangeError(w, "invalid_request", err.Error())
+		return
+	}
+	if r.PostForm.Get("grant_type") != tokenExchangeGrantType {
+		tokenExchangeError(w, "unsupported_grant_type",
+			"unsupported grant type")
+		return
+	}
+	subject := r.PostForm.Get("subject_token")
+	if subject == "" {
+		tokenExchangeError(w, "invalid_request",
+			"missing subject token")
+		return
+	}
+	switch r.PostForm.Get("subject_token_type") {
+	case tokenTypeJWT, tokenTypeAccessToken, tokenTypeIDToken:
+	default:
+		tokenExchangeError(w, "invalid_request",
+			"unsupported subject token type")
+		return
+	}
+	requested := r.PostForm.Get("requested_token_type")
+	if requested != "" && requested != tokenTypeAccessToken {
+		tokenExchangeError(w, "invalid_request",
+			"unsupported requested token type")
+		return
+	}
+
+	now := time.Now().UTC()
+	tok, err := group.ExchangeToken(g, subject, now)
+	if err != nil {
+		var autherr *group.NotAuthorisedError
+		if errors.As(err, &autherr) {
+			log.Printf("Token exchange: %v", err)
+			tokenExchangeError(w, "invalid_grant",
+				"token exchange denied")
+			return
+		}
+		httpError(w, err)
+		return
+	}
+
+	t, err := token.Update(tok, "")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	// Galene's tokens are not OAuth bearer tokens, they are passed to
+	// the server when joining a group
+	reply := tokenExchangeResponse{
+		AccessToken:     t.Token,
+		IssuedTokenType: tokenTypeAccessToken,
+		TokenType:       "N_A",
+	}
+	if t.Expires != nil {
+		reply.ExpiresIn = int64(t.Expires.Sub(now).Seconds())
+	}
+	w.Header().Set("cache-control", "no-store")
+	sendJSON(w, r, reply)
+}
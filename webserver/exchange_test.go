@@ -0,0 +1,230 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestTokenExchange(t *testing.T) {
+	dir := t.TempDir()
+	err := setupTest(dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ",
+	}
+	exchange := map[string]any{
+		"keys":     []any{key},
+		"issuer":   "https://idp.example.org",
+		"audience": "galene",
+		"validity": 600,
+		"rules": []any{
+			map[string]any{
+				"claim":       "groups",
+				"value":       "teachers",
+				"permissions": "op",
+			},
+			map[string]any{
+				"claim":       "groups",
+				"value":       "students",
+				"permissions": "present",
+			},
+		},
+	}
+	desc, err := json.Marshal(map[string]any{
+		"token-exchange": exchange,
+		"users": map[string]any{
+			"jch": map[string]any{"permissions": "op", "password": "pw"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "exchange.json"), desc, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sign := func(claims map[string]any) string {
+		c := map[string]any{
+			"iss": "https://idp.example.org",
+			"aud": "galene",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range claims {
+			c[k] = v
+		}
+		jwt, err := token.SignJWT(key, c)
+		if err != nil {
+			t.Fatalf("SignJWT: %v", err)
+		}
+		return jwt
+	}
+
+	exchangeURL := "http://localhost:1234/group/exchange/.token-exchange"
+	postForm := func(form url.Values) (map[string]any, int) {
+		resp, err := http.PostForm(exchangeURL, form)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		defer resp.Body.Close()
+		var reply map[string]any
+		err = json.NewDecoder(resp.Body).Decode(&reply)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return reply, resp.StatusCode
+	}
+	// post returns the stateful token, or the error code
+	post := func(jwt string) (*token.Stateful, string) {
+		reply, status := postForm(url.Values{
+			"grant_type":         []string{tokenExchangeGrantType},
+			"subject_token":      []string{jwt},
+			"subject_token_type": []string{tokenTypeJWT},
+		})
+		if status != http.StatusOK {
+			if status != http.StatusBadRequest {
+				t.Errorf("Unexpected status %v", status)
+			}
+			code, _ := reply["error"].(string)
+			return nil, code
+		}
+		if reply["issued_token_type"] != tokenTypeAccessToken ||
+			reply["token_type"] != "N_A" {
+			t.Errorf("Bad reply %v", reply)
+		}
+		if e, ok := reply["expires_in"].(float64); !ok || e > 600 {
+			t.Errorf("Bad expires_in %v", reply["expires_in"])
+		}
+		id, _ := reply["access_token"].(string)
+		tok, _, err := token.Get(id)
+		if err != nil {
+			t.Fatalf("token not saved: %v", err)
+		}
+		return tok, ""
+	}
+
+	tok, code := post(sign(map[string]any{
+		"sub":    "alice",
+		"groups": []any{"staff", "teachers"},
+	}))
+	if tok == nil {
+		t.Fatalf("exchange: %v", code)
+	}
+	if tok.Group != "exchange" || tok.Username == nil ||
+		*tok.Username != "alice" || !member("op", tok.Permissions) {
+		t.Errorf("bad token %#v", tok)
+	}
+	if tok.Expires == nil || tok.Expires.After(now.Add(601*time.Second)) {
+		t.Errorf("bad expiration %v", tok.Expires)
+	}
+	if tok.IssuedBy == nil || *tok.IssuedBy != "https://idp.example.org" {
+		t.Errorf("bad issuer %v", tok.IssuedBy)
+	}
+
+	tok, _ = post(sign(map[string]any{
+		"sub":    "bob",
+		"groups": "students",
+	}))
+	if tok == nil || member("op", tok.Permissions) ||
+		!member("present", tok.Permissions) {
+		t.Errorf("bad student token %#v", tok)
+	}
+
+	_, code = post(sign(map[string]any{
+		"sub":    "eve",
+		"groups": []any{"guests"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("no matching rule: got %v", code)
+	}
+
+	_, code = post(sign(map[string]any{
+		"sub":    "jch",
+		"groups": []any{"teachers"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("existing user: got %v", code)
+	}
+
+	_, code = post(sign(map[string]any{
+		"iss":    "https://evil.example.org",
+		"sub":    "mallory",
+		"groups": []any{"teachers"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("bad issuer: got %v", code)
+	}
+
+	_, code = post(sign(map[string]any{
+		"aud":    "other",
+		"sub":    "mallory",
+		"groups": []any{"teachers"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("bad audience: got %v", code)
+	}
+
+	_, code = post(sign(map[string]any{
+		"aud":    nil,
+		"sub":    "mallory",
+		"groups": []any{"teachers"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("missing audience: got %v", code)
+	}
+
+	// the audience is required
+	delete(exchange, "audience")
+	desc, err = json.Marshal(map[string]any{"token-exchange": exchange})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "exchange.json"), desc, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code = post(sign(map[string]any{
+		"sub":    "carol",
+		"groups": []any{"teachers"},
+	}))
+	if code != "invalid_grant" {
+		t.Errorf("no configured audience: got %v", code)
+	}
+
+	_, code = post("garbage")
+	if code != "invalid_grant" {
+		t.Errorf("garbage: got %v", code)
+	}
+
+	reply, status := postForm(url.Values{
+		"grant_type":    []string{tokenExchangeGrantType},
+		"subject_token": []string{sign(map[string]any{"sub": "alice"})},
+	})
+	if status != http.StatusBadRequest || reply["error"] != "invalid_request" {
+		t.Errorf("missing token type: got %v %v", status, reply)
+	}
+
+	reply, status = postForm(url.Values{
+		"grant_type":         []string{"password"},
+		"subject_token":      []string{sign(map[string]any{"sub": "alice"})},
+		"subject_token_type": []string{tokenTypeJWT},
+	})
+	if status != http.StatusBadRequest ||
+		reply["error"] != "unsupported_grant_type" {
+		t.Errorf("bad grant type: got %v %v", status, reply)
+	}
+}
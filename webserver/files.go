@@ -0,0 +1,206 @@
+package webserver
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// The drop box of a group is served under /group/name/.files/.  Files are
+// uploaded and deleted by presenters, who authenticate with the group's
+// credentials.  Files are downloaded either with the credentials of
+// a member of the group, or with the access string that the client
+// received in the group status, see group/dropbox.go.
+
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	pth, kind, rest := splitPath(r.URL.Path)
+	if kind != ".files" {
+		internalError(w, "filesHandler: this shouldn't happen")
+		return
+	}
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	g, err := group.Add(name, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if rest == "" || rest == "/" {
+		if r.Method == "OPTIONS" {
+			CheckOrigin(w, r, false)
+			w.Header().Set("Access-Control-Allow-Methods",
+				"OPTIONS, POST")
+			w.Header().Set("Access-Control-Allow-Headers",
+				"Authorization, Content-Type")
+			return
+		}
+		if r.Method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		uploadFile(w, r, g)
+		return
+	}
+
+	id := rest[1:]
+	switch r.Method {
+	case "HEAD", "GET":
+		serveDropBoxFile(w, r, g, id)
+	case "DELETE":
+		username, ok := checkPresenter(w, r, g)
+		if !ok {
+			return
+		}
+		err := g.DelFile(id)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		log.Printf("Drop box %v: %v deleted file %v",
+			g.Name(), username, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "HEAD, GET, DELETE")
+	}
+}
+
+// checkPresenter checks that the request carries the credentials of
+// a member of group g with the present or op permission.  It returns
+// the member's username.
+func checkPresenter(w http.ResponseWriter, r *http.Request, g *group.Group) (string, bool) {
+	username, perms, ok := checkMember(w, r, g)
+	if !ok {
+		return "", false
+	}
+	if !canPresent(perms) && !member("op", perms) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
+
+// checkMember checks that the request carries the credentials of
+// a member of group g.  It returns the member's username and permissions.
+func checkMember(w http.ResponseWriter, r *http.Request, g *group.Group) (string, []string, bool) {
+	var creds group.ClientCredentials
+	creds.Token = parseBearerToken(r.Header.Get("Authorization"))
+	if creds.Token == "" {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			failAuthentication(w, "files/"+g.Name())
+			return "", nil, false
+		}
+		creds.Username = &username
+		creds.Password = password
+	}
+
+	username, perms, err := g.GetPermission(creds)
+	if err != nil {
+		var autherr *group.NotAuthorisedError
+		if errors.As(err, &autherr) {
+			time.Sleep(200 * time.Millisecond)
+			if creds.Token == "" {
+				failAuthentication(w, "files/"+g.Name())
+				return "", nil, false
+			}
+		}
+		httpError(w, err)
+		return "", nil, false
+	}
+	return username, perms, true
+}
+
+func uploadFile(w http.ResponseWriter, r *http.Request, g *group.Group) {
+	CheckOrigin(w, r, false)
+
+	username, ok := checkPresenter(w, r, g)
+	if !ok {
+		return
+	}
+
+	filename := r.URL.Query().Get("name")
+	if filename == "" {
+		http.Error(w, "no filename provided", http.StatusBadRequest)
+		return
+	}
+
+	file, err := g.AddFile(
+		username, filename, r.Header.Get("Content-Type"), r.Body,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, group.ErrNoDropBox):
+			notFound(w)
+		case errors.Is(err, group.ErrBadFilename):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, group.ErrBadFileType):
+			http.Error(w, err.Error(),
+				http.StatusUnsupportedMediaType)
+		case errors.Is(err, group.ErrFileTooLarge):
+			http.Error(w, err.Error(),
+				http.StatusRequestEntityTooLarge)
+		case errors.Is(err, group.ErrDropBoxFull):
+			http.Error(w, err.Error(),
+				http.StatusInsufficientStorage)
+		default:
+			httpError(w, err)
+		}
+		return
+	}
+	log.Printf("Drop box %v: %v uploaded %v (%v bytes)",
+		g.Name(), username, file.Name, file.Size)
+
+	w.Header().Set("Location", path.Join(r.URL.Path, file.Id))
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	sendJSON(w, r, file)
+}
+
+func serveDropBoxFile(w http.ResponseWriter, r *http.Request, g *group.Group, id string) {
+	access := r.URL.Query().Get("access")
+	if access == "" {
+		_, _, ok := checkMember(w, r, g)
+		if !ok {
+			return
+		}
+	} else if !g.CheckFileAccess(id, access) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	file, filename, err := g.GetFile(id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", file.Type)
+	w.Header().Set("Content-Disposition",
+		mime.FormatMediaType("attachment",
+			map[string]string{"filename": file.Name},
+		),
+	)
+	// the contents are provided by users, don't let them run in our
+	// origin
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", file.Time, f)
+}
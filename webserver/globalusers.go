@@ -0,0 +1,175 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/jech/galene/group"
+)
+
+// globalUsersHandler handles the server-wide users under /.users/.
+func globalUsersHandler(w http.ResponseWriter, r *http.Request, pth string) {
+	if pth == "/" {
+		if apiCORS(w, r, "HEAD, GET") {
+			return
+		}
+		if !checkAdmin(w, r) {
+			return
+		}
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD, GET")
+			return
+		}
+		users, etag, err := group.GetGlobalUsers()
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("etag", etag)
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+		sendJSON(w, r, users)
+		return
+	}
+
+	first, kind, rest := splitPath(pth)
+	if first != "" && kind == "" {
+		globalUserHandler(w, r, first[1:])
+		return
+	} else if first != "" && kind == ".password" && rest == "" {
+		globalPasswordHandler(w, r, first[1:])
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+	notFound(w)
+}
+
+func globalUserHandler(w http.ResponseWriter, r *http.Request, user string) {
+	if apiCORS(w, r, "HEAD, GET, PUT, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		user, etag, err := group.GetSanitisedGlobalUser(user)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("etag", etag)
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+		sendJSON(w, r, user)
+		return
+	} else if r.Method == "PUT" {
+		etag, err := group.GetGlobalUserTag(user)
+		if errors.Is(err, os.ErrNotExist) {
+			etag = ""
+			err = nil
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+
+		var newdesc group.UserDescription
+		done = getJSON(w, r, &newdesc)
+		if done {
+			return
+		}
+		err = group.UpdateGlobalUser(user, etag, &newdesc)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		group.RevokeUserSessions(user)
+		if etag == "" {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	} else if r.Method == "DELETE" {
+		etag, err := group.GetGlobalUserTag(user)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+
+		done := checkPreconditions(w, r, etag)
+		if done {
+			return
+		}
+
+		err = group.DeleteGlobalUser(user, etag)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
+}
+
+func globalPasswordHandler(w http.ResponseWriter, r *http.Request, user string) {
+	if apiCORS(w, r, "HEAD, GET, PUT, POST, DELETE") {
+		return
+	}
+	if !checkGlobalPasswordAdmin(w, r, user) {
+		return
+	}
+
+	handlePassword(w, r,
+		func() (string, error) {
+			return group.GetGlobalUserPasswordType(user)
+		},
+		func(pw group.Password) error {
+			err := group.SetGlobalUserPassword(user, pw)
+			if err != nil {
+				return err
+			}
+			group.RevokeUserSessions(user)
+			return nil
+		},
+	)
+}
+
+// checkGlobalPasswordAdmin is like checkPasswordAdmin, but for a global
+// user.
+func checkGlobalPasswordAdmin(w http.ResponseWriter, r *http.Request, user string) bool {
+	ok, err := scopeMatch(r, "", "")
+	if err != nil {
+		internalError(w, "Admin match: %v", err)
+		return false
+	}
+	if ok {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	if ok && username == user {
+		ok, err := group.MatchGlobalUser(user, password)
+		if err != nil {
+			internalError(w, "Password match: %v", err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	failAuthentication(w, "/galene-api/")
+	return false
+}
@@ -0,0 +1,82 @@
+package webserver
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/stats"
+)
+
+// the time at which the server was started, for the health endpoint
+var startTime = time.Now()
+
+type healthReply struct {
+	Version string         `json:"version"`
+	Uptime  stats.Duration `json:"uptime"`
+	Groups  int            `json:"groups"`
+	Clients int            `json:"clients"`
+	Ready   bool           `json:"ready"`
+	// the start of the scheduled maintenance window
+	Maintenance *time.Time `json:"maintenance,omitempty"`
+}
+
+// version returns the version of the server, as recorded by the Go
+// toolchain at build time.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "unknown"
+	}
+	return info.Main.Version
+}
+
+// healthHandler serves a cheap liveness probe.  It requires no
+// authentication, and only returns aggregate counts.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "HEAD, GET") {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD, GET")
+		return
+	}
+
+	reply := healthReply{
+		Version: version(),
+		Uptime:  stats.Duration(time.Since(startTime)),
+		Ready:   group.Ready(),
+	}
+	if m := group.GetMaintenance(); m != nil {
+		reply.Maintenance = &m.Start
+	}
+	group.Range(func(g *group.Group) bool {
+		reply.Groups++
+		reply.Clients += g.ClientCount()
+		return true
+	})
+
+	w.Header().Set("cache-control", "no-store")
+	sendJSON(w, r, reply)
+}
+
+// readyHandler serves a readiness probe, which fails until the group
+// definitions have been loaded.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "HEAD, GET") {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD, GET")
+		return
+	}
+
+	w.Header().Set("cache-control", "no-store")
+	if !group.Ready() {
+		w.Header().Set("retry-after", "5")
+		http.Error(w, "Loading groups", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,167 @@
+package webserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+// The invitations endpoint allows users with the "token" permission to
+// create invitations without going through the administrative API.
+
+const defaultInvitationLifetime = 24 * time.Hour
+
+// checkInvitationPermissions authenticates the client against the
+// group's user database, and returns its username and permissions.
+func checkInvitationPermissions(w http.ResponseWriter, r *http.Request, g *group.Group) (string, []string, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		failAuthentication(w, "invitations/"+g.Name())
+		return "", nil, false
+	}
+
+	_, perms, err := g.GetPermission(
+		group.ClientCredentials{
+			Username: &username,
+			Password: password,
+		},
+	)
+	if err != nil || !member("token", perms) {
+		var autherr *group.NotAuthorisedError
+		if errors.As(err, &autherr) {
+			time.Sleep(200 * time.Millisecond)
+		}
+		failAuthentication(w, "invitations/"+g.Name())
+		return "", nil, false
+	}
+	return username, perms, true
+}
+
+func invitationsHandler(w http.ResponseWriter, r *http.Request) {
+	pth, _, rest := splitPath(r.URL.Path)
+	if rest != "/" {
+		notFound(w)
+		return
+	}
+
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	g, err := group.Add(name, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	CheckOrigin(w, r, false)
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods",
+			"OPTIONS, HEAD, GET, POST",
+		)
+		w.Header().Set("Access-Control-Allow-Headers",
+			"Authorization, Content-Type",
+		)
+		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		return
+	}
+
+	username, perms, ok := checkInvitationPermissions(w, r, g)
+	if !ok {
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		tokens, _, err := token.List(g.Name())
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		// operators see all invitations, other users only see the
+		// ones they have created.
+		op := member("op", perms)
+		now := time.Now()
+		result := make([]*token.Stateful, 0)
+		for _, t := range tokens {
+			if t.Expires == nil || !t.Expires.After(now) {
+				continue
+			}
+			if !op && (t.IssuedBy == nil || *t.IssuedBy != username) {
+				continue
+			}
+			result = append(result, t)
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, result)
+		return
+	} else if r.Method == "POST" {
+		var tok token.Stateful
+		done := getJSON(w, r, &tok)
+		if done {
+			return
+		}
+		if tok.Token != "" || tok.IncludeSubgroups ||
+			tok.IssuedBy != nil || tok.IssuedAt != nil {
+			http.Error(w, "overspecified token",
+				http.StatusBadRequest)
+			return
+		}
+		if tok.Group != "" && tok.Group != g.Name() {
+			http.Error(w, "wrong group in token",
+				http.StatusBadRequest)
+			return
+		}
+		if tok.Username != nil && g.UserExists(*tok.Username) {
+			http.Error(w, "that username is taken",
+				http.StatusConflict)
+			return
+		}
+		if tok.Permissions == nil {
+			tok.Permissions = []string{"present"}
+		}
+		for _, p := range tok.Permissions {
+			if !member(p, perms) {
+				http.Error(w, "not authorised",
+					http.StatusForbidden)
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		if tok.Expires == nil {
+			expires := now.Add(defaultInvitationLifetime)
+			tok.Expires = &expires
+		}
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		tok.Token = base64.RawURLEncoding.EncodeToString(buf)
+		tok.Group = g.Name()
+		tok.IssuedBy = &username
+		tok.IssuedAt = &now
+
+		t, err := g.AddToken(&tok, now)
+		if err != nil {
+			if errors.Is(err, group.ErrTokenQuota) {
+				http.Error(w, err.Error(),
+					http.StatusForbidden)
+				return
+			}
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("location", t.Token)
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		sendJSON(w, r, t)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, POST")
+}
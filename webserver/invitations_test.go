@@ -0,0 +1,120 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestInvitations(t *testing.T) {
+	dir := t.TempDir()
+	err := setupTest(dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := `{
+    "unrestricted-tokens": true,
+    "max-tokens": 2,
+    "max-token-lifetime": 3600,
+    "users": {
+        "jch": {"permissions": "op", "password": "pw"},
+        "john": {"permissions": "present", "password": "pw"},
+        "james": {"permissions": "observe", "password": "pw"}
+    }
+}`
+	err = os.WriteFile(
+		filepath.Join(dir, "invite.json"), []byte(desc), 0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := http.Client{}
+	url := "http://localhost:1234/group/invite/.invitations/"
+
+	do := func(method, username, body string) (*http.Response, error) {
+		req, err := http.NewRequest(
+			method, url, bytes.NewReader([]byte(body)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.SetBasicAuth(username, "pw")
+		return client.Do(req)
+	}
+
+	list := func(username string) []*token.Stateful {
+		resp, err := do("GET", username, "")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET: %v", resp.StatusCode)
+		}
+		var tokens []*token.Stateful
+		err = json.NewDecoder(resp.Body).Decode(&tokens)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return tokens
+	}
+
+	resp, err := do("POST", "john", `{"expires": "2100-01-01T00:00:00Z"}`)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST john: %v %v", err, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("POST john: content-type %v", ct)
+	}
+	var tok token.Stateful
+	err = json.NewDecoder(resp.Body).Decode(&tok)
+	resp.Body.Close()
+	if err != nil || tok.Expires == nil ||
+		tok.Expires.After(time.Now().Add(time.Hour)) {
+		t.Errorf("POST john: expires %v %v", tok.Expires, err)
+	}
+
+	resp, err = do("POST", "john", `{"permissions": ["op"]}`)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST john op: %v %v", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp, err = do("POST", "james", "{}")
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("POST james: %v %v", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp, err = do("POST", "jch", `{"permissions": ["op"]}`)
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Errorf("POST jch: %v %v", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp, err = do("POST", "jch", "{}")
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("POST over quota: %v %v", err, resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	if tokens := list("jch"); len(tokens) != 2 {
+		t.Errorf("Expected 2 tokens, got %v", len(tokens))
+	}
+	tokens := list("john")
+	if len(tokens) != 1 || tokens[0].IssuedBy == nil ||
+		*tokens[0].IssuedBy != "john" {
+		t.Errorf("Expected john's token, got %v", tokens)
+	}
+}
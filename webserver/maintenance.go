@@ -0,0 +1,56 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/jech/galene/group"
+)
+
+// maintenanceHandler serves the scheduled maintenance window.  Reading it
+// requires the stats permission, scheduling or cancelling it requires
+// administrator privileges.
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	if apiCORS(w, r, "HEAD, GET, PUT, DELETE") {
+		return
+	}
+
+	switch r.Method {
+	case "HEAD", "GET":
+		if !checkStats(w, r) {
+			return
+		}
+		m := group.GetMaintenance()
+		if m == nil {
+			notFound(w)
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, m)
+	case "PUT":
+		if !checkAdmin(w, r) {
+			return
+		}
+		var m group.Maintenance
+		done := getJSON(w, r, &m)
+		if done {
+			return
+		}
+		err := group.SetMaintenance(&m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if !checkAdmin(w, r) {
+			return
+		}
+		if !group.CancelMaintenance() {
+			notFound(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
+	}
+}
@@ -0,0 +1,371 @@
+package webserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/token"
+)
+
+// OpenID Connect login uses the authorisation code flow with PKCE.  The
+// login endpoint redirects the user to the provider, which redirects
+// back to the callback endpoint with a code.  We exchange the code for
+// an ID token, map its claims to a stateful token, and redirect the user
+// to the group with the token in the URL.
+//
+// We keep no state between the two requests: the state, nonce and PKCE
+// verifier are stored in a signed HttpOnly cookie, which binds the login
+// to the browser that started it, and is checked by the callback.
+
+const (
+	oidcLoginTimeout  = 10 * time.Minute
+	oidcProviderTTL   = 10 * time.Minute
+	oidcClientTimeout = 10 * time.Second
+	oidcCookieName    = "galene-oidc"
+)
+
+var oidcClient = &http.Client{Timeout: oidcClientTimeout}
+
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	keys                  []map[string]any
+	fetched               time.Time
+}
+
+var oidcProviders struct {
+	mu        sync.Mutex
+	providers map[string]*oidcProvider
+}
+
+type pendingLogin struct {
+	Group    string    `json:"group"`
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"exp"`
+}
+
+// oidcKey is the key used to sign login cookies.  It is generated when
+// the server starts, so restarting the server invalidates pending logins.
+var oidcKey struct {
+	once sync.Once
+	key  []byte
+}
+
+func oidcMAC(payload string) []byte {
+	oidcKey.once.Do(func() {
+		oidcKey.key = make([]byte, 32)
+		_, err := rand.Read(oidcKey.key)
+		if err != nil {
+			panic(err)
+		}
+	})
+	mac := hmac.New(sha256.New, oidcKey.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func oidcGetJSON(u string, value any) error {
+	resp, err := oidcClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v: %v", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).
+		Decode(value)
+}
+
+// usableKeys returns the signature keys of a key set that we know how to
+// use, filling in the algorithm of RSA keys if it is missing.
+func usableKeys(keys []map[string]any) []map[string]any {
+	var result []map[string]any
+	for _, k := range keys {
+		if use, ok := k["use"].(string); ok && use != "sig" {
+			continue
+		}
+		if _, ok := k["alg"]; !ok && k["kty"] == "RSA" {
+			k["alg"] = "RS256"
+		}
+		if _, err := token.ParseKey(k); err != nil {
+			continue
+		}
+		result = append(result, k)
+	}
+	return result
+}
+
+// getOIDCProvider returns the endpoints and keys of an issuer, using
+// OpenID Connect discovery.
+func getOIDCProvider(issuer string) (*oidcProvider, error) {
+	oidcProviders.mu.Lock()
+	p := oidcProviders.providers[issuer]
+	oidcProviders.mu.Unlock()
+	if p != nil && time.Since(p.fetched) < oidcProviderTTL {
+		return p, nil
+	}
+
+	p = &oidcProvider{}
+	err := oidcGetJSON(
+		strings.TrimRight(issuer, "/")+
+			"/.well-known/openid-configuration",
+		p,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" ||
+		p.JWKSURI == "" {
+		return nil, errors.New("incomplete OpenID configuration")
+	}
+	var keys struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	err = oidcGetJSON(p.JWKSURI, &keys)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = usableKeys(keys.Keys)
+	p.fetched = time.Now()
+
+	oidcProviders.mu.Lock()
+	defer oidcProviders.mu.Unlock()
+	if oidcProviders.providers == nil {
+		oidcProviders.providers = make(map[string]*oidcProvider)
+	}
+	oidcProviders.providers[issuer] = p
+	return p, nil
+}
+
+// encodeLogin returns the signed value of a login cookie.
+func encodeLogin(login pendingLogin) (string, error) {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := base64.RawURLEncoding.EncodeToString(oidcMAC(payload))
+	return payload + "." + mac, nil
+}
+
+// decodeLogin checks a login cookie against the state returned by the
+// provider.
+func decodeLogin(value, group, state string, now time.Time) (pendingLogin, bool) {
+	payload, mac, found := strings.Cut(value, ".")
+	if !found {
+		return pendingLogin{}, false
+	}
+	m, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(m, oidcMAC(payload)) {
+		return pendingLogin{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return pendingLogin{}, false
+	}
+	var login pendingLogin
+	err = json.Unmarshal(data, &login)
+	if err != nil {
+		return pendingLogin{}, false
+	}
+	if login.Group != group || state == "" ||
+		!hmac.Equal([]byte(login.State), []byte(state)) ||
+		!now.Before(login.Expires) {
+		return pendingLogin{}, false
+	}
+	return login, true
+}
+
+func oidcHandler(w http.ResponseWriter, r *http.Request) {
+	pth, _, rest := splitPath(r.URL.Path)
+	name := parseGroupName("/group/", pth)
+	if name == "" || (rest != "/login" && rest != "/callback") {
+		notFound(w)
+		return
+	}
+
+	g, err := group.Add(name, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	oidc := g.Description().OIDC
+	if oidc == nil {
+		notFound(w)
+		return
+	}
+
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD, GET")
+		return
+	}
+
+	base, err := baseURL(r)
+	if err != nil {
+		internalError(w, "Parse ProxyURL: %v", err)
+		return
+	}
+	location := g.Status(false, base).Location
+	redirectURI := location + ".oidc/callback"
+
+	provider, err := getOIDCProvider(oidc.Issuer)
+	if err != nil {
+		log.Printf("OpenID Connect discovery: %v", err)
+		http.Error(w, "couldn't contact identity provider",
+			http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("cache-control", "no-store")
+
+	// the location takes the proxy URL into account
+	l, err := url.Parse(location)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	cookie := http.Cookie{
+		Name:     oidcCookieName,
+		Path:     l.Path + ".oidc/",
+		Secure:   !Insecure,
+		HttpOnly: true,
+		// the callback is a cross-site navigation
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	if rest == "/login" {
+		login := pendingLogin{
+			Group:    name,
+			State:    randomString(),
+			Nonce:    randomString(),
+			Verifier: randomString(),
+			Expires:  time.Now().Add(oidcLoginTimeout),
+		}
+		value, err := encodeLogin(login)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		cookie.Value = value
+		cookie.Expires = login.Expires
+		http.SetCookie(w, &cookie)
+		challenge := sha256.Sum256([]byte(login.Verifier))
+		u, err := url.Parse(provider.AuthorizationEndpoint)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		q := u.Query()
+		q.Set("response_type", "code")
+		q.Set("client_id", oidc.ClientID)
+		q.Set("redirect_uri", redirectURI)
+		q.Set("scope", strings.Join(
+			append([]string{"openid"}, oidc.Scopes...), " ",
+		))
+		q.Set("state", login.State)
+		q.Set("nonce", login.Nonce)
+		q.Set("code_challenge",
+			base64.RawURLEncoding.EncodeToString(challenge[:]))
+		q.Set("code_challenge_method", "S256")
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusSeeOther)
+		return
+	}
+
+	query := r.URL.Query()
+	var login pendingLogin
+	ok := false
+	if c, err := r.Cookie(oidcCookieName); err == nil {
+		login, ok = decodeLogin(
+			c.Value, name, query.Get("state"), time.Now(),
+		)
+	}
+	// the login cookie may only be used once
+	cookie.Expires = time.Unix(0, 0)
+	cookie.MaxAge = -1
+	http.SetCookie(w, &cookie)
+	if !ok {
+		http.Error(w, "login expired, please try again",
+			http.StatusBadRequest)
+		return
+	}
+	if e := query.Get("error"); e != "" {
+		http.Error(w, "login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "missing code", http.StatusBadRequest)
+		return
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {oidc.ClientID},
+		"code_verifier": {login.Verifier},
+	}
+	if oidc.ClientSecret != "" {
+		form.Set("client_secret", oidc.ClientSecret)
+	}
+	resp, err := oidcClient.PostForm(provider.TokenEndpoint, form)
+	if err != nil {
+		log.Printf("OpenID Connect token request: %v", err)
+		http.Error(w, "couldn't contact identity provider",
+			http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).
+		Decode(&reply)
+	if err != nil || resp.StatusCode != http.StatusOK ||
+		reply.IDToken == "" {
+		log.Printf("OpenID Connect token request: %v %v",
+			resp.Status, err)
+		http.Error(w, "login failed", http.StatusUnauthorized)
+		return
+	}
+
+	tok, err := group.OIDCToken(
+		g, reply.IDToken, provider.keys, login.Nonce, time.Now().UTC(),
+	)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	t, err := token.Update(tok, "")
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, location+"?token="+url.QueryEscape(t.Token),
+		http.StatusSeeOther)
+}
@@ -0,0 +1,186 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/token"
+)
+
+func TestOIDC(t *testing.T) {
+	dir := t.TempDir()
+	err := setupTest(dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := map[string]any{
+		"kty": "oct",
+		"alg": "HS256",
+		"k":   "4S9YZLHK1traIaXQooCnPfBw_yR8j9VEPaAMWAog_YQ",
+	}
+
+	var nonce string
+	mux := http.NewServeMux()
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	mux.HandleFunc("/.well-known/openid-configuration",
+		func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                 provider.URL,
+				"authorization_endpoint": provider.URL + "/auth",
+				"token_endpoint":         provider.URL + "/token",
+				"jwks_uri":               provider.URL + "/jwks",
+			})
+		})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []any{key},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("code") != "code" ||
+			r.PostForm.Get("code_verifier") == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		jwt, err := token.SignJWT(key, map[string]any{
+			"iss":                provider.URL,
+			"aud":                "galene",
+			"iat":                now.Unix(),
+			"exp":                now.Add(time.Minute).Unix(),
+			"nonce":              nonce,
+			"preferred_username": "vimes",
+			"groups":             []any{"watch"},
+		})
+		if err != nil {
+			t.Errorf("SignJWT: %v", err)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id_token": jwt})
+	})
+
+	desc, err := json.Marshal(map[string]any{
+		"oidc": map[string]any{
+			"issuer":    provider.URL,
+			"client-id": "galene",
+			"rules": []any{
+				map[string]any{
+					"claim":       "groups",
+					"value":       "watch",
+					"permissions": "op",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "oidc.json"), desc, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("http://localhost:1234/group/oidc/.oidc/login")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Login: %v", resp.Status)
+	}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly ||
+		cookies[0].Path != "/group/oidc/.oidc/" {
+		t.Fatalf("Unexpected cookies %v", cookies)
+	}
+	cookie := cookies[0]
+
+	get := func(u string, cookie *http.Cookie) *http.Response {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	auth, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(auth.String(), provider.URL) {
+		t.Fatalf("Location: %v %v", auth, err)
+	}
+	q := auth.Query()
+	if q.Get("redirect_uri") !=
+		"http://localhost:1234/group/oidc/.oidc/callback" ||
+		q.Get("code_challenge_method") != "S256" {
+		t.Errorf("Unexpected query %v", q)
+	}
+	nonce = q.Get("nonce")
+	state := q.Get("state")
+
+	callback := "http://localhost:1234/group/oidc/.oidc/callback"
+	resp = get(callback+"?code=code&state=bad", cookie)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Callback (bad state): %v", resp.Status)
+	}
+
+	// the login is bound to the browser that started it
+	resp = get(callback+"?code=code&state="+state, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Callback (no cookie): %v", resp.Status)
+	}
+
+	forged := *cookie
+	forged.Value = strings.Replace(forged.Value, ".", "x.", 1)
+	resp = get(callback+"?code=code&state="+state, &forged)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Callback (forged cookie): %v", resp.Status)
+	}
+
+	resp = get(callback+"?code=code&state="+state, cookie)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Callback: %v", resp.Status)
+	}
+	cookies = resp.Cookies()
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Cookie not cleared: %v", cookies)
+	}
+	u, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || u.Path != "/group/oidc/" {
+		t.Fatalf("Location: %v %v", u, err)
+	}
+	tok, _, err := token.Get(u.Query().Get("token"))
+	if err != nil {
+		t.Fatalf("token.Get: %v", err)
+	}
+	if tok.Group != "oidc" || tok.Username == nil ||
+		*tok.Username != "vimes" || len(tok.Permissions) == 0 {
+		t.Errorf("Unexpected token %#v", tok)
+	}
+
+	// the cookie has been cleared
+	resp = get(callback+"?code=code&state="+state, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Callback (replay): %v", resp.Status)
+	}
+}
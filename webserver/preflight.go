@@ -0,0 +1,105 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// The pre-flight endpoint allows external portals to check whether a join
+// would succeed, without actually joining the group.
+
+type preflightRequest struct {
+	Username *string `json:"username,omitempty"`
+	Password string  `json:"password,omitempty"`
+	Token    string  `json:"token,omitempty"`
+}
+
+type preflightReply struct {
+	// whether the join would succeed
+	OK bool `json:"ok"`
+	// one of "not-found", "not-authorised" or "refused"
+	Status      string   `json:"status,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	Username    *string  `json:"username,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+func preflightHandler(w http.ResponseWriter, r *http.Request) {
+	pth, _, rest := splitPath(r.URL.Path)
+	if rest != "" {
+		notFound(w)
+		return
+	}
+
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	CheckOrigin(w, r, false)
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		return
+	}
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	var req preflightRequest
+	r.Body = http.MaxBytesReader(w, r.Body, 16384)
+	done := getJSON(w, r, &req)
+	if done {
+		return
+	}
+	if req.Username == nil && req.Token == "" {
+		http.Error(w, "neither username nor token provided",
+			http.StatusBadRequest)
+		return
+	}
+
+	username, perms, err := group.CheckJoin(name,
+		group.ClientCredentials{
+			Username: req.Username,
+			Password: req.Password,
+			Token:    req.Token,
+		},
+	)
+
+	var reply preflightReply
+	var autherr *group.NotAuthorisedError
+	var usererr group.UserError
+	if err == nil {
+		reply.OK = true
+		reply.Username = &username
+		reply.Permissions = perms
+	} else if errors.Is(err, os.ErrNotExist) {
+		reply.Status = "not-found"
+		reply.Error = "this group does not exist"
+	} else if errors.As(err, &autherr) {
+		// same delay as a failed login
+		time.Sleep(200 * time.Millisecond)
+		reply.Status = "not-authorised"
+		reply.Error = "not authorised"
+		if errors.Is(err, group.ErrDuplicateUsername) {
+			reply.Error = err.Error()
+		}
+	} else if errors.As(err, &usererr) {
+		reply.Status = "refused"
+		reply.Error = usererr.Error()
+	} else {
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("cache-control", "no-store")
+	sendJSON(w, r, reply)
+}
@@ -0,0 +1,98 @@
+package webserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestPreflight(t *testing.T) {
+	dir := t.TempDir()
+	err := setupTest(dir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc := `{
+    "max-clients": 1,
+    "users": {
+        "jch": {"permissions": "op", "password": "pw"},
+        "john": {"permissions": "present", "password": "pw"}
+    }
+}`
+	err = os.WriteFile(
+		filepath.Join(dir, "preflight.json"), []byte(desc), 0o600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(g, body string) preflightReply {
+		resp, err := http.Post(
+			"http://localhost:1234/group/"+g+"/.preflight",
+			"application/json", strings.NewReader(body),
+		)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST: %v", resp.StatusCode)
+		}
+		var reply preflightReply
+		err = json.NewDecoder(resp.Body).Decode(&reply)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		return reply
+	}
+
+	reply := check("preflight", `{"username": "john", "password": "pw"}`)
+	if !reply.OK || reply.Username == nil || *reply.Username != "john" ||
+		!member("present", reply.Permissions) {
+		t.Errorf("john: %#v", reply)
+	}
+
+	reply = check("preflight", `{"username": "john", "password": "bad"}`)
+	if reply.OK || reply.Status != "not-authorised" {
+		t.Errorf("bad password: %#v", reply)
+	}
+
+	reply = check("nonexistent", `{"username": "john", "password": "pw"}`)
+	if reply.OK || reply.Status != "not-found" {
+		t.Errorf("nonexistent group: %#v", reply)
+	}
+
+	g := group.Get("preflight")
+	if g == nil {
+		t.Fatalf("group not created")
+	}
+	g.SetLocked(true, "closed for maintenance")
+	reply = check("preflight", `{"username": "john", "password": "pw"}`)
+	if reply.OK || reply.Status != "refused" ||
+		reply.Error != "closed for maintenance" {
+		t.Errorf("locked: %#v", reply)
+	}
+
+	reply = check("preflight", `{"username": "jch", "password": "pw"}`)
+	if !reply.OK {
+		t.Errorf("op in locked group: %#v", reply)
+	}
+
+	resp, err := http.Post(
+		"http://localhost:1234/group/preflight/.preflight",
+		"application/json", strings.NewReader(`{}`),
+	)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("no credentials: %v", resp.StatusCode)
+	}
+}
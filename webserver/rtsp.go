@@ -0,0 +1,91 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtsp"
+)
+
+// rtspHandler handles the RTSP sources of a group under .rtsp/.
+func rtspHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
+	if pth == "" {
+		notFound(w)
+		return
+	}
+	if pth == "/" {
+		if apiCORS(w, r, "HEAD, GET, POST") {
+			return
+		}
+	} else if apiCORS(w, r, "HEAD, GET, DELETE") {
+		return
+	}
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	_, err := group.GetDescription(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if pth == "/" {
+		if r.Method == "HEAD" || r.Method == "GET" {
+			w.Header().Set("cache-control", "no-cache")
+			sendJSON(w, r, rtsp.List(g))
+			return
+		} else if r.Method == "POST" {
+			var src group.RTSPSource
+			done := getJSON(w, r, &src)
+			if done {
+				return
+			}
+			id, err := rtsp.Add(g, src)
+			if err != nil {
+				var uerr group.UserError
+				if errors.As(err, &uerr) {
+					http.Error(w, err.Error(),
+						http.StatusBadRequest)
+					return
+				}
+				httpError(w, err)
+				return
+			}
+			w.Header().Set("location", id)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		methodNotAllowed(w, "HEAD, GET, POST")
+		return
+	}
+
+	if pth[0] != '/' {
+		notFound(w)
+		return
+	}
+	id := pth[1:]
+	if r.Method == "HEAD" || r.Method == "GET" {
+		status, err := rtsp.Get(g, id)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, status)
+		return
+	} else if r.Method == "DELETE" {
+		err := rtsp.Remove(g, id)
+		if errors.Is(err, rtsp.ErrConfigured) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	methodNotAllowed(w, "HEAD, GET, DELETE")
+}
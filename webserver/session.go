@@ -0,0 +1,80 @@
+package webserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// The session endpoint stores the session obtained when joining a group
+// into an HttpOnly cookie, which is sent with the WebSocket handshake.
+// See group/session.go.
+
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	pth, _, rest := splitPath(r.URL.Path)
+	if rest != "" {
+		notFound(w)
+		return
+	}
+
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	if !CheckOrigin(nil, r, false) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("cache-control", "no-store")
+
+	cookie := http.Cookie{
+		Name:     group.SessionCookieName(name),
+		Path:     "/ws",
+		Secure:   !Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	switch r.Method {
+	case "POST":
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 8192))
+		if err != nil {
+			http.Error(w, "couldn't read body", http.StatusBadRequest)
+			return
+		}
+		value := strings.TrimSpace(string(body))
+		expires, err := group.CheckSession(name, value)
+		if err != nil {
+			http.Error(w, "bad session", http.StatusBadRequest)
+			return
+		}
+		cookie.Value = value
+		cookie.Expires = expires
+		http.SetCookie(w, &cookie)
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		cookie.Expires = time.Unix(0, 0)
+		cookie.MaxAge = -1
+		http.SetCookie(w, &cookie)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "POST, DELETE")
+	}
+}
+
+// sessionCookies returns the session cookies sent with a request.
+func sessionCookies(r *http.Request) []string {
+	var sessions []string
+	for _, c := range r.Cookies() {
+		if strings.HasPrefix(c.Name, group.SessionCookiePrefix) {
+			sessions = append(sessions, c.Value)
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,68 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestSessionHandler(t *testing.T) {
+	perms, _ := group.NewPermissions("op")
+	g, err := group.Add("session-test", &group.Description{
+		Users: map[string]group.UserDescription{
+			"jch": {Permissions: perms},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	defer group.Delete("session-test")
+	value, _, err := group.NewSession(g, "jch")
+	if err != nil || value == "" {
+		t.Fatalf("NewSession: %v %v", value, err)
+	}
+
+	post := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/group/session-test/.session",
+			strings.NewReader(body))
+		w := httptest.NewRecorder()
+		sessionHandler(w, r)
+		return w
+	}
+
+	w := post("garbage")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %v", w.Code)
+	}
+
+	w = post(value)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %v", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly ||
+		cookies[0].Name != group.SessionCookieName("session-test") ||
+		cookies[0].Value != value {
+		t.Errorf("Bad cookies %v", cookies)
+	}
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.AddCookie(cookies[0])
+	r.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+	sessions := sessionCookies(r)
+	if len(sessions) != 1 || sessions[0] != value {
+		t.Errorf("Expected [%v], got %v", value, sessions)
+	}
+
+	r = httptest.NewRequest("POST", "/group/session-test/.session",
+		strings.NewReader(value))
+	r.Header.Set("Origin", "https://evil.example.org")
+	w = httptest.NewRecorder()
+	sessionHandler(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Cross-origin request: expected 403, got %v", w.Code)
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/jech/cert"
 	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/fips"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/rtpconn"
 )
@@ -44,7 +45,7 @@ func Serve(address string, dataDir string) error {
 	http.HandleFunc("/recordings/", recordingsHandler)
 	http.HandleFunc("/ws", wsHandler)
 	http.HandleFunc("/public-groups.json", publicHandler)
-	http.HandleFunc("/galene-api/", apiHandler)
+	http.HandleFunc("/galene-api/", publicAPIHandler)
 
 	s := &http.Server{
 		Addr:              address,
@@ -62,6 +63,7 @@ func Serve(address string, dataDir string) error {
 				return certificate.Get()
 			},
 		}
+		fips.ConfigureTLS(s.TLSConfig)
 	}
 	s.RegisterOnShutdown(func() {
 		group.Shutdown("server is shutting down")
@@ -341,6 +343,24 @@ func groupHandler(w http.ResponseWriter, r *http.Request) {
 			whipResourceHandler(w, r)
 		}
 		return
+	} else if kind == ".invitations" {
+		invitationsHandler(w, r)
+		return
+	} else if kind == ".token-exchange" {
+		tokenExchangeHandler(w, r)
+		return
+	} else if kind == ".preflight" {
+		preflightHandler(w, r)
+		return
+	} else if kind == ".oidc" {
+		oidcHandler(w, r)
+		return
+	} else if kind == ".session" {
+		sessionHandler(w, r)
+		return
+	} else if kind == ".files" {
+		filesHandler(w, r)
+		return
 	} else if kind != "" {
 		notFound(w)
 		return
@@ -463,31 +483,48 @@ func publicHandler(w http.ResponseWriter, r *http.Request) {
 	e.Encode(g)
 }
 
-func adminMatch(username, password string) (bool, error) {
+// permissionScopes maps the permissions of server-wide users to the API
+// scopes that they grant.
+var permissionScopes = map[string]string{
+	"admin":    "admin",
+	"stats":    "stats:read",
+	"announce": "announce:write",
+}
+
+// serverScopes returns the API scopes granted to a server-wide user, or
+// nil if the credentials don't match.
+func serverScopes(username, password string) ([]string, error) {
 	conf, err := group.GetConfiguration()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	u, found := conf.Users[username]
-	if found {
-		ok, err := u.Password.Match(password)
-		if err != nil {
-			return false, err
-		}
-		if !ok {
-			return false, nil
-		}
-		perms := u.Permissions.Permissions(nil)
-		for _, p := range perms {
-			if p == "admin" {
-				return true, nil
-			}
+	if !found {
+		return nil, nil
+	}
+	ok, err := u.Password.Match(password)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var scopes []string
+	for _, p := range u.Permissions.Permissions(nil) {
+		s, ok := permissionScopes[p]
+		if ok {
+			scopes = append(scopes, s)
 		}
-		return false, nil
 	}
+	return append(scopes, u.Scopes...), nil
+}
 
-	return false, nil
+func member(v string, l []string) bool {
+	for _, w := range l {
+		if v == w {
+			return true
+		}
+	}
+	return false
 }
 
 func failAuthentication(w http.ResponseWriter, realm string) {
@@ -553,6 +590,8 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sessions := sessionCookies(r)
+
 	var addr net.Addr
 	tcpaddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
 	if err != nil {
@@ -562,7 +601,7 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	go func() {
-		err := rtpconn.StartClient(conn, addr)
+		err := rtpconn.StartClient(conn, addr, sessions)
 		if err != nil {
 			log.Printf("client: %v", err)
 		}
@@ -795,4 +834,5 @@ func Shutdown() {
 	defer cancel()
 	server.Shutdown(ctx)
 	server = nil
+	shutdownAdmin(ctx)
 }
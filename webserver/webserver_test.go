@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/pion/webrtc/v4"
@@ -210,40 +211,128 @@ func TestMatchAdmin(t *testing.T) {
 	f.Write([]byte(`{
 	    "users": {
 		"root": {"password": "pwd", "permissions": "admin"},
-		"notroot": {"password": "pwd"}
+		"notroot": {"password": "pwd"},
+		"monitor": {"password": "pwd", "permissions": "stats"},
+		"teacher": {
+		    "password": "pwd",
+		    "scopes": ["groups:write:school", "tokens:create"]
+		}
 	    }
 	}`))
 	f.Close()
 
-	ok, err := adminMatch("jch", "pwd")
+	match := func(username, password, resource, action string) (bool, error) {
+		scopes, err := serverScopes(username, password)
+		if err != nil {
+			return false, err
+		}
+		return group.ScopesAllow(scopes, resource, action, ""), nil
+	}
+
+	ok, err := match("jch", "pwd", "", "")
 	if ok || err != nil {
 		t.Errorf("jch: %v %v", ok, err)
 	}
 
-	ok, err = adminMatch("root", "pwd")
+	ok, err = match("root", "pwd", "", "")
 	if !ok || err != nil {
 		t.Errorf("root: %v %v", ok, err)
 	}
 
-	ok, err = adminMatch("root", "notpwd")
+	ok, err = match("root", "notpwd", "", "")
 	if ok || err != nil {
 		t.Errorf("root: %v %v", ok, err)
 	}
 
-	ok, err = adminMatch("root", "")
+	ok, err = match("root", "", "", "")
 	if ok || err != nil {
 		t.Errorf("root: %v %v", ok, err)
 	}
 
-	ok, err = adminMatch("notroot", "pwd")
+	ok, err = match("notroot", "pwd", "", "")
+	if ok || err != nil {
+		t.Errorf("notroot: %v %v", ok, err)
+	}
+
+	ok, err = match("notroot", "notpwd", "", "")
 	if ok || err != nil {
 		t.Errorf("notroot: %v %v", ok, err)
 	}
 
-	ok, err = adminMatch("notroot", "notpwd")
+	ok, err = match("monitor", "pwd", "", "")
+	if ok || err != nil {
+		t.Errorf("monitor: %v %v", ok, err)
+	}
+
+	ok, err = match("monitor", "pwd", "stats", "read")
+	if !ok || err != nil {
+		t.Errorf("monitor: %v %v", ok, err)
+	}
+
+	ok, err = match("monitor", "notpwd", "stats", "read")
+	if ok || err != nil {
+		t.Errorf("monitor: %v %v", ok, err)
+	}
+
+	ok, err = match("root", "pwd", "stats", "read")
+	if !ok || err != nil {
+		t.Errorf("root: %v %v", ok, err)
+	}
+
+	ok, err = match("notroot", "pwd", "stats", "read")
 	if ok || err != nil {
 		t.Errorf("notroot: %v %v", ok, err)
 	}
+
+	ok, err = match("teacher", "pwd", "", "")
+	if ok || err != nil {
+		t.Errorf("teacher: %v %v", ok, err)
+	}
+
+	ok, err = match("teacher", "pwd", "stats", "read")
+	if ok || err != nil {
+		t.Errorf("teacher: %v %v", ok, err)
+	}
+
+	ok, err = match("teacher", "pwd", "tokens", "create")
+	if !ok || err != nil {
+		t.Errorf("teacher: %v %v", ok, err)
+	}
+
+	scopes, err := serverScopes("teacher", "pwd")
+	if err != nil {
+		t.Fatalf("serverScopes: %v", err)
+	}
+	if !group.ScopesAllow(scopes, "groups", "write", "school") ||
+		!group.ScopesAllow(scopes, "groups", "read", "school/math") ||
+		group.ScopesAllow(scopes, "groups", "write", "") ||
+		group.ScopesAllow(scopes, "groups", "write", "schoolyard") {
+		t.Errorf("teacher: bad scopes %v", scopes)
+	}
+
+	scopes, err = serverScopes("teacher", "notpwd")
+	if scopes != nil || err != nil {
+		t.Errorf("teacher: %v %v", scopes, err)
+	}
+}
+
+func TestBadScopes(t *testing.T) {
+	d := t.TempDir()
+	group.DataDirectory = d
+
+	err := os.WriteFile(filepath.Join(d, "config.json"), []byte(`{
+	    "users": {
+		"teacher": {"password": "pwd", "scopes": ["groups:manage"]}
+	    }
+	}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err = serverScopes("teacher", "pwd")
+	if err == nil {
+		t.Errorf("Bad scope accepted")
+	}
 }
 
 func TestObfuscate(t *testing.T) {
@@ -265,3 +354,67 @@ func TestObfuscate(t *testing.T) {
 		t.Errorf("obfuscate: no errror")
 	}
 }
+
+func TestWhipBasicAuth(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(
+		filepath.Join(group.Directory, "whip.json"),
+		[]byte(`{
+		    "users": {
+		        "encoder": {"password": "secret", "permissions": "present"},
+		        "watcher": {"password": "secret", "permissions": "observe"}
+		    }
+		}`), 0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(username, password string) *http.Response {
+		req, err := http.NewRequest("POST",
+			"http://localhost:1234/group/whip/.whip",
+			strings.NewReader("v=0\r\n"),
+		)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/sdp")
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Post: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := post("", "")
+	if resp.StatusCode != http.StatusUnauthorized ||
+		resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("No credentials: %v %v",
+			resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	resp = post("encoder", "wrong")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Bad password: %v", resp.StatusCode)
+	}
+
+	resp = post("watcher", "secret")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Not a presenter: %v", resp.StatusCode)
+	}
+
+	// authentication succeeds, but the offer is bogus
+	resp = post("encoder", "secret")
+	if resp.StatusCode == http.StatusUnauthorized ||
+		resp.StatusCode == http.StatusForbidden {
+		t.Errorf("Good password: %v", resp.StatusCode)
+	}
+}
@@ -14,11 +14,11 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/pion/webrtc/v4"
 
 	"github.com/jech/galene/group"
-	"github.com/jech/galene/ice"
 	"github.com/jech/galene/rtpconn"
 	"github.com/jech/galene/sdpfrag"
 )
@@ -127,8 +127,8 @@ func formatICEServer(server webrtc.ICEServer, u string) string {
 	return ""
 }
 
-func whipICEServers(w http.ResponseWriter) {
-	conf := ice.ICEConfiguration()
+func whipICEServers(w http.ResponseWriter, g *group.Group) {
+	conf := g.ICEConfiguration()
 	for _, server := range conf.ICEServers {
 		for _, u := range server.URLs {
 			v := formatICEServer(server, u)
@@ -173,7 +173,7 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 			"Authorization, Content-Type",
 		)
 		w.Header().Set("Access-Control-Expose-Headers", "Link")
-		whipICEServers(w)
+		whipICEServers(w, g)
 		return
 	}
 
@@ -204,6 +204,14 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 		Token:    token,
 	}
 
+	// encoders that cannot obtain a token may use HTTP Basic
+	// authentication against the group's user database
+	username, password, basic := r.BasicAuth()
+	if token == "" && basic {
+		creds.Username = &username
+		creds.Password = password
+	}
+
 	id := newId()
 	obfuscated, err := obfuscate(id)
 	if err != nil {
@@ -220,10 +228,28 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	c := rtpconn.NewWhipClient(g, id, token, addr)
+	if token == "" && basic {
+		c.SetPassword(password)
+	}
+	err = c.SetStream(
+		r.URL.Query().Get("label"), r.URL.Query().Get("role"),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	_, err = group.AddClient(g.Name(), c, creds)
 	if err != nil {
 		log.Printf("WHIP: %v", err)
+		var autherr *group.NotAuthorisedError
+		if errors.As(err, &autherr) {
+			time.Sleep(200 * time.Millisecond)
+			if token == "" {
+				failAuthentication(w, "whip/"+g.Name())
+				return
+			}
+		}
 		httpError(w, err)
 		return
 	}
@@ -234,11 +260,28 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("label") == "screenshare" &&
+		!g.CanScreenshare(c.Permissions()) {
+		group.DelClient(c)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	c.SetETag("\"" + newId() + "\"")
 
 	answer, err := c.NewConnection(r.Context(), body)
 	if err != nil {
 		group.DelClient(c)
+		if errors.Is(err, rtpconn.ErrDuplicatePublisher) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, rtpconn.ErrAudioOnly) ||
+			errors.Is(err, rtpconn.ErrTooManyTracks) ||
+			errors.Is(err, rtpconn.ErrScreenshare) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		log.Printf("WHIP offer: %v", err)
 		httpError(w, err)
 		return
@@ -246,8 +289,9 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Location", path.Join(r.URL.Path, obfuscated))
 	w.Header().Set("Access-Control-Expose-Headers",
-		"Location, Content-Type, Link, ETag")
-	whipICEServers(w)
+		"Location, Content-Type, Link, ETag, Accept-Patch")
+	whipICEServers(w, g)
+	w.Header().Set("Accept-Patch", "application/trickle-ice-sdpfrag")
 	w.Header().Set("Content-Type", "application/sdp")
 	w.Header().Set("ETag", c.ETag())
 	w.WriteHeader(http.StatusCreated)
@@ -299,6 +343,14 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
+	} else if c.HasPassword() {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != c.Username() ||
+			!c.CheckPassword(password) {
+			time.Sleep(200 * time.Millisecond)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
 	}
 
 	CheckOrigin(w, r, false)
@@ -389,11 +441,37 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write(f2)
 		return
 	}
+	if c.RestartPending() {
+		// the server has requested an ICE restart, the client
+		// will retry with new credentials
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
 	for _, init := range frag.AllCandidates() {
 		err := c.GotICECandidate(init)
 		if err != nil {
 			log.Printf("WHIP candidate: %v", err)
 		}
 	}
-	w.WriteHeader(http.StatusNoContent)
+
+	// reply with any local candidates gathered since the last request
+	frag2, ok, err := c.NewCandidates()
+	if err != nil {
+		log.Printf("WHIP local candidates: %v", err)
+		ok = false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f2, err := frag2.Marshal()
+	if err != nil {
+		log.Printf("WHIP marshal frag: %v", err)
+		http.Error(w, "internal server error",
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	w.Header().Set("Content-Type", "application/trickle-ice-sdpfrag")
+	w.Write(f2)
 }